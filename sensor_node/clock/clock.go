@@ -0,0 +1,193 @@
+// Package clock abstracts the current time, timers and tickers, so pacing, retry
+// backoff and heartbeats can be tested and simulated deterministically.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers and tickers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer fires once on C like time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// Ticker delivers ticks like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// Sleep waits for d on clk. It returns false if done is closed first.
+func Sleep(clk Clock, d time.Duration, done <-chan struct{}) bool {
+	timer := clk.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Fake is a clock that only moves when advanced. Timers and tickers fire during
+// Advance in time order; like their time counterparts they drop ticks a slow receiver
+// misses. It is safe for concurrent use.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// NewFake returns a fake clock stopped at now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0)
+}
+
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d)}
+}
+
+func (f *Fake) add(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{clock: f, c: make(chan time.Time, 1), period: period}
+	f.schedule(w, d)
+	return w
+}
+
+// schedule makes w fire after d, immediately on the next Advance if d is not
+// positive. Callers must hold mu.
+func (f *Fake) schedule(w *fakeWaiter, d time.Duration) {
+	w.next = f.now.Add(d)
+	f.waiters = append(f.waiters, w)
+	if d <= 0 {
+		f.fire(w)
+	}
+}
+
+// Advance moves the clock forward by d, firing every timer and tick that falls due on
+// the way.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	end := f.now.Add(d)
+	for {
+		var next *fakeWaiter
+		for _, w := range f.waiters {
+			if !w.next.After(end) && (next == nil || w.next.Before(next.next)) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		f.now = next.next
+		f.fire(next)
+	}
+	f.now = end
+}
+
+// fire delivers the current time to w and reschedules or removes it. Callers must
+// hold mu.
+func (f *Fake) fire(w *fakeWaiter) {
+	select {
+	case w.c <- f.now:
+	default:
+	}
+
+	if w.period > 0 {
+		w.next = w.next.Add(w.period)
+	} else {
+		f.remove(w)
+	}
+}
+
+func (f *Fake) remove(w *fakeWaiter) bool {
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeWaiter struct {
+	clock  *Fake
+	c      chan time.Time
+	period time.Duration // 0 for timers
+	next   time.Time
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.c }
+
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	active := w.clock.remove(w)
+	w.clock.schedule(w, d)
+	return active
+}
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	return w.clock.remove(w)
+}
+
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) Stop() { t.fakeWaiter.Stop() }
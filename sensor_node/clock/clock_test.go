@@ -0,0 +1,75 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFake_Timer(t *testing.T) {
+	c := NewFake(start)
+	timer := c.NewTimer(time.Second)
+
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	c.Advance(time.Millisecond)
+	select {
+	case fired := <-timer.C():
+		if want := start.Add(time.Second); !fired.Equal(want) {
+			t.Errorf("timer fired at %v, want %v", fired, want)
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+
+	if timer.Stop() {
+		t.Error("Stop() on a fired timer = true, want false")
+	}
+	if timer.Reset(time.Minute) {
+		t.Error("Reset() on a fired timer = true, want false")
+	}
+	if !timer.Stop() {
+		t.Error("Stop() on a reset timer = false, want true")
+	}
+	c.Advance(time.Hour)
+	select {
+	case <-timer.C():
+		t.Error("stopped timer fired")
+	default:
+	}
+}
+
+func TestFake_TickerDropsMissedTicks(t *testing.T) {
+	c := NewFake(start)
+	ticker := c.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	c.Advance(10 * time.Minute)
+	if tick := <-ticker.C(); !tick.Equal(start.Add(time.Minute)) {
+		t.Errorf("first tick = %v, want %v", tick, start.Add(time.Minute))
+	}
+	select {
+	case <-ticker.C():
+		t.Error("missed ticks should be dropped")
+	default:
+	}
+	if !c.Now().Equal(start.Add(10 * time.Minute)) {
+		t.Errorf("Now() = %v, want %v", c.Now(), start.Add(10*time.Minute))
+	}
+}
+
+func TestSleep_StopsOnDone(t *testing.T) {
+	c := NewFake(start)
+	done := make(chan struct{})
+	close(done)
+
+	if Sleep(c, time.Hour, done) {
+		t.Error("Sleep() = true, want false once done is closed")
+	}
+}
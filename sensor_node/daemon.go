@@ -0,0 +1,212 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pipeline is one of the independent pipelines a node runs in daemon mode: an input,
+// or generated readings, with their tags and encryption, sent to a sink at its own
+// rate, over its own connections and TLS, and batched on its own.
+type pipeline struct {
+	name   string
+	config Config
+}
+
+// loadPipelines reads a daemon config file. A pipeline is a map of flag names to
+// values over the defaults, which every pipeline starts from; a pipeline's value
+// replaces the default's. Lists are passed comma separated and maps as key=value
+// pairs:
+//
+//	defaults:
+//	  sink-addr: sink.example:9090
+//	  tls: true
+//	pipelines:
+//	  - name: boiler
+//	    sensor-name: boiler
+//	    input: modbus
+//	    modbus-addr: 10.0.0.7:502
+//	    input-mapping: /etc/node/boiler.yaml
+//	    tags: {site: plant-1}
+func loadPipelines(path string) ([]pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	var file struct {
+		Defaults  map[string]any   `yaml:"defaults"`
+		Pipelines []map[string]any `yaml:"pipelines"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse config file: %w", err)
+	}
+	if len(file.Pipelines) == 0 {
+		return nil, fmt.Errorf("config file defines no pipelines")
+	}
+	if _, ok := file.Defaults["name"]; ok {
+		return nil, fmt.Errorf("defaults can't set a name")
+	}
+
+	var (
+		pipelines []pipeline
+		stdin     string                // pipeline reading stdin
+		states    = map[string]string{} // pipeline by state file
+		spools    = map[string]string{} // pipeline by spool directory
+	)
+	for i, settings := range file.Pipelines {
+		name, _ := settings["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("pipeline %d: name is required", i+1)
+		}
+		if slices.ContainsFunc(pipelines, func(p pipeline) bool { return p.name == name }) {
+			return nil, fmt.Errorf("pipeline %s: defined twice", name)
+		}
+
+		config, err := pipelineConfig(file.Defaults, settings)
+		if err == nil {
+			err = validateConfig(config)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", name, err)
+		}
+		if config.Input == "file" && config.InputFile == "-" {
+			if stdin != "" {
+				return nil, fmt.Errorf("pipeline %s: stdin is read by pipeline %s already", name, stdin)
+			}
+			stdin = name
+		}
+		if config.StateFile != "" {
+			if other, ok := states[config.StateFile]; ok {
+				return nil, fmt.Errorf("pipeline %s: state file is used by pipeline %s already", name, other)
+			}
+			states[config.StateFile] = name
+		}
+		if config.SpoolDir != "" {
+			if other, ok := spools[config.SpoolDir]; ok {
+				return nil, fmt.Errorf("pipeline %s: spool directory is used by pipeline %s already", name, other)
+			}
+			spools[config.SpoolDir] = name
+		}
+		pipelines = append(pipelines, pipeline{name: name, config: config})
+	}
+
+	return pipelines, nil
+}
+
+// pipelineConfig sets the flags of a pipeline to the defaults and then to its
+// settings, starting from the flags' own defaults.
+func pipelineConfig(defaults, settings map[string]any) (Config, error) {
+	var config Config
+	fs := flag.NewFlagSet("pipeline", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	defineFlags(fs, &config)
+
+	for _, values := range []map[string]any{defaults, settings} {
+		for _, name := range slices.Sorted(maps.Keys(values)) {
+			if name == "name" {
+				continue
+			}
+			if fs.Lookup(name) == nil {
+				return config, fmt.Errorf("unknown setting %s", name)
+			}
+			if err := fs.Set(name, flagValue(values[name])); err != nil {
+				return config, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// flagValue formats a YAML value as a flag value.
+func flagValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = flagValue(item)
+		}
+		return strings.Join(items, ",")
+	case map[string]any:
+		var pairs []string
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			pairs = append(pairs, key+"="+flagValue(v[key]))
+		}
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// runDaemon runs the pipelines in path until a shutdown signal, each as a node of
+// its own.
+func runDaemon(path string) {
+	var others []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "config" && f.Name != "check-config" {
+			others = append(others, "-"+f.Name)
+		}
+	})
+	if len(others) > 0 {
+		fatal(exitConfig, "Invalid configuration: -config can't be combined with %s; set them under defaults", strings.Join(others, ", "))
+	}
+
+	pipelines, err := loadPipelines(path)
+	if err != nil {
+		fatal(exitConfig, "Invalid configuration: %v", err)
+	}
+	if *checkConfig {
+		log.Printf("Configuration OK: %d pipelines", len(pipelines))
+		return
+	}
+
+	nodes := make([]*SensorNode, 0, len(pipelines))
+	for _, p := range pipelines {
+		node, err := NewSensorNode(p.config)
+		if err != nil {
+			for _, node := range nodes {
+				node.Close()
+			}
+			fatal(exitRuntime, "Failed to create pipeline %s: %v", p.name, err)
+		}
+		nodes = append(nodes, node)
+		log.Printf("Starting pipeline %s: sensor %s, rate: %.2f msg/s, sink: %s, use TLS: %v", p.name, p.config.SensorName, p.config.Rate, p.config.SinkAddr, p.config.UseTLS)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		log.Println("Received shutdown signal, stopping pipelines")
+		for _, node := range nodes {
+			node.Stop()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.Run()
+		}()
+	}
+	wg.Wait()
+
+	for _, node := range nodes {
+		node.Close()
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadPipelines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.yaml")
+	if err := os.WriteFile(path, []byte(`
+defaults:
+  sink-addr: sink.example:9090
+  rate: 2
+pipelines:
+  - name: temp
+    sensor-name: temp-1
+    tags: {site: berlin, floor: 3}
+  - name: power
+    sensor-name: power
+    sink-addr: power.example:9090
+    metrics: [l1, l2, l3]
+    batch-size: 10
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pipelines, err := loadPipelines(path)
+	if err != nil {
+		t.Fatalf("loadPipelines() error = %v", err)
+	}
+	if len(pipelines) != 2 {
+		t.Fatalf("loadPipelines() = %d pipelines, want 2", len(pipelines))
+	}
+
+	temp, power := pipelines[0], pipelines[1]
+	if temp.name != "temp" || temp.config.SensorName != "temp-1" || temp.config.SinkAddr != "sink.example:9090" || temp.config.Rate != 2 {
+		t.Errorf("temp = %s %+v", temp.name, temp.config)
+	}
+	if temp.config.Tags["site"] != "berlin" || temp.config.Tags["floor"] != "3" {
+		t.Errorf("temp tags = %v", temp.config.Tags)
+	}
+	if power.config.SinkAddr != "power.example:9090" || power.config.BatchSize != 10 {
+		t.Errorf("power = %+v, want its own sink and batch size", power.config)
+	}
+	if want := []string{"l1", "l2", "l3"}; !slices.Equal(power.config.Metrics, want) {
+		t.Errorf("power metrics = %v, want %v", power.config.Metrics, want)
+	}
+	if power.config.Connections != temp.config.Connections || power.config.Connections == 0 {
+		t.Errorf("connections = %d and %d, want the flag default", temp.config.Connections, power.config.Connections)
+	}
+}
+
+func TestLoadPipelines_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"no pipelines", "defaults: {rate: 1}\n", "no pipelines"},
+		{"missing name", "pipelines:\n  - {sensor-name: a}\n", "pipeline 1: name is required"},
+		{"duplicate name", "pipelines:\n  - {name: a}\n  - {name: a}\n", "defined twice"},
+		{"unknown setting", "pipelines:\n  - {name: a, humidity: 3}\n", "unknown setting humidity"},
+		{"bad value", "pipelines:\n  - {name: a, rate: fast}\n", "pipeline a: rate"},
+		{"invalid config", "pipelines:\n  - {name: a, tls-server-name: sink.example}\n", "pipeline a:"},
+		{"named defaults", "defaults: {name: a}\npipelines:\n  - {name: b}\n", "can't set a name"},
+		{"two stdin readers", "defaults: {input: file, input-file: '-', input-format: json}\npipelines:\n  - {name: a}\n  - {name: b}\n", "read by pipeline a"},
+		{"shared state file", "defaults: {state-file: /tmp/node.state}\npipelines:\n  - {name: a}\n  - {name: b}\n", "used by pipeline a"},
+		{"shared spool", "defaults: {spool-dir: /tmp/spool}\npipelines:\n  - {name: a}\n  - {name: b}\n", "used by pipeline a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "node.yaml")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := loadPipelines(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadPipelines() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
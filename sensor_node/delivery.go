@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/sensor_node/proto"
+	"github.com/sensor_node/slo"
+)
+
+const (
+	// healthWindow is the window the success rate is held to -min-success-rate over.
+	healthWindow = 5 * time.Minute
+	// healthCheckInterval is how often the success rate is checked.
+	healthCheckInterval = 10 * time.Second
+	// budgetWindow is the window the error budget is reported over.
+	budgetWindow = time.Hour
+)
+
+// recordUndelivered counts readings that weren't delivered despite retries.
+func (s *SensorNode) recordUndelivered(readings []*pb.SensorData) {
+	for range readings {
+		s.delivery.Record(false)
+	}
+}
+
+// deliveryLoop checks the success rate against MinSuccessRate and logs a summary of
+// the rates every DeliveryReportInterval.
+func (s *SensorNode) deliveryLoop() {
+	defer s.sends.Done()
+
+	var check, report <-chan time.Time
+	if s.config.MinSuccessRate > 0 {
+		ticker := s.config.Clock.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+		check = ticker.C()
+	}
+	if s.config.DeliveryReportInterval > 0 {
+		ticker := s.config.Clock.NewTicker(s.config.DeliveryReportInterval)
+		defer ticker.Stop()
+		report = ticker.C()
+	}
+
+	for {
+		select {
+		case <-check:
+			s.checkDelivery()
+		case <-report:
+			log.Println(s.deliverySummary())
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// checkDelivery marks the node unhealthy while the success rate over healthWindow is
+// below MinSuccessRate, and logs and reports the changes to the sink.
+func (s *SensorNode) checkDelivery() {
+	rate, attempted := s.delivery.Rate(healthWindow)
+	unhealthy := attempted > 0 && rate < s.config.MinSuccessRate
+	if s.unhealthy.Swap(unhealthy) == unhealthy {
+		return
+	}
+
+	event := &pb.Event{
+		SensorName: s.config.SensorName,
+		Timestamp:  timestamppb.New(s.config.Clock.Now()),
+		Severity:   pb.Severity_SEVERITY_INFO,
+		Message:    "delivery success rate recovered",
+		Attributes: map[string]string{
+			"success_rate":     strconv.FormatFloat(rate, 'f', 4, 64),
+			"min_success_rate": strconv.FormatFloat(s.config.MinSuccessRate, 'f', 4, 64),
+			"window":           windowName(healthWindow),
+		},
+	}
+	if unhealthy {
+		log.Printf("Node unhealthy: delivered %.2f%% of %d readings over %v, below %.2f%%", rate*100, attempted, healthWindow, s.config.MinSuccessRate*100)
+		event.Severity = pb.Severity_SEVERITY_ERROR
+		event.Message = "delivery success rate below target"
+	} else {
+		log.Printf("Node healthy again: delivered %.2f%% of readings over %v", rate*100, healthWindow)
+	}
+	s.reportEvent(event, "delivery health")
+}
+
+// deliverySummary describes the success rate over every window, and the error
+// budget used when there is a target.
+func (s *SensorNode) deliverySummary() string {
+	var b strings.Builder
+	b.WriteString("Delivery:")
+	for i, window := range slo.Windows {
+		rate, attempted := s.delivery.Rate(window)
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, " %s %.2f%% of %d", windowName(window), rate*100, attempted)
+	}
+	if target := s.config.MinSuccessRate; target > 0 {
+		fmt.Fprintf(&b, ", error budget used over %s: %.0f%%", windowName(budgetWindow), s.delivery.BudgetUsed(budgetWindow, target)*100)
+	}
+	return b.String()
+}
+
+// serveMetrics serves the delivery metrics on /metrics and the node's health on
+// /healthz from l until Close.
+func (s *SensorNode) serveMetrics(l net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/healthz", s.handleHealth)
+	s.metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := s.metricsServer.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Printf("Metrics server failed: %v", err)
+		}
+	}()
+}
+
+// handleMetrics writes the delivery metrics in the Prometheus text format.
+func (s *SensorNode) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	sensor := "sensor=" + strconv.Quote(s.config.SensorName)
+	attempted, delivered := s.delivery.Totals()
+	healthy := 1
+	if s.unhealthy.Load() {
+		healthy = 0
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP sensor_node_readings_attempted_total Readings the node attempted to deliver.\n")
+	fmt.Fprintf(w, "# TYPE sensor_node_readings_attempted_total counter\n")
+	fmt.Fprintf(w, "sensor_node_readings_attempted_total{%s} %d\n", sensor, attempted)
+	fmt.Fprintf(w, "# HELP sensor_node_readings_delivered_total Readings the sink accepted.\n")
+	fmt.Fprintf(w, "# TYPE sensor_node_readings_delivered_total counter\n")
+	fmt.Fprintf(w, "sensor_node_readings_delivered_total{%s} %d\n", sensor, delivered)
+	fmt.Fprintf(w, "# HELP sensor_node_delivery_success_ratio Share of the readings attempted over the window that were delivered.\n")
+	fmt.Fprintf(w, "# TYPE sensor_node_delivery_success_ratio gauge\n")
+	for _, window := range slo.Windows {
+		rate, _ := s.delivery.Rate(window)
+		fmt.Fprintf(w, "sensor_node_delivery_success_ratio{%s,window=%q} %g\n", sensor, windowName(window), rate)
+	}
+	if target := s.config.MinSuccessRate; target > 0 {
+		fmt.Fprintf(w, "# HELP sensor_node_error_budget_used_ratio Share of the error budget of -min-success-rate used over the window.\n")
+		fmt.Fprintf(w, "# TYPE sensor_node_error_budget_used_ratio gauge\n")
+		fmt.Fprintf(w, "sensor_node_error_budget_used_ratio{%s,window=%q} %g\n", sensor, windowName(budgetWindow), s.delivery.BudgetUsed(budgetWindow, target))
+	}
+	if s.spool != nil {
+		readings, size := s.spool.Len()
+		fmt.Fprintf(w, "# HELP sensor_node_spooled_readings Readings in -spool-dir waiting to be resent.\n")
+		fmt.Fprintf(w, "# TYPE sensor_node_spooled_readings gauge\n")
+		fmt.Fprintf(w, "sensor_node_spooled_readings{%s} %d\n", sensor, readings)
+		fmt.Fprintf(w, "# HELP sensor_node_spool_bytes Bytes of the readings in -spool-dir.\n")
+		fmt.Fprintf(w, "# TYPE sensor_node_spool_bytes gauge\n")
+		fmt.Fprintf(w, "sensor_node_spool_bytes{%s} %d\n", sensor, size)
+	}
+	fmt.Fprintf(w, "# HELP sensor_node_healthy Whether the delivery success rate meets -min-success-rate.\n")
+	fmt.Fprintf(w, "# TYPE sensor_node_healthy gauge\n")
+	fmt.Fprintf(w, "sensor_node_healthy{%s} %d\n", sensor, healthy)
+}
+
+// handleHealth fails while the success rate is below MinSuccessRate.
+func (s *SensorNode) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if s.unhealthy.Load() {
+		http.Error(w, "delivery success rate below target", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// windowName formats a window as in 5m or 1h.
+func windowName(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return strconv.Itoa(int(d/time.Hour)) + "h"
+	case d%time.Minute == 0:
+		return strconv.Itoa(int(d/time.Minute)) + "m"
+	default:
+		return d.String()
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sensor_node/clock"
+	"github.com/sensor_node/slo"
+)
+
+func TestDeliveryReporting(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	s := &SensorNode{
+		config:   Config{SensorName: "temp-1", MinSuccessRate: 0.5, Clock: clk},
+		delivery: slo.NewTracker(clk),
+	}
+	for i := 0; i < 20; i++ {
+		s.delivery.Record(i%4 != 0)
+	}
+
+	if got, want := s.deliverySummary(), "Delivery: 1m 75.00% of 20, 5m 75.00% of 20, 1h 75.00% of 20, error budget used over 1h: 50%"; got != want {
+		t.Errorf("deliverySummary() = %q, want %q", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`sensor_node_readings_attempted_total{sensor="temp-1"} 20`,
+		`sensor_node_readings_delivered_total{sensor="temp-1"} 15`,
+		`sensor_node_delivery_success_ratio{sensor="temp-1",window="5m"} 0.75`,
+		`sensor_node_error_budget_used_ratio{sensor="temp-1",window="1h"} 0.5`,
+		`sensor_node_healthy{sensor="temp-1"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want+"\n") {
+			t.Errorf("metrics lack %s:\n%s", want, rec.Body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", rec.Code)
+	}
+	s.unhealthy.Store(true)
+	rec = httptest.NewRecorder()
+	s.handleHealth(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz while unhealthy = %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,199 @@
+// Package discovery resolves dns:/// sink addresses for gRPC and keeps re-resolving
+// them, so connections follow sink instances that come and go behind a headless
+// Kubernetes service. gRPC's own DNS resolver only re-resolves after a connection
+// fails and would never notice added instances.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/sensor_node/clock"
+)
+
+const (
+	// Scheme is the target scheme handled by the resolver.
+	Scheme = "dns"
+
+	// defaultPort applies to targets without a port, matching the sink's default.
+	defaultPort = "9090"
+
+	// lookupTimeout bounds a single DNS lookup.
+	lookupTimeout = 10 * time.Second
+
+	// serviceConfig spreads calls over every resolved sink instead of using the first.
+	serviceConfig = `{"loadBalancingConfig":[{"round_robin":{}}]}`
+)
+
+// IsDNSTarget reports whether target is a dns:/// address.
+func IsDNSTarget(target string) bool {
+	return strings.HasPrefix(target, Scheme+":")
+}
+
+// DialOptions returns the options for dialing a dns:/// target, re-resolving it
+// every refresh and balancing calls round-robin over all resolved addresses.
+func DialOptions(refresh time.Duration, clk clock.Clock) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithResolvers(NewBuilder(refresh, clk)),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+	}
+}
+
+// Builder builds resolvers for dns:/// targets.
+type Builder struct {
+	refresh    time.Duration
+	clock      clock.Clock
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+// NewBuilder returns a builder whose resolvers look up the target every refresh.
+func NewBuilder(refresh time.Duration, clk clock.Clock) *Builder {
+	return &Builder{
+		refresh:    refresh,
+		clock:      clk,
+		lookupHost: net.DefaultResolver.LookupHost,
+	}
+}
+
+func (b *Builder) Scheme() string {
+	return Scheme
+}
+
+func (b *Builder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	host, port, err := splitHostPort(target.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+
+	r := &dnsResolver{
+		host:       host,
+		port:       port,
+		cc:         cc,
+		lookupHost: b.lookupHost,
+		resolveNow: make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	// IP literals never change, there is nothing to re-resolve.
+	if net.ParseIP(host) != nil {
+		r.update([]string{host})
+		return r, nil
+	}
+
+	r.resolve()
+	r.wg.Add(1)
+	go r.watch(b.clock.NewTicker(b.refresh))
+
+	return r, nil
+}
+
+func splitHostPort(endpoint string) (string, string, error) {
+	if endpoint == "" {
+		return "", "", fmt.Errorf("dns target has no host")
+	}
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		// No port, or an IPv6 literal without brackets.
+		return strings.Trim(endpoint, "[]"), defaultPort, nil
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("dns target %q has no host", endpoint)
+	}
+	return host, port, nil
+}
+
+type dnsResolver struct {
+	host       string
+	port       string
+	cc         resolver.ClientConn
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	resolveNow chan struct{}
+	done       chan struct{}
+	wg         sync.WaitGroup
+
+	addrs []string // last addresses passed to cc, sorted
+}
+
+// watch re-resolves on every tick and whenever gRPC asks after a connection failure.
+func (r *dnsResolver) watch(ticker clock.Ticker) {
+	defer r.wg.Done()
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+		case <-r.resolveNow:
+		case <-r.done:
+			return
+		}
+		r.resolve()
+	}
+}
+
+func (r *dnsResolver) resolve() {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	ips, err := r.lookupHost(ctx, r.host)
+	if err == nil && len(ips) == 0 {
+		err = fmt.Errorf("no addresses for %s", r.host)
+	}
+	if err != nil {
+		// Keep the last known addresses; gRPC only drops them on an empty update.
+		log.Printf("Failed to resolve sink %s: %v", r.host, err)
+		if r.addrs == nil {
+			r.cc.ReportError(err)
+		}
+		return
+	}
+
+	r.update(ips)
+}
+
+// update hands the addresses to gRPC if they changed. Connections to removed
+// addresses are closed and new ones opened, rebalancing calls across the sinks.
+func (r *dnsResolver) update(ips []string) {
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip, r.port))
+	}
+	slices.Sort(addrs)
+	addrs = slices.Compact(addrs)
+
+	if slices.Equal(addrs, r.addrs) {
+		return
+	}
+	if r.addrs != nil {
+		log.Printf("Sink %s resolved to %s", r.host, strings.Join(addrs, ", "))
+	}
+	r.addrs = addrs
+
+	state := resolver.State{Addresses: make([]resolver.Address, 0, len(addrs))}
+	for _, addr := range addrs {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+	}
+	if err := r.cc.UpdateState(state); err != nil {
+		log.Printf("Failed to update sink addresses: %v", err)
+	}
+}
+
+func (r *dnsResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+func (r *dnsResolver) Close() {
+	close(r.done)
+	r.wg.Wait()
+}
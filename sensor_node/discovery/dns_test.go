@@ -0,0 +1,174 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+
+	"github.com/sensor_node/clock"
+)
+
+// fakeClientConn records the address lists the resolver reports.
+type fakeClientConn struct {
+	resolver.ClientConn
+
+	states chan []string
+	errs   chan error
+}
+
+func newFakeClientConn() *fakeClientConn {
+	return &fakeClientConn{states: make(chan []string, 10), errs: make(chan error, 10)}
+}
+
+func (cc *fakeClientConn) UpdateState(state resolver.State) error {
+	var addrs []string
+	for _, addr := range state.Addresses {
+		addrs = append(addrs, addr.Addr)
+	}
+	cc.states <- addrs
+	return nil
+}
+
+func (cc *fakeClientConn) ReportError(err error) {
+	cc.errs <- err
+}
+
+func (cc *fakeClientConn) next(t *testing.T) []string {
+	t.Helper()
+	select {
+	case addrs := <-cc.states:
+		return addrs
+	case <-time.After(time.Second):
+		t.Fatal("no address update")
+		return nil
+	}
+}
+
+// fakeDNS answers lookups from a table that tests change.
+type fakeDNS struct {
+	mu    sync.Mutex
+	hosts map[string][]string
+}
+
+func (d *fakeDNS) set(host string, ips ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hosts[host] = ips
+}
+
+func (d *fakeDNS) lookupHost(_ context.Context, host string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ips, ok := d.hosts[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return ips, nil
+}
+
+func newTestBuilder(t *testing.T, refresh time.Duration) (*Builder, *fakeDNS, *clock.Fake) {
+	t.Helper()
+
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dns := &fakeDNS{hosts: make(map[string][]string)}
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := NewBuilder(refresh, clk)
+	b.lookupHost = dns.lookupHost
+
+	return b, dns, clk
+}
+
+func target(s string) resolver.Target {
+	u, err := url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return resolver.Target{URL: *u}
+}
+
+func TestResolver_ReResolvesPeriodically(t *testing.T) {
+	b, dns, clk := newTestBuilder(t, 30*time.Second)
+	dns.set("telemetry-sink.internal", "10.0.0.2", "10.0.0.1")
+	cc := newFakeClientConn()
+
+	r, err := b.Build(target("dns:///telemetry-sink.internal:9091"), cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	defer r.Close()
+
+	if got, want := cc.next(t), []string{"10.0.0.1:9091", "10.0.0.2:9091"}; !slices.Equal(got, want) {
+		t.Errorf("initial addresses = %v, want %v", got, want)
+	}
+
+	// Unchanged answers are not reported again.
+	dns.set("telemetry-sink.internal", "10.0.0.1", "10.0.0.2")
+	clk.Advance(30 * time.Second)
+
+	dns.set("telemetry-sink.internal", "10.0.0.1", "10.0.0.3")
+	clk.Advance(30 * time.Second)
+	if got, want := cc.next(t), []string{"10.0.0.1:9091", "10.0.0.3:9091"}; !slices.Equal(got, want) {
+		t.Errorf("addresses after re-resolution = %v, want %v", got, want)
+	}
+}
+
+func TestResolver_KeepsAddressesOnLookupFailure(t *testing.T) {
+	b, dns, _ := newTestBuilder(t, time.Minute)
+	cc := newFakeClientConn()
+
+	r, err := b.Build(target("dns:///sink"), cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	defer r.Close()
+
+	select {
+	case <-cc.errs:
+	default:
+		t.Fatal("lookup failure without known addresses was not reported")
+	}
+
+	dns.set("sink", "10.0.0.1")
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	if got, want := cc.next(t), []string{"10.0.0.1:" + defaultPort}; !slices.Equal(got, want) {
+		t.Errorf("addresses after ResolveNow() = %v, want %v", got, want)
+	}
+
+	dns.mu.Lock()
+	delete(dns.hosts, "sink")
+	dns.mu.Unlock()
+	r.(*dnsResolver).resolve()
+	select {
+	case addrs := <-cc.states:
+		t.Errorf("failed lookup reported addresses %v", addrs)
+	case err := <-cc.errs:
+		t.Errorf("failed lookup with known addresses reported %v", err)
+	default:
+	}
+}
+
+func TestResolver_IPLiteral(t *testing.T) {
+	b, _, _ := newTestBuilder(t, time.Minute)
+	cc := newFakeClientConn()
+
+	r, err := b.Build(target("dns:///[::1]:9090"), cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	defer r.Close()
+
+	if got, want := cc.next(t), []string{"[::1]:9090"}; !slices.Equal(got, want) {
+		t.Errorf("addresses = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,210 @@
+// Package fault injects sensor failures into generated readings: periodic dropouts,
+// stuck values, spikes, drift, NaN and overflowing values. Faults are drawn from a
+// seeded random source, so a seed replays the same failure scenario.
+package fault
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sensor_node/clock"
+)
+
+// spikeFactor is how many times its value a spike is, away from zero.
+const spikeFactor = 10
+
+// Faults are the failures to inject. Chances are per reading, between 0 and 1; the
+// zero value injects nothing.
+type Faults struct {
+	// DropoutEvery is the period of dropouts, the last DropoutFor of which the
+	// sensor produces no readings
+	DropoutEvery time.Duration
+	DropoutFor   time.Duration
+	// Stuck is the chance of the value getting stuck for StuckFor
+	Stuck    float64
+	StuckFor time.Duration
+	// Spike is the chance of a value spikeFactor times as far from zero
+	Spike float64
+	// Drift is added to values per hour since the injector started
+	Drift float64
+	// NaN is the chance of a value that isn't a number
+	NaN float64
+	// Overflow is the chance of a value out of the range of its type
+	Overflow float64
+}
+
+// Parse parses a comma separated list of faults, e.g.
+// dropout=10m/30s,stuck=0.01/1m,spike=0.02,drift=5,nan=0.01,overflow=0.01.
+func Parse(spec string) (Faults, error) {
+	var f Faults
+	for _, pair := range strings.Split(spec, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return Faults{}, fmt.Errorf("invalid fault %q, expected name=value", pair)
+		}
+
+		var err error
+		switch name {
+		case "dropout":
+			f.DropoutEvery, f.DropoutFor, err = parseDurations(value)
+			if err == nil && (f.DropoutFor <= 0 || f.DropoutFor >= f.DropoutEvery) {
+				err = fmt.Errorf("dropout must be shorter than its period")
+			}
+		case "stuck":
+			chance, duration, found := strings.Cut(value, "/")
+			if !found {
+				err = fmt.Errorf("expected chance/duration")
+				break
+			}
+			if f.Stuck, err = parseChance(chance); err == nil {
+				f.StuckFor, err = parseDuration(duration)
+			}
+		case "spike":
+			f.Spike, err = parseChance(value)
+		case "drift":
+			f.Drift, err = strconv.ParseFloat(value, 64)
+			if err == nil && (math.IsNaN(f.Drift) || math.IsInf(f.Drift, 0)) {
+				err = fmt.Errorf("drift must be finite")
+			}
+		case "nan":
+			f.NaN, err = parseChance(value)
+		case "overflow":
+			f.Overflow, err = parseChance(value)
+		default:
+			return Faults{}, fmt.Errorf("unknown fault %q, expected dropout, stuck, spike, drift, nan or overflow", name)
+		}
+		if err != nil {
+			return Faults{}, fmt.Errorf("fault %s: %w", name, err)
+		}
+	}
+	return f, nil
+}
+
+// parseDurations parses every/for, as in 10m/30s.
+func parseDurations(value string) (time.Duration, time.Duration, error) {
+	every, length, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected period/duration")
+	}
+	e, err := parseDuration(every)
+	if err != nil {
+		return 0, 0, err
+	}
+	l, err := parseDuration(length)
+	return e, l, err
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("duration %s must be positive", value)
+	}
+	return d, err
+}
+
+func parseChance(value string) (float64, error) {
+	p, err := strconv.ParseFloat(value, 64)
+	if err == nil && !(p >= 0 && p <= 1) {
+		err = fmt.Errorf("chance %s must be between 0 and 1", value)
+	}
+	return p, err
+}
+
+// Enabled reports whether any fault is injected.
+func (f Faults) Enabled() bool {
+	return f != Faults{}
+}
+
+// Injector applies faults to the values of readings as they are generated. It is
+// safe for concurrent use.
+type Injector struct {
+	faults Faults
+	clock  clock.Clock
+	start  time.Time
+
+	mu    sync.Mutex
+	rand  *rand.Rand
+	stuck map[string]stuckValue
+}
+
+type stuckValue struct {
+	value float64
+	until time.Time
+}
+
+// NewInjector creates an injector drawing faults from a source seeded with seed and
+// timing dropouts, stuck values and drift by clk from now.
+func NewInjector(faults Faults, clk clock.Clock, seed int64) *Injector {
+	return &Injector{
+		faults: faults,
+		clock:  clk,
+		start:  clk.Now(),
+		rand:   rand.New(rand.NewSource(seed)),
+		stuck:  make(map[string]stuckValue),
+	}
+}
+
+// Dropped reports whether the sensor is in a dropout and produces no reading.
+func (in *Injector) Dropped() bool {
+	if in.faults.DropoutEvery == 0 {
+		return false
+	}
+	elapsed := in.clock.Now().Sub(in.start) % in.faults.DropoutEvery
+	return elapsed >= in.faults.DropoutEvery-in.faults.DropoutFor
+}
+
+// Value returns the named value with faults applied. An overflow is +Inf.
+func (in *Injector) Value(name string, value float64) float64 {
+	return in.apply(name, value, math.Inf(1), true)
+}
+
+// IntValue returns the named integer value with faults applied. It can't be NaN, and
+// an overflow is the largest int32.
+func (in *Injector) IntValue(name string, value int32) int32 {
+	v := in.apply(name, float64(value), math.MaxInt32, false)
+	return int32(max(min(v, math.MaxInt32), math.MinInt32))
+}
+
+// apply draws the faults of a reading in a fixed order, so a seed gives the same
+// faults whatever they are applied to.
+func (in *Injector) apply(name string, value, overflow float64, nan bool) float64 {
+	now := in.clock.Now()
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if stuck, ok := in.stuck[name]; ok {
+		if now.Before(stuck.until) {
+			return stuck.value
+		}
+		delete(in.stuck, name)
+	}
+
+	value += in.faults.Drift * now.Sub(in.start).Hours()
+	switch {
+	case in.chance(in.faults.NaN) && nan:
+		value = math.NaN()
+	case in.chance(in.faults.Overflow):
+		value = overflow
+	case in.chance(in.faults.Spike):
+		value *= spikeFactor
+	}
+	if in.chance(in.faults.Stuck) {
+		in.stuck[name] = stuckValue{value: value, until: now.Add(in.faults.StuckFor)}
+	}
+	return value
+}
+
+// chance draws whether an event of probability p happens; nothing is drawn for
+// faults not injected.
+func (in *Injector) chance(p float64) bool {
+	return p > 0 && in.rand.Float64() < p
+}
@@ -0,0 +1,133 @@
+package fault
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sensor_node/clock"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	f, err := Parse("dropout=10m/30s, stuck=0.01/1m,spike=0.02,drift=-5,nan=0.5,overflow=1")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := Faults{
+		DropoutEvery: 10 * time.Minute, DropoutFor: 30 * time.Second,
+		Stuck: 0.01, StuckFor: time.Minute,
+		Spike: 0.02, Drift: -5, NaN: 0.5, Overflow: 1,
+	}
+	if f != want {
+		t.Errorf("Parse() = %+v, want %+v", f, want)
+	}
+
+	for _, spec := range []string{"spike", "spike=2", "nan=-0.1", "stuck=0.1", "dropout=30s/10m", "drift=NaN", "flicker=0.1"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) error = nil, want an error", spec)
+		}
+	}
+}
+
+func TestInjector_Dropout(t *testing.T) {
+	clk := clock.NewFake(start)
+	in := NewInjector(Faults{DropoutEvery: time.Minute, DropoutFor: 10 * time.Second}, clk, 1)
+
+	for _, tc := range []struct {
+		at   time.Duration
+		want bool
+	}{
+		{0, false},
+		{49 * time.Second, false},
+		{50 * time.Second, true},
+		{59 * time.Second, true},
+		{60 * time.Second, false},
+		{115 * time.Second, true},
+	} {
+		clk.Advance(tc.at - clk.Now().Sub(start))
+		if got := in.Dropped(); got != tc.want {
+			t.Errorf("Dropped() at %v = %v, want %v", tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestInjector_Values(t *testing.T) {
+	clk := clock.NewFake(start)
+
+	in := NewInjector(Faults{Overflow: 1}, clk, 1)
+	if v := in.Value("temperature", 20); !math.IsInf(v, 1) {
+		t.Errorf("overflowing Value() = %v, want +Inf", v)
+	}
+	if v := in.IntValue("temperature", 20); v != math.MaxInt32 {
+		t.Errorf("overflowing IntValue() = %v, want %d", v, math.MaxInt32)
+	}
+
+	in = NewInjector(Faults{NaN: 1}, clk, 1)
+	if v := in.Value("temperature", 20); !math.IsNaN(v) {
+		t.Errorf("Value() = %v, want NaN", v)
+	}
+	if v := in.IntValue("temperature", 20); v != 20 {
+		t.Errorf("IntValue() = %v, want 20: integers can't be NaN", v)
+	}
+
+	in = NewInjector(Faults{Spike: 1}, clk, 1)
+	if v := in.Value("temperature", -20); v != -200 {
+		t.Errorf("spiking Value() = %v, want -200", v)
+	}
+
+	in = NewInjector(Faults{Drift: 4}, clk, 1)
+	clk.Advance(30 * time.Minute)
+	if v := in.Value("temperature", 20); v != 22 {
+		t.Errorf("Value() after half an hour of drift = %v, want 22", v)
+	}
+}
+
+func TestInjector_Stuck(t *testing.T) {
+	clk := clock.NewFake(start)
+	in := NewInjector(Faults{Stuck: 1, StuckFor: time.Minute}, clk, 1)
+
+	in.Value("temperature", 20)
+	clk.Advance(30 * time.Second)
+	if v := in.Value("temperature", 25); v != 20 {
+		t.Errorf("stuck Value() = %v, want 20", v)
+	}
+	if v := in.Value("humidity", 40); v != 40 {
+		t.Errorf("Value() of another value = %v, want 40: values get stuck separately", v)
+	}
+	clk.Advance(30 * time.Second)
+	if v := in.Value("temperature", 25); v != 25 {
+		t.Errorf("Value() once unstuck = %v, want 25", v)
+	}
+}
+
+func TestInjector_Seed(t *testing.T) {
+	faults := Faults{Spike: 0.3, NaN: 0.1, Stuck: 0.1, StuckFor: time.Second}
+	run := func(seed int64) []float64 {
+		clk := clock.NewFake(start)
+		in := NewInjector(faults, clk, seed)
+		var values []float64
+		for i := 0; i < 100; i++ {
+			values = append(values, in.Value("temperature", float64(i)))
+			clk.Advance(100 * time.Millisecond)
+		}
+		return values
+	}
+
+	first, again, other := run(7), run(7), run(8)
+	same := func(a, b []float64) bool {
+		for i := range a {
+			if a[i] != b[i] && !(math.IsNaN(a[i]) && math.IsNaN(b[i])) {
+				return false
+			}
+		}
+		return true
+	}
+	if !same(first, again) {
+		t.Errorf("runs with the same seed differ:\n%v\n%v", first, again)
+	}
+	if same(first, other) {
+		t.Error("runs with different seeds inject the same faults")
+	}
+}
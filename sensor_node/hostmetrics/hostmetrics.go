@@ -0,0 +1,16 @@
+// Package hostmetrics samples the health of the host a sensor node runs on, so a
+// fleet's hosts can be monitored through the same pipeline as its readings.
+package hostmetrics
+
+import "errors"
+
+// Names of the sampled values.
+const (
+	CPUPercent         = "cpu_percent"         // busy time since the previous sample
+	MemoryPercent      = "memory_percent"      // memory not available to new processes
+	DiskPercent        = "disk_percent"        // used space of the root file system
+	TemperatureCelsius = "temperature_celsius" // hottest thermal zone, if the host has any
+)
+
+// ErrUnsupported is returned by NewSampler on platforms it can't sample.
+var ErrUnsupported = errors.New("host metrics are only supported on Linux")
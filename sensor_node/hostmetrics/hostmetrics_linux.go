@@ -0,0 +1,170 @@
+package hostmetrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Sampler reads host metrics from procfs, sysfs and the root file system.
+type Sampler struct {
+	procDir  string
+	sysDir   string
+	diskPath string
+
+	prev cpuTimes // at the previous sample
+}
+
+// cpuTimes are the cumulative jiffies of all CPUs.
+type cpuTimes struct {
+	idle, total uint64
+}
+
+// NewSampler creates a sampler. CPU usage is measured from this call on.
+func NewSampler() (*Sampler, error) {
+	return newSampler("/proc", "/sys", "/")
+}
+
+func newSampler(procDir, sysDir, diskPath string) (*Sampler, error) {
+	s := &Sampler{procDir: procDir, sysDir: sysDir, diskPath: diskPath}
+	prev, err := s.cpuTimes()
+	if err != nil {
+		return nil, err
+	}
+	s.prev = prev
+	return s, nil
+}
+
+// Sample returns the current values by name. Temperature is left out on hosts
+// without thermal zones. A Sampler isn't safe for concurrent use.
+func (s *Sampler) Sample() (map[string]float64, error) {
+	values := make(map[string]float64, 4)
+
+	times, err := s.cpuTimes()
+	if err != nil {
+		return nil, err
+	}
+	if total := times.total - s.prev.total; total > 0 {
+		values[CPUPercent] = 100 * (1 - float64(times.idle-s.prev.idle)/float64(total))
+	} else {
+		values[CPUPercent] = 0
+	}
+	s.prev = times
+
+	if values[MemoryPercent], err = s.memoryPercent(); err != nil {
+		return nil, err
+	}
+	if values[DiskPercent], err = s.diskPercent(); err != nil {
+		return nil, err
+	}
+	if celsius, ok := s.temperature(); ok {
+		values[TemperatureCelsius] = celsius
+	}
+
+	return values, nil
+}
+
+// cpuTimes reads the first line of /proc/stat: the jiffies all CPUs spent in user,
+// nice, system, idle, iowait, irq, softirq and steal time. Guest time is already
+// part of user time.
+func (s *Sampler) cpuTimes() (cpuTimes, error) {
+	data, err := os.ReadFile(filepath.Join(s.procDir, "stat"))
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return cpuTimes{}, fmt.Errorf("unexpected /proc/stat line %q", line)
+	}
+
+	var times cpuTimes
+	for i, field := range fields[1:min(len(fields), 9)] {
+		jiffies, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return cpuTimes{}, fmt.Errorf("parse /proc/stat: %w", err)
+		}
+		times.total += jiffies
+		if i == 3 || i == 4 { // idle and iowait
+			times.idle += jiffies
+		}
+	}
+	return times, nil
+}
+
+// memoryPercent returns the share of memory that isn't available, as free reports
+// it.
+func (s *Sampler) memoryPercent() (float64, error) {
+	file, err := os.Open(filepath.Join(s.procDir, "meminfo"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var total, available uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total, err = strconv.ParseUint(fields[1], 10, 64)
+		case "MemAvailable:":
+			available, err = strconv.ParseUint(fields[1], 10, 64)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("parse /proc/meminfo: %w", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if total == 0 {
+		return 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+	}
+	return 100 * (1 - float64(available)/float64(total)), nil
+}
+
+// diskPercent returns the used share of the file system, as df reports it: space
+// reserved for root counts as neither used nor available.
+func (s *Sampler) diskPercent() (float64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(s.diskPath, &stat); err != nil {
+		return 0, fmt.Errorf("statfs %s: %w", s.diskPath, err)
+	}
+	used := stat.Blocks - stat.Bfree
+	if used+stat.Bavail == 0 {
+		return 0, nil
+	}
+	return 100 * float64(used) / float64(used+stat.Bavail), nil
+}
+
+// temperature returns the temperature of the hottest thermal zone.
+func (s *Sampler) temperature() (float64, bool) {
+	paths, _ := filepath.Glob(filepath.Join(s.sysDir, "class", "thermal", "thermal_zone*", "temp"))
+
+	var hottest float64
+	var found bool
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// Some zones fail to read while their sensor is powered down.
+			continue
+		}
+		millidegrees, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			continue
+		}
+		if celsius := float64(millidegrees) / 1000; !found || celsius > hottest {
+			hottest, found = celsius, true
+		}
+	}
+	return hottest, found
+}
@@ -0,0 +1,81 @@
+package hostmetrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSampler_Sample(t *testing.T) {
+	proc, sys := t.TempDir(), t.TempDir()
+	writeFile(t, filepath.Join(proc, "stat"), "cpu  100 0 100 700 100 0 0 0 0 0\ncpu0 100 0 100 700 100 0 0 0 0 0\n")
+	writeFile(t, filepath.Join(proc, "meminfo"), "MemTotal:        8000000 kB\nMemFree:          1000000 kB\nMemAvailable:     6000000 kB\n")
+	writeFile(t, filepath.Join(sys, "class/thermal/thermal_zone0/temp"), "41500\n")
+	writeFile(t, filepath.Join(sys, "class/thermal/thermal_zone1/temp"), "63250\n")
+
+	s, err := newSampler(proc, sys, t.TempDir())
+	if err != nil {
+		t.Fatalf("newSampler() error = %v", err)
+	}
+
+	// 400 jiffies pass, 100 of them idle or waiting for I/O.
+	writeFile(t, filepath.Join(proc, "stat"), "cpu  300 0 200 750 150 0 0 0 0 0\n")
+	values, err := s.Sample()
+	if err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+
+	if got := values[CPUPercent]; got != 75 {
+		t.Errorf("%s = %v, want 75", CPUPercent, got)
+	}
+	if got := values[MemoryPercent]; got != 25 {
+		t.Errorf("%s = %v, want 25", MemoryPercent, got)
+	}
+	if got := values[DiskPercent]; got < 0 || got > 100 {
+		t.Errorf("%s = %v, want a percentage", DiskPercent, got)
+	}
+	if got := values[TemperatureCelsius]; got != 63.25 {
+		t.Errorf("%s = %v, want the hottest zone", TemperatureCelsius, got)
+	}
+
+	// Without a change since the previous sample the CPU was idle.
+	if values, err = s.Sample(); err != nil || values[CPUPercent] != 0 {
+		t.Errorf("Sample() = %v, %v, want an idle CPU", values, err)
+	}
+}
+
+func TestSampler_NoThermalZones(t *testing.T) {
+	proc := t.TempDir()
+	writeFile(t, filepath.Join(proc, "stat"), "cpu  1 0 1 1 0 0 0 0\n")
+	writeFile(t, filepath.Join(proc, "meminfo"), "MemTotal: 100 kB\nMemAvailable: 100 kB\n")
+
+	s, err := newSampler(proc, t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := s.Sample()
+	if err != nil {
+		t.Fatalf("Sample() error = %v", err)
+	}
+	if _, ok := values[TemperatureCelsius]; ok {
+		t.Errorf("Sample() = %v, want no temperature", values)
+	}
+}
+
+func TestNewSampler_MalformedStat(t *testing.T) {
+	proc := t.TempDir()
+	writeFile(t, filepath.Join(proc, "stat"), "intr 1 2 3\n")
+	if _, err := newSampler(proc, t.TempDir(), "/"); err == nil {
+		t.Error("newSampler() error = nil, want one for a malformed /proc/stat")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package hostmetrics
+
+// Sampler is unavailable on this platform.
+type Sampler struct{}
+
+// NewSampler fails with ErrUnsupported.
+func NewSampler() (*Sampler, error) {
+	return nil, ErrUnsupported
+}
+
+// Sample is never called, as there are no samplers on this platform.
+func (s *Sampler) Sample() (map[string]float64, error) {
+	return nil, ErrUnsupported
+}
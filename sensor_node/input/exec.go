@@ -0,0 +1,115 @@
+package input
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxOutputSize bounds the output read from a command.
+	maxOutputSize = 64 * 1024
+
+	// maxStderrSize bounds the part of a failed command's stderr in its error.
+	maxStderrSize = 1024
+
+	// execWaitDelay is how long a command's output is waited for after it exits or
+	// is killed, in case it started children that hold on to its stdout.
+	execWaitDelay = time.Second
+)
+
+// Exec runs a shell command on every poll, such as reading a sysfs file or running
+// a vendor tool, and reads readings from its output: a single number, sent for the
+// default sensor, or JSON records like those of File. A command that exits with a
+// non-zero status, outlives the poll's context or prints no reading fails the poll.
+type Exec struct {
+	command       string
+	defaultSensor string
+}
+
+// NewExec creates an adapter running command with sh -c (cmd /C on Windows).
+func NewExec(command, defaultSensor string) *Exec {
+	return &Exec{command: command, defaultSensor: defaultSensor}
+}
+
+// Poll runs the command once and returns what it printed. The command, and on Unix
+// every process it started, is killed when ctx ends.
+func (e *Exec) Poll(ctx context.Context) ([]Reading, error) {
+	cmd := shellCommand(ctx, e.command)
+	cmd.WaitDelay = execWaitDelay
+	stdout := &cappedBuffer{max: maxOutputSize}
+	stderr := &cappedBuffer{max: maxStderrSize}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("command killed: %w", ctx.Err())
+		}
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("command failed: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("command failed: %w", err)
+	}
+	if stdout.truncated {
+		return nil, fmt.Errorf("command printed more than %d bytes", maxOutputSize)
+	}
+
+	return e.parse(stdout.Bytes())
+}
+
+// parse returns the readings in a command's output.
+func (e *Exec) parse(output []byte) ([]Reading, error) {
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 {
+		return nil, fmt.Errorf("command printed nothing")
+	}
+	if value, err := strconv.ParseFloat(string(output), 64); err == nil {
+		return []Reading{{SensorName: e.defaultSensor, Value: value}}, nil
+	}
+
+	// A single record may be pretty-printed over several lines; otherwise every
+	// line is a record.
+	parser := recordParser{format: FormatJSON, defaultSensor: e.defaultSensor}
+	if reading, _, err := parser.parse(output); err == nil {
+		return []Reading{reading}, nil
+	}
+	var readings []Reading
+	for i, line := range bytes.Split(output, []byte("\n")) {
+		reading, ok, err := parser.parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("parse output line %d: %w", i+1, err)
+		}
+		if ok {
+			readings = append(readings, reading)
+		}
+	}
+	return readings, nil
+}
+
+// Close does nothing, as commands don't outlive polls.
+func (e *Exec) Close() error {
+	return nil
+}
+
+// cappedBuffer keeps the first max bytes written to it and discards the rest, so a
+// command can't exhaust memory and isn't blocked writing.
+type cappedBuffer struct {
+	buf       bytes.Buffer // not embedded, so that io.Copy can't bypass Write
+	max       int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
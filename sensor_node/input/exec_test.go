@@ -0,0 +1,70 @@
+package input
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExec_Poll(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands use sh")
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		want    []Reading
+		wantErr string
+	}{
+		{"number", "echo 45.5", []Reading{{SensorName: "cpu", Value: 45.5}}, ""},
+		{"record", `printf '{\n  "sensor_name": "fan",\n  "sensor_value": 1200\n}\n'`, []Reading{{SensorName: "fan", Value: 1200}}, ""},
+		{"records", `echo '{"values":{"rx":1}}'; echo '{"sensor_name":"link","sensor_value":1}'`,
+			[]Reading{{SensorName: "cpu", Values: map[string]float64{"rx": 1}}, {SensorName: "link", Value: 1}}, ""},
+		{"failure", "echo 'sensor not found' >&2; exit 3", nil, "exit status 3: sensor not found"},
+		{"no output", "true", nil, "printed nothing"},
+		{"garbage", "echo warm", nil, "parse output line 1"},
+		{"too much output", "yes 1 | head -c 100000", nil, "more than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewExec(tt.command, "cpu").Poll(context.Background())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Poll() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Poll() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Poll() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExec_PollTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands use sh")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The child holds on to stdout; it is killed with the shell.
+	start := time.Now()
+	_, err := NewExec("sleep 10 & wait", "cpu").Poll(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Poll() error = %v, want the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > execWaitDelay {
+		t.Errorf("Poll() took %v, want the command killed at the deadline", elapsed)
+	}
+}
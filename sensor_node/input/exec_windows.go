@@ -0,0 +1,14 @@
+//go:build windows
+
+package input
+
+import (
+	"context"
+	"os/exec"
+)
+
+// shellCommand runs command with cmd. Processes it starts are left running when ctx
+// ends.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "cmd", "/C", command)
+}
@@ -0,0 +1,355 @@
+package input
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record formats of a file input.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+const (
+	// tailInterval is how often a file that was read to its end is checked for new
+	// records, truncation and rotation.
+	tailInterval = 250 * time.Millisecond
+
+	// maxRecordSize bounds a line, so a file without newlines can't exhaust memory.
+	maxRecordSize = 64 * 1024
+
+	// recordBuffer is the number of records read ahead of polls. The reader waits
+	// once it is full.
+	recordBuffer = 1024
+)
+
+// File reads records from a file as they are appended to it, like tail -f, or from
+// stdin until it ends. Each line is a record: a JSON object with the SensorData
+// field names, or a CSV row whose columns are named by the first line.
+type File struct {
+	path   string // "-" for stdin
+	parser recordParser
+
+	records chan Reading // closed when reading ends
+	err     error        // why reading ended, set before records is closed
+	done    chan struct{}
+}
+
+// OpenFile starts reading records from the file at path, or from stdin if path is
+// "-". A file is read from its current end, so only records appended from now on
+// are sent; its CSV header is read from its first line. Records without a sensor
+// name are sent for defaultSensor.
+func OpenFile(path, format, defaultSensor string) (*File, error) {
+	if format != FormatJSON && format != FormatCSV {
+		return nil, fmt.Errorf("unknown record format %q", format)
+	}
+
+	f := newFile(path, format, defaultSensor)
+	if path == "-" {
+		go f.read(os.Stdin, nil)
+		return f, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.skipToEnd(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	go f.read(file, file)
+	return f, nil
+}
+
+func newFile(path, format, defaultSensor string) *File {
+	return &File{
+		path:    path,
+		parser:  recordParser{format: format, defaultSensor: defaultSensor},
+		records: make(chan Reading, recordBuffer),
+		done:    make(chan struct{}),
+	}
+}
+
+// skipToEnd reads the CSV header, if any, and moves to the end of the file.
+func (f *File) skipToEnd(file *os.File) error {
+	if f.parser.format == FormatCSV {
+		line, err := bufio.NewReader(file).ReadBytes('\n')
+		if err == nil {
+			f.parser.parse(line)
+		}
+	}
+	_, err := file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// read sends records to the records channel until the input ends or Close is
+// called. file is nil for stdin, which isn't tailed.
+func (f *File) read(r io.Reader, file *os.File) {
+	defer close(f.records)
+	if file != nil {
+		defer func() { file.Close() }()
+	}
+
+	reader := bufio.NewReader(r)
+	var line []byte
+	var skipping bool // discarding the rest of an oversized line
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		switch {
+		case skipping:
+		case len(line)+len(chunk) > maxRecordSize:
+			log.Printf("Input %s: dropping record over %d bytes", f.path, maxRecordSize)
+			line, skipping = line[:0], true
+		default:
+			line = append(line, chunk...)
+		}
+
+		switch {
+		case err == nil:
+			if !skipping && !f.emit(line) {
+				return
+			}
+			line, skipping = line[:0], false
+			continue
+		case err == bufio.ErrBufferFull:
+			continue
+		case err != io.EOF:
+			f.err = err
+			return
+		case file == nil:
+			// The last line of stdin may lack a newline.
+			if !skipping && len(line) > 0 && !f.emit(line) {
+				return
+			}
+			f.err = io.EOF
+			return
+		}
+
+		// Caught up with the writer; a partial line stays in line until completed.
+		select {
+		case <-f.done:
+			return
+		case <-time.After(tailInterval):
+		}
+
+		reopened, err := f.follow(file)
+		if err != nil {
+			log.Printf("Input %s: %v", f.path, err)
+			continue
+		}
+		if reopened != nil {
+			file = reopened
+			reader.Reset(file)
+			line, skipping = line[:0], false
+		}
+	}
+}
+
+// follow checks whether the file was rotated or truncated. It returns the file to
+// continue with when reading must start over: the new file at path after rotation,
+// or the same file rewound after truncation. Otherwise it returns nil.
+func (f *File) follow(file *os.File) (*os.File, error) {
+	current, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	latest, err := os.Stat(f.path)
+	if err != nil {
+		// Rotated away and not yet recreated.
+		return nil, nil
+	}
+
+	if !os.SameFile(current, latest) {
+		reopened, err := os.Open(f.path)
+		if err != nil {
+			return nil, err
+		}
+		file.Close()
+		log.Printf("Input %s: file rotated, reading the new file", f.path)
+		f.parser.header = nil
+		return reopened, nil
+	}
+
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if current.Size() < offset {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		log.Printf("Input %s: file truncated, reading from the start", f.path)
+		f.parser.header = nil
+		return file, nil
+	}
+	return nil, nil
+}
+
+// emit parses a line and queues its record. It returns false once the file is
+// closed.
+func (f *File) emit(line []byte) bool {
+	reading, ok, err := f.parser.parse(line)
+	if err != nil {
+		log.Printf("Input %s: skipping record: %v", f.path, err)
+		return true
+	}
+	if !ok {
+		return true
+	}
+
+	select {
+	case f.records <- reading:
+		return true
+	case <-f.done:
+		return false
+	}
+}
+
+// Poll returns the records read since the last poll without waiting for more.
+func (f *File) Poll(ctx context.Context) ([]Reading, error) {
+	var readings []Reading
+	for {
+		select {
+		case reading, ok := <-f.records:
+			if !ok {
+				return readings, f.err
+			}
+			readings = append(readings, reading)
+		default:
+			return readings, nil
+		}
+	}
+}
+
+// Close stops reading. Stdin itself is left open.
+func (f *File) Close() error {
+	close(f.done)
+	return nil
+}
+
+// recordParser turns lines into readings.
+type recordParser struct {
+	format        string
+	defaultSensor string
+	header        []string // CSV column names, nil until the header line is read
+}
+
+// jsonRecord is a JSON line. The timestamp is an RFC 3339 string or seconds since
+// the epoch.
+type jsonRecord struct {
+	SensorName  string             `json:"sensor_name"`
+	SensorValue *float64           `json:"sensor_value"`
+	Values      map[string]float64 `json:"values"`
+	Timestamp   any                `json:"timestamp"`
+	Tags        map[string]string  `json:"tags"`
+}
+
+// parse returns the reading in a line. ok is false for lines without a reading:
+// empty lines and the CSV header.
+func (p *recordParser) parse(line []byte) (reading Reading, ok bool, err error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return Reading{}, false, nil
+	}
+
+	if p.format == FormatJSON {
+		var record jsonRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return Reading{}, false, err
+		}
+		if record.SensorValue == nil && len(record.Values) == 0 {
+			return Reading{}, false, fmt.Errorf("record has no sensor_value or values")
+		}
+
+		reading = Reading{SensorName: record.SensorName, Values: record.Values, Tags: record.Tags}
+		if record.SensorValue != nil {
+			reading.Value = *record.SensorValue
+		}
+		switch ts := record.Timestamp.(type) {
+		case nil:
+		case string:
+			if reading.Time, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+				return Reading{}, false, fmt.Errorf("timestamp: %w", err)
+			}
+		case float64:
+			reading.Time = epochTime(ts)
+		default:
+			return Reading{}, false, fmt.Errorf("timestamp must be a string or a number")
+		}
+	} else {
+		fields, err := csv.NewReader(bytes.NewReader(line)).Read()
+		if err != nil {
+			return Reading{}, false, err
+		}
+		if p.header == nil {
+			p.header = fields
+			return Reading{}, false, nil
+		}
+		if len(fields) != len(p.header) {
+			return Reading{}, false, fmt.Errorf("record has %d fields, the header %d", len(fields), len(p.header))
+		}
+
+		var hasValue bool
+		for i, field := range fields {
+			if field = strings.TrimSpace(field); field == "" {
+				continue
+			}
+			switch column := p.header[i]; column {
+			case "sensor_name":
+				reading.SensorName = field
+			case "timestamp":
+				if reading.Time, err = parseTime(field); err != nil {
+					return Reading{}, false, fmt.Errorf("timestamp: %w", err)
+				}
+			default:
+				value, err := strconv.ParseFloat(field, 64)
+				if err != nil {
+					return Reading{}, false, fmt.Errorf("%s: %w", column, err)
+				}
+				hasValue = true
+				if column == "sensor_value" {
+					reading.Value = value
+					continue
+				}
+				if reading.Values == nil {
+					reading.Values = make(map[string]float64)
+				}
+				reading.Values[column] = value
+			}
+		}
+		if !hasValue {
+			return Reading{}, false, fmt.Errorf("record has no values")
+		}
+	}
+
+	if reading.SensorName == "" {
+		reading.SensorName = p.defaultSensor
+	}
+	return reading, true, nil
+}
+
+// parseTime parses an RFC 3339 time or seconds since the epoch.
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return epochTime(seconds), nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+func epochTime(seconds float64) time.Time {
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
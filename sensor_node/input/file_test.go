@@ -0,0 +1,161 @@
+package input
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordParser(t *testing.T) {
+	taken := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		format  string
+		header  []string
+		line    string
+		want    Reading
+		wantOK  bool
+		wantErr string
+	}{
+		{"json value", FormatJSON, nil, `{"sensor_name":"boiler","sensor_value":71.5,"timestamp":"2026-10-17T12:00:00Z","tags":{"site":"b7"}}`,
+			Reading{SensorName: "boiler", Value: 71.5, Time: taken, Tags: map[string]string{"site": "b7"}}, true, ""},
+		{"json values with epoch", FormatJSON, nil, `{"values":{"voltage":230.5},"timestamp":1792238400}`,
+			Reading{SensorName: "logger", Values: map[string]float64{"voltage": 230.5}, Time: taken}, true, ""},
+		{"json without value", FormatJSON, nil, `{"sensor_name":"boiler"}`, Reading{}, false, "no sensor_value"},
+		{"json bad timestamp", FormatJSON, nil, `{"sensor_value":1,"timestamp":"yesterday"}`, Reading{}, false, "timestamp"},
+		{"invalid json", FormatJSON, nil, `{"sensor_value":`, Reading{}, false, "unexpected end"},
+		{"empty line", FormatJSON, nil, "  \n", Reading{}, false, ""},
+		{"csv header", FormatCSV, nil, "timestamp,sensor_name,sensor_value\n", Reading{}, false, ""},
+		{"csv value", FormatCSV, []string{"timestamp", "sensor_name", "sensor_value"}, "2026-10-17T12:00:00Z,boiler,71.5\n",
+			Reading{SensorName: "boiler", Value: 71.5, Time: taken}, true, ""},
+		{"csv values", FormatCSV, []string{"timestamp", "voltage", "current"}, "1792238400,230.5,\n",
+			Reading{SensorName: "logger", Values: map[string]float64{"voltage": 230.5}, Time: taken}, true, ""},
+		{"csv field count", FormatCSV, []string{"sensor_name", "sensor_value"}, "boiler\n", Reading{}, false, "fields"},
+		{"csv not a number", FormatCSV, []string{"sensor_value"}, "warm\n", Reading{}, false, "sensor_value"},
+		{"csv without value", FormatCSV, []string{"sensor_name", "sensor_value"}, "boiler,\n", Reading{}, false, "no values"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := recordParser{format: tt.format, defaultSensor: "logger", header: tt.header}
+			got, ok, err := p.parse([]byte(tt.line))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parse() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// pollUntil polls f until it returns n readings or an error, failing the test if
+// that takes too long.
+func pollUntil(t *testing.T, f *File, n int) ([]Reading, error) {
+	t.Helper()
+
+	var readings []Reading
+	deadline := time.Now().Add(5 * time.Second)
+	for len(readings) < n && time.Now().Before(deadline) {
+		got, err := f.Poll(context.Background())
+		readings = append(readings, got...)
+		if err != nil {
+			return readings, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(readings) < n {
+		t.Fatalf("polled %d readings, want %d", len(readings), n)
+	}
+	return readings, nil
+}
+
+func sensorNames(readings []Reading) []string {
+	var names []string
+	for _, r := range readings {
+		names = append(names, r.SensorName)
+	}
+	return names
+}
+
+func appendLines(t *testing.T, path string, lines string) {
+	t.Helper()
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(lines); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFile_Tail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logger.csv")
+	appendLines(t, path, "sensor_name,sensor_value\nold,1\n")
+
+	f, err := OpenFile(path, FormatCSV, "logger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Records written before opening are skipped, and a partial line waits for the
+	// rest of it.
+	appendLines(t, path, "a,2\nb,")
+	readings, err := pollUntil(t, f, 1)
+	if err != nil || !reflect.DeepEqual(sensorNames(readings), []string{"a"}) {
+		t.Fatalf("Poll() = %v, %v, want a", readings, err)
+	}
+	appendLines(t, path, "3\n")
+	if readings, _ := pollUntil(t, f, 1); !reflect.DeepEqual(readings, []Reading{{SensorName: "b", Value: 3}}) {
+		t.Errorf("Poll() = %v, want b=3", readings)
+	}
+
+	// After truncation the header is read again.
+	if err := os.WriteFile(path, []byte("sensor_value,sensor_name\n4,c\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if readings, _ := pollUntil(t, f, 1); !reflect.DeepEqual(readings, []Reading{{SensorName: "c", Value: 4}}) {
+		t.Errorf("Poll() after truncation = %v, want c=4", readings)
+	}
+
+	// After rotation the new file is read from its start.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path, "sensor_name,sensor_value\nd,5\n")
+	if readings, _ := pollUntil(t, f, 1); !reflect.DeepEqual(sensorNames(readings), []string{"d"}) {
+		t.Errorf("Poll() after rotation = %v, want d", readings)
+	}
+}
+
+func TestFile_Stdin(t *testing.T) {
+	f := newFile("-", FormatJSON, "logger")
+	defer f.Close()
+	long := `{"sensor_value":1,"tags":{"pad":"` + strings.Repeat("x", maxRecordSize) + `"}}`
+	go f.read(strings.NewReader(`{"sensor_value":1}`+"\n"+long+"\n"+"not json\n"+`{"sensor_name":"last","sensor_value":2}`), nil)
+
+	readings, err := pollUntil(t, f, 2)
+	if err == nil {
+		_, err = pollUntil(t, f, 0)
+	}
+	if err != io.EOF {
+		t.Errorf("Poll() error = %v, want io.EOF", err)
+	}
+	if !reflect.DeepEqual(sensorNames(readings), []string{"logger", "last"}) {
+		t.Errorf("Poll() = %v, want the valid records", readings)
+	}
+}
@@ -0,0 +1,29 @@
+// Package input reads values from the equipment a sensor node collects from, such
+// as a PLC or an existing logger, instead of generating random readings.
+package input
+
+import (
+	"context"
+	"time"
+)
+
+// Adapter polls a source of values for the readings to send. Adapters are safe for
+// concurrent use.
+type Adapter interface {
+	// Poll returns the readings available since the last poll. On a partial failure
+	// it returns the readings it has along with the error. Sources that end, such
+	// as stdin, return io.EOF once every reading has been returned.
+	Poll(ctx context.Context) ([]Reading, error)
+
+	Close() error
+}
+
+// Reading is what a source read for a sensor: a single value, or named values sent
+// together.
+type Reading struct {
+	SensorName string
+	Time       time.Time          // zero uses the time of the poll
+	Value      float64            // single value, unless Values is set
+	Values     map[string]float64 // by metric name
+	Tags       map[string]string  // added to the node's tags
+}
@@ -0,0 +1,439 @@
+package input
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// modbusTimeout bounds connecting to the device and each request, unless the
+	// context passed to Poll ends sooner.
+	modbusTimeout = 5 * time.Second
+
+	// maxRegisters and maxBits are the most a single read request may return.
+	maxRegisters = 125
+	maxBits      = 2000
+)
+
+// Modbus tables a register can be read from.
+const (
+	TableHolding  = "holding"
+	TableInput    = "input"
+	TableCoil     = "coil"
+	TableDiscrete = "discrete"
+)
+
+// readFunctions are the function codes reading each table.
+var readFunctions = map[string]byte{
+	TableCoil:     1,
+	TableDiscrete: 2,
+	TableHolding:  3,
+	TableInput:    4,
+}
+
+// typeWidths are the number of 16-bit registers each value type spans.
+var typeWidths = map[string]uint16{
+	"uint16":  1,
+	"int16":   1,
+	"uint32":  2,
+	"int32":   2,
+	"float32": 2,
+}
+
+// ModbusMapping maps values of a Modbus TCP device to sensor names.
+type ModbusMapping struct {
+	UnitID    uint8             `yaml:"unit_id"` // e.g. of a device behind a serial gateway, 0 uses 1
+	Registers []*ModbusRegister `yaml:"registers"`
+
+	requests []*modbusRequest
+}
+
+// ModbusRegister maps one value to a sensor.
+type ModbusRegister struct {
+	Sensor    string  `yaml:"sensor"`
+	Metric    string  `yaml:"metric"`     // empty sends the value as the sensor's single value
+	Table     string  `yaml:"table"`      // holding (default), input, coil or discrete
+	Address   uint16  `yaml:"address"`    // zero-based, as sent on the wire
+	Type      string  `yaml:"type"`       // uint16 (default), int16, uint32, int32 or float32
+	SwapWords bool    `yaml:"swap_words"` // 32-bit values with the low word first
+	Scale     float64 `yaml:"scale"`      // multiplies the raw value, 0 means 1
+}
+
+// width returns the number of registers, or bits for coils and discrete inputs, the
+// value spans.
+func (r *ModbusRegister) width() uint16 {
+	if r.Type == "" {
+		return 1
+	}
+	return typeWidths[r.Type]
+}
+
+// modbusRequest reads a contiguous range of a table holding one or more values.
+type modbusRequest struct {
+	function  byte
+	address   uint16
+	quantity  uint16
+	registers []*ModbusRegister
+}
+
+// bits reports whether the request reads coils or discrete inputs.
+func (req *modbusRequest) bits() bool {
+	return req.function == readFunctions[TableCoil] || req.function == readFunctions[TableDiscrete]
+}
+
+// dataSize returns the number of data bytes in the response to the request.
+func (req *modbusRequest) dataSize() int {
+	if req.bits() {
+		return (int(req.quantity) + 7) / 8
+	}
+	return int(req.quantity) * 2
+}
+
+// value decodes a register's value from the data read by the request.
+func (req *modbusRequest) value(r *ModbusRegister, data []byte) float64 {
+	offset := int(r.Address - req.address)
+
+	var raw float64
+	switch {
+	case req.bits():
+		if data[offset/8]&(1<<(offset%8)) != 0 {
+			raw = 1
+		}
+	case r.Type == "int16":
+		raw = float64(int16(binary.BigEndian.Uint16(data[offset*2:])))
+	case r.Type == "uint16" || r.Type == "":
+		raw = float64(binary.BigEndian.Uint16(data[offset*2:]))
+	default:
+		hi, lo := binary.BigEndian.Uint16(data[offset*2:]), binary.BigEndian.Uint16(data[offset*2+2:])
+		if r.SwapWords {
+			hi, lo = lo, hi
+		}
+		v := uint32(hi)<<16 | uint32(lo)
+		switch r.Type {
+		case "uint32":
+			raw = float64(v)
+		case "int32":
+			raw = float64(int32(v))
+		case "float32":
+			raw = float64(math.Float32frombits(v))
+		}
+	}
+	return raw * r.Scale
+}
+
+// LoadModbusMapping reads and checks a YAML mapping file.
+func LoadModbusMapping(path string) (*ModbusMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read mapping file: %w", err)
+	}
+
+	return parseModbusMapping(data)
+}
+
+func parseModbusMapping(data []byte) (*ModbusMapping, error) {
+	var mapping ModbusMapping
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	// A misspelled key would silently read address 0 or an unscaled value.
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&mapping); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("parse mapping file: %w", err)
+	}
+
+	if err := mapping.compile(); err != nil {
+		return nil, err
+	}
+
+	return &mapping, nil
+}
+
+func (m *ModbusMapping) compile() error {
+	if len(m.Registers) == 0 {
+		return fmt.Errorf("at least one register is required")
+	}
+	if m.UnitID == 0 {
+		m.UnitID = 1
+	}
+
+	type key struct{ sensor, metric string }
+	mapped := make(map[key]bool)
+	withMetrics := make(map[string]bool)
+	for i, r := range m.Registers {
+		if r == nil || r.Sensor == "" {
+			return fmt.Errorf("register %d: sensor is required", i+1)
+		}
+		if r.Table == "" {
+			r.Table = TableHolding
+		}
+		if _, ok := readFunctions[r.Table]; !ok {
+			return fmt.Errorf("register %d: unknown table %q", i+1, r.Table)
+		}
+		if r.Table == TableCoil || r.Table == TableDiscrete {
+			if r.Type != "" || r.SwapWords {
+				return fmt.Errorf("register %d: type and swap_words apply only to holding and input registers", i+1)
+			}
+		} else {
+			if r.Type == "" {
+				r.Type = "uint16"
+			}
+			if _, ok := typeWidths[r.Type]; !ok {
+				return fmt.Errorf("register %d: unknown type %q", i+1, r.Type)
+			}
+			if r.SwapWords && r.width() != 2 {
+				return fmt.Errorf("register %d: swap_words applies only to 32-bit types", i+1)
+			}
+		}
+		if int(r.Address)+int(r.width()) > math.MaxUint16+1 {
+			return fmt.Errorf("register %d: %s extends past address %d", i+1, r.Type, math.MaxUint16)
+		}
+		if math.IsNaN(r.Scale) || math.IsInf(r.Scale, 0) {
+			return fmt.Errorf("register %d: scale must be finite", i+1)
+		}
+		if r.Scale == 0 {
+			r.Scale = 1
+		}
+
+		k := key{r.Sensor, r.Metric}
+		if mapped[k] {
+			return fmt.Errorf("register %d: %s is mapped twice", i+1, describeValue(r))
+		}
+		mapped[k] = true
+		if seen, ok := withMetrics[r.Sensor]; ok && seen != (r.Metric != "") {
+			return fmt.Errorf("register %d: sensor %s can't have both a single value and metrics", i+1, r.Sensor)
+		}
+		withMetrics[r.Sensor] = r.Metric != ""
+	}
+
+	m.plan()
+	return nil
+}
+
+// describeValue names a register's value in errors.
+func describeValue(r *ModbusRegister) string {
+	if r.Metric == "" {
+		return "sensor " + r.Sensor
+	}
+	return fmt.Sprintf("metric %s of sensor %s", r.Metric, r.Sensor)
+}
+
+// plan groups the registers into as few requests as possible. Only contiguous or
+// overlapping registers share a request, as reading the gaps between them may fail
+// on devices that don't have those addresses.
+func (m *ModbusMapping) plan() {
+	registers := slices.Clone(m.Registers)
+	slices.SortStableFunc(registers, func(a, b *ModbusRegister) int {
+		return cmp.Or(cmp.Compare(readFunctions[a.Table], readFunctions[b.Table]), cmp.Compare(a.Address, b.Address))
+	})
+
+	m.requests = nil
+	var current *modbusRequest
+	for _, r := range registers {
+		function := readFunctions[r.Table]
+		start, end := int(r.Address), int(r.Address)+int(r.width())
+		if current != nil && current.function == function {
+			currentEnd := int(current.address) + int(current.quantity)
+			limit := maxRegisters
+			if current.bits() {
+				limit = maxBits
+			}
+			if start <= currentEnd && max(end, currentEnd)-int(current.address) <= limit {
+				current.quantity = uint16(max(end, currentEnd) - int(current.address))
+				current.registers = append(current.registers, r)
+				continue
+			}
+		}
+
+		current = &modbusRequest{
+			function:  function,
+			address:   r.Address,
+			quantity:  r.width(),
+			registers: []*ModbusRegister{r},
+		}
+		m.requests = append(m.requests, current)
+	}
+}
+
+// ModbusException is an exception response from the device, e.g. for an address it
+// doesn't have. The connection remains usable.
+type ModbusException struct {
+	Function byte
+	Code     byte
+}
+
+var exceptionNames = map[byte]string{
+	1:  "illegal function",
+	2:  "illegal data address",
+	3:  "illegal data value",
+	4:  "server device failure",
+	5:  "acknowledge",
+	6:  "server device busy",
+	10: "gateway path unavailable",
+	11: "gateway target device failed to respond",
+}
+
+func (e *ModbusException) Error() string {
+	name, ok := exceptionNames[e.Code]
+	if !ok {
+		name = "unknown exception"
+	}
+	return fmt.Sprintf("modbus exception %d (%s) for function %d", e.Code, name, e.Function)
+}
+
+// Modbus polls the values of a ModbusMapping from a Modbus TCP device. It connects
+// on the first poll and reconnects on the next poll after the connection fails.
+type Modbus struct {
+	addr    string
+	mapping *ModbusMapping
+
+	mu            sync.Mutex
+	conn          net.Conn // nil until connected
+	transactionID uint16
+}
+
+// NewModbus creates an adapter polling the device at addr (host:port).
+func NewModbus(addr string, mapping *ModbusMapping) *Modbus {
+	return &Modbus{addr: addr, mapping: mapping}
+}
+
+// Poll reads every mapped value once and returns a reading per sensor. Values the
+// device answers with an exception for are skipped; after a connection failure the
+// remaining values are.
+func (m *Modbus) Poll(ctx context.Context) ([]Reading, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var readings []Reading
+	bySensor := make(map[string]int) // index in readings
+	var errs []error
+	for _, req := range m.mapping.requests {
+		data, err := m.read(ctx, req)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("function %d at address %d, quantity %d: %w", req.function, req.address, req.quantity, err))
+			var exception *ModbusException
+			if errors.As(err, &exception) {
+				continue
+			}
+			break
+		}
+
+		for _, r := range req.registers {
+			i, ok := bySensor[r.Sensor]
+			if !ok {
+				i = len(readings)
+				bySensor[r.Sensor] = i
+				readings = append(readings, Reading{SensorName: r.Sensor})
+			}
+
+			value := req.value(r, data)
+			if r.Metric == "" {
+				readings[i].Value = value
+				continue
+			}
+			if readings[i].Values == nil {
+				readings[i].Values = make(map[string]float64)
+			}
+			readings[i].Values[r.Metric] = value
+		}
+	}
+
+	return readings, errors.Join(errs...)
+}
+
+// read sends a request and returns the data of the response, connecting first if
+// needed. A connection that fails is closed, as it may hold part of a response.
+func (m *Modbus) read(ctx context.Context, req *modbusRequest) ([]byte, error) {
+	if m.conn == nil {
+		dialer := net.Dialer{Timeout: modbusTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+		if err != nil {
+			return nil, err
+		}
+		m.conn = conn
+	}
+
+	data, err := m.exchange(ctx, req)
+	if err != nil {
+		var exception *ModbusException
+		if !errors.As(err, &exception) {
+			m.conn.Close()
+			m.conn = nil
+		}
+	}
+	return data, err
+}
+
+func (m *Modbus) exchange(ctx context.Context, req *modbusRequest) ([]byte, error) {
+	deadline := time.Now().Add(modbusTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	m.conn.SetDeadline(deadline)
+
+	// MBAP header (transaction ID, protocol 0, length of the rest, unit ID) followed
+	// by the PDU: function code, starting address and quantity.
+	m.transactionID++
+	var request [12]byte
+	binary.BigEndian.PutUint16(request[0:], m.transactionID)
+	binary.BigEndian.PutUint16(request[4:], 6)
+	request[6] = m.mapping.UnitID
+	request[7] = req.function
+	binary.BigEndian.PutUint16(request[8:], req.address)
+	binary.BigEndian.PutUint16(request[10:], req.quantity)
+	if _, err := m.conn.Write(request[:]); err != nil {
+		return nil, err
+	}
+
+	var header [7]byte
+	if _, err := io.ReadFull(m.conn, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint16(header[4:])
+	switch {
+	case binary.BigEndian.Uint16(header[0:]) != m.transactionID:
+		return nil, fmt.Errorf("response to transaction %d, want %d", binary.BigEndian.Uint16(header[0:]), m.transactionID)
+	case binary.BigEndian.Uint16(header[2:]) != 0:
+		return nil, fmt.Errorf("response with protocol %d, want 0", binary.BigEndian.Uint16(header[2:]))
+	case length < 3 || length > 254:
+		return nil, fmt.Errorf("response with invalid length %d", length)
+	}
+
+	pdu := make([]byte, length-1)
+	if _, err := io.ReadFull(m.conn, pdu); err != nil {
+		return nil, err
+	}
+	switch {
+	case pdu[0] == req.function|0x80:
+		return nil, &ModbusException{Function: req.function, Code: pdu[1]}
+	case pdu[0] != req.function:
+		return nil, fmt.Errorf("response for function %d, want %d", pdu[0], req.function)
+	case int(pdu[1]) != req.dataSize() || len(pdu) != 2+req.dataSize():
+		return nil, fmt.Errorf("response with %d data bytes, want %d", len(pdu)-2, req.dataSize())
+	}
+	return pdu[2:], nil
+}
+
+// Close closes the connection to the device.
+func (m *Modbus) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn == nil {
+		return nil
+	}
+	err := m.conn.Close()
+	m.conn = nil
+	return err
+}
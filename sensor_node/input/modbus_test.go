@@ -0,0 +1,212 @@
+package input
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseModbusMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"single values", "registers:\n- {sensor: boiler-temp, address: 100, type: int16, scale: 0.1}\n- {sensor: pump-on, table: coil, address: 3}\n", ""},
+		{"metrics", "registers:\n- {sensor: meter, metric: voltage, type: float32}\n- {sensor: meter, metric: current, address: 2, type: float32, swap_words: true}\n", ""},
+		{"no registers", "unit_id: 3\n", "at least one register"},
+		{"empty file", "", "at least one register"},
+		{"missing sensor", "registers:\n- {address: 1}\n", "sensor is required"},
+		{"misspelled key", "registers:\n- {sensor: a, adress: 1}\n", "adress"},
+		{"unknown table", "registers:\n- {sensor: a, table: eeprom}\n", "unknown table"},
+		{"unknown type", "registers:\n- {sensor: a, type: float64}\n", "unknown type"},
+		{"typed coil", "registers:\n- {sensor: a, table: coil, type: uint16}\n", "only to holding and input"},
+		{"swapped 16-bit", "registers:\n- {sensor: a, swap_words: true}\n", "32-bit"},
+		{"past last address", "registers:\n- {sensor: a, address: 65535, type: uint32}\n", "extends past"},
+		{"mapped twice", "registers:\n- {sensor: a, address: 1}\n- {sensor: a, address: 2}\n", "mapped twice"},
+		{"single value and metrics", "registers:\n- {sensor: a, address: 1}\n- {sensor: a, metric: m, address: 2}\n", "both"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseModbusMapping([]byte(tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("parseModbusMapping() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseModbusMapping() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestModbusMapping_Plan(t *testing.T) {
+	mapping, err := parseModbusMapping([]byte(`
+registers:
+- {sensor: a, address: 10, type: uint32}
+- {sensor: b, address: 12}
+- {sensor: c, address: 20}
+- {sensor: d, table: input, address: 12}
+- {sensor: e, table: coil, address: 0}
+- {sensor: f, table: coil, address: 1999}
+- {sensor: g, table: coil, address: 2000}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type request struct {
+		function          byte
+		address, quantity uint16
+		registers         int
+	}
+	var got []request
+	for _, req := range mapping.requests {
+		got = append(got, request{req.function, req.address, req.quantity, len(req.registers)})
+	}
+	want := []request{
+		{1, 0, 1, 1},    // coils 0 and 1999 aren't contiguous
+		{1, 1999, 2, 2}, // adjacent coils share a request
+		{3, 10, 3, 2},   // a 32-bit value followed by the next register
+		{3, 20, 1, 1},   // a gap starts a new request
+		{4, 12, 1, 1},   // other tables are read separately
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+// fakeDevice serves holding registers and coils over Modbus TCP until the test ends.
+// Reads past the registers it has get an illegal data address exception.
+func fakeDevice(t *testing.T, holding []uint16, coils []bool) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var req [12]byte
+					if _, err := io.ReadFull(conn, req[:]); err != nil {
+						return
+					}
+					function := req[7]
+					address, quantity := int(binary.BigEndian.Uint16(req[8:])), int(binary.BigEndian.Uint16(req[10:]))
+
+					var pdu []byte
+					switch {
+					case function == 3 && address+quantity <= len(holding):
+						pdu = []byte{function, byte(quantity * 2)}
+						for _, v := range holding[address : address+quantity] {
+							pdu = binary.BigEndian.AppendUint16(pdu, v)
+						}
+					case function == 1 && address+quantity <= len(coils):
+						pdu = []byte{function, byte((quantity + 7) / 8)}
+						pdu = append(pdu, make([]byte, (quantity+7)/8)...)
+						for i, on := range coils[address : address+quantity] {
+							if on {
+								pdu[2+i/8] |= 1 << (i % 8)
+							}
+						}
+					default:
+						pdu = []byte{function | 0x80, 2}
+					}
+
+					resp := append([]byte(nil), req[:4]...)
+					resp = binary.BigEndian.AppendUint16(resp, uint16(len(pdu)+1))
+					resp = append(resp, req[6])
+					if _, err := conn.Write(append(resp, pdu...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return lis.Addr().String()
+}
+
+func TestModbus_Poll(t *testing.T) {
+	f32 := math.Float32bits(230.5)
+	holding := []uint16{
+		0xff38, // -200
+		uint16(f32 >> 16), uint16(f32),
+		uint16(f32), uint16(f32 >> 16), // low word first
+	}
+	addr := fakeDevice(t, holding, []bool{false, false, true})
+
+	mapping, err := parseModbusMapping([]byte(`
+registers:
+- {sensor: boiler, address: 0, type: int16, scale: 0.5}
+- {sensor: meter, metric: voltage, address: 1, type: float32}
+- {sensor: meter, metric: voltage-swapped, address: 3, type: float32, swap_words: true}
+- {sensor: pump, table: coil, address: 2}
+- {sensor: missing, address: 40}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewModbus(addr, mapping)
+	defer m.Close()
+
+	for range 2 {
+		readings, err := m.Poll(context.Background())
+
+		var exception *ModbusException
+		if !errors.As(err, &exception) || exception.Code != 2 {
+			t.Errorf("Poll() error = %v, want an illegal data address exception", err)
+		}
+		want := []Reading{
+			{SensorName: "pump", Value: 1},
+			{SensorName: "boiler", Value: -100},
+			{SensorName: "meter", Values: map[string]float64{"voltage": 230.5, "voltage-swapped": 230.5}},
+		}
+		if !reflect.DeepEqual(readings, want) {
+			t.Errorf("Poll() = %v, want %v", readings, want)
+		}
+	}
+}
+
+func TestModbus_PollReconnects(t *testing.T) {
+	mapping, err := parseModbusMapping([]byte("registers:\n- {sensor: a, address: 0}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on the address yet.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	m := NewModbus(addr, mapping)
+	defer m.Close()
+	if readings, err := m.Poll(context.Background()); err == nil || len(readings) != 0 {
+		t.Fatalf("Poll() = %v, %v, want a connection error", readings, err)
+	}
+
+	m.addr = fakeDevice(t, []uint16{7}, nil)
+	readings, err := m.Poll(context.Background())
+	if err != nil {
+		t.Fatalf("Poll() error = %v", err)
+	}
+	if want := []Reading{{SensorName: "a", Value: 7}}; !reflect.DeepEqual(readings, want) {
+		t.Errorf("Poll() = %v, want %v", readings, want)
+	}
+}
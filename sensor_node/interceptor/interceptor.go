@@ -0,0 +1,272 @@
+// Package interceptor provides the client interceptors applied to every call the
+// node makes to the sink, so metadata, request IDs, trace context, auth tokens,
+// redirects, quota reports and call metrics don't depend on each call site handling them.
+package interceptor
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sensor_node/proto"
+)
+
+const (
+	// RequestIDKey is the metadata key of the ID stamped on every call.
+	RequestIDKey = "x-request-id"
+	// TraceParentKey is the metadata key of the W3C trace context.
+	TraceParentKey = "traceparent"
+	// AuthorizationKey is the metadata key of the bearer token.
+	AuthorizationKey = "authorization"
+)
+
+// Trailer keys of the quota a sink reports left after a call.
+const (
+	QuotaLimitKey     = "x-quota-limit-bytes"
+	QuotaRemainingKey = "x-quota-remaining-bytes"
+	QuotaResetKey     = "x-quota-reset-ms"
+)
+
+// Metadata returns an interceptor adding the key/value pairs to every call.
+func Metadata(kv ...string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(metadata.AppendToOutgoingContext(ctx, kv...), method, req, reply, cc, opts...)
+	}
+}
+
+// RequestID returns an interceptor stamping every call with a random UUID, unless
+// the caller set one, so a call can be found in the logs of the node, proxies and
+// sink. Retries are separate calls with IDs of their own unless the caller sets one.
+func RequestID() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get(RequestIDKey)) == 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, RequestIDKey, uuid.NewString())
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context starting a trace, so the calls made with it, such as
+// the retries of a reading, share a trace ID.
+func WithTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, traceKey{}, randomHex(16))
+}
+
+// Trace returns an interceptor propagating the W3C trace context: every call is a
+// span of the context's trace, or of a trace of its own without one.
+func Trace() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		traceID, ok := ctx.Value(traceKey{}).(string)
+		if !ok {
+			traceID = randomHex(16)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, TraceParentKey, "00-"+traceID+"-"+randomHex(8)+"-01")
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Redirect returns an interceptor passing follow the endpoints of a Redirect the
+// sink sent in a response or as a detail of an error.
+func Redirect(follow func(endpoints []string)) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if redirect := redirectOf(reply, err); len(redirect.GetEndpoints()) > 0 {
+			follow(redirect.Endpoints)
+		}
+		return err
+	}
+}
+
+func redirectOf(reply any, err error) *pb.Redirect {
+	if err != nil {
+		for _, detail := range status.Convert(err).Details() {
+			if redirect, ok := detail.(*pb.Redirect); ok {
+				return redirect
+			}
+		}
+		return nil
+	}
+	if resp, ok := reply.(interface{ GetRedirect() *pb.Redirect }); ok {
+		return resp.GetRedirect()
+	}
+	return nil
+}
+
+// Quota is what a sink reported is left of the tightest quota applying to a call's
+// readings.
+type Quota struct {
+	Limit     int           // bytes per second
+	Remaining int           // bytes that may be sent now
+	Reset     time.Duration // until the quota is full again
+}
+
+// ReportQuota returns an interceptor passing report the quota a sink sent in the
+// trailers of a call. Sinks that don't report quotas send none.
+func ReportQuota(report func(Quota)) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var trailer metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+		if quota, ok := quotaOf(trailer); ok {
+			report(quota)
+		}
+		return err
+	}
+}
+
+func quotaOf(trailer metadata.MD) (Quota, bool) {
+	value := func(key string) (int, bool) {
+		values := trailer.Get(key)
+		if len(values) != 1 {
+			return 0, false
+		}
+		n, err := strconv.Atoi(values[0])
+		return n, err == nil && n >= 0
+	}
+	limit, ok1 := value(QuotaLimitKey)
+	remaining, ok2 := value(QuotaRemainingKey)
+	reset, ok3 := value(QuotaResetKey)
+	if !ok1 || !ok2 || !ok3 || limit == 0 {
+		return Quota{}, false
+	}
+	return Quota{Limit: limit, Remaining: remaining, Reset: time.Duration(reset) * time.Millisecond}, true
+}
+
+// BearerToken returns an interceptor sending the token in path as a bearer token.
+// The file is read again once it changes, so tokens can be rotated without a
+// restart. Calls fail with Unauthenticated while it can't be read.
+func BearerToken(path string) grpc.UnaryClientInterceptor {
+	t := &tokenFile{path: path}
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		token, err := t.token()
+		if err != nil {
+			return status.Errorf(codes.Unauthenticated, "auth token: %v", err)
+		}
+		return invoker(metadata.AppendToOutgoingContext(ctx, AuthorizationKey, "Bearer "+token), method, req, reply, cc, opts...)
+	}
+}
+
+// LoadToken reads the token in path, which must not be empty.
+func LoadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := string(bytes.TrimSpace(data))
+	if token == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return token, nil
+}
+
+// tokenFile caches the token of a file until its modification time changes.
+type tokenFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	value   string
+}
+
+func (t *tokenFile) token() (string, error) {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return "", err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.value != "" && info.ModTime().Equal(t.modTime) {
+		return t.value, nil
+	}
+	value, err := LoadToken(t.path)
+	if err != nil {
+		return "", err
+	}
+	t.value, t.modTime = value, info.ModTime()
+	return value, nil
+}
+
+// Metrics counts the calls of each method, their failures by code and their
+// latency.
+type Metrics struct {
+	mu      sync.Mutex
+	methods map[string]*MethodStats
+}
+
+// MethodStats is a snapshot of the calls of a method.
+type MethodStats struct {
+	Method  string // short name, e.g. SendSensorData
+	Calls   uint64
+	Failed  map[codes.Code]uint64
+	Latency time.Duration // total of every call
+}
+
+// NewMetrics creates empty call metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{methods: make(map[string]*MethodStats)}
+}
+
+// Interceptor returns the interceptor recording calls in m.
+func (m *Metrics) Interceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		m.record(method[strings.LastIndexByte(method, '/')+1:], time.Since(start), err)
+		return err
+	}
+}
+
+func (m *Metrics) record(method string, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, ok := m.methods[method]
+	if !ok {
+		stats = &MethodStats{Method: method, Failed: make(map[codes.Code]uint64)}
+		m.methods[method] = stats
+	}
+	stats.Calls++
+	stats.Latency += latency
+	if err != nil {
+		stats.Failed[status.Code(err)]++
+	}
+}
+
+// Snapshot returns the stats of every method called, by method name.
+func (m *Metrics) Snapshot() []MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := make([]MethodStats, 0, len(m.methods))
+	for _, stats := range m.methods {
+		s := *stats
+		s.Failed = maps.Clone(stats.Failed)
+		snapshot = append(snapshot, s)
+	}
+	slices.SortFunc(snapshot, func(a, b MethodStats) int { return strings.Compare(a.Method, b.Method) })
+	return snapshot
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,195 @@
+package interceptor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sensor_node/proto"
+)
+
+const method = "/telemetry.TelemetryService/SendSensorData"
+
+// call runs interceptor around an invoker returning err and returns the metadata
+// the invoker saw.
+func call(t *testing.T, ctx context.Context, interceptor grpc.UnaryClientInterceptor, err error) (metadata.MD, error) {
+	t.Helper()
+	var md metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return err
+	}
+	return md, interceptor(ctx, method, nil, nil, nil, invoker)
+}
+
+func TestMetadata(t *testing.T) {
+	md, _ := call(t, context.Background(), Metadata("x-tenant-id", "acme"), nil)
+	if got := md.Get("x-tenant-id"); len(got) != 1 || got[0] != "acme" {
+		t.Errorf("x-tenant-id = %v, want [acme]", got)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	first, _ := call(t, context.Background(), RequestID(), nil)
+	second, _ := call(t, context.Background(), RequestID(), nil)
+	if len(first.Get(RequestIDKey)) != 1 || first.Get(RequestIDKey)[0] == second.Get(RequestIDKey)[0] {
+		t.Errorf("request IDs = %v and %v, want one distinct ID per call", first.Get(RequestIDKey), second.Get(RequestIDKey))
+	}
+	if _, err := uuid.Parse(first.Get(RequestIDKey)[0]); err != nil {
+		t.Errorf("request ID %q isn't a UUID: %v", first.Get(RequestIDKey)[0], err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), RequestIDKey, "given")
+	md, _ := call(t, ctx, RequestID(), nil)
+	if got := md.Get(RequestIDKey); len(got) != 1 || got[0] != "given" {
+		t.Errorf("request ID = %v, want the caller's", got)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	traceparent := regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-01$`)
+	parse := func(md metadata.MD) (trace, span string) {
+		t.Helper()
+		values := md.Get(TraceParentKey)
+		if len(values) != 1 {
+			t.Fatalf("traceparent = %v, want one", values)
+		}
+		m := traceparent.FindStringSubmatch(values[0])
+		if m == nil {
+			t.Fatalf("traceparent = %q, want a W3C trace context", values[0])
+		}
+		return m[1], m[2]
+	}
+
+	ctx := WithTrace(context.Background())
+	first, _ := call(t, ctx, Trace(), nil)
+	retry, _ := call(t, ctx, Trace(), nil)
+	other, _ := call(t, context.Background(), Trace(), nil)
+
+	trace1, span1 := parse(first)
+	trace2, span2 := parse(retry)
+	trace3, _ := parse(other)
+	if trace1 != trace2 || span1 == span2 {
+		t.Errorf("calls in a trace = %s/%s and %s/%s, want the same trace and new spans", trace1, span1, trace2, span2)
+	}
+	if trace3 == trace1 {
+		t.Errorf("call without a trace is in trace %s, want a new one", trace3)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	interceptor := BearerToken(path)
+
+	md, _ := call(t, context.Background(), interceptor, nil)
+	if got := md.Get(AuthorizationKey); len(got) != 1 || got[0] != "Bearer first" {
+		t.Errorf("authorization = %v, want [Bearer first]", got)
+	}
+
+	// Rotate the token; the new modification time makes it read again.
+	if err := os.WriteFile(path, []byte("second"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	md, _ = call(t, context.Background(), interceptor, nil)
+	if got := md.Get(AuthorizationKey); len(got) != 1 || got[0] != "Bearer second" {
+		t.Errorf("authorization after rotation = %v, want [Bearer second]", got)
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(t, context.Background(), interceptor, nil); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("call without a token file error = %v, want Unauthenticated", err)
+	}
+}
+
+func TestLoadToken_Empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(" \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadToken(path); err == nil {
+		t.Error("LoadToken() error = nil, want an error for an empty file")
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	var followed [][]string
+	interceptor := Redirect(func(endpoints []string) { followed = append(followed, endpoints) })
+	invoke := func(reply any, err error) {
+		t.Helper()
+		invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			return err
+		}
+		interceptor(context.Background(), method, nil, reply, nil, invoker)
+	}
+
+	invoke(&pb.SensorDataResponse{}, nil)
+	invoke(&pb.HeartbeatResponse{Redirect: &pb.Redirect{Endpoints: []string{"sink-b:9090"}}}, nil)
+	st, _ := status.New(codes.Unavailable, "standby sink").WithDetails(&pb.Redirect{Endpoints: []string{"sink-a:9090"}})
+	invoke(&pb.SensorDataResponse{}, st.Err())
+	invoke(&pb.SensorDataResponse{}, status.Error(codes.Unavailable, "sink down"))
+
+	if len(followed) != 2 || followed[0][0] != "sink-b:9090" || followed[1][0] != "sink-a:9090" {
+		t.Errorf("followed %v, want the redirects of the response and the error", followed)
+	}
+}
+
+func TestReportQuota(t *testing.T) {
+	var reported []Quota
+	interceptor := ReportQuota(func(q Quota) { reported = append(reported, q) })
+	invoke := func(kv ...string) {
+		t.Helper()
+		invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			for _, opt := range opts {
+				if trailer, ok := opt.(grpc.TrailerCallOption); ok {
+					*trailer.TrailerAddr = metadata.Pairs(kv...)
+				}
+			}
+			return nil
+		}
+		interceptor(context.Background(), method, nil, nil, nil, invoker)
+	}
+
+	invoke(QuotaLimitKey, "1000", QuotaRemainingKey, "250", QuotaResetKey, "750", "x-quota-remaining-messages", "5")
+	invoke()
+	invoke(QuotaLimitKey, "1000", QuotaRemainingKey, "many", QuotaResetKey, "750")
+
+	want := Quota{Limit: 1000, Remaining: 250, Reset: 750 * time.Millisecond}
+	if len(reported) != 1 || reported[0] != want {
+		t.Errorf("reported %+v, want only %+v", reported, want)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	interceptor := metrics.Interceptor()
+	call(t, context.Background(), interceptor, nil)
+	call(t, context.Background(), interceptor, status.Error(codes.Unavailable, "sink down"))
+	call(t, context.Background(), interceptor, status.Error(codes.Unavailable, "sink down"))
+
+	snapshot := metrics.Snapshot()
+	if len(snapshot) != 1 {
+		t.Fatalf("Snapshot() = %+v, want one method", snapshot)
+	}
+	stats := snapshot[0]
+	if stats.Method != "SendSensorData" || stats.Calls != 3 || stats.Failed[codes.Unavailable] != 2 || len(stats.Failed) != 1 {
+		t.Errorf("Snapshot() = %+v, want 3 calls of SendSensorData, 2 Unavailable", stats)
+	}
+}
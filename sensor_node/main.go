@@ -1,34 +1,94 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"math"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/sensor_node/clock"
+	"github.com/sensor_node/discovery"
+	"github.com/sensor_node/fault"
+	"github.com/sensor_node/hostmetrics"
+	"github.com/sensor_node/input"
+	"github.com/sensor_node/interceptor"
+	"github.com/sensor_node/mesh"
+	"github.com/sensor_node/pacer"
+	"github.com/sensor_node/pool"
 	pb "github.com/sensor_node/proto"
+	"github.com/sensor_node/seal"
+	"github.com/sensor_node/sequence"
+	"github.com/sensor_node/slo"
+	"github.com/sensor_node/spool"
 )
 
 const (
-	serverName = "localhost"
-	maxRetries = 5
-	baseDelay  = 100 * time.Millisecond
-	maxDelay   = 10 * time.Second
+	// Defaults of the retry policy.
+	defaultMaxRetries = 5
+	defaultBaseDelay  = 100 * time.Millisecond
+	defaultMaxDelay   = 10 * time.Second
+
+	// tenantMetadataKey is the gRPC metadata key the sink uses for per-tenant quotas.
+	tenantMetadataKey = "x-tenant-id"
+
+	// defaultSendTimeout and defaultConnectTimeout apply when -send-timeout and
+	// -connect-timeout are unset, as in tests and embedders.
+	defaultSendTimeout    = 5 * time.Second
+	defaultConnectTimeout = 20 * time.Second
+
+	// messageTooLarge is part of the status message gRPC returns for a message over
+	// the server's receive size limit.
+	messageTooLarge = "larger than max"
+
+	// defaultHeartbeatInterval applies when the sink doesn't suggest an interval.
+	defaultHeartbeatInterval = 30 * time.Second
+
+	// maxBatchSize is the most readings the sink accepts in one batch.
+	maxBatchSize = 1000
+
+	// maxThrottle caps the pause a sink may ask for before the next reading.
+	maxThrottle = time.Minute
+
+	// clockOffsetWarning is how far the node's clock may be from the sink's before
+	// it is logged, as reading timestamps are off by as much.
+	clockOffsetWarning = time.Second
+
+	// hostSensorSuffix is appended to the sensor name for readings of the host's
+	// health.
+	hostSensorSuffix = "-host"
 )
 
 // Config holds the configuration for the sensor node
@@ -36,27 +96,219 @@ type Config struct {
 	Rate       float64
 	SensorName string
 	SinkAddr   string
+	Tenant     string
+
+	// File of the bearer token sent with every call, empty sends none
+	AuthTokenFile string
+
+	// Interval between lookups of a dns:/// sink address
+	DNSRefresh time.Duration
+
+	// Reconnect to the sink addresses a sink redirects the node to; the control plane
+	// places xds:/// sink addresses instead
+	FollowRedirects bool
+
+	// Reading metadata
+	Critical bool
+	Tags     map[string]string
+	Unit     string // of the values, for the sink to convert them to its canonical units
+
+	// Send every reading once, without retries, and let the sink store it without
+	// checking for duplicates or waiting for a stalled writer
+	FireAndForget bool
+
+	// Names of the values sent together in each reading, empty sends a single value
+	Metrics []string
+
+	// Position reported with each reading: latitude, longitude and optionally
+	// altitude; empty sends none
+	Location []float64
+
+	// Samples aggregated into each reading, 0 sends a single value
+	AggregateSamples int
+	HistogramBuckets []float64 // upper bounds, empty sends a count/sum summary
+
+	// Failures injected into generated readings
+	Faults fault.Faults
+
+	// Seed of the random sources of generated values, jitter and faults; 0 seeds
+	// them randomly
+	Seed int64
+
+	// File attached to every AttachmentEvery-th reading, empty sends no attachments
+	AttachmentFile  string
+	AttachmentEvery int
+
+	// Source of readings: empty generates random values, "modbus" polls the values
+	// mapped in InputMapping from the Modbus TCP device at ModbusAddr, "file" reads
+	// InputFormat records from InputFile ("-" for stdin) and "exec" runs
+	// InputCommand, every PollInterval
+	Input        string
+	InputMapping string
+	ModbusAddr   string
+	InputFile    string
+	InputFormat  string
+	InputCommand string
+	PollInterval time.Duration
+	PollTimeout  time.Duration
+
+	// Readings of the host's CPU, memory, disk and temperature, sent every
+	// HostMetricsInterval
+	ReportHostMetrics   bool
+	HostMetricsInterval time.Duration
+
+	// Registration and liveness
+	Metadata          map[string]string
+	HeartbeatInterval time.Duration // 0 uses the interval suggested by the sink
+
+	// Fleet start behaviour
+	StartJitter time.Duration
+	RampUp      time.Duration
+
+	// Connection pool and concurrency
+	Connections int
+	MaxInFlight int
+
+	// Deadline of every call to the sink, and of every attempt to connect to it
+	SendTimeout    time.Duration
+	ConnectTimeout time.Duration
+	// Time after the first attempt to send a reading after which it is dropped
+	// rather than retried, 0 retries until MaxRetries attempts failed
+	DeliveryDeadline time.Duration
+
+	// Retry policy: attempts per reading, 0 retries until it is delivered, with
+	// exponential backoff from RetryBaseDelay up to RetryMaxDelay, varied by up to
+	// RetryJitter of it either way. RetryCodes are the gRPC codes retried, nil
+	// retries all but those of invalid or unauthorized requests.
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	RetryJitter    float64
+	RetryCodes     []codes.Code
+
+	// Directory spooling the readings not delivered when their attempts run out or
+	// their deadline passes, to resend until the sink accepts them; empty drops
+	// them. At most SpoolMaxBytes are spooled.
+	SpoolDir      string
+	SpoolMaxBytes int64
+
+	// Readings per SendSensorDataBatch call, 1 sends each reading on its own
+	BatchSize int
+
+	// Delivery success rate: below MinSuccessRate over healthWindow the node is
+	// unhealthy, 0 disables. Rates are logged every DeliveryReportInterval, 0
+	// disables the summary, and served with the node's health on MetricsAddr.
+	MinSuccessRate         float64
+	DeliveryReportInterval time.Duration
+	MetricsAddr            string
+
+	// Outbound limits, 0 disables
+	MaxMsgsPerSec  float64
+	MaxBytesPerSec float64
+
+	// Share of the quota a sink reports, from 0 to 1, below which the node pauses
+	// until it is refilled to it; 0 disables
+	QuotaSlowdownBelow float64
+
+	// End-to-end encryption of values and tags, empty disables
+	PayloadKeyFile string
+
+	// File keeping the sequence numbers of readings across restarts, empty sends
+	// readings unnumbered
+	StateFile string
 
 	UseTLS         bool
-	CertFile       string
+	CertFile       string // CA certificate, empty uses the system root CAs
 	ClientCertFile string
 	ClientKeyFile  string
+	TLSServerName  string // SNI and name expected in the sink's certificate, empty uses the sink address host
+
+	// Clock drives pacing, retry backoff, heartbeats and reading timestamps, nil uses
+	// clock.Real. Tests and simulations set a clock.Fake.
+	Clock clock.Clock
 }
 
 // SensorNode represents a sensor node that generates and sends data
 type SensorNode struct {
 	config Config
-	client pb.TelemetryServiceClient
-	conn   *grpc.ClientConn
-	done   chan struct{}
+	pool   *pool.Pool
+	pacer  *pacer.Pacer
+	sealer *seal.Sealer  // nil unless values and tags are encrypted for offline readers
+	input  input.Adapter // nil generates random readings
+	// faults injects failures into generated readings, nil unless Faults are set
+	faults *fault.Injector
+	random randomStreams
+	// calls records the outgoing calls by method
+	calls *interceptor.Metrics
+	// delivery counts the readings delivered of those attempted
+	delivery *slo.Tracker
+	// sequences numbers the readings, nil unless StateFile is set
+	sequences *sequence.Store
+	// spool holds the readings to resend, nil unless SpoolDir is set; spooled
+	// wakes the loop resending them
+	spool   *spool.Spool
+	spooled chan struct{}
+	// unhealthy is whether the success rate was last found below MinSuccessRate
+	unhealthy atomic.Bool
+	// metricsServer serves MetricsAddr, nil when not set
+	metricsServer *http.Server
+	// hostMetrics samples the host's health, nil unless ReportHostMetrics is set
+	hostMetrics *hostmetrics.Sampler
+	hostname    string
+	// attachment is the content of AttachmentFile, nil when not set
+	attachment []byte
+	readings   atomic.Uint64 // readings generated, to attach to every AttachmentEvery-th
+	inFlight   chan struct{} // semaphore bounding concurrent sends
+	sends      sync.WaitGroup
+	done       chan struct{}
+	stopOnce   sync.Once
+	// inputFailing is whether the last poll of the input failed, to report changes
+	inputFailing atomic.Bool
+	// clockOff is whether the node's clock was last found off the sink's by more
+	// than clockOffsetWarning, to log changes
+	clockOff atomic.Bool
+	// acked holds the names of sensors the sink accepted readings of, to notice
+	// when its count starts over
+	acked sync.Map
+	// batch holds readings waiting for a full batch
+	batch   []*pb.SensorData
+	batchMu sync.Mutex
+	// batchUnsupported is set once the sink turned out not to accept batches
+	batchUnsupported atomic.Bool
+	// redirects receives the sink addresses a sink redirected the node to, nil
+	// unless redirects are followed
+	redirects chan []string
 }
 
+// Exit codes tell orchestrators a configuration error, which restarting won't fix,
+// from a failure at runtime. Invalid flags also exit with exitConfig, as the flag
+// package does.
+const (
+	exitRuntime = 1
+	exitConfig  = 2
+)
+
+var checkConfig = flag.Bool("check-config", false, "Validate the configuration, including keys and certificates, and exit")
+
+var configFile = flag.String("config", "", "YAML file of pipelines to run as a daemon, each with its own input, sink, rate, TLS and batching set by the other flags' names; replaces them")
+
 func main() {
 	config := parseFlags()
+	if *configFile != "" {
+		runDaemon(*configFile)
+		return
+	}
+	if err := validateConfig(config); err != nil {
+		fatal(exitConfig, "Invalid configuration: %v", err)
+	}
+	if *checkConfig {
+		log.Println("Configuration OK")
+		return
+	}
 
 	node, err := NewSensorNode(config)
 	if err != nil {
-		log.Fatalf("Failed to create sensor node: %v", err)
+		fatal(exitRuntime, "Failed to create sensor node: %v", err)
 	}
 	defer node.Close()
 
@@ -75,6 +327,30 @@ func main() {
 		config.ClientKeyFile,
 	)
 
+	if config.MaxRetries == 0 && !config.FireAndForget {
+		log.Printf("Retrying readings until they are delivered, backoff %v to %v", config.RetryBaseDelay, config.RetryMaxDelay)
+	}
+	if config.DeliveryDeadline > 0 {
+		if limit := retryTime(config); limit > 0 && config.DeliveryDeadline >= limit {
+			log.Printf("Delivery deadline %v is no shorter than the %v %d attempts take at most; attempts run out first", config.DeliveryDeadline, limit, config.MaxRetries)
+		} else {
+			log.Printf("Delivery deadline: readings not delivered within %v are given up", config.DeliveryDeadline)
+		}
+	}
+	if config.Connections > 1 || config.MaxInFlight > 1 {
+		log.Printf("Connection pool: %d connections, max %d sends in flight", config.Connections, config.MaxInFlight)
+	}
+	if node.pacer.Enabled() {
+		log.Printf(
+			"Outbound pacing: max %.2f msg/s, max %.0f bytes/s",
+			config.MaxMsgsPerSec,
+			config.MaxBytesPerSec,
+		)
+	}
+	if config.QuotaSlowdownBelow > 0 {
+		log.Printf("Slowing down below %.0f%% of the sink's quota", config.QuotaSlowdownBelow*100)
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
@@ -89,61 +365,546 @@ func main() {
 
 func parseFlags() Config {
 	var config Config
+	defineFlags(flag.CommandLine, &config)
+	flag.Parse()
 
-	flag.Float64Var(&config.Rate, "rate", 1.0, "Number of messages per second")
-	flag.StringVar(&config.SensorName, "sensor-name", "default-sensor", "Name of the sensor")
-	flag.StringVar(&config.SinkAddr, "sink-addr", "localhost:9090", "Address of the telemetry sink")
+	return config
+}
 
-	flag.BoolVar(&config.UseTLS, "tls", false, "Use TLS for connection")
-	flag.StringVar(&config.CertFile, "cert-file", "", "Path to TLS certificate file (optional)")
-	flag.StringVar(&config.ClientCertFile, "client-cert", "", "Path to client certificate file (for mTLS)")
-	flag.StringVar(&config.ClientKeyFile, "client-key", "", "Path to client private key file (for mTLS)")
+// defineFlags defines the flags setting config on fs: the command line's, or a
+// pipeline's in daemon mode.
+func defineFlags(fs *flag.FlagSet, config *Config) {
+	fs.Float64Var(&config.Rate, "rate", 1.0, "Number of messages per second")
+	fs.StringVar(&config.SensorName, "sensor-name", "default-sensor", "Name of the sensor")
+	fs.StringVar(&config.SinkAddr, "sink-addr", "localhost:9090", "Address of the telemetry sink (host:port, dns:///host:port, xds:///service or unix:///path/to.sock)")
+	fs.DurationVar(&config.DNSRefresh, "dns-refresh", 30*time.Second, "Interval between DNS lookups of a dns:/// -sink-addr, to follow sink instances being added or removed")
+	fs.BoolVar(&config.FollowRedirects, "follow-redirects", false, "Reconnect to the sink addresses a sink redirects the node to, e.g. while it is drained or when it is the standby of an active/standby pair. Whatever answers on -sink-addr can then send the node and its credentials elsewhere")
+	fs.StringVar(&config.Tenant, "tenant", "", "Tenant to report for (sent as request metadata)")
+	fs.StringVar(&config.AuthTokenFile, "auth-token-file", "", "Path to a token sent as a bearer token with every call, read again when it changes")
+	fs.BoolVar(&config.Critical, "critical", false, "Send readings with critical priority (e.g. safety sensors)")
+	fs.BoolVar(&config.FireAndForget, "fire-and-forget", false, "Send every reading once, without retries, and have the sink skip duplicate checks and never wait for its writer; trades durability for throughput")
+	fs.Func("tags", "Comma separated key=value tags attached to every reading", func(value string) error {
+		tags, err := parseTags(value)
+		config.Tags = tags
+		return err
+	})
+	fs.StringVar(&config.Unit, "unit", "", "Unit the readings' values are in (e.g. fahrenheit, psi or °F), sent with every reading so a sink can convert them to canonical units")
+	fs.Func("metrics", "Comma separated names of values measured together and sent in one reading (e.g. temperature,humidity,battery)", func(value string) error {
+		config.Metrics = nil
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				config.Metrics = append(config.Metrics, name)
+			}
+		}
+		return nil
+	})
+	fs.Func("faults", "Comma separated failures injected into generated readings: dropout=period/duration, stuck=chance/duration, spike=chance, drift=per-hour, nan=chance, overflow=chance", func(value string) error {
+		faults, err := fault.Parse(value)
+		config.Faults = faults
+		return err
+	})
+	fs.Int64Var(&config.Seed, "seed", 0, "Seed of generated values, start and retry jitter and faults, to replay the same readings (0 picks one and logs it)")
+	fs.IntVar(&config.AggregateSamples, "aggregate-samples", 0, "Samples taken and aggregated into each reading, sent as a count/sum summary or a histogram (0 sends single values)")
+	fs.Func("histogram-buckets", "Comma separated ascending upper bounds of the histogram buckets of aggregated readings", func(value string) error {
+		config.HistogramBuckets = nil
+		for _, bound := range strings.Split(value, ",") {
+			if bound = strings.TrimSpace(bound); bound == "" {
+				continue
+			}
+			f, err := strconv.ParseFloat(bound, 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return fmt.Errorf("invalid bucket bound %q", bound)
+			}
+			config.HistogramBuckets = append(config.HistogramBuckets, f)
+		}
+		return nil
+	})
+	fs.Func("location", "Position reported with every reading as latitude,longitude[,altitude] in degrees and meters", func(value string) error {
+		config.Location = nil
+		for _, coord := range strings.Split(value, ",") {
+			f, err := strconv.ParseFloat(strings.TrimSpace(coord), 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				return fmt.Errorf("invalid coordinate %q", coord)
+			}
+			config.Location = append(config.Location, f)
+		}
+		if len(config.Location) != 2 && len(config.Location) != 3 {
+			return fmt.Errorf("want latitude,longitude[,altitude]")
+		}
+		return nil
+	})
+	fs.StringVar(&config.AttachmentFile, "attachment-file", "", "File sent as a binary attachment (e.g. a spectrum snapshot) with every -attachment-every-th reading")
+	fs.IntVar(&config.AttachmentEvery, "attachment-every", 10, "Attach -attachment-file to one reading in this many")
+	fs.StringVar(&config.Input, "input", "", "Source of readings: empty generates random values, modbus polls a Modbus TCP device, file tails a file or reads stdin, exec runs a command")
+	fs.StringVar(&config.InputMapping, "input-mapping", "", "Path to the YAML file mapping the values polled with -input=modbus to sensor names")
+	fs.StringVar(&config.ModbusAddr, "modbus-addr", "", "Address of the Modbus TCP device polled with -input=modbus (host:port)")
+	fs.StringVar(&config.InputFile, "input-file", "", "File tailed with -input=file, or - to read stdin until it ends")
+	fs.StringVar(&config.InputFormat, "input-format", input.FormatJSON, "Format of the records read with -input=file: json lines or csv with a header line")
+	fs.StringVar(&config.InputCommand, "input-command", "", "Shell command run with -input=exec, printing a number or JSON records")
+	fs.DurationVar(&config.PollInterval, "poll-interval", 10*time.Second, "Interval between polls with -input, replacing -rate")
+	fs.DurationVar(&config.PollTimeout, "poll-timeout", 10*time.Second, "Maximum duration of a poll with -input; longer commands are killed")
+	fs.BoolVar(&config.ReportHostMetrics, "report-host-metrics", false, "Also send the CPU, memory, disk and temperature of the host, as sensor <sensor-name>-host tagged with the hostname")
+	fs.DurationVar(&config.HostMetricsInterval, "host-metrics-interval", time.Minute, "Interval between readings of the host with -report-host-metrics")
+	fs.Func("metadata", "Comma separated key=value device attributes sent when registering with the sink", func(value string) error {
+		metadata, err := parseTags(value)
+		config.Metadata = metadata
+		return err
+	})
+	fs.DurationVar(&config.HeartbeatInterval, "heartbeat-interval", 0, "Interval between heartbeats (0 uses the interval suggested by the sink)")
+	fs.DurationVar(&config.StartJitter, "start-jitter", 0, "Random delay up to this duration before the first send")
+	fs.DurationVar(&config.RampUp, "ramp-up", 0, "Period over which the send rate increases gradually to -rate")
+	fs.IntVar(&config.Connections, "connections", 1, "Number of gRPC connections to the sink to spread sends across")
+	fs.IntVar(&config.MaxInFlight, "max-in-flight", 1, "Maximum number of readings being sent concurrently")
+	fs.DurationVar(&config.SendTimeout, "send-timeout", defaultSendTimeout, "Deadline of every call to the sink, including each attempt to send a reading")
+	fs.DurationVar(&config.ConnectTimeout, "connect-timeout", defaultConnectTimeout, "Deadline of every attempt to connect to the sink; failed attempts are retried with backoff")
+	fs.DurationVar(&config.DeliveryDeadline, "delivery-deadline", 0, "Time after the first attempt to send a reading after which it is dropped rather than retried, at least -send-timeout (0 retries until 5 attempts failed)")
+	fs.IntVar(&config.MaxRetries, "max-retries", defaultMaxRetries, "Attempts to send a reading before it is dropped or spooled (0 retries until it is delivered)")
+	fs.DurationVar(&config.RetryBaseDelay, "retry-base-delay", defaultBaseDelay, "Backoff after the first failed attempt, doubling with every further one")
+	fs.DurationVar(&config.RetryMaxDelay, "retry-max-delay", defaultMaxDelay, "Longest backoff between attempts")
+	fs.Float64Var(&config.RetryJitter, "retry-jitter", 0.25, "Share of the backoff, from 0 to 1, it is randomly lengthened or shortened by, so nodes failing together don't retry together")
+	fs.Func("retry-codes", "Comma separated gRPC codes to retry, e.g. UNAVAILABLE,DEADLINE_EXCEEDED (default: all but INVALID_ARGUMENT, NOT_FOUND, PERMISSION_DENIED and UNAUTHENTICATED)", func(value string) error {
+		list, err := parseCodes(value)
+		config.RetryCodes = list
+		return err
+	})
+	fs.StringVar(&config.SpoolDir, "spool-dir", "", "Directory keeping readings not delivered when their attempts run out or -delivery-deadline passes, resent until the sink accepts them (default: dropped)")
+	fs.Int64Var(&config.SpoolMaxBytes, "spool-max-bytes", 64<<20, "Most bytes of readings kept in -spool-dir; readings not fitting are dropped")
+	fs.IntVar(&config.BatchSize, "batch-size", 1, "Readings sent per call: generated readings are collected until a batch is full, a poll's readings are sent together (1 sends each reading on its own)")
+	fs.Float64Var(&config.MinSuccessRate, "min-success-rate", 0, "Share of readings, from 0 to 1, to deliver over 5 minutes; below it the node is unhealthy: it logs it, reports an event and fails /healthz (0 disables)")
+	fs.DurationVar(&config.DeliveryReportInterval, "delivery-report-interval", 5*time.Minute, "Interval between summaries of the delivery success rates in the log (0 disables)")
+	fs.StringVar(&config.MetricsAddr, "metrics-addr", "", "Address to serve the delivery success rates on /metrics and the node's health on /healthz (e.g. localhost:9100)")
+	fs.Float64Var(&config.MaxMsgsPerSec, "max-msgs-per-sec", 0, "Maximum outgoing messages per second, including retries (0 disables)")
+	fs.Float64Var(&config.MaxBytesPerSec, "max-bytes-per-sec", 0, "Maximum outgoing bytes per second, including retries (0 disables)")
+	fs.Float64Var(&config.QuotaSlowdownBelow, "quota-slowdown-below", 0, "Share of the quota the sink reports, from 0 to 1, below which sending pauses until the quota is refilled to it, rather than running into rejections (0 disables)")
 
-	flag.Parse()
+	fs.StringVar(&config.PayloadKeyFile, "payload-key-file", "", "Path to base64 encoded 32-byte key; values and tags are encrypted with it so the sink stores only ciphertext")
+	fs.StringVar(&config.StateFile, "state-file", "", "Path to the file keeping each sensor's reading sequence numbers across restarts, which the sink recognizes resent readings by")
 
-	return config
+	fs.BoolVar(&config.UseTLS, "tls", false, "Use TLS for connection")
+	fs.StringVar(&config.CertFile, "cert-file", "", "Path to the CA certificate the sink's certificate is verified with (default: system root CAs)")
+	fs.StringVar(&config.ClientCertFile, "client-cert", "", "Path to client certificate file (for mTLS)")
+	fs.StringVar(&config.ClientKeyFile, "client-key", "", "Path to client private key file (for mTLS)")
+	fs.StringVar(&config.TLSServerName, "tls-sni", "", "Server name sent with SNI and expected in the sink's certificate (default: host of -sink-addr)")
+}
+
+func fatal(code int, format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// validateConfig checks the flags and loads the keys and certificates they refer to,
+// without connecting to the sink.
+func validateConfig(config Config) error {
+	switch {
+	case config.Rate <= 0:
+		return fmt.Errorf("-rate must be positive")
+	case config.SensorName == "":
+		return fmt.Errorf("-sensor-name is required")
+	case config.SinkAddr == "":
+		return fmt.Errorf("-sink-addr is required")
+	case mesh.IsXDSTarget(config.SinkAddr) && !mesh.Supported:
+		return fmt.Errorf("-sink-addr %s requires a sensor node built with -tags xds", config.SinkAddr)
+	case discovery.IsDNSTarget(config.SinkAddr) && config.DNSRefresh <= 0:
+		return fmt.Errorf("-dns-refresh must be positive")
+	case config.Connections < 1:
+		return fmt.Errorf("-connections must be at least 1")
+	case config.MaxRetries < 0:
+		return fmt.Errorf("-max-retries can't be negative")
+	case config.RetryBaseDelay <= 0 || config.RetryMaxDelay < config.RetryBaseDelay:
+		return fmt.Errorf("-retry-base-delay must be positive and at most -retry-max-delay")
+	case config.RetryJitter < 0 || config.RetryJitter > 1:
+		return fmt.Errorf("-retry-jitter must be between 0 and 1")
+	case config.SpoolDir != "" && config.FireAndForget:
+		return fmt.Errorf("-spool-dir can't be combined with -fire-and-forget, whose readings may be lost")
+	case config.SpoolDir != "" && config.SpoolMaxBytes <= 0:
+		return fmt.Errorf("-spool-max-bytes must be positive")
+	case config.SendTimeout <= 0 || config.ConnectTimeout <= 0:
+		return fmt.Errorf("-send-timeout and -connect-timeout must be positive")
+	case config.DeliveryDeadline < 0:
+		return fmt.Errorf("-delivery-deadline can't be negative")
+	case config.DeliveryDeadline > 0 && config.FireAndForget:
+		return fmt.Errorf("-delivery-deadline can't be combined with -fire-and-forget, whose readings are sent once")
+	case config.DeliveryDeadline > 0 && config.DeliveryDeadline < config.SendTimeout:
+		return fmt.Errorf("-delivery-deadline must be at least -send-timeout, or no attempt could run its course")
+	case config.BatchSize < 1 || config.BatchSize > maxBatchSize:
+		return fmt.Errorf("-batch-size must be between 1 and %d", maxBatchSize)
+	case config.MinSuccessRate < 0 || config.MinSuccessRate > 1:
+		return fmt.Errorf("-min-success-rate must be between 0 and 1")
+	case config.QuotaSlowdownBelow < 0 || config.QuotaSlowdownBelow > 1:
+		return fmt.Errorf("-quota-slowdown-below must be between 0 and 1")
+	case config.DeliveryReportInterval < 0:
+		return fmt.Errorf("-delivery-report-interval can't be negative")
+	case (config.ClientCertFile == "") != (config.ClientKeyFile == ""):
+		return fmt.Errorf("-client-cert and -client-key must be set together")
+	case !config.UseTLS && (config.CertFile != "" || config.ClientCertFile != "" || config.TLSServerName != ""):
+		return fmt.Errorf("-cert-file, -client-cert and -tls-sni require -tls")
+	case len(config.Location) > 0 && (math.Abs(config.Location[0]) > 90 || math.Abs(config.Location[1]) > 180):
+		return fmt.Errorf("-location must have a latitude within -90 to 90 and a longitude within -180 to 180")
+	case config.AggregateSamples < 0:
+		return fmt.Errorf("-aggregate-samples can't be negative")
+	case config.AggregateSamples > 0 && len(config.Metrics) > 0:
+		return fmt.Errorf("-aggregate-samples can't be combined with -metrics")
+	case len(config.HistogramBuckets) > 0 && config.AggregateSamples == 0:
+		return fmt.Errorf("-histogram-buckets requires -aggregate-samples")
+	case config.FireAndForget && config.Critical:
+		return fmt.Errorf("-fire-and-forget can't be combined with -critical")
+	case config.Faults.Enabled() && config.Input != "":
+		return fmt.Errorf("-faults applies to generated readings and can't be combined with -input")
+	case config.AttachmentFile != "" && config.AttachmentEvery < 1:
+		return fmt.Errorf("-attachment-every must be at least 1")
+	case config.Input != "" && config.Input != "modbus" && config.Input != "file" && config.Input != "exec":
+		return fmt.Errorf("-input must be empty, modbus, file or exec")
+	case config.Input != "modbus" && (config.InputMapping != "" || config.ModbusAddr != ""):
+		return fmt.Errorf("-input-mapping and -modbus-addr require -input=modbus")
+	case config.Input == "modbus" && (config.InputMapping == "" || config.ModbusAddr == ""):
+		return fmt.Errorf("-input=modbus requires -input-mapping and -modbus-addr")
+	case config.Input != "file" && config.InputFile != "":
+		return fmt.Errorf("-input-file requires -input=file")
+	case config.Input == "file" && config.InputFile == "":
+		return fmt.Errorf("-input=file requires -input-file")
+	case config.Input == "file" && config.InputFormat != input.FormatJSON && config.InputFormat != input.FormatCSV:
+		return fmt.Errorf("-input-format must be json or csv")
+	case config.Input != "exec" && config.InputCommand != "":
+		return fmt.Errorf("-input-command requires -input=exec")
+	case config.Input == "exec" && config.InputCommand == "":
+		return fmt.Errorf("-input=exec requires -input-command")
+	case config.Input != "" && (config.PollInterval <= 0 || config.PollTimeout <= 0):
+		return fmt.Errorf("-poll-interval and -poll-timeout must be positive")
+	case config.ReportHostMetrics && config.HostMetricsInterval <= 0:
+		return fmt.Errorf("-host-metrics-interval must be positive")
+	case config.Input != "" && (len(config.Metrics) > 0 || config.AggregateSamples > 0):
+		return fmt.Errorf("-input can't be combined with -metrics or -aggregate-samples")
+	}
+	for i := 1; i < len(config.HistogramBuckets); i++ {
+		if config.HistogramBuckets[i] <= config.HistogramBuckets[i-1] {
+			return fmt.Errorf("-histogram-buckets must be in ascending order")
+		}
+	}
+
+	if config.AttachmentFile != "" {
+		if _, err := os.Stat(config.AttachmentFile); err != nil {
+			return fmt.Errorf("attachment: %w", err)
+		}
+	}
+	switch {
+	case config.Input == "modbus":
+		if _, err := input.LoadModbusMapping(config.InputMapping); err != nil {
+			return fmt.Errorf("input: %w", err)
+		}
+	case config.Input == "file" && config.InputFile != "-":
+		if _, err := os.Stat(config.InputFile); err != nil {
+			return fmt.Errorf("input: %w", err)
+		}
+	}
+	if config.ReportHostMetrics {
+		if _, err := hostmetrics.NewSampler(); err != nil {
+			return fmt.Errorf("host metrics: %w", err)
+		}
+	}
+	if config.PayloadKeyFile != "" {
+		key, err := seal.LoadKey(config.PayloadKeyFile)
+		if err != nil {
+			return fmt.Errorf("payload key: %w", err)
+		}
+		if _, err := seal.NewSealer(key); err != nil {
+			return fmt.Errorf("payload key: %w", err)
+		}
+	}
+	if config.UseTLS {
+		if _, err := loadTLSCredentials(config); err != nil {
+			return fmt.Errorf("TLS: %w", err)
+		}
+	}
+	if config.AuthTokenFile != "" {
+		if _, err := interceptor.LoadToken(config.AuthTokenFile); err != nil {
+			return fmt.Errorf("auth token: %w", err)
+		}
+	}
+	if config.StateFile != "" {
+		if _, err := sequence.Open(config.StateFile); err != nil {
+			return fmt.Errorf("state: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// parseCodes parses a comma separated list of gRPC code names, such as UNAVAILABLE.
+func parseCodes(value string) ([]codes.Code, error) {
+	var list []codes.Code
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name)))); err != nil {
+			return nil, fmt.Errorf("unknown gRPC code %q", name)
+		}
+		list = append(list, code)
+	}
+	return list, nil
+}
+
+// parseTags parses a comma separated list of key=value pairs.
+func parseTags(value string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		tags[key] = val
+	}
+	return tags, nil
 }
 
 func NewSensorNode(config Config) (*SensorNode, error) {
-	var opts []grpc.DialOption
+	if config.Clock == nil {
+		config.Clock = clock.Real
+	}
+	config.SendTimeout = cmp.Or(config.SendTimeout, defaultSendTimeout)
+	config.ConnectTimeout = cmp.Or(config.ConnectTimeout, defaultConnectTimeout)
+
+	opts := []grpc.DialOption{
+		grpc.WithConnectParams(grpc.ConnectParams{Backoff: backoff.DefaultConfig, MinConnectTimeout: config.ConnectTimeout}),
+	}
 
-	if config.UseTLS && config.CertFile != "" {
-		creds, err := loadTLSCredentials(config)
+	var sealer *seal.Sealer
+	if config.PayloadKeyFile != "" {
+		key, err := seal.LoadKey(config.PayloadKeyFile)
 		if err != nil {
+			return nil, fmt.Errorf("failed to load payload key: %w", err)
+		}
+		if sealer, err = seal.NewSealer(key); err != nil {
+			return nil, fmt.Errorf("failed to create payload sealer: %w", err)
+		}
+		log.Println("End-to-end payload encryption enabled")
+	}
+
+	var attachment []byte
+	if config.AttachmentFile != "" {
+		var err error
+		if attachment, err = os.ReadFile(config.AttachmentFile); err != nil {
+			return nil, fmt.Errorf("failed to read attachment: %w", err)
+		}
+	}
+
+	var sequences *sequence.Store
+	if config.StateFile != "" {
+		var err error
+		if sequences, err = sequence.Open(config.StateFile); err != nil {
+			return nil, fmt.Errorf("failed to open state file: %w", err)
+		}
+		for _, p := range sequences.Positions() {
+			log.Printf("Resuming %s at sequence %d, last acknowledged %d", p.Sensor, p.Next, p.Acked)
+		}
+	}
+
+	var spooled *spool.Spool
+	if config.SpoolDir != "" {
+		var err error
+		if spooled, err = spool.Open(config.SpoolDir, config.SpoolMaxBytes); err != nil {
+			return nil, fmt.Errorf("failed to open spool: %w", err)
+		}
+		readings, size := spooled.Len()
+		log.Printf("Spool %s: %d readings (%d bytes) to resend", config.SpoolDir, readings, size)
+	}
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("Seeding random values with %d", seed)
+	random := newRandomStreams(seed)
+
+	var faults *fault.Injector
+	if config.Faults.Enabled() {
+		faults = fault.NewInjector(config.Faults, config.Clock, random.faults)
+		log.Printf("Injecting faults %+v", config.Faults)
+	}
+
+	var source input.Adapter
+	if config.Input != "" {
+		var err error
+		if source, err = openInput(config); err != nil {
+			return nil, fmt.Errorf("failed to open input: %w", err)
+		}
+		log.Printf("Polling %s input every %v", config.Input, config.PollInterval)
+	}
+
+	var sampler *hostmetrics.Sampler
+	var hostname string
+	if config.ReportHostMetrics {
+		var err error
+		if sampler, err = hostmetrics.NewSampler(); err != nil {
+			return nil, fmt.Errorf("failed to sample host metrics: %w", err)
+		}
+		if hostname, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("failed to get hostname: %w", err)
+		}
+		log.Printf("Reporting host metrics as %s%s every %v", config.SensorName, hostSensorSuffix, config.HostMetricsInterval)
+	}
+
+	creds := insecure.NewCredentials()
+	if config.UseTLS {
+		var err error
+		if creds, err = loadTLSCredentials(config); err != nil {
 			return nil, fmt.Errorf("failed to load TLS credentials: %w", err)
 		}
-		opts = append(opts, grpc.WithTransportCredentials(creds))
 
 		if config.ClientCertFile != "" && config.ClientKeyFile != "" {
 			log.Println("mTLS enabled for client connection")
 		} else {
 			log.Println("TLS enabled for client connection")
 		}
+	}
+
+	if mesh.IsXDSTarget(config.SinkAddr) {
+		// The control plane decides on mTLS; the configured credentials are the fallback.
+		xdsOpts, err := mesh.DialOptions(creds)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, xdsOpts...)
+		log.Println("xDS enabled for client connection")
 	} else {
-		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		opts = append(opts, grpc.WithTransportCredentials(creds))
 	}
 
-	conn, err := grpc.Dial(config.SinkAddr, opts...)
+	if discovery.IsDNSTarget(config.SinkAddr) {
+		opts = append(opts, discovery.DialOptions(config.DNSRefresh, config.Clock)...)
+	}
+
+	calls := interceptor.NewMetrics()
+	var redirects chan []string
+	if config.FollowRedirects && !mesh.IsXDSTarget(config.SinkAddr) {
+		redirects = make(chan []string, 1)
+	}
+	pace := pacer.New(config.MaxMsgsPerSec, config.MaxBytesPerSec, config.Clock)
+	opts = append(opts, grpc.WithChainUnaryInterceptor(interceptors(config, calls, redirects, pace)...))
+
+	var metricsListener net.Listener
+	if config.MetricsAddr != "" {
+		var err error
+		if metricsListener, err = net.Listen("tcp", config.MetricsAddr); err != nil {
+			return nil, fmt.Errorf("failed to listen for metrics: %w", err)
+		}
+	}
+
+	connPool, err := pool.Dial(config.SinkAddr, config.Connections, opts...)
 	if err != nil {
+		if metricsListener != nil {
+			metricsListener.Close()
+		}
 		return nil, fmt.Errorf("failed to connect to sink: %w", err)
 	}
 
-	client := pb.NewTelemetryServiceClient(conn)
+	maxInFlight := config.MaxInFlight
+	if maxInFlight < 1 {
+		maxInFlight = 1
+	}
+
+	s := &SensorNode{
+		config:      config,
+		pool:        connPool,
+		pacer:       pace,
+		calls:       calls,
+		delivery:    slo.NewTracker(config.Clock),
+		sequences:   sequences,
+		spool:       spooled,
+		spooled:     make(chan struct{}, 1),
+		sealer:      sealer,
+		input:       source,
+		faults:      faults,
+		random:      random,
+		hostMetrics: sampler,
+		hostname:    hostname,
+		attachment:  attachment,
+		inFlight:    make(chan struct{}, maxInFlight),
+		done:        make(chan struct{}),
+		redirects:   redirects,
+	}
+	if metricsListener != nil {
+		s.serveMetrics(metricsListener)
+		log.Printf("Serving delivery metrics on %s", metricsListener.Addr())
+	}
+	return s, nil
+}
+
+// interceptors returns the interceptors applied to every call to the sink, outermost
+// first. The metrics interceptor is innermost, so it times the call alone. Redirects
+// are passed on to redirects unless it is nil. With QuotaSlowdownBelow, pace is held
+// while the quota the sink reports is low.
+func interceptors(config Config, calls *interceptor.Metrics, redirects chan<- []string, pace *pacer.Pacer) []grpc.UnaryClientInterceptor {
+	chain := []grpc.UnaryClientInterceptor{interceptor.RequestID(), interceptor.Trace()}
+	if below := config.QuotaSlowdownBelow; below > 0 {
+		chain = append(chain, interceptor.ReportQuota(func(quota interceptor.Quota) {
+			if pause := quotaSlowdown(quota, below); pause > 0 {
+				pace.Hold(pause)
+			}
+		}))
+	}
+	if redirects != nil {
+		chain = append(chain, interceptor.Redirect(func(endpoints []string) {
+			select {
+			case redirects <- endpoints:
+			default: // the node is following a redirect already
+			}
+		}))
+	}
+	if config.Tenant != "" {
+		chain = append(chain, interceptor.Metadata(tenantMetadataKey, config.Tenant))
+	}
+	if config.AuthTokenFile != "" {
+		if !config.UseTLS && !mesh.IsXDSTarget(config.SinkAddr) {
+			log.Println("Warning: sending the auth token without TLS")
+		}
+		chain = append(chain, interceptor.BearerToken(config.AuthTokenFile))
+	}
+	return append(chain, calls.Interceptor())
+}
 
-	return &SensorNode{
-		config: config,
-		client: client,
-		conn:   conn,
-		done:   make(chan struct{}),
-	}, nil
+// openInput creates the adapter for -input.
+func openInput(config Config) (input.Adapter, error) {
+	switch config.Input {
+	case "file":
+		return input.OpenFile(config.InputFile, config.InputFormat, config.SensorName)
+	case "exec":
+		return input.NewExec(config.InputCommand, config.SensorName), nil
+	}
+
+	mapping, err := input.LoadModbusMapping(config.InputMapping)
+	if err != nil {
+		return nil, err
+	}
+	return input.NewModbus(config.ModbusAddr, mapping), nil
 }
 
+// loadTLSCredentials returns credentials verifying the sink against the CA in CertFile,
+// or the system root CAs without one. TLS is never silently dropped: any failure to
+// set it up is returned.
 func loadTLSCredentials(config Config) (credentials.TransportCredentials, error) {
 	tlsConfig := &tls.Config{
-		ServerName: serverName,
+		ServerName: config.TLSServerName,
+	}
+	// gRPC would verify the sink against the host of a link-local address such as
+	// [fe80::1%eth0]:9090 with its zone, which certificates never name.
+	if host, _, err := net.SplitHostPort(config.SinkAddr); err == nil && tlsConfig.ServerName == "" {
+		if ip, err := netip.ParseAddr(host); err == nil && ip.Zone() != "" {
+			tlsConfig.ServerName = ip.WithZone("").String()
+		}
 	}
 
-	if config.CertFile != "" {
+	if config.CertFile == "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("load system root CAs: %w", err)
+		}
+		tlsConfig.RootCAs = pool
+	} else {
 		caCert, err := os.ReadFile(config.CertFile)
 		if err != nil {
 			return nil, fmt.Errorf("read CA certificate: %w", err)
@@ -169,14 +930,60 @@ func loadTLSCredentials(config Config) (credentials.TransportCredentials, error)
 }
 
 func (s *SensorNode) Run() {
-	interval := time.Duration(float64(time.Second) / s.config.Rate)
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	if s.config.StartJitter > 0 {
+		delay := time.Duration(s.random.jitter.Int63n(int64(s.config.StartJitter)))
+		log.Printf("Delaying start by %v", delay)
+
+		if !clock.Sleep(s.config.Clock, delay, s.done) {
+			log.Println("Sensor node stopped")
+			return
+		}
+	}
+
+	if interval, ok := s.register(); ok {
+		s.sends.Add(1)
+		go s.heartbeatLoop(interval)
+	}
+	if s.hostMetrics != nil {
+		s.sends.Add(1)
+		go s.hostMetricsLoop()
+	}
+	if s.config.MinSuccessRate > 0 || s.config.DeliveryReportInterval > 0 {
+		s.sends.Add(1)
+		go s.deliveryLoop()
+	}
+	if s.redirects != nil {
+		s.sends.Add(1)
+		go s.redirectLoop()
+	}
+	if s.spool != nil {
+		s.sends.Add(1)
+		go s.spoolLoop()
+	}
+
+	rate := s.config.Rate
+	if s.input != nil {
+		rate = 1 / s.config.PollInterval.Seconds()
+	}
+
+	now := s.config.Clock.Now()
+	ramp := pacer.NewRamp(rate, s.config.RampUp, now)
+	next := now.Add(ramp.Interval(now))
+	timer := s.config.Clock.NewTimer(next.Sub(now))
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			s.generateAndSendData()
+		case <-timer.C():
+			s.dispatch()
+
+			// Like a ticker, skip missed slots instead of bursting to catch up.
+			now := s.config.Clock.Now()
+			next = next.Add(ramp.Interval(now))
+			if next.Before(now) {
+				next = now
+			}
+			timer.Reset(next.Sub(now))
 		case <-s.done:
 			log.Println("Sensor node stopped")
 			return
@@ -184,11 +991,248 @@ func (s *SensorNode) Run() {
 	}
 }
 
+// dispatch sends new readings, concurrently with other sends when -max-in-flight
+// allows it. When every slot is busy it waits, which slows generation down to what
+// the sink accepts.
+func (s *SensorNode) dispatch() {
+	if cap(s.inFlight) == 1 {
+		s.collect()
+		return
+	}
+
+	select {
+	case s.inFlight <- struct{}{}:
+	case <-s.done:
+		return
+	}
+
+	s.sends.Add(1)
+	go func() {
+		defer s.sends.Done()
+		defer func() { <-s.inFlight }()
+		s.collect()
+	}()
+}
+
+// collect sends the next readings: those polled from the input when there is one,
+// a random reading otherwise.
+func (s *SensorNode) collect() {
+	if s.input != nil {
+		s.pollAndSendData()
+	} else {
+		s.generateAndSendData()
+	}
+}
+
 func (s *SensorNode) generateAndSendData() {
+	if s.faults != nil && s.faults.Dropped() {
+		return
+	}
+
 	sensorData := &pb.SensorData{
 		SensorName:  s.config.SensorName,
-		SensorValue: rand.Int31n(100),
-		Timestamp:   timestamppb.Now(),
+		SensorValue: s.random.values.Int31n(100),
+		Timestamp:   timestamppb.New(s.config.Clock.Now()),
+		Tags:        s.config.Tags,
+		Unit:        s.config.Unit,
+	}
+	if len(s.config.Metrics) > 0 {
+		sensorData.SensorValue = 0
+		sensorData.Values = make(map[string]float64, len(s.config.Metrics))
+		for _, name := range s.config.Metrics {
+			sensorData.Values[name] = float64(s.random.values.Int31n(100))
+		}
+	}
+	if s.config.AggregateSamples > 0 {
+		sensorData.SensorValue = 0
+		sensorData.Aggregate = sampleAggregate(s.random.values, s.config.AggregateSamples, s.config.HistogramBuckets)
+	}
+	if s.faults != nil {
+		s.injectFaults(sensorData)
+	}
+	s.send(sensorData)
+}
+
+// injectFaults applies the injected faults to the value, or each of the values, of a
+// generated reading. Aggregates are left as sampled.
+func (s *SensorNode) injectFaults(sensorData *pb.SensorData) {
+	switch {
+	case sensorData.Aggregate != nil:
+	case len(sensorData.Values) > 0:
+		for _, name := range slices.Sorted(maps.Keys(sensorData.Values)) {
+			sensorData.Values[name] = s.faults.Value(name, sensorData.Values[name])
+		}
+	default:
+		sensorData.SensorValue = s.faults.IntValue(sensorData.SensorName, sensorData.SensorValue)
+	}
+}
+
+// pollAndSendData polls the input and sends what it read. Readings polled before a
+// failure are still sent, and the node stops once the input ends.
+func (s *SensorNode) pollAndSendData() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.PollTimeout)
+	readings, err := s.input.Poll(ctx)
+	cancel()
+	ended := errors.Is(err, io.EOF)
+	if err != nil && !ended {
+		log.Printf("Failed to poll input: %v", err)
+		s.reportInputState(err)
+	} else {
+		s.reportInputState(nil)
+	}
+
+	now := s.config.Clock.Now()
+	for _, reading := range readings {
+		if sensorData, err := sensorDataFromReading(reading, now, s.config.Tags); err != nil {
+			log.Printf("Dropping reading of %s: %v", reading.SensorName, err)
+		} else {
+			sensorData.Unit = s.config.Unit
+			s.send(sensorData)
+		}
+	}
+	if s.config.BatchSize > 1 {
+		s.flushBatch()
+	}
+
+	if ended {
+		log.Println("Input ended")
+		s.Stop()
+	}
+}
+
+// reportInputState sends an event when polling the input starts failing and when it
+// recovers, so failures show up in the sink's log without an event for every poll.
+func (s *SensorNode) reportInputState(err error) {
+	failing := err != nil
+	if s.inputFailing.Swap(failing) == failing {
+		return
+	}
+
+	event := &pb.Event{
+		SensorName: s.config.SensorName,
+		Timestamp:  timestamppb.New(s.config.Clock.Now()),
+		Severity:   pb.Severity_SEVERITY_INFO,
+		Message:    "input recovered",
+		Attributes: map[string]string{"input": s.config.Input},
+	}
+	if failing {
+		event.Severity = pb.Severity_SEVERITY_ERROR
+		event.Message = "input failed: " + err.Error()
+	}
+	s.reportEvent(event, "input state")
+}
+
+// reportEvent sends a device event to the sink; what describes it in the log.
+func (s *SensorNode) reportEvent(event *pb.Event, what string) {
+	ctx, cancel := s.callContext(context.Background())
+	defer cancel()
+	conn := s.pool.Pick()
+	resp, err := conn.Client.ReportEvent(ctx, event)
+	conn.Report(err)
+
+	switch {
+	case status.Code(err) == codes.Unimplemented:
+		log.Printf("Sink does not support events, %s not reported", what)
+	case err != nil:
+		log.Printf("Failed to report %s: %v", what, err)
+	case resp.Draining:
+		conn.Drain()
+	}
+}
+
+// redirectLoop reconnects to the sink addresses sinks redirect the node to until
+// Stop is called. Calls in flight finish on the old connections.
+func (s *SensorNode) redirectLoop() {
+	defer s.sends.Done()
+
+	for {
+		select {
+		case endpoints := <-s.redirects:
+			s.followRedirect(endpoints)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *SensorNode) followRedirect(endpoints []string) {
+	changed, err := s.pool.Redial(endpoints)
+	switch {
+	case err != nil:
+		log.Printf("Failed to follow the sink's redirect to %s: %v", strings.Join(endpoints, ", "), err)
+	case changed:
+		log.Printf("Sink redirected the node to %s, reconnected", strings.Join(endpoints, ", "))
+	}
+}
+
+// sensorDataFromReading converts a polled reading, timestamped now unless the source
+// says when it was taken. A single value is rounded to the integer SensorValue.
+// Values that aren't finite are dropped.
+func sensorDataFromReading(reading input.Reading, now time.Time, tags map[string]string) (*pb.SensorData, error) {
+	if !reading.Time.IsZero() {
+		now = reading.Time
+	}
+	sensorData := &pb.SensorData{
+		SensorName: reading.SensorName,
+		Timestamp:  timestamppb.New(now),
+		Tags:       tags,
+	}
+	if len(reading.Tags) > 0 {
+		sensorData.Tags = maps.Clone(tags)
+		if sensorData.Tags == nil {
+			sensorData.Tags = make(map[string]string, len(reading.Tags))
+		}
+		maps.Copy(sensorData.Tags, reading.Tags)
+	}
+
+	if len(reading.Values) > 0 {
+		sensorData.Values = make(map[string]float64, len(reading.Values))
+		for name, value := range reading.Values {
+			if !math.IsNaN(value) && !math.IsInf(value, 0) {
+				sensorData.Values[name] = value
+			}
+		}
+		if len(sensorData.Values) == 0 {
+			return nil, fmt.Errorf("no finite values")
+		}
+		return sensorData, nil
+	}
+
+	value := math.Round(reading.Value)
+	if math.IsNaN(value) || value < math.MinInt32 || value > math.MaxInt32 {
+		return nil, fmt.Errorf("value %g out of range", reading.Value)
+	}
+	sensorData.SensorValue = int32(value)
+	return sensorData, nil
+}
+
+// send adds the per-node fields to a reading, seals it if configured and sends it.
+func (s *SensorNode) send(sensorData *pb.SensorData) {
+	if location := s.config.Location; len(location) > 0 {
+		sensorData.Location = &pb.Location{Latitude: location[0], Longitude: location[1]}
+		if len(location) > 2 {
+			sensorData.Location.Altitude = &location[2]
+		}
+	}
+	if s.attachment != nil && (s.readings.Add(1)-1)%uint64(s.config.AttachmentEvery) == 0 {
+		sensorData.Attachment = s.attachment
+	}
+	if s.config.Critical {
+		sensorData.Priority = pb.Priority_PRIORITY_CRITICAL
+	}
+	if s.config.FireAndForget {
+		sensorData.Delivery = pb.Delivery_DELIVERY_FIRE_AND_FORGET
+	}
+	if s.sealer != nil {
+		if err := s.sealer.Seal(sensorData); err != nil {
+			log.Printf("Failed to seal reading: %v", err)
+			return
+		}
+	}
+
+	if s.config.BatchSize > 1 && !s.batchUnsupported.Load() {
+		s.queue(sensorData)
+		return
 	}
 
 	err := s.sendWithRetry(sensorData)
@@ -197,35 +1241,536 @@ func (s *SensorNode) generateAndSendData() {
 	}
 }
 
+// queue adds a reading to the batch and sends the batch once it is full.
+func (s *SensorNode) queue(sensorData *pb.SensorData) {
+	s.batchMu.Lock()
+	s.batch = append(s.batch, sensorData)
+	var full []*pb.SensorData
+	if len(s.batch) >= s.config.BatchSize {
+		full, s.batch = s.batch, nil
+	}
+	s.batchMu.Unlock()
+
+	if full != nil {
+		s.sendBatch(full)
+	}
+}
+
+// flushBatch sends the readings waiting for a full batch.
+func (s *SensorNode) flushBatch() {
+	s.batchMu.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.batchMu.Unlock()
+
+	if len(batch) > 0 {
+		s.sendBatch(batch)
+	}
+}
+
+func (s *SensorNode) sendBatch(batch []*pb.SensorData) {
+	if err := s.sendBatchWithRetry(batch); err != nil {
+		log.Printf("Failed to send batch after retries: %v", err)
+	}
+}
+
+// sendWithRetry sends a reading until the sink accepts or rejects it. Every attempt
+// carries the reading's request ID, as metadata and in the reading, for the sink to
+// log and return with errors.
 func (s *SensorNode) sendWithRetry(sensorData *pb.SensorData) error {
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	s.stamp(sensorData)
+	size := proto.Size(sensorData)
+	parent := metadata.AppendToOutgoingContext(interceptor.WithTrace(context.Background()), interceptor.RequestIDKey, sensorData.RequestId)
+
+	deadline := s.deliveryDeadline()
+	expired := false
+	attempts := s.attempts()
+	for attempt := 0; attempt < attempts; attempt++ {
+		if !s.pacer.Wait(s.done, size) {
+			return fmt.Errorf("sensor node stopped")
+		}
 
-		response, err := s.client.SendSensorData(ctx, sensorData)
+		ctx, cancel, ok := s.sendContext(parent, deadline)
+		if !ok {
+			expired = true
+			break
+		}
+		conn := s.pool.Pick()
+		sent := s.config.Clock.Now()
+		response, err := conn.Client.SendSensorData(ctx, sensorData)
 		cancel()
+		conn.Report(err)
 
 		if err == nil {
-			log.Printf("Sent: %s=%d at %s, Response: %s",
+			s.delivery.Record(true)
+			s.sequences.Ack(sensorData.SensorName, sensorData.Sequence)
+			s.handleResponse(conn, response, sent)
+			s.checkSequence(sensorData.SensorName, response.Sequence)
+			log.Printf("Sent: %s=%s at %s, request %s, Response: %s",
 				sensorData.SensorName,
-				sensorData.SensorValue,
+				formatValue(sensorData),
 				sensorData.Timestamp.AsTime().Format(time.RFC3339),
+				sensorData.RequestId,
 				response.Message)
 			return nil
 		}
 
 		// Check if error is retryable
 		if !s.isRetryableError(err) {
-			return fmt.Errorf("non-retryable error: %w", err)
+			s.delivery.Record(false)
+			return fmt.Errorf("request %s: non-retryable error: %w%s", sensorData.RequestId, err, describeViolations(err))
+		}
+
+		if attempt < attempts-1 {
+			delay := s.calculateDelay(attempt)
+			if s.pastDeadline(deadline, delay) {
+				expired = true
+				break
+			}
+			log.Printf("Attempt %d of request %s failed: %v. Retrying in %v...", attempt+1, sensorData.RequestId, err, delay)
+			if !clock.Sleep(s.config.Clock, delay, s.done) {
+				return fmt.Errorf("sensor node stopped")
+			}
+		}
+	}
+
+	s.delivery.Record(false)
+	if attempts == 1 {
+		return fmt.Errorf("request %s: not delivered, fire-and-forget readings aren't retried", sensorData.RequestId)
+	}
+	if s.spool != nil {
+		return s.spoolReadings([]*pb.SensorData{sensorData})
+	}
+	if expired {
+		return fmt.Errorf("request %s: not delivered within the delivery deadline of %v, dropped", sensorData.RequestId, s.config.DeliveryDeadline)
+	}
+	return fmt.Errorf("request %s: max retries (%d) exceeded", sensorData.RequestId, s.config.MaxRetries)
+}
+
+// attempts returns how many times a reading is sent before giving up: once with
+// -fire-and-forget, and until it is delivered with -max-retries=0.
+func (s *SensorNode) attempts() int {
+	switch {
+	case s.config.FireAndForget:
+		return 1
+	case s.config.MaxRetries == 0:
+		return math.MaxInt
+	}
+	return s.config.MaxRetries
+}
+
+// stamp gives a reading its request ID and, with a state file, its sequence number,
+// unless it has them from an earlier attempt. A reading that can't be numbered is
+// sent without a number.
+func (s *SensorNode) stamp(sensorData *pb.SensorData) {
+	if sensorData.RequestId == "" {
+		sensorData.RequestId = uuid.NewString()
+	}
+	if sensorData.Sequence == 0 {
+		n, err := s.sequences.Next(sensorData.SensorName)
+		if err != nil {
+			log.Printf("Failed to number reading of %s: %v", sensorData.SensorName, err)
+		}
+		sensorData.Sequence = n
+	}
+}
+
+// sendBatchWithRetry sends readings in one call, then resends those the sink
+// throttled or couldn't store until each is accepted or rejected as invalid. A
+// sink without batches gets them one by one. With a spool, the readings left when
+// the attempts run out or the node stops are spooled.
+func (s *SensorNode) sendBatchWithRetry(batch []*pb.SensorData) error {
+	for _, sensorData := range batch {
+		s.stamp(sensorData)
+	}
+	pending := batch
+	trace := interceptor.WithTrace(context.Background())
+	deadline := s.deliveryDeadline()
+	expired, stopped := false, false
+	attempts := s.attempts()
+	for attempt := 0; attempt < attempts; attempt++ {
+		req := &pb.SensorDataBatch{Readings: pending}
+		if !s.pacer.Wait(s.done, proto.Size(req)) {
+			stopped = true
+			break
+		}
+
+		ctx, cancel, ok := s.sendContext(trace, deadline)
+		if !ok {
+			expired = true
+			break
+		}
+		conn := s.pool.Pick()
+		sent := s.config.Clock.Now()
+		response, err := conn.Client.SendSensorDataBatch(ctx, req)
+		cancel()
+		conn.Report(err)
+
+		switch {
+		case status.Code(err) == codes.Unimplemented:
+			if !s.batchUnsupported.Swap(true) {
+				log.Println("Sink does not support batches, sending readings one by one")
+			}
+			for _, sensorData := range pending {
+				if err := s.sendWithRetry(sensorData); err != nil {
+					log.Printf("Failed to send data after retries: %v", err)
+				}
+			}
+			return nil
+		case err == nil:
+			s.handleResponse(conn, response, sent)
+			n := len(pending)
+			if pending, err = s.unaccepted(pending, response.Results); err != nil {
+				return err
+			}
+			log.Printf("Sent batch of %d readings, %d to resend", n, len(pending))
+			if len(pending) == 0 {
+				return nil
+			}
+		case !s.isRetryableError(err):
+			s.recordUndelivered(pending)
+			return fmt.Errorf("non-retryable error: %w%s", err, describeViolations(err))
+		default:
+			log.Printf("Attempt %d failed: %v", attempt+1, err)
+		}
+
+		if attempt < attempts-1 {
+			delay := s.calculateDelay(attempt)
+			if s.pastDeadline(deadline, delay) {
+				expired = true
+				break
+			}
+			log.Printf("Resending %d readings in %v...", len(pending), delay)
+			if !clock.Sleep(s.config.Clock, delay, s.done) {
+				stopped = true
+				break
+			}
+		}
+	}
+
+	s.recordUndelivered(pending)
+	if attempts == 1 {
+		return fmt.Errorf("%d fire-and-forget readings not accepted", len(pending))
+	}
+	if s.spool != nil {
+		return s.spoolReadings(pending)
+	}
+	if stopped {
+		return fmt.Errorf("sensor node stopped, %d readings not sent", len(pending))
+	}
+	if expired {
+		return fmt.Errorf("%d readings not accepted within the delivery deadline of %v, dropped", len(pending), s.config.DeliveryDeadline)
+	}
+	return fmt.Errorf("max retries (%d) exceeded, %d readings not accepted", s.config.MaxRetries, len(pending))
+}
+
+// unaccepted returns the readings of a batch to resend: those the sink throttled or
+// couldn't store. Readings rejected as invalid are logged and dropped.
+func (s *SensorNode) unaccepted(batch []*pb.SensorData, results []*pb.ReadingResult) ([]*pb.SensorData, error) {
+	if len(results) != len(batch) {
+		return nil, fmt.Errorf("sink returned %d results for %d readings", len(results), len(batch))
+	}
+
+	var resend []*pb.SensorData
+	for i, result := range results {
+		sensorData := batch[i]
+		switch result.Status {
+		case pb.ReadingStatus_READING_STATUS_ACCEPTED:
+			s.delivery.Record(true)
+			s.sequences.Ack(sensorData.SensorName, sensorData.Sequence)
+			s.checkSequence(sensorData.SensorName, result.Sequence)
+		case pb.ReadingStatus_READING_STATUS_DUPLICATE:
+			s.delivery.Record(true)
+			s.sequences.Ack(sensorData.SensorName, sensorData.Sequence)
+		case pb.ReadingStatus_READING_STATUS_INVALID:
+			s.delivery.Record(false)
+			log.Printf("Sink rejected reading of %s at %s, request %s: %s", sensorData.SensorName, sensorData.Timestamp.AsTime().Format(time.RFC3339), sensorData.RequestId, result.Message)
+		default:
+			resend = append(resend, sensorData)
 		}
+	}
+	return resend, nil
+}
+
+// sinkResponse is what SensorDataResponse and SensorDataBatchResponse have in
+// common.
+type sinkResponse interface {
+	GetDraining() bool
+	GetReceivedAt() *timestamppb.Timestamp
+	GetThrottle() *durationpb.Duration
+}
+
+// handleResponse acts on the sink's response to readings sent at sent: it moves
+// traffic off a draining sink, pauses sending when the sink asks to, and logs when
+// the node's clock is off.
+func (s *SensorNode) handleResponse(conn *pool.Conn, resp sinkResponse, sent time.Time) {
+	if resp.GetDraining() {
+		conn.Drain()
+	}
+	if throttle := resp.GetThrottle().AsDuration(); throttle > 0 {
+		s.pacer.Hold(min(throttle, maxThrottle))
+	}
+
+	if receivedAt := resp.GetReceivedAt(); receivedAt != nil {
+		offset := clockOffset(sent, s.config.Clock.Now(), receivedAt.AsTime())
+		off := offset > clockOffsetWarning || offset < -clockOffsetWarning
+		switch {
+		case s.clockOff.Swap(off) == off:
+		case off && offset > 0:
+			log.Printf("Node clock is about %v ahead of the sink's", offset.Round(time.Millisecond))
+		case off:
+			log.Printf("Node clock is about %v behind the sink's", (-offset).Round(time.Millisecond))
+		default:
+			log.Println("Node clock agrees with the sink's again")
+		}
+	}
+
+}
+
+// checkSequence logs when the sink starts its count of a sensor's readings over.
+func (s *SensorNode) checkSequence(sensorName string, sequence uint64) {
+	if sequence == 0 {
+		return
+	}
+	if _, seen := s.acked.LoadOrStore(sensorName, struct{}{}); seen && sequence == 1 {
+		log.Printf("Sink started counting readings of %s over: it restarted or forgot the sensor", sensorName)
+	}
+}
+
+// quotaSlowdown returns how long to pause for the quota, refilled at its limit per
+// second, to hold the share below of its limit again, or 0 when it does.
+func quotaSlowdown(quota interceptor.Quota, below float64) time.Duration {
+	missing := below*float64(quota.Limit) - float64(quota.Remaining)
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / float64(quota.Limit) * float64(time.Second))
+}
+
+// clockOffset estimates how far the node's clock is ahead of the sink's from when
+// a request was sent and its response arrived, by the node's clock, and when the
+// sink received it, by the sink's. The request is assumed to take half the round
+// trip.
+func clockOffset(sent, responded, receivedAt time.Time) time.Duration {
+	return sent.Add(responded.Sub(sent) / 2).Sub(receivedAt)
+}
+
+// sampleAggregate takes n samples from rnd and aggregates them into a histogram
+// with the given upper bounds, or a count/sum summary without bounds.
+func sampleAggregate(rnd *rand.Rand, n int, bounds []float64) *pb.Aggregate {
+	aggregate := &pb.Aggregate{Count: uint64(n)}
+	for _, bound := range bounds {
+		aggregate.Buckets = append(aggregate.Buckets, &pb.HistogramBucket{UpperBound: bound})
+	}
+
+	for i := 0; i < n; i++ {
+		sample := rnd.Float64() * 100
+		aggregate.Sum += sample
+		if j, _ := slices.BinarySearch(bounds, sample); j < len(bounds) {
+			aggregate.Buckets[j].Count++
+		}
+	}
+	return aggregate
+}
 
-		if attempt < maxRetries-1 {
-			delay := s.calculateDelay(attempt, baseDelay, maxDelay)
-			log.Printf("Attempt %d failed: %v. Retrying in %v...", attempt+1, err, delay)
-			time.Sleep(delay)
+// formatValue returns the reading's value for logging, which is hidden once sealed.
+func formatValue(sensorData *pb.SensorData) string {
+	if len(sensorData.SealedPayload) > 0 {
+		return "<sealed>"
+	}
+	if len(sensorData.Values) > 0 {
+		values := make([]string, 0, len(sensorData.Values))
+		for _, name := range slices.Sorted(maps.Keys(sensorData.Values)) {
+			values = append(values, name+":"+strconv.FormatFloat(sensorData.Values[name], 'g', -1, 64))
 		}
+		return "{" + strings.Join(values, ",") + "}"
+	}
+	if a := sensorData.Aggregate; a != nil {
+		return fmt.Sprintf("%d samples, sum %.2f", a.Count, a.Sum)
+	}
+	return strconv.Itoa(int(sensorData.SensorValue))
+}
+
+// formatFailures lists failed calls by code, e.g. "3 (Unavailable: 2, ResourceExhausted: 1)".
+func formatFailures(failed map[codes.Code]uint64) string {
+	var total uint64
+	var byCode []string
+	for _, code := range slices.Sorted(maps.Keys(failed)) {
+		total += failed[code]
+		byCode = append(byCode, fmt.Sprintf("%s: %d", code, failed[code]))
+	}
+	if total == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%d (%s)", total, strings.Join(byCode, ", "))
+}
+
+// describeViolations lists the fields the sink rejected, if it said which.
+func describeViolations(err error) string {
+	var b strings.Builder
+	for _, detail := range status.Convert(err).Details() {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				fmt.Fprintf(&b, "; %s: %s", v.Field, v.Description)
+			}
+		}
+	}
+	return b.String()
+}
+
+// callContext returns the context for a single call to the sink made on behalf of
+// parent, e.g. a trace started for a reading's retries.
+func (s *SensorNode) callContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, s.config.SendTimeout)
+}
+
+// deliveryDeadline returns when the delivery of readings first sent now is given up,
+// zero without -delivery-deadline.
+func (s *SensorNode) deliveryDeadline() time.Time {
+	if s.config.DeliveryDeadline <= 0 {
+		return time.Time{}
 	}
+	return s.config.Clock.Now().Add(s.config.DeliveryDeadline)
+}
 
-	return fmt.Errorf("max retries (%d) exceeded", maxRetries)
+// sendContext is callContext for an attempt to send readings whose delivery is given
+// up at deadline: the attempt ends with it. It returns false once deadline passed.
+func (s *SensorNode) sendContext(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc, bool) {
+	if deadline.IsZero() {
+		ctx, cancel := s.callContext(parent)
+		return ctx, cancel, true
+	}
+	left := deadline.Sub(s.config.Clock.Now())
+	if left <= 0 {
+		return nil, nil, false
+	}
+	ctx, cancel := context.WithTimeout(parent, min(s.config.SendTimeout, left))
+	return ctx, cancel, true
+}
+
+// pastDeadline reports whether readings would miss their delivery deadline by waiting
+// delay for their next attempt.
+func (s *SensorNode) pastDeadline(deadline time.Time, delay time.Duration) bool {
+	return !deadline.IsZero() && !s.config.Clock.Now().Add(delay).Before(deadline)
+}
+
+// retryTime returns the longest the attempts to send a reading take: each running
+// into -send-timeout, with the longest backoff in between. It returns 0 when readings
+// are retried until they are delivered.
+func retryTime(config Config) time.Duration {
+	if config.MaxRetries == 0 {
+		return 0
+	}
+	total := time.Duration(config.MaxRetries) * config.SendTimeout
+	for attempt := range config.MaxRetries - 1 {
+		total += backoffDelay(config, attempt, 1)
+	}
+	return total
+}
+
+// register announces the sensor to the sink and returns the heartbeat interval to
+// use. Registration is best effort: readings are sent even if it fails, and sinks
+// without liveness tracking turn heartbeats off.
+func (s *SensorNode) register() (time.Duration, bool) {
+	ctx, cancel := s.callContext(context.Background())
+	defer cancel()
+
+	conn := s.pool.Pick()
+	resp, err := conn.Client.RegisterSensor(ctx, &pb.RegisterSensorRequest{
+		SensorName: s.config.SensorName,
+		Metadata:   s.config.Metadata,
+	})
+	conn.Report(err)
+
+	if status.Code(err) == codes.Unimplemented {
+		log.Println("Sink does not support registration, heartbeats disabled")
+		return 0, false
+	}
+	if err != nil {
+		log.Printf("Failed to register with sink: %v", err)
+	}
+
+	interval := s.config.HeartbeatInterval
+	if interval == 0 && resp != nil {
+		interval = resp.HeartbeatInterval.AsDuration()
+	}
+	if interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+
+	log.Printf("Registered with sink, heartbeat every %v", interval)
+	return interval, true
+}
+
+func (s *SensorNode) heartbeatLoop(interval time.Duration) {
+	defer s.sends.Done()
+
+	ticker := s.config.Clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			ctx, cancel := s.callContext(context.Background())
+			conn := s.pool.Pick()
+			resp, err := conn.Client.Heartbeat(ctx, &pb.HeartbeatRequest{
+				SensorName: s.config.SensorName,
+				Timestamp:  timestamppb.New(s.config.Clock.Now()),
+			})
+			cancel()
+			conn.Report(err)
+
+			if err != nil {
+				log.Printf("Heartbeat failed: %v", err)
+			} else if resp.Draining {
+				conn.Drain()
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// hostMetricsLoop sends a reading of the host's health every HostMetricsInterval.
+func (s *SensorNode) hostMetricsLoop() {
+	defer s.sends.Done()
+
+	ticker := s.config.Clock.NewTicker(s.config.HostMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			values, err := s.hostMetrics.Sample()
+			if err != nil {
+				log.Printf("Failed to sample host metrics: %v", err)
+				continue
+			}
+
+			tags := maps.Clone(s.config.Tags)
+			if tags == nil {
+				tags = make(map[string]string, 1)
+			}
+			tags["host"] = s.hostname
+			sensorData := &pb.SensorData{
+				SensorName: s.config.SensorName + hostSensorSuffix,
+				Timestamp:  timestamppb.New(s.config.Clock.Now()),
+				Tags:       tags,
+				Values:     values,
+			}
+			if s.sealer != nil {
+				if err := s.sealer.Seal(sensorData); err != nil {
+					log.Printf("Failed to seal host metrics: %v", err)
+					continue
+				}
+			}
+			if err := s.sendWithRetry(sensorData); err != nil {
+				log.Printf("Failed to send host metrics: %v", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
 }
 
 func (s *SensorNode) isRetryableError(err error) bool {
@@ -238,8 +1783,19 @@ func (s *SensorNode) isRetryableError(err error) bool {
 		return true
 	}
 
+	// A message over the sink's size limit fails the same way on every attempt.
+	if st.Code() == codes.ResourceExhausted && strings.Contains(st.Message(), messageTooLarge) {
+		return false
+	}
+	if s.config.RetryCodes != nil {
+		return slices.Contains(s.config.RetryCodes, st.Code())
+	}
+
 	switch st.Code() {
-	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+	case codes.ResourceExhausted:
+		// Rate limits and quotas recover with time.
+		return true
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
 		return true
 	case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied, codes.Unauthenticated:
 		return false
@@ -248,25 +1804,53 @@ func (s *SensorNode) isRetryableError(err error) bool {
 	}
 }
 
-func (s *SensorNode) calculateDelay(attempt int, baseDelay, maxDelay time.Duration) time.Duration {
-	delay := time.Duration(float64(baseDelay) * math.Pow(2, float64(attempt)))
-
-	jitter := time.Duration(rand.Float64()*0.5-0.25) * delay
-	delay += jitter
-
-	if delay > maxDelay {
-		delay = maxDelay
-	}
+// calculateDelay returns the backoff after a reading's failed attempt, counted from
+// 0.
+func (s *SensorNode) calculateDelay(attempt int) time.Duration {
+	return backoffDelay(s.config, attempt, s.random.jitter.Float64()*2-1)
+}
 
-	return delay
+// backoffDelay returns the backoff after the failed attempt, lengthened by jitter,
+// from -1 to 1, times RetryJitter of it, and capped at RetryMaxDelay.
+func backoffDelay(config Config, attempt int, jitter float64) time.Duration {
+	// Past 32 doublings any delay is capped, and more would overflow.
+	delay := float64(config.RetryBaseDelay) * math.Pow(2, float64(min(attempt, 32)))
+	delay += jitter * config.RetryJitter * delay
+	return time.Duration(min(delay, float64(config.RetryMaxDelay)))
 }
 
 func (s *SensorNode) Stop() {
-	close(s.done)
+	s.stopOnce.Do(func() { close(s.done) })
 }
 
 func (s *SensorNode) Close() {
-	if s.conn != nil {
-		s.conn.Close()
+	s.sends.Wait()
+
+	// Readings still waiting for a full batch get a last attempt; like readings whose
+	// attempts ran out, those it doesn't deliver are spooled to resend after restart.
+	s.flushBatch()
+
+	if s.input != nil {
+		s.input.Close()
+	}
+	s.closeSpool()
+	if err := s.sequences.Close(); err != nil {
+		log.Printf("Failed to save sequence numbers: %v", err)
+	}
+	if s.calls != nil {
+		for _, st := range s.calls.Snapshot() {
+			log.Printf("Calls to %s: %d, failed %s, average latency %v", st.Method, st.Calls, formatFailures(st.Failed), (st.Latency / time.Duration(st.Calls)).Round(time.Microsecond))
+		}
+	}
+	if s.metricsServer != nil {
+		s.metricsServer.Close()
+	}
+	if s.pool != nil {
+		if s.pool.Size() > 1 {
+			for _, st := range s.pool.Stats() {
+				log.Printf("Connection %d: state=%s sent=%d failed=%d", st.ID, st.State, st.Sent, st.Failed)
+			}
+		}
+		s.pool.Close()
 	}
 }
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sensor_node/clock"
+	"github.com/sensor_node/fault"
+	"github.com/sensor_node/input"
+	"github.com/sensor_node/interceptor"
+	pb "github.com/sensor_node/proto"
+	"github.com/sensor_node/slo"
+)
+
+func TestValidateConfig_TLS(t *testing.T) {
+	badCA := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(badCA, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	base := Config{
+		Rate: 1, SensorName: "temp", SinkAddr: "localhost:9090", Connections: 1, BatchSize: 1,
+		SendTimeout: 5 * time.Second, ConnectTimeout: 20 * time.Second,
+		MaxRetries: 5, RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: 10 * time.Second,
+	}
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string
+	}{
+		{"plaintext", func(*Config) {}, ""},
+		{"system roots", func(c *Config) { c.UseTLS = true }, ""},
+		{"system roots with SNI", func(c *Config) { c.UseTLS = true; c.TLSServerName = "sink.example.com" }, ""},
+		{"CA without TLS", func(c *Config) { c.CertFile = badCA }, "require -tls"},
+		{"SNI without TLS", func(c *Config) { c.TLSServerName = "sink.example.com" }, "require -tls"},
+		{"unreadable CA", func(c *Config) { c.UseTLS = true; c.CertFile = badCA + ".missing" }, "read CA certificate"},
+		{"invalid CA", func(c *Config) { c.UseTLS = true; c.CertFile = badCA }, "append CA certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			tt.modify(&config)
+
+			err := validateConfig(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateConfig() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateConfig() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadTLSCredentials_ServerName(t *testing.T) {
+	creds, err := loadTLSCredentials(Config{UseTLS: true, TLSServerName: "sink.example.com"})
+	if err != nil {
+		t.Fatalf("loadTLSCredentials() error = %v", err)
+	}
+	if got := creds.Info().ServerName; got != "sink.example.com" {
+		t.Errorf("ServerName = %q, want the -tls-sni value", got)
+	}
+
+	// Without -tls-sni gRPC verifies the host of the sink address.
+	creds, err = loadTLSCredentials(Config{UseTLS: true})
+	if err != nil {
+		t.Fatalf("loadTLSCredentials() error = %v", err)
+	}
+	if got := creds.Info().ServerName; got != "" {
+		t.Errorf("ServerName = %q, want empty", got)
+	}
+}
+
+func TestValidateConfig_Reading(t *testing.T) {
+	base := Config{
+		Rate: 1, SensorName: "vibration", SinkAddr: "localhost:9090", Connections: 1, BatchSize: 1,
+		SendTimeout: 5 * time.Second, ConnectTimeout: 20 * time.Second,
+		MaxRetries: 5, RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: 10 * time.Second,
+	}
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string
+	}{
+		{"summary", func(c *Config) { c.AggregateSamples = 1000 }, ""},
+		{"histogram", func(c *Config) { c.AggregateSamples = 1000; c.HistogramBuckets = []float64{10, 50, 90} }, ""},
+		{"buckets without samples", func(c *Config) { c.HistogramBuckets = []float64{10} }, "requires -aggregate-samples"},
+		{"fire-and-forget", func(c *Config) { c.FireAndForget = true }, ""},
+		{"critical fire-and-forget", func(c *Config) { c.FireAndForget = true; c.Critical = true }, "-critical"},
+		{"faults on polled input", func(c *Config) { c.Faults = fault.Faults{Spike: 0.1}; c.Input = "exec"; c.InputCommand = "echo 1" }, "can't be combined with -input"},
+		{"buckets out of order", func(c *Config) { c.AggregateSamples = 10; c.HistogramBuckets = []float64{50, 10} }, "ascending"},
+		{"duplicate bucket", func(c *Config) { c.AggregateSamples = 10; c.HistogramBuckets = []float64{10, 10} }, "ascending"},
+		{"with metrics", func(c *Config) { c.AggregateSamples = 10; c.Metrics = []string{"x"} }, "-metrics"},
+		{"location", func(c *Config) { c.Location = []float64{52.2, 21.0, 110} }, ""},
+		{"location out of range", func(c *Config) { c.Location = []float64{91, 21.0} }, "-location"},
+		{"attachment every reading", func(c *Config) { c.AttachmentFile = "main.go"; c.AttachmentEvery = 1 }, ""},
+		{"attachment never sent", func(c *Config) { c.AttachmentFile = "main.go" }, "-attachment-every"},
+		{"missing attachment", func(c *Config) { c.AttachmentFile = "missing.bin"; c.AttachmentEvery = 1 }, "attachment"},
+		{"host metrics", func(c *Config) { c.ReportHostMetrics = true; c.HostMetricsInterval = time.Minute }, ""},
+		{"zero host metrics interval", func(c *Config) { c.ReportHostMetrics = true }, "-host-metrics-interval"},
+		{"batches", func(c *Config) { c.BatchSize = 100 }, ""},
+		{"batch over the sink's limit", func(c *Config) { c.BatchSize = 1001 }, "-batch-size"},
+		{"delivery deadline", func(c *Config) { c.DeliveryDeadline = time.Minute }, ""},
+		{"zero send timeout", func(c *Config) { c.SendTimeout = 0 }, "-send-timeout"},
+		{"negative delivery deadline", func(c *Config) { c.DeliveryDeadline = -time.Second }, "-delivery-deadline"},
+		{"delivery deadline below send timeout", func(c *Config) { c.DeliveryDeadline = time.Second }, "at least -send-timeout"},
+		{"fire-and-forget delivery deadline", func(c *Config) { c.FireAndForget = true; c.DeliveryDeadline = time.Minute }, "-fire-and-forget"},
+		{"retry until delivered", func(c *Config) { c.MaxRetries = 0 }, ""},
+		{"negative retries", func(c *Config) { c.MaxRetries = -1 }, "-max-retries"},
+		{"base delay over max delay", func(c *Config) { c.RetryBaseDelay = time.Minute }, "-retry-base-delay"},
+		{"jitter over 1", func(c *Config) { c.RetryJitter = 1.5 }, "-retry-jitter"},
+		{"spool", func(c *Config) { c.SpoolDir = "spool"; c.SpoolMaxBytes = 1 << 20 }, ""},
+		{"spool without room", func(c *Config) { c.SpoolDir = "spool" }, "-spool-max-bytes"},
+		{"fire-and-forget spool", func(c *Config) { c.SpoolDir = "spool"; c.SpoolMaxBytes = 1 << 20; c.FireAndForget = true }, "-fire-and-forget"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			tt.modify(&config)
+
+			err := validateConfig(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateConfig() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateConfig() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfig_Input(t *testing.T) {
+	mapping := filepath.Join(t.TempDir(), "modbus.yaml")
+	if err := os.WriteFile(mapping, []byte("registers:\n- {sensor: boiler-temp, address: 100}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	base := Config{
+		Rate: 1, SensorName: "plc-1", SinkAddr: "localhost:9090", Connections: 1, BatchSize: 1,
+		SendTimeout: 5 * time.Second, ConnectTimeout: 20 * time.Second,
+		MaxRetries: 5, RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: 10 * time.Second,
+		PollInterval: time.Second, PollTimeout: time.Second,
+	}
+	modbus := func(c *Config) { c.Input = "modbus"; c.InputMapping = mapping; c.ModbusAddr = "10.0.0.7:502" }
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr string
+	}{
+		{"modbus", modbus, ""},
+		{"file", func(c *Config) { c.Input = "file"; c.InputFile = mapping; c.InputFormat = "csv" }, ""},
+		{"stdin", func(c *Config) { c.Input = "file"; c.InputFile = "-"; c.InputFormat = "json" }, ""},
+		{"unknown input", func(c *Config) { modbus(c); c.Input = "opcua" }, "-input must be"},
+		{"mapping without input", func(c *Config) { c.InputMapping = mapping }, "require -input=modbus"},
+		{"mapping with file input", func(c *Config) { modbus(c); c.Input = "file"; c.InputFile = "-" }, "require -input=modbus"},
+		{"missing mapping", func(c *Config) { modbus(c); c.InputMapping = "" }, "-input-mapping"},
+		{"missing address", func(c *Config) { modbus(c); c.ModbusAddr = "" }, "-modbus-addr"},
+		{"file without input", func(c *Config) { c.InputFile = "-" }, "requires -input=file"},
+		{"missing file", func(c *Config) { c.Input = "file"; c.InputFormat = "json" }, "-input-file"},
+		{"unknown format", func(c *Config) { c.Input = "file"; c.InputFile = "-"; c.InputFormat = "xml" }, "-input-format"},
+		{"exec", func(c *Config) { c.Input = "exec"; c.InputCommand = "cat /sys/class/thermal/thermal_zone0/temp" }, ""},
+		{"missing command", func(c *Config) { c.Input = "exec" }, "-input-command"},
+		{"command without input", func(c *Config) { c.InputCommand = "true" }, "requires -input=exec"},
+		{"zero poll timeout", func(c *Config) { modbus(c); c.PollTimeout = -1 }, "-poll-timeout"},
+		{"nonexistent file", func(c *Config) { c.Input = "file"; c.InputFile = mapping + ".missing"; c.InputFormat = "json" }, "no such file"},
+		{"zero poll interval", func(c *Config) { modbus(c); c.PollInterval = 0 }, "-poll-interval"},
+		{"with metrics", func(c *Config) { modbus(c); c.Metrics = []string{"x"} }, "-metrics"},
+		{"unreadable mapping", func(c *Config) { modbus(c); c.InputMapping += ".missing" }, "read mapping file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			tt.modify(&config)
+
+			err := validateConfig(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateConfig() error = %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateConfig() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSensorDataFromReading(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	taken := now.Add(-time.Minute)
+	tags := map[string]string{"site": "b7"}
+
+	tests := []struct {
+		name    string
+		reading input.Reading
+		want    *pb.SensorData
+		wantErr bool
+	}{
+		{
+			"single value",
+			input.Reading{SensorName: "boiler", Value: 71.6},
+			&pb.SensorData{SensorName: "boiler", SensorValue: 72, Timestamp: timestamppb.New(now), Tags: tags},
+			false,
+		},
+		{
+			"values with time and tags",
+			input.Reading{SensorName: "meter", Time: taken, Values: map[string]float64{"voltage": 230.5, "current": math.NaN()}, Tags: map[string]string{"phase": "L1"}},
+			&pb.SensorData{SensorName: "meter", Values: map[string]float64{"voltage": 230.5}, Timestamp: timestamppb.New(taken), Tags: map[string]string{"site": "b7", "phase": "L1"}},
+			false,
+		},
+		{"out of range", input.Reading{SensorName: "counter", Value: math.MaxUint32}, nil, true},
+		{"not finite", input.Reading{SensorName: "flow", Value: math.Inf(1)}, nil, true},
+		{"no finite values", input.Reading{SensorName: "flow", Values: map[string]float64{"rate": math.NaN()}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sensorDataFromReading(tt.reading, now, tags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sensorDataFromReading() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !proto.Equal(got, tt.want) {
+				t.Errorf("sensorDataFromReading() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if len(tags) != 1 {
+		t.Errorf("node tags = %v, want them unchanged", tags)
+	}
+}
+
+func TestSampleAggregate(t *testing.T) {
+	aggregate := sampleAggregate(rand.New(rand.NewSource(1)), 1000, []float64{25, 50, 75})
+	if aggregate.Count != 1000 || aggregate.Sum <= 0 || aggregate.Sum >= 100*1000 {
+		t.Fatalf("sampleAggregate() = %v, want 1000 samples in [0,100)", aggregate)
+	}
+
+	var bucketed uint64
+	for i, b := range aggregate.Buckets {
+		bucketed += b.Count
+		if b.Count == 0 {
+			t.Errorf("bucket %d is empty, want about a quarter of the samples", i)
+		}
+	}
+	if bucketed > aggregate.Count {
+		t.Errorf("buckets hold %d samples, more than the count", bucketed)
+	}
+
+	if summary := sampleAggregate(rand.New(rand.NewSource(1)), 10, nil); summary.Count != 10 || len(summary.Buckets) != 0 {
+		t.Errorf("sampleAggregate() without bounds = %v, want a summary of 10 samples", summary)
+	}
+}
+
+func TestClockOffset(t *testing.T) {
+	sent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		roundTrip  time.Duration
+		receivedAt time.Time
+		want       time.Duration
+	}{
+		{"in line", 200 * time.Millisecond, sent.Add(100 * time.Millisecond), 0},
+		{"node ahead", 200 * time.Millisecond, sent.Add(-4900 * time.Millisecond), 5 * time.Second},
+		{"node behind", 0, sent.Add(time.Minute), -time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clockOffset(sent, sent.Add(tt.roundTrip), tt.receivedAt); got != tt.want {
+				t.Errorf("clockOffset() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotaSlowdown(t *testing.T) {
+	tests := []struct {
+		name  string
+		quota interceptor.Quota
+		want  time.Duration
+	}{
+		{"above the threshold", interceptor.Quota{Limit: 1000, Remaining: 500}, 0},
+		{"below the threshold", interceptor.Quota{Limit: 1000, Remaining: 100}, 100 * time.Millisecond},
+		{"exhausted", interceptor.Quota{Limit: 1000}, 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quotaSlowdown(tt.quota, 0.2); got != tt.want {
+				t.Errorf("quotaSlowdown() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnaccepted(t *testing.T) {
+	batch := make([]*pb.SensorData, 5)
+	for i := range batch {
+		batch[i] = &pb.SensorData{SensorName: fmt.Sprintf("sensor-%d", i), Timestamp: timestamppb.Now()}
+	}
+	results := []*pb.ReadingResult{
+		{Status: pb.ReadingStatus_READING_STATUS_ACCEPTED, Sequence: 1},
+		{Status: pb.ReadingStatus_READING_STATUS_DUPLICATE},
+		{Status: pb.ReadingStatus_READING_STATUS_INVALID, Message: "sensor name is too long"},
+		{Status: pb.ReadingStatus_READING_STATUS_THROTTLED},
+		{Status: pb.ReadingStatus_READING_STATUS_UNAVAILABLE},
+	}
+
+	s := &SensorNode{delivery: slo.NewTracker(clock.Real)}
+	resend, err := s.unaccepted(batch, results)
+	if err != nil {
+		t.Fatalf("unaccepted() error = %v", err)
+	}
+	if want := batch[3:]; !slices.Equal(resend, want) {
+		t.Errorf("unaccepted() = %v, want the throttled and unavailable readings", resend)
+	}
+	if attempted, delivered := s.delivery.Totals(); attempted != 3 || delivered != 2 {
+		t.Errorf("delivery totals = %d attempted, %d delivered, want the accepted and duplicate of 3 delivered", attempted, delivered)
+	}
+
+	if _, err := s.unaccepted(batch, results[:4]); err == nil {
+		t.Error("unaccepted() with a result missing error = nil, want an error")
+	}
+}
+
+func TestDeliveryDeadline(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC))
+	s := &SensorNode{config: Config{SendTimeout: 5 * time.Second, DeliveryDeadline: 12 * time.Second, Clock: clk}}
+
+	deadline := s.deliveryDeadline()
+	clk.Advance(9 * time.Second)
+	ctx, cancel, ok := s.sendContext(context.Background(), deadline)
+	if !ok {
+		t.Fatal("sendContext() before the deadline = false, want true")
+	}
+	// The last attempt only gets the time left.
+	if d, _ := ctx.Deadline(); time.Until(d) > 3*time.Second {
+		t.Errorf("attempt deadline in %v, want at most the 3s left", time.Until(d))
+	}
+	cancel()
+	if !s.pastDeadline(deadline, 3*time.Second) || s.pastDeadline(deadline, time.Second) {
+		t.Error("pastDeadline() wants to back off past the deadline, or not within it")
+	}
+
+	clk.Advance(3 * time.Second)
+	if _, _, ok := s.sendContext(context.Background(), deadline); ok {
+		t.Error("sendContext() at the deadline = true, want false")
+	}
+
+	// Without -delivery-deadline attempts run for -send-timeout until retries run out.
+	s.config.DeliveryDeadline = 0
+	if deadline := s.deliveryDeadline(); !deadline.IsZero() || s.pastDeadline(deadline, time.Hour) {
+		t.Errorf("deliveryDeadline() = %v, want none", deadline)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	config := Config{RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second, RetryJitter: 0.5}
+	if got := backoffDelay(config, 2, 1); got != 600*time.Millisecond {
+		t.Errorf("backoffDelay(2, longest) = %v, want 400ms lengthened by half", got)
+	}
+	if got := backoffDelay(config, 2, -1); got != 200*time.Millisecond {
+		t.Errorf("backoffDelay(2, shortest) = %v, want 400ms shortened by half", got)
+	}
+	if got := backoffDelay(config, 10, 0); got != time.Second {
+		t.Errorf("backoffDelay(10) = %v, want capped at 1s", got)
+	}
+
+	retryCodes, err := parseCodes("unavailable, DEADLINE_EXCEEDED")
+	if err != nil || !slices.Equal(retryCodes, []codes.Code{codes.Unavailable, codes.DeadlineExceeded}) {
+		t.Fatalf("parseCodes() = %v, %v, want Unavailable and DeadlineExceeded", retryCodes, err)
+	}
+	if _, err := parseCodes("UNAVAILABLE,TIMEOUT"); err == nil {
+		t.Error("parseCodes() of an unknown code error = nil, want an error")
+	}
+
+	tests := []struct {
+		err        error
+		byDefault  bool
+		configured bool
+	}{
+		{status.Error(codes.Unavailable, "sink down"), true, true},
+		{status.Error(codes.Internal, "sink bug"), true, false},
+		{status.Error(codes.InvalidArgument, "bad reading"), false, false},
+		{status.Error(codes.ResourceExhausted, "rate limited"), true, false},
+		{status.Error(codes.ResourceExhausted, "grpc: received message larger than max"), false, false},
+		{errors.New("connection reset"), true, true},
+	}
+	for _, tt := range tests {
+		s := &SensorNode{}
+		if got := s.isRetryableError(tt.err); got != tt.byDefault {
+			t.Errorf("isRetryableError(%v) = %v, want %v", tt.err, got, tt.byDefault)
+		}
+		s.config.RetryCodes = retryCodes
+		if got := s.isRetryableError(tt.err); got != tt.configured {
+			t.Errorf("isRetryableError(%v) with -retry-codes = %v, want %v", tt.err, got, tt.configured)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Package mesh lets the sensor node reach the sink through a proxyless gRPC service
+// mesh (Istio, Traffic Director): xds:/// targets are resolved, balanced and secured
+// with the routing and mTLS settings of an xDS control plane.
+//
+// xDS support pulls in large dependencies, so it is only compiled into nodes built
+// with -tags xds; other builds reject xds:/// targets.
+package mesh
+
+import "strings"
+
+// Scheme is the target scheme resolved through xDS.
+const Scheme = "xds"
+
+// IsXDSTarget reports whether target is an xds:/// address.
+func IsXDSTarget(target string) bool {
+	return strings.HasPrefix(target, Scheme+":")
+}
@@ -0,0 +1,37 @@
+package mesh
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestIsXDSTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"xds:///telemetry-sink", true},
+		{"xds://control-plane/telemetry-sink", true},
+		{"dns:///telemetry-sink:9090", false},
+		{"localhost:9090", false},
+		{"unix:///var/run/telemetry.sock", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsXDSTarget(tt.target); got != tt.want {
+			t.Errorf("IsXDSTarget(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestDialOptions(t *testing.T) {
+	opts, err := DialOptions(insecure.NewCredentials())
+	if Supported {
+		if err != nil || len(opts) == 0 {
+			t.Errorf("DialOptions() = %v, %v, want options", opts, err)
+		}
+	} else if err == nil {
+		t.Error("DialOptions() without xDS support error = nil")
+	}
+}
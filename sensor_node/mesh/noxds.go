@@ -0,0 +1,18 @@
+//go:build !xds
+
+package mesh
+
+import (
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Supported reports whether this build can dial xds:/// targets.
+const Supported = false
+
+// DialOptions fails, as this build has no xDS support.
+func DialOptions(credentials.TransportCredentials) ([]grpc.DialOption, error) {
+	return nil, errors.New("xds:/// targets require a sensor node built with -tags xds")
+}
@@ -0,0 +1,28 @@
+//go:build xds
+
+package mesh
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	xdscreds "google.golang.org/grpc/credentials/xds"
+	_ "google.golang.org/grpc/xds" // registers the xds resolver and balancers
+)
+
+// Supported reports whether this build can dial xds:/// targets.
+const Supported = true
+
+// DialOptions returns the options for dialing an xds:/// target. Connections use the
+// mTLS certificates handed out by the control plane, or fallback when it configures
+// none. The control plane is found through the bootstrap file named by the
+// GRPC_XDS_BOOTSTRAP environment variable.
+func DialOptions(fallback credentials.TransportCredentials) ([]grpc.DialOption, error) {
+	creds, err := xdscreds.NewClientCredentials(xdscreds.ClientOptions{FallbackCreds: fallback})
+	if err != nil {
+		return nil, fmt.Errorf("create xDS credentials: %w", err)
+	}
+
+	return []grpc.DialOption{grpc.WithTransportCredentials(creds)}, nil
+}
@@ -0,0 +1,90 @@
+package pacer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sensor_node/clock"
+)
+
+// Pacer spaces outgoing sends evenly so that neither the message rate nor the byte
+// rate exceeds its limit. Instead of a token bucket that allows bursts, every send
+// reserves a slot on a virtual schedule and waits until that slot starts.
+type Pacer struct {
+	maxMsgsPerSec  float64
+	maxBytesPerSec float64
+	clock          clock.Clock
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// New creates a pacer scheduling on clk. A limit of zero disables that dimension;
+// with both limits zero the pacer never delays.
+func New(maxMsgsPerSec, maxBytesPerSec float64, clk clock.Clock) *Pacer {
+	return &Pacer{
+		maxMsgsPerSec:  maxMsgsPerSec,
+		maxBytesPerSec: maxBytesPerSec,
+		clock:          clk,
+	}
+}
+
+// Enabled reports whether the pacer limits anything.
+func (p *Pacer) Enabled() bool {
+	return p.maxMsgsPerSec > 0 || p.maxBytesPerSec > 0
+}
+
+// Reserve books a slot for a message of the given size and returns how long the
+// caller must wait before sending it.
+func (p *Pacer) Reserve(bytes int) time.Duration {
+	cost := p.cost(bytes)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+	start := p.next
+	if start.Before(now) {
+		start = now
+	}
+	p.next = start.Add(cost)
+
+	return start.Sub(now)
+}
+
+// Hold delays the next send until d from now, e.g. when the sink asks the node to
+// slow down, also when the pacer limits nothing. Sends reserved later are scheduled
+// after it.
+func (p *Pacer) Hold(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if until := p.clock.Now().Add(d); until.After(p.next) {
+		p.next = until
+	}
+}
+
+// Wait blocks until a message of the given size may be sent. It returns false if
+// done is closed first.
+func (p *Pacer) Wait(done <-chan struct{}, bytes int) bool {
+	delay := p.Reserve(bytes)
+	if delay <= 0 {
+		return true
+	}
+
+	return clock.Sleep(p.clock, delay, done)
+}
+
+func (p *Pacer) cost(bytes int) time.Duration {
+	var seconds float64
+	if p.maxMsgsPerSec > 0 {
+		seconds = 1 / p.maxMsgsPerSec
+	}
+	if p.maxBytesPerSec > 0 {
+		if byteSeconds := float64(bytes) / p.maxBytesPerSec; byteSeconds > seconds {
+			seconds = byteSeconds
+		}
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
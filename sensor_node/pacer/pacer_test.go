@@ -0,0 +1,112 @@
+package pacer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sensor_node/clock"
+)
+
+func TestPacer_Reserve(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxMsgsPerSec  float64
+		maxBytesPerSec float64
+		bytes          int
+		expectedGap    time.Duration
+	}{
+		{
+			name:        "unlimited",
+			bytes:       100,
+			expectedGap: 0,
+		},
+		{
+			name:          "message rate only",
+			maxMsgsPerSec: 10,
+			bytes:         100,
+			expectedGap:   100 * time.Millisecond,
+		},
+		{
+			name:           "byte rate only",
+			maxBytesPerSec: 1000,
+			bytes:          250,
+			expectedGap:    250 * time.Millisecond,
+		},
+		{
+			name:           "byte rate dominates",
+			maxMsgsPerSec:  100,
+			maxBytesPerSec: 1000,
+			bytes:          500,
+			expectedGap:    500 * time.Millisecond,
+		},
+		{
+			name:           "message rate dominates",
+			maxMsgsPerSec:  2,
+			maxBytesPerSec: 1000,
+			bytes:          10,
+			expectedGap:    500 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(tt.maxMsgsPerSec, tt.maxBytesPerSec, clock.NewFake(time.Unix(0, 0)))
+
+			if first := p.Reserve(tt.bytes); first != 0 {
+				t.Errorf("first Reserve() = %v, want 0", first)
+			}
+
+			if second := p.Reserve(tt.bytes); second != tt.expectedGap {
+				t.Errorf("second Reserve() = %v, want %v", second, tt.expectedGap)
+			}
+		})
+	}
+}
+
+func TestPacer_WaitStopsOnDone(t *testing.T) {
+	p := New(0.1, 0, clock.NewFake(time.Unix(0, 0)))
+	done := make(chan struct{})
+
+	if !p.Wait(done, 1) {
+		t.Fatal("first Wait() should not block")
+	}
+
+	close(done)
+	if p.Wait(done, 1) {
+		t.Error("Wait() should return false once done is closed")
+	}
+}
+
+func TestPacer_IdleTimeIsNotBanked(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	p := New(10, 0, clk)
+
+	p.Reserve(1)
+	clk.Advance(time.Minute)
+
+	if delay := p.Reserve(1); delay != 0 {
+		t.Errorf("Reserve() after idling = %v, want 0", delay)
+	}
+	if delay := p.Reserve(1); delay != 100*time.Millisecond {
+		t.Errorf("next Reserve() = %v, want 100ms, without a burst for the idle minute", delay)
+	}
+}
+
+func TestPacer_Hold(t *testing.T) {
+	clk := clock.NewFake(time.Unix(0, 0))
+	p := New(0, 0, clk)
+
+	p.Hold(2 * time.Second)
+	if delay := p.Reserve(1); delay != 2*time.Second {
+		t.Errorf("Reserve() after Hold() = %v, want 2s", delay)
+	}
+	if delay := p.Reserve(1); delay != 2*time.Second {
+		t.Errorf("second Reserve() = %v, want 2s, as an unlimited pacer sends the held messages together", delay)
+	}
+
+	clk.Advance(3 * time.Second)
+	p.Hold(-time.Second)
+	if delay := p.Reserve(1); delay != 0 {
+		t.Errorf("Reserve() after the hold passed = %v, want 0", delay)
+	}
+}
@@ -0,0 +1,47 @@
+package pacer
+
+import "time"
+
+// minRampFraction is the share of the target rate used at the very start of a ramp-up.
+const minRampFraction = 0.1
+
+// Ramp grows the send rate linearly from a fraction of the target up to the full
+// target over the ramp-up period, so nodes restarted together don't hit the sink at
+// full speed at once.
+type Ramp struct {
+	target float64
+	period time.Duration
+	start  time.Time
+}
+
+// NewRamp starts a ramp towards target messages per second at start. A zero period
+// means no ramp-up: the target rate applies immediately.
+func NewRamp(target float64, period time.Duration, start time.Time) *Ramp {
+	return &Ramp{
+		target: target,
+		period: period,
+		start:  start,
+	}
+}
+
+// Rate returns the send rate in messages per second at the given time.
+func (r *Ramp) Rate(now time.Time) float64 {
+	if r.period <= 0 {
+		return r.target
+	}
+
+	fraction := float64(now.Sub(r.start)) / float64(r.period)
+	if fraction < minRampFraction {
+		fraction = minRampFraction
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+
+	return r.target * fraction
+}
+
+// Interval returns the delay until the next send at the given time.
+func (r *Ramp) Interval(now time.Time) time.Duration {
+	return time.Duration(float64(time.Second) / r.Rate(now))
+}
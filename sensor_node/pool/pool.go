@@ -0,0 +1,267 @@
+package pool
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sensor_node/proto"
+)
+
+const (
+	// unhealthyAfter consecutive transport failures take a connection out of rotation.
+	unhealthyAfter = 3
+	// unhealthyCooldown is how long an unhealthy connection is skipped before retrying it.
+	unhealthyCooldown = 10 * time.Second
+	// drainCooldown is how long a connection to a draining sink is skipped. By then the
+	// sink has closed it and the connection redials.
+	drainCooldown = 30 * time.Second
+	// retireAfter is how long the connections replaced by Redial are kept open for the
+	// calls still using them.
+	retireAfter = 30 * time.Second
+)
+
+// Conn is a single connection of the pool with its health state.
+type Conn struct {
+	ID     int
+	Client pb.TelemetryServiceClient
+	cc     *grpc.ClientConn
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+	unhealthyUntil      time.Time
+	drainingUntil       time.Time
+	sent                uint64
+	failed              uint64
+}
+
+// Pool keeps several independent HTTP/2 connections to the sink and spreads calls
+// across them round-robin, skipping connections that keep failing.
+type Pool struct {
+	conns   atomic.Pointer[[]*Conn]
+	targets atomic.Pointer[[]string]
+	next    atomic.Uint64
+	opts    []grpc.DialOption
+	size    int
+	redial  sync.Mutex
+}
+
+// Dial opens size connections to target.
+func Dial(target string, size int, opts ...grpc.DialOption) (*Pool, error) {
+	if size < 1 {
+		size = 1
+	}
+
+	p := &Pool{opts: opts, size: size}
+	targets := []string{target}
+	conns, err := p.dial(targets, size)
+	if err != nil {
+		return nil, err
+	}
+	p.conns.Store(&conns)
+	p.targets.Store(&targets)
+
+	return p, nil
+}
+
+// Target returns addr as a gRPC target. The zone of an IPv6 literal such as
+// [fe80::1%eth0]:9090 is escaped, as gRPC parses targets as URLs.
+func Target(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || strings.Contains(host, "%25") {
+		return addr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil || ip.Zone() == "" {
+		return addr
+	}
+	return net.JoinHostPort(strings.Replace(host, "%", "%25", 1), port)
+}
+
+// dial opens size connections spread across targets.
+func (p *Pool) dial(targets []string, size int) ([]*Conn, error) {
+	conns := make([]*Conn, 0, size)
+	for i := 0; i < size; i++ {
+		cc, err := grpc.Dial(Target(targets[i%len(targets)]), p.opts...)
+		if err != nil {
+			for _, c := range conns {
+				c.cc.Close()
+			}
+			return nil, fmt.Errorf("dial connection %d: %w", i, err)
+		}
+		conns = append(conns, &Conn{
+			ID:     i,
+			Client: pb.NewTelemetryServiceClient(cc),
+			cc:     cc,
+		})
+	}
+	return conns, nil
+}
+
+// Redial replaces the pool's connections with as many spread across targets, and at
+// least one per target, e.g. when the sink redirects the node. The replaced
+// connections are closed once the calls in flight on them had time to finish.
+// Redialing the current targets is a no-op, reported as false.
+func (p *Pool) Redial(targets []string) (bool, error) {
+	if len(targets) == 0 {
+		return false, fmt.Errorf("no targets to redial")
+	}
+
+	p.redial.Lock()
+	defer p.redial.Unlock()
+
+	if slices.Equal(*p.targets.Load(), targets) {
+		return false, nil
+	}
+	old := *p.conns.Load()
+	conns, err := p.dial(targets, max(p.size, len(targets)))
+	if err != nil {
+		return false, err
+	}
+	targets = slices.Clone(targets)
+	p.conns.Store(&conns)
+	p.targets.Store(&targets)
+
+	time.AfterFunc(retireAfter, func() {
+		for _, c := range old {
+			c.cc.Close()
+		}
+	})
+	return true, nil
+}
+
+// Targets returns the addresses the pool's connections are spread across.
+func (p *Pool) Targets() []string {
+	return *p.targets.Load()
+}
+
+// Size returns the number of connections in the pool.
+func (p *Pool) Size() int {
+	return len(*p.conns.Load())
+}
+
+// Pick returns the next healthy connection. If every connection is unhealthy the
+// next one in rotation is returned anyway, so sends keep probing the sink.
+func (p *Pool) Pick() *Conn {
+	conns := *p.conns.Load()
+	n := uint64(len(conns))
+	start := p.next.Add(1)
+
+	for i := uint64(0); i < n; i++ {
+		conn := conns[(start+i)%n]
+		if conn.healthy() {
+			return conn
+		}
+	}
+
+	return conns[start%n]
+}
+
+// Report records the outcome of a call made on the connection. Only transport-level
+// failures count against its health; application errors such as rate limiting mean
+// the connection itself works.
+func (c *Conn) Report(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sent++
+	if err == nil || !isTransportError(err) {
+		if c.consecutiveFailures >= unhealthyAfter {
+			log.Printf("Connection %d recovered", c.ID)
+		}
+		c.consecutiveFailures = 0
+		c.unhealthyUntil = time.Time{}
+		return
+	}
+
+	c.failed++
+	c.consecutiveFailures++
+	if c.consecutiveFailures >= unhealthyAfter {
+		c.unhealthyUntil = time.Now().Add(unhealthyCooldown)
+		if c.consecutiveFailures == unhealthyAfter {
+			log.Printf("Connection %d marked unhealthy after %d failures: %v", c.ID, c.consecutiveFailures, err)
+		}
+	}
+}
+
+// Drain takes the connection out of rotation because the sink said it is shutting down,
+// so calls move to the other connections before this one closes. Successful calls
+// don't bring it back early.
+func (c *Conn) Drain() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.After(c.drainingUntil) {
+		log.Printf("Sink is draining connection %d, moving calls to other connections", c.ID)
+	}
+	c.drainingUntil = now.Add(drainCooldown)
+}
+
+func (c *Conn) healthy() bool {
+	if c.cc.GetState() == connectivity.TransientFailure {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	return (c.unhealthyUntil.IsZero() || now.After(c.unhealthyUntil)) && now.After(c.drainingUntil)
+}
+
+// Stats is a snapshot of a connection's health.
+type Stats struct {
+	ID                  int
+	State               connectivity.State
+	Sent                uint64
+	Failed              uint64
+	ConsecutiveFailures int
+}
+
+// Stats returns a snapshot of every connection's health.
+func (p *Pool) Stats() []Stats {
+	conns := *p.conns.Load()
+	stats := make([]Stats, 0, len(conns))
+	for _, c := range conns {
+		c.mu.Lock()
+		stats = append(stats, Stats{
+			ID:                  c.ID,
+			State:               c.cc.GetState(),
+			Sent:                c.sent,
+			Failed:              c.failed,
+			ConsecutiveFailures: c.consecutiveFailures,
+		})
+		c.mu.Unlock()
+	}
+	return stats
+}
+
+func (p *Pool) Close() error {
+	var firstErr error
+	for _, c := range *p.conns.Load() {
+		if err := c.cc.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func isTransportError(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return true
+	}
+	return st.Code() == codes.Unavailable || st.Code() == codes.DeadlineExceeded
+}
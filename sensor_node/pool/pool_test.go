@@ -0,0 +1,56 @@
+package pool
+
+import (
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestTarget(t *testing.T) {
+	for _, tc := range []struct {
+		addr string
+		want string
+	}{
+		{"localhost:9090", "localhost:9090"},
+		{"[2001:db8::7]:9090", "[2001:db8::7]:9090"},
+		{"[fe80::1%eth0]:9090", "[fe80::1%25eth0]:9090"},
+		{"[fe80::1%25eth0]:9090", "[fe80::1%25eth0]:9090"},
+		{"dns:///sink.example:9090", "dns:///sink.example:9090"},
+		{"unix:///run/sink.sock", "unix:///run/sink.sock"},
+	} {
+		if got := Target(tc.addr); got != tc.want {
+			t.Errorf("Target(%s) = %s, want %s", tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestRedial_SpreadsAcrossTargets(t *testing.T) {
+	p, err := Dial("localhost:9090", 2, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer p.Close()
+
+	targets := []string{"sink-a:9090", "sink-b:9090", "sink-c:9090"}
+	if changed, err := p.Redial(targets); err != nil || !changed {
+		t.Fatalf("Redial() = %v, %v, want true, nil", changed, err)
+	}
+	dialed := map[string]bool{}
+	for _, c := range *p.conns.Load() {
+		dialed[c.cc.Target()] = true
+	}
+	for _, target := range targets {
+		if !dialed[target] {
+			t.Errorf("no connection to %s after redialing %d connections to %v", target, 2, targets)
+		}
+	}
+
+	// Redialing fewer targets goes back to the configured size.
+	if _, err := p.Redial([]string{"sink-a:9090"}); err != nil {
+		t.Fatalf("Redial() error = %v", err)
+	}
+	if size := p.Size(); size != 2 {
+		t.Errorf("Size() = %d after redialing one target, want 2", size)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// randomStreams are the random sources of a node, derived from its seed. Generated
+// values, jitter and faults each draw from their own stream, so retries, which
+// depend on the sink, don't shift the values and faults a seed produces.
+type randomStreams struct {
+	values *rand.Rand
+	jitter *rand.Rand
+	faults int64 // seed of the fault injector
+}
+
+// newRandomStreams derives the streams from seed.
+func newRandomStreams(seed int64) randomStreams {
+	root := rand.New(rand.NewSource(seed))
+	return randomStreams{
+		values: newRand(root.Int63()),
+		jitter: newRand(root.Int63()),
+		faults: root.Int63(),
+	}
+}
+
+// newRand returns a source seeded with seed that, unlike those of rand.New, is safe
+// for concurrent use.
+func newRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
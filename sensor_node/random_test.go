@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sensor_node/clock"
+	"github.com/sensor_node/fault"
+	pb "github.com/sensor_node/proto"
+)
+
+func TestSeed(t *testing.T) {
+	// generate returns the readings a node seeded with seed generates, with or
+	// without retries between them.
+	generate := func(seed int64, retries bool) []string {
+		clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		random := newRandomStreams(seed)
+		s := &SensorNode{
+			config: Config{SensorName: "weather-01", Metrics: []string{"temperature", "humidity"}},
+			random: random,
+			faults: fault.NewInjector(fault.Faults{Spike: 0.2, Stuck: 0.1, StuckFor: time.Second}, clk, random.faults),
+		}
+		var readings []string
+		for i := 0; i < 50; i++ {
+			if retries {
+				s.calculateDelay(i % 3)
+			}
+
+			sensorData := &pb.SensorData{SensorName: "weather-01", Values: map[string]float64{
+				"temperature": float64(s.random.values.Int31n(100)),
+				"humidity":    float64(s.random.values.Int31n(100)),
+			}}
+			s.injectFaults(sensorData)
+			readings = append(readings, formatValue(sensorData))
+			clk.Advance(200 * time.Millisecond)
+		}
+		return readings
+	}
+
+	first := generate(42, false)
+	if again := generate(42, false); !slices.Equal(first, again) {
+		t.Errorf("readings with the same seed differ:\n%v\n%v", first, again)
+	}
+	if retried := generate(42, true); !slices.Equal(first, retried) {
+		t.Errorf("readings with retries between them differ:\n%v\n%v", first, retried)
+	}
+	if other := generate(43, false); slices.Equal(first, other) {
+		t.Error("different seeds generate the same readings")
+	}
+}
@@ -0,0 +1,108 @@
+// Package seal encrypts reading values and tags on the sensor node, so the sink only
+// ever stores ciphertext it cannot read.
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/sensor_node/proto"
+)
+
+// version is the first byte of every sealed payload.
+const version byte = 1
+
+// Sealer moves the value and tags of readings into an AES-256-GCM sealed payload.
+type Sealer struct {
+	gcm cipher.AEAD
+}
+
+// LoadKey reads a base64 encoded 32-byte key from a file.
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read payload key file: %w", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decode payload key: %w", err)
+	}
+	return key, nil
+}
+
+// NewSealer creates a sealer for a 32-byte key.
+func NewSealer(key []byte) (*Sealer, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("payload key must be 32 bytes long")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create AES cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create GCM: %w", err)
+	}
+
+	return &Sealer{gcm: gcm}, nil
+}
+
+// Seal encrypts the reading's value, named values, aggregate and tags into
+// SealedPayload and clears them from the reading. The sensor name is bound to the ciphertext as additional data.
+func (s *Sealer) Seal(data *pb.SensorData) error {
+	plaintext, err := proto.Marshal(&pb.SealedPayload{
+		SensorValue: data.SensorValue,
+		Tags:        data.Tags,
+		Values:      data.Values,
+		Aggregate:   data.Aggregate,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+
+	sealed := make([]byte, 1+s.gcm.NonceSize(), 1+s.gcm.NonceSize()+len(plaintext)+s.gcm.Overhead())
+	sealed[0] = version
+	nonce := sealed[1:]
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+
+	data.SealedPayload = s.gcm.Seal(sealed, nonce, plaintext, []byte(data.SensorName))
+	data.SensorValue = 0
+	data.Tags = nil
+	data.Values = nil
+	data.Aggregate = nil
+	return nil
+}
+
+// Open decrypts a sealed payload reported for sensorName.
+func (s *Sealer) Open(sensorName string, sealed []byte) (*pb.SealedPayload, error) {
+	if len(sealed) < 1+s.gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed payload too short")
+	}
+	if sealed[0] != version {
+		return nil, fmt.Errorf("unsupported sealed payload version %d", sealed[0])
+	}
+
+	nonce, ciphertext := sealed[1:1+s.gcm.NonceSize()], sealed[1+s.gcm.NonceSize():]
+	plaintext, err := s.gcm.Open(nil, nonce, ciphertext, []byte(sensorName))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt payload: %w", err)
+	}
+
+	var payload pb.SealedPayload
+	if err := proto.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("unmarshal payload: %w", err)
+	}
+	return &payload, nil
+}
@@ -0,0 +1,50 @@
+package seal
+
+import (
+	"bytes"
+	"testing"
+
+	pb "github.com/sensor_node/proto"
+)
+
+func TestSealer_RoundTrip(t *testing.T) {
+	sealer, err := NewSealer(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("NewSealer() error = %v", err)
+	}
+
+	data := &pb.SensorData{
+		SensorName:  "temp-01",
+		SensorValue: 42,
+		Tags:        map[string]string{"site": "a"},
+		Values:      map[string]float64{"humidity": 40},
+	}
+	if err := sealer.Seal(data); err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	if data.SensorValue != 0 || data.Tags != nil || data.Values != nil {
+		t.Errorf("Seal() left value %d, tags %v and values %v in the clear", data.SensorValue, data.Tags, data.Values)
+	}
+	if data.SealedPayload[0] != version {
+		t.Errorf("SealedPayload version = %d, want %d", data.SealedPayload[0], version)
+	}
+
+	payload, err := sealer.Open("temp-01", data.SealedPayload)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if payload.SensorValue != 42 || payload.Tags["site"] != "a" || payload.Values["humidity"] != 40 {
+		t.Errorf("Open() = %v, want value 42, site=a and humidity 40", payload)
+	}
+
+	if _, err := sealer.Open("temp-02", data.SealedPayload); err == nil {
+		t.Error("Open() with another sensor name succeeded, want error")
+	}
+}
+
+func TestNewSealer_KeySize(t *testing.T) {
+	if _, err := NewSealer(make([]byte, 16)); err == nil {
+		t.Error("NewSealer() with a 16-byte key succeeded, want error")
+	}
+}
@@ -0,0 +1,205 @@
+// Package sequence numbers a node's readings per sensor and keeps the numbers in a
+// state file, so they keep increasing across restarts and crashes of the node.
+package sequence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// leaseSize is how many numbers are reserved in the state file at a time. Numbers
+// are only handed out once reserved, so after a crash the node carries on past the
+// reserved ones and never reuses a number, leaving a gap of up to leaseSize.
+const leaseSize = 1000
+
+// Store hands out the numbers of each sensor's readings and remembers the highest
+// the sink acknowledged. A nil Store numbers nothing.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	sensors map[string]*sensor
+}
+
+type sensor struct {
+	next     uint64 // next number to hand out
+	reserved uint64 // highest number reserved in the file
+	acked    uint64 // highest number the sink acknowledged
+}
+
+// fileState is the content of the state file.
+type fileState struct {
+	Sensors map[string]sensorState `json:"sensors"`
+}
+
+type sensorState struct {
+	Reserved uint64 `json:"reserved"`
+	Acked    uint64 `json:"acked,omitempty"`
+}
+
+// Open loads the state file at path; a missing file starts every sensor at 1. A file
+// that can't be parsed is an error rather than a fresh start, which would reuse
+// numbers the sink has seen.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, sensors: make(map[string]*sensor)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read state file: %w", err)
+	}
+	var state fileState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parse state file %s: %w", path, err)
+	}
+	for name, st := range state.Sensors {
+		s.sensors[name] = &sensor{next: st.Reserved + 1, reserved: st.Reserved, acked: st.Acked}
+	}
+	return s, nil
+}
+
+// Next returns the number of a sensor's next reading, reserving more numbers in the
+// state file first once the reserved ones are used up. It returns 0 with the error
+// when they can't be reserved.
+func (s *Store) Next(name string) (uint64, error) {
+	if s == nil {
+		return 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sn, ok := s.sensors[name]
+	if !ok {
+		sn = &sensor{next: 1}
+		s.sensors[name] = sn
+	}
+	if sn.next > sn.reserved {
+		reserved := sn.reserved
+		sn.reserved = sn.next + leaseSize - 1
+		if err := s.save(); err != nil {
+			sn.reserved = reserved
+			return 0, err
+		}
+	}
+
+	n := sn.next
+	sn.next++
+	return n, nil
+}
+
+// Ack records that the sink accepted a sensor's reading numbered n. It is saved with
+// the next reservation or Save.
+func (s *Store) Ack(name string, n uint64) {
+	if s == nil || n == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sn, ok := s.sensors[name]; ok && n > sn.acked {
+		sn.acked = n
+	}
+}
+
+// Position is where a sensor's numbering stands.
+type Position struct {
+	Sensor string
+	Next   uint64 // number of the next reading
+	Acked  uint64 // highest number acknowledged, 0 when none
+}
+
+// Positions returns the position of every sensor numbered so far, by sensor name.
+func (s *Store) Positions() []Position {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions := make([]Position, 0, len(s.sensors))
+	for name, sn := range s.sensors {
+		positions = append(positions, Position{Sensor: name, Next: sn.next, Acked: sn.acked})
+	}
+	slices.SortFunc(positions, func(a, b Position) int { return strings.Compare(a.Sensor, b.Sensor) })
+	return positions
+}
+
+// Save writes the state file.
+func (s *Store) Save() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// Close gives back the numbers reserved but not handed out and saves the state file,
+// so after a clean shutdown the node carries on without a gap the sink would report
+// as missing readings. The Store must not be used afterwards.
+func (s *Store) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sn := range s.sensors {
+		sn.reserved = sn.next - 1
+	}
+	return s.save()
+}
+
+// save replaces the state file atomically: the new content is written to a
+// temporary file, synced and renamed over it, so a crash leaves either the old or
+// the new state. The directory is synced so the rename survives a power loss.
+func (s *Store) save() error {
+	state := fileState{Sensors: make(map[string]sensorState, len(s.sensors))}
+	for name, sn := range s.sensors {
+		state.Sensors[name] = sensorState{Reserved: sn.reserved, Acked: sn.acked}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write state file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("replace state file: %w", err)
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("sync state directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync state directory: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package sequence
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.state")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	for want := uint64(1); want <= 3; want++ {
+		if n, err := store.Next("temp-1"); err != nil || n != want {
+			t.Fatalf("Next(temp-1) = %d, %v, want %d", n, err, want)
+		}
+	}
+	if n, _ := store.Next("hum-1"); n != 1 {
+		t.Errorf("Next(hum-1) = %d, want 1: sensors are numbered separately", n)
+	}
+	store.Ack("temp-1", 2)
+	store.Ack("temp-1", 1)
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	// After a restart, or a crash, the numbers reserved before are skipped, so none
+	// is handed out twice.
+	store, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() after restart error = %v", err)
+	}
+	if n, err := store.Next("temp-1"); err != nil || n != leaseSize+1 {
+		t.Errorf("Next(temp-1) after restart = %d, %v, want %d", n, err, leaseSize+1)
+	}
+	for _, p := range store.Positions() {
+		if p.Sensor == "temp-1" && (p.Acked != 2 || p.Next != leaseSize+2) {
+			t.Errorf("temp-1 position = %+v, want acked 2, next %d", p, leaseSize+2)
+		}
+	}
+
+	// Numbers past a reservation are reserved before they are handed out.
+	for i := 0; i < leaseSize; i++ {
+		if _, err := store.Next("temp-1"); err != nil {
+			t.Fatalf("Next(temp-1) error = %v", err)
+		}
+	}
+	store, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() after crash error = %v", err)
+	}
+	if n, _ := store.Next("temp-1"); n != 3*leaseSize+1 {
+		t.Errorf("Next(temp-1) after crash = %d, want %d", n, 3*leaseSize+1)
+	}
+}
+
+func TestStore_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.state")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	store.Next("temp-1")
+	store.Next("temp-1")
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// After a clean shutdown the numbering carries on where it stopped.
+	store, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() after shutdown error = %v", err)
+	}
+	if n, err := store.Next("temp-1"); err != nil || n != 3 {
+		t.Errorf("Next(temp-1) after shutdown = %d, %v, want 3", n, err)
+	}
+}
+
+func TestOpen_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.state")
+	if err := os.WriteFile(path, []byte(`{"sensors":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Error("Open() of a truncated file error = nil, want an error")
+	}
+}
+
+func TestStore_Nil(t *testing.T) {
+	var store *Store
+	if n, err := store.Next("temp-1"); n != 0 || err != nil {
+		t.Errorf("nil Store Next() = %d, %v, want 0, nil", n, err)
+	}
+	store.Ack("temp-1", 1)
+	if err := store.Save(); err != nil {
+		t.Errorf("nil Store Save() error = %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("nil Store Close() error = %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+// Package slo tracks how many of the readings a node attempts to send are
+// delivered, over rolling windows, to report its success rate and error budget.
+package slo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sensor_node/clock"
+)
+
+const (
+	// bucketWidth is the resolution of the windows.
+	bucketWidth = 5 * time.Second
+	// maxWindow is the longest window rates are kept for.
+	maxWindow = time.Hour
+)
+
+// Windows are the windows success rates are reported over.
+var Windows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour}
+
+// Tracker counts attempted and delivered readings in buckets of bucketWidth for
+// the last maxWindow, and in total since it was created.
+type Tracker struct {
+	clock clock.Clock
+
+	mu        sync.Mutex
+	buckets   [maxWindow / bucketWidth]bucket
+	attempted uint64
+	delivered uint64
+}
+
+type bucket struct {
+	start     int64 // bucket number, the time divided by bucketWidth
+	attempted uint64
+	delivered uint64
+}
+
+// NewTracker creates a tracker timing readings by clk.
+func NewTracker(clk clock.Clock) *Tracker {
+	return &Tracker{clock: clk}
+}
+
+// Record counts a reading that was, or despite retries wasn't, delivered.
+func (t *Tracker) Record(delivered bool) {
+	n := t.clock.Now().UnixNano() / int64(bucketWidth)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := &t.buckets[n%int64(len(t.buckets))]
+	if b.start != n {
+		*b = bucket{start: n}
+	}
+	b.attempted++
+	t.attempted++
+	if delivered {
+		b.delivered++
+		t.delivered++
+	}
+}
+
+// Rate returns the share of readings delivered over the window, rounded up to whole
+// buckets, and how many were attempted. Without attempts the rate is 1.
+func (t *Tracker) Rate(window time.Duration) (rate float64, attempted uint64) {
+	n := t.clock.Now().UnixNano() / int64(bucketWidth)
+	oldest := n - int64((min(window, maxWindow)+bucketWidth-1)/bucketWidth) + 1
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var delivered uint64
+	for _, b := range t.buckets {
+		if b.start >= oldest && b.start <= n {
+			attempted += b.attempted
+			delivered += b.delivered
+		}
+	}
+	if attempted == 0 {
+		return 1, 0
+	}
+	return float64(delivered) / float64(attempted), attempted
+}
+
+// Totals returns the readings attempted and delivered since the tracker was
+// created.
+func (t *Tracker) Totals() (attempted, delivered uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.attempted, t.delivered
+}
+
+// BudgetUsed returns the share of the error budget of a target success rate the
+// failures over the window used up: 0 without failures, 1 once the rate is down to
+// the target and above 1 past it.
+func (t *Tracker) BudgetUsed(window time.Duration, target float64) float64 {
+	rate, attempted := t.Rate(window)
+	if attempted == 0 || rate == 1 {
+		return 0
+	}
+	if target >= 1 {
+		return 1
+	}
+	return (1 - rate) / (1 - target)
+}
@@ -0,0 +1,66 @@
+package slo
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sensor_node/clock"
+)
+
+func TestTracker(t *testing.T) {
+	clk := clock.NewFake(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	tracker := NewTracker(clk)
+
+	if rate, attempted := tracker.Rate(time.Minute); rate != 1 || attempted != 0 {
+		t.Errorf("Rate() without readings = %v, %d, want 1, 0", rate, attempted)
+	}
+
+	// An outage 10 minutes ago: 10 of 20 readings lost.
+	for i := 0; i < 20; i++ {
+		tracker.Record(i%2 == 0)
+	}
+	clk.Advance(10 * time.Minute)
+	// Since then 1 of 10 readings lost.
+	for i := 0; i < 10; i++ {
+		tracker.Record(i != 0)
+		clk.Advance(time.Second)
+	}
+
+	tests := []struct {
+		window        time.Duration
+		wantRate      float64
+		wantAttempted uint64
+	}{
+		{time.Minute, 0.9, 10},
+		{5 * time.Minute, 0.9, 10},
+		{time.Hour, 19.0 / 30, 30},
+	}
+	for _, tt := range tests {
+		rate, attempted := tracker.Rate(tt.window)
+		if math.Abs(rate-tt.wantRate) > 1e-9 || attempted != tt.wantAttempted {
+			t.Errorf("Rate(%v) = %v, %d, want %v, %d", tt.window, rate, attempted, tt.wantRate, tt.wantAttempted)
+		}
+	}
+	if attempted, delivered := tracker.Totals(); attempted != 30 || delivered != 19 {
+		t.Errorf("Totals() = %d, %d, want 30, 19", attempted, delivered)
+	}
+
+	if used := tracker.BudgetUsed(5*time.Minute, 0.8); math.Abs(used-0.5) > 1e-9 {
+		t.Errorf("BudgetUsed(5m, 0.8) = %v, want 0.5", used)
+	}
+	if used := tracker.BudgetUsed(5*time.Minute, 0.95); used <= 1 {
+		t.Errorf("BudgetUsed(5m, 0.95) = %v, want it exhausted", used)
+	}
+
+	// An hour later the readings have left every window, though the buckets they
+	// were counted in are reused.
+	clk.Advance(time.Hour)
+	if rate, attempted := tracker.Rate(time.Hour); rate != 1 || attempted != 0 {
+		t.Errorf("Rate(1h) an hour later = %v, %d, want 1, 0", rate, attempted)
+	}
+	tracker.Record(true)
+	if rate, attempted := tracker.Rate(time.Hour); rate != 1 || attempted != 1 {
+		t.Errorf("Rate(1h) after a new reading = %v, %d, want 1, 1", rate, attempted)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sensor_node/clock"
+	"github.com/sensor_node/interceptor"
+	pb "github.com/sensor_node/proto"
+)
+
+// spoolReadings keeps readings whose attempts ran out in the spool, for spoolLoop to
+// resend. Readings that don't fit are dropped and reported in the error.
+func (s *SensorNode) spoolReadings(readings []*pb.SensorData) error {
+	var dropped int
+	var err error
+	for _, sensorData := range readings {
+		data, marshalErr := proto.Marshal(sensorData)
+		if marshalErr == nil {
+			marshalErr = s.spool.Add(data)
+		}
+		if marshalErr != nil {
+			dropped++
+			err = marshalErr
+		}
+	}
+
+	select {
+	case s.spooled <- struct{}{}:
+	default:
+	}
+	if spooled := len(readings) - dropped; spooled > 0 {
+		log.Printf("Spooled %d readings to resend", spooled)
+	}
+	if dropped > 0 {
+		return fmt.Errorf("%d readings not spooled, dropped: %w", dropped, err)
+	}
+	return nil
+}
+
+// spoolLoop resends the spooled readings, oldest first, until Stop is called. A
+// reading is retried with backoff until the sink accepts it or rejects it as
+// invalid, while newer readings are sent as usual.
+func (s *SensorNode) spoolLoop() {
+	defer s.sends.Done()
+
+	attempt := 0
+	for {
+		data, err := s.spool.Next()
+		if err == io.EOF {
+			select {
+			case <-s.spooled:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		if err != nil {
+			// Unreadable segments are dropped, but one that can't be opened stays,
+			// so back off rather than spin on it.
+			delay := s.calculateDelay(attempt)
+			attempt++
+			log.Printf("Failed to read spooled reading: %v. Retrying in %v...", err, delay)
+			if !clock.Sleep(s.config.Clock, delay, s.done) {
+				return
+			}
+			continue
+		}
+
+		sensorData := &pb.SensorData{}
+		if err := proto.Unmarshal(data, sensorData); err != nil {
+			log.Printf("Dropping spooled reading that can't be decoded: %v", err)
+			s.spool.Remove()
+			continue
+		}
+		if !s.pacer.Wait(s.done, len(data)) {
+			return
+		}
+
+		err = s.resend(sensorData)
+		switch {
+		case err == nil:
+			s.sequences.Ack(sensorData.SensorName, sensorData.Sequence)
+			log.Printf("Resent spooled reading of %s at %s, request %s", sensorData.SensorName, sensorData.Timestamp.AsTime().Format(time.RFC3339), sensorData.RequestId)
+		case !s.isRetryableError(err):
+			log.Printf("Sink rejected spooled reading, request %s, dropping it: %v%s", sensorData.RequestId, err, describeViolations(err))
+		default:
+			delay := s.calculateDelay(attempt)
+			attempt++
+			log.Printf("Resending spooled request %s failed: %v. Retrying in %v...", sensorData.RequestId, err, delay)
+			if !clock.Sleep(s.config.Clock, delay, s.done) {
+				return
+			}
+			continue
+		}
+		attempt = 0
+		if err := s.spool.Remove(); err != nil {
+			log.Printf("Failed to remove resent reading from the spool: %v", err)
+		}
+	}
+}
+
+// resend makes one attempt to send a spooled reading.
+func (s *SensorNode) resend(sensorData *pb.SensorData) error {
+	parent := metadata.AppendToOutgoingContext(interceptor.WithTrace(context.Background()), interceptor.RequestIDKey, sensorData.RequestId)
+	ctx, cancel := s.callContext(parent)
+	defer cancel()
+
+	conn := s.pool.Pick()
+	_, err := conn.Client.SendSensorData(ctx, sensorData)
+	conn.Report(err)
+	return err
+}
+
+// closeSpool closes the spool at shutdown; the readings in it are resent after the
+// next start.
+func (s *SensorNode) closeSpool() {
+	if s.spool == nil {
+		return
+	}
+	if n, _ := s.spool.Len(); n > 0 {
+		log.Printf("Keeping %d spooled readings to resend after restart", n)
+	}
+	if err := s.spool.Close(); err != nil {
+		log.Printf("Failed to close spool: %v", err)
+	}
+}
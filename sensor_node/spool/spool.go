@@ -0,0 +1,290 @@
+// Package spool keeps readings the node couldn't deliver in files on disk until
+// they are, oldest first, so an unattended node outlasts long outages of its sinks.
+package spool
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// segmentSize is the size past which records go to a new file. Files are removed
+// once all their records are.
+const segmentSize = 1 << 20
+
+const suffix = ".spool"
+
+// ErrFull is returned by Add when the record would grow the spool past its limit.
+var ErrFull = errors.New("spool full")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Spool is a queue of records in numbered files in a directory. Every record is
+// framed as its uvarint length, the record and its CRC-32C, and synced before Add
+// returns. A record torn by a crash is cut off when the spool is opened again.
+type Spool struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	segments []*segment // oldest first
+	records  int
+	size     int64
+	w        *os.File // of the newest segment, nil until the first Add
+	r        *os.File // of the oldest segment, nil until the first Next
+	offset   int64    // of the next record in the oldest segment
+	next     int64    // framed size of the record Next returned, 0 if none
+}
+
+type segment struct {
+	number  uint64
+	size    int64
+	records int
+}
+
+func (s *segment) path(dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("%016x%s", s.number, suffix))
+}
+
+// Open opens the spool in dir, creating dir if needed, with the records left in it.
+// Add fails once maxBytes of records are waiting.
+func Open(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, maxBytes: maxBytes}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), suffix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		number, err := strconv.ParseUint(name, 16, 64)
+		if err != nil {
+			continue
+		}
+		seg := &segment{number: number}
+		if err := seg.scan(dir); err != nil {
+			return nil, fmt.Errorf("spool %s: %w", entry.Name(), err)
+		}
+		if seg.records == 0 {
+			os.Remove(seg.path(dir))
+			continue
+		}
+		s.segments = append(s.segments, seg)
+		s.records += seg.records
+		s.size += seg.size
+	}
+	slices.SortFunc(s.segments, func(a, b *segment) int {
+		return cmp.Compare(a.number, b.number)
+	})
+	return s, nil
+}
+
+// scan counts the records of the segment and cuts off a torn or corrupt tail.
+func (seg *segment) scan(dir string) error {
+	f, err := os.OpenFile(seg.path(dir), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for {
+		_, n, err := readRecord(f, seg.size)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return f.Truncate(seg.size)
+		}
+		seg.size += n
+		seg.records++
+	}
+}
+
+// readRecord reads the record at offset of f and returns it with its framed size. It
+// returns io.EOF at the end of f.
+func readRecord(f *os.File, offset int64) ([]byte, int64, error) {
+	var header [binary.MaxVarintLen64]byte
+	n, err := f.ReadAt(header[:], offset)
+	if n == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	length, size := binary.Uvarint(header[:n])
+	if size <= 0 || length > segmentSize {
+		return nil, 0, fmt.Errorf("bad record length at offset %d", offset)
+	}
+
+	buf := make([]byte, length+crc32.Size)
+	if _, err := f.ReadAt(buf, offset+int64(size)); err != nil {
+		return nil, 0, fmt.Errorf("record at offset %d: %w", offset, err)
+	}
+	record := buf[:length]
+	if binary.BigEndian.Uint32(buf[length:]) != crc32.Checksum(record, castagnoli) {
+		return nil, 0, fmt.Errorf("record at offset %d: checksum mismatch", offset)
+	}
+	return record, int64(size) + int64(len(buf)), nil
+}
+
+// Add appends a record to the spool and syncs it.
+func (s *Spool) Add(record []byte) error {
+	if len(record) > segmentSize {
+		return fmt.Errorf("record of %d bytes is larger than %d", len(record), segmentSize)
+	}
+	frame := binary.AppendUvarint(nil, uint64(len(record)))
+	frame = append(frame, record...)
+	frame = binary.BigEndian.AppendUint32(frame, crc32.Checksum(record, castagnoli))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxBytes > 0 && s.size+int64(len(frame)) > s.maxBytes {
+		return ErrFull
+	}
+	last := s.last()
+	if s.w == nil || last.size >= segmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		last = s.last()
+	}
+	if _, err := s.w.Write(frame); err != nil {
+		return err
+	}
+	if err := s.w.Sync(); err != nil {
+		return err
+	}
+	last.size += int64(len(frame))
+	last.records++
+	s.size += int64(len(frame))
+	s.records++
+	return nil
+}
+
+func (s *Spool) last() *segment {
+	if len(s.segments) == 0 {
+		return nil
+	}
+	return s.segments[len(s.segments)-1]
+}
+
+// rotate starts a new segment for Add. Segments left by an earlier run are never
+// appended to, as they may end in a torn record.
+func (s *Spool) rotate() error {
+	seg := &segment{}
+	if last := s.last(); last != nil {
+		seg.number = last.number + 1
+	}
+	f, err := os.OpenFile(seg.path(s.dir), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if s.w != nil {
+		s.w.Close()
+	}
+	s.w = f
+	s.segments = append(s.segments, seg)
+	return nil
+}
+
+// Next returns the oldest record without removing it, or io.EOF when the spool is
+// empty. Records that can't be read are dropped with the rest of their segment and
+// reported in the error.
+func (s *Spool) Next() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records == 0 {
+		return nil, io.EOF
+	}
+	oldest := s.segments[0]
+	if s.r == nil {
+		f, err := os.Open(oldest.path(s.dir))
+		if err != nil {
+			return nil, err
+		}
+		s.r, s.offset = f, 0
+	}
+	record, n, err := readRecord(s.r, s.offset)
+	if err != nil {
+		dropped := oldest.records
+		s.records -= dropped
+		s.size -= oldest.size - s.offset
+		s.removeOldest()
+		return nil, fmt.Errorf("spool: dropped %d records: %w", dropped, err)
+	}
+	s.next = n
+	return record, nil
+}
+
+// Remove removes the record Next returned.
+func (s *Spool) Remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next == 0 {
+		return errors.New("spool: Remove without Next")
+	}
+	oldest := s.segments[0]
+	s.offset += s.next
+	s.size -= s.next
+	s.next = 0
+	s.records--
+	oldest.records--
+	if oldest.records == 0 {
+		return s.removeOldest()
+	}
+	return nil
+}
+
+func (s *Spool) removeOldest() error {
+	oldest := s.segments[0]
+	if s.r != nil {
+		s.r.Close()
+		s.r = nil
+	}
+	if oldest == s.last() && s.w != nil {
+		s.w.Close()
+		s.w = nil
+	}
+	s.segments = s.segments[1:]
+	s.offset, s.next = 0, 0
+	return os.Remove(oldest.path(s.dir))
+}
+
+// Len returns the number of records waiting and their size on disk.
+func (s *Spool) Len() (records int, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records, s.size
+}
+
+// Close closes the spool's files; the records stay for the next Open.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.r != nil {
+		err = s.r.Close()
+		s.r = nil
+	}
+	if s.w != nil {
+		err = errors.Join(err, s.w.Close())
+		s.w = nil
+	}
+	return err
+}
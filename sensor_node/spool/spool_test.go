@@ -0,0 +1,147 @@
+package spool
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// drain returns the records of the spool, removing them.
+func drain(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var records []string
+	for {
+		record, err := s.Next()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		records = append(records, string(record))
+		if err := s.Remove(); err != nil {
+			t.Fatalf("Remove() error = %v", err)
+		}
+	}
+}
+
+func TestSpool(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "spool")
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	for _, record := range []string{"a", "b", "c"} {
+		if err := s.Add([]byte(record)); err != nil {
+			t.Fatalf("Add(%s) error = %v", record, err)
+		}
+	}
+	// Next returns the oldest record until it is removed.
+	if record, _ := s.Next(); string(record) != "a" {
+		t.Errorf("Next() = %q, want a", record)
+	}
+	if record, _ := s.Next(); string(record) != "a" {
+		t.Errorf("Next() again = %q, want a", record)
+	}
+	if err := s.Remove(); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	s.Close()
+
+	// The records not removed are kept across restarts.
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open() after restart error = %v", err)
+	}
+	if err := s.Add([]byte("d")); err != nil {
+		t.Fatalf("Add(d) error = %v", err)
+	}
+	if n, _ := s.Len(); n != 4 {
+		t.Errorf("Len() = %d, want a, b, c and d", n)
+	}
+	// The first record was removed in memory only, so it comes again.
+	if got := drain(t, s); len(got) != 4 || got[0] != "a" || got[3] != "d" {
+		t.Errorf("records = %q, want a to d", got)
+	}
+	if n, size := s.Len(); n != 0 || size != 0 {
+		t.Errorf("Len() after drain = %d, %d, want 0, 0", n, size)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("files after drain = %v, want none", files)
+	}
+
+	// Added after draining, records go to a new file.
+	s.Add([]byte("e"))
+	if got := drain(t, s); len(got) != 1 || got[0] != "e" {
+		t.Errorf("records = %q, want e", got)
+	}
+}
+
+func TestSpool_Segments(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	big := bytes.Repeat([]byte("x"), segmentSize/2+1)
+	for i := 0; i < 3; i++ {
+		if err := s.Add(big); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	if len(s.segments) != 2 {
+		t.Errorf("segments = %d, want a full one and a second", len(s.segments))
+	}
+	if got := drain(t, s); len(got) != 3 {
+		t.Errorf("drained %d records, want 3", len(got))
+	}
+}
+
+func TestSpool_Full(t *testing.T) {
+	s, err := Open(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := s.Add([]byte("abc")); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := s.Add([]byte("def")); !errors.Is(err, ErrFull) {
+		t.Errorf("Add() past the limit error = %v, want ErrFull", err)
+	}
+	// Removing records makes room.
+	s.Next()
+	s.Remove()
+	if err := s.Add([]byte("def")); err != nil {
+		t.Errorf("Add() after Remove() error = %v", err)
+	}
+}
+
+func TestOpen_TornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	s.Add([]byte("complete"))
+	s.Add([]byte("torn"))
+	s.Close()
+
+	path := s.segments[0].path(dir)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(dir, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if got := drain(t, s); len(got) != 1 || got[0] != "complete" {
+		t.Errorf("records = %q, want the complete one", got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sensor_node/clock"
+	pb "github.com/sensor_node/proto"
+	"github.com/sensor_node/spool"
+)
+
+// countingClock is a fake clock counting the timers started on it.
+type countingClock struct {
+	*clock.Fake
+	timers atomic.Int32
+}
+
+func (c *countingClock) NewTimer(d time.Duration) clock.Timer {
+	c.timers.Add(1)
+	return c.Fake.NewTimer(d)
+}
+
+func TestSpoolLoop_BacksOffOnReadErrors(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	sp, err := spool.Open(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("spool.Open() error = %v", err)
+	}
+	defer sp.Close()
+	if err := sp.Add([]byte("reading")); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	// A segment that can't be opened stays in the spool.
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"))
+	for _, segment := range segments {
+		os.Remove(segment)
+	}
+
+	clk := &countingClock{Fake: clock.NewFake(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))}
+	s := &SensorNode{
+		config:  Config{Clock: clk, RetryBaseDelay: time.Second, RetryMaxDelay: time.Minute},
+		random:  newRandomStreams(1),
+		spool:   sp,
+		spooled: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	s.sends.Add(1)
+	go s.spoolLoop()
+	defer s.sends.Wait()
+	defer s.Stop()
+
+	waitFor := func(timers int32) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); clk.timers.Load() < timers; {
+			if time.Now().After(deadline) {
+				t.Fatalf("spool loop started %d backoff timers, want %d", clk.timers.Load(), timers)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitFor(1)
+	time.Sleep(50 * time.Millisecond)
+	if got := clk.timers.Load(); got != 1 {
+		t.Errorf("spool loop started %d backoff timers without the clock moving, want 1", got)
+	}
+	clk.Advance(time.Minute)
+	waitFor(2)
+}
+
+func TestClose_SpoolsPendingBatch(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	config, err := pipelineConfig(nil, map[string]any{
+		"sink-addr":        "127.0.0.1:1", // refuses connections
+		"batch-size":       10,
+		"spool-dir":        dir,
+		"retry-base-delay": "1h",
+	})
+	if err != nil {
+		t.Fatalf("pipelineConfig() error = %v", err)
+	}
+	s, err := NewSensorNode(config)
+	if err != nil {
+		t.Fatalf("NewSensorNode() error = %v", err)
+	}
+
+	for range 3 {
+		s.queue(&pb.SensorData{SensorName: "temp", SensorValue: 21})
+	}
+	s.Stop()
+	s.Close()
+
+	sp, err := spool.Open(dir, config.SpoolMaxBytes)
+	if err != nil {
+		t.Fatalf("spool.Open() error = %v", err)
+	}
+	defer sp.Close()
+	if n, _ := sp.Len(); n != 3 {
+		t.Errorf("spool holds %d readings after Close, want the 3 waiting for a full batch", n)
+	}
+}
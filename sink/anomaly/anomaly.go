@@ -0,0 +1,79 @@
+package anomaly
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sink/state"
+)
+
+// Detector scores readings against the recent behaviour of the same series.
+type Detector interface {
+	// Observe records a value for the series identified by key and returns its
+	// anomaly score and whether the score is over the detector's threshold.
+	Observe(key string, value float64) (score float64, anomalous bool)
+}
+
+// EWMA tracks an exponentially weighted moving mean and variance per series and
+// scores values by their z-score against them.
+type EWMA struct {
+	alpha     float64 // weight of the newest value, in (0, 1]
+	threshold float64 // z-score above which a value is anomalous
+	warmup    int     // values per series observed before any is flagged
+
+	mu     sync.Mutex
+	series *state.Table[string, *ewmaState]
+}
+
+type ewmaState struct {
+	mean     float64
+	variance float64
+	count    int
+}
+
+// NewEWMA creates a detector. Series are dropped, and warm up again when they come
+// back, once idle for longer than the limits' TTL or when more than MaxEntries exist.
+func NewEWMA(alpha, threshold float64, warmup int, limits state.Limits) *EWMA {
+	return &EWMA{
+		alpha:     alpha,
+		threshold: threshold,
+		warmup:    warmup,
+		series:    state.NewTable[string, *ewmaState]("anomaly_series", limits),
+	}
+}
+
+func (d *EWMA) Observe(key string, value float64) (float64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	created := false
+	st := d.series.GetOrCreate(key, time.Now(), func() *ewmaState {
+		created = true
+		return &ewmaState{mean: value, count: 1}
+	})
+	if created {
+		return 0, false
+	}
+
+	diff := value - st.mean
+	score := zScore(diff, st.variance)
+	warm := st.count >= d.warmup
+
+	// Anomalous values still update the state, so a lasting level shift becomes the
+	// new normal instead of alerting forever.
+	incr := d.alpha * diff
+	st.mean += incr
+	st.variance = (1 - d.alpha) * (st.variance + diff*incr)
+	st.count++
+
+	return score, warm && score > d.threshold
+}
+
+// minVariance keeps scores finite for series that have been perfectly constant, where
+// any change is then scored as a large outlier.
+const minVariance = 1e-12
+
+func zScore(diff, variance float64) float64 {
+	return math.Abs(diff) / math.Sqrt(math.Max(variance, minVariance))
+}
@@ -0,0 +1,70 @@
+package anomaly
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sink/state"
+)
+
+func TestEWMA_Observe(t *testing.T) {
+	d := NewEWMA(0.1, 3, 10, state.Limits{})
+
+	// A noisy but stable series.
+	for i := 0; i < 50; i++ {
+		value := 20.0
+		if i%2 == 0 {
+			value = 21.0
+		}
+		if _, anomalous := d.Observe("temp", value); anomalous {
+			t.Fatalf("Observe(%v) flagged a normal value at step %d", value, i)
+		}
+	}
+
+	score, anomalous := d.Observe("temp", 40)
+	if !anomalous {
+		t.Errorf("Observe(40) = %v, false, want anomalous", score)
+	}
+
+	if _, anomalous := d.Observe("other", 40); anomalous {
+		t.Error("First value of a new series should not be anomalous")
+	}
+}
+
+func TestEWMA_Warmup(t *testing.T) {
+	d := NewEWMA(0.5, 3, 5, state.Limits{})
+
+	d.Observe("s", 1)
+	if _, anomalous := d.Observe("s", 1000); anomalous {
+		t.Error("Values during warmup should not be flagged")
+	}
+}
+
+func TestWebhook_Notify(t *testing.T) {
+	received := make(chan Alert, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("decode alert: %v", err)
+		}
+		received <- alert
+	}))
+	defer srv.Close()
+
+	w := NewWebhook(srv.URL, time.Second)
+	w.Notify(Alert{SensorName: "temp", Score: 4.2})
+	w.Close()
+	w.Notify(Alert{SensorName: "late"}) // must not panic after Close
+
+	select {
+	case alert := <-received:
+		if alert.SensorName != "temp" || alert.Score != 4.2 {
+			t.Errorf("alert = %+v, want temp with score 4.2", alert)
+		}
+	default:
+		t.Error("Close() should deliver queued alerts")
+	}
+}
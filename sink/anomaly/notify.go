@@ -0,0 +1,118 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Alert describes an anomalous reading.
+type Alert struct {
+	SensorName string    `json:"sensor_name"`
+	Tenant     string    `json:"tenant"`
+	Value      float64   `json:"value"`
+	Score      float64   `json:"score"`
+	DataTime   time.Time `json:"data_time"`
+}
+
+// Notifier delivers alerts. Notify must not block ingestion.
+type Notifier interface {
+	Notify(alert Alert)
+	Close() error
+}
+
+const webhookQueueSize = 100
+
+// Webhook posts alerts as JSON to a URL from a background goroutine. Alerts arriving
+// while the queue is full are dropped.
+type Webhook struct {
+	url    string
+	client *http.Client
+	queue  chan Alert
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex // guards closed against concurrent Notify calls
+	closed bool
+}
+
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	w := &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan Alert, webhookQueueSize),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w
+}
+
+func (w *Webhook) Notify(alert Alert) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.queue <- alert:
+	default:
+		log.Printf("anomaly webhook queue full, dropping alert for %s", alert.SensorName)
+	}
+}
+
+// Close delivers queued alerts and stops the background goroutine.
+func (w *Webhook) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	w.wg.Wait()
+	return nil
+}
+
+func (w *Webhook) run() {
+	defer w.wg.Done()
+
+	for alert := range w.queue {
+		if err := w.post(alert); err != nil {
+			log.Printf("failed to send anomaly alert for %s: %v", alert.SensorName, err)
+		}
+	}
+}
+
+func (w *Webhook) post(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types recorded in the audit log.
+const (
+	EventClientConnect    = "client_connect"
+	EventClientDisconnect = "client_disconnect"
+	EventAuthFailure      = "auth_failure"
+	EventAdminCall        = "admin_call"
+	EventConfigReload     = "config_reload"
+	EventKeyRotation      = "key_rotation"
+)
+
+// Event is a single audit record, written as one JSON line.
+type Event struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Identity string    `json:"identity,omitempty"`
+	Remote   string    `json:"remote,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	// Sig chains every record to the previous one with HMAC-SHA256, so removed or
+	// edited lines break verification. Empty when signing is disabled.
+	Sig string `json:"sig,omitempty"`
+}
+
+// Logger appends audit events to a file that is never truncated or rewritten;
+// when it grows past maxSize it is renamed aside and a new file is started.
+type Logger struct {
+	path       string
+	maxSize    int64
+	signingKey []byte
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	seq     uint64
+	prevSig string
+}
+
+// NewLogger opens (or creates) the audit log at path. A maxSize of zero disables
+// rotation; a nil signingKey disables signing.
+func NewLogger(path string, maxSize int64, signingKey []byte) (*Logger, error) {
+	l := &Logger{
+		path:       path,
+		maxSize:    maxSize,
+		signingKey: signingKey,
+	}
+
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// LoadSigningKey reads a base64 encoded HMAC key from a file.
+func LoadSigningKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decode signing key: %w", err)
+	}
+	if len(key) < 16 {
+		return nil, fmt.Errorf("signing key must be at least 16 bytes long")
+	}
+
+	return key, nil
+}
+
+// Log records an event. Failures to write are reported to the process log but never
+// block the caller's request.
+func (l *Logger) Log(eventType, identity, remote, detail string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	event := Event{
+		Seq:      l.seq,
+		Time:     time.Now().UTC(),
+		Type:     eventType,
+		Identity: identity,
+		Remote:   remote,
+		Detail:   detail,
+	}
+
+	if l.signingKey != nil {
+		event.Sig = Sign(l.signingKey, l.prevSig, event)
+		l.prevSig = event.Sig
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("audit: marshal event: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	if l.maxSize > 0 && l.size+int64(len(line)) > l.maxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			log.Printf("audit: rotate log: %v", err)
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("audit: write event: %v", err)
+		return
+	}
+	if err := l.file.Sync(); err != nil {
+		log.Printf("audit: sync log: %v", err)
+	}
+}
+
+// Sign computes the chained signature of an event given the signature of the
+// previous event. The Sig field of the event is ignored.
+func Sign(key []byte, prevSig string, event Event) string {
+	event.Sig = ""
+	payload, _ := json.Marshal(event)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(prevSig))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat audit log: %w", err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	rotated := fmt.Sprintf("%s.%s", l.path, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(l.path, rotated); err != nil {
+		return err
+	}
+
+	return l.open()
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestLogger_SignatureChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	key := []byte("0123456789abcdef")
+
+	l, err := NewLogger(path, 0, key)
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+	l.Log(EventClientConnect, "", "10.0.0.1:5000", "")
+	l.Log(EventAuthFailure, "sensor-a", "10.0.0.1:5000", "no client certificate provided")
+	l.Log(EventConfigReload, "", "", "authorization policy")
+	l.Close()
+
+	events := readEvents(t, path)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+
+	prevSig := ""
+	for i, event := range events {
+		if event.Seq != uint64(i+1) {
+			t.Errorf("event %d seq = %d, want %d", i, event.Seq, i+1)
+		}
+		if want := Sign(key, prevSig, event); event.Sig != want {
+			t.Errorf("event %d signature does not verify", i)
+		}
+		prevSig = event.Sig
+	}
+
+	events[1].Detail = "tampered"
+	if Sign(key, events[0].Sig, events[1]) == events[1].Sig {
+		t.Error("tampered event should not verify")
+	}
+}
+
+func TestLogger_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+
+	l, err := NewLogger(path, 200, nil)
+	if err != nil {
+		t.Fatalf("NewLogger() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		l.Log(EventClientConnect, "", "10.0.0.1:5000", "")
+	}
+	l.Close()
+
+	matches, _ := filepath.Glob(path + ".*")
+	if len(matches) == 0 {
+		t.Error("expected rotated audit log files")
+	}
+
+	if events := readEvents(t, path); len(events) == 0 || events[len(events)-1].Seq != 5 {
+		t.Errorf("current log should end with seq 5, got %+v", events)
+	}
+}
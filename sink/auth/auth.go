@@ -0,0 +1,112 @@
+// Package auth authenticates the clients of the sink's calls: by a static bearer
+// token, by their mTLS client certificate or by a JWT of an OpenID Connect provider.
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Methods clients authenticate with.
+const (
+	MethodToken = "token"
+	MethodMTLS  = "mtls"
+	MethodOIDC  = "oidc"
+)
+
+// ErrNoCredentials is returned by an Authenticator when a call carries no
+// credentials of its kind, so the next one in a Chain is tried.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Identity is the authenticated client of a call.
+type Identity struct {
+	// Name of the client: the name of its token, the SPIFFE ID or CN of its
+	// certificate or the subject claim of its JWT
+	Name   string
+	Method string
+	Cert   *x509.Certificate // the client certificate with MethodMTLS
+}
+
+// String returns the name of the client, empty for a nil Identity.
+func (id *Identity) String() string {
+	if id == nil {
+		return ""
+	}
+	return id.Name
+}
+
+// Authenticator authenticates the client of a call from its peer and metadata.
+type Authenticator interface {
+	Authenticate(ctx context.Context) (*Identity, error)
+}
+
+// AuthenticatorFunc adapts a function to an Authenticator.
+type AuthenticatorFunc func(ctx context.Context) (*Identity, error)
+
+// Authenticate calls f.
+func (f AuthenticatorFunc) Authenticate(ctx context.Context) (*Identity, error) {
+	return f(ctx)
+}
+
+// Chain tries its authenticators in order and returns the first identity one of them
+// establishes. Without one, it returns the first error other than ErrNoCredentials,
+// so a static token is reported as unknown rather than as an invalid JWT.
+type Chain []Authenticator
+
+// Authenticate authenticates the client with the first authenticator accepting it.
+func (c Chain) Authenticate(ctx context.Context) (*Identity, error) {
+	var first error
+	for _, a := range c {
+		id, err := a.Authenticate(ctx)
+		if err == nil {
+			return id, nil
+		}
+		if first == nil && !errors.Is(err, ErrNoCredentials) {
+			first = err
+		}
+	}
+	if first == nil {
+		return nil, ErrNoCredentials
+	}
+	return nil, first
+}
+
+// BearerToken returns the token of the call's "authorization: Bearer" metadata.
+func BearerToken(ctx context.Context) (string, bool) {
+	values := metadata.ValueFromIncomingContext(ctx, "authorization")
+	if len(values) == 0 {
+		return "", false
+	}
+	scheme, token, ok := strings.Cut(values[0], " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// MTLS authenticates clients by the certificate they presented in the TLS handshake,
+// verified against the CA by then, naming them with Name.
+type MTLS struct {
+	Name func(cert *x509.Certificate) string
+}
+
+// Authenticate returns the identity of the call's client certificate.
+func (m MTLS) Authenticate(ctx context.Context) (*Identity, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil, ErrNoCredentials
+	}
+	cert := tlsInfo.State.PeerCertificates[0]
+	return &Identity{Name: m.Name(cert), Method: MethodMTLS, Cert: cert}, nil
+}
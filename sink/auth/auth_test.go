@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestTokens(t *testing.T) {
+	tokens, err := parseTokens([]byte(`
+tokens:
+  - name: plant-a
+    sha256: ` + hash("secret-a") + `
+  - name: plant-b
+    sha256: ` + hash("secret-b") + `
+`))
+	if err != nil {
+		t.Fatalf("parseTokens() error = %v", err)
+	}
+
+	if id, err := tokens.Authenticate(withToken("secret-b")); err != nil || id.Name != "plant-b" || id.Method != MethodToken {
+		t.Errorf("Authenticate(secret-b) = %+v, %v, want plant-b", id, err)
+	}
+	if _, err := tokens.Authenticate(withToken("secret-c")); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Authenticate(unknown token) error = %v, want it rejected", err)
+	}
+	if _, err := tokens.Authenticate(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Authenticate(no token) error = %v, want ErrNoCredentials", err)
+	}
+	basic := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Basic c2VjcmV0LWE="))
+	if _, err := tokens.Authenticate(basic); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Authenticate(basic auth) error = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestParseTokens_Invalid(t *testing.T) {
+	for _, data := range []string{
+		"tokens: [{sha256: " + hash("a") + "}]",
+		"tokens: [{name: a, sha256: abc}]",
+		"tokens: [{name: a, sha256: " + hash("a") + "}, {name: b, sha256: " + hash("a") + "}]",
+		"tokens: {a: b}",
+	} {
+		if _, err := parseTokens([]byte(data)); err == nil {
+			t.Errorf("parseTokens(%q) error = nil, want an error", data)
+		}
+	}
+}
+
+func TestMTLS(t *testing.T) {
+	m := MTLS{Name: func(cert *x509.Certificate) string { return cert.Subject.CommonName }}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "sensor-7"}}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}}})
+	if id, err := m.Authenticate(ctx); err != nil || id.Name != "sensor-7" || id.Cert != cert {
+		t.Errorf("Authenticate() = %+v, %v, want sensor-7 with its certificate", id, err)
+	}
+
+	ctx = peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{}})
+	if _, err := m.Authenticate(ctx); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Authenticate(no certificate) error = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	none := AuthenticatorFunc(func(ctx context.Context) (*Identity, error) { return nil, ErrNoCredentials })
+	unknown := AuthenticatorFunc(func(ctx context.Context) (*Identity, error) { return nil, errors.New("unknown token") })
+	invalid := AuthenticatorFunc(func(ctx context.Context) (*Identity, error) { return nil, errors.New("invalid JWT") })
+	client := AuthenticatorFunc(func(ctx context.Context) (*Identity, error) { return &Identity{Name: "client"}, nil })
+
+	if id, err := (Chain{none, unknown, client}).Authenticate(context.Background()); err != nil || id.Name != "client" {
+		t.Errorf("Authenticate() = %v, %v, want the client of the last authenticator", id, err)
+	}
+	if _, err := (Chain{none, unknown, invalid}).Authenticate(context.Background()); err == nil || err.Error() != "unknown token" {
+		t.Errorf("Authenticate() error = %v, want the first failure", err)
+	}
+	if _, err := (Chain{none}).Authenticate(context.Background()); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Authenticate() error = %v, want ErrNoCredentials", err)
+	}
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/sink/clock"
+)
+
+const (
+	// oidcFetchTimeout bounds fetching the discovery document or the signing keys.
+	oidcFetchTimeout = 10 * time.Second
+	// keyRefetchInterval is the least time between fetches for a token signed with a
+	// key the sink doesn't know yet, as after the provider rotates its keys.
+	keyRefetchInterval = time.Minute
+	// clockLeeway is the skew allowed between the sink's and the provider's clocks.
+	clockLeeway = time.Minute
+	// maxCachedTokens bounds the verified tokens kept, so a client sending a token
+	// with every reading doesn't pay for verifying its signature every time.
+	maxCachedTokens = 4096
+)
+
+var signatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// OIDCConfig configures an OIDC authenticator.
+type OIDCConfig struct {
+	Issuer       string        // "iss" of accepted tokens, whose discovery document names the keys
+	Audience     string        // required in the "aud" of accepted tokens
+	JWKSURL      string        // overrides the jwks_uri of the discovery document
+	SubjectClaim string        // claim naming the client, "sub" when empty
+	KeyRefresh   time.Duration // age after which the keys are fetched again
+	Clock        clock.Clock   // clock.Real when nil
+}
+
+// OIDC authenticates clients by JWTs an OpenID Connect provider issued, verified
+// against the provider's published signing keys. Tokens must name the sink's audience
+// and expire.
+type OIDC struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    jose.JSONWebKeySet
+	fetched time.Time
+	tokens  map[string]verifiedToken
+}
+
+type verifiedToken struct {
+	id     *Identity
+	expiry time.Time
+}
+
+// NewOIDC discovers the provider's signing keys, unless config names them, and
+// fetches them.
+func NewOIDC(config OIDCConfig) (*OIDC, error) {
+	if config.Issuer == "" || config.Audience == "" {
+		return nil, errors.New("OIDC requires an issuer and an audience")
+	}
+	config.SubjectClaim = cmp.Or(config.SubjectClaim, "sub")
+	if config.Clock == nil {
+		config.Clock = clock.Real
+	}
+	o := &OIDC{
+		config: config,
+		client: &http.Client{Timeout: oidcFetchTimeout},
+		tokens: make(map[string]verifiedToken),
+	}
+
+	if o.config.JWKSURL == "" {
+		var err error
+		if o.config.JWKSURL, err = o.discover(); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.fetchKeys(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// discover returns the jwks_uri of the issuer's discovery document.
+func (o *OIDC) discover() (string, error) {
+	url := strings.TrimSuffix(o.config.Issuer, "/") + "/.well-known/openid-configuration"
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := o.get(url, &doc); err != nil {
+		return "", fmt.Errorf("OIDC discovery: %w", err)
+	}
+	if doc.Issuer != o.config.Issuer {
+		return "", fmt.Errorf("OIDC discovery: issuer %q, want %q", doc.Issuer, o.config.Issuer)
+	}
+	if doc.JWKSURI == "" {
+		return "", errors.New("OIDC discovery: no jwks_uri")
+	}
+	return doc.JWKSURI, nil
+}
+
+// fetchKeys replaces the signing keys with those published at JWKSURL. Callers
+// other than NewOIDC hold mu.
+func (o *OIDC) fetchKeys() error {
+	var keys jose.JSONWebKeySet
+	if err := o.get(o.config.JWKSURL, &keys); err != nil {
+		return fmt.Errorf("fetch OIDC signing keys: %w", err)
+	}
+	if len(keys.Keys) == 0 {
+		return fmt.Errorf("fetch OIDC signing keys: none at %s", o.config.JWKSURL)
+	}
+	o.keys = keys
+	o.fetched = o.config.Clock.Now()
+	log.Printf("OIDC: fetched %d signing keys from %s", len(keys.Keys), o.config.JWKSURL)
+	return nil
+}
+
+func (o *OIDC) get(url string, v any) error {
+	resp, err := o.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// keysFor returns the keys a token signed with keyID may be verified with, fetching
+// them again when they are older than KeyRefresh or keyID is unknown. The keys in
+// hand are kept if fetching fails.
+func (o *OIDC) keysFor(keyID string, now time.Time) []jose.JSONWebKey {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	age := now.Sub(o.fetched)
+	unknown := keyID != "" && len(o.keys.Key(keyID)) == 0
+	if (o.config.KeyRefresh > 0 && age >= o.config.KeyRefresh) || (unknown && age >= keyRefetchInterval) {
+		if err := o.fetchKeys(); err != nil {
+			log.Printf("OIDC: %v, keeping the %d keys fetched %v ago", err, len(o.keys.Keys), age.Round(time.Second))
+		}
+	}
+	if keyID == "" {
+		return o.keys.Keys
+	}
+	return o.keys.Key(keyID)
+}
+
+// Authenticate verifies the call's bearer token and returns the client its subject
+// claim names.
+func (o *OIDC) Authenticate(ctx context.Context) (*Identity, error) {
+	raw, ok := BearerToken(ctx)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	now := o.config.Clock.Now()
+
+	o.mu.Lock()
+	verified, ok := o.tokens[raw]
+	o.mu.Unlock()
+	if ok && now.Before(verified.expiry) {
+		return verified.id, nil
+	}
+
+	token, err := jwt.ParseSigned(raw, signatureAlgorithms)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT: %w", err)
+	}
+	var claims jwt.Claims
+	var all map[string]any
+	err = errors.New("no key to verify the JWT with")
+	for _, key := range o.keysFor(token.Headers[0].KeyID, now) {
+		if err = token.Claims(key.Key, &claims, &all); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT: %w", err)
+	}
+
+	if claims.Expiry == nil {
+		return nil, errors.New("invalid JWT: no expiry")
+	}
+	expected := jwt.Expected{Issuer: o.config.Issuer, AnyAudience: jwt.Audience{o.config.Audience}, Time: now}
+	if err := claims.ValidateWithLeeway(expected, clockLeeway); err != nil {
+		return nil, fmt.Errorf("invalid JWT: %w", err)
+	}
+	name, _ := all[o.config.SubjectClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("invalid JWT: no %s claim", o.config.SubjectClaim)
+	}
+
+	id := &Identity{Name: name, Method: MethodOIDC}
+	o.remember(raw, verifiedToken{id: id, expiry: claims.Expiry.Time()})
+	return id, nil
+}
+
+// remember keeps a verified token until it expires, dropping expired ones when
+// maxCachedTokens are kept and all of them if that doesn't make room.
+func (o *OIDC) remember(raw string, verified verifiedToken) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.tokens) >= maxCachedTokens {
+		now := o.config.Clock.Now()
+		for raw, t := range o.tokens {
+			if !now.Before(t.expiry) {
+				delete(o.tokens, raw)
+			}
+		}
+		if len(o.tokens) >= maxCachedTokens {
+			clear(o.tokens)
+		}
+	}
+	o.tokens[raw] = verified
+}
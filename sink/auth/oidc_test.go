@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+
+	"github.com/sink/clock"
+)
+
+// provider is an OpenID Connect provider serving its discovery document and
+// signing keys.
+type provider struct {
+	*httptest.Server
+	t *testing.T
+
+	mu      sync.Mutex
+	keys    map[string]*ecdsa.PrivateKey
+	fetches int
+}
+
+func newProvider(t *testing.T) *provider {
+	p := &provider{t: t, keys: map[string]*ecdsa.PrivateKey{}}
+	p.rotate("key-1")
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": p.URL, "jwks_uri": p.URL + "/keys"})
+		case "/keys":
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.fetches++
+			var set jose.JSONWebKeySet
+			for id, key := range p.keys {
+				set.Keys = append(set.Keys, jose.JSONWebKey{Key: key.Public(), KeyID: id, Algorithm: string(jose.ES256), Use: "sig"})
+			}
+			json.NewEncoder(w).Encode(set)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// rotate adds a signing key.
+func (p *provider) rotate(keyID string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	p.mu.Lock()
+	p.keys[keyID] = key
+	p.mu.Unlock()
+}
+
+// token returns a JWT signed with keyID.
+func (p *provider) token(keyID string, claims jwt.Claims, extra map[string]any) string {
+	p.mu.Lock()
+	key := p.keys[keyID]
+	p.mu.Unlock()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: jose.JSONWebKey{Key: key, KeyID: keyID}}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	raw, err := jwt.Signed(signer).Claims(claims).Claims(extra).Serialize()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return raw
+}
+
+func (p *provider) keyFetches() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fetches
+}
+
+func TestOIDC(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	p := newProvider(t)
+	clk := clock.NewFake(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	o, err := NewOIDC(OIDCConfig{Issuer: p.URL, Audience: "telemetry-sink", KeyRefresh: time.Hour, Clock: clk})
+	if err != nil {
+		t.Fatalf("NewOIDC() error = %v", err)
+	}
+
+	now := clk.Now()
+	valid := jwt.Claims{
+		Issuer:   p.URL,
+		Subject:  "sensor-fleet/plant-a",
+		Audience: jwt.Audience{"telemetry-sink"},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(5 * time.Minute)),
+	}
+	id, err := o.Authenticate(withToken(p.token("key-1", valid, nil)))
+	if err != nil || id.Name != "sensor-fleet/plant-a" || id.Method != MethodOIDC {
+		t.Fatalf("Authenticate(valid JWT) = %+v, %v, want sensor-fleet/plant-a", id, err)
+	}
+
+	for name, modify := range map[string]func(*jwt.Claims){
+		"wrong issuer":   func(c *jwt.Claims) { c.Issuer = "https://other.example" },
+		"wrong audience": func(c *jwt.Claims) { c.Audience = jwt.Audience{"other-service"} },
+		"expired":        func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-2 * clockLeeway)) },
+		"no expiry":      func(c *jwt.Claims) { c.Expiry = nil },
+		"no subject":     func(c *jwt.Claims) { c.Subject = "" },
+	} {
+		claims := valid
+		modify(&claims)
+		if _, err := o.Authenticate(withToken(p.token("key-1", claims, nil))); err == nil {
+			t.Errorf("Authenticate(JWT with %s) error = nil, want it rejected", name)
+		}
+	}
+
+	// A token signed by another key with the same key ID is rejected.
+	other := newProvider(t)
+	if _, err := o.Authenticate(withToken(other.token("key-1", valid, nil))); err == nil {
+		t.Error("Authenticate(JWT of another provider) error = nil, want it rejected")
+	}
+	if _, err := o.Authenticate(withToken("not-a-jwt")); err == nil || !strings.Contains(err.Error(), "invalid JWT") {
+		t.Errorf("Authenticate(malformed token) error = %v, want invalid JWT", err)
+	}
+
+	// The verified token is kept until it expires.
+	raw := p.token("key-1", valid, nil)
+	o.Authenticate(withToken(raw))
+	clk.Advance(5*time.Minute + 2*clockLeeway)
+	if _, err := o.Authenticate(withToken(raw)); err == nil {
+		t.Error("Authenticate(token past its expiry) error = nil, want it rejected")
+	}
+}
+
+func TestOIDC_KeyRotation(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	p := newProvider(t)
+	clk := clock.NewFake(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	o, err := NewOIDC(OIDCConfig{Issuer: p.URL, Audience: "telemetry-sink", JWKSURL: p.URL + "/keys", SubjectClaim: "client_id", KeyRefresh: time.Hour, Clock: clk})
+	if err != nil {
+		t.Fatalf("NewOIDC() error = %v", err)
+	}
+	claims := func() jwt.Claims {
+		return jwt.Claims{Issuer: p.URL, Audience: jwt.Audience{"telemetry-sink"}, Expiry: jwt.NewNumericDate(clk.Now().Add(time.Hour))}
+	}
+	clientID := map[string]any{"client_id": "gateway-3"}
+
+	p.rotate("key-2")
+	// Unknown keys are fetched at most every keyRefetchInterval.
+	if _, err := o.Authenticate(withToken(p.token("key-2", claims(), clientID))); err == nil {
+		t.Error("Authenticate(JWT of a new key) right after start error = nil, want it rejected")
+	}
+	clk.Advance(keyRefetchInterval)
+	id, err := o.Authenticate(withToken(p.token("key-2", claims(), clientID)))
+	if err != nil || id.Name != "gateway-3" {
+		t.Errorf("Authenticate(JWT of a new key) = %+v, %v, want gateway-3", id, err)
+	}
+	if fetches := p.keyFetches(); fetches != 2 {
+		t.Errorf("key fetches = %d, want 2", fetches)
+	}
+
+	// The keys the sink holds are kept when refreshing them fails.
+	p.Close()
+	clk.Advance(time.Hour)
+	if _, err := o.Authenticate(withToken(p.token("key-1", claims(), clientID))); err != nil {
+		t.Errorf("Authenticate() with the provider down error = %v, want the known keys used", err)
+	}
+}
+
+func TestNewOIDC_Discovery(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	p := newProvider(t)
+	if _, err := NewOIDC(OIDCConfig{Issuer: p.URL + "/other", Audience: "telemetry-sink"}); err == nil {
+		t.Error("NewOIDC(issuer without discovery) error = nil, want an error")
+	}
+	if _, err := NewOIDC(OIDCConfig{Issuer: p.URL}); err == nil {
+		t.Error("NewOIDC(no audience) error = nil, want an error")
+	}
+}
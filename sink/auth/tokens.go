@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tokens authenticates clients by static bearer tokens. Only the SHA-256 of each
+// token is kept, so the file doesn't hold secrets the sink never needs to send.
+type Tokens struct {
+	names map[[sha256.Size]byte]string // client name by token hash
+}
+
+// tokensFile is the YAML form of Tokens.
+type tokensFile struct {
+	Tokens []struct {
+		Name   string `yaml:"name"`
+		SHA256 string `yaml:"sha256"` // hex SHA-256 of the token
+	} `yaml:"tokens"`
+}
+
+// LoadTokens reads a YAML token file.
+func LoadTokens(path string) (*Tokens, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read token file: %w", err)
+	}
+	return parseTokens(data)
+}
+
+func parseTokens(data []byte) (*Tokens, error) {
+	var f tokensFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse token file: %w", err)
+	}
+
+	t := &Tokens{names: make(map[[sha256.Size]byte]string, len(f.Tokens))}
+	for i, token := range f.Tokens {
+		if token.Name == "" {
+			return nil, fmt.Errorf("token %d: name is required", i+1)
+		}
+		hash, err := hex.DecodeString(token.SHA256)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("token %s: sha256 must be 64 hex digits", token.Name)
+		}
+		key := [sha256.Size]byte(hash)
+		if other, ok := t.names[key]; ok {
+			return nil, fmt.Errorf("token %s: same token as %s", token.Name, other)
+		}
+		t.names[key] = token.Name
+	}
+	return t, nil
+}
+
+// Len returns the number of tokens.
+func (t *Tokens) Len() int {
+	return len(t.names)
+}
+
+// Authenticate returns the client named for the call's bearer token.
+func (t *Tokens) Authenticate(ctx context.Context) (*Identity, error) {
+	token, ok := BearerToken(ctx)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	name, ok := t.names[sha256.Sum256([]byte(token))]
+	if !ok {
+		return nil, fmt.Errorf("unknown bearer token")
+	}
+	return &Identity{Name: name, Method: MethodToken}, nil
+}
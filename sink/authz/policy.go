@@ -0,0 +1,222 @@
+package authz
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sink/clock"
+	"github.com/sink/pkg/glob"
+	"github.com/sink/ratelimit"
+)
+
+// Policy maps client identities and certificate attributes to permissions. Rules are
+// evaluated in order and the first rule whose match block fits the client applies.
+type Policy struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Rule grants a set of permissions to clients matching its attributes.
+type Rule struct {
+	Name    string   `yaml:"name"`
+	Match   Match    `yaml:"match"`
+	Sensors []string `yaml:"sensors"`  // sensor name patterns the client may report for
+	Tenants []string `yaml:"tenants"`  // tenant patterns, empty allows any tenant
+	MaxRate int      `yaml:"max_rate"` // bytes per second per client, 0 is unlimited
+
+	sensors []*regexp.Regexp
+	tenants []*regexp.Regexp
+	limiter *ratelimit.LocalKeyedLimiter
+}
+
+// Match lists identity and certificate attribute patterns. Empty fields match
+// anything; all non-empty fields must match, so a rule with certificate patterns
+// never matches a client authenticated without one. Patterns support '*' as a
+// wildcard for any sequence.
+type Match struct {
+	Subject string `yaml:"subject"` // the client's identity: its certificate's SPIFFE ID or CN, token name or JWT subject
+	CN      string `yaml:"cn"`
+	OU      string `yaml:"ou"`
+	SAN     string `yaml:"san"` // matched against DNS, URI, email and IP SANs
+
+	subject *regexp.Regexp
+	cn      *regexp.Regexp
+	ou      *regexp.Regexp
+	san     *regexp.Regexp
+}
+
+// LoadPolicy reads and compiles a YAML policy file. Rule rate limits read time from
+// clk.
+func LoadPolicy(path string, clk clock.Clock) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy file: %w", err)
+	}
+
+	return parsePolicy(data, clk)
+}
+
+func parsePolicy(data []byte, clk clock.Clock) (*Policy, error) {
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("parse policy file: %w", err)
+	}
+
+	if err := policy.compile(clk); err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+func (p *Policy) compile(clk clock.Clock) error {
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if len(rule.Sensors) == 0 {
+			return fmt.Errorf("rule %s: at least one sensor pattern is required", rule.Name)
+		}
+
+		var err error
+		if rule.Match.subject, err = compileOptional(rule.Match.Subject); err != nil {
+			return fmt.Errorf("rule %s: subject: %w", rule.Name, err)
+		}
+		if rule.Match.cn, err = compileOptional(rule.Match.CN); err != nil {
+			return fmt.Errorf("rule %s: cn: %w", rule.Name, err)
+		}
+		if rule.Match.ou, err = compileOptional(rule.Match.OU); err != nil {
+			return fmt.Errorf("rule %s: ou: %w", rule.Name, err)
+		}
+		if rule.Match.san, err = compileOptional(rule.Match.SAN); err != nil {
+			return fmt.Errorf("rule %s: san: %w", rule.Name, err)
+		}
+		if rule.sensors, err = compileAll(rule.Sensors); err != nil {
+			return fmt.Errorf("rule %s: sensors: %w", rule.Name, err)
+		}
+		if rule.tenants, err = compileAll(rule.Tenants); err != nil {
+			return fmt.Errorf("rule %s: tenants: %w", rule.Name, err)
+		}
+
+		if rule.MaxRate > 0 {
+			// Clients are bounded by the certificates issued, idle buckets still expire.
+			rule.limiter = ratelimit.NewLocalKeyedLimiter(rule.MaxRate, 0, clk)
+		}
+	}
+
+	return nil
+}
+
+// RuleFor returns the first rule matching the client identified as subject, with its
+// certificate or nil, or nil if none does.
+func (p *Policy) RuleFor(subject string, cert *x509.Certificate) *Rule {
+	for _, rule := range p.Rules {
+		if rule.Match.matches(subject, cert) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// AllowsSensor reports whether the rule permits reporting for the sensor name.
+func (r *Rule) AllowsSensor(sensorName string) bool {
+	return matchAny(r.sensors, sensorName)
+}
+
+// AllowsTenant reports whether the rule permits reporting for the tenant.
+func (r *Rule) AllowsTenant(tenant string) bool {
+	if len(r.tenants) == 0 {
+		return true
+	}
+	return matchAny(r.tenants, tenant)
+}
+
+// AllowRate applies the rule's per-client rate limit to a request of the given size.
+func (r *Rule) AllowRate(ctx context.Context, identity string, bytes int) bool {
+	if r.limiter == nil {
+		return true
+	}
+	return r.limiter.Allow(ctx, identity, bytes)
+}
+
+// RateQuota returns what is left of the rule's per-client rate limit, and false when
+// the rule sets none.
+func (r *Rule) RateQuota(ctx context.Context, identity string) (ratelimit.Quota, bool) {
+	if r.limiter == nil {
+		return ratelimit.Quota{}, false
+	}
+	return r.limiter.Quota(ctx, identity), true
+}
+
+func (m *Match) matches(subject string, cert *x509.Certificate) bool {
+	if m.subject != nil && !m.subject.MatchString(subject) {
+		return false
+	}
+	if cert == nil {
+		return m.cn == nil && m.ou == nil && m.san == nil
+	}
+	if m.cn != nil && !m.cn.MatchString(cert.Subject.CommonName) {
+		return false
+	}
+	if m.ou != nil && !matchAnyValue(m.ou, cert.Subject.OrganizationalUnit) {
+		return false
+	}
+	if m.san != nil && !matchAnyValue(m.san, subjectAltNames(cert)) {
+		return false
+	}
+	return true
+}
+
+func subjectAltNames(cert *x509.Certificate) []string {
+	names := make([]string, 0, len(cert.DNSNames)+len(cert.URIs)+len(cert.EmailAddresses)+len(cert.IPAddresses))
+	names = append(names, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+func compileOptional(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return glob.Compile(pattern)
+}
+
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func matchAny(patterns []*regexp.Regexp, value string) bool {
+	for _, re := range patterns {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAnyValue(re *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
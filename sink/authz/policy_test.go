@@ -0,0 +1,118 @@
+package authz
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/sink/clock"
+)
+
+const testPolicy = `
+rules:
+  - name: spiffe-workloads
+    match:
+      san: "spiffe://plant.example/sensors/*"
+    sensors: ["*"]
+    tenants: ["plant-*"]
+  - name: hvac
+    match:
+      cn: "hvac-*"
+      ou: "Facilities"
+    sensors: ["temperature-*", "humidity-*"]
+    max_rate: 100
+  - name: fleet
+    match:
+      subject: "sensor-fleet/*"
+    sensors: ["*"]
+`
+
+func TestPolicy_RuleFor(t *testing.T) {
+	policy, err := parsePolicy([]byte(testPolicy), clock.Real)
+	if err != nil {
+		t.Fatalf("parsePolicy() error = %v", err)
+	}
+
+	spiffeID, _ := url.Parse("spiffe://plant.example/sensors/line-3")
+
+	tests := []struct {
+		name         string
+		subject      string
+		cert         *x509.Certificate
+		expectedRule string
+	}{
+		{
+			name:         "match by CN and OU",
+			cert:         &x509.Certificate{Subject: pkix.Name{CommonName: "hvac-01", OrganizationalUnit: []string{"Dev", "Facilities"}}},
+			expectedRule: "hvac",
+		},
+		{
+			name:         "CN matches but OU does not",
+			cert:         &x509.Certificate{Subject: pkix.Name{CommonName: "hvac-01", OrganizationalUnit: []string{"Dev"}}},
+			expectedRule: "",
+		},
+		{
+			name:         "match by URI SAN with slashes",
+			cert:         &x509.Certificate{URIs: []*url.URL{spiffeID}},
+			expectedRule: "spiffe-workloads",
+		},
+		{
+			name:         "no attributes match",
+			cert:         &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}},
+			expectedRule: "",
+		},
+		{
+			name:         "match by subject without a certificate",
+			subject:      "sensor-fleet/plant-a",
+			expectedRule: "fleet",
+		},
+		{
+			name:         "certificate patterns need a certificate",
+			subject:      "hvac-01",
+			expectedRule: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := policy.RuleFor(tt.subject, tt.cert)
+
+			var name string
+			if rule != nil {
+				name = rule.Name
+			}
+			if name != tt.expectedRule {
+				t.Errorf("RuleFor() = %q, want %q", name, tt.expectedRule)
+			}
+		})
+	}
+}
+
+func TestRule_Permissions(t *testing.T) {
+	policy, err := parsePolicy([]byte(testPolicy), clock.Real)
+	if err != nil {
+		t.Fatalf("parsePolicy() error = %v", err)
+	}
+	hvac := policy.Rules[1]
+
+	if !hvac.AllowsSensor("temperature-01") {
+		t.Error("hvac should be allowed to report temperature-01")
+	}
+	if hvac.AllowsSensor("pressure-01") {
+		t.Error("hvac should not be allowed to report pressure-01")
+	}
+	if !hvac.AllowsTenant("anything") {
+		t.Error("rule without tenants should allow any tenant")
+	}
+	if policy.Rules[0].AllowsTenant("office") {
+		t.Error("spiffe-workloads should only allow plant-* tenants")
+	}
+}
+
+func TestParsePolicy_RequiresSensors(t *testing.T) {
+	_, err := parsePolicy([]byte("rules:\n  - name: empty\n    match:\n      cn: \"x\"\n"), clock.Real)
+	if err == nil {
+		t.Error("parsePolicy() should reject rules without sensor patterns")
+	}
+}
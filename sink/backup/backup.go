@@ -0,0 +1,258 @@
+// Package backup snapshots the storage of a sink log and restores it: the active log,
+// its rotated and compacted segments and the compaction manifest, along with what is
+// needed to read them again, short of the encryption key itself.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sink/compact"
+	"github.com/sink/storage"
+)
+
+// ManifestName is the name of the manifest in a snapshot or backup directory. A backup
+// is complete once it is there, as it is written last.
+const ManifestName = "backup.json"
+
+// Encryption describes how an encrypted log was written. The key is never backed up.
+type Encryption struct {
+	Cipher string `json:"cipher,omitempty"`
+	Mode   string `json:"mode,omitempty"` // config.EncryptionModeEntry or EncryptionModeSegment
+	KeyID  string `json:"key_id"`         // of the key, as encryption.Encryptor.KeyID gives it
+}
+
+// File is a file of the log's storage.
+type File struct {
+	Name   string `json:"name"`             // base name
+	Size   int64  `json:"size"`             // bytes backed up
+	SHA256 string `json:"sha256,omitempty"` // hex, set once the file is copied
+}
+
+// Manifest describes a snapshot or backup.
+type Manifest struct {
+	Created    time.Time         `json:"created"`
+	Log        string            `json:"log"`                   // base name of the active log
+	Format     string            `json:"format,omitempty"`      // config.LogFormatText or LogFormatBinary
+	Fields     map[string]string `json:"fields,omitempty"`      // renamed fields of the entries
+	TimeFormat string            `json:"time_format,omitempty"` // of the entries' times, with Fields
+	Encryption *Encryption       `json:"encryption,omitempty"`
+	Files      []File            `json:"files"`
+}
+
+// Snapshot hard-links the storage of the log at path into dir, which is created and
+// so must not exist, and writes m there listing the files. The log must not be
+// written meanwhile. Linked files are unaffected by later rotations and compactions;
+// of the active log, only the bytes written by now are part of the snapshot.
+func Snapshot(path, dir string, m *Manifest) error {
+	names, err := compact.Files(path)
+	if err != nil {
+		return fmt.Errorf("list log files: %w", err)
+	}
+	m.Log = filepath.Base(path)
+	m.Files = m.Files[:0]
+	// The active log goes last: restored, it is the one written to next.
+	if _, err := os.Stat(path); err == nil {
+		names = append(names, m.Log)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return fmt.Errorf("create snapshot: %w", err)
+	}
+	logDir := filepath.Dir(path)
+	for _, name := range names {
+		target := filepath.Join(dir, name)
+		if err := os.Link(filepath.Join(logDir, name), target); err != nil {
+			os.RemoveAll(dir)
+			return fmt.Errorf("link %s: %w", name, err)
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+		m.Files = append(m.Files, File{Name: name, Size: info.Size()})
+	}
+
+	if err := writeManifest(dir, m); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("write snapshot manifest: %w", err)
+	}
+	return nil
+}
+
+// Copy copies the snapshot in dir to dest, which is created and so must not exist,
+// checksumming every file. The backup's manifest is written last, once the files are
+// synced to disk.
+func Copy(dir, dest string) (*Manifest, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(dest, 0700); err != nil {
+		return nil, fmt.Errorf("create backup: %w", err)
+	}
+	for i, file := range m.Files {
+		if m.Files[i].SHA256, err = copyFile(filepath.Join(dir, file.Name), filepath.Join(dest, file.Name), file.Size); err != nil {
+			return nil, fmt.Errorf("copy %s: %w", file.Name, err)
+		}
+	}
+	if err := writeManifest(dest, m); err != nil {
+		return nil, fmt.Errorf("write backup manifest: %w", err)
+	}
+	return m, nil
+}
+
+// Verify checks the files of the backup in dir against the sizes and checksums in
+// its manifest.
+func Verify(dir string) (*Manifest, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, file := range m.Files {
+		if err := verifyFile(filepath.Join(dir, file.Name), file); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", file.Name, err))
+		}
+	}
+	return m, errors.Join(errs...)
+}
+
+func verifyFile(path string, file File) error {
+	if file.SHA256 == "" {
+		return errors.New("no checksum, the backup was never completed")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	switch {
+	case err != nil:
+		return err
+	case n != file.Size:
+		return fmt.Errorf("%d bytes, want %d", n, file.Size)
+	case hex.EncodeToString(h.Sum(nil)) != file.SHA256:
+		return errors.New("checksum mismatch")
+	}
+	return nil
+}
+
+// Restore verifies the backup in src and copies its files into dir, the directory
+// of the sink's log. It refuses to replace any file, so a sink's storage is only
+// rebuilt into an empty place. Files are copied under temporary names and renamed
+// once all are synced, so an interrupted restore leaves no partial log behind.
+func Restore(src, dir string) (*Manifest, error) {
+	m, err := Verify(src)
+	if err != nil {
+		return nil, fmt.Errorf("verify backup: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	for _, file := range m.Files {
+		if _, err := os.Lstat(filepath.Join(dir, file.Name)); err == nil {
+			return nil, fmt.Errorf("%s already exists in %s", file.Name, dir)
+		}
+	}
+
+	var temps []string
+	defer func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+	}()
+	for _, file := range m.Files {
+		temp := filepath.Join(dir, file.Name+".restore")
+		temps = append(temps, temp)
+		if _, err := copyFile(filepath.Join(src, file.Name), temp, file.Size); err != nil {
+			return nil, fmt.Errorf("copy %s: %w", file.Name, err)
+		}
+	}
+	for i, file := range m.Files {
+		if err := os.Rename(temps[i], filepath.Join(dir, file.Name)); err != nil {
+			return nil, err
+		}
+	}
+	temps = nil
+	return m, storage.SyncDir(dir)
+}
+
+// ReadManifest reads the manifest of the snapshot or backup in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// copyFile copies the first size bytes of src to a new file dst, synced to disk, and
+// returns their checksum.
+func copyFile(src, dst string, size int64) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(out, h), in, size); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeManifest writes m to dir through a synced temporary file.
+func writeManifest(dir string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, ManifestName+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, ManifestName)); err != nil {
+		return err
+	}
+	return storage.SyncDir(dir)
+}
@@ -0,0 +1,126 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sink/compact"
+	"github.com/sink/storage"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSnapshotCopyRestore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "telemetry.log")
+	segment := storage.SegmentPath(path, time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC))
+	writeFile(t, segment, "{\"sensor_name\":\"a\"}\n")
+	writeFile(t, compact.ManifestPath(path), "{}\n")
+	writeFile(t, path, "{\"sensor_name\":\"b\"}\n")
+	writeFile(t, filepath.Join(dir, "unrelated.log"), "x")
+
+	snapshot := filepath.Join(dir, ".snapshot")
+	m := &Manifest{Format: "text", Encryption: &Encryption{KeyID: "0123456789abcdef"}}
+	if err := Snapshot(path, snapshot, m); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	// Writes and compactions after the snapshot don't change it.
+	appendFile(t, path, "{\"sensor_name\":\"c\"}\n")
+	os.Remove(segment)
+
+	dest := filepath.Join(t.TempDir(), "backup")
+	if _, err := Copy(snapshot, dest); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	backup, err := Verify(dest)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	var names []string
+	for _, file := range backup.Files {
+		names = append(names, file.Name)
+	}
+	want := []string{filepath.Base(segment), "telemetry.log.manifest", "telemetry.log"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("backup files = %v, want %v", names, want)
+	}
+	if backup.Log != "telemetry.log" || backup.Format != "text" || backup.Encryption == nil || backup.Encryption.KeyID != "0123456789abcdef" {
+		t.Errorf("backup manifest = %+v, want the log, format and key ID recorded", backup)
+	}
+
+	restored := filepath.Join(t.TempDir(), "sink")
+	if _, err := Restore(dest, restored); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if got := readFile(t, filepath.Join(restored, "telemetry.log")); got != "{\"sensor_name\":\"b\"}\n" {
+		t.Errorf("restored log = %q, want the entry written before the snapshot only", got)
+	}
+	if got := readFile(t, filepath.Join(restored, filepath.Base(segment))); got != "{\"sensor_name\":\"a\"}\n" {
+		t.Errorf("restored segment = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(restored, "unrelated.log")); err == nil {
+		t.Error("unrelated file restored")
+	}
+
+	// A sink's storage is never overwritten.
+	if _, err := Restore(dest, restored); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Restore() into existing storage error = %v, want already exists", err)
+	}
+}
+
+func TestVerify_DetectsDamage(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "telemetry.log")
+	writeFile(t, path, "{\"sensor_name\":\"a\"}\n")
+	snapshot := filepath.Join(dir, ".snapshot")
+	if err := Snapshot(path, snapshot, &Manifest{}); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	// A snapshot has no checksums until it is copied.
+	if _, err := Verify(snapshot); err == nil {
+		t.Error("Verify() of a snapshot succeeded, want an incomplete backup")
+	}
+
+	dest := filepath.Join(t.TempDir(), "backup")
+	if _, err := Copy(snapshot, dest); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	writeFile(t, filepath.Join(dest, "telemetry.log"), "{\"sensor_name\":\"x\"}\n")
+	if _, err := Verify(dest); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Verify() of a damaged backup error = %v, want checksum mismatch", err)
+	}
+	if _, err := Restore(dest, filepath.Join(t.TempDir(), "sink")); err == nil {
+		t.Error("Restore() of a damaged backup succeeded")
+	}
+}
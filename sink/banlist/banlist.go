@@ -0,0 +1,139 @@
+// Package banlist bans client IPs that repeatedly fail authentication, so a
+// misconfigured or hostile client stops costing handshakes and policy checks.
+package banlist
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sink/clock"
+	"github.com/sink/state"
+)
+
+// Ban is a banned client IP.
+type Ban struct {
+	IP     string    `json:"ip"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// List counts authentication failures per client IP and bans an IP for a while once
+// it fails too often within a window. Failures and bans are kept in bounded tables,
+// so a flood of addresses can't exhaust memory.
+type List struct {
+	after    int
+	window   time.Duration
+	duration time.Duration
+	clock    clock.Clock
+
+	mu       sync.Mutex
+	failures *state.Table[string, *failures]
+	bans     *state.Table[string, Ban]
+}
+
+type failures struct {
+	count int
+	since time.Time // first failure counted in the window
+}
+
+// New creates a list banning an IP for duration once it fails after times within
+// window.
+func New(after int, window, duration time.Duration, clk clock.Clock) *List {
+	return &List{
+		after:    after,
+		window:   window,
+		duration: duration,
+		clock:    clk,
+		failures: state.NewTable[string, *failures]("auth_failures", state.Limits{TTL: window, MaxEntries: state.DefaultMaxEntries}),
+		bans:     state.NewTable[string, Ban]("bans", state.Limits{MaxEntries: state.DefaultMaxEntries}),
+	}
+}
+
+// Fail records a failed authentication from ip and returns the ban if it got the
+// IP banned.
+func (l *List) Fail(ip string) (Ban, bool) {
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f := l.failures.GetOrCreate(ip, now, func() *failures { return &failures{since: now} })
+	if now.Sub(f.since) >= l.window {
+		*f = failures{since: now}
+	}
+	f.count++
+	if f.count < l.after {
+		return Ban{}, false
+	}
+
+	l.failures.Delete(ip)
+	ban := Ban{IP: ip, Until: now.Add(l.duration), Reason: "failed authentication"}
+	l.bans.GetOrCreate(ip, now, func() Ban { return ban })
+	return ban, true
+}
+
+// Banned returns the ban of ip, if it is banned. A nil List bans nothing.
+func (l *List) Banned(ip string) (Ban, bool) {
+	if l == nil {
+		return Ban{}, false
+	}
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ban, ok := l.bans.Get(ip, now)
+	if !ok {
+		return Ban{}, false
+	}
+	if !now.Before(ban.Until) {
+		l.bans.Delete(ip)
+		return Ban{}, false
+	}
+	return ban, true
+}
+
+// Ban bans ip for d, replacing any ban it has.
+func (l *List) Ban(ip string, d time.Duration, reason string) Ban {
+	now := l.clock.Now()
+	ban := Ban{IP: ip, Until: now.Add(d), Reason: reason}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bans.Delete(ip)
+	l.bans.GetOrCreate(ip, now, func() Ban { return ban })
+	return ban
+}
+
+// Unban lifts the ban of ip and forgets its failures. It reports whether ip was
+// banned.
+func (l *List) Unban(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.failures.Delete(ip)
+	_, ok := l.bans.Get(ip, l.clock.Now())
+	l.bans.Delete(ip)
+	return ok
+}
+
+// List returns the bans in effect, by IP.
+func (l *List) List() []Ban {
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var bans []Ban
+	l.bans.Range(func(_ string, ban Ban) bool {
+		if now.Before(ban.Until) {
+			bans = append(bans, ban)
+		}
+		return true
+	})
+	slices.SortFunc(bans, func(a, b Ban) int { return strings.Compare(a.IP, b.IP) })
+	return bans
+}
@@ -0,0 +1,68 @@
+package banlist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sink/clock"
+)
+
+func TestList(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := New(3, time.Minute, 10*time.Minute, clk)
+
+	l.Fail("10.0.0.1")
+	l.Fail("10.0.0.1")
+	if _, banned := l.Banned("10.0.0.1"); banned {
+		t.Fatal("IP banned after 2 failures, want 3")
+	}
+	// Failures older than the window don't count.
+	clk.Advance(time.Minute)
+	l.Fail("10.0.0.1")
+	if _, banned := l.Banned("10.0.0.1"); banned {
+		t.Fatal("IP banned after failures spread over more than the window")
+	}
+	l.Fail("10.0.0.1")
+	ban, banned := l.Fail("10.0.0.1")
+	if !banned || ban.Until != clk.Now().Add(10*time.Minute) {
+		t.Fatalf("Fail() = %+v, %v, want a ban for 10m", ban, banned)
+	}
+	if _, banned := l.Banned("10.0.0.2"); banned {
+		t.Error("other IP banned")
+	}
+	if bans := l.List(); len(bans) != 1 || bans[0].IP != "10.0.0.1" {
+		t.Errorf("List() = %+v, want the ban of 10.0.0.1", bans)
+	}
+
+	clk.Advance(10 * time.Minute)
+	if _, banned := l.Banned("10.0.0.1"); banned {
+		t.Error("IP still banned after the ban expired")
+	}
+	if bans := l.List(); len(bans) != 0 {
+		t.Errorf("List() = %+v, want no bans", bans)
+	}
+}
+
+func TestList_BanUnban(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := New(3, time.Minute, 10*time.Minute, clk)
+
+	l.Ban("10.0.0.1", time.Hour, "scanner")
+	if ban, banned := l.Banned("10.0.0.1"); !banned || ban.Reason != "scanner" {
+		t.Fatalf("Banned() = %+v, %v, want the manual ban", ban, banned)
+	}
+	if !l.Unban("10.0.0.1") {
+		t.Error("Unban() = false, want true for a banned IP")
+	}
+	if _, banned := l.Banned("10.0.0.1"); banned {
+		t.Error("IP banned after Unban")
+	}
+	if l.Unban("10.0.0.1") {
+		t.Error("Unban() of an IP not banned = true")
+	}
+
+	var nilList *List
+	if _, banned := nilList.Banned("10.0.0.1"); banned {
+		t.Error("nil List bans")
+	}
+}
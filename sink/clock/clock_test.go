@@ -0,0 +1,49 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_AdvanceFiresTickers(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	ticker := c.NewTicker(time.Minute)
+
+	c.Advance(59 * time.Second)
+	select {
+	case tick := <-ticker.C():
+		t.Fatalf("ticker fired early at %v", tick)
+	default:
+	}
+
+	c.Advance(2 * time.Second)
+	select {
+	case tick := <-ticker.C():
+		if want := start.Add(time.Minute); !tick.Equal(want) {
+			t.Errorf("tick = %v, want %v", tick, want)
+		}
+	default:
+		t.Fatal("ticker did not fire after a minute")
+	}
+	if want := start.Add(61 * time.Second); !c.Now().Equal(want) {
+		t.Errorf("Now() = %v, want %v", c.Now(), want)
+	}
+
+	// Ticks the receiver misses are dropped, as with time.Ticker.
+	c.Advance(10 * time.Minute)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Error("missed ticks should be dropped")
+	default:
+	}
+
+	ticker.Stop()
+	c.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Error("stopped ticker fired")
+	default:
+	}
+}
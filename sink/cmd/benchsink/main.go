@@ -0,0 +1,224 @@
+// Command benchsink measures end-to-end ingestion: it runs a sink and gRPC clients in
+// one process, connected over an in-memory listener, and reports throughput and
+// latency percentiles. Compare its output before and after a change to catch
+// performance regressions in review.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sink/config"
+	encryption "github.com/sink/encryptor"
+	pb "github.com/sink/proto"
+	grpcserver "github.com/sink/server"
+)
+
+type options struct {
+	duration   time.Duration
+	clients    int
+	tags       int
+	bufferSize int
+	logFormat  string
+	encrypt    bool
+	cipher     string
+	mode       string
+	json       bool
+}
+
+type result struct {
+	Messages   int     `json:"messages"`
+	Errors     int     `json:"errors"`
+	Seconds    float64 `json:"seconds"`
+	MsgsPerSec float64 `json:"msgs_per_sec"`
+	P50Micros  float64 `json:"p50_us"`
+	P99Micros  float64 `json:"p99_us"`
+	MaxMicros  float64 `json:"max_us"`
+}
+
+func main() {
+	var opts options
+
+	flag.DurationVar(&opts.duration, "duration", 10*time.Second, "How long to send readings")
+	flag.IntVar(&opts.clients, "clients", 16, "Number of concurrent clients, each sending one reading at a time")
+	flag.IntVar(&opts.tags, "tags", 2, "Number of tags on every reading")
+	flag.IntVar(&opts.bufferSize, "buffer-size", 1024*1024, "Sink buffer size in bytes")
+	flag.StringVar(&opts.logFormat, "log-format", config.LogFormatText, "Sink log format: text or binary")
+	flag.BoolVar(&opts.encrypt, "encrypt", false, "Encrypt the log with a random key")
+	flag.StringVar(&opts.cipher, "encryption-cipher", encryption.CipherAESGCM, "Cipher for log encryption")
+	flag.StringVar(&opts.mode, "encryption-mode", config.EncryptionModeEntry, "Encryption granularity: entry or segment")
+	flag.BoolVar(&opts.json, "json", false, "Print the result as JSON")
+	flag.Parse()
+
+	res, err := run(opts)
+	if err != nil {
+		log.Fatalf("Benchmark failed: %v", err)
+	}
+
+	if opts.json {
+		if err := json.NewEncoder(os.Stdout).Encode(res); err != nil {
+			log.Fatalf("Failed to encode result: %v", err)
+		}
+		return
+	}
+	fmt.Printf("%d readings in %.1fs from %d clients, %d errors\n", res.Messages, res.Seconds, opts.clients, res.Errors)
+	fmt.Printf("throughput: %.0f msgs/s\n", res.MsgsPerSec)
+	fmt.Printf("latency: p50 %.0fµs, p99 %.0fµs, max %.0fµs\n", res.P50Micros, res.P99Micros, res.MaxMicros)
+}
+
+func run(opts options) (result, error) {
+	dir, err := os.MkdirTemp("", "benchsink")
+	if err != nil {
+		return result{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := config.Config{
+		LogFilePath:      filepath.Join(dir, "telemetry.log"),
+		LogFormat:        opts.logFormat,
+		BufferSize:       opts.bufferSize,
+		WriteQueueSize:   64,
+		FlushInterval:    time.Minute,
+		RateLimit:        1 << 40,
+		EnableEncryption: opts.encrypt,
+		EncryptionCipher: opts.cipher,
+		EncryptionMode:   opts.mode,
+	}
+	if opts.encrypt {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return result{}, err
+		}
+		cfg.EncryptionKey = base64.StdEncoding.EncodeToString(key)
+	}
+
+	// The sink logs every flush; keep the report readable.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	sink, err := grpcserver.NewSinkServer(cfg)
+	if err != nil {
+		return result{}, fmt.Errorf("create sink: %w", err)
+	}
+	defer sink.Close()
+
+	lis := bufconn.Listen(1024 * 1024)
+	served := make(chan error, 1)
+	go func() { served <- sink.Serve(lis) }()
+	defer func() {
+		sink.Stop()
+		<-served
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		return result{}, fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+
+	return send(pb.NewTelemetryServiceClient(conn), opts), nil
+}
+
+// send runs the clients for opts.duration and aggregates their latencies.
+func send(client pb.TelemetryServiceClient, opts options) result {
+	tags := make(map[string]string, opts.tags)
+	for i := 0; i < opts.tags; i++ {
+		tags["tag-"+strconv.Itoa(i)] = "value-" + strconv.Itoa(i)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.duration)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		failures  int
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	for c := 0; c < opts.clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+
+			var (
+				own    []time.Duration
+				failed int
+			)
+			name := "sensor-" + strconv.Itoa(c)
+			for i := 0; ctx.Err() == nil; i++ {
+				req := &pb.SensorData{
+					SensorName:  name,
+					SensorValue: int32(i),
+					Timestamp:   timestamppb.Now(),
+					Tags:        tags,
+				}
+
+				sent := time.Now()
+				_, err := client.SendSensorData(ctx, req)
+				if ctx.Err() != nil {
+					break
+				}
+				if err != nil {
+					failed++
+					continue
+				}
+				own = append(own, time.Since(sent))
+			}
+
+			mu.Lock()
+			latencies = append(latencies, own...)
+			failures += failed
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	slices.Sort(latencies)
+	return result{
+		Messages:   len(latencies),
+		Errors:     failures,
+		Seconds:    elapsed.Seconds(),
+		MsgsPerSec: float64(len(latencies)) / elapsed.Seconds(),
+		P50Micros:  micros(percentile(latencies, 0.50)),
+		P99Micros:  micros(percentile(latencies, 0.99)),
+		MaxMicros:  micros(percentile(latencies, 1)),
+	}
+}
+
+// percentile returns the latency below which the fraction p of sorted latencies fall.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+func micros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sink/config"
+	encryption "github.com/sink/encryptor"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0.50, 50 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+		{0, time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+
+	if got := percentile(nil, 0.99); got != 0 {
+		t.Errorf("percentile(nil) = %v, want 0", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	res, err := run(options{
+		duration:   200 * time.Millisecond,
+		clients:    4,
+		tags:       2,
+		bufferSize: 4096,
+		logFormat:  config.LogFormatBinary,
+		encrypt:    true,
+		cipher:     encryption.CipherXChaCha20,
+		mode:       config.EncryptionModeSegment,
+	})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	if res.Messages == 0 || res.Errors != 0 {
+		t.Errorf("run() sent %d readings with %d errors, want some and none", res.Messages, res.Errors)
+	}
+	if res.P50Micros > res.P99Micros || res.P99Micros > res.MaxMicros {
+		t.Errorf("run() percentiles out of order: p50 %v, p99 %v, max %v", res.P50Micros, res.P99Micros, res.MaxMicros)
+	}
+}
@@ -0,0 +1,237 @@
+// Command chaosproxy sits between sensor nodes and a sink and degrades the
+// connections through it: it adds latency, holds back chunks as if their packets
+// were lost and retransmitted, resets connections and caps bandwidth. It forwards
+// bytes without parsing them, so it works for gRPC, TLS and framed connections
+// alike, and exercises the nodes' retries and failover in CI and locally.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// chunkSize is the most bytes read at once, and the unit latency, loss and resets
+// apply to.
+const chunkSize = 16 * 1024
+
+type options struct {
+	latency    time.Duration
+	jitter     time.Duration
+	loss       float64
+	retransmit time.Duration
+	reset      float64
+	bandwidth  int // bytes per second each way per connection, 0 is unlimited
+	seed       uint64
+}
+
+func main() {
+	var opts options
+
+	listen := flag.String("listen", "localhost:9190", "Address nodes connect to")
+	target := flag.String("target", "localhost:9090", "Address of the sink")
+	flag.DurationVar(&opts.latency, "latency", 0, "Latency added to every chunk forwarded, either way")
+	flag.DurationVar(&opts.jitter, "jitter", 0, "Random latency added on top of -latency, up to this much")
+	flag.Float64Var(&opts.loss, "loss", 0, "Chance of a chunk being lost and retransmitted after -retransmit-delay")
+	flag.DurationVar(&opts.retransmit, "retransmit-delay", 200*time.Millisecond, "Delay of a lost chunk, as TCP retransmits it")
+	flag.Float64Var(&opts.reset, "reset", 0, "Chance per chunk of resetting the connection")
+	flag.IntVar(&opts.bandwidth, "bandwidth", 0, "Bytes per second forwarded each way per connection (0 is unlimited)")
+	flag.Uint64Var(&opts.seed, "seed", 0, "Seed of the random source losses, resets and jitter are drawn from (0 picks one and logs it)")
+	flag.Parse()
+
+	if err := opts.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if opts.seed == 0 {
+		opts.seed = uint64(time.Now().UnixNano())
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	log.Printf("Proxying %s to %s with seed %d", l.Addr(), *target, opts.seed)
+	log.Fatal(newProxy(*target, opts).serve(l))
+}
+
+func (o options) validate() error {
+	switch {
+	case o.latency < 0 || o.jitter < 0 || o.retransmit < 0:
+		return fmt.Errorf("-latency, -jitter and -retransmit-delay can't be negative")
+	case o.loss < 0 || o.loss > 1 || o.reset < 0 || o.reset > 1:
+		return fmt.Errorf("-loss and -reset must be between 0 and 1")
+	case o.bandwidth < 0:
+		return fmt.Errorf("-bandwidth can't be negative")
+	}
+	return nil
+}
+
+type proxy struct {
+	target string
+	opts   options
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newProxy(target string, opts options) *proxy {
+	return &proxy{target: target, opts: opts, rand: rand.New(rand.NewPCG(opts.seed, opts.seed))}
+}
+
+// serve accepts connections on l and proxies each to the target until l is closed.
+func (p *proxy) serve(l net.Listener) error {
+	for {
+		client, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handle(client)
+	}
+}
+
+// handle connects a client to the target and forwards between them until both
+// sides close or the connection fails or is reset.
+func (p *proxy) handle(client net.Conn) {
+	sink, err := net.Dial("tcp", p.target)
+	if err != nil {
+		log.Printf("Failed to connect %s to %s: %v", client.RemoteAddr(), p.target, err)
+		client.Close()
+		return
+	}
+	log.Printf("Connected %s to %s", client.RemoteAddr(), p.target)
+
+	c := &conn{client: client, sink: sink, closed: make(chan struct{})}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); p.forward(c, sink, client) }()
+	go func() { defer wg.Done(); p.forward(c, client, sink) }()
+	wg.Wait()
+	c.close(false)
+	log.Printf("Disconnected %s", client.RemoteAddr())
+}
+
+// conn is a proxied connection.
+type conn struct {
+	client, sink net.Conn
+	once         sync.Once
+	closed       chan struct{} // closed with the connection
+}
+
+// close closes both sides, with a TCP reset instead of an orderly close when reset
+// is set.
+func (c *conn) close(reset bool) {
+	c.once.Do(func() {
+		if reset {
+			log.Printf("Resetting %s", c.client.RemoteAddr())
+		}
+		for _, side := range []net.Conn{c.client, c.sink} {
+			if tcp, ok := side.(*net.TCPConn); ok && reset {
+				tcp.SetLinger(0)
+			}
+			side.Close()
+		}
+		close(c.closed)
+	})
+}
+
+type chunk struct {
+	data []byte
+	due  time.Time
+}
+
+// forward copies src to dst one direction of c. Chunks are read as they arrive and
+// written once their latency has passed, so latency doesn't add up under load.
+func (p *proxy) forward(c *conn, dst, src net.Conn) {
+	chunks := make(chan chunk, 64)
+	go func() {
+		defer close(chunks)
+		for {
+			buf := make([]byte, chunkSize)
+			n, err := src.Read(buf)
+			if n > 0 {
+				if p.chance(p.opts.reset) {
+					c.close(true)
+					return
+				}
+				select {
+				case chunks <- chunk{data: buf[:n], due: time.Now().Add(p.delay())}:
+				case <-c.closed:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var next time.Time // when the bandwidth allows the next write
+	for ch := range chunks {
+		time.Sleep(time.Until(ch.due))
+		if err := p.write(dst, ch.data, &next); err != nil {
+			c.close(false)
+			return
+		}
+	}
+	// Pass the end of src on to dst; the other way stays open until it ends too.
+	if tcp, ok := dst.(*net.TCPConn); ok {
+		tcp.CloseWrite()
+	}
+}
+
+// write writes data to dst within the bandwidth, in pieces of a tenth of a second
+// so that the rate stays even.
+func (p *proxy) write(dst io.Writer, data []byte, next *time.Time) error {
+	if p.opts.bandwidth == 0 {
+		_, err := dst.Write(data)
+		return err
+	}
+
+	piece := max(p.opts.bandwidth/10, 1)
+	for len(data) > 0 {
+		n := min(piece, len(data))
+		time.Sleep(time.Until(*next))
+		if _, err := dst.Write(data[:n]); err != nil {
+			return err
+		}
+		*next = later(*next, time.Now()).Add(time.Duration(n) * time.Second / time.Duration(p.opts.bandwidth))
+		data = data[n:]
+	}
+	return nil
+}
+
+// delay draws the latency of a chunk, including a retransmission when it's lost.
+func (p *proxy) delay() time.Duration {
+	d := p.opts.latency
+	if p.opts.jitter > 0 {
+		p.mu.Lock()
+		d += time.Duration(p.rand.Int64N(int64(p.opts.jitter)))
+		p.mu.Unlock()
+	}
+	if p.chance(p.opts.loss) {
+		d += p.opts.retransmit
+	}
+	return d
+}
+
+// chance draws whether an event of probability prob happens.
+func (p *proxy) chance(prob float64) bool {
+	if prob <= 0 {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rand.Float64() < prob
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startProxy runs a proxy with opts in front of an echo server and returns its
+// address.
+func startProxy(t *testing.T, opts options) string {
+	t.Helper()
+	listen := func() net.Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l
+	}
+
+	echo := listen()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	l := listen()
+	go newProxy(echo.Addr().String(), opts).serve(l)
+	return l.Addr().String()
+}
+
+// roundTrip sends data through the proxy at addr and returns the echo and how long
+// it took.
+func roundTrip(t *testing.T, addr string, data []byte) ([]byte, time.Duration, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	start := time.Now()
+	if _, err := conn.Write(data); err != nil {
+		return nil, 0, err
+	}
+	echoed := make([]byte, len(data))
+	_, err = io.ReadFull(conn, echoed)
+	return echoed, time.Since(start), err
+}
+
+func TestProxy(t *testing.T) {
+	data := bytes.Repeat([]byte("reading "), 100)
+
+	echoed, _, err := roundTrip(t, startProxy(t, options{seed: 1}), data)
+	if err != nil || !bytes.Equal(echoed, data) {
+		t.Fatalf("round trip = %d bytes, %v, want the %d bytes sent", len(echoed), err, len(data))
+	}
+}
+
+func TestProxy_Latency(t *testing.T) {
+	const latency = 50 * time.Millisecond
+	_, took, err := roundTrip(t, startProxy(t, options{latency: latency, seed: 1}), []byte("ping"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The latency is added both ways.
+	if took < 2*latency {
+		t.Errorf("round trip took %v, want at least %v", took, 2*latency)
+	}
+}
+
+func TestProxy_Loss(t *testing.T) {
+	const retransmit = 50 * time.Millisecond
+	echoed, took, err := roundTrip(t, startProxy(t, options{loss: 1, retransmit: retransmit, seed: 1}), []byte("ping"))
+	if err != nil || string(echoed) != "ping" {
+		t.Fatalf("round trip = %q, %v, want lost chunks retransmitted", echoed, err)
+	}
+	if took < 2*retransmit {
+		t.Errorf("round trip took %v, want at least %v", took, 2*retransmit)
+	}
+}
+
+func TestProxy_Bandwidth(t *testing.T) {
+	// 2000 bytes at 4000 bytes per second go in five pieces of a tenth of a
+	// second, the first at once. The echo overlaps with the way out.
+	_, took, err := roundTrip(t, startProxy(t, options{bandwidth: 4000, seed: 1}), make([]byte, 2000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 400 * time.Millisecond; took < want {
+		t.Errorf("round trip took %v, want at least %v", took, want)
+	}
+}
+
+func TestProxy_Reset(t *testing.T) {
+	_, _, err := roundTrip(t, startProxy(t, options{reset: 1, seed: 1}), []byte("ping"))
+	if err == nil {
+		t.Error("round trip error = nil, want the connection reset")
+	}
+}
+
+func TestOptions_Validate(t *testing.T) {
+	for _, opts := range []options{{latency: -time.Second}, {loss: 1.5}, {reset: -0.1}, {bandwidth: -1}} {
+		if err := opts.validate(); err == nil {
+			t.Errorf("validate(%+v) error = nil, want an error", opts)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Command frameclient sends readings to a sink's -frame-addr listener the way a
+// microcontroller without gRPC would: length-framed protobuf over one TCP or TLS
+// connection. It is a reference for device firmware and a tool for testing.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"os"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sink/pkg/frame"
+	pb "github.com/sink/proto"
+)
+
+// maxResponseSize bounds responses, which are small status messages.
+const maxResponseSize = 64 * 1024
+
+func main() {
+	addr := flag.String("addr", "localhost:9092", "Address of the sink's frame listener")
+	sensorName := flag.String("sensor-name", "frame-sensor", "Name of the sensor")
+	count := flag.Int("count", 1, "Number of readings to send")
+	interval := flag.Duration("interval", time.Second, "Interval between readings")
+	useTLS := flag.Bool("tls", false, "Connect with TLS")
+	caFile := flag.String("ca-file", "", "Path to the CA certificate the sink is verified with (default: system root CAs)")
+	certFile := flag.String("client-cert", "", "Path to client certificate file (for mTLS)")
+	keyFile := flag.String("client-key", "", "Path to client private key file (for mTLS)")
+	flag.Parse()
+
+	conn, err := dial(*addr, *useTLS, *caFile, *certFile, *keyFile)
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+
+		req := &pb.SensorData{
+			SensorName:  *sensorName,
+			SensorValue: rand.Int32N(100),
+			Timestamp:   timestamppb.Now(),
+		}
+		resp, err := send(conn, req)
+		if err != nil {
+			log.Fatalf("Failed to send reading: %v", err)
+		}
+		log.Printf("Sent: %s=%d, Response: %s", req.SensorName, req.SensorValue, resp.Message)
+	}
+}
+
+func dial(addr string, useTLS bool, caFile, certFile, keyFile string) (net.Conn, error) {
+	if !useTLS {
+		return net.Dial("tcp", addr)
+	}
+
+	config := &tls.Config{}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA certificate: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("append CA certificate")
+		}
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return tls.Dial("tcp", addr, config)
+}
+
+// send writes a reading and waits for the sink's response. Rejections are returned
+// as gRPC status errors, as a gRPC client would see them.
+func send(conn io.ReadWriter, req *pb.SensorData) (*pb.SensorDataResponse, error) {
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := frame.Write(conn, frame.TypeSensorData, payload); err != nil {
+		return nil, err
+	}
+
+	code, payload, err := frame.Read(conn, maxResponseSize)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if codes.Code(code) != codes.OK {
+		return nil, status.Error(codes.Code(code), string(payload))
+	}
+
+	resp := &pb.SensorDataResponse{}
+	if err := proto.Unmarshal(payload, resp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return resp, nil
+}
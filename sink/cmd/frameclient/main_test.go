@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sink/pkg/frame"
+	pb "github.com/sink/proto"
+)
+
+func TestSend(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	// Accepts the first reading and rejects the second, like a sink would.
+	go func() {
+		defer server.Close()
+		for i := 0; ; i++ {
+			code, payload, err := frame.Read(server, 1024)
+			if err != nil {
+				return
+			}
+			var req pb.SensorData
+			if code != frame.TypeSensorData || proto.Unmarshal(payload, &req) != nil {
+				t.Errorf("sink received frame %d %q, want a SensorData", code, payload)
+				return
+			}
+			if i == 0 {
+				resp, _ := proto.Marshal(&pb.SensorDataResponse{Message: "Received successfully"})
+				frame.Write(server, uint8(codes.OK), resp)
+			} else {
+				frame.Write(server, uint8(codes.InvalidArgument), []byte("invalid reading: timestamp is required"))
+			}
+		}
+	}()
+
+	resp, err := send(client, &pb.SensorData{SensorName: "mcu-1", SensorValue: 7})
+	if err != nil || resp.Message != "Received successfully" {
+		t.Fatalf("send() = %v, %v, want the sink's response", resp, err)
+	}
+
+	_, err = send(client, &pb.SensorData{SensorName: "mcu-1"})
+	if st := status.Convert(err); st.Code() != codes.InvalidArgument || st.Message() != "invalid reading: timestamp is required" {
+		t.Errorf("send() error = %v, want the sink's InvalidArgument", err)
+	}
+}
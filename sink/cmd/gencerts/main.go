@@ -0,0 +1,189 @@
+// Command gencerts creates a CA plus server and client certificates for testing TLS
+// and mTLS between sensor nodes and the sink.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type options struct {
+	dir      string
+	hosts    []string // server DNS names and IP addresses
+	clients  []string // client common names
+	validity time.Duration
+}
+
+func main() {
+	opts := parseFlags()
+
+	if err := generate(opts); err != nil {
+		log.Fatalf("Failed to generate certificates: %v", err)
+	}
+
+	printUsage(opts)
+}
+
+func parseFlags() options {
+	var (
+		opts    options
+		hosts   string
+		clients string
+	)
+
+	flag.StringVar(&opts.dir, "dir", "certs", "Directory to write certificates and keys to")
+	flag.StringVar(&hosts, "hosts", "localhost,127.0.0.1,::1", "Comma separated DNS names and IP addresses for the server certificate")
+	flag.StringVar(&clients, "clients", "client", "Comma separated client names; each gets <name>-cert.pem and <name>-key.pem with the name as CN")
+	flag.DurationVar(&opts.validity, "validity", 365*24*time.Hour, "Validity period of the certificates")
+	flag.Parse()
+
+	opts.hosts = splitList(hosts)
+	opts.clients = splitList(clients)
+	return opts
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func generate(opts options) error {
+	if len(opts.hosts) == 0 {
+		return fmt.Errorf("at least one server host is required")
+	}
+	if err := os.MkdirAll(opts.dir, 0700); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	notBefore := time.Now().Add(-time.Minute)
+	notAfter := notBefore.Add(opts.validity)
+
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"Telemetry Test"}, CommonName: "Telemetry-CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caCert, caKey, err := issue(opts.dir, "ca", caTemplate, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	serverTemplate := &x509.Certificate{
+		Subject:     pkix.Name{Organization: []string{"Telemetry Test"}, CommonName: opts.hosts[0]},
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range opts.hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+	if _, _, err := issue(opts.dir, "server", serverTemplate, caCert, caKey); err != nil {
+		return err
+	}
+
+	for _, client := range opts.clients {
+		clientTemplate := &x509.Certificate{
+			Subject:     pkix.Name{Organization: []string{"Telemetry Test"}, CommonName: client},
+			NotBefore:   notBefore,
+			NotAfter:    notAfter,
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if _, _, err := issue(opts.dir, client, clientTemplate, caCert, caKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// issue creates a key pair and a certificate for it, signed by parent or self-signed
+// when parent is nil, and writes them to <name>-cert.pem and <name>-key.pem.
+func issue(dir, name string, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: generate key: %w", name, err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: generate serial number: %w", name, err)
+	}
+	template.SerialNumber = serial
+
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: create certificate: %w", name, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: parse certificate: %w", name, err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: marshal key: %w", name, err)
+	}
+
+	if err := writePEM(filepath.Join(dir, name+"-cert.pem"), "CERTIFICATE", der, 0644); err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(filepath.Join(dir, name+"-key.pem"), "PRIVATE KEY", keyDER, 0600); err != nil {
+		return nil, nil, err
+	}
+
+	return cert, key, nil
+}
+
+func writePEM(path, blockType string, der []byte, mode os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, mode); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+func printUsage(opts options) {
+	path := func(name string) string {
+		return filepath.Join(opts.dir, name)
+	}
+
+	fmt.Printf("Certificates written to %s\n\n", opts.dir)
+	fmt.Println("Sink:")
+	fmt.Printf("  ./bin/server --tls --cert-file=%s --key-file=%s --ca-file=%s\n\n",
+		path("server-cert.pem"), path("server-key.pem"), path("ca-cert.pem"))
+	fmt.Println("Sensor node:")
+	for _, client := range opts.clients {
+		fmt.Printf("  ./bin/sensor_node-linux-amd64 --tls --cert-file=%s --client-cert=%s --client-key=%s\n",
+			path("ca-cert.pem"), path(client+"-cert.pem"), path(client+"-key.pem"))
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	opts := options{
+		dir:      dir,
+		hosts:    []string{"localhost", "127.0.0.1"},
+		clients:  []string{"sensor-a", "sensor-b"},
+		validity: time.Hour,
+	}
+
+	if err := generate(opts); err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+
+	caPEM, err := os.ReadFile(filepath.Join(dir, "ca-cert.pem"))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatal("ca-cert.pem is not a valid certificate")
+	}
+
+	tests := []struct {
+		name  string
+		usage x509.ExtKeyUsage
+		host  string
+	}{
+		{name: "server", usage: x509.ExtKeyUsageServerAuth, host: "localhost"},
+		{name: "server", usage: x509.ExtKeyUsageServerAuth, host: "127.0.0.1"},
+		{name: "sensor-a", usage: x509.ExtKeyUsageClientAuth},
+		{name: "sensor-b", usage: x509.ExtKeyUsageClientAuth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+tt.host, func(t *testing.T) {
+			pair, err := tls.LoadX509KeyPair(filepath.Join(dir, tt.name+"-cert.pem"), filepath.Join(dir, tt.name+"-key.pem"))
+			if err != nil {
+				t.Fatalf("LoadX509KeyPair() error = %v", err)
+			}
+
+			_, err = pair.Leaf.Verify(x509.VerifyOptions{
+				DNSName:   tt.host,
+				Roots:     roots,
+				KeyUsages: []x509.ExtKeyUsage{tt.usage},
+			})
+			if err != nil {
+				t.Errorf("Verify() error = %v", err)
+			}
+		})
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "ca-key.pem"))
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("ca-key.pem mode = %v, want 0600", info.Mode().Perm())
+	}
+}
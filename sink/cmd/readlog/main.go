@@ -0,0 +1,351 @@
+// Command readlog prints a sink log as plain JSON lines, decrypting entries and
+// segments written with -encrypt and opening values and tags sealed by sensor nodes.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/pkg/logformat"
+	pb "github.com/sink/proto"
+)
+
+type reader struct {
+	encryptor *encryption.Encryptor     // nil when the log is not encrypted
+	opener    *encryption.PayloadOpener // nil leaves sealed payloads as they are
+	entryType string                    // entryReading or entryEvent, empty prints every entry
+	bbox      *boundingBox              // nil prints entries regardless of location
+	// attachmentDir is where the sink stored attachments (-attachment-dir); empty
+	// leaves attachment IDs as they are
+	attachmentDir string
+	fields        *logformat.Fields // names of the entries' fields, nil for the default
+}
+
+// boundingBox selects entries located within it. A box whose west edge is east of
+// its east edge crosses the antimeridian.
+type boundingBox struct {
+	south, west, north, east float64
+}
+
+// parseBoundingBox parses "south,west,north,east" in degrees.
+func parseBoundingBox(value string) (*boundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("want south,west,north,east")
+	}
+
+	var coords [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid coordinate %q", part)
+		}
+		coords[i] = f
+	}
+
+	b := &boundingBox{south: coords[0], west: coords[1], north: coords[2], east: coords[3]}
+	switch {
+	case !(b.south >= -90 && b.north <= 90 && b.south <= b.north):
+		return nil, fmt.Errorf("latitudes must be within -90 to 90, south first")
+	case !(b.west >= -180 && b.west <= 180 && b.east >= -180 && b.east <= 180):
+		return nil, fmt.Errorf("longitudes must be within -180 to 180")
+	}
+	return b, nil
+}
+
+func (b *boundingBox) contains(latitude, longitude float64) bool {
+	if latitude < b.south || latitude > b.north {
+		return false
+	}
+	if b.west <= b.east {
+		return longitude >= b.west && longitude <= b.east
+	}
+	return longitude >= b.west || longitude <= b.east
+}
+
+// Entry types; the sink writes "type" only for events.
+const (
+	entryReading = "reading"
+	entryEvent   = "event"
+)
+
+func main() {
+	var r reader
+	encryptionKey := flag.String("encryption-key", os.Getenv("ENCRYPTION_KEY"), "Base64 encoded 32-byte key the sink encrypted the log with (-encrypt); defaults to ENCRYPTION_KEY")
+	encryptionKeyFile := flag.String("encryption-key-file", "", "Path to the base64 encoded 32-byte key the sink encrypted the log with")
+	payloadKeyFile := flag.String("payload-key-file", "", "Path to the base64 encoded 32-byte key sensor nodes sealed payloads with")
+	flag.StringVar(&r.entryType, "type", "", "Print only entries of this type: reading or event (default: all)")
+	flag.Func("bbox", "Print only entries located within south,west,north,east (degrees); west above east crosses the antimeridian", func(value string) error {
+		var err error
+		r.bbox, err = parseBoundingBox(value)
+		return err
+	})
+	flag.StringVar(&r.attachmentDir, "attachment-dir", "", "Directory the sink stored attachments in, to print them inline instead of their IDs")
+	flag.Func("log-fields", "Fields of the entries the sink renamed (-log-fields of the sink), e.g. timestamp=ts,type=kind", func(value string) error {
+		names, err := logformat.ParseFieldNames(value)
+		if err != nil {
+			return err
+		}
+		r.fields, err = logformat.NewFields(names, "")
+		return err
+	})
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [log file...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if r.entryType != "" && r.entryType != entryReading && r.entryType != entryEvent {
+		log.Fatalf("Invalid -type %q, want %s or %s", r.entryType, entryReading, entryEvent)
+	}
+
+	var err error
+	// The cipher is detected per line, so any choice opens every supported cipher.
+	switch {
+	case *encryptionKeyFile != "":
+		key, err := encryption.LoadKey(*encryptionKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load encryption key: %v", err)
+		}
+		r.encryptor, err = encryption.NewEncryptorFromKey(encryption.CipherAESGCM, key)
+		clear(key)
+		if err != nil {
+			log.Fatalf("Failed to create encryptor: %v", err)
+		}
+	case *encryptionKey != "":
+		if r.encryptor, err = encryption.NewEncryptor(encryption.CipherAESGCM, *encryptionKey); err != nil {
+			log.Fatalf("Failed to create encryptor: %v", err)
+		}
+	}
+	if *payloadKeyFile != "" {
+		key, err := encryption.LoadKey(*payloadKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load payload key: %v", err)
+		}
+		if r.opener, err = encryption.NewPayloadOpener(key); err != nil {
+			log.Fatalf("Failed to create payload opener: %v", err)
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	failed := 0
+	if flag.NArg() == 0 {
+		failed = r.copy(out, os.Stdin, "stdin")
+	}
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("Failed to open log file: %v", err)
+			failed++
+			continue
+		}
+		failed += r.copy(out, f, path)
+		f.Close()
+	}
+
+	if failed > 0 {
+		out.Flush()
+		log.Fatalf("%d lines could not be read", failed)
+	}
+}
+
+// copy writes every entry of in to out and returns the number of entries it failed
+// to read. Failed entries are reported and skipped.
+func (r *reader) copy(out io.Writer, in io.Reader, name string) int {
+	entries := logformat.NewReader(in, r.encryptor)
+
+	failed := 0
+	for n := 1; ; n++ {
+		entry, err := entries.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			entry, err = r.decodeEntry(entry)
+		}
+		if err != nil {
+			log.Printf("%s: entry %d: %v", name, n, err)
+			failed++
+			if errors.Is(err, logformat.ErrEntry) || errors.Is(err, errUnseal) || errors.Is(err, errAttachment) {
+				continue
+			}
+			// Broken framing leaves the reader at an unknown position.
+			break
+		}
+		if !r.wanted(entry) {
+			continue
+		}
+		out.Write(entry)
+		io.WriteString(out, "\n")
+	}
+	return failed
+}
+
+// wanted reports whether an entry has the type selected with -type and lies within
+// the -bbox box. Entries that can't be parsed are printed, so nothing is hidden by
+// mistake; entries without a location are outside every box.
+func (r *reader) wanted(entry []byte) bool {
+	if r.entryType == "" && r.bbox == nil {
+		return true
+	}
+
+	var (
+		fields   map[string]json.RawMessage
+		typ      string
+		location *struct {
+			Latitude  float64 `json:"latitude"`
+			Longitude float64 `json:"longitude"`
+		}
+	)
+	if err := json.Unmarshal(entry, &fields); err != nil {
+		return true
+	}
+	if value, ok := fields[r.fields.Name("type")]; ok && json.Unmarshal(value, &typ) != nil {
+		return true
+	}
+	if value, ok := fields[r.fields.Name("location")]; ok && json.Unmarshal(value, &location) != nil {
+		return true
+	}
+
+	if typ == "" {
+		typ = entryReading
+	}
+	if r.entryType != "" && typ != r.entryType {
+		return false
+	}
+	if r.bbox != nil && (location == nil || !r.bbox.contains(location.Latitude, location.Longitude)) {
+		return false
+	}
+	return true
+}
+
+var (
+	// errUnseal marks entries whose sealed payload could not be opened.
+	errUnseal = errors.New("cannot unseal entry")
+	// errAttachment marks entries whose stored attachment could not be read.
+	errAttachment = errors.New("cannot read attachment")
+)
+
+// decodeEntry opens the sealed payload of an entry and inlines its stored
+// attachment, if there is anything to open or inline.
+func (r *reader) decodeEntry(line []byte) ([]byte, error) {
+	if r.opener == nil && r.attachmentDir == "" {
+		return line, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	var entry map[string]any
+	if err := decoder.Decode(&entry); err != nil {
+		return nil, fmt.Errorf("%w: parse entry: %v", logformat.ErrEntry, err)
+	}
+
+	sealed, isSealed := entry[r.fields.Name("sealed_payload")].(string)
+	attachmentID, hasAttachment := entry[r.fields.Name("attachment_id")].(string)
+	isSealed = isSealed && r.opener != nil
+	hasAttachment = hasAttachment && r.attachmentDir != ""
+	if !isSealed && !hasAttachment {
+		return line, nil
+	}
+
+	if isSealed {
+		if err := r.unseal(entry, sealed); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUnseal, err)
+		}
+	}
+	if hasAttachment {
+		attachment, err := r.readAttachment(attachmentID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errAttachment, err)
+		}
+		delete(entry, r.fields.Name("attachment_id"))
+		entry[r.fields.Name("attachment")] = attachment // encoding/json writes it as base64
+	}
+	return json.Marshal(entry)
+}
+
+// readAttachment reads an attachment the sink stored in -attachment-dir, decrypting
+// it when the log is encrypted.
+func (r *reader) readAttachment(id string) ([]byte, error) {
+	// IDs are hex digests; anything else must not escape the directory.
+	if _, err := hex.DecodeString(id); err != nil || id == "" {
+		return nil, fmt.Errorf("invalid attachment ID %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(r.attachmentDir, id))
+	if err != nil {
+		return nil, err
+	}
+	if r.encryptor == nil {
+		return data, nil
+	}
+	attachment, err := r.encryptor.Decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt attachment %s: %w", id, err)
+	}
+	return attachment, nil
+}
+
+// unseal replaces the entry's sealed payload with the value and tags inside it. Tags
+// added by the sink, such as clock_skew, are kept.
+func (r *reader) unseal(entry map[string]any, sealed string) error {
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return fmt.Errorf("decode sealed payload: %w", err)
+	}
+
+	sensorName, _ := entry[r.fields.Name("sensor_name")].(string)
+	payload, err := r.opener.Open(sensorName, ciphertext)
+	if err != nil {
+		return fmt.Errorf("open sealed payload of %s: %w", sensorName, err)
+	}
+
+	delete(entry, r.fields.Name("sealed_payload"))
+	switch {
+	case len(payload.Values) > 0:
+		entry[r.fields.Name("values")] = payload.Values
+	case payload.Aggregate != nil:
+		entry[r.fields.Name("aggregate")] = aggregateJSON(payload.Aggregate)
+	default:
+		entry[r.fields.Name("sensor_value")] = payload.SensorValue
+	}
+	if len(payload.Tags) > 0 {
+		tags, _ := entry[r.fields.Name("tags")].(map[string]any)
+		if tags == nil {
+			tags = make(map[string]any, len(payload.Tags))
+		}
+		for k, v := range payload.Tags {
+			tags[k] = v
+		}
+		entry[r.fields.Name("tags")] = tags
+	}
+	return nil
+}
+
+// aggregateJSON returns an aggregate in the form the sink writes unsealed ones.
+func aggregateJSON(a *pb.Aggregate) map[string]any {
+	aggregate := map[string]any{"count": a.Count, "sum": a.Sum}
+	if len(a.Buckets) > 0 {
+		buckets := make([]map[string]any, len(a.Buckets))
+		for i, b := range a.Buckets {
+			buckets[i] = map[string]any{"count": b.Count, "le": b.UpperBound}
+		}
+		aggregate["buckets"] = buckets
+	}
+	return aggregate
+}
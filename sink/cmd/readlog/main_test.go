@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/pkg/logformat"
+	pb "github.com/sink/proto"
+)
+
+// seal builds a payload the way sensor nodes do with -payload-key-file.
+func seal(t *testing.T, key []byte, sensorName string, payload *pb.SealedPayload) string {
+	t.Helper()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("NewCipher() error = %v", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("NewGCM() error = %v", err)
+	}
+	plaintext, err := proto.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	sealed := make([]byte, 1+gcm.NonceSize())
+	sealed[0] = 1
+	sealed = gcm.Seal(sealed, sealed[1:], plaintext, []byte(sensorName))
+	return base64.StdEncoding.EncodeToString(sealed)
+}
+
+func TestReader_DecodeEntry(t *testing.T) {
+	key := bytes.Repeat([]byte{3}, 32)
+	opener, err := encryption.NewPayloadOpener(key)
+	if err != nil {
+		t.Fatalf("NewPayloadOpener() error = %v", err)
+	}
+	r := reader{opener: opener}
+
+	sealed := seal(t, key, "hr-01", &pb.SealedPayload{SensorValue: 71, Tags: map[string]string{"patient": "p7"}})
+	sealedAggregate := seal(t, key, "vib-01", &pb.SealedPayload{Aggregate: &pb.Aggregate{Count: 3, Sum: 1.5, Buckets: []*pb.HistogramBucket{{UpperBound: 1, Count: 3}}}})
+	sealedValues := seal(t, key, "vitals-01", &pb.SealedPayload{Values: map[string]float64{"hr": 71, "spo2": 98.5}})
+
+	tests := []struct {
+		name    string
+		line    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "plain entry is unchanged",
+			line: `{"sensor_name":"t","sensor_value":1.5}`,
+			want: `{"sensor_name":"t","sensor_value":1.5}`,
+		},
+		{
+			name: "sealed entry is opened and keeps sink tags",
+			line: `{"sealed_payload":"` + sealed + `","sensor_name":"hr-01","tags":{"clock_skew":"1.000"}}`,
+			want: `{"sensor_name":"hr-01","sensor_value":71,"tags":{"clock_skew":"1.000","patient":"p7"}}`,
+		},
+		{
+			name: "sealed multi-value entry",
+			line: `{"sealed_payload":"` + sealedValues + `","sensor_name":"vitals-01"}`,
+			want: `{"sensor_name":"vitals-01","values":{"hr":71,"spo2":98.5}}`,
+		},
+		{
+			name: "sealed aggregate",
+			line: `{"sealed_payload":"` + sealedAggregate + `","sensor_name":"vib-01"}`,
+			want: `{"aggregate":{"buckets":[{"count":3,"le":1}],"count":3,"sum":1.5},"sensor_name":"vib-01"}`,
+		},
+		{
+			name:    "payload sealed for another sensor",
+			line:    `{"sealed_payload":"` + sealed + `","sensor_name":"hr-02"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.decodeEntry([]byte(tt.line))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("decodeEntry() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReader_DecodeEntryAttachment(t *testing.T) {
+	dir := t.TempDir()
+	id := "0a1b2c"
+	if err := os.WriteFile(filepath.Join(dir, id), []byte("spectrum"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r := reader{attachmentDir: dir}
+
+	got, err := r.decodeEntry([]byte(`{"attachment_id":"` + id + `","sensor_name":"s","sensor_value":1}`))
+	if err != nil {
+		t.Fatalf("decodeEntry() error = %v", err)
+	}
+	if want := `{"attachment":"c3BlY3RydW0=","sensor_name":"s","sensor_value":1}`; string(got) != want {
+		t.Errorf("decodeEntry() = %s, want %s", got, want)
+	}
+
+	for _, id := range []string{"ffff", "../" + id} {
+		if _, err := r.decodeEntry([]byte(`{"attachment_id":"` + id + `"}`)); !errors.Is(err, errAttachment) {
+			t.Errorf("decodeEntry() with attachment %q error = %v, want errAttachment", id, err)
+		}
+	}
+}
+
+func TestReader_Wanted(t *testing.T) {
+	reading := []byte(`{"sensor_name":"pump-3","sensor_value":7}`)
+	event := []byte(`{"event":{"message":"stalled","severity":"error"},"sensor_name":"pump-3","type":"event"}`)
+
+	tests := []struct {
+		entryType   string
+		wantReading bool
+		wantEvent   bool
+	}{
+		{"", true, true},
+		{entryReading, true, false},
+		{entryEvent, false, true},
+	}
+
+	for _, tt := range tests {
+		r := reader{entryType: tt.entryType}
+		if got := r.wanted(reading); got != tt.wantReading {
+			t.Errorf("-type=%q: wanted(reading) = %v, want %v", tt.entryType, got, tt.wantReading)
+		}
+		if got := r.wanted(event); got != tt.wantEvent {
+			t.Errorf("-type=%q: wanted(event) = %v, want %v", tt.entryType, got, tt.wantEvent)
+		}
+	}
+}
+
+func TestReader_WantedRenamedFields(t *testing.T) {
+	fields, err := logformat.NewFields(map[string]string{"type": "kind"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := reader{entryType: entryEvent, fields: fields}
+	if !r.wanted([]byte(`{"name":"pump-3","kind":"event"}`)) {
+		t.Error("wanted() = false for an event with a renamed type field")
+	}
+	if r.wanted([]byte(`{"name":"pump-3","val":7}`)) {
+		t.Error("wanted() = true for a reading")
+	}
+}
+
+func TestReader_WantedBoundingBox(t *testing.T) {
+	entry := func(location string) []byte {
+		return []byte(`{"location":` + location + `,"sensor_name":"drone-7","sensor_value":1}`)
+	}
+	warsaw := entry(`{"latitude":52.2297,"longitude":21.0122}`)
+	fiji := entry(`{"altitude":5,"latitude":-17.7134,"longitude":178.065}`)
+	unlocated := []byte(`{"sensor_name":"t","sensor_value":1}`)
+
+	tests := []struct {
+		bbox string
+		want [3]bool // warsaw, fiji, unlocated
+	}{
+		{"49,14,55,24.2", [3]bool{true, false, false}},
+		{"-20,170,-10,-170", [3]bool{false, true, false}}, // across the antimeridian
+		{"-90,-180,90,180", [3]bool{true, true, false}},
+	}
+
+	for _, tt := range tests {
+		bbox, err := parseBoundingBox(tt.bbox)
+		if err != nil {
+			t.Fatalf("parseBoundingBox(%q) error = %v", tt.bbox, err)
+		}
+		r := reader{bbox: bbox}
+		for i, e := range [][]byte{warsaw, fiji, unlocated} {
+			if got := r.wanted(e); got != tt.want[i] {
+				t.Errorf("-bbox=%s: wanted(%s) = %v, want %v", tt.bbox, e, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestParseBoundingBox_Invalid(t *testing.T) {
+	for _, value := range []string{"1,2,3", "10,0,5,1", "-91,0,0,1", "0,0,1,181", "a,0,1,1"} {
+		if _, err := parseBoundingBox(value); err == nil {
+			t.Errorf("parseBoundingBox(%q) succeeded, want error", value)
+		}
+	}
+}
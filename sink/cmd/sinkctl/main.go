@@ -0,0 +1,229 @@
+// Command sinkctl backs up a sink's log storage and restores it: the active log, its
+// segments and the compaction manifest, checksummed, with the log format and the ID
+// of the encryption key recorded next to them. It also migrates logs of older sinks
+// to the current schema version, and to other formats and keys.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sink/backup"
+	"github.com/sink/config"
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/pkg/logformat"
+	"github.com/sink/storage"
+)
+
+const usage = `Usage:
+  %[1]s backup -admin-addr=127.0.0.1:9091 -out=DIR   back up a running sink
+  %[1]s backup -log-file=telemetry.log -out=DIR      back up the log of a stopped sink
+  %[1]s restore -from=DIR -dir=DIR                   rebuild a sink's storage from a backup
+  %[1]s migrate -log-file=telemetry.log -out=DIR     rewrite the log of a stopped sink in the current
+                                                    schema, format and encryption
+
+Run %[1]s <command> -h for the flags of a command.
+`
+
+type backupOptions struct {
+	adminAddr string // of the running sink, empty when it is stopped
+	logFile   string // log of the stopped sink
+	keyFile   string // the stopped sink's encryption key, to record its ID
+	out       string
+}
+
+type restoreOptions struct {
+	from    string
+	dir     string
+	keyFile string // checked against the key ID in the backup
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, filepath.Base(os.Args[0]))
+	}
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "backup":
+		var opts backupOptions
+		fs := flag.NewFlagSet("backup", flag.ExitOnError)
+		fs.StringVar(&opts.adminAddr, "admin-addr", "", "Admin address of the running sink, which takes the snapshot (-admin-addr of the sink)")
+		fs.StringVar(&opts.logFile, "log-file", "", "Log file of a stopped sink, without -admin-addr")
+		fs.StringVar(&opts.keyFile, "encryption-key-file", "", "Encryption key of a stopped sink, to record its ID in the backup")
+		fs.StringVar(&opts.out, "out", "", "Directory to create the backup in")
+		fs.Parse(flag.Args()[1:])
+
+		m, err := backupLog(opts, &http.Client{Timeout: time.Minute})
+		if err != nil {
+			log.Fatalf("Backup failed: %v", err)
+		}
+		log.Printf("Backed up %s to %s", describe(m), opts.out)
+	case "restore":
+		var opts restoreOptions
+		fs := flag.NewFlagSet("restore", flag.ExitOnError)
+		fs.StringVar(&opts.from, "from", "", "Backup directory")
+		fs.StringVar(&opts.dir, "dir", "", "Directory of the sink's log to restore into; none of the backed up files may exist there")
+		fs.StringVar(&opts.keyFile, "encryption-key-file", "", "Encryption key the sink will run with, checked against the key the log was encrypted with")
+		fs.Parse(flag.Args()[1:])
+
+		m, err := restoreLog(opts)
+		if err != nil {
+			log.Fatalf("Restore failed: %v", err)
+		}
+		log.Printf("Restored %s to %s", describe(m), opts.dir)
+		if m.Encryption != nil && opts.keyFile == "" {
+			log.Printf("The log is encrypted with key %s; start the sink with that key", m.Encryption.KeyID)
+		}
+	case "migrate":
+		var opts migrateOptions
+		fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+		fs.StringVar(&opts.logFile, "log-file", "", "Log file of a stopped sink")
+		fs.StringVar(&opts.out, "out", "", "Directory to write the migrated log, its segments and manifest to; none of them may exist there")
+		fs.StringVar(&opts.fromKeyFile, "from-encryption-key-file", "", "Encryption key the log was written with, if encrypted")
+		fs.StringVar(&opts.fromFields, "from-log-fields", "", "Renamed fields the log was written with (-log-fields of the sink)")
+		fs.StringVar(&opts.fromTimeFormat, "from-log-time-format", logformat.TimeRFC3339, "Time format the log was written with (-log-time-format of the sink)")
+		fs.StringVar(&opts.keyFile, "encryption-key-file", "", "Encryption key to write the migrated log with; unencrypted when empty")
+		fs.StringVar(&opts.cipher, "encryption-cipher", encryption.CipherAESGCM, "Cipher to encrypt with: aes-gcm, chacha20poly1305 or xchacha20")
+		fs.StringVar(&opts.mode, "encryption-mode", config.EncryptionModeEntry, "Encryption granularity: entry or segment")
+		fs.StringVar(&opts.format, "log-format", config.LogFormatText, "Format of the migrated log: text or binary")
+		fs.StringVar(&opts.fields, "log-fields", "", "Renamed fields to write the migrated entries with")
+		fs.StringVar(&opts.timeFormat, "log-time-format", logformat.TimeRFC3339, "Time format to write the migrated entries with")
+		fs.Parse(flag.Args()[1:])
+
+		done, err := migrateLog(opts)
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("Migrated %d entries in %d files (%d bytes) to schema version %d in %s", done.entries, done.files, done.size, logformat.SchemaVersion, opts.out)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// backupLog snapshots the log, through the running sink or directly when it is
+// stopped, and copies the snapshot to opts.out.
+func backupLog(opts backupOptions, client *http.Client) (*backup.Manifest, error) {
+	switch {
+	case opts.out == "":
+		return nil, fmt.Errorf("-out is required")
+	case (opts.adminAddr == "") == (opts.logFile == ""):
+		return nil, fmt.Errorf("give either -admin-addr of a running sink or -log-file of a stopped one")
+	case opts.adminAddr != "" && opts.keyFile != "":
+		return nil, fmt.Errorf("-encryption-key-file is for stopped sinks; a running sink records its key ID itself")
+	}
+
+	var dir string
+	if opts.adminAddr != "" {
+		var err error
+		if dir, err = requestSnapshot(client, opts.adminAddr); err != nil {
+			return nil, err
+		}
+	} else {
+		m := &backup.Manifest{Created: time.Now().UTC()}
+		if opts.keyFile != "" {
+			keyID, err := loadKeyID(opts.keyFile)
+			if err != nil {
+				return nil, err
+			}
+			m.Encryption = &backup.Encryption{KeyID: keyID}
+		}
+		dir = filepath.Join(filepath.Dir(opts.logFile), ".snapshot-"+m.Created.Format(storage.SegmentTimeFormat))
+		if err := backup.Snapshot(opts.logFile, dir, m); err != nil {
+			return nil, fmt.Errorf("snapshot: %w", err)
+		}
+	}
+	// The snapshot holds links to the log's files, which it would keep on disk.
+	defer os.RemoveAll(dir)
+
+	return backup.Copy(dir, opts.out)
+}
+
+// requestSnapshot asks the sink at the admin address addr for a snapshot of its log
+// and returns the snapshot's directory.
+func requestSnapshot(client *http.Client, addr string) (string, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	resp, err := client.Post(strings.TrimSuffix(addr, "/")+"/snapshot", "", nil)
+	if err != nil {
+		return "", fmt.Errorf("request snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("request snapshot: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("request snapshot: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var snapshot struct {
+		Dir string `json:"dir"`
+	}
+	if err := json.Unmarshal(body, &snapshot); err != nil || snapshot.Dir == "" {
+		return "", fmt.Errorf("request snapshot: unexpected response %q", body)
+	}
+	return snapshot.Dir, nil
+}
+
+// restoreLog checks the key, if given, against the backup in opts.from and restores
+// the backup into opts.dir.
+func restoreLog(opts restoreOptions) (*backup.Manifest, error) {
+	if opts.from == "" || opts.dir == "" {
+		return nil, fmt.Errorf("-from and -dir are required")
+	}
+	m, err := backup.ReadManifest(opts.from)
+	if err != nil {
+		return nil, err
+	}
+	if opts.keyFile != "" {
+		keyID, err := loadKeyID(opts.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case m.Encryption == nil:
+			return nil, fmt.Errorf("the backed up log is not encrypted")
+		case m.Encryption.KeyID != keyID:
+			return nil, fmt.Errorf("the log was encrypted with key %s, not %s", m.Encryption.KeyID, keyID)
+		}
+	}
+	return backup.Restore(opts.from, opts.dir)
+}
+
+func loadKeyID(path string) (string, error) {
+	e, err := loadEncryptor(path, encryption.CipherAESGCM)
+	if err != nil {
+		return "", err
+	}
+	return e.KeyID(), nil
+}
+
+func loadEncryptor(path, cipher string) (*encryption.Encryptor, error) {
+	key, err := encryption.LoadKey(path)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+	return encryption.NewEncryptorFromKey(cipher, key)
+}
+
+// describe summarizes a backup for the log.
+func describe(m *backup.Manifest) string {
+	var size int64
+	for _, file := range m.Files {
+		size += file.Size
+	}
+	return fmt.Sprintf("%s (%d files, %d bytes, taken %s)", m.Log, len(m.Files), size, m.Created.Format(time.RFC3339))
+}
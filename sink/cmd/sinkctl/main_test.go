@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sink/backup"
+	"github.com/sink/compact"
+	"github.com/sink/config"
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/pkg/logformat"
+)
+
+func writeKey(t *testing.T, path string, b byte) {
+	t.Helper()
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+	if err := os.WriteFile(path, []byte(key), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupAndRestore_StoppedSink(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "telemetry.log")
+	if err := os.WriteFile(logFile, []byte("entry\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "encryption.key")
+	writeKey(t, keyFile, 1)
+	otherKeyFile := filepath.Join(dir, "other.key")
+	writeKey(t, otherKeyFile, 2)
+
+	out := filepath.Join(t.TempDir(), "backup")
+	m, err := backupLog(backupOptions{logFile: logFile, keyFile: keyFile, out: out}, nil)
+	if err != nil {
+		t.Fatalf("backupLog() error = %v", err)
+	}
+	if len(m.Files) != 1 || m.Encryption == nil {
+		t.Errorf("backup = %+v, want the log and the key ID", m)
+	}
+	if snapshots, _ := filepath.Glob(filepath.Join(dir, ".snapshot-*")); len(snapshots) > 0 {
+		t.Errorf("snapshots left behind: %v", snapshots)
+	}
+
+	restored := filepath.Join(t.TempDir(), "sink")
+	if _, err := restoreLog(restoreOptions{from: out, dir: restored, keyFile: otherKeyFile}); err == nil || !strings.Contains(err.Error(), "encrypted with key") {
+		t.Errorf("restoreLog() with another key error = %v, want a key mismatch", err)
+	}
+	if _, err := restoreLog(restoreOptions{from: out, dir: restored, keyFile: keyFile}); err != nil {
+		t.Fatalf("restoreLog() error = %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(restored, "telemetry.log")); err != nil || string(data) != "entry\n" {
+		t.Errorf("restored log = %q, %v", data, err)
+	}
+}
+
+func TestBackup_RunningSink(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "telemetry.log")
+	if err := os.WriteFile(logFile, []byte("entry\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := filepath.Join(dir, ".snapshot-1")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/snapshot" {
+			http.NotFound(w, r)
+			return
+		}
+		m := &backup.Manifest{}
+		if err := backup.Snapshot(logFile, snapshot, m); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"dir": snapshot, "log": m.Log})
+	}))
+	defer srv.Close()
+
+	out := filepath.Join(t.TempDir(), "backup")
+	if _, err := backupLog(backupOptions{adminAddr: strings.TrimPrefix(srv.URL, "http://"), out: out}, srv.Client()); err != nil {
+		t.Fatalf("backupLog() error = %v", err)
+	}
+	if _, err := backup.Verify(out); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if _, err := os.Stat(snapshot); err == nil {
+		t.Error("snapshot left behind")
+	}
+
+	// A second snapshot fails, as its directory now exists.
+	os.Mkdir(snapshot, 0700)
+	if _, err := backupLog(backupOptions{adminAddr: srv.URL, out: filepath.Join(t.TempDir(), "backup")}, srv.Client()); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("backupLog() with a failing sink error = %v, want the sink's error", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "telemetry.log")
+	// Entries of a sink before schema stamps, with renamed fields and epoch milliseconds.
+	active := `{"data_time":1705314599123,"name":"temp-1","sensor_value":21.5,"ts":1705314600123}` + "\n"
+	if err := os.WriteFile(logFile, []byte(active), 0644); err != nil {
+		t.Fatal(err)
+	}
+	segment := "telemetry.log.20240115T100000.000000000.c1"
+	records, err := logformat.NewZstdEncoder(nil).Encode(nil, []byte(`{"ts":1705312800000,"name":"temp-2","sensor_value":3}`+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, segment), records, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := &compact.Manifest{Generation: 1, Segments: []compact.Segment{{File: segment, Entries: 1, Size: int64(len(records))}}}
+	if err := compact.WriteManifest(logFile, m); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "encryption.key")
+	writeKey(t, keyFile, 1)
+
+	out := t.TempDir()
+	opts := migrateOptions{
+		logFile: logFile, out: out, fromFields: "timestamp=ts,sensor_name=name", fromTimeFormat: logformat.TimeUnixMilli,
+		keyFile: keyFile, cipher: encryption.CipherAESGCM, mode: config.EncryptionModeEntry, format: config.LogFormatBinary, timeFormat: logformat.TimeRFC3339,
+	}
+	done, err := migrateLog(opts)
+	if err != nil {
+		t.Fatalf("migrateLog() error = %v", err)
+	}
+	if done.files != 2 || done.entries != 2 {
+		t.Errorf("migrateLog() = %+v, want 2 entries in 2 files", done)
+	}
+	if _, err := migrateLog(opts); err == nil {
+		t.Error("migrateLog() into a migrated log succeeded, want an error")
+	}
+
+	e, err := loadEncryptor(keyFile, encryption.CipherAESGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readEntries := func(name string) []string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("temp-")) {
+			t.Errorf("%s holds entries in the clear", name)
+		}
+		var entries []string
+		r := logformat.NewReader(bytes.NewReader(data), e)
+		for {
+			entry, err := r.Next()
+			if err != nil {
+				if err != io.EOF {
+					t.Fatalf("%s: %v", name, err)
+				}
+				return entries
+			}
+			entries = append(entries, string(entry))
+		}
+	}
+	want := `{"data_time":"2024-01-15T10:29:59.123Z","schema":2,"sensor_name":"temp-1","sensor_value":21.5,"timestamp":"2024-01-15T10:30:00.123Z"}`
+	if entries := readEntries("telemetry.log"); len(entries) != 1 || entries[0] != want {
+		t.Errorf("migrated log = %q, want %q", entries, want)
+	}
+	if entries := readEntries(segment); len(entries) != 1 || !strings.Contains(entries[0], `"schema":2`) {
+		t.Errorf("migrated segment = %q, want a stamped entry", entries)
+	}
+
+	migrated, err := compact.ReadManifest(filepath.Join(out, "telemetry.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(out, segment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := migrated.Segments[0]; s.Schema != logformat.SchemaVersion || s.Size != info.Size() {
+		t.Errorf("migrated manifest segment = %+v, want schema %d and size %d", s, logformat.SchemaVersion, info.Size())
+	}
+}
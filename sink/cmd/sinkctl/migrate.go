@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/sink/compact"
+	"github.com/sink/config"
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/pkg/logformat"
+)
+
+type migrateOptions struct {
+	logFile string // log of the stopped sink
+	out     string
+
+	// How the log was written.
+	fromKeyFile    string
+	fromFields     string // renamed fields, as -log-fields takes them
+	fromTimeFormat string
+
+	// How the migrated log is written, as the sink's flags of the same names.
+	keyFile    string // empty writes it unencrypted
+	cipher     string
+	mode       string
+	format     string
+	fields     string
+	timeFormat string
+}
+
+// migration counts what migrateLog wrote.
+type migration struct {
+	files, entries int
+	size           int64
+}
+
+// migrateLog rewrites the log of a stopped sink, its segments and the compaction
+// manifest into opts.out: every entry upgraded to the current schema version with the
+// new field names and time format, and the files encoded and encrypted as a sink
+// with the new settings writes them. Compacted segments stay zstd records.
+func migrateLog(opts migrateOptions) (migration, error) {
+	var done migration
+	switch {
+	case opts.logFile == "" || opts.out == "":
+		return done, fmt.Errorf("-log-file and -out are required")
+	case opts.format != config.LogFormatText && opts.format != config.LogFormatBinary:
+		return done, fmt.Errorf("invalid -log-format %q, want %s or %s", opts.format, config.LogFormatText, config.LogFormatBinary)
+	case opts.mode != config.EncryptionModeEntry && opts.mode != config.EncryptionModeSegment:
+		return done, fmt.Errorf("invalid -encryption-mode %q, want %s or %s", opts.mode, config.EncryptionModeEntry, config.EncryptionModeSegment)
+	}
+
+	from, err := parseFields(opts.fromFields, opts.fromTimeFormat)
+	if err != nil {
+		return done, fmt.Errorf("-from-log-fields: %w", err)
+	}
+	to, err := parseFields(opts.fields, opts.timeFormat)
+	if err != nil {
+		return done, fmt.Errorf("-log-fields: %w", err)
+	}
+	var decryptor, encryptor *encryption.Encryptor
+	if opts.fromKeyFile != "" {
+		// Every entry names the cipher that sealed it.
+		if decryptor, err = loadEncryptor(opts.fromKeyFile, encryption.CipherAESGCM); err != nil {
+			return done, fmt.Errorf("-from-encryption-key-file: %w", err)
+		}
+	}
+	if opts.keyFile != "" {
+		if encryptor, err = loadEncryptor(opts.keyFile, opts.cipher); err != nil {
+			return done, fmt.Errorf("-encryption-key-file: %w", err)
+		}
+	}
+
+	m, err := compact.ReadManifest(opts.logFile)
+	if err != nil {
+		return done, err
+	}
+	compacted := make(map[string]*compact.Segment, len(m.Segments))
+	for i := range m.Segments {
+		segment := &m.Segments[i]
+		if segment.Error != "" {
+			return done, fmt.Errorf("segment %s was kept unread by compaction (%s); fix or remove it first", segment.File, segment.Error)
+		}
+		compacted[segment.File] = segment
+	}
+
+	names, err := compact.Files(opts.logFile)
+	if err != nil {
+		return done, err
+	}
+	names = slices.DeleteFunc(names, func(name string) bool {
+		return name == filepath.Base(compact.ManifestPath(opts.logFile))
+	})
+	if _, err := os.Stat(opts.logFile); err == nil {
+		names = append(names, filepath.Base(opts.logFile))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return done, err
+	}
+
+	if err := os.MkdirAll(opts.out, 0755); err != nil {
+		return done, err
+	}
+	for _, name := range names {
+		w := &migrateWriter{from: from, to: to}
+		switch segment := compacted[name]; {
+		case segment != nil:
+			w.encoder = logformat.NewZstdEncoder(encryptor)
+		case encryptor != nil && opts.mode == config.EncryptionModeSegment:
+			w.encoder = logformat.NewEncoder(true, encryptor)
+		case encryptor != nil:
+			w.sealer, w.binary = encryptor, opts.format == config.LogFormatBinary
+		case opts.format == config.LogFormatBinary:
+			w.encoder = logformat.NewEncoder(true, nil)
+		}
+
+		src := filepath.Join(filepath.Dir(opts.logFile), name)
+		if err := w.migrate(src, filepath.Join(opts.out, name), decryptor); err != nil {
+			return done, fmt.Errorf("%s: %w", name, err)
+		}
+		if segment := compacted[name]; segment != nil {
+			segment.Size, segment.Schema = w.size, logformat.SchemaVersion
+		}
+		done.files++
+		done.entries += w.entries
+		done.size += w.size
+	}
+
+	if len(m.Segments) > 0 || m.Generation > 0 {
+		if err := compact.WriteManifest(filepath.Join(opts.out, filepath.Base(opts.logFile)), m); err != nil {
+			return done, fmt.Errorf("write manifest: %w", err)
+		}
+	}
+	return done, nil
+}
+
+func parseFields(list, format string) (*logformat.Fields, error) {
+	names, err := logformat.ParseFieldNames(list)
+	if err != nil {
+		return nil, err
+	}
+	return logformat.NewFields(names, format)
+}
+
+// migrateWriter migrates the entries of a file into a new one.
+type migrateWriter struct {
+	from, to *logformat.Fields
+
+	encoder *logformat.Encoder    // encodes buffered entries into records, nil for lines
+	sealer  *encryption.Encryptor // encrypts entries one by one when set
+	binary  bool                  // sealed entries are records rather than base64 lines
+
+	buf     []byte
+	out     []byte
+	scratch []byte
+
+	entries int
+	size    int64
+}
+
+// migrate reads the entries of src and writes them, migrated, to dst, which must
+// not exist. Any entry that can't be read fails the migration, as it would be lost.
+func (w *migrateWriter) migrate(src, dst string, decryptor *encryption.Encryptor) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	r := logformat.NewReader(in, decryptor)
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", w.entries+1, err)
+		}
+		if err := w.add(entry); err != nil {
+			return fmt.Errorf("entry %d: %w", w.entries+1, err)
+		}
+		w.entries++
+		if len(w.buf) >= logformat.ZstdChunkSize {
+			if err := w.flush(out); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.flush(out); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// add buffers the migrated entry as the new log stores it.
+func (w *migrateWriter) add(entry []byte) error {
+	migrated, err := logformat.Migrate(entry, w.from, w.to)
+	if err != nil {
+		return err
+	}
+	start := len(w.buf)
+	w.buf = append(w.buf, migrated...)
+	if w.sealer == nil {
+		w.buf = append(w.buf, '\n')
+		return nil
+	}
+
+	if w.scratch, err = w.sealer.EncryptAppend(w.scratch[:0], w.buf[start:]); err != nil {
+		return err
+	}
+	if w.binary {
+		w.buf = logformat.AppendRecord(w.buf[:start], logformat.FlagEncrypted, w.scratch)
+		return nil
+	}
+	w.buf = base64.StdEncoding.AppendEncode(w.buf[:start], w.scratch)
+	w.buf = append(w.buf, '\n')
+	return nil
+}
+
+func (w *migrateWriter) flush(out io.Writer) error {
+	data := w.buf
+	if w.encoder != nil {
+		var err error
+		if w.out, err = w.encoder.Encode(w.out[:0], w.buf); err != nil {
+			return err
+		}
+		data = w.out
+	}
+	if _, err := out.Write(data); err != nil {
+		return err
+	}
+	w.size += int64(len(data))
+	w.buf = w.buf[:0]
+	return nil
+}
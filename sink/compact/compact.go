@@ -0,0 +1,402 @@
+// Package compact rewrites the rotated segments of a sink log to keep its disk usage
+// predictable on long-running sinks: it drops entries past the retention,
+// recompresses segments with zstd and merges small ones. A manifest next to the log
+// lists the compacted segments and is replaced atomically, so an interrupted
+// compaction neither loses nor duplicates entries.
+package compact
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/pkg/logformat"
+	"github.com/sink/storage"
+)
+
+// Options configure a Compactor.
+type Options struct {
+	// Path of the active log, which is never touched. Its rotated segments are named
+	// as storage.SegmentPath names them.
+	Path string
+	// Entries received longer ago than Retention are dropped, 0 keeps them.
+	Retention time.Duration
+	// Compacted segments are merged up to about SegmentSize bytes. Those at least
+	// half as large are left alone until entries in them expire.
+	SegmentSize int64
+	// Encryptor reads encrypted entries and encrypts compacted segments, nil for
+	// unencrypted logs.
+	Encryptor *encryption.Encryptor
+	// Fields names the entries' timestamp and sets its format, nil for the default.
+	Fields *logformat.Fields
+}
+
+// Stats describe a compaction.
+type Stats struct {
+	Written   int   // compacted segments written
+	Removed   int   // segment files removed
+	Expired   int   // entries dropped past the retention
+	Reclaimed int64 // bytes freed, less those written
+}
+
+// Compactor compacts the segments of a log. Compactions run one at a time.
+type Compactor struct {
+	opts Options
+	mu   sync.Mutex
+}
+
+func New(opts Options) *Compactor {
+	return &Compactor{opts: opts}
+}
+
+// segmentFile is a segment file found next to the log.
+type segmentFile struct {
+	name       string // base name
+	stamp      string // rotation time, in storage.SegmentTimeFormat
+	generation int    // of the compaction that wrote it, 0 for a rotated segment
+	size       int64
+}
+
+// compactedName returns the name of a segment written by compaction generation,
+// starting with the entries of the segment rotated at stamp.
+func (c *Compactor) compactedName(stamp string, generation int) string {
+	return fmt.Sprintf("%s.%s.c%d", filepath.Base(c.opts.Path), stamp, generation)
+}
+
+// Run compacts the log's segments as of now: rotated segments and those with entries
+// past the retention are rewritten, merged with their small neighbours, and the
+// segments they replace are removed once the new manifest is in place.
+func (c *Compactor) Run(now time.Time) (Stats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var stats Stats
+	manifest, err := ReadManifest(c.opts.Path)
+	if err != nil {
+		return stats, err
+	}
+	files, err := c.segmentFiles()
+	if err != nil {
+		return stats, err
+	}
+
+	var cutoff time.Time
+	if c.opts.Retention > 0 {
+		cutoff = now.Add(-c.opts.Retention)
+	}
+
+	listed := make(map[string]Segment, len(manifest.Segments))
+	for _, segment := range manifest.Segments {
+		listed[segment.File] = segment
+	}
+	// Small segments are only rewritten to merge them with segments rotated since.
+	rotated := slices.ContainsFunc(files, func(file segmentFile) bool {
+		return file.generation == 0 && file.stamp > manifest.Through
+	})
+	next := &Manifest{Generation: manifest.Generation + 1, Through: manifest.Through}
+	run := &compaction{c: c, stats: &stats, manifest: next}
+
+	var replaced []segmentFile
+	for _, file := range files {
+		segment, ok := listed[file.name]
+		switch {
+		case !ok && (file.generation > 0 || file.stamp <= manifest.Through):
+			// Written by an interrupted compaction, or merged into a listed segment
+			// by one interrupted before it removed its inputs.
+			replaced = append(replaced, file)
+			continue
+		case file.generation == 0 && file.stamp > next.Through:
+			next.Through = file.stamp
+		}
+
+		if ok {
+			expiring := !cutoff.IsZero() && segment.First.Before(cutoff)
+			switch {
+			case segment.Error == "" && expiring && segment.Entries > 0 && segment.Last.Before(cutoff):
+				stats.Expired += segment.Entries
+				replaced = append(replaced, file)
+				continue
+			case segment.Error != "", !expiring && (!rotated || segment.Size >= c.opts.SegmentSize/2):
+				if err := run.keep(segment); err != nil {
+					run.abort()
+					return stats, err
+				}
+				continue
+			}
+		}
+
+		entries, err := c.read(file, cutoff)
+		if err != nil {
+			log.Printf("compact: keeping segment %s as it is: %v", file.name, err)
+			if !ok {
+				segment = Segment{File: file.name, Size: file.size, Error: err.Error()}
+			}
+			if err := run.keep(segment); err != nil {
+				run.abort()
+				return stats, err
+			}
+			continue
+		}
+		stats.Expired += entries.expired
+		replaced = append(replaced, file)
+
+		if err := run.add(file, entries); err != nil {
+			run.abort()
+			return stats, err
+		}
+	}
+	if err := run.finish(); err != nil {
+		run.abort()
+		return stats, err
+	}
+
+	if err := WriteManifest(c.opts.Path, next); err != nil {
+		run.abort()
+		return stats, fmt.Errorf("write manifest: %w", err)
+	}
+
+	dir := filepath.Dir(c.opts.Path)
+	for _, file := range replaced {
+		if err := os.Remove(filepath.Join(dir, file.name)); err != nil {
+			log.Printf("compact: remove segment %s: %v", file.name, err)
+			continue
+		}
+		stats.Removed++
+		stats.Reclaimed += file.size
+	}
+	return stats, nil
+}
+
+// Files returns the base names of the log's segment files, in the order they were
+// written, followed by its manifest if it has one. With the active log they are all
+// of the log's storage.
+func Files(path string) ([]string, error) {
+	files, err := (&Compactor{opts: Options{Path: path}}).segmentFiles()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files)+1)
+	for _, file := range files {
+		names = append(names, file.name)
+	}
+	if _, err := os.Stat(ManifestPath(path)); err == nil {
+		names = append(names, filepath.Base(ManifestPath(path)))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return names, nil
+}
+
+// segmentFiles returns the log's segment files in the order they were written.
+func (c *Compactor) segmentFiles() ([]segmentFile, error) {
+	dir := filepath.Dir(c.opts.Path)
+	prefix := filepath.Base(c.opts.Path) + "."
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list segments: %w", err)
+	}
+
+	var files []segmentFile
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if !strings.HasPrefix(name, prefix) || !dirEntry.Type().IsRegular() {
+			continue
+		}
+		file, ok := parseSegmentName(name, strings.TrimPrefix(name, prefix))
+		if !ok {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("list segments: %w", err)
+		}
+		file.size = info.Size()
+		files = append(files, file)
+	}
+
+	slices.SortFunc(files, func(a, b segmentFile) int {
+		return strings.Compare(a.stamp, b.stamp)
+	})
+	return files, nil
+}
+
+// parseSegmentName parses the part of a segment file's name after the log's name:
+// the rotation time, followed by .c<generation> for compacted segments.
+func parseSegmentName(name, suffix string) (segmentFile, bool) {
+	stamp, generation, compacted := strings.Cut(suffix, ".c")
+	if _, err := time.Parse(storage.SegmentTimeFormat, stamp); err != nil || len(stamp) != len(storage.SegmentTimeFormat) {
+		return segmentFile{}, false
+	}
+	file := segmentFile{name: name, stamp: stamp}
+	if compacted {
+		n, err := strconv.Atoi(generation)
+		if err != nil || n < 1 {
+			return segmentFile{}, false
+		}
+		file.generation = n
+	}
+	return file, true
+}
+
+// segmentEntries are the entries of a segment kept by a compaction.
+type segmentEntries struct {
+	data        []byte // newline terminated JSON entries
+	count       int
+	first, last time.Time
+	schema      int // oldest schema version of the entries
+	expired     int
+}
+
+// read returns the entries of a segment received at or after cutoff.
+func (c *Compactor) read(file segmentFile, cutoff time.Time) (*segmentEntries, error) {
+	f, err := os.Open(filepath.Join(filepath.Dir(c.opts.Path), file.name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := &segmentEntries{}
+	r := logformat.NewReader(f, c.opts.Encryptor)
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := c.opts.Fields.Info(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", logformat.ErrEntry, err)
+		}
+		t := info.Received
+		if !cutoff.IsZero() && t.Before(cutoff) {
+			entries.expired++
+			continue
+		}
+
+		entries.data = append(entries.data, entry...)
+		entries.data = append(entries.data, '\n')
+		entries.count++
+		if entries.first.IsZero() || t.Before(entries.first) {
+			entries.first = t
+		}
+		if t.After(entries.last) {
+			entries.last = t
+		}
+		if entries.schema == 0 || info.Schema < entries.schema {
+			entries.schema = info.Schema
+		}
+	}
+}
+
+// compaction writes the segments of a run and collects the next manifest.
+type compaction struct {
+	c        *Compactor
+	stats    *Stats
+	manifest *Manifest
+
+	out     *os.File // the segment being written, nil between segments
+	segment Segment
+	encoder *logformat.Encoder
+	encoded []byte
+	written []string // paths of the segments written
+}
+
+// keep lists an existing segment in the manifest unchanged. It ends the segment
+// being written, which holds older entries.
+func (r *compaction) keep(segment Segment) error {
+	if err := r.finish(); err != nil {
+		return err
+	}
+	r.manifest.Segments = append(r.manifest.Segments, segment)
+	return nil
+}
+
+// add appends entries read from file to the segment being written, starting one if
+// needed, and ends the segment once it reaches the segment size.
+func (r *compaction) add(file segmentFile, entries *segmentEntries) error {
+	if entries.count == 0 {
+		return nil
+	}
+
+	if r.out == nil {
+		name := r.c.compactedName(file.stamp, r.manifest.Generation)
+		path := filepath.Join(filepath.Dir(r.c.opts.Path), name)
+		out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("create segment: %w", err)
+		}
+		r.out = out
+		r.written = append(r.written, path)
+		r.segment = Segment{File: name, First: entries.first, Schema: entries.schema}
+		if r.encoder == nil {
+			r.encoder = logformat.NewZstdEncoder(r.c.opts.Encryptor)
+		}
+	}
+
+	var err error
+	if r.encoded, err = r.encoder.Encode(r.encoded[:0], entries.data); err != nil {
+		return fmt.Errorf("encode segment: %w", err)
+	}
+	if _, err := r.out.Write(r.encoded); err != nil {
+		return fmt.Errorf("write segment: %w", err)
+	}
+	r.segment.Size += int64(len(r.encoded))
+	r.segment.Entries += entries.count
+	if entries.first.Before(r.segment.First) {
+		r.segment.First = entries.first
+	}
+	r.segment.Schema = min(r.segment.Schema, entries.schema)
+	if entries.last.After(r.segment.Last) {
+		r.segment.Last = entries.last
+	}
+
+	if r.segment.Size >= r.c.opts.SegmentSize {
+		return r.finish()
+	}
+	return nil
+}
+
+// finish syncs and closes the segment being written and lists it in the manifest.
+func (r *compaction) finish() error {
+	if r.out == nil {
+		return nil
+	}
+	out := r.out
+	r.out = nil
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("sync segment: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("close segment: %w", err)
+	}
+	r.manifest.Segments = append(r.manifest.Segments, r.segment)
+	r.stats.Written++
+	r.stats.Reclaimed -= r.segment.Size
+	return nil
+}
+
+// abort removes the segments written by a failed run, which no manifest lists.
+func (r *compaction) abort() {
+	if r.out != nil {
+		r.out.Close()
+	}
+	for _, path := range r.written {
+		os.Remove(path)
+	}
+	r.stats.Written = 0
+}
@@ -0,0 +1,233 @@
+package compact
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/pkg/logformat"
+	"github.com/sink/storage"
+)
+
+var start = time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+
+// writeSegment writes a rotated segment of the log at path holding an entry received
+// at each of times, encoded by encode.
+func writeSegment(t *testing.T, path string, rotated time.Time, encode func([]byte) []byte, times ...time.Time) {
+	t.Helper()
+
+	var buf []byte
+	for _, received := range times {
+		buf = fmt.Appendf(buf, `{"sensor_name":"flow","sensor_value":1,"timestamp":%q}`+"\n", received.Format(time.RFC3339Nano))
+	}
+	if encode != nil {
+		buf = encode(buf)
+	}
+	if err := os.WriteFile(storage.SegmentPath(path, rotated), buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readSegments returns the number of entries in the files of the log at path other
+// than the active log and its manifest.
+func readSegments(t *testing.T, path string, encryptor *encryption.Encryptor) (files, entries int) {
+	t.Helper()
+
+	segments, err := filepath.Glob(path + ".2*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, segment := range segments {
+		f, err := os.Open(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := logformat.NewReader(f, encryptor)
+		for {
+			_, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("read %s: %v", segment, err)
+			}
+			entries++
+		}
+		f.Close()
+	}
+	return len(segments), entries
+}
+
+func TestRun_MergesAndRecompressesSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.log")
+	for i := 0; i < 3; i++ {
+		rotated := start.Add(time.Duration(i+1) * time.Minute)
+		writeSegment(t, path, rotated, nil, rotated.Add(-30*time.Second), rotated.Add(-10*time.Second))
+	}
+
+	stats, err := New(Options{Path: path, SegmentSize: 1 << 20}).Run(start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if stats.Written != 1 || stats.Removed != 3 || stats.Reclaimed <= 0 {
+		t.Errorf("Run() = %+v, want 1 segment written in place of 3 and bytes reclaimed", stats)
+	}
+	if files, entries := readSegments(t, path, nil); files != 1 || entries != 6 {
+		t.Errorf("segments hold %d entries in %d files, want 6 in 1", entries, files)
+	}
+
+	manifest, err := ReadManifest(path)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if len(manifest.Segments) != 1 {
+		t.Fatalf("manifest = %+v, want 1 segment", manifest)
+	}
+	segment := manifest.Segments[0]
+	wantName := filepath.Base(storage.SegmentPath(path, start.Add(time.Minute))) + ".c1"
+	if segment.File != wantName || segment.Entries != 6 || !segment.First.Equal(start.Add(30*time.Second)) || !segment.Last.Equal(start.Add(170*time.Second)) {
+		t.Errorf("manifest segment = %+v, want %s with 6 entries from 00:30 to 02:50", segment, wantName)
+	}
+
+	// A newly rotated segment is merged into the small compacted one.
+	writeSegment(t, path, start.Add(4*time.Minute), nil, start.Add(230*time.Second))
+	if _, err := New(Options{Path: path, SegmentSize: 1 << 20}).Run(start.Add(time.Hour)); err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+	if files, entries := readSegments(t, path, nil); files != 1 || entries != 7 {
+		t.Errorf("after second Run(), segments hold %d entries in %d files, want 7 in 1", entries, files)
+	}
+}
+
+func TestRun_DropsExpiredEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.log")
+	writeSegment(t, path, start.Add(time.Hour), nil, start, start.Add(50*time.Minute))
+	writeSegment(t, path, start.Add(2*time.Hour), nil, start.Add(90*time.Minute))
+	c := New(Options{Path: path, Retention: 24 * time.Hour, SegmentSize: 64})
+
+	stats, err := c.Run(start.Add(24*time.Hour + 30*time.Minute))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if stats.Expired != 1 {
+		t.Errorf("Run() expired %d entries, want 1", stats.Expired)
+	}
+	if _, entries := readSegments(t, path, nil); entries != 2 {
+		t.Errorf("segments hold %d entries, want 2", entries)
+	}
+
+	// Segments whose entries all expired are removed without being read.
+	stats, err = c.Run(start.Add(26 * time.Hour))
+	if err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+	if stats.Expired != 2 {
+		t.Errorf("second Run() expired %d entries, want 2", stats.Expired)
+	}
+	if files, _ := readSegments(t, path, nil); files != 0 {
+		t.Errorf("%d segment files left, want none", files)
+	}
+}
+
+func TestRun_RenamedTimestamp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.log")
+	writeSegment(t, path, start.Add(2*time.Hour), func(buf []byte) []byte {
+		return fmt.Appendf(nil, `{"name":"flow","ts":%d}`+"\n"+`{"name":"flow","ts":%d}`+"\n", start.UnixMilli(), start.Add(90*time.Minute).UnixMilli())
+	})
+	fields, err := logformat.NewFields(map[string]string{"timestamp": "ts", "sensor_name": "name"}, logformat.TimeUnixMilli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := New(Options{Path: path, Retention: 24 * time.Hour, SegmentSize: 64, Fields: fields}).Run(start.Add(24*time.Hour + 30*time.Minute))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if stats.Expired != 1 {
+		t.Errorf("Run() expired %d entries, want 1", stats.Expired)
+	}
+}
+
+func TestRun_EncryptedLog(t *testing.T) {
+	e, err := encryption.NewEncryptor(encryption.CipherAESGCM, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	encrypt := func(buf []byte) []byte {
+		encoded, err := logformat.NewEncoder(true, e).Encode(nil, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+
+	path := filepath.Join(t.TempDir(), "telemetry.log")
+	writeSegment(t, path, start.Add(time.Minute), encrypt, start, start.Add(time.Second))
+
+	// Without the key, the segment can't be read and is kept as it is.
+	if _, err := New(Options{Path: path, SegmentSize: 1 << 20}).Run(start); err != nil {
+		t.Fatalf("Run() without a key error = %v", err)
+	}
+	manifest, err := ReadManifest(path)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if len(manifest.Segments) != 1 || manifest.Segments[0].Error == "" {
+		t.Errorf("manifest = %+v, want the segment kept with an error", manifest)
+	}
+
+	os.Remove(ManifestPath(path))
+	if _, err := New(Options{Path: path, SegmentSize: 1 << 20, Encryptor: e}).Run(start); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if _, entries := readSegments(t, path, e); entries != 2 {
+		t.Errorf("segments hold %d entries, want 2", entries)
+	}
+	segments, _ := filepath.Glob(path + ".*.c*")
+	for _, segment := range segments {
+		f, _ := os.Open(segment)
+		_, err := logformat.NewReader(f, nil).Next()
+		f.Close()
+		if !errors.Is(err, logformat.ErrEntry) {
+			t.Errorf("reading %s without a key error = %v, want ErrEntry", segment, err)
+		}
+	}
+}
+
+func TestRun_CleansUpInterruptedCompaction(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	path := filepath.Join(t.TempDir(), "telemetry.log")
+	writeSegment(t, path, start.Add(time.Minute), nil, start)
+	c := New(Options{Path: path, SegmentSize: 1 << 20})
+	if _, err := c.Run(start); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// A segment merged before an interruption kept it from being removed, and a
+	// segment written by a compaction whose manifest never made it.
+	writeSegment(t, path, start.Add(time.Minute), nil, start)
+	if err := os.WriteFile(storage.SegmentPath(path, start.Add(2*time.Minute))+".c7", []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := c.Run(start)
+	if err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+	if stats.Removed != 2 || stats.Written != 0 {
+		t.Errorf("second Run() = %+v, want the 2 leftovers removed", stats)
+	}
+	if files, entries := readSegments(t, path, nil); files != 1 || entries != 1 {
+		t.Errorf("segments hold %d entries in %d files, want 1 in 1", entries, files)
+	}
+}
@@ -0,0 +1,91 @@
+package compact
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sink/storage"
+)
+
+// Manifest lists the compacted segments of a log, oldest first. Segment files next to
+// the log that it doesn't list are either rotated since the last compaction or left
+// over from one that was interrupted.
+type Manifest struct {
+	// Generation counts compactions; the segments a compaction writes carry it.
+	Generation int `json:"generation"`
+	// Through is the rotation time of the latest rotated segment compacted. Rotated
+	// segments up to it that aren't listed were merged into listed ones.
+	Through  string    `json:"through"`
+	Segments []Segment `json:"segments"`
+}
+
+// Segment describes a compacted segment.
+type Segment struct {
+	File    string    `json:"file"`  // base name
+	First   time.Time `json:"first"` // receive time of the oldest entry
+	Last    time.Time `json:"last"`  // receive time of the newest entry
+	Entries int       `json:"entries"`
+	Size    int64     `json:"size"`
+	Schema  int       `json:"schema,omitempty"` // oldest schema version of the entries
+	// Error is why the segment was kept as it was rotated, unread, e.g. an entry
+	// that couldn't be decrypted. Such segments are never compacted or expired.
+	Error string `json:"error,omitempty"`
+}
+
+// ManifestPath returns the path of the manifest of the log at path.
+func ManifestPath(path string) string {
+	return path + ".manifest"
+}
+
+// ReadManifest reads the manifest of the log at path. A log never compacted has an
+// empty one.
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(ManifestPath(path))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// WriteManifest replaces the manifest of the log at path through a synced temporary
+// file, so a crash leaves either the old or the new manifest.
+func WriteManifest(path string, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	target := ManifestPath(path)
+	tmp := target + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return err
+	}
+	return storage.SyncDir(filepath.Dir(path))
+}
@@ -1,21 +1,362 @@
 package config
 
-import "time"
+import (
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/sink/clock"
+)
+
+// Log encryption modes.
+const (
+	EncryptionModeEntry   = "entry"   // encrypt and base64 encode every entry
+	EncryptionModeSegment = "segment" // compress and encrypt flushed buffers in chunks
+)
+
+// Log file formats.
+const (
+	LogFormatText   = "text"   // JSON lines, base64 lines when encrypted per entry
+	LogFormatBinary = "binary" // logformat records with checksums
+)
+
+// Outputs entries are stored to.
+const (
+	OutputLog         = "log"          // the log file, in LogFormat
+	OutputInflux      = "influx"       // InfluxDB line protocol, to the log file or InfluxURL
+	OutputPostgres    = "postgres"     // rows of a PostgreSQL or TimescaleDB table
+	OutputClickHouse  = "clickhouse"   // rows of a ClickHouse table
+	OutputNATS        = "nats"         // messages on a NATS JetStream subject
+	OutputRemoteWrite = "remote-write" // Prometheus remote_write requests
+)
+
+// Actions for entries whose device time is off by more than MaxClockSkew.
+const (
+	ClockSkewFlag    = "flag"    // tag the entry with its skew
+	ClockSkewRewrite = "rewrite" // also replace the device time with the receive time
+)
+
+// Storage of readings carrying several named values.
+const (
+	MultiValueSplit    = "split"    // one entry per value, named by its metric
+	MultiValueCombined = "combined" // one entry holding all values
+)
+
+// Flush policies, when a buffer is handed to the writer before it is full.
+const (
+	FlushPolicyInterval = "interval" // every FlushInterval
+	FlushPolicyAdaptive = "adaptive" // also at FlushTargetSize and when the ingest rate drops
+)
+
+// Overload policies for entries that find both the buffer and the write queue full.
+const (
+	OverloadReject     = "reject"      // reject the new entries with Unavailable
+	OverloadDropOldest = "drop-oldest" // discard the buffered entries to make room
+	OverloadSample     = "sample"      // keep 1 in OverloadSampleRate entries, discard the rest
+)
+
+// When readings are acknowledged to clients with replication to a standby.
+const (
+	ReplicaAckPrimary = "primary" // once buffered here; the standby gets them asynchronously
+	ReplicaAckBoth    = "both"    // once the standby has applied them too
+)
+
+// Watchdog actions when a threshold is crossed.
+const (
+	WatchdogShed  = "shed"  // reject non-critical readings with Unavailable
+	WatchdogFlush = "flush" // hand a buffer grown by held critical entries to the writer
+	WatchdogDump  = "dump"  // write goroutine stacks and a heap profile to WatchdogDumpDir
+)
 
 type Config struct {
-	BindAddr      string
+	BindAddr      string // host:port or unix:///path/to.sock
 	LogFilePath   string
 	BufferSize    int
 	FlushInterval time.Duration
 	RateLimit     int // bytes per second
 
+	// Longest an accepted entry waits in the buffer, flushed early when the flush
+	// interval would keep it longer, 0 disables
+	MaxBufferAge time.Duration
+
+	// FlushPolicyInterval or FlushPolicyAdaptive; adaptive flushing writes buffers of
+	// FlushTargetSize bytes while the writer keeps up, BufferSize when it is 0
+	FlushPolicy     string
+	FlushTargetSize int
+
+	// Bytes per second reserved for critical readings on top of RateLimit, 0 disables
+	CriticalRateLimit int
+
+	// Number of full buffers that may wait for the writer before requests are rejected
+	WriteQueueSize int
+
+	// Where flushed buffers go: one of the Output constants. With OutputInflux, buffers
+	// are posted to InfluxURL as batches, or written to LogFilePath when it is empty.
+	Output            string
+	InfluxURL         string // full write URL, e.g. http://influxdb:8086/api/v2/write?org=acme&bucket=telemetry
+	InfluxToken       string
+	InfluxMeasurement string
+	InfluxTimeout     time.Duration // per request
+	InfluxRetries     int           // retries of a failed batch
+
+	// Further outputs fed a copy of every entry, in the clear, each with its own
+	// buffer and write queue. A copy that finds its output's queue full is dropped, so
+	// a slow output holds up neither ingestion nor the others.
+	FanOut []string
+
+	// With OutputPostgres, buffers are copied into PostgresTable, created if missing.
+	PostgresURL      string // postgres:// URL or key=value connection string
+	PostgresTable    string // optionally schema qualified
+	PostgresMaxConns int
+	PostgresTimeout  time.Duration // per statement
+	PostgresRetries  int           // retries of a failed batch
+
+	// With OutputClickHouse, buffers are inserted into ClickHouseTable, created if
+	// missing, through the HTTP interface at ClickHouseURL.
+	ClickHouseURL                string
+	ClickHouseUser               string
+	ClickHousePassword           string
+	ClickHouseTable              string // optionally database qualified
+	ClickHouseAsyncInsert        bool
+	ClickHouseWaitForAsyncInsert bool
+	ClickHouseTimeout            time.Duration // per request
+	ClickHouseRetries            int           // retries of a failed batch
+
+	// NATS servers, used by OutputNATS to publish entries to NATSSubject and, with
+	// NATSIngestSubject set, to consume readings from that subject.
+	NATSURL           string // comma separated
+	NATSCredsFile     string
+	NATSSubject       string
+	NATSTimeout       time.Duration // for a batch to be acknowledged, and per reading consumed
+	NATSRetries       int           // retries of a failed batch
+	NATSIngestSubject string
+	NATSIngestDurable string // consumer name
+
+	// With OutputRemoteWrite, numeric values are sent to RemoteWriteURL as series
+	// named RemoteWriteName, through a write-ahead log in RemoteWriteWALDir.
+	RemoteWriteURL      string
+	RemoteWriteToken    string
+	RemoteWriteTenant   string
+	RemoteWriteName     string
+	RemoteWriteWALDir   string
+	RemoteWriteWALQuota int64
+	RemoteWriteTimeout  time.Duration // per request
+
+	// Directory that buffers overflowing the write queue are spilled to, up to
+	// SpillQuota bytes, until the writer catches up. Empty disables spilling.
+	SpillDir   string
+	SpillQuota int64
+
+	// What to do with entries when the buffer and the write queue are full: one of
+	// OverloadReject, OverloadDropOldest or OverloadSample
+	OverloadPolicy     string
+	OverloadSampleRate int // 1 in N entries kept with OverloadSample
+
+	// Time between the start of shutdown and closing connections, during which
+	// responses tell sensor nodes to move their traffic elsewhere
+	DrainPeriod time.Duration
+
+	// Permissions of the socket file when BindAddr is a unix:// address
+	UnixSocketMode os.FileMode
+
+	// Listen on IPv6 only with BindAddr, FrameAddr and CoAPAddr, so [::]:9090
+	// doesn't accept IPv4 clients as well
+	IPv6Only bool
+
+	// PROXY protocol v2 on the gRPC, frame and Connect listeners, for a sink behind
+	// an L4 load balancer. ProxyProtocolFrom lists the load balancers' networks, the
+	// only ones trusted to send the header.
+	ProxyProtocol     bool
+	ProxyProtocolFrom []netip.Prefix
+
+	// gRPC server tuning, 0 keeps the gRPC default
+	MaxConcurrentStreams uint32 // per connection
+	MaxRecvMsgSize       int    // bytes
+	StreamWorkers        uint32 // size of the handler worker pool
+	MaxConnsPerIP        int    // open connections per client IP, 0 is unlimited
+	HandshakesPerIP      int    // new connections per second per client IP, 0 is unlimited
+
+	// Client IPs failing authentication BanAfter times within BanWindow are banned
+	// for BanDuration; 0 BanAfter disables. Bans are listed and lifted on AdminAddr.
+	BanAfter    int
+	BanWindow   time.Duration
+	BanDuration time.Duration
+
+	// YAML file of CIDR allow and deny lists checked on accept and on every call,
+	// reloaded on SIGHUP; empty allows every client IP
+	IPAccessFile string
+
+	// Payload limits, 0 disables
+	MaxTags      int // tags per reading
+	MaxFieldSize int // bytes of the sensor name and of each tag key and value
+
+	// Attachments: bytes per attachment (0 rejects them), bytes per second charged
+	// instead of RateLimit (0 disables) and the directory they are stored in, empty
+	// storing them inline
+	MaxAttachmentSize   int
+	AttachmentRateLimit int
+	AttachmentDir       string
+
+	// Per-tenant and per-sensor quotas, shared across sinks when Redis is configured
+	TenantRateLimit int // bytes per second, 0 disables
+	SensorRateLimit int // bytes per second, 0 disables
+	RedisAddr       string
+	RedisPassword   string
+	RedisKeyPrefix  string
+
+	// QuotaTrailers reports the tightest remaining quota of each reading's rate
+	// limits in the trailers of gRPC responses. With Redis quotas, reporting costs
+	// a round trip per reading.
+	QuotaTrailers bool
+
 	// TLS configuration
 	UseTLS   bool
 	CertFile string
 	KeyFile  string
 	CAFile   string
 
+	// SPIFFE workload identity, replaces certificate files when set
+	SpiffeSocket      string
+	SpiffeTrustDomain string
+
+	// Ways clients authenticate, tried in order: auth.MethodToken, auth.MethodMTLS
+	// or auth.MethodOIDC. Empty requires a client certificate with mTLS and nothing
+	// otherwise
+	AuthMethods []string
+
+	// YAML file of the names and SHA-256 hashes of the bearer tokens of
+	// auth.MethodToken
+	AuthTokensFile string
+
+	// OpenID Connect provider whose JWTs authenticate clients with auth.MethodOIDC
+	OIDCIssuer       string
+	OIDCAudience     string
+	OIDCJWKSURL      string        // discovered from OIDCIssuer when empty
+	OIDCSubjectClaim string        // claim naming the client
+	OIDCKeyRefresh   time.Duration // age after which the signing keys are fetched again
+
+	// Authorization policy for authenticated clients
+	AuthzPolicyFile string
+
+	// Processing pipeline applied to entries before storage
+	PipelineFile string
+
+	// Rules selecting the entries each output stores, every output storing every
+	// entry when empty
+	RoutesFile string
+
+	// Admin HTTP server with metrics and the sensor inventory, disabled when empty
+	AdminAddr string
+
+	// Listener serving the TelemetryService over Connect, gRPC-Web and gRPC with the
+	// gRPC listener's TLS settings, disabled when empty, for browsers from
+	// ConnectOrigins, "*" allowing any origin
+	ConnectAddr    string
+	ConnectOrigins []string
+
+	// Plain TCP listener for length-framed protobuf readings, disabled when empty
+	FrameAddr        string
+	FrameIdleTimeout time.Duration // closes connections without frames for this long
+
+	// UDP address of the CoAP endpoint for constrained devices, disabled when empty
+	CoAPAddr string
+
+	// Delay suggested to nodes when the write queue is full, in proportion to how
+	// full it is; 0 never asks nodes to slow down
+	MaxThrottleHint time.Duration
+
+	// Sensor liveness
+	HeartbeatInterval time.Duration // interval suggested to registering sensors
+	SensorSilentAfter time.Duration // without readings or heartbeats, 0 disables
+
+	// Bounds on per-sensor state (liveness, quota buckets), 0 disables each
+	MaxTrackedSensors int
+	SensorStateTTL    time.Duration // idle time after which a sensor's state is dropped
+
+	// How readings with several named values are stored, MultiValueSplit or
+	// MultiValueCombined
+	MultiValueMode string
+
+	// Device clock skew handling, disabled when MaxClockSkew is 0
+	MaxClockSkew    time.Duration
+	ClockSkewAction string // ClockSkewFlag or ClockSkewRewrite
+
+	// Readings received later than this after their device time are tagged and
+	// counted per sensor, 0 disables
+	MaxIngestLatency time.Duration
+
+	// Log segments: the log is rotated once it reaches LogSegmentSize bytes (0 never
+	// rotates), and rotated segments are compacted every CompactInterval (0 disables),
+	// dropping entries received more than LogRetention ago (0 keeps them)
+	LogSegmentSize  int64
+	CompactInterval time.Duration
+	LogRetention    time.Duration
+
+	// Watchdog, disabled when WatchdogInterval is 0; thresholds of 0 are not checked
+	WatchdogInterval        time.Duration
+	WatchdogMaxQueued       int           // buffers waiting for the writer
+	WatchdogMaxFlushLatency time.Duration // time the buffer being written has waited
+	WatchdogMaxGoroutines   int
+	WatchdogActions         []string // WatchdogShed, WatchdogFlush, WatchdogDump
+	WatchdogDumpDir         string
+
+	// Self-telemetry: buffer, queue, rejection and write figures sent as readings of
+	// SelfTelemetrySensor every SelfTelemetryInterval (0 disables), to the sink at
+	// SelfTelemetryUpstream or, when empty, through this sink's own pipeline
+	SelfTelemetryInterval time.Duration
+	SelfTelemetrySensor   string
+	SelfTelemetryUpstream string // host:port, dialed with this sink's TLS files when UseTLS is set
+
+	// Replication of accepted entries to the standby sink at ReplicaAddr (empty
+	// disables), dialed like SelfTelemetryUpstream, through a journal in
+	// ReplicaJournalDir of at most ReplicaJournalQuota bytes
+	ReplicaAddr         string
+	ReplicaAck          string        // ReplicaAckPrimary or ReplicaAckBoth
+	ReplicaTimeout      time.Duration // per call, and the wait for the standby with ReplicaAckBoth
+	ReplicaJournalDir   string        // empty is LogFilePath with a .journal suffix
+	ReplicaJournalQuota int64
+
+	// Apply entries replicated by a primary sink; what was applied is kept next to
+	// the log with a .replica suffix. Only primaries whose client certificate names
+	// one of ReplicationPeers (SPIFFE ID, or the subject's common name) may replicate
+	AcceptReplication bool
+	ReplicationPeers  []string
+
+	// Active/standby election: the sink holding the lock on LeaderLockFile (empty
+	// disables) accepts readings, and the other refuses them, naming the leader's
+	// AdvertiseAddr, while retrying the lock every LeaderPollInterval
+	LeaderLockFile     string
+	AdvertiseAddr      string // host:port clients reach this sink at
+	LeaderPollInterval time.Duration
+
+	// Sink addresses (host:port) nodes are asked to move their traffic to, empty
+	// while they should stay; changed at runtime with the admin server's /redirect
+	RedirectTo []string
+
+	// Dead-letter file for rejected messages, disabled when DeadLetterFile is empty
+	DeadLetterFile    string
+	DeadLetterMaxSize int64 // bytes before rotation, 0 disables rotation
+
+	// Audit log, disabled when AuditLogFile is empty
+	AuditLogFile        string
+	AuditMaxSize        int64 // bytes before rotation, 0 disables rotation
+	AuditSigningKeyFile string
+
 	// Encryption
-	EnableEncryption bool
-	EncryptionKey    string
+	EnableEncryption     bool
+	EncryptionKey        string // base64, from ENCRYPTION_KEY or -encryption-key
+	EncryptionKeyFile    string // file with the base64 key, used instead of EncryptionKey
+	EncryptionKeyFromEnv bool   // EncryptionKey came from ENCRYPTION_KEY, cleared once read
+	EncryptionCipher     string // one of encryption.Ciphers
+	EncryptionMode       string // EncryptionModeEntry or EncryptionModeSegment
+	LogFormat            string // LogFormatText or LogFormatBinary
+	// Names of the log entries' fields renamed for downstream parsers, by default
+	// name, and the format of their times: one of the logformat Time constants or a
+	// Go time layout, empty for RFC 3339
+	LogFields     map[string]string
+	LogTimeFormat string
+	// Clock drives receive timestamps, liveness, rate limits, the flush timer and the
+	// restart delay after a panic, nil uses clock.Real. Tests and replays set a
+	// clock.Fake.
+	Clock clock.Clock
 }
@@ -0,0 +1,173 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rejection reasons recorded with dead letters.
+const (
+	ReasonRateLimit   = "rate_limit"
+	ReasonQuota       = "quota"
+	ReasonInvalid     = "invalid"
+	ReasonProcessing  = "processing"
+	ReasonUnavailable = "unavailable"
+)
+
+// TypeEvent is the type of records whose payload is an Event rather than SensorData.
+const TypeEvent = "event"
+
+const queueSize = 1024
+
+// Record is a rejected message, written as one JSON line.
+type Record struct {
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason"`
+	Detail   string    `json:"detail,omitempty"`
+	Tenant   string    `json:"tenant,omitempty"`
+	Identity string    `json:"identity,omitempty"`
+	Type     string    `json:"type,omitempty"` // TypeEvent, or empty for readings
+	// RequestID is the ID the node gave the reading, empty for events and clients
+	// without IDs.
+	RequestID string `json:"request_id,omitempty"`
+	// Payload is the serialized SensorData, or Event, as received, base64 encoded in
+	// the file. When Encrypted is set it was sealed with the sink's log encryption key.
+	Payload   []byte `json:"payload"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+// Writer appends records to a file from a background goroutine, so rejecting a
+// message never waits on disk. Records arriving while the queue is full are counted
+// and dropped; a flood of rejections must not turn into a flood of writes.
+type Writer struct {
+	path    string
+	maxSize int64
+
+	file    *os.File
+	size    int64
+	queue   chan Record
+	dropped atomic.Uint64
+	wg      sync.WaitGroup
+
+	mu     sync.RWMutex // guards closed against concurrent Write calls
+	closed bool
+}
+
+// NewWriter opens (or creates) the dead-letter file at path. When the file grows past
+// maxSize it is renamed aside and a new one is started; zero disables rotation.
+func NewWriter(path string, maxSize int64) (*Writer, error) {
+	w := &Writer{
+		path:    path,
+		maxSize: maxSize,
+		queue:   make(chan Record, queueSize),
+	}
+
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w, nil
+}
+
+// Write queues a record without blocking.
+func (w *Writer) Write(record Record) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.queue <- record:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Close writes queued records and closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.queue)
+	w.mu.Unlock()
+
+	w.wg.Wait()
+	return w.file.Close()
+}
+
+func (w *Writer) run() {
+	defer w.wg.Done()
+
+	for record := range w.queue {
+		if err := w.write(record); err != nil {
+			log.Printf("dead-letter: %v", err)
+		}
+		if n := w.dropped.Swap(0); n > 0 {
+			log.Printf("dead-letter: queue full, dropped %d records", n)
+		}
+	}
+}
+
+func (w *Writer) write(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+	line = append(line, '\n')
+
+	if w.maxSize > 0 && w.size+int64(len(line)) > w.maxSize && w.size > 0 {
+		if err := w.rotate(); err != nil {
+			return fmt.Errorf("rotate file: %w", err)
+		}
+	}
+
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("open dead-letter file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat dead-letter file: %w", err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	rotated := fmt.Sprintf("%s.%s", w.path, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(w.path, rotated); err != nil {
+		return err
+	}
+
+	return w.open()
+}
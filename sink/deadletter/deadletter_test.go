@@ -0,0 +1,77 @@
+package deadletter
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriter_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.log")
+
+	w, err := NewWriter(path, 0)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+
+	want := Record{
+		Time:    time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC),
+		Reason:  ReasonQuota,
+		Detail:  "tenant quota exceeded",
+		Tenant:  "building-a",
+		Payload: []byte{0x0a, 0x03, 'a', 'b', 'c'},
+	}
+	w.Write(want)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	w.Write(Record{Reason: ReasonInvalid}) // must not panic after Close
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+	got := records[0]
+	if got.Reason != want.Reason || got.Tenant != want.Tenant || string(got.Payload) != string(want.Payload) || !got.Time.Equal(want.Time) {
+		t.Errorf("record = %+v, want %+v", got, want)
+	}
+}
+
+func TestWriter_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "dead-letter.log")
+
+	w, err := NewWriter(path, 200)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		w.Write(Record{Reason: ReasonRateLimit, Payload: make([]byte, 64)})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	matches, _ := filepath.Glob(path + ".*")
+	if len(matches) == 0 {
+		t.Error("Writer should rotate the file once it exceeds maxSize")
+	}
+}
@@ -0,0 +1,79 @@
+// Package election elects the active sink of an active/standby pair: the sink holding
+// an exclusive lock on a file both can reach, e.g. on shared storage, is the leader,
+// and writes into the file the address clients should use to reach it.
+package election
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Lock is one sink's side of the election.
+type Lock struct {
+	path string
+	addr string
+	file *os.File // open while the lock is held
+}
+
+// New returns the lock on the file at path of the sink clients reach at addr.
+func New(path, addr string) *Lock {
+	return &Lock{path: path, addr: addr}
+}
+
+// TryAcquire takes the lock unless the other sink holds it, and reports whether this
+// sink is the leader. The lock is held until Release or the process exits.
+func (l *Lock) TryAcquire() (bool, error) {
+	if l.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, fmt.Errorf("open leader lock: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, fmt.Errorf("lock %s: %w", l.path, err)
+	}
+
+	// Followers read the address without the lock, so it is written in one call.
+	if err := file.Truncate(0); err == nil {
+		_, err = file.WriteAt([]byte(l.addr+"\n"), 0)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return false, fmt.Errorf("record leader address: %w", err)
+	}
+
+	l.file = file
+	return true, nil
+}
+
+// Leader returns the address the leader recorded, empty when none did.
+func (l *Lock) Leader() (string, error) {
+	data, err := os.ReadFile(l.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("read leader lock: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Release gives up the lock, letting the other sink take over. The recorded address
+// is left for the next leader to replace.
+func (l *Lock) Release() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
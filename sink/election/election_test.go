@@ -0,0 +1,32 @@
+package election
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sink.leader")
+	a, b := New(path, "sink-a:9090"), New(path, "sink-b:9090")
+
+	if leader, err := a.TryAcquire(); err != nil || !leader {
+		t.Fatalf("TryAcquire() of the first sink = %v, %v, want leader", leader, err)
+	}
+	if leader, err := b.TryAcquire(); err != nil || leader {
+		t.Fatalf("TryAcquire() while the other sink leads = %v, %v, want standby", leader, err)
+	}
+	if addr, err := b.Leader(); err != nil || addr != "sink-a:9090" {
+		t.Errorf("Leader() = %q, %v, want sink-a:9090", addr, err)
+	}
+
+	if err := a.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if leader, err := b.TryAcquire(); err != nil || !leader {
+		t.Fatalf("TryAcquire() after the leader released = %v, %v, want leader", leader, err)
+	}
+	if addr, err := a.Leader(); err != nil || addr != "sink-b:9090" {
+		t.Errorf("Leader() after takeover = %q, %v, want sink-b:9090", addr, err)
+	}
+	b.Release()
+}
@@ -1,19 +1,52 @@
 package encryption
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/sink/errdefs"
+)
+
+// Supported AEAD ciphers. ChaCha20-Poly1305 is much faster than AES-GCM on CPUs
+// without AES instructions; XChaCha20-Poly1305 uses 24-byte nonces.
+const (
+	CipherAESGCM           = "aes-gcm"
+	CipherChaCha20Poly1305 = "chacha20poly1305"
+	CipherXChaCha20        = "xchacha20"
+)
+
+// Format bytes prefixed to every ciphertext, so readers can tell which cipher sealed
+// it. Ciphertexts written before the prefix existed are plain AES-GCM.
+const (
+	formatAESGCM           byte = 1
+	formatChaCha20Poly1305 byte = 2
+	formatXChaCha20        byte = 3
 )
 
-type AESGCMEncryptor struct {
-	gcm cipher.AEAD
+// Ciphers lists the names accepted by NewEncryptor.
+var Ciphers = []string{CipherAESGCM, CipherChaCha20Poly1305, CipherXChaCha20}
+
+// Encryptor seals data with the configured cipher and opens data sealed with any of
+// the supported ciphers under the same key.
+type Encryptor struct {
+	aead   cipher.AEAD
+	format byte
+	key    []byte
 }
 
-func NewAESGCMEncryptor(encryptionKey string) (*AESGCMEncryptor, error) {
+// NewEncryptor creates an encryptor for the named cipher and a base64 encoded
+// 32-byte key.
+func NewEncryptor(cipherName, encryptionKey string) (*Encryptor, error) {
 	var (
 		key []byte
 		err error
@@ -24,44 +57,155 @@ func NewAESGCMEncryptor(encryptionKey string) (*AESGCMEncryptor, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to decode encryption key: %w", err)
 		}
+		defer clear(key)
 	}
 
+	return NewEncryptorFromKey(cipherName, key)
+}
+
+// NewEncryptorFromKey creates an encryptor for the named cipher and a raw 32-byte
+// key. The encryptor keeps its own copy, so callers can clear key afterwards.
+func NewEncryptorFromKey(cipherName string, key []byte) (*Encryptor, error) {
 	if len(key) != 32 {
 		return nil, fmt.Errorf("encryption key must be 32 bytes long")
 	}
 
-	block, err := aes.NewCipher(key)
+	var format byte
+	switch cipherName {
+	case CipherAESGCM, "":
+		format = formatAESGCM
+	case CipherChaCha20Poly1305:
+		format = formatChaCha20Poly1305
+	case CipherXChaCha20:
+		format = formatXChaCha20
+	default:
+		return nil, fmt.Errorf("unknown cipher %q, want one of %v", cipherName, Ciphers)
+	}
+
+	key = bytes.Clone(key)
+	aead, err := newAEAD(format, key)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+		clear(key)
+		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	return &Encryptor{aead: aead, format: format, key: key}, nil
+}
+
+// LoadKey reads a base64 encoded 32-byte key from path. The file contents are
+// cleared once decoded and the caller should clear the returned key when done. Go
+// may still have copied either while reading, so this is best-effort.
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	defer clear(data)
+
+	encoded := bytes.TrimSpace(data)
+	key := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(key, encoded)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+		clear(key)
+		return nil, fmt.Errorf("decode key file: %w", err)
+	}
+	return key[:n], nil
+}
+
+func newAEAD(format byte, key []byte) (cipher.AEAD, error) {
+	switch format {
+	case formatAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create GCM: %w", err)
+		}
+		return gcm, nil
+	case formatChaCha20Poly1305:
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ChaCha20-Poly1305: %w", err)
+		}
+		return aead, nil
+	case formatXChaCha20:
+		aead, err := chacha20poly1305.NewX(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create XChaCha20-Poly1305: %w", err)
+		}
+		return aead, nil
+	default:
+		return nil, fmt.Errorf("unknown cipher format %d", format)
 	}
+}
 
-	return &AESGCMEncryptor{gcm: gcm}, nil
+// KeyID identifies the key without revealing it: the first 8 bytes of a SHA-256 of
+// the key, in hex. Backups record it so a restore can check it is given the right key.
+func (e *Encryptor) KeyID() string {
+	h := sha256.New()
+	h.Write([]byte("telemetry log key\x00"))
+	h.Write(e.key)
+	return hex.EncodeToString(h.Sum(nil)[:8])
 }
 
-func (e *AESGCMEncryptor) Encrypt(plaintext []byte) ([]byte, error) {
-	nonce := make([]byte, e.gcm.NonceSize())
+func (e *Encryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	return e.EncryptAppend(nil, plaintext)
+}
+
+// EncryptAppend encrypts plaintext and appends the format byte, nonce and ciphertext
+// to dst, so callers can reuse buffers across calls. dst must not overlap plaintext.
+func (e *Encryptor) EncryptAppend(dst, plaintext []byte) ([]byte, error) {
+	nonceSize := e.aead.NonceSize()
+	dst = append(dst, e.format)
+	start := len(dst)
+	dst = append(dst, make([]byte, nonceSize)...)
+
+	nonce := dst[start : start+nonceSize]
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+		return nil, errdefs.Errorf(errdefs.ErrEncryption, "failed to generate nonce: %w", err)
 	}
 
-	ciphertext := e.gcm.Seal(nonce, nonce, plaintext, nil)
-	return ciphertext, nil
+	return e.aead.Seal(dst, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens ciphertext sealed with any supported cipher, detected from its
+// format byte, as well as AES-GCM ciphertext written without one.
+func (e *Encryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) > 0 {
+		if aead, err := e.aeadFor(ciphertext[0]); err == nil {
+			if plaintext, err := open(aead, ciphertext[1:]); err == nil {
+				return plaintext, nil
+			}
+		}
+	}
+
+	// Older entries have no format byte; authentication tells the two layouts apart.
+	legacy, err := e.aeadFor(formatAESGCM)
+	if err != nil {
+		return nil, err
+	}
+	return open(legacy, ciphertext)
+}
+
+func (e *Encryptor) aeadFor(format byte) (cipher.AEAD, error) {
+	if format == e.format {
+		return e.aead, nil
+	}
+	return newAEAD(format, e.key)
 }
 
-func (e *AESGCMEncryptor) Decrypt(ciphertext []byte) ([]byte, error) {
-	if len(ciphertext) < e.gcm.NonceSize() {
-		return nil, fmt.Errorf("ciphertext too short")
+func open(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errdefs.Errorf(errdefs.ErrEncryption, "ciphertext too short")
 	}
 
-	nonce, ciphertext := ciphertext[:e.gcm.NonceSize()], ciphertext[e.gcm.NonceSize():]
-	plaintext, err := e.gcm.Open(nil, nonce, ciphertext, nil)
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt: %w", err)
+		return nil, errdefs.Errorf(errdefs.ErrEncryption, "failed to decrypt: %w", err)
 	}
 
 	return plaintext, nil
@@ -0,0 +1,207 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sink/errdefs"
+)
+
+var testKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{9}, 32))
+
+func TestEncryptor_RoundTrip(t *testing.T) {
+	plaintext := []byte(`{"sensor_name":"t","sensor_value":1}`)
+
+	for _, name := range Ciphers {
+		t.Run(name, func(t *testing.T) {
+			e, err := NewEncryptor(name, testKey)
+			if err != nil {
+				t.Fatalf("NewEncryptor() error = %v", err)
+			}
+
+			ciphertext, err := e.Encrypt(plaintext)
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			if ciphertext[0] != e.format {
+				t.Errorf("format byte = %d, want %d", ciphertext[0], e.format)
+			}
+
+			// A reader configured with any cipher detects the one used.
+			for _, readerCipher := range Ciphers {
+				reader, err := NewEncryptor(readerCipher, testKey)
+				if err != nil {
+					t.Fatalf("NewEncryptor() error = %v", err)
+				}
+				got, err := reader.Decrypt(ciphertext)
+				if err != nil {
+					t.Fatalf("Decrypt() with %s reader error = %v", readerCipher, err)
+				}
+				if !bytes.Equal(got, plaintext) {
+					t.Errorf("Decrypt() = %s, want %s", got, plaintext)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptor_DecryptLegacyAESGCM(t *testing.T) {
+	key, _ := base64.StdEncoding.DecodeString(testKey)
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+
+	plaintext := []byte("written before format bytes")
+	for i := 0; i < 20; i++ {
+		nonce := make([]byte, gcm.NonceSize())
+		rand.Read(nonce)
+		// Cover nonces that happen to start with a valid format byte.
+		nonce[0] = byte(i % 4)
+		ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+
+		e, err := NewEncryptor(CipherChaCha20Poly1305, testKey)
+		if err != nil {
+			t.Fatalf("NewEncryptor() error = %v", err)
+		}
+		got, err := e.Decrypt(ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt() error = %v", err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("Decrypt() = %s, want %s", got, plaintext)
+		}
+	}
+}
+
+func TestNewEncryptor_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		cipher string
+		key    string
+	}{
+		{name: "unknown cipher", cipher: "rot13", key: testKey},
+		{name: "short key", cipher: CipherXChaCha20, key: base64.StdEncoding.EncodeToString(make([]byte, 16))},
+		{name: "missing key", cipher: CipherAESGCM},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewEncryptor(tt.cipher, tt.key); err == nil {
+				t.Errorf("NewEncryptor(%q) error = nil, want error", tt.cipher)
+			}
+		})
+	}
+}
+
+func TestNewEncryptorFromKey_CopiesKey(t *testing.T) {
+	key := bytes.Repeat([]byte{9}, 32)
+	e, err := NewEncryptorFromKey(CipherChaCha20Poly1305, key)
+	if err != nil {
+		t.Fatalf("NewEncryptorFromKey() error = %v", err)
+	}
+	clear(key)
+
+	ciphertext, err := e.Encrypt([]byte("reading"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	reference, err := NewEncryptor(CipherAESGCM, testKey)
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	// Opening with another cipher uses the stored key, which clearing ours must not touch.
+	if plaintext, err := reference.Decrypt(ciphertext); err != nil || string(plaintext) != "reading" {
+		t.Errorf("Decrypt() after clearing the caller's key = %q, %v", plaintext, err)
+	}
+}
+
+func TestEncryptor_KeyID(t *testing.T) {
+	aes, err := NewEncryptor(CipherAESGCM, testKey)
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	chacha, err := NewEncryptor(CipherChaCha20Poly1305, testKey)
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	other, err := NewEncryptorFromKey(CipherAESGCM, bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("NewEncryptorFromKey() error = %v", err)
+	}
+
+	if id := aes.KeyID(); len(id) != 16 || id != chacha.KeyID() {
+		t.Errorf("KeyID() = %q and %q with another cipher, want the same 16 hex digits", id, chacha.KeyID())
+	}
+	if aes.KeyID() == other.KeyID() {
+		t.Errorf("KeyID() of different keys = %q for both", aes.KeyID())
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	key, err := LoadKey(write("key", testKey+"\n"))
+	if err != nil {
+		t.Fatalf("LoadKey() error = %v", err)
+	}
+	if !bytes.Equal(key, bytes.Repeat([]byte{9}, 32)) {
+		t.Errorf("LoadKey() = %x", key)
+	}
+
+	if _, err := LoadKey(write("bad", "not base64!")); err == nil {
+		t.Error("LoadKey() of a malformed key error = nil")
+	}
+	if _, err := LoadKey(filepath.Join(dir, "missing")); err == nil {
+		t.Error("LoadKey() of a missing file error = nil")
+	}
+}
+
+func TestEncryptor_DecryptTampered(t *testing.T) {
+	e, err := NewEncryptor(CipherXChaCha20, testKey)
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	ciphertext, err := e.Encrypt([]byte("data"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+
+	if _, err := e.Decrypt(ciphertext); !errors.Is(err, errdefs.ErrEncryption) {
+		t.Errorf("Decrypt() of tampered ciphertext error = %v, want ErrEncryption", err)
+	}
+}
+
+func BenchmarkEncryptor_Encrypt(b *testing.B) {
+	plaintext := []byte(`{"timestamp":"2024-05-17T10:30:00.123456789Z","sensor_name":"temperature-01","sensor_value":42,"data_time":"2024-05-17T10:29:59Z"}`)
+
+	for _, name := range Ciphers {
+		b.Run(name, func(b *testing.B) {
+			e, err := NewEncryptor(name, testKey)
+			if err != nil {
+				b.Fatalf("NewEncryptor() error = %v", err)
+			}
+
+			b.SetBytes(int64(len(plaintext)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := e.Encrypt(plaintext); err != nil {
+					b.Fatalf("Encrypt() error = %v", err)
+				}
+			}
+		})
+	}
+}
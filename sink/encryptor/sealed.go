@@ -0,0 +1,63 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sink/errdefs"
+	pb "github.com/sink/proto"
+)
+
+// sealedPayloadVersion is the first byte of payloads sealed by sensor nodes.
+const sealedPayloadVersion byte = 1
+
+// PayloadOpener decrypts values and tags sealed by sensor nodes with
+// -payload-key-file. The sink never holds this key; offline readers do.
+type PayloadOpener struct {
+	gcm cipher.AEAD
+}
+
+func NewPayloadOpener(key []byte) (*PayloadOpener, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("payload key must be 32 bytes long")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return &PayloadOpener{gcm: gcm}, nil
+}
+
+// Open decrypts a payload sealed for sensorName. It fails if the payload was sealed
+// under another sensor name.
+func (o *PayloadOpener) Open(sensorName string, sealed []byte) (*pb.SealedPayload, error) {
+	nonceSize := o.gcm.NonceSize()
+	if len(sealed) < 1+nonceSize {
+		return nil, errdefs.Errorf(errdefs.ErrEncryption, "sealed payload too short")
+	}
+	if sealed[0] != sealedPayloadVersion {
+		return nil, fmt.Errorf("unsupported sealed payload version %d", sealed[0])
+	}
+
+	nonce, ciphertext := sealed[1:1+nonceSize], sealed[1+nonceSize:]
+	plaintext, err := o.gcm.Open(nil, nonce, ciphertext, []byte(sensorName))
+	if err != nil {
+		return nil, errdefs.Errorf(errdefs.ErrEncryption, "failed to decrypt: %w", err)
+	}
+
+	var payload pb.SealedPayload
+	if err := proto.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+	return &payload, nil
+}
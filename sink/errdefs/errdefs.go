@@ -0,0 +1,100 @@
+// Package errdefs defines the kinds of error the sink's packages return, so callers
+// embedding the sink can tell them apart with errors.Is and errors.As, and maps each
+// kind to the gRPC status code clients see.
+package errdefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Kinds of error. Errors returned by the sink wrap at most one of them.
+var (
+	// ErrInvalid is a message the sink can't accept as sent; retrying won't help.
+	ErrInvalid = errors.New("invalid")
+	// ErrRateLimited is a message over a sink-wide, client or attachment rate limit.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrQuotaExceeded is a message over its tenant's or sensor's quota.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrOverloaded is a message shed while the sink is overloaded.
+	ErrOverloaded = errors.New("overloaded")
+	// ErrNotLeader is a message sent to the standby of an active/standby pair.
+	ErrNotLeader = errors.New("not leader")
+	// ErrBufferFull is a message that found the buffer and the write queue full.
+	ErrBufferFull = errors.New("buffer full")
+	// ErrEncryption is a failure to encrypt or decrypt data.
+	ErrEncryption = errors.New("encryption failed")
+	// ErrStorage is a failure to write the log or an attachment.
+	ErrStorage = errors.New("storage failed")
+	// ErrProcessing is a processing stage failing on an entry.
+	ErrProcessing = errors.New("processing failed")
+)
+
+// Error is an error of one kind. Its message is that of the underlying error alone,
+// so wrapping an error doesn't change what is logged or sent to clients.
+type Error struct {
+	Kind error // one of the Err values above
+	Err  error
+}
+
+// Errorf returns an error of kind, formatted like fmt.Errorf.
+func Errorf(kind error, format string, args ...any) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, args...)}
+}
+
+// Wrap returns err as an error of kind, or nil if err is nil.
+func Wrap(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// GRPCStatus lets gRPC, and status.Code and status.Convert, see the code of the
+// error's kind.
+func (e *Error) GRPCStatus() *status.Status {
+	return status.New(kindCode(e.Kind), e.Error())
+}
+
+// Code returns the gRPC status code for err: that of the kind it wraps, or of its
+// status if it has one.
+func Code(err error) codes.Code {
+	for _, kind := range []error{ErrInvalid, ErrRateLimited, ErrQuotaExceeded, ErrOverloaded, ErrNotLeader, ErrBufferFull, ErrEncryption, ErrStorage, ErrProcessing} {
+		if errors.Is(err, kind) {
+			return kindCode(kind)
+		}
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	}
+	return status.Code(err)
+}
+
+func kindCode(kind error) codes.Code {
+	switch kind {
+	case ErrInvalid:
+		return codes.InvalidArgument
+	case ErrRateLimited, ErrQuotaExceeded:
+		return codes.ResourceExhausted
+	case ErrOverloaded, ErrNotLeader, ErrBufferFull, ErrStorage:
+		return codes.Unavailable
+	case ErrEncryption, ErrProcessing:
+		return codes.Internal
+	}
+	return codes.Unknown
+}
@@ -0,0 +1,71 @@
+package errdefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorf(t *testing.T) {
+	err := Errorf(ErrStorage, "failed to store attachment: %w", io.ErrShortWrite)
+
+	if got, want := err.Error(), "failed to store attachment: short write"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, ErrStorage) || !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("errors.Is(%v) misses its kind or cause", err)
+	}
+	if errors.Is(err, ErrEncryption) {
+		t.Errorf("errors.Is(%v, ErrEncryption) = true", err)
+	}
+	var typed *Error
+	if !errors.As(fmt.Errorf("store: %w", err), &typed) || typed.Kind != ErrStorage {
+		t.Errorf("errors.As() of a wrapped error = %v, want kind ErrStorage", typed)
+	}
+
+	st := status.Convert(err)
+	if st.Code() != codes.Unavailable || st.Message() != err.Error() {
+		t.Errorf("status.Convert() = %v, %q, want Unavailable, %q", st.Code(), st.Message(), err.Error())
+	}
+}
+
+func TestWrap(t *testing.T) {
+	if err := Wrap(ErrStorage, nil); err != nil {
+		t.Errorf("Wrap(nil) = %v, want nil", err)
+	}
+	if err := Wrap(ErrStorage, io.ErrShortWrite); err.Error() != io.ErrShortWrite.Error() || !errors.Is(err, ErrStorage) {
+		t.Errorf("Wrap() = %v, want short write of kind ErrStorage", err)
+	}
+}
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{nil, codes.OK},
+		{Errorf(ErrInvalid, "bad reading"), codes.InvalidArgument},
+		{Errorf(ErrRateLimited, "rate limit exceeded"), codes.ResourceExhausted},
+		{Errorf(ErrQuotaExceeded, "tenant quota exceeded"), codes.ResourceExhausted},
+		{Errorf(ErrOverloaded, "sink overloaded"), codes.Unavailable},
+		{Errorf(ErrNotLeader, "standby sink"), codes.Unavailable},
+		{fmt.Errorf("flush: %w", Wrap(ErrBufferFull, io.ErrShortWrite)), codes.Unavailable},
+		{ErrStorage, codes.Unavailable},
+		{Errorf(ErrEncryption, "failed to decrypt"), codes.Internal},
+		{Errorf(ErrProcessing, "stage failed"), codes.Internal},
+		{fmt.Errorf("wait: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+		{context.Canceled, codes.Canceled},
+		{status.Error(codes.PermissionDenied, "denied"), codes.PermissionDenied},
+		{io.EOF, codes.Unknown},
+	}
+	for _, tt := range tests {
+		if got := Code(tt.err); got != tt.want {
+			t.Errorf("Code(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
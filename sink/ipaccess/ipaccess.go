@@ -0,0 +1,102 @@
+// Package ipaccess decides which client IPs may connect to the sink by CIDR allow
+// and deny lists, for deployments where network policy alone isn't enough.
+package ipaccess
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// List holds the networks clients may and may not connect from. A client is denied
+// if it is in a deny network, and otherwise allowed if it is in an allow network or
+// there are none.
+type List struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// file is the YAML form of a list, whose entries are networks in CIDR notation or
+// single addresses.
+type file struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// Load reads a YAML list file.
+func Load(path string) (*List, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read IP access file: %w", err)
+	}
+	return parse(data)
+}
+
+func parse(data []byte) (*List, error) {
+	var f file
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse IP access file: %w", err)
+	}
+
+	var l List
+	var err error
+	if l.Allow, err = parsePrefixes(f.Allow); err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	if l.Deny, err = parsePrefixes(f.Deny); err != nil {
+		return nil, fmt.Errorf("deny: %w", err)
+	}
+	return &l, nil
+}
+
+func parsePrefixes(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Check returns why ip may not connect, or "" if it may. A nil List allows every
+// client.
+func (l *List) Check(ip string) string {
+	if l == nil {
+		return ""
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "invalid client address"
+	}
+	// Prefixes never contain an address with a zone, such as a link-local
+	// fe80::1%eth0, so it is matched without.
+	addr = addr.Unmap().WithZone("")
+
+	if contains(l.Deny, addr) {
+		return "denied by the IP access list"
+	}
+	if len(l.Allow) > 0 && !contains(l.Allow, addr) {
+		return "not in the IP allow list"
+	}
+	return ""
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package ipaccess
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip-access.yaml")
+	err := os.WriteFile(path, []byte(`
+allow:
+  - 10.0.0.0/8
+  - 192.168.1.20
+  - 2001:db8::/32
+  - fe80::/10
+deny:
+  - 10.66.0.0/16
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	for _, tc := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"192.168.1.20", true},
+		{"192.168.1.21", false},
+		{"10.66.0.9", false},
+		{"2001:db8::7", true},
+		{"2001:db9::7", false},
+		{"fe80::1%eth0", true},
+		{"fe80::1", true},
+	} {
+		if reason := l.Check(tc.ip); (reason == "") != tc.allowed {
+			t.Errorf("Check(%s) = %q, want allowed %v", tc.ip, reason, tc.allowed)
+		}
+	}
+
+	var none *List
+	if reason := none.Check("203.0.113.7"); reason != "" {
+		t.Errorf("nil List Check() = %q, want every client allowed", reason)
+	}
+	if reason := (&List{}).Check("203.0.113.7"); reason != "" {
+		t.Errorf("empty List Check() = %q, want every client allowed", reason)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, data := range []string{"allow: [10.0.0.0/33]", "deny: [not-a-network]", "allow: {a: b}"} {
+		if _, err := parse([]byte(data)); err == nil {
+			t.Errorf("parse(%q) error = nil, want an error", data)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Environment variables used to hand a listener over to an upgraded sink process.
+const (
+	InheritedFdEnv = "TELEMETRY_LISTENER_FD"
+	ReadyFdEnv     = "TELEMETRY_READY_FD"
+)
+
+// Inherited returns the listener handed over by a parent sink during a graceful
+// upgrade, or nil if there is none.
+func Inherited() (net.Listener, error) {
+	fd, err := strconv.Atoi(os.Getenv(InheritedFdEnv))
+	if err != nil {
+		return nil, nil
+	}
+	os.Unsetenv(InheritedFdEnv)
+
+	file := os.NewFile(uintptr(fd), "inherited-listener")
+	defer file.Close()
+
+	lis, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("use inherited listener: %w", err)
+	}
+
+	return lis, nil
+}
+
+// File returns a duplicate file descriptor for the listener so it can be passed to a
+// child process. For unix sockets the socket file is kept on disk when the current
+// process closes its listener, since the child keeps serving on it.
+func File(lis net.Listener) (*os.File, error) {
+	switch l := lis.(type) {
+	case *net.TCPListener:
+		return l.File()
+	case *net.UnixListener:
+		l.SetUnlinkOnClose(false)
+		return l.File()
+	default:
+		return nil, fmt.Errorf("listener type %T cannot be handed over", lis)
+	}
+}
+
+// NotifyReady tells the parent sink that this process is serving, so the parent can
+// start draining. It is a no-op when the process was not started by an upgrade.
+func NotifyReady() error {
+	fd, err := strconv.Atoi(os.Getenv(ReadyFdEnv))
+	if err != nil {
+		return nil
+	}
+	os.Unsetenv(ReadyFdEnv)
+
+	pipe := os.NewFile(uintptr(fd), "ready-pipe")
+	defer pipe.Close()
+
+	_, err = pipe.Write([]byte{1})
+	return err
+}
@@ -0,0 +1,122 @@
+package listener
+
+import (
+	"log"
+	"net"
+	"sync"
+)
+
+// perIPLimitListener closes accepted connections from client IPs that already hold
+// the maximum number of open connections.
+type perIPLimitListener struct {
+	net.Listener
+	max int
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// LimitPerIP wraps lis so that each client IP may hold at most max open connections.
+// Connections without an IP address (unix sockets) are not limited.
+func LimitPerIP(lis net.Listener, max int) net.Listener {
+	return &perIPLimitListener{
+		Listener: lis,
+		max:      max,
+		conns:    make(map[string]int),
+	}
+}
+
+func (l *perIPLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip, ok := remoteIP(conn)
+		if !ok {
+			return conn, nil
+		}
+
+		if !l.acquire(ip) {
+			log.Printf("Rejecting connection from %s: limit of %d connections per IP reached", conn.RemoteAddr(), l.max)
+			conn.Close()
+			continue
+		}
+
+		return &trackedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+	}
+}
+
+func (l *perIPLimitListener) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns[ip] >= l.max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+func (l *perIPLimitListener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.conns[ip]--
+	if l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
+
+// filterListener closes accepted connections from client IPs its check rejects.
+type filterListener struct {
+	net.Listener
+	check func(ip string) string
+}
+
+// Filter wraps lis so that connections from client IPs for which check returns a
+// reason are closed on accept, before any handshake. Connections without an IP
+// address (unix sockets) are not checked.
+func Filter(lis net.Listener, check func(ip string) string) net.Listener {
+	return &filterListener{Listener: lis, check: check}
+}
+
+func (l *filterListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		ip, ok := remoteIP(conn)
+		if !ok {
+			return conn, nil
+		}
+		if reason := l.check(ip); reason != "" {
+			log.Printf("Rejecting connection from %s: %s", conn.RemoteAddr(), reason)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+// trackedConn runs release exactly once when the connection is closed.
+type trackedConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+func remoteIP(conn net.Conn) (string, bool) {
+	if _, ok := conn.RemoteAddr().(*net.TCPAddr); !ok {
+		return "", false
+	}
+	return ClientIP(conn.RemoteAddr())
+}
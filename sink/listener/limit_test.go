@@ -0,0 +1,122 @@
+package listener
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLimitPerIP(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	lis := LimitPerIP(tcp, 2)
+	defer lis.Close()
+
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", tcp.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		return conn
+	}
+
+	first, second := dial(), dial()
+	defer first.Close()
+	defer second.Close()
+	serverFirst, serverSecond := <-accepted, <-accepted
+	defer serverSecond.Close()
+
+	third := dial()
+	defer third.Close()
+
+	// The third connection is closed by the listener.
+	third.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := third.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("third connection read error = %v, want EOF", err)
+	}
+
+	// Closing an accepted connection frees a slot for a new one.
+	serverFirst.Close()
+	fourth := dial()
+	defer fourth.Close()
+
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(2 * time.Second):
+		t.Error("connection after a slot was freed should be accepted")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	var rejected atomic.Bool
+	rejected.Store(true)
+	lis := Filter(tcp, func(ip string) string {
+		if rejected.Load() {
+			return "banned"
+		}
+		return ""
+	})
+	defer lis.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer first.Close()
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := first.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("rejected connection read error = %v, want EOF", err)
+	}
+	rejected.Store(false)
+
+	second, err := net.Dial("tcp", tcp.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer second.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(2 * time.Second):
+		t.Error("connection the check allows should be accepted")
+	}
+}
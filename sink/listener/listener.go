@@ -0,0 +1,115 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+const unixScheme = "unix://"
+
+// Listen opens the listener described by bindAddr. Plain host:port addresses listen
+// on TCP; "unix:///path/to.sock" listens on a unix domain socket whose file mode is
+// set to socketMode so access can be controlled with file permissions. A wildcard
+// address such as [::]:9090 accepts IPv4 clients too unless ipv6Only is set.
+func Listen(bindAddr string, socketMode os.FileMode, ipv6Only bool) (net.Listener, error) {
+	path, ok := UnixSocketPath(bindAddr)
+	if !ok {
+		return net.Listen(Network("tcp", ipv6Only), bindAddr)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, socketMode); err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("chmod socket: %w", err)
+	}
+
+	return lis, nil
+}
+
+// Network returns the network to listen on for "tcp" or "udp": "tcp6" or "udp6" if
+// ipv6Only is set, which keeps [::] and :port from accepting IPv4 clients.
+func Network(network string, ipv6Only bool) string {
+	if ipv6Only {
+		return network + "6"
+	}
+	return network
+}
+
+// CheckIPv6Only returns an error if addr, a host:port, names an IPv4 address, which
+// can't be listened on IPv6 only.
+func CheckIPv6Only(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && ip.Unmap().Is4() {
+		return fmt.Errorf("%s is an IPv4 address", host)
+	}
+	return nil
+}
+
+// ClientIP returns the IP address of a client connected over TCP or UDP, with the
+// zone of a link-local IPv6 address such as fe80::1%eth0 and IPv4-mapped addresses
+// as plain IPv4.
+func ClientIP(addr net.Addr) (string, bool) {
+	var ip net.IP
+	var zone string
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip, zone = addr.IP, addr.Zone
+	case *net.UDPAddr:
+		ip, zone = addr.IP, addr.Zone
+	default:
+		return "", false
+	}
+	a, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return "", false
+	}
+	a = a.Unmap()
+	if a.Is6() {
+		a = a.WithZone(zone)
+	}
+	return a.String(), true
+}
+
+// UnixSocketPath returns the socket path if bindAddr uses the unix:// scheme.
+func UnixSocketPath(bindAddr string) (string, bool) {
+	if !strings.HasPrefix(bindAddr, unixScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(bindAddr, unixScheme), true
+}
+
+// removeStaleSocket deletes a socket file left behind by a previous process that did
+// not shut down cleanly. Regular files are never removed.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stat socket: %w", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use by another process", path)
+	}
+
+	return os.Remove(path)
+}
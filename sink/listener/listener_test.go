@@ -0,0 +1,80 @@
+package listener
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestListen_IPv6Only(t *testing.T) {
+	for _, tc := range []struct {
+		ipv6Only bool
+		wantV4   bool
+	}{
+		{ipv6Only: false, wantV4: true},
+		{ipv6Only: true, wantV4: false},
+	} {
+		lis, err := Listen("[::]:0", 0, tc.ipv6Only)
+		if err != nil {
+			t.Skipf("IPv6 unavailable: %v", err)
+		}
+		port := strconv.Itoa(lis.Addr().(*net.TCPAddr).Port)
+
+		conn, err := net.Dial("tcp6", net.JoinHostPort("::1", port))
+		if err != nil {
+			t.Errorf("ipv6Only %v: dial [::1] error = %v", tc.ipv6Only, err)
+		} else {
+			conn.Close()
+		}
+		conn, err = net.Dial("tcp4", net.JoinHostPort("127.0.0.1", port))
+		if (err == nil) != tc.wantV4 {
+			t.Errorf("ipv6Only %v: dial 127.0.0.1 error = %v, want IPv4 accepted %v", tc.ipv6Only, err, tc.wantV4)
+		}
+		if err == nil {
+			conn.Close()
+		}
+		lis.Close()
+	}
+}
+
+func TestCheckIPv6Only(t *testing.T) {
+	for _, tc := range []struct {
+		addr    string
+		wantErr bool
+	}{
+		{"[::]:9090", false},
+		{":9090", false},
+		{"[fe80::1%eth0]:9090", false},
+		{"sink.example:9090", false},
+		{"0.0.0.0:9090", true},
+		{"[::ffff:10.0.0.1]:9090", true},
+		{"[::]", true},
+	} {
+		if err := CheckIPv6Only(tc.addr); (err != nil) != tc.wantErr {
+			t.Errorf("CheckIPv6Only(%s) error = %v, want error %v", tc.addr, err, tc.wantErr)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	for _, tc := range []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 5000}, "192.0.2.7"},
+		{&net.TCPAddr{IP: net.IPv4(192, 0, 2, 7).To4(), Port: 5000}, "192.0.2.7"},
+		{&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.7"), Port: 5000}, "192.0.2.7"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 5000}, "2001:db8::7"},
+		{&net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 5000, Zone: "eth0"}, "fe80::1%eth0"},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 5683, Zone: "wlan0"}, "fe80::1%wlan0"},
+	} {
+		got, ok := ClientIP(tc.addr)
+		if !ok || got != tc.want {
+			t.Errorf("ClientIP(%s) = %q, %v, want %q", tc.addr, got, ok, tc.want)
+		}
+	}
+
+	if _, ok := ClientIP(&net.UnixAddr{Name: "/run/sink.sock", Net: "unix"}); ok {
+		t.Error("ClientIP(unix) ok = true, want false")
+	}
+}
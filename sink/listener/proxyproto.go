@@ -0,0 +1,208 @@
+package listener
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long a connection may take to send its PROXY
+// protocol header.
+const proxyHeaderTimeout = 5 * time.Second
+
+// proxySignature starts every PROXY protocol v2 header.
+var proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// PROXY protocol v2 commands and address families.
+const (
+	proxyCommandLocal = 0x0
+	proxyCommandProxy = 0x1
+	proxyFamilyTCP4   = 0x11
+	proxyFamilyTCP6   = 0x21
+)
+
+// proxyListener reads the PROXY protocol header of accepted connections and
+// reports the client address it carries as their remote address.
+type proxyListener struct {
+	net.Listener
+	trusted []netip.Prefix
+
+	accepted chan acceptResult
+	done     chan struct{}
+	once     sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// ProxyProtocol wraps lis for a sink behind an L4 load balancer: every connection
+// must start with a PROXY protocol v2 header, and its remote address is the client
+// address in the header rather than the load balancer's. Connections from outside
+// trusted are closed, so clients can't forge their address; with no trusted
+// networks every connection is. Headers are
+// read off the accept loop, so a connection that is slow to send one holds up no
+// other. Connections without an IP address (unix sockets) are passed through.
+func ProxyProtocol(lis net.Listener, trusted []netip.Prefix) net.Listener {
+	l := &proxyListener{
+		Listener: lis,
+		trusted:  trusted,
+		accepted: make(chan acceptResult),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *proxyListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.accepted <- acceptResult{err: err}:
+			case <-l.done:
+				return
+			}
+			// Temporary errors, such as running out of file descriptors, are
+			// passed on for the server to back off and call Accept again.
+			if temp, ok := err.(interface{ Temporary() bool }); ok && temp.Temporary() {
+				continue
+			}
+			return
+		}
+		go l.readHeader(conn)
+	}
+}
+
+func (l *proxyListener) readHeader(conn net.Conn) {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		l.deliver(conn)
+		return
+	}
+	if !l.isTrusted(addr) {
+		log.Printf("Rejecting connection from %s: not a trusted load balancer", addr)
+		conn.Close()
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	r := bufio.NewReader(conn)
+	source, err := readProxyHeader(r)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Printf("Rejecting connection from %s: PROXY protocol header: %v", addr, err)
+		conn.Close()
+		return
+	}
+	if source == nil {
+		source = addr
+	}
+	l.deliver(&proxiedConn{Conn: conn, reader: r, remote: source})
+}
+
+func (l *proxyListener) deliver(conn net.Conn) {
+	select {
+	case l.accepted <- acceptResult{conn: conn}:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *proxyListener) isTrusted(addr *net.TCPAddr) bool {
+	ip, ok := netip.AddrFromSlice(addr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case result := <-l.accepted:
+		return result.conn, result.err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// readProxyHeader reads a PROXY protocol v2 header and returns the client address
+// it carries, or nil for a LOCAL command, which load balancers send for their own
+// health checks, and for address families other than TCP.
+func readProxyHeader(r *bufio.Reader) (*net.TCPAddr, error) {
+	var header [16]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:12], proxySignature) {
+		return nil, fmt.Errorf("missing v2 signature")
+	}
+	if version := header[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	command, family := header[12]&0xf, header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case proxyCommandLocal:
+		return nil, nil
+	case proxyCommandProxy:
+	default:
+		return nil, fmt.Errorf("unknown command %d", command)
+	}
+
+	// The addresses are followed by TLVs, which are ignored.
+	switch family {
+	case proxyFamilyTCP4:
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("short IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case proxyFamilyTCP6:
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("short IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// proxiedConn is a connection whose header was read, reporting the client's
+// address. Reads go through the reader the header was read with, as it may hold
+// data sent right after the header.
+type proxiedConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxiedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,124 @@
+package listener
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+)
+
+// proxyHeader builds a PROXY protocol v2 header for a TCP connection from src.
+func proxyHeader(command byte, src netip.AddrPort) []byte {
+	header := append([]byte{}, proxySignature...)
+	header = append(header, 0x20|command)
+	var addrs []byte
+	if src.Addr().Is4() {
+		header = append(header, proxyFamilyTCP4)
+		addrs = append(addrs, src.Addr().AsSlice()...)
+		addrs = append(addrs, 10, 0, 0, 1)
+	} else {
+		header = append(header, proxyFamilyTCP6)
+		addrs = append(addrs, src.Addr().AsSlice()...)
+		addrs = append(addrs, netip.MustParseAddr("fd00::1").AsSlice()...)
+	}
+	addrs = binary.BigEndian.AppendUint16(addrs, src.Port())
+	addrs = binary.BigEndian.AppendUint16(addrs, 9090)
+	addrs = append(addrs, 0x04, 0x00, 0x01, 0xff) // a TLV, ignored
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestProxyProtocol(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	listen := func(trusted []netip.Prefix) (net.Listener, <-chan net.Conn) {
+		tcp, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		lis := ProxyProtocol(tcp, trusted)
+		t.Cleanup(func() { lis.Close() })
+
+		accepted := make(chan net.Conn, 4)
+		go func() {
+			for {
+				conn, err := lis.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- conn
+			}
+		}()
+		return lis, accepted
+	}
+	dial := func(lis net.Listener, data []byte) net.Conn {
+		conn, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.Write(data)
+		return conn
+	}
+	receive := func(accepted <-chan net.Conn) net.Conn {
+		select {
+		case conn := <-accepted:
+			t.Cleanup(func() { conn.Close() })
+			return conn
+		case <-time.After(2 * time.Second):
+			t.Fatal("connection not accepted")
+			return nil
+		}
+	}
+	closed := func(conn net.Conn) bool {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		return err == io.EOF
+	}
+
+	lis, accepted := listen([]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")})
+	for _, client := range []string{"203.0.113.7:40000", "[2001:db8::7]:40000"} {
+		dial(lis, append(proxyHeader(proxyCommandProxy, netip.MustParseAddrPort(client)), "hello"...))
+		conn := receive(accepted)
+		if got := conn.RemoteAddr().String(); got != client {
+			t.Errorf("RemoteAddr() = %s, want the client's %s", got, client)
+		}
+		data := make([]byte, 5)
+		if _, err := io.ReadFull(conn, data); err != nil || string(data) != "hello" {
+			t.Errorf("data after the header = %q, %v, want hello", data, err)
+		}
+	}
+
+	// A load balancer's health check keeps its own address.
+	dial(lis, proxyHeader(proxyCommandLocal, netip.MustParseAddrPort("203.0.113.7:40000")))
+	if conn := receive(accepted); conn.RemoteAddr().(*net.TCPAddr).IP.String() != "127.0.0.1" {
+		t.Errorf("RemoteAddr() of a LOCAL connection = %s, want the load balancer's", conn.RemoteAddr())
+	}
+
+	// A connection without a header is closed and holds up no other.
+	silent := dial(lis, nil)
+	garbage := dial(lis, []byte("GET / HTTP/1.1\r\nHost: sink\r\n\r\n"))
+	if !closed(garbage) {
+		t.Error("connection without a header should be closed")
+	}
+	dial(lis, proxyHeader(proxyCommandProxy, netip.MustParseAddrPort("203.0.113.8:40000")))
+	if conn := receive(accepted); conn.RemoteAddr().String() != "203.0.113.8:40000" {
+		t.Errorf("RemoteAddr() = %s while another connection sends nothing, want 203.0.113.8:40000", conn.RemoteAddr())
+	}
+	silent.Close()
+
+	// Only trusted load balancers may send headers.
+	lis, _ = listen([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")})
+	if !closed(dial(lis, proxyHeader(proxyCommandProxy, netip.MustParseAddrPort("203.0.113.7:40000")))) {
+		t.Error("connection from an untrusted address should be closed")
+	}
+	lis, _ = listen(nil)
+	if !closed(dial(lis, proxyHeader(proxyCommandProxy, netip.MustParseAddrPort("203.0.113.7:40000")))) {
+		t.Error("connection without trusted networks should be closed")
+	}
+}
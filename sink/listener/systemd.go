@@ -0,0 +1,45 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd socket activation.
+const listenFdsStart = 3
+
+// Activated returns the listener passed by systemd socket activation (sd_listen_fds),
+// or nil if the process was not socket activated. Only the first passed socket is
+// used. The activation environment is cleared so child processes don't inherit it.
+func Activated() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds < 1 {
+		return nil, nil
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		syscall.CloseOnExec(fd)
+	}
+
+	file := os.NewFile(uintptr(listenFdsStart), "systemd-socket")
+	defer file.Close()
+
+	lis, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("use systemd socket: %w", err)
+	}
+
+	return lis, nil
+}
@@ -0,0 +1,87 @@
+package liveness
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	lastSeenDesc = prometheus.NewDesc(
+		"telemetry_sensor_last_seen_timestamp_seconds",
+		"Unix time of the latest reading or heartbeat from a sensor.",
+		[]string{"tenant", "sensor"}, nil,
+	)
+	clockSkewDesc = prometheus.NewDesc(
+		"telemetry_sensor_clock_skew_seconds",
+		"Server time minus device time of the latest timestamped message from a sensor.",
+		[]string{"tenant", "sensor"}, nil,
+	)
+	ingestLatencyDesc = prometheus.NewDesc(
+		"telemetry_sensor_ingest_latency_seconds",
+		"Receive time minus device time of timestamped readings from a sensor.",
+		[]string{"tenant", "sensor"}, nil,
+	)
+	lateReadingsDesc = prometheus.NewDesc(
+		"telemetry_sensor_late_readings_total",
+		"Timestamped readings from a sensor received more than -max-ingest-latency after their device time.",
+		[]string{"tenant", "sensor"}, nil,
+	)
+	sequenceGapsDesc = prometheus.NewDesc(
+		"telemetry_sensor_sequence_gaps_total",
+		"Jumps in the sequence numbers of a sensor's readings.",
+		[]string{"tenant", "sensor"}, nil,
+	)
+	missingReadingsDesc = prometheus.NewDesc(
+		"telemetry_sensor_missing_readings",
+		"Sequence numbers of a sensor's readings skipped by gaps and not received since.",
+		[]string{"tenant", "sensor"}, nil,
+	)
+	sensorsDesc = prometheus.NewDesc(
+		"telemetry_sensors",
+		"Tracked sensors by state.",
+		[]string{"state"}, nil,
+	)
+)
+
+// Collector exports the tracker's sensors as Prometheus metrics at scrape time.
+type Collector struct {
+	tracker *Tracker
+}
+
+func NewCollector(tracker *Tracker) *Collector {
+	return &Collector{tracker: tracker}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lastSeenDesc
+	ch <- clockSkewDesc
+	ch <- ingestLatencyDesc
+	ch <- lateReadingsDesc
+	ch <- sequenceGapsDesc
+	ch <- missingReadingsDesc
+	ch <- sensorsDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	var alive, silent int
+	for _, s := range c.tracker.List(time.Now()) {
+		ch <- prometheus.MustNewConstMetric(lastSeenDesc, prometheus.GaugeValue, float64(s.LastSeen.UnixNano())/1e9, s.Tenant, s.Name)
+		ch <- prometheus.MustNewConstMetric(clockSkewDesc, prometheus.GaugeValue, s.ClockSkew.Seconds(), s.Tenant, s.Name)
+		if s.latency.count > 0 {
+			ch <- prometheus.MustNewConstHistogram(ingestLatencyDesc, s.latency.count, s.latency.sum, s.latency.buckets(), s.Tenant, s.Name)
+			ch <- prometheus.MustNewConstMetric(lateReadingsDesc, prometheus.CounterValue, float64(s.LateReadings), s.Tenant, s.Name)
+		}
+		if s.LastSequence > 0 {
+			ch <- prometheus.MustNewConstMetric(sequenceGapsDesc, prometheus.CounterValue, float64(s.SequenceGaps), s.Tenant, s.Name)
+			ch <- prometheus.MustNewConstMetric(missingReadingsDesc, prometheus.GaugeValue, float64(s.MissingReadings), s.Tenant, s.Name)
+		}
+		if s.Silent {
+			silent++
+		} else {
+			alive++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(sensorsDesc, prometheus.GaugeValue, float64(alive), "alive")
+	ch <- prometheus.MustNewConstMetric(sensorsDesc, prometheus.GaugeValue, float64(silent), "silent")
+}
@@ -0,0 +1,257 @@
+package liveness
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sink/state"
+)
+
+// Sensor is what the tracker knows about a sensor.
+type Sensor struct {
+	Tenant        string            `json:"tenant"`
+	Name          string            `json:"name"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Registered    bool              `json:"registered"`
+	FirstSeen     time.Time         `json:"first_seen"`
+	LastSeen      time.Time         `json:"last_seen"` // latest reading or heartbeat
+	LastReading   time.Time         `json:"last_reading,omitempty"`
+	LastHeartbeat time.Time         `json:"last_heartbeat,omitempty"`
+	Readings      uint64            `json:"readings"`
+	Silent        bool              `json:"silent"`
+	// ClockSkew is server time minus device time of the latest reading or heartbeat
+	// that carried a timestamp; positive when the device clock is behind.
+	ClockSkew time.Duration `json:"clock_skew"`
+	// LateReadings counts the timestamped readings received more than the tracker's
+	// maximum latency after their device time.
+	LateReadings uint64 `json:"late_readings"`
+	// LastSequence is the highest sequence number of the sensor's readings, 0 while
+	// they carry none.
+	LastSequence uint64 `json:"last_sequence,omitempty"`
+	// SequenceGaps counts the jumps in the sequence numbers, and MissingReadings the
+	// numbers they skipped that no reading has filled since.
+	SequenceGaps    uint64 `json:"sequence_gaps"`
+	MissingReadings uint64 `json:"missing_readings"`
+	// Gaps are the ranges of missing numbers, oldest first. Only the latest maxGaps
+	// are kept; the numbers of older ones stay missing.
+	Gaps []Gap `json:"gaps,omitempty"`
+
+	latency histogram // of receive time minus device time of timestamped readings
+}
+
+// Gap is a range of sequence numbers no reading of a sensor has carried, From to To
+// inclusive.
+type Gap struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+// maxGaps bounds the gaps kept per sensor.
+const maxGaps = 16
+
+// LatencyBuckets are the upper bounds, in seconds, of the sensors' ingest latency
+// histograms: from a reading on a LAN to one held back by a flaky uplink.
+var LatencyBuckets = [...]float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// histogram counts observations into LatencyBuckets.
+type histogram struct {
+	counts [len(LatencyBuckets)]uint64
+	count  uint64
+	sum    float64
+}
+
+// observe records a latency. Negative ones, from device clocks running ahead, count
+// as 0.
+func (h *histogram) observe(latency time.Duration) {
+	seconds := max(latency.Seconds(), 0)
+	for i, bound := range LatencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// buckets returns the cumulative count of every bucket, keyed by upper bound.
+func (h *histogram) buckets() map[float64]uint64 {
+	buckets := make(map[float64]uint64, len(LatencyBuckets))
+	for i, bound := range LatencyBuckets {
+		buckets[bound] = h.counts[i]
+	}
+	return buckets
+}
+
+type key struct {
+	tenant string
+	name   string
+}
+
+// Tracker keeps the last-seen time of every sensor that registered, sent a heartbeat
+// or reported a reading, and the ingest latency of its readings. Sensors silent for
+// longer than the limits' TTL are forgotten, as is the least recently seen sensor
+// when more than MaxEntries are tracked.
+type Tracker struct {
+	silentAfter time.Duration // 0 never reports a sensor silent
+	maxLatency  time.Duration // 0 counts no reading late
+
+	mu      sync.Mutex
+	sensors *state.Table[key, *Sensor]
+}
+
+func NewTracker(silentAfter, maxLatency time.Duration, limits state.Limits) *Tracker {
+	return &Tracker{
+		silentAfter: silentAfter,
+		maxLatency:  maxLatency,
+		sensors:     state.NewTable[key, *Sensor]("sensors", limits),
+	}
+}
+
+// Register records a sensor announcing itself, replacing earlier metadata.
+func (t *Tracker) Register(tenant, name string, metadata map[string]string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.get(tenant, name, now)
+	s.Registered = true
+	s.Metadata = metadata
+	s.LastSeen = now
+}
+
+// Heartbeat records a heartbeat from a sensor. deviceTime is the sensor's clock when
+// it sent the heartbeat, or zero if unknown.
+func (t *Tracker) Heartbeat(tenant, name string, now, deviceTime time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.get(tenant, name, now)
+	s.LastHeartbeat = now
+	s.LastSeen = now
+	if !deviceTime.IsZero() {
+		s.ClockSkew = now.Sub(deviceTime)
+	}
+}
+
+// Reading records an accepted reading from a sensor and returns the number of
+// readings accepted from it so far. deviceTime is the reading's timestamp, or zero
+// if unknown.
+func (t *Tracker) Reading(tenant, name string, now, deviceTime time.Time) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.get(tenant, name, now)
+	s.LastReading = now
+	s.LastSeen = now
+	s.Readings++
+	if !deviceTime.IsZero() {
+		s.ClockSkew = now.Sub(deviceTime)
+		s.latency.observe(s.ClockSkew)
+		if t.maxLatency > 0 && s.ClockSkew > t.maxLatency {
+			s.LateReadings++
+		}
+	}
+	return s.Readings
+}
+
+// Sequence records the sequence number of a reading received from a tracked sensor,
+// whether it was stored or filtered. A number past the next one expected opens a gap
+// of the numbers in between, and one inside a gap fills it. Numbers seen before are
+// ignored, except 1, which tells that the sensor's numbering started over.
+func (t *Tracker) Sequence(tenant, name string, sequence uint64, now time.Time) {
+	if sequence == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.sensors.Get(key{tenant: tenant, name: name}, now)
+	if !ok {
+		return
+	}
+	switch {
+	case s.LastSequence == 0 || sequence == s.LastSequence+1 || sequence == 1:
+		// The numbers before the first one seen aren't known to be missing.
+		s.LastSequence = sequence
+	case sequence > s.LastSequence:
+		s.SequenceGaps++
+		s.MissingReadings += sequence - s.LastSequence - 1
+		s.addGap(Gap{From: s.LastSequence + 1, To: sequence - 1})
+		s.LastSequence = sequence
+	default:
+		s.fill(sequence)
+	}
+}
+
+func (s *Sensor) addGap(gap Gap) {
+	s.Gaps = append(s.Gaps, gap)
+	if len(s.Gaps) > maxGaps {
+		s.Gaps = slices.Delete(s.Gaps, 0, len(s.Gaps)-maxGaps)
+	}
+}
+
+// fill removes a late sequence number from the gap holding it, if any.
+func (s *Sensor) fill(sequence uint64) {
+	i := slices.IndexFunc(s.Gaps, func(g Gap) bool { return g.From <= sequence && sequence <= g.To })
+	if i < 0 {
+		return
+	}
+	s.MissingReadings--
+	switch gap := s.Gaps[i]; {
+	case gap.From == gap.To:
+		s.Gaps = slices.Delete(s.Gaps, i, i+1)
+	case sequence == gap.From:
+		s.Gaps[i].From++
+	case sequence == gap.To:
+		s.Gaps[i].To--
+	default:
+		s.Gaps[i].To = sequence - 1
+		s.Gaps = slices.Insert(s.Gaps, i+1, Gap{From: sequence + 1, To: gap.To})
+		if len(s.Gaps) > maxGaps {
+			s.Gaps = slices.Delete(s.Gaps, 0, 1)
+		}
+	}
+}
+
+// List returns a snapshot of all tracked sensors ordered by tenant and name.
+func (t *Tracker) List(now time.Time) []Sensor {
+	t.mu.Lock()
+	t.sensors.Sweep(now)
+	list := make([]Sensor, 0, t.sensors.Len())
+	t.sensors.Range(func(_ key, s *Sensor) bool {
+		c := *s
+		c.Gaps = slices.Clone(s.Gaps)
+		list = append(list, c)
+		return true
+	})
+	t.mu.Unlock()
+
+	for i := range list {
+		list[i].Silent = t.silent(&list[i], now)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Tenant != list[j].Tenant {
+			return list[i].Tenant < list[j].Tenant
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// SilentAfter returns the time without readings or heartbeats after which a sensor
+// is reported silent.
+func (t *Tracker) SilentAfter() time.Duration {
+	return t.silentAfter
+}
+
+func (t *Tracker) silent(s *Sensor, now time.Time) bool {
+	return t.silentAfter > 0 && now.Sub(s.LastSeen) > t.silentAfter
+}
+
+func (t *Tracker) get(tenant, name string, now time.Time) *Sensor {
+	return t.sensors.GetOrCreate(key{tenant: tenant, name: name}, now, func() *Sensor {
+		return &Sensor{Tenant: tenant, Name: name, FirstSeen: now}
+	})
+}
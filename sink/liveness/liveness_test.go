@@ -0,0 +1,130 @@
+package liveness
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sink/state"
+)
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker(time.Minute, 0, state.Limits{})
+	start := time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+
+	tracker.Register("plant", "temperature-01", map[string]string{"model": "TMP117"}, start)
+	tracker.Reading("plant", "temperature-01", start.Add(10*time.Second), start.Add(9*time.Second))
+	tracker.Reading("plant", "pressure-01", start.Add(20*time.Second), time.Time{})
+	tracker.Heartbeat("plant", "temperature-01", start.Add(50*time.Second), start.Add(53*time.Second))
+
+	list := tracker.List(start.Add(90 * time.Second))
+	if len(list) != 2 {
+		t.Fatalf("List() returned %d sensors, want 2", len(list))
+	}
+
+	pressure, temperature := list[0], list[1]
+	if pressure.Name != "pressure-01" || temperature.Name != "temperature-01" {
+		t.Fatalf("List() = %v, %v, want sorted by name", pressure.Name, temperature.Name)
+	}
+
+	if pressure.Registered || !pressure.Silent || pressure.Readings != 1 {
+		t.Errorf("pressure-01 = %+v, want unregistered, silent, 1 reading", pressure)
+	}
+	if !temperature.Registered || temperature.Silent || temperature.Metadata["model"] != "TMP117" {
+		t.Errorf("temperature-01 = %+v, want registered, alive, with metadata", temperature)
+	}
+	if temperature.ClockSkew != -3*time.Second {
+		t.Errorf("temperature-01 ClockSkew = %v, want -3s from the latest heartbeat", temperature.ClockSkew)
+	}
+	if !temperature.LastSeen.Equal(start.Add(50 * time.Second)) {
+		t.Errorf("temperature-01 LastSeen = %v, want last heartbeat", temperature.LastSeen)
+	}
+}
+
+func TestTracker_ForgetsIdleSensors(t *testing.T) {
+	tracker := NewTracker(time.Minute, 0, state.Limits{TTL: time.Hour, MaxEntries: 2})
+	start := time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+
+	tracker.Reading("plant", "old", start, time.Time{})
+	tracker.Reading("plant", "a", start.Add(30*time.Minute), time.Time{})
+	tracker.Reading("plant", "b", start.Add(40*time.Minute), time.Time{})
+	tracker.Reading("plant", "c", start.Add(50*time.Minute), time.Time{})
+
+	// "old" was evicted by the cap when "b" arrived, "a" by the cap for "c".
+	list := tracker.List(start.Add(50 * time.Minute))
+	if len(list) != 2 || list[0].Name != "b" || list[1].Name != "c" {
+		t.Fatalf("List() = %+v, want b and c", list)
+	}
+
+	// Both are idle for more than the TTL two hours later.
+	if list := tracker.List(start.Add(3 * time.Hour)); len(list) != 0 {
+		t.Errorf("List() after TTL = %+v, want none", list)
+	}
+}
+
+func TestTracker_IngestLatency(t *testing.T) {
+	tracker := NewTracker(time.Minute, 5*time.Second, state.Limits{})
+	start := time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+
+	tracker.Reading("site-a", "flow-01", start, start.Add(-200*time.Millisecond))
+	tracker.Reading("site-a", "flow-01", start.Add(time.Minute), start.Add(50*time.Second))
+	tracker.Reading("site-a", "flow-01", start.Add(2*time.Minute), start.Add(2*time.Minute+time.Second)) // device clock ahead
+	tracker.Reading("site-a", "flow-01", start.Add(3*time.Minute), time.Time{})
+
+	s := tracker.List(start.Add(3 * time.Minute))[0]
+	if s.LateReadings != 1 {
+		t.Errorf("LateReadings = %d, want 1", s.LateReadings)
+	}
+	if s.latency.count != 3 || s.latency.sum != 10.2 {
+		t.Errorf("latency count, sum = %d, %v, want 3 timestamped readings summing to 10.2s", s.latency.count, s.latency.sum)
+	}
+	buckets := s.latency.buckets()
+	if buckets[0.01] != 1 || buckets[0.25] != 2 || buckets[10] != 3 {
+		t.Errorf("latency buckets = %v, want 1 at 0.01s, 2 at 0.25s and 3 at 10s", buckets)
+	}
+}
+
+func TestTracker_Sequence(t *testing.T) {
+	tracker := NewTracker(time.Minute, 0, state.Limits{})
+	now := time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+
+	// Untracked sensors, e.g. ones whose readings were all filtered, aren't added.
+	tracker.Sequence("plant", "flow-01", 7, now)
+	if list := tracker.List(now); len(list) != 0 {
+		t.Fatalf("List() = %+v, want none", list)
+	}
+
+	tracker.Reading("plant", "flow-01", now, time.Time{})
+	for _, n := range []uint64{7, 8, 12, 10, 8, 20, 15, 19} {
+		tracker.Sequence("plant", "flow-01", n, now)
+	}
+	s := tracker.List(now)[0]
+	want := []Gap{{From: 9, To: 9}, {From: 11, To: 11}, {From: 13, To: 14}, {From: 16, To: 18}}
+	if s.LastSequence != 20 || s.SequenceGaps != 2 || s.MissingReadings != 7 || !slices.Equal(s.Gaps, want) {
+		t.Errorf("sequence state = last %d, %d gaps, %d missing, %v; want last 20, 2 gaps, 7 missing, %v",
+			s.LastSequence, s.SequenceGaps, s.MissingReadings, s.Gaps, want)
+	}
+
+	// The node started its numbering over.
+	tracker.Sequence("plant", "flow-01", 1, now)
+	tracker.Sequence("plant", "flow-01", 3, now)
+	s = tracker.List(now)[0]
+	if s.LastSequence != 3 || s.SequenceGaps != 3 || s.MissingReadings != 8 {
+		t.Errorf("after restart = last %d, %d gaps, %d missing; want last 3, 3 gaps, 8 missing", s.LastSequence, s.SequenceGaps, s.MissingReadings)
+	}
+}
+
+func TestTracker_SequenceKeepsLatestGaps(t *testing.T) {
+	tracker := NewTracker(0, 0, state.Limits{})
+	now := time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+	tracker.Reading("plant", "flow-01", now, time.Time{})
+
+	for n := uint64(1); n <= 2*maxGaps+3; n += 2 {
+		tracker.Sequence("plant", "flow-01", n, now)
+	}
+	s := tracker.List(now)[0]
+	// The numbers of the dropped gap stay missing.
+	if len(s.Gaps) != maxGaps || s.Gaps[0].From != 4 || s.MissingReadings != maxGaps+1 {
+		t.Errorf("gaps = %v, %d missing; want the latest %d from 4, %d missing", s.Gaps, s.MissingReadings, maxGaps, maxGaps+1)
+	}
+}
@@ -1,28 +1,55 @@
 package main
 
 import (
+	"cmp"
 	"flag"
 	"fmt"
 	"log"
+	"net/netip"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/sink/auth"
 	"github.com/sink/config"
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/listener"
+	"github.com/sink/pkg/logformat"
+	"github.com/sink/replication"
 	grpcserver "github.com/sink/server"
+	"github.com/sink/state"
 )
 
+// Exit codes tell orchestrators a configuration error, which restarting won't fix,
+// from a failure at runtime. Invalid flags also exit with exitConfig, as the flag
+// package does.
+const (
+	exitRuntime = 1
+	exitConfig  = 2
+)
+
+var checkConfig = flag.Bool("check-config", false, "Validate the configuration, including keys, certificates and policy files, and exit")
+
 func main() {
 	cfg, err := parseFlags()
 	if err != nil {
-		log.Fatalf("Failed to parse flags: %v", err)
+		fatal(exitConfig, "Failed to parse flags: %v", err)
+	}
+	if err := grpcserver.CheckConfig(cfg); err != nil {
+		fatal(exitConfig, "Invalid configuration: %v", err)
+	}
+	if *checkConfig {
+		log.Println("Configuration OK")
+		return
 	}
 
 	server, err := grpcserver.NewSinkServer(cfg)
 	if err != nil {
-		log.Fatalf("Failed to create sink server: %v", err)
+		fatal(exitRuntime, "Failed to create sink server: %v", err)
 	}
 	defer server.Close()
 
@@ -35,38 +62,303 @@ func main() {
 		server.Stop()
 	}()
 
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+
+	go func() {
+		for range reloadChan {
+			log.Println("Received SIGHUP, reloading configuration...")
+			if err := server.Reload(); err != nil {
+				log.Printf("Failed to reload configuration: %v", err)
+			}
+		}
+	}()
+
+	upgradeChan := make(chan os.Signal, 1)
+	signal.Notify(upgradeChan, syscall.SIGUSR2)
+
+	go func() {
+		for range upgradeChan {
+			log.Println("Received SIGUSR2, upgrading sink process...")
+			if err := server.Upgrade(); err != nil {
+				log.Printf("Upgrade failed, continuing to serve: %v", err)
+			}
+		}
+	}()
+
+	flushChan := make(chan os.Signal, 1)
+	signal.Notify(flushChan, syscall.SIGUSR1)
+
+	go func() {
+		for range flushChan {
+			log.Println("Received SIGUSR1, flushing buffer to disk...")
+			if err := server.FlushNow(); err != nil {
+				log.Printf("Failed to flush buffer: %v", err)
+			} else {
+				log.Println("Buffer flushed and log file synced")
+			}
+		}
+	}()
+
 	log.Printf("Starting sink server on %s", cfg.BindAddr)
 	log.Printf("Log file: %s", cfg.LogFilePath)
+	if len(cfg.LogFields) > 0 || cfg.LogTimeFormat != logformat.TimeRFC3339 {
+		log.Printf("Log entries: renamed fields %v, times as %s", cfg.LogFields, cfg.LogTimeFormat)
+	}
 	log.Printf("Buffer size: %d bytes", cfg.BufferSize)
 	log.Printf("Flush interval: %v", cfg.FlushInterval)
-	log.Printf("Rate limit: %d bytes/sec", cfg.RateLimit)
+	if cfg.MaxBufferAge > 0 {
+		log.Printf("Max buffer age: %v", cfg.MaxBufferAge)
+	}
+	if cfg.FlushPolicy == config.FlushPolicyAdaptive {
+		log.Printf("Adaptive flushing: target write size %d bytes", cmp.Or(cfg.FlushTargetSize, cfg.BufferSize))
+	}
+	log.Printf("Rate limit: %d bytes/sec (critical reserve: %d bytes/sec)", cfg.RateLimit, cfg.CriticalRateLimit)
+	if cfg.TenantRateLimit > 0 || cfg.SensorRateLimit > 0 {
+		log.Printf("Quotas: tenant %d bytes/sec, sensor %d bytes/sec, redis: %q", cfg.TenantRateLimit, cfg.SensorRateLimit, cfg.RedisAddr)
+	}
+	if cfg.AttachmentRateLimit > 0 {
+		log.Printf("Attachment rate limit: %d bytes/sec", cfg.AttachmentRateLimit)
+	}
+	if cfg.OverloadPolicy == config.OverloadSample {
+		log.Printf("Overload policy: %s, keeping 1 in %d", cfg.OverloadPolicy, cfg.OverloadSampleRate)
+	} else {
+		log.Printf("Overload policy: %s", cfg.OverloadPolicy)
+	}
+	for _, output := range outputs(cfg) {
+		switch {
+		case output == config.OutputInflux && cfg.InfluxURL != "":
+			log.Printf("Output: %s to %s, measurement %q, %d retries", output, cfg.InfluxURL, cfg.InfluxMeasurement, cfg.InfluxRetries)
+		case output == config.OutputInflux:
+			log.Printf("Output: %s to %s, measurement %q", output, cfg.LogFilePath, cfg.InfluxMeasurement)
+		case output == config.OutputPostgres:
+			log.Printf("Output: %s table %s, %d connections, %d retries", output, cfg.PostgresTable, cfg.PostgresMaxConns, cfg.PostgresRetries)
+		case output == config.OutputRemoteWrite:
+			log.Printf("Output: %s to %s as %s, write-ahead log %s (quota %d bytes)", output, cfg.RemoteWriteURL, cfg.RemoteWriteName, cfg.RemoteWriteWALDir, cfg.RemoteWriteWALQuota)
+		case output == config.OutputNATS:
+			log.Printf("Output: %s subject %s, %d retries", output, cfg.NATSSubject, cfg.NATSRetries)
+		case output == config.OutputClickHouse:
+			log.Printf("Output: %s table %s, async insert %v (wait %v), %d retries", output, cfg.ClickHouseTable, cfg.ClickHouseAsyncInsert, cfg.ClickHouseWaitForAsyncInsert, cfg.ClickHouseRetries)
+		case len(cfg.FanOut) > 0:
+			log.Printf("Output: %s to %s", output, cfg.LogFilePath)
+		}
+	}
+	if cfg.NATSIngestSubject != "" {
+		log.Printf("NATS ingestion: %s as %s", cfg.NATSIngestSubject, cfg.NATSIngestDurable)
+	}
+	if cfg.SpillDir != "" {
+		log.Printf("Spill: %s, quota %d bytes", cfg.SpillDir, cfg.SpillQuota)
+	}
+	if cfg.WatchdogInterval > 0 {
+		log.Printf("Watchdog: every %v, actions %v", cfg.WatchdogInterval, cfg.WatchdogActions)
+	}
+	if cfg.ReplicaAddr != "" {
+		log.Printf("Replication: to %s, acknowledged by %s", cfg.ReplicaAddr, cfg.ReplicaAck)
+	}
+	if cfg.AcceptReplication {
+		log.Printf("Replication: accepted from primary sinks %s", strings.Join(cfg.ReplicationPeers, ", "))
+	}
+	if cfg.LeaderLockFile != "" {
+		log.Printf("Leader election: lock %s, advertising %s", cfg.LeaderLockFile, cfg.AdvertiseAddr)
+	}
+	if len(cfg.RedirectTo) > 0 {
+		log.Printf("Redirecting sensor nodes to %s", strings.Join(cfg.RedirectTo, ", "))
+	}
+	if cfg.SelfTelemetryInterval > 0 {
+		log.Printf("Self-telemetry: every %v as %q, upstream: %q", cfg.SelfTelemetryInterval, cfg.SelfTelemetrySensor, cfg.SelfTelemetryUpstream)
+	}
 
 	if err := server.Start(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		fatal(exitRuntime, "Failed to start server: %v", err)
 	}
 }
 
+func fatal(code int, format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+// outputs returns the primary output followed by the fan-out ones.
+func outputs(cfg config.Config) []string {
+	return append([]string{cfg.Output}, cfg.FanOut...)
+}
+
+func hasOutput(cfg config.Config, output string) bool {
+	return slices.Contains(outputs(cfg), output)
+}
+
 func parseFlags() (config.Config, error) {
 	var (
 		cfg config.Config
 		err error
 	)
 
-	flag.StringVar(&cfg.BindAddr, "bind-addr", ":9090", "Server bind address")
+	flag.StringVar(&cfg.BindAddr, "bind-addr", ":9090", "Server bind address (host:port or unix:///path/to.sock)")
+	socketMode := flag.Uint("unix-socket-mode", 0660, "Permissions of the unix socket file when -bind-addr is unix://")
+	flag.BoolVar(&cfg.IPv6Only, "ipv6-only", false, "Listen on IPv6 only: -bind-addr, -frame-addr and -coap-addr of [::] or without a host don't accept IPv4 clients (by default they are dual-stack)")
 	flag.StringVar(&cfg.LogFilePath, "log-file", "telemetry.log", "Path to output log file")
+	flag.StringVar(&cfg.LogFormat, "log-format", config.LogFormatText, "Log file format: text (JSON lines) or binary (compressed, checksummed records)")
+	logFields := flag.String("log-fields", "", "Comma separated fields of log entries to rename for downstream parsers, e.g. timestamp=ts,sensor_name=name,sensor_value=val (empty keeps the names)")
+	flag.StringVar(&cfg.LogTimeFormat, "log-time-format", logformat.TimeRFC3339, "Format of the times in log entries: rfc3339, unix, unix-ms, unix-us or a Go time layout such as \"2006-01-02 15:04:05.000\", written in UTC")
+	flag.StringVar(&cfg.Output, "output", config.OutputLog, "Where entries are stored: log (-log-file in -log-format), influx (InfluxDB line protocol to -influx-url, or to -log-file when empty) postgres (rows of -postgres-table), clickhouse (rows of -clickhouse-table), nats (messages on -nats-subject) or remote-write (Prometheus remote_write to -remote-write-url). A comma separated list stores to each, with its own buffer and write queue; only the first one holds up or rejects readings, the others drop batches while they fall behind")
+	flag.StringVar(&cfg.InfluxURL, "influx-url", "", "InfluxDB or Telegraf write URL that buffers are posted to as batches, e.g. http://influxdb:8086/api/v2/write?org=acme&bucket=telemetry; the token is read from INFLUX_TOKEN")
+	flag.StringVar(&cfg.InfluxMeasurement, "influx-measurement", "telemetry", "Measurement of the points written with -output=influx")
+	flag.DurationVar(&cfg.InfluxTimeout, "influx-timeout", 10*time.Second, "Timeout of each request to -influx-url")
+	flag.IntVar(&cfg.InfluxRetries, "influx-retries", 3, "Retries of a batch that failed with a server error, throttling or a network error, backing off exponentially")
+	flag.StringVar(&cfg.PostgresURL, "postgres-url", "", "PostgreSQL connection URL or key=value string, e.g. postgres://sink@db:5432/metrics; the password is read from PGPASSWORD or ~/.pgpass")
+	flag.StringVar(&cfg.PostgresTable, "postgres-table", "telemetry", "Table, optionally schema qualified, created if missing (a hypertable with TimescaleDB)")
+	flag.IntVar(&cfg.PostgresMaxConns, "postgres-max-conns", 4, "Maximum connections to PostgreSQL")
+	flag.DurationVar(&cfg.PostgresTimeout, "postgres-timeout", 30*time.Second, "Timeout of each statement, including copying a batch")
+	flag.IntVar(&cfg.PostgresRetries, "postgres-retries", 3, "Retries of a batch that failed with a connection or transient server error, backing off exponentially")
+	flag.StringVar(&cfg.ClickHouseURL, "clickhouse-url", "", "ClickHouse HTTP interface URL, e.g. http://clickhouse:8123/?database=metrics, optionally with settings such as async_insert_busy_timeout_ms; the password is read from CLICKHOUSE_PASSWORD")
+	flag.StringVar(&cfg.ClickHouseUser, "clickhouse-user", "default", "ClickHouse user")
+	flag.StringVar(&cfg.ClickHouseTable, "clickhouse-table", "telemetry", "Table, optionally database qualified, created if missing")
+	flag.BoolVar(&cfg.ClickHouseAsyncInsert, "clickhouse-async-insert", true, "Use asynchronous inserts, which the server merges into larger batches")
+	flag.BoolVar(&cfg.ClickHouseWaitForAsyncInsert, "clickhouse-wait-for-async-insert", true, "Wait until asynchronous inserts are written, so batches aren't lost if ClickHouse fails before flushing them")
+	flag.DurationVar(&cfg.ClickHouseTimeout, "clickhouse-timeout", 30*time.Second, "Timeout of each request to ClickHouse")
+	flag.IntVar(&cfg.ClickHouseRetries, "clickhouse-retries", 3, "Retries of a batch that failed with a server error, throttling or a network error, backing off exponentially")
+	flag.StringVar(&cfg.NATSURL, "nats-url", "", "Comma separated NATS server URLs for -output=nats and -nats-ingest-subject")
+	flag.StringVar(&cfg.NATSCredsFile, "nats-creds", "", "NATS user credentials file")
+	flag.StringVar(&cfg.NATSSubject, "nats-subject", "telemetry.entries", "JetStream subject entries are published to with -output=nats")
+	flag.DurationVar(&cfg.NATSTimeout, "nats-timeout", 10*time.Second, "Time for a published batch to be acknowledged, and for a consumed reading to be stored")
+	flag.IntVar(&cfg.NATSRetries, "nats-retries", 3, "Retries of the entries of a batch that weren't acknowledged, backing off exponentially")
+	flag.StringVar(&cfg.NATSIngestSubject, "nats-ingest-subject", "", "JetStream subject to consume SensorData readings from (empty disables)")
+	flag.StringVar(&cfg.NATSIngestDurable, "nats-ingest-durable", "sink", "Name of the durable consumer of -nats-ingest-subject, shared by sinks splitting the readings")
+	flag.StringVar(&cfg.RemoteWriteURL, "remote-write-url", "", "Prometheus remote_write endpoint of Mimir, Thanos, VictoriaMetrics or Prometheus for -output=remote-write; a bearer token is read from REMOTE_WRITE_TOKEN")
+	flag.StringVar(&cfg.RemoteWriteTenant, "remote-write-tenant", "", "Tenant sent as X-Scope-OrgID to multi-tenant endpoints")
+	flag.StringVar(&cfg.RemoteWriteName, "remote-write-name", "telemetry", "Metric name of readings' values, prefixing the names of named values")
+	flag.StringVar(&cfg.RemoteWriteWALDir, "remote-write-wal-dir", "remote-write-wal", "Directory of the write-ahead log of requests waiting to be delivered")
+	flag.Int64Var(&cfg.RemoteWriteWALQuota, "remote-write-wal-quota", 1024*1024*1024, "Bytes of requests at most in -remote-write-wal-dir, beyond which batches are dropped")
+	flag.DurationVar(&cfg.RemoteWriteTimeout, "remote-write-timeout", 30*time.Second, "Timeout of each request to -remote-write-url")
 	flag.IntVar(&cfg.BufferSize, "buffer-size", 1024*5, "Buffer size in bytes")
 	flag.DurationVar(&cfg.FlushInterval, "flush-interval", 1*time.Minute, "Buffer flush interval")
+	flag.StringVar(&cfg.FlushPolicy, "flush-policy", config.FlushPolicyInterval, "When buffers are written before they are full: interval (every -flush-interval) or adaptive (also at -flush-target-size while the writer keeps up, and as soon as the ingest rate drops)")
+	flag.IntVar(&cfg.FlushTargetSize, "flush-target-size", 0, "Bytes written at a time with -flush-policy=adaptive while the writer keeps up; buffers grow to -buffer-size while it is behind (0 is -buffer-size)")
+	flag.DurationVar(&cfg.MaxBufferAge, "max-buffer-age", 0, "Longest an accepted entry waits in the buffer: the buffer is flushed early when its oldest entry would exceed it before the next flush (0 leaves it to -flush-interval)")
+	flag.IntVar(&cfg.WriteQueueSize, "write-queue-size", 4, "Number of full buffers that may wait for disk writes before requests are rejected")
+	flag.StringVar(&cfg.SpillDir, "spill-dir", "", "Directory for buffers that overflow the write queue, written once the writer catches up (empty rejects requests instead)")
+	flag.Int64Var(&cfg.SpillQuota, "spill-quota", 1024*1024*1024, "Bytes of spilled buffers at most in -spill-dir")
+	flag.StringVar(&cfg.OverloadPolicy, "overload-policy", config.OverloadReject, "What to do when the buffer and write queue are full: reject (new readings), drop-oldest (buffered entries) or sample (keep 1 in -overload-sample-rate)")
+	flag.IntVar(&cfg.OverloadSampleRate, "overload-sample-rate", 10, "Keep 1 in N readings while overloaded with -overload-policy=sample")
+	flag.DurationVar(&cfg.MaxThrottleHint, "max-throttle-hint", 0, "Delay suggested to sensor nodes in responses when the write queue is full, scaled down while it fills (0 disables)")
+	watchdogActions := flag.String("watchdog-actions", config.WatchdogShed+","+config.WatchdogFlush, "Comma separated actions while a watchdog threshold is crossed: shed, flush, dump")
+	flag.DurationVar(&cfg.WatchdogInterval, "watchdog-interval", 0, "Interval between watchdog checks of buffer, queue, flush latency and goroutines (0 disables)")
+	flag.IntVar(&cfg.WatchdogMaxQueued, "watchdog-max-queued", 0, "Queued buffers at which the watchdog trips (0 disables)")
+	flag.DurationVar(&cfg.WatchdogMaxFlushLatency, "watchdog-max-flush-latency", 10*time.Second, "Time a buffer may wait to be written before the watchdog trips (0 disables)")
+	flag.IntVar(&cfg.WatchdogMaxGoroutines, "watchdog-max-goroutines", 10000, "Goroutine count above which the watchdog trips (0 disables)")
+	flag.StringVar(&cfg.WatchdogDumpDir, "watchdog-dump-dir", "", "Directory for goroutine stacks and heap profiles written by the dump action")
+	flag.DurationVar(&cfg.SelfTelemetryInterval, "self-telemetry-interval", 0, "Interval between readings of the sink's own buffer, queue, rejections and write durations (0 disables)")
+	flag.StringVar(&cfg.SelfTelemetrySensor, "self-telemetry-sensor", "sink", "Sensor name of the sink's own readings")
+	flag.StringVar(&cfg.SelfTelemetryUpstream, "self-telemetry-upstream", "", "Sink address (host:port) to send the sink's own readings to, using the -tls settings (empty stores them through this sink's pipeline)")
+	flag.DurationVar(&cfg.DrainPeriod, "drain-period", 5*time.Second, "Time to keep serving after shutdown starts while telling sensor nodes the sink is draining")
 	flag.IntVar(&cfg.RateLimit, "rate-limit", 1024*1024, "Rate limit in bytes per second")
+	flag.IntVar(&cfg.CriticalRateLimit, "critical-rate-limit", 0, "Bytes per second reserved for critical readings on top of -rate-limit (0 disables)")
+
+	// gRPC server tuning
+	maxConcurrentStreams := flag.Uint("max-concurrent-streams", 0, "Maximum concurrent streams per client connection (0 uses the gRPC default)")
+	flag.IntVar(&cfg.MaxRecvMsgSize, "max-recv-msg-size", 0, "Maximum size of a received message in bytes (0 uses the gRPC default of 4MB)")
+	streamWorkers := flag.Uint("stream-workers", 0, "Number of worker goroutines handling requests (0 starts a goroutine per stream)")
+	flag.IntVar(&cfg.MaxConnsPerIP, "max-conns-per-ip", 0, "Maximum open connections per client IP (0 is unlimited)")
+	flag.BoolVar(&cfg.ProxyProtocol, "proxy-protocol", false, "Expect a PROXY protocol v2 header on every gRPC, frame and Connect connection and use the client address in it")
+	proxyProtocolFrom := flag.String("proxy-protocol-from", "", "Comma separated addresses or networks of the load balancers allowed to send PROXY protocol headers, required with -proxy-protocol")
+	flag.IntVar(&cfg.HandshakesPerIP, "handshakes-per-ip", 0, "Maximum new connections per second per client IP, further ones are closed before the handshake (0 is unlimited)")
+	flag.StringVar(&cfg.IPAccessFile, "ip-access", "", "YAML file of CIDR allow and deny lists checked on accept and on every call (reloaded on SIGHUP)")
+	flag.IntVar(&cfg.BanAfter, "ban-after", 0, "Failed authentications from a client IP within -ban-window that ban it (0 disables)")
+	flag.DurationVar(&cfg.BanWindow, "ban-window", time.Minute, "Window failed authentications are counted over")
+	flag.DurationVar(&cfg.BanDuration, "ban-duration", 15*time.Minute, "How long a client IP stays banned")
+	flag.IntVar(&cfg.MaxTags, "max-tags", 32, "Maximum number of tags per reading (0 is unlimited)")
+	flag.IntVar(&cfg.MaxFieldSize, "max-field-size", 256, "Maximum size in bytes of the sensor name and of each tag key and value (0 is unlimited)")
+	flag.IntVar(&cfg.MaxAttachmentSize, "max-attachment-size", 64*1024, "Maximum size in bytes of a reading's attachment (0 rejects attachments)")
+	flag.IntVar(&cfg.AttachmentRateLimit, "attachment-rate-limit", 0, "Rate limit in bytes per second for attachments, charged instead of -rate-limit (0 counts them against -rate-limit)")
+	flag.StringVar(&cfg.AttachmentDir, "attachment-dir", "", "Directory to store attachments in, referenced from the log by ID (empty stores them inline as base64)")
+
+	// Quotas
+	flag.IntVar(&cfg.TenantRateLimit, "tenant-rate-limit", 0, "Per-tenant rate limit in bytes per second (0 disables)")
+	flag.IntVar(&cfg.SensorRateLimit, "sensor-rate-limit", 0, "Per-sensor rate limit in bytes per second (0 disables)")
+	flag.StringVar(&cfg.RedisAddr, "redis-addr", "", "Redis address for quotas shared across sink instances")
+	flag.StringVar(&cfg.RedisKeyPrefix, "redis-key-prefix", "telemetry:ratelimit:", "Key prefix for rate limit buckets in Redis")
+	flag.BoolVar(&cfg.QuotaTrailers, "quota-trailers", true, "Report the remaining quota and its reset time in the trailers of gRPC responses")
 
 	// TLS flags
 	flag.BoolVar(&cfg.UseTLS, "tls", false, "Enable TLS")
 	flag.StringVar(&cfg.CertFile, "cert-file", "", "Path to TLS certificate file")
 	flag.StringVar(&cfg.KeyFile, "key-file", "", "Path to TLS private key file")
 	flag.StringVar(&cfg.CAFile, "ca-file", "", "Path to CA certificate file (for mutual TLS)")
+	flag.StringVar(&cfg.SpiffeSocket, "spiffe-socket", "", "SPIRE Workload API socket (e.g. unix:///run/spire/sockets/agent.sock), enables mTLS with SPIFFE identities")
+	flag.StringVar(&cfg.SpiffeTrustDomain, "spiffe-trust-domain", "", "Only accept clients from this SPIFFE trust domain")
+	authMethods := flag.String("auth", "", "Comma separated ways clients authenticate, tried in order: token (a bearer token of -auth-tokens), mtls (a client certificate, requires -tls with -ca-file or -spiffe-socket) and oidc (a JWT of -oidc-issuer). Empty requires a client certificate with mTLS and nothing otherwise")
+	flag.StringVar(&cfg.AuthTokensFile, "auth-tokens", "", "YAML file of the names and SHA-256 hashes of the bearer tokens of -auth=token (reloaded on SIGHUP)")
+	flag.StringVar(&cfg.OIDCIssuer, "oidc-issuer", "", "OpenID Connect provider issuing the JWTs of -auth=oidc; tokens must carry it as iss, and its signing keys are discovered from <issuer>/.well-known/openid-configuration")
+	flag.StringVar(&cfg.OIDCAudience, "oidc-audience", "", "Audience JWTs must name in aud for -auth=oidc")
+	flag.StringVar(&cfg.OIDCJWKSURL, "oidc-jwks-url", "", "URL of the provider's signing keys (JWKS), instead of the one of its discovery document")
+	flag.StringVar(&cfg.OIDCSubjectClaim, "oidc-subject-claim", "sub", "JWT claim naming the client, e.g. client_id or azp for tokens of the client credentials flow")
+	flag.DurationVar(&cfg.OIDCKeyRefresh, "oidc-key-refresh", time.Hour, "Age after which the provider's signing keys are fetched again; keys unknown to the sink are fetched at most once a minute")
+	flag.StringVar(&cfg.AuthzPolicyFile, "authz-policy", "", "Path to YAML authorization policy for authenticated clients")
+
+	// Processing pipeline
+	flag.StringVar(&cfg.PipelineFile, "pipeline", "", "YAML file describing the processing stages applied before storage (reloaded on SIGHUP)")
+	flag.StringVar(&cfg.RoutesFile, "routes", "", "YAML file of rules selecting the entries each -output stores by sensor, tags and value; outputs without rules store every entry (reloaded on SIGHUP)")
+
+	// Admin and liveness
+	flag.StringVar(&cfg.AdminAddr, "admin-addr", "", "Admin HTTP address serving /metrics and /sensors (empty disables)")
+	flag.StringVar(&cfg.ConnectAddr, "connect-addr", "", "HTTP address serving the TelemetryService over Connect, gRPC-Web and gRPC; uses the -tls settings (empty disables)")
+	connectOrigins := flag.String("connect-origins", "", "Comma separated browser origins allowed to call -connect-addr, * allowing any (empty allows only calls without an Origin header)")
+	flag.StringVar(&cfg.FrameAddr, "frame-addr", "", "TCP address accepting readings as length-framed protobuf, for devices without gRPC; uses the -tls settings (empty disables)")
+	flag.DurationVar(&cfg.FrameIdleTimeout, "frame-idle-timeout", 5*time.Minute, "Close frame connections idle for this long")
+	flag.StringVar(&cfg.CoAPAddr, "coap-addr", "", "UDP address of a CoAP endpoint accepting CBOR or protobuf readings at /readings; unencrypted and unauthenticated (empty disables)")
+	flag.DurationVar(&cfg.HeartbeatInterval, "heartbeat-interval", 30*time.Second, "Heartbeat interval suggested to registering sensors")
+	flag.DurationVar(&cfg.SensorSilentAfter, "sensor-silent-after", 2*time.Minute, "Time without readings or heartbeats after which a sensor is reported silent (0 disables)")
+
+	// Per-sensor state limits
+	flag.IntVar(&cfg.MaxTrackedSensors, "max-tracked-sensors", state.DefaultMaxEntries, "Maximum sensors kept in per-sensor state such as liveness and quota buckets; the least recently seen are evicted (0 is unlimited)")
+	flag.DurationVar(&cfg.SensorStateTTL, "sensor-state-ttl", 24*time.Hour, "Time after which state of a sensor that stopped reporting is dropped (0 keeps it forever)")
+
+	// Multi-value readings
+	flag.StringVar(&cfg.MultiValueMode, "multi-value-mode", config.MultiValueSplit, "How readings with several named values are stored: split (one entry per value) or combined (one entry with all values)")
+
+	// Clock skew
+	flag.DurationVar(&cfg.MaxClockSkew, "max-clock-skew", 0, "Flag entries whose device time differs from the receive time by more than this (0 disables)")
+	flag.StringVar(&cfg.ClockSkewAction, "clock-skew-action", config.ClockSkewFlag, "What to do with skewed entries: flag (tag with clock_skew) or rewrite (also replace the device time)")
+
+	// Ingest latency
+	flag.DurationVar(&cfg.MaxIngestLatency, "max-ingest-latency", 0, "Tag readings received more than this after their device time with ingest_latency and count them per sensor (0 disables)")
+
+	// Log segments and compaction
+	flag.Int64Var(&cfg.LogSegmentSize, "log-segment-size", 0, "Rotate -log-file into segments of about this many bytes (0 never rotates)")
+	flag.DurationVar(&cfg.CompactInterval, "compact-interval", 0, "Compact rotated log segments this often: drop entries past -log-retention, recompress with zstd and merge small segments (0 disables)")
+	flag.DurationVar(&cfg.LogRetention, "log-retention", 0, "Drop entries received longer ago than this when compacting (0 keeps them)")
+
+	// Replication
+	flag.StringVar(&cfg.ReplicaAddr, "replica-addr", "", "Standby sink address (host:port), started with -accept-replication, that accepted entries are replicated to using the -tls settings (empty disables)")
+	flag.StringVar(&cfg.ReplicaAck, "replica-ack", config.ReplicaAckPrimary, "When readings are acknowledged: primary (once buffered here, replicated asynchronously) or both (once the standby has applied them too)")
+	flag.DurationVar(&cfg.ReplicaTimeout, "replica-timeout", 5*time.Second, "Timeout of each call to the standby, and of the wait for it with -replica-ack=both")
+	flag.StringVar(&cfg.ReplicaJournalDir, "replica-journal-dir", "", "Directory of the journal of entries the standby has yet to acknowledge (default: -log-file with a .journal suffix)")
+	flag.Int64Var(&cfg.ReplicaJournalQuota, "replica-journal-quota", 1<<30, "Bytes the journal may hold while the standby is behind; entries beyond it aren't replicated")
+	flag.BoolVar(&cfg.AcceptReplication, "accept-replication", false, "Apply entries replicated by a primary sink's -replica-addr")
+	replicationPeers := flag.String("replication-peer", "", "Comma separated certificate identities (SPIFFE ID, or the subject's common name) of the primaries allowed to replicate with -accept-replication")
+
+	// Leader election
+	flag.StringVar(&cfg.LeaderLockFile, "leader-lock-file", "", "Lock file on storage shared with the other sink of an active/standby pair; the sink holding the lock accepts readings and the other refuses them (empty disables)")
+	flag.StringVar(&cfg.AdvertiseAddr, "advertise-addr", "", "Address (host:port) clients reach this sink at, recorded in -leader-lock-file while it leads")
+	flag.DurationVar(&cfg.LeaderPollInterval, "leader-poll-interval", time.Second, "How often the standby tries to take over the lock")
+	redirectTo := flag.String("redirect-to", "", "Comma separated sink addresses (host:port) sensor nodes are asked to move their traffic to, e.g. while draining this sink (empty keeps them)")
+
+	// Dead letters
+	flag.StringVar(&cfg.DeadLetterFile, "dead-letter-file", "", "Path to file recording rejected messages with the rejection reason (empty disables)")
+	flag.Int64Var(&cfg.DeadLetterMaxSize, "dead-letter-max-size", 100*1024*1024, "Dead-letter file size in bytes before rotation (0 disables rotation)")
+
+	// Audit log
+	flag.StringVar(&cfg.AuditLogFile, "audit-log", "", "Path to append-only audit log (empty disables)")
+	flag.Int64Var(&cfg.AuditMaxSize, "audit-max-size", 10*1024*1024, "Audit log size in bytes before rotation (0 disables rotation)")
+	flag.StringVar(&cfg.AuditSigningKeyFile, "audit-signing-key-file", "", "Path to base64 encoded HMAC key for signing audit records")
 
 	// Encryption
-	flag.BoolVar(&cfg.EnableEncryption, "encrypt", false, "Enable AES-GCM encryption for log data")
-	flag.StringVar(&cfg.EncryptionKey, "encryption-key", "", "Base64 encoded 32-byte encryption key")
+	flag.BoolVar(&cfg.EnableEncryption, "encrypt", false, "Enable encryption for log data")
+	flag.StringVar(&cfg.EncryptionKey, "encryption-key", "", "Base64 encoded 32-byte encryption key; visible to other users in the process list, so requires -allow-insecure-key-flag (prefer -encryption-key-file or ENCRYPTION_KEY)")
+	flag.StringVar(&cfg.EncryptionKeyFile, "encryption-key-file", "", "Path to the base64 encoded 32-byte encryption key")
+	allowInsecureKeyFlag := flag.Bool("allow-insecure-key-flag", false, "Accept the encryption key from -encryption-key")
+	flag.StringVar(&cfg.EncryptionMode, "encryption-mode", config.EncryptionModeEntry, "Encryption granularity: entry (each line encrypted) or segment (compressed 64KB chunks, far smaller)")
+	flag.StringVar(&cfg.EncryptionCipher, "encryption-cipher", encryption.CipherAESGCM, "Cipher for log encryption: aes-gcm, chacha20poly1305 or xchacha20 (faster without AES hardware)")
 
 	if addr := os.Getenv("BIND_ADDR"); addr != "" {
 		cfg.BindAddr = addr
@@ -92,7 +384,327 @@ func parseFlags() (config.Config, error) {
 		}
 	}
 
+	if redisAddr := os.Getenv("REDIS_ADDR"); redisAddr != "" {
+		cfg.RedisAddr = redisAddr
+	}
+	cfg.RedisPassword = os.Getenv("REDIS_PASSWORD")
+	cfg.EncryptionKey = os.Getenv("ENCRYPTION_KEY")
+	// Child processes shouldn't inherit the key.
+	os.Unsetenv("ENCRYPTION_KEY")
+	cfg.InfluxToken = os.Getenv("INFLUX_TOKEN")
+	cfg.ClickHousePassword = os.Getenv("CLICKHOUSE_PASSWORD")
+	cfg.RemoteWriteToken = os.Getenv("REMOTE_WRITE_TOKEN")
+
 	flag.Parse()
 
+	cfg.UnixSocketMode = os.FileMode(*socketMode)
+	cfg.MaxConcurrentStreams = uint32(*maxConcurrentStreams)
+	cfg.StreamWorkers = uint32(*streamWorkers)
+
+	if cfg.MaxBufferAge < 0 {
+		return cfg, fmt.Errorf("-max-buffer-age can't be negative")
+	}
+	switch cfg.FlushPolicy {
+	case config.FlushPolicyInterval:
+		if cfg.FlushTargetSize != 0 {
+			return cfg, fmt.Errorf("-flush-target-size requires -flush-policy=%s", config.FlushPolicyAdaptive)
+		}
+	case config.FlushPolicyAdaptive:
+		if cfg.FlushTargetSize < 0 || cfg.FlushTargetSize > cfg.BufferSize {
+			return cfg, fmt.Errorf("-flush-target-size must be between 0 and -buffer-size (%d)", cfg.BufferSize)
+		}
+	default:
+		return cfg, fmt.Errorf("invalid -flush-policy %q, want %s or %s", cfg.FlushPolicy, config.FlushPolicyInterval, config.FlushPolicyAdaptive)
+	}
+	if cfg.MaxIngestLatency < 0 {
+		return cfg, fmt.Errorf("-max-ingest-latency can't be negative")
+	}
+	if cfg.LogSegmentSize < 0 || cfg.CompactInterval < 0 || cfg.LogRetention < 0 {
+		return cfg, fmt.Errorf("-log-segment-size, -compact-interval and -log-retention can't be negative")
+	}
+	if cfg.CompactInterval > 0 && cfg.LogSegmentSize == 0 {
+		return cfg, fmt.Errorf("-compact-interval requires -log-segment-size, as only rotated segments are compacted")
+	}
+	if cfg.LogRetention > 0 && cfg.CompactInterval == 0 {
+		return cfg, fmt.Errorf("-log-retention requires -compact-interval")
+	}
+	if cfg.ClockSkewAction != config.ClockSkewFlag && cfg.ClockSkewAction != config.ClockSkewRewrite {
+		return cfg, fmt.Errorf("invalid -clock-skew-action %q, want %s or %s", cfg.ClockSkewAction, config.ClockSkewFlag, config.ClockSkewRewrite)
+	}
+
+	if cfg.MultiValueMode != config.MultiValueSplit && cfg.MultiValueMode != config.MultiValueCombined {
+		return cfg, fmt.Errorf("invalid -multi-value-mode %q, want %s or %s", cfg.MultiValueMode, config.MultiValueSplit, config.MultiValueCombined)
+	}
+
+	for _, method := range strings.Split(*authMethods, ",") {
+		switch method = strings.TrimSpace(method); method {
+		case "":
+		case auth.MethodToken, auth.MethodMTLS, auth.MethodOIDC:
+			if slices.Contains(cfg.AuthMethods, method) {
+				return cfg, fmt.Errorf("-auth lists %s twice", method)
+			}
+			cfg.AuthMethods = append(cfg.AuthMethods, method)
+		default:
+			return cfg, fmt.Errorf("invalid -auth entry %q, want %s, %s or %s", method, auth.MethodToken, auth.MethodMTLS, auth.MethodOIDC)
+		}
+	}
+	mtls := (cfg.UseTLS && cfg.CAFile != "") || cfg.SpiffeSocket != ""
+	if (cfg.AuthTokensFile != "") != slices.Contains(cfg.AuthMethods, auth.MethodToken) {
+		return cfg, fmt.Errorf("-auth=%s and -auth-tokens require each other", auth.MethodToken)
+	}
+	if slices.Contains(cfg.AuthMethods, auth.MethodMTLS) && !mtls {
+		return cfg, fmt.Errorf("-auth=%s requires -tls with -ca-file, or -spiffe-socket", auth.MethodMTLS)
+	}
+	if slices.Contains(cfg.AuthMethods, auth.MethodOIDC) {
+		if cfg.OIDCIssuer == "" || cfg.OIDCAudience == "" {
+			return cfg, fmt.Errorf("-auth=%s requires -oidc-issuer and -oidc-audience", auth.MethodOIDC)
+		}
+		if cfg.OIDCKeyRefresh <= 0 {
+			return cfg, fmt.Errorf("-oidc-key-refresh must be positive")
+		}
+	} else if cfg.OIDCIssuer != "" || cfg.OIDCAudience != "" || cfg.OIDCJWKSURL != "" {
+		return cfg, fmt.Errorf("-oidc-issuer, -oidc-audience and -oidc-jwks-url require -auth=%s", auth.MethodOIDC)
+	}
+	if len(cfg.AuthMethods) > 0 {
+		if cfg.CoAPAddr != "" {
+			return cfg, fmt.Errorf("-coap-addr can't be used with -auth, as CoAP requests carry no credentials")
+		}
+		if cfg.FrameAddr != "" && !slices.Contains(cfg.AuthMethods, auth.MethodMTLS) {
+			return cfg, fmt.Errorf("-frame-addr requires -auth to include %s, as framed readings carry no tokens", auth.MethodMTLS)
+		}
+	}
+	if cfg.IPv6Only {
+		for _, addr := range []struct{ flag, value string }{{"-bind-addr", cfg.BindAddr}, {"-frame-addr", cfg.FrameAddr}, {"-connect-addr", cfg.ConnectAddr}, {"-coap-addr", cfg.CoAPAddr}} {
+			if _, unix := listener.UnixSocketPath(addr.value); addr.value == "" || unix {
+				continue
+			}
+			if err := listener.CheckIPv6Only(addr.value); err != nil {
+				return cfg, fmt.Errorf("%s can't be used with -ipv6-only: %w", addr.flag, err)
+			}
+		}
+	}
+	if cfg.CoAPAddr != "" && ((cfg.UseTLS && cfg.CAFile != "") || cfg.SpiffeSocket != "") {
+		return cfg, fmt.Errorf("-coap-addr can't be used with mTLS, as CoAP requests carry no client certificate")
+	}
+	if cfg.AttachmentRateLimit > 0 && cfg.MaxAttachmentSize > cfg.AttachmentRateLimit {
+		return cfg, fmt.Errorf("-max-attachment-size must not exceed -attachment-rate-limit, or the largest attachments are never admitted")
+	}
+
+	if cfg.SensorStateTTL > 0 && cfg.SensorStateTTL <= cfg.SensorSilentAfter {
+		return cfg, fmt.Errorf("-sensor-state-ttl must be longer than -sensor-silent-after, or silent sensors are dropped before they are reported")
+	}
+	if cfg.LogFormat != config.LogFormatText && cfg.LogFormat != config.LogFormatBinary {
+		return cfg, fmt.Errorf("invalid -log-format %q, want %s or %s", cfg.LogFormat, config.LogFormatText, config.LogFormatBinary)
+	}
+	if cfg.EncryptionMode != config.EncryptionModeEntry && cfg.EncryptionMode != config.EncryptionModeSegment {
+		return cfg, fmt.Errorf("invalid -encryption-mode %q, want %s or %s", cfg.EncryptionMode, config.EncryptionModeEntry, config.EncryptionModeSegment)
+	}
+
+	names := strings.Split(cfg.Output, ",")
+	cfg.Output, cfg.FanOut = names[0], names[1:]
+	for i, name := range names {
+		switch name {
+		case config.OutputLog, config.OutputInflux, config.OutputPostgres, config.OutputClickHouse, config.OutputNATS, config.OutputRemoteWrite:
+		default:
+			return cfg, fmt.Errorf("invalid -output %q, want %s, %s, %s, %s, %s or %s", name, config.OutputLog, config.OutputInflux, config.OutputPostgres, config.OutputClickHouse, config.OutputNATS, config.OutputRemoteWrite)
+		}
+		if slices.Contains(names[:i], name) {
+			return cfg, fmt.Errorf("-output lists %s twice", name)
+		}
+	}
+	if cfg.Output != config.OutputLog && (cfg.EnableEncryption || cfg.LogFormat == config.LogFormatBinary) {
+		return cfg, fmt.Errorf("-output=%s can't be combined with -encrypt or -log-format=%s", cfg.Output, config.LogFormatBinary)
+	}
+	if cfg.LogFields, err = logformat.ParseFieldNames(*logFields); err != nil {
+		return cfg, fmt.Errorf("invalid -log-fields: %v", err)
+	}
+	if fields, err := logformat.NewFields(cfg.LogFields, cfg.LogTimeFormat); err != nil {
+		return cfg, fmt.Errorf("invalid -log-fields or -log-time-format: %v", err)
+	} else if fields != nil && cfg.Output != config.OutputLog {
+		return cfg, fmt.Errorf("-log-fields and -log-time-format require -output=%s first", config.OutputLog)
+	}
+	if cfg.LogSegmentSize > 0 && cfg.Output != config.OutputLog {
+		return cfg, fmt.Errorf("-log-segment-size requires -output=%s first", config.OutputLog)
+	}
+	if len(cfg.FanOut) > 0 && cfg.EnableEncryption {
+		return cfg, fmt.Errorf("-encrypt can't be combined with several outputs, which get the entries in the clear")
+	}
+	if hasOutput(cfg, config.OutputInflux) && cfg.InfluxURL == "" && (cfg.Output != config.OutputInflux || hasOutput(cfg, config.OutputLog)) {
+		return cfg, fmt.Errorf("-output=%s writes to -log-file without -influx-url, so it must come first and can't be combined with %s", config.OutputInflux, config.OutputLog)
+	}
+	if hasOutput(cfg, config.OutputInflux) {
+		if cfg.InfluxMeasurement == "" {
+			return cfg, fmt.Errorf("-output=%s requires -influx-measurement", config.OutputInflux)
+		}
+		if cfg.InfluxRetries < 0 {
+			return cfg, fmt.Errorf("-influx-retries must not be negative")
+		}
+	} else if cfg.InfluxURL != "" {
+		return cfg, fmt.Errorf("-influx-url requires -output=%s", config.OutputInflux)
+	}
+	if hasOutput(cfg, config.OutputPostgres) {
+		if cfg.PostgresURL == "" || cfg.PostgresTable == "" {
+			return cfg, fmt.Errorf("-output=%s requires -postgres-url and -postgres-table", config.OutputPostgres)
+		}
+		if cfg.PostgresMaxConns < 1 || cfg.PostgresRetries < 0 {
+			return cfg, fmt.Errorf("-postgres-max-conns must be positive and -postgres-retries not negative")
+		}
+	} else if cfg.PostgresURL != "" {
+		return cfg, fmt.Errorf("-postgres-url requires -output=%s", config.OutputPostgres)
+	}
+	if hasOutput(cfg, config.OutputClickHouse) {
+		if cfg.ClickHouseURL == "" || cfg.ClickHouseTable == "" {
+			return cfg, fmt.Errorf("-output=%s requires -clickhouse-url and -clickhouse-table", config.OutputClickHouse)
+		}
+		if cfg.ClickHouseRetries < 0 {
+			return cfg, fmt.Errorf("-clickhouse-retries must not be negative")
+		}
+	} else if cfg.ClickHouseURL != "" {
+		return cfg, fmt.Errorf("-clickhouse-url requires -output=%s", config.OutputClickHouse)
+	}
+	if hasOutput(cfg, config.OutputRemoteWrite) {
+		if cfg.RemoteWriteURL == "" || cfg.RemoteWriteWALDir == "" {
+			return cfg, fmt.Errorf("-output=%s requires -remote-write-url and -remote-write-wal-dir", config.OutputRemoteWrite)
+		}
+		if cfg.RemoteWriteName == "" || cfg.RemoteWriteWALQuota <= 0 {
+			return cfg, fmt.Errorf("-output=%s requires -remote-write-name and a positive -remote-write-wal-quota", config.OutputRemoteWrite)
+		}
+	} else if cfg.RemoteWriteURL != "" {
+		return cfg, fmt.Errorf("-remote-write-url requires -output=%s", config.OutputRemoteWrite)
+	}
+	if (hasOutput(cfg, config.OutputNATS) || cfg.NATSIngestSubject != "") && cfg.NATSURL == "" {
+		return cfg, fmt.Errorf("-output=%s and -nats-ingest-subject require -nats-url", config.OutputNATS)
+	}
+	if hasOutput(cfg, config.OutputNATS) && cfg.NATSSubject == "" {
+		return cfg, fmt.Errorf("-output=%s requires -nats-subject", config.OutputNATS)
+	}
+	if cfg.NATSRetries < 0 {
+		return cfg, fmt.Errorf("-nats-retries must not be negative")
+	}
+	if cfg.NATSIngestSubject != "" {
+		if cfg.NATSIngestDurable == "" {
+			return cfg, fmt.Errorf("-nats-ingest-subject requires -nats-ingest-durable")
+		}
+		if hasOutput(cfg, config.OutputNATS) && cfg.NATSIngestSubject == cfg.NATSSubject {
+			return cfg, fmt.Errorf("-nats-ingest-subject must differ from -nats-subject, or entries are ingested again")
+		}
+		if (cfg.UseTLS && cfg.CAFile != "") || cfg.SpiffeSocket != "" {
+			return cfg, fmt.Errorf("-nats-ingest-subject can't be used with mTLS, as NATS messages carry no client certificate")
+		}
+	}
+
+	switch cfg.OverloadPolicy {
+	case config.OverloadReject, config.OverloadDropOldest, config.OverloadSample:
+	default:
+		return cfg, fmt.Errorf("invalid -overload-policy %q, want %s, %s or %s", cfg.OverloadPolicy, config.OverloadReject, config.OverloadDropOldest, config.OverloadSample)
+	}
+	if cfg.OverloadSampleRate < 1 {
+		return cfg, fmt.Errorf("-overload-sample-rate must be at least 1")
+	}
+	if cfg.SpillDir != "" && cfg.SpillQuota <= 0 {
+		return cfg, fmt.Errorf("-spill-quota must be positive with -spill-dir")
+	}
+
+	if cfg.ReplicaAddr != "" || cfg.AcceptReplication {
+		if cfg.Output != config.OutputLog {
+			return cfg, fmt.Errorf("-replica-addr and -accept-replication require -output=%s first", config.OutputLog)
+		}
+		if cfg.ReplicaAck != config.ReplicaAckPrimary && cfg.ReplicaAck != config.ReplicaAckBoth {
+			return cfg, fmt.Errorf("invalid -replica-ack %q, want %s or %s", cfg.ReplicaAck, config.ReplicaAckPrimary, config.ReplicaAckBoth)
+		}
+		if cfg.ReplicaTimeout <= 0 || cfg.ReplicaJournalQuota < replication.SegmentSize {
+			return cfg, fmt.Errorf("-replica-timeout must be positive and -replica-journal-quota at least %d", replication.SegmentSize)
+		}
+	}
+	for _, peer := range strings.Split(*replicationPeers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			cfg.ReplicationPeers = append(cfg.ReplicationPeers, peer)
+		}
+	}
+	if cfg.AcceptReplication {
+		// Primaries authenticate with their certificate, whatever -auth is set.
+		if len(cfg.ReplicationPeers) == 0 {
+			return cfg, fmt.Errorf("-accept-replication requires -replication-peer")
+		}
+		if !mtls {
+			return cfg, fmt.Errorf("-accept-replication requires mTLS (-tls and -ca-file, or -spiffe-socket)")
+		}
+	} else if len(cfg.ReplicationPeers) > 0 {
+		return cfg, fmt.Errorf("-replication-peer requires -accept-replication")
+	}
+
+	if cfg.LeaderLockFile != "" {
+		if cfg.AdvertiseAddr == "" {
+			return cfg, fmt.Errorf("-leader-lock-file requires -advertise-addr")
+		}
+		if cfg.LeaderPollInterval <= 0 {
+			return cfg, fmt.Errorf("-leader-poll-interval must be positive")
+		}
+	}
+
+	if cfg.SelfTelemetryInterval > 0 && cfg.SelfTelemetrySensor == "" {
+		return cfg, fmt.Errorf("-self-telemetry-interval requires -self-telemetry-sensor")
+	}
+	if cfg.SelfTelemetryUpstream != "" && cfg.SelfTelemetryInterval == 0 {
+		return cfg, fmt.Errorf("-self-telemetry-upstream requires -self-telemetry-interval")
+	}
+
+	keyFlagSet := false
+	flag.Visit(func(f *flag.Flag) { keyFlagSet = keyFlagSet || f.Name == "encryption-key" })
+	if keyFlagSet && !*allowInsecureKeyFlag {
+		return cfg, fmt.Errorf("-encryption-key exposes the key in the process list and shell history; use -encryption-key-file or ENCRYPTION_KEY, or set -allow-insecure-key-flag")
+	}
+	if cfg.EncryptionKeyFile != "" && cfg.EncryptionKey != "" {
+		return cfg, fmt.Errorf("-encryption-key-file can't be combined with -encryption-key or ENCRYPTION_KEY")
+	}
+	cfg.EncryptionKeyFromEnv = cfg.EnableEncryption && cfg.EncryptionKey != "" && !keyFlagSet
+
+	for _, action := range strings.Split(*watchdogActions, ",") {
+		switch action = strings.TrimSpace(action); action {
+		case "":
+		case config.WatchdogShed, config.WatchdogFlush, config.WatchdogDump:
+			cfg.WatchdogActions = append(cfg.WatchdogActions, action)
+		default:
+			return cfg, fmt.Errorf("invalid -watchdog-actions entry %q, want %s, %s or %s", action, config.WatchdogShed, config.WatchdogFlush, config.WatchdogDump)
+		}
+	}
+	if cfg.RedirectTo, err = grpcserver.ParseEndpoints(*redirectTo); err != nil {
+		return cfg, fmt.Errorf("invalid -redirect-to: %v", err)
+	}
+	for _, origin := range strings.Split(*connectOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.ConnectOrigins = append(cfg.ConnectOrigins, origin)
+		}
+	}
+	for _, network := range strings.Split(*proxyProtocolFrom, ",") {
+		if network = strings.TrimSpace(network); network == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				return cfg, fmt.Errorf("invalid -proxy-protocol-from entry %q: %v", network, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		cfg.ProxyProtocolFrom = append(cfg.ProxyProtocolFrom, prefix.Masked())
+	}
+	if cfg.ProxyProtocol && len(cfg.ProxyProtocolFrom) == 0 {
+		return cfg, fmt.Errorf("-proxy-protocol requires -proxy-protocol-from, or any client could forge its address")
+	}
+	if len(cfg.ProxyProtocolFrom) > 0 && !cfg.ProxyProtocol {
+		return cfg, fmt.Errorf("-proxy-protocol-from requires -proxy-protocol")
+	}
+	if len(cfg.ConnectOrigins) > 0 && cfg.ConnectAddr == "" {
+		return cfg, fmt.Errorf("-connect-origins requires -connect-addr")
+	}
+	if cfg.BanAfter > 0 && (cfg.BanWindow <= 0 || cfg.BanDuration <= 0) {
+		return cfg, fmt.Errorf("-ban-window and -ban-duration must be positive")
+	}
+	if slices.Contains(cfg.WatchdogActions, config.WatchdogDump) && cfg.WatchdogDumpDir == "" {
+		return cfg, fmt.Errorf("-watchdog-actions=%s requires -watchdog-dump-dir", config.WatchdogDump)
+	}
+
 	return cfg, nil
 }
@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const namespace = "telemetry"
+
+// latencyBuckets spans 1µs to about 4s, as stages range from map lookups to disk writes.
+var latencyBuckets = prometheus.ExponentialBuckets(1e-6, 4, 12)
+
+// Process-wide sink metrics. They are registered with every registry created by
+// NewRegistry.
+var (
+	EntriesReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entries_received_total",
+		Help:      "Readings accepted and buffered for storage.",
+	})
+	EntriesRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entries_rejected_total",
+		Help:      "Readings and events rejected after authentication, by reason.",
+	}, []string{"reason"})
+	EntriesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entries_dropped_total",
+		Help:      "Entries discarded by the overload policy while the buffer and write queue were full, by reason: oldest or sampled.",
+	}, []string{"reason"})
+	FanOutEntriesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fanout_entries_dropped_total",
+		Help:      "Entries an additional output discarded because its write queue was full, by output.",
+	}, []string{"output"})
+	EventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Events accepted and buffered for storage, by severity.",
+	}, []string{"severity"})
+	AttachmentBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "attachment_bytes_total",
+		Help:      "Bytes of reading attachments accepted, inline or in the attachment directory.",
+	})
+	Heartbeats = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "heartbeats_total",
+		Help:      "Sensor heartbeats received.",
+	})
+	RequestsCanceled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_canceled_total",
+		Help:      "Readings abandoned because the client canceled or its deadline passed, by the stage reached.",
+	}, []string{"stage"})
+	ReceiveDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "receive_duration_seconds",
+		Help:      "Time from a decoded reading or event to the sink's response, for every outcome.",
+		Buckets:   latencyBuckets,
+	})
+	StageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Time readings spend in each stage: unmarshal, rate_limit, process, encode, encrypt, buffer, replicate, and for buffers flush_wait and write.",
+		Buckets:   latencyBuckets,
+	}, []string{"stage"})
+	SpillBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "spill_bytes",
+		Help:      "Bytes of buffers spilled to disk while the writer is behind, waiting to be written.",
+	})
+	RemoteWriteWALBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "remote_write_wal_bytes",
+		Help:      "Bytes of remote_write requests in the write-ahead log, waiting to be delivered.",
+	})
+	WatchdogViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watchdog_violations_total",
+		Help:      "Watchdog checks that found a threshold crossed, by check (buffer, queue, flush_latency, goroutines).",
+	}, []string{"check"})
+	Overloaded = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "overloaded",
+		Help:      "1 while the watchdog sheds non-critical readings, 0 otherwise.",
+	})
+	Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "1 while this sink holds the leader lock of its active/standby pair, 0 otherwise.",
+	})
+	Panics = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panics_recovered_total",
+		Help:      "Request handlers that panicked and were answered with an Internal error.",
+	})
+	GoroutinePanics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "goroutine_panics_recovered_total",
+		Help:      "Panics recovered in background goroutines, which were restarted, by goroutine.",
+	}, []string{"goroutine"})
+	ConnectionsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "connections_rejected_total",
+		Help:      "Connections closed on accept, by reason: ip_access, banned or handshake_rate.",
+	}, []string{"reason"})
+	ClientsBanned = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "clients_banned_total",
+		Help:      "Client IPs banned for failing authentication too often.",
+	})
+	CompactionReclaimedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "compaction_reclaimed_bytes_total",
+		Help:      "Bytes of log segments freed by compaction, less the bytes of the segments it wrote.",
+	})
+	CompactionExpiredEntries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "compaction_expired_entries_total",
+		Help:      "Log entries dropped by compaction as older than the retention.",
+	})
+	CompactionFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "compaction_failures_total",
+		Help:      "Log compactions that failed and were retried at the next interval.",
+	})
+	ReplicationJournalBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "replication_journal_bytes",
+		Help:      "Bytes in the replication journal, of batches the standby has yet to acknowledge.",
+	})
+	ReplicationLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "replication_lag_batches",
+		Help:      "Batches of accepted entries journaled but not yet acknowledged by the standby.",
+	})
+	ReplicationEntriesDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "replication_entries_dropped_total",
+		Help:      "Accepted entries not replicated because the replication journal was full.",
+	})
+	ReplicationEntriesApplied = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "replication_entries_applied_total",
+		Help:      "Entries replicated by a primary sink and buffered by this standby.",
+	})
+	StateEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "state_entries",
+		Help:      "Entries held in per-sensor state tables, by table.",
+	}, []string{"table"})
+	StateEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "state_evictions_total",
+		Help:      "Entries evicted from per-sensor state tables, by table and reason (idle or cap).",
+	}, []string{"table", "reason"})
+)
+
+// NewRegistry returns a registry with the process-wide metrics, Go runtime and
+// process metrics, and any extra collectors.
+func NewRegistry(extra ...prometheus.Collector) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		EntriesReceived,
+		EntriesRejected,
+		EntriesDropped,
+		FanOutEntriesDropped,
+		EventsReceived,
+		AttachmentBytes,
+		Heartbeats,
+		RequestsCanceled,
+		ReceiveDuration,
+		StageDuration,
+		SpillBytes,
+		RemoteWriteWALBytes,
+		WatchdogViolations,
+		Overloaded,
+		Leader,
+		Panics,
+		GoroutinePanics,
+		ConnectionsRejected,
+		ClientsBanned,
+		CompactionReclaimedBytes,
+		CompactionExpiredEntries,
+		CompactionFailures,
+		ReplicationJournalBytes,
+		ReplicationLag,
+		ReplicationEntriesDropped,
+		ReplicationEntriesApplied,
+		StateEntries,
+		StateEvictions,
+	)
+	reg.MustRegister(extra...)
+	return reg
+}
+
+// Total returns the sum of a collector's counter and gauge values across all labels.
+func Total(c prometheus.Collector) float64 {
+	var total float64
+	for _, m := range collect(c) {
+		total += m.GetCounter().GetValue() + m.GetGauge().GetValue()
+	}
+	return total
+}
+
+// Observed returns the number and sum of the observations of a collector's
+// histograms across all labels.
+func Observed(c prometheus.Collector) (count uint64, sum float64) {
+	for _, m := range collect(c) {
+		count += m.GetHistogram().GetSampleCount()
+		sum += m.GetHistogram().GetSampleSum()
+	}
+	return count, sum
+}
+
+func collect(c prometheus.Collector) []*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var metrics []*dto.Metric
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err == nil {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
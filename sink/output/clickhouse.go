@@ -0,0 +1,208 @@
+package output
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// clickHouseTimeLayout is a DateTime64(9) in ClickHouse's basic input format.
+const clickHouseTimeLayout = "2006-01-02 15:04:05.000000000"
+
+// ClickHouse inserts buffers of JSON lines, as written to the log, into a ClickHouse
+// table through its HTTP interface, as the rows decodeRows makes of them. Inserts
+// are asynchronous, so the server merges the batches of many sinks into fewer parts.
+// It is the destination of a storage.FileWriter, so a buffer is a batch.
+type ClickHouse struct {
+	endpoint string // with the insert query and its settings
+	user     string
+	password string
+	retries  int
+	backoff  time.Duration // before the first retry, doubled for each one after
+	client   *http.Client
+}
+
+// ClickHouseOptions configures a ClickHouse output.
+type ClickHouseOptions struct {
+	URL      string // HTTP interface, e.g. http://clickhouse:8123/?database=metrics, may carry settings
+	User     string
+	Password string
+	Table    string // optionally database qualified
+
+	// AsyncInsert has the server buffer inserts and write them in its own batches;
+	// WaitForAsyncInsert holds the response until they are written, so an
+	// acknowledged batch is stored.
+	AsyncInsert        bool
+	WaitForAsyncInsert bool
+
+	Timeout time.Duration // per request
+	Retries int           // retries of a failed batch
+}
+
+// OpenClickHouse creates the table if it doesn't exist and returns a destination
+// inserting into it.
+func OpenClickHouse(ctx context.Context, opts ClickHouseOptions) (*ClickHouse, error) {
+	base, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parse ClickHouse URL: %w", err)
+	}
+	table := quoteClickHouseTable(opts.Table)
+
+	c := &ClickHouse{
+		user:     opts.User,
+		password: opts.Password,
+		retries:  opts.Retries,
+		backoff:  time.Second,
+		client:   &http.Client{Timeout: opts.Timeout},
+	}
+
+	if err := c.exec(ctx, base.String(), `CREATE TABLE IF NOT EXISTS `+table+` (
+	ts     DateTime64(9, 'UTC'),
+	sensor LowCardinality(String),
+	metric LowCardinality(String),
+	value  Nullable(Float64),
+	tags   Map(LowCardinality(String), String)
+) ENGINE = MergeTree
+PARTITION BY toDate(ts)
+ORDER BY (sensor, metric, ts)`); err != nil {
+		return nil, fmt.Errorf("create table %s: %w", opts.Table, err)
+	}
+
+	query := base.Query()
+	query.Set("query", "INSERT INTO "+table+" (ts, sensor, metric, value, tags) FORMAT JSONEachRow")
+	if opts.AsyncInsert {
+		query.Set("async_insert", "1")
+		if opts.WaitForAsyncInsert {
+			query.Set("wait_for_async_insert", "1")
+		} else {
+			query.Set("wait_for_async_insert", "0")
+		}
+	}
+	base.RawQuery = query.Encode()
+	c.endpoint = base.String()
+
+	return c, nil
+}
+
+// quoteClickHouseTable quotes each part of a possibly database qualified name.
+func quoteClickHouseTable(table string) string {
+	parts := strings.Split(table, ".")
+	for i, part := range parts {
+		part = strings.ReplaceAll(part, `\`, `\\`)
+		parts[i] = "`" + strings.ReplaceAll(part, "`", "\\`") + "`"
+	}
+	return strings.Join(parts, ".")
+}
+
+// exec runs a statement without results.
+func (c *ClickHouse) exec(ctx context.Context, endpoint, statement string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(statement))
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = checkResponse(resp)
+	return err
+}
+
+func (c *ClickHouse) authorize(req *http.Request) {
+	if c.user != "" {
+		req.Header.Set("X-ClickHouse-User", c.user)
+	}
+	if c.password != "" {
+		req.Header.Set("X-ClickHouse-Key", c.password)
+	}
+}
+
+// Write inserts the entries of data, retrying throttling, server and network
+// errors. A batch the server rejects as invalid is not retried.
+func (c *ClickHouse) Write(data []byte) (int, error) {
+	rows, err := decodeRows(data)
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return len(data), nil
+	}
+	body, err := clickHouseBody(rows)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := retry("ClickHouse", c.retries, c.backoff, func() (bool, error) { return c.post(body) }); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// clickHouseBody encodes rows as gzipped JSONEachRow.
+func clickHouseBody(rows []row) ([]byte, error) {
+	type clickHouseRow struct {
+		Time   string            `json:"ts"`
+		Sensor string            `json:"sensor"`
+		Metric string            `json:"metric"`
+		Value  *float64          `json:"value"`
+		Tags   map[string]string `json:"tags"`
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, r := range rows {
+		if err := enc.Encode(clickHouseRow{
+			Time:   r.Time.UTC().Format(clickHouseTimeLayout),
+			Sensor: r.Sensor,
+			Metric: r.Metric,
+			Value:  r.Value,
+			Tags:   r.Tags,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// post sends one insert and reports whether a failure is worth retrying.
+func (c *ClickHouse) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	c.authorize(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp)
+}
+
+// Sync returns nil: a batch is stored once Write returns, or queued on the server
+// without WaitForAsyncInsert.
+func (c *ClickHouse) Sync() error {
+	return nil
+}
+
+func (c *ClickHouse) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
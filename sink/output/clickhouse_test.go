@@ -0,0 +1,113 @@
+package output
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClickHouse(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		statements []string
+		inserted   string
+		settings   string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if got := r.Header.Get("X-ClickHouse-User"); got != "sink" {
+			t.Errorf("X-ClickHouse-User = %q, want %q", got, "sink")
+		}
+		if got := r.Header.Get("X-ClickHouse-Key"); got != "secret" {
+			t.Errorf("X-ClickHouse-Key = %q, want %q", got, "secret")
+		}
+		query := r.URL.Query()
+		if got := query.Get("database"); got != "metrics" {
+			t.Errorf("database = %q, want %q", got, "metrics")
+		}
+		if !query.Has("query") {
+			body, _ := io.ReadAll(r.Body)
+			statements = append(statements, string(body))
+			return
+		}
+
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Content-Encoding = %q, want gzip", r.Header.Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("gzip.NewReader() error = %v", err)
+			return
+		}
+		body, _ := io.ReadAll(zr)
+		inserted += string(body)
+		statements = append(statements, query.Get("query"))
+		settings = "async_insert=" + query.Get("async_insert") + " wait_for_async_insert=" + query.Get("wait_for_async_insert")
+	}))
+	defer srv.Close()
+
+	c, err := OpenClickHouse(context.Background(), ClickHouseOptions{
+		URL:                srv.URL + "/?database=metrics",
+		User:               "sink",
+		Password:           "secret",
+		Table:              "metrics.telemetry",
+		AsyncInsert:        true,
+		WaitForAsyncInsert: true,
+		Timeout:            time.Second,
+	})
+	if err != nil {
+		t.Fatalf("OpenClickHouse() error = %v", err)
+	}
+	defer c.Close()
+
+	data := `{"data_time":"2024-05-01T12:00:00.5Z","metric":"humidity","sensor_name":"env-1","sensor_value":40,"tags":{"room":"a"},"timestamp":"2024-05-01T12:00:01Z"}` + "\n" +
+		`{"data_time":"2024-05-01T12:00:00Z","event":{"message":"door open","severity":"warning"},"sensor_name":"door-1","timestamp":"2024-05-01T12:00:01Z","type":"event"}` + "\n"
+	if n, err := c.Write([]byte(data)); err != nil || n != len(data) {
+		t.Fatalf("Write() = %d, %v, want %d, nil", n, err, len(data))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(statements) != 2 {
+		t.Fatalf("statements = %q, want a CREATE TABLE and an INSERT", statements)
+	}
+	if !strings.HasPrefix(statements[0], "CREATE TABLE IF NOT EXISTS `metrics`.`telemetry` (") {
+		t.Errorf("statements[0] = %q, want CREATE TABLE of `metrics`.`telemetry`", statements[0])
+	}
+	if want := "INSERT INTO `metrics`.`telemetry` (ts, sensor, metric, value, tags) FORMAT JSONEachRow"; statements[1] != want {
+		t.Errorf("statements[1] = %q, want %q", statements[1], want)
+	}
+	if want := "async_insert=1 wait_for_async_insert=1"; settings != want {
+		t.Errorf("settings = %q, want %q", settings, want)
+	}
+	want := `{"ts":"2024-05-01 12:00:00.500000000","sensor":"env-1","metric":"humidity","value":40,"tags":{"room":"a"}}` + "\n" +
+		`{"ts":"2024-05-01 12:00:00.000000000","sensor":"door-1","metric":"","value":null,"tags":{"message":"door open","severity":"warning","type":"event"}}` + "\n"
+	if inserted != want {
+		t.Errorf("inserted =\n%s\nwant\n%s", inserted, want)
+	}
+}
+
+func TestQuoteClickHouseTable(t *testing.T) {
+	tests := []struct {
+		table string
+		want  string
+	}{
+		{table: "telemetry", want: "`telemetry`"},
+		{table: "metrics.telemetry", want: "`metrics`.`telemetry`"},
+		{table: "odd`name", want: "`odd\\`name`"},
+	}
+
+	for _, tt := range tests {
+		if got := quoteClickHouseTable(tt.table); got != tt.want {
+			t.Errorf("quoteClickHouseTable(%q) = %s, want %s", tt.table, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Package output sends stored entries to systems other than the sink's log file.
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sink/processor"
+)
+
+// Tags an InfluxDB line sets besides the entry's own, which they take precedence over.
+const (
+	influxSensorTag   = "sensor"
+	influxMetricTag   = "metric"
+	influxPriorityTag = "priority"
+	influxTypeTag     = "type"
+	influxSeverityTag = "severity"
+)
+
+// AppendInfluxLine appends the entry as a point in InfluxDB line protocol, without
+// the trailing newline. The sensor, the metric of a split value, critical priority,
+// an event's severity and the entry's tags become tags; values, aggregates, location,
+// event messages and payloads become fields. The point's time is the device time.
+func AppendInfluxLine(dst []byte, measurement string, e *processor.Entry) ([]byte, error) {
+	dst = appendInfluxEscaped(dst, measurement, ", ")
+
+	tags := map[string]string{influxSensorTag: e.SensorName}
+	for k, v := range e.Tags {
+		if _, reserved := tags[k]; !reserved {
+			tags[k] = v
+		}
+	}
+	if e.Metric != "" {
+		tags[influxMetricTag] = e.Metric
+	}
+	if e.Critical {
+		tags[influxPriorityTag] = "critical"
+	}
+	if e.Event != nil {
+		tags[influxTypeTag] = "event"
+		tags[influxSeverityTag] = e.Event.Severity
+	}
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		// InfluxDB rejects empty tag keys and values.
+		if k == "" || tags[k] == "" {
+			continue
+		}
+		dst = append(dst, ',')
+		dst = appendInfluxEscaped(dst, k, ",= ")
+		dst = append(dst, '=')
+		dst = appendInfluxEscaped(dst, tags[k], ",= ")
+	}
+
+	fields := len(dst)
+	field := func(name string) {
+		if len(dst) == fields {
+			dst = append(dst, ' ')
+		} else {
+			dst = append(dst, ',')
+		}
+		dst = appendInfluxEscaped(dst, name, ",= ")
+		dst = append(dst, '=')
+	}
+	var err error
+	float := func(name string, v float64) {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			err = fmt.Errorf("%s: unsupported value %v", name, v)
+			return
+		}
+		field(name)
+		dst = strconv.AppendFloat(dst, v, 'g', -1, 64)
+	}
+	str := func(name, v string) {
+		field(name)
+		dst = append(dst, '"')
+		for i := 0; i < len(v); i++ {
+			switch c := v[i]; c {
+			case '"', '\\':
+				dst = append(dst, '\\', c)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			default:
+				dst = append(dst, c)
+			}
+		}
+		dst = append(dst, '"')
+	}
+
+	switch {
+	case e.Sealed != nil:
+		str("sealed_payload", base64.StdEncoding.EncodeToString(e.Sealed))
+	case e.Event != nil:
+		str("message", e.Event.Message)
+	case e.Aggregate != nil:
+		field("count")
+		dst = strconv.AppendUint(dst, e.Aggregate.Count, 10)
+		dst = append(dst, 'i')
+		float("sum", e.Aggregate.Sum)
+	case e.Values != nil:
+		for _, name := range slices.Sorted(maps.Keys(e.Values)) {
+			float(name, e.Values[name])
+		}
+	default:
+		float("value", e.SensorValue)
+	}
+	if e.RawValue != nil {
+		float("raw_value", *e.RawValue)
+	}
+	if e.Location != nil {
+		float("latitude", e.Location.Latitude)
+		float("longitude", e.Location.Longitude)
+		if e.Location.Altitude != nil {
+			float("altitude", *e.Location.Altitude)
+		}
+	}
+	if e.AttachmentID != "" {
+		str("attachment_id", e.AttachmentID)
+	} else if e.Attachment != nil {
+		str("attachment", base64.StdEncoding.EncodeToString(e.Attachment))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(dst) == fields {
+		return nil, fmt.Errorf("entry has no fields")
+	}
+
+	ts := e.DataTime
+	if ts.IsZero() {
+		ts = e.Timestamp
+	}
+	dst = append(dst, ' ')
+	dst = strconv.AppendInt(dst, ts.UnixNano(), 10)
+
+	return dst, nil
+}
+
+// appendInfluxEscaped appends s with a backslash before every character in special.
+// Newlines, which line protocol can't carry in names, are replaced with spaces.
+func appendInfluxEscaped(dst []byte, s, special string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\n' {
+			c = ' '
+		}
+		if strings.IndexByte(special, c) >= 0 {
+			dst = append(dst, '\\')
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// InfluxHTTP posts buffers of line protocol to an InfluxDB or Telegraf write
+// endpoint, retrying failed writes with exponential backoff. It is the destination
+// of a storage.FileWriter, so a buffer is a batch.
+type InfluxHTTP struct {
+	url     string
+	token   string
+	retries int
+	backoff time.Duration // before the first retry, doubled for each one after
+	client  *http.Client
+}
+
+// NewInfluxHTTP creates a destination posting to url, the full write URL such as
+// http://influxdb:8086/api/v2/write?org=acme&bucket=telemetry. A non-empty token is
+// sent as "Authorization: Token <token>". Each request may take up to timeout, and a
+// failed batch is retried up to retries times.
+func NewInfluxHTTP(url, token string, timeout time.Duration, retries int) *InfluxHTTP {
+	return &InfluxHTTP{
+		url:     url,
+		token:   token,
+		retries: retries,
+		backoff: time.Second,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Write posts p, retrying server errors, throttling and network errors. Other client
+// errors mean the batch itself is rejected and aren't retried.
+func (h *InfluxHTTP) Write(p []byte) (int, error) {
+	if err := retry("InfluxDB", h.retries, h.backoff, func() (bool, error) { return h.post(p) }); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// post sends one request and reports whether a failure is worth retrying.
+func (h *InfluxHTTP) post(p []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, h.url, bytes.NewReader(p))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Token "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp)
+}
+
+// Sync returns nil: a batch is stored once Write returns.
+func (h *InfluxHTTP) Sync() error {
+	return nil
+}
+
+func (h *InfluxHTTP) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
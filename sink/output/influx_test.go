@@ -0,0 +1,185 @@
+package output
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sink/processor"
+)
+
+func TestAppendInfluxLine(t *testing.T) {
+	dataTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	altitude := 120.5
+	raw := 2.5
+
+	tests := []struct {
+		name  string
+		entry processor.Entry
+		want  string
+	}{
+		{
+			name:  "value",
+			entry: processor.Entry{SensorName: "temp-1", SensorValue: 21.5, DataTime: dataTime},
+			want:  "telemetry,sensor=temp-1 value=21.5 1714564800000000000",
+		},
+		{
+			name: "tags sorted, escaped and empty skipped",
+			entry: processor.Entry{
+				SensorName:  "room 1,a",
+				SensorValue: 1,
+				Tags:        map[string]string{"zone": "b=2", "building": "north", "empty": ""},
+				DataTime:    dataTime,
+			},
+			want: `telemetry,building=north,sensor=room\ 1\,a,zone=b\=2 value=1 1714564800000000000`,
+		},
+		{
+			name: "split value with raw value and critical priority",
+			entry: processor.Entry{
+				SensorName:  "env-1",
+				SensorValue: 40,
+				Metric:      "humidity",
+				RawValue:    &raw,
+				Critical:    true,
+				DataTime:    dataTime,
+			},
+			want: "telemetry,metric=humidity,priority=critical,sensor=env-1 value=40,raw_value=2.5 1714564800000000000",
+		},
+		{
+			name: "entry tags don't replace the sensor",
+			entry: processor.Entry{
+				SensorName:  "env-1",
+				SensorValue: 1,
+				Tags:        map[string]string{"sensor": "other"},
+				DataTime:    dataTime,
+			},
+			want: "telemetry,sensor=env-1 value=1 1714564800000000000",
+		},
+		{
+			name: "combined values",
+			entry: processor.Entry{
+				SensorName: "env-1",
+				Values:     map[string]float64{"temperature": 21, "humidity": 40},
+				DataTime:   dataTime,
+			},
+			want: "telemetry,sensor=env-1 humidity=40,temperature=21 1714564800000000000",
+		},
+		{
+			name: "aggregate",
+			entry: processor.Entry{
+				SensorName: "flow-1",
+				Aggregate:  &processor.Aggregate{Count: 10, Sum: 55},
+				DataTime:   dataTime,
+			},
+			want: "telemetry,sensor=flow-1 count=10i,sum=55 1714564800000000000",
+		},
+		{
+			name: "event",
+			entry: processor.Entry{
+				SensorName: "door-1",
+				Event:      &processor.Event{Severity: "warning", Message: `left "open"` + "\nagain"},
+				DataTime:   dataTime,
+			},
+			want: `telemetry,sensor=door-1,severity=warning,type=event message="left \"open\"\nagain" 1714564800000000000`,
+		},
+		{
+			name: "location and attachment",
+			entry: processor.Entry{
+				SensorName:   "truck-1",
+				SensorValue:  3,
+				Location:     &processor.Location{Latitude: 50.45, Longitude: 30.52, Altitude: &altitude},
+				AttachmentID: "abc",
+				DataTime:     dataTime,
+			},
+			want: `telemetry,sensor=truck-1 value=3,latitude=50.45,longitude=30.52,altitude=120.5,attachment_id="abc" 1714564800000000000`,
+		},
+		{
+			name:  "receive time without device time",
+			entry: processor.Entry{SensorName: "temp-1", SensorValue: 1, Timestamp: dataTime},
+			want:  "telemetry,sensor=temp-1 value=1 1714564800000000000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AppendInfluxLine(nil, "telemetry", &tt.entry)
+			if err != nil {
+				t.Fatalf("AppendInfluxLine() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("AppendInfluxLine() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendInfluxLine_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry processor.Entry
+	}{
+		{name: "NaN", entry: processor.Entry{SensorName: "temp-1", SensorValue: math.NaN()}},
+		{name: "infinity", entry: processor.Entry{SensorName: "temp-1", Values: map[string]float64{"a": math.Inf(1)}}},
+		{name: "no fields", entry: processor.Entry{SensorName: "temp-1", Values: map[string]float64{}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := AppendInfluxLine(nil, "telemetry", &tt.entry); err == nil {
+				t.Error("AppendInfluxLine() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestInfluxHTTP_Write(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int // of consecutive requests
+		retries  int
+		wantErr  bool
+		wantReqs int
+	}{
+		{name: "accepted", statuses: []int{http.StatusNoContent}, retries: 3, wantReqs: 1},
+		{name: "retried server error", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent}, retries: 3, wantReqs: 3},
+		{name: "retries exhausted", statuses: []int{http.StatusInternalServerError, http.StatusInternalServerError}, retries: 1, wantErr: true, wantReqs: 2},
+		{name: "rejected batch not retried", statuses: []int{http.StatusBadRequest}, retries: 3, wantErr: true, wantReqs: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reqs atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(reqs.Add(1)) - 1
+				if got := r.Header.Get("Authorization"); got != "Token secret" {
+					t.Errorf("Authorization = %q, want %q", got, "Token secret")
+				}
+				if body, _ := io.ReadAll(r.Body); string(body) != "telemetry,sensor=a value=1 1\n" {
+					t.Errorf("body = %q", body)
+				}
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses)-1)])
+			}))
+			defer srv.Close()
+
+			h := NewInfluxHTTP(srv.URL, "secret", time.Second, tt.retries)
+			h.backoff = time.Millisecond
+			defer h.Close()
+
+			_, err := h.Write([]byte("telemetry,sensor=a value=1 1\n"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Write() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "unexpected status") {
+				t.Errorf("Write() error = %v, want the response status", err)
+			}
+			if got := int(reqs.Load()); got != tt.wantReqs {
+				t.Errorf("requests = %d, want %d", got, tt.wantReqs)
+			}
+		})
+	}
+}
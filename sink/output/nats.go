@@ -0,0 +1,138 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// DialNATS connects to the NATS servers in url, a comma separated list, with the
+// user credentials in credsFile if set. The connection reconnects on its own.
+func DialNATS(url, credsFile, name string) (*nats.Conn, error) {
+	opts := []nats.Option{nats.Name(name), nats.MaxReconnects(-1)}
+	if credsFile != "" {
+		opts = append(opts, nats.UserCredentials(credsFile))
+	}
+	nc, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connect to NATS: %w", err)
+	}
+	return nc, nil
+}
+
+// NATS publishes buffers of JSON lines, as written to the log, to a JetStream
+// subject, an entry per message. It is the destination of a storage.FileWriter,
+// so a buffer is a batch, published asynchronously and acknowledged as a whole.
+type NATS struct {
+	nc      *nats.Conn
+	js      jetstream.JetStream
+	subject string
+	timeout time.Duration
+	retries int
+	backoff time.Duration // before the first retry, doubled for each one after
+}
+
+// NewNATS creates a destination publishing to subject, which must belong to a
+// stream. A batch must be acknowledged within timeout, and the entries that weren't
+// are published again up to retries times. The connection is closed with it.
+func NewNATS(nc *nats.Conn, subject string, timeout time.Duration, retries int) (*NATS, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+	return &NATS{
+		nc:      nc,
+		js:      js,
+		subject: subject,
+		timeout: timeout,
+		retries: retries,
+		backoff: time.Second,
+	}, nil
+}
+
+// Write publishes each line of data. Every message carries a Nats-Msg-Id derived
+// from its content, so the stream discards entries published again after a partial
+// failure, or by a spilled buffer written twice, within its duplicate window.
+func (n *NATS) Write(data []byte) (int, error) {
+	pending := natsMessages(n.subject, data)
+
+	err := retry("NATS", n.retries, n.backoff, func() (bool, error) {
+		var err error
+		pending, err = n.publish(pending)
+		return true, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// publish publishes msgs and returns the ones that weren't acknowledged.
+func (n *NATS) publish(msgs []*nats.Msg) ([]*nats.Msg, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+
+	futures := make([]jetstream.PubAckFuture, 0, len(msgs))
+	var (
+		failed  []*nats.Msg
+		lastErr error
+	)
+	for _, msg := range msgs {
+		future, err := n.js.PublishMsgAsync(msg)
+		if err != nil {
+			failed = append(failed, msg)
+			lastErr = err
+			continue
+		}
+		futures = append(futures, future)
+	}
+	for _, future := range futures {
+		select {
+		case <-future.Ok():
+		case err := <-future.Err():
+			failed = append(failed, future.Msg())
+			lastErr = err
+		case <-ctx.Done():
+			failed = append(failed, future.Msg())
+			lastErr = ctx.Err()
+		}
+	}
+	if len(failed) > 0 {
+		return failed, fmt.Errorf("%d of %d entries not acknowledged: %w", len(failed), len(msgs), lastErr)
+	}
+	return nil, nil
+}
+
+// natsMessages makes a message of each line of data.
+func natsMessages(subject string, data []byte) []*nats.Msg {
+	var msgs []*nats.Msg
+	for line := range bytes.Lines(data) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		sum := sha256.Sum256(line)
+		msg := nats.NewMsg(subject)
+		msg.Header.Set(jetstream.MsgIDHeader, hex.EncodeToString(sum[:16]))
+		msg.Header.Set("Content-Type", "application/json")
+		msg.Data = line
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// Sync flushes the connection; acknowledged batches are stored already.
+func (n *NATS) Sync() error {
+	return n.nc.Flush()
+}
+
+// Close flushes pending messages and closes the connection.
+func (n *NATS) Close() error {
+	return n.nc.Drain()
+}
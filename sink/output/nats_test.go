@@ -0,0 +1,34 @@
+package output
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestNATSMessages(t *testing.T) {
+	data := []byte(`{"sensor_name":"a","sensor_value":1}` + "\n" +
+		`{"sensor_name":"b","sensor_value":1}` + "\n" +
+		`{"sensor_name":"a","sensor_value":1}` + "\n")
+
+	msgs := natsMessages("telemetry.entries", data)
+	if len(msgs) != 3 {
+		t.Fatalf("natsMessages() = %d messages, want 3", len(msgs))
+	}
+	for _, msg := range msgs {
+		if msg.Subject != "telemetry.entries" {
+			t.Errorf("Subject = %q, want telemetry.entries", msg.Subject)
+		}
+		if len(msg.Data) == 0 || msg.Data[len(msg.Data)-1] != '}' {
+			t.Errorf("Data = %q, want the entry without its newline", msg.Data)
+		}
+	}
+
+	id := func(i int) string { return msgs[i].Header.Get(jetstream.MsgIDHeader) }
+	if id(0) == "" || id(0) == id(1) {
+		t.Errorf("message IDs %q and %q of different entries, want distinct IDs", id(0), id(1))
+	}
+	if id(0) != id(2) {
+		t.Errorf("message IDs %q and %q of the same entry, want equal IDs so the stream drops the duplicate", id(0), id(2))
+	}
+}
@@ -0,0 +1,152 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresColumns are the columns a row is copied into, see ensureTable.
+var postgresColumns = []string{"ts", "sensor", "metric", "value", "tags"}
+
+// Postgres copies buffers of JSON lines, as written to the log, into a PostgreSQL
+// or TimescaleDB table as the rows decodeRows makes of them. It is the destination
+// of a storage.FileWriter, so a buffer is a batch, copied in one statement.
+type Postgres struct {
+	pool    *pgxpool.Pool
+	table   pgx.Identifier
+	timeout time.Duration
+	retries int
+	backoff time.Duration // before the first retry, doubled for each one after
+}
+
+// OpenPostgres connects a pool of at most maxConns connections to url, a
+// postgres:// URL or key=value connection string, and creates table if it doesn't
+// exist, as a hypertable when the timescaledb extension is installed. The password
+// may also come from PGPASSWORD or a .pgpass file. Each statement may take up to
+// timeout, and a failed batch is retried up to retries times.
+func OpenPostgres(ctx context.Context, url, table string, maxConns int32, timeout time.Duration, retries int) (*Postgres, error) {
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, fmt.Errorf("parse PostgreSQL URL: %w", err)
+	}
+	if maxConns > 0 {
+		cfg.MaxConns = maxConns
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect to PostgreSQL: %w", err)
+	}
+
+	p := &Postgres{
+		pool:    pool,
+		table:   pgx.Identifier(strings.Split(table, ".")),
+		timeout: timeout,
+		retries: retries,
+		backoff: time.Second,
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := p.ensureTable(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("create table %s: %w", table, err)
+	}
+	return p, nil
+}
+
+// ensureTable creates the table and its index, and turns it into a hypertable
+// partitioned by time when TimescaleDB is available.
+func (p *Postgres) ensureTable(ctx context.Context) error {
+	table := p.table.Sanitize()
+	index := pgx.Identifier{p.table[len(p.table)-1] + "_sensor_ts_idx"}.Sanitize()
+
+	if _, err := p.pool.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+table+` (
+	ts     timestamptz NOT NULL,
+	sensor text NOT NULL,
+	metric text,
+	value  double precision,
+	tags   jsonb NOT NULL DEFAULT '{}'
+)`); err != nil {
+		return err
+	}
+
+	var timescale bool
+	if err := p.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&timescale); err != nil {
+		return err
+	}
+	if timescale {
+		if _, err := p.pool.Exec(ctx, `SELECT create_hypertable($1::regclass, 'ts', if_not_exists => TRUE, migrate_data => TRUE)`, table); err != nil {
+			return fmt.Errorf("create hypertable: %w", err)
+		}
+	}
+
+	_, err := p.pool.Exec(ctx, `CREATE INDEX IF NOT EXISTS `+index+` ON `+table+` (sensor, ts DESC)`)
+	return err
+}
+
+// Write copies the entries of p into the table, retrying connection failures and
+// errors the server reports as transient. A buffer the server rejects, such as one
+// violating a constraint added to the table, is not retried.
+func (p *Postgres) Write(data []byte) (int, error) {
+	decoded, err := decodeRows(data)
+	if err != nil {
+		return 0, err
+	}
+	if len(decoded) == 0 {
+		return len(data), nil
+	}
+	rows := make([][]any, len(decoded))
+	for i, r := range decoded {
+		var metric, value any
+		if r.Metric != "" {
+			metric = r.Metric
+		}
+		if r.Value != nil {
+			value = *r.Value
+		}
+		rows[i] = []any{r.Time, r.Sensor, metric, value, r.Tags}
+	}
+
+	err = retry("PostgreSQL", p.retries, p.backoff, func() (bool, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		defer cancel()
+
+		_, err := p.pool.CopyFrom(ctx, p.table, postgresColumns, pgx.CopyFromRows(rows))
+		return retryablePostgres(err), err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// retryablePostgres reports whether err may go away on its own: anything but an
+// error the server reported, or one of its connection, rollback, resource or
+// shutdown error classes.
+func retryablePostgres(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return true
+	}
+	switch pgErr.Code[:2] {
+	case "08", "40", "53", "57":
+		return true
+	}
+	return false
+}
+
+// Sync returns nil: a batch is stored once Write returns.
+func (p *Postgres) Sync() error {
+	return nil
+}
+
+func (p *Postgres) Close() error {
+	p.pool.Close()
+	return nil
+}
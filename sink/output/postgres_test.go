@@ -0,0 +1,30 @@
+package output
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestRetryablePostgres(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "network", err: errors.New("connection refused"), want: true},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, want: true},
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, want: true},
+		{name: "invalid byte sequence", err: &pgconn.PgError{Code: "22021"}, want: false},
+		{name: "check violation", err: &pgconn.PgError{Code: "23514"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryablePostgres(tt.err); got != tt.want {
+				t.Errorf("retryablePostgres() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
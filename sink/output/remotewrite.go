@@ -0,0 +1,321 @@
+package output
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"log"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/sink/metrics"
+)
+
+const (
+	remoteWriteNameLabel   = "__name__"
+	remoteWriteSensorLabel = "sensor"
+
+	// remoteWriteMaxBackoff caps the wait between attempts to deliver a request.
+	remoteWriteMaxBackoff = time.Minute
+)
+
+// RemoteWrite converts buffers of JSON lines, as written to the log, to Prometheus
+// remote_write requests and delivers them to an endpoint such as Mimir, Thanos or
+// VictoriaMetrics. It is the destination of a storage.FileWriter, so a buffer is a
+// request. A request is stored once it is in the write-ahead log; it is delivered
+// in the background, in order, and retried until the endpoint accepts or rejects it,
+// across restarts.
+type RemoteWrite struct {
+	url    string
+	token  string
+	tenant string
+	name   string // metric name, and prefix of the names of named values
+	client *http.Client
+
+	wal     *wal
+	backoff time.Duration // before the first retry, doubled for each one after
+	notify  chan struct{} // signaled when a request is appended
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// RemoteWriteOptions configures a remote_write output.
+type RemoteWriteOptions struct {
+	URL    string
+	Token  string // sent as a bearer token
+	Tenant string // sent as X-Scope-OrgID for multi-tenant endpoints
+	Name   string // metric name
+
+	WALDir   string
+	WALQuota int64 // bytes of requests waiting to be delivered
+
+	Timeout time.Duration // per request
+}
+
+// OpenRemoteWrite opens the write-ahead log, delivering the requests a previous run
+// left in it first.
+func OpenRemoteWrite(opts RemoteWriteOptions) (*RemoteWrite, error) {
+	return openRemoteWrite(opts, time.Second)
+}
+
+func openRemoteWrite(opts RemoteWriteOptions, backoff time.Duration) (*RemoteWrite, error) {
+	wal, err := openWAL(opts.WALDir, opts.WALQuota, metrics.RemoteWriteWALBytes)
+	if err != nil {
+		return nil, err
+	}
+	if n := wal.Len(); n > 0 {
+		log.Printf("Found %d remote_write requests to deliver from a previous run", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &RemoteWrite{
+		url:     opts.URL,
+		token:   opts.Token,
+		tenant:  opts.Tenant,
+		name:    opts.Name,
+		client:  &http.Client{Timeout: opts.Timeout},
+		wal:     wal,
+		backoff: backoff,
+		notify:  make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	r.wg.Add(1)
+	go r.deliver()
+	return r, nil
+}
+
+// Write appends the numeric values in data to the write-ahead log as a request.
+// Events and sealed entries have none and are left out.
+func (r *RemoteWrite) Write(data []byte) (int, error) {
+	rows, err := decodeRows(data)
+	if err != nil {
+		return 0, err
+	}
+	series := remoteWriteSeries(rows, r.name)
+	if len(series) == 0 {
+		return len(data), nil
+	}
+
+	if err := r.wal.append(snappy.Encode(nil, appendWriteRequest(nil, series))); err != nil {
+		return 0, err
+	}
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+	return len(data), nil
+}
+
+// deliver sends the requests in the write-ahead log, oldest first, until Close.
+func (r *RemoteWrite) deliver() {
+	defer r.wg.Done()
+
+	backoff := r.backoff
+	for {
+		data, ok, err := r.wal.oldest()
+		if !ok {
+			select {
+			case <-r.notify:
+				continue
+			case <-r.ctx.Done():
+				return
+			}
+		}
+		retryable := false
+		if err == nil {
+			retryable, err = r.post(data)
+		}
+
+		switch {
+		case err == nil:
+			backoff = r.backoff
+		case !retryable:
+			log.Printf("remote_write request rejected, dropping it: %v", err)
+			backoff = r.backoff
+		default:
+			log.Printf("remote_write failed, retrying in %v: %v", backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-r.ctx.Done():
+				return
+			}
+			backoff = min(backoff*2, remoteWriteMaxBackoff)
+			continue
+		}
+		if err := r.wal.remove(); err != nil {
+			log.Printf("Failed to remove delivered remote_write request: %v", err)
+		}
+	}
+}
+
+// post sends one request and reports whether a failure is worth retrying.
+func (r *RemoteWrite) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+	if r.tenant != "" {
+		req.Header.Set("X-Scope-OrgID", r.tenant)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp)
+}
+
+// Sync returns nil: requests are synced to the write-ahead log once Write returns.
+func (r *RemoteWrite) Sync() error {
+	return nil
+}
+
+// Close stops delivering, leaving the requests not delivered yet in the log.
+func (r *RemoteWrite) Close() error {
+	r.cancel()
+	r.wg.Wait()
+	r.client.CloseIdleConnections()
+	return nil
+}
+
+type remoteWriteLabel struct {
+	name, value string
+}
+
+type remoteWriteSample struct {
+	value     float64
+	timestamp int64 // milliseconds since the epoch
+}
+
+type remoteWriteTimeSeries struct {
+	labels  []remoteWriteLabel // sorted by name
+	samples []remoteWriteSample
+}
+
+// remoteWriteSeries groups the rows with values into series. A row's metric is
+// appended to name to make the series name; its sensor and tags become labels, with
+// names changed to valid label names and those clashing with the sensor label
+// dropped. Samples are sorted by time within each series.
+func remoteWriteSeries(rows []row, name string) []remoteWriteTimeSeries {
+	byLabels := make(map[string]*remoteWriteTimeSeries)
+	var keys []string
+	for _, r := range rows {
+		if r.Value == nil {
+			continue
+		}
+
+		metric := name
+		if r.Metric != "" {
+			metric += "_" + r.Metric
+		}
+		labels := map[string]string{
+			remoteWriteNameLabel:   sanitizeMetricName(metric),
+			remoteWriteSensorLabel: r.Sensor,
+		}
+		for k, v := range r.Tags {
+			k = sanitizeLabelName(k)
+			if _, reserved := labels[k]; !reserved && !strings.HasPrefix(k, "__") && v != "" {
+				labels[k] = v
+			}
+		}
+
+		var key strings.Builder
+		ts := remoteWriteTimeSeries{}
+		for _, k := range slices.Sorted(maps.Keys(labels)) {
+			ts.labels = append(ts.labels, remoteWriteLabel{name: k, value: labels[k]})
+			key.WriteString(k)
+			key.WriteByte(0)
+			key.WriteString(labels[k])
+			key.WriteByte(0)
+		}
+
+		series, ok := byLabels[key.String()]
+		if !ok {
+			series = &ts
+			byLabels[key.String()] = series
+			keys = append(keys, key.String())
+		}
+		series.samples = append(series.samples, remoteWriteSample{value: *r.Value, timestamp: r.Time.UnixMilli()})
+	}
+
+	all := make([]remoteWriteTimeSeries, 0, len(keys))
+	for _, key := range keys {
+		series := byLabels[key]
+		slices.SortStableFunc(series.samples, func(a, b remoteWriteSample) int { return cmp.Compare(a.timestamp, b.timestamp) })
+		all = append(all, *series)
+	}
+	return all
+}
+
+// appendWriteRequest appends a prometheus.WriteRequest holding series.
+func appendWriteRequest(dst []byte, series []remoteWriteTimeSeries) []byte {
+	var ts, field []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			field = protowire.AppendTag(field[:0], 1, protowire.BytesType)
+			field = protowire.AppendString(field, l.name)
+			field = protowire.AppendTag(field, 2, protowire.BytesType)
+			field = protowire.AppendString(field, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, field)
+		}
+		for _, sample := range s.samples {
+			field = protowire.AppendTag(field[:0], 1, protowire.Fixed64Type)
+			field = protowire.AppendFixed64(field, math.Float64bits(sample.value))
+			field = protowire.AppendTag(field, 2, protowire.VarintType)
+			field = protowire.AppendVarint(field, uint64(sample.timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, field)
+		}
+		dst = protowire.AppendTag(dst, 1, protowire.BytesType)
+		dst = protowire.AppendBytes(dst, ts)
+	}
+	return dst
+}
+
+// sanitizeMetricName replaces the characters not allowed in a metric name with
+// underscores.
+func sanitizeMetricName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizeLabelName replaces the characters not allowed in a label name with
+// underscores.
+func sanitizeLabelName(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, colons bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':' && colons:
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
@@ -0,0 +1,187 @@
+package output
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestRemoteWriteSeries(t *testing.T) {
+	t0 := time.UnixMilli(1714564800000).UTC()
+	t1 := t0.Add(time.Second)
+	value := func(v float64) *float64 { return &v }
+
+	rows := []row{
+		{Time: t1, Sensor: "temp-1", Value: value(22), Tags: map[string]string{"room": "a"}},
+		{Time: t0, Sensor: "temp-1", Value: value(21), Tags: map[string]string{"room": "a"}},
+		{Time: t0, Sensor: "env-1", Metric: "humidity", Value: value(40), Tags: map[string]string{"floor-2": "x", "sensor": "other", "__name__": "x", "empty": ""}},
+		{Time: t0, Sensor: "door-1", Tags: map[string]string{"type": "event"}},
+	}
+
+	want := []remoteWriteTimeSeries{
+		{
+			labels:  []remoteWriteLabel{{"__name__", "telemetry"}, {"room", "a"}, {"sensor", "temp-1"}},
+			samples: []remoteWriteSample{{21, t0.UnixMilli()}, {22, t1.UnixMilli()}},
+		},
+		{
+			labels:  []remoteWriteLabel{{"__name__", "telemetry_humidity"}, {"floor_2", "x"}, {"sensor", "env-1"}},
+			samples: []remoteWriteSample{{40, t0.UnixMilli()}},
+		},
+	}
+	if got := remoteWriteSeries(rows, "telemetry"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remoteWriteSeries() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		failures = 2
+		received []remoteWriteTimeSeries
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		for header, want := range map[string]string{
+			"Content-Encoding": "snappy",
+			"Content-Type":     "application/x-protobuf",
+			"Authorization":    "Bearer secret",
+			"X-Scope-OrgID":    "acme",
+		} {
+			if got := r.Header.Get(header); got != want {
+				t.Errorf("%s = %q, want %q", header, got, want)
+			}
+		}
+		body, _ := io.ReadAll(r.Body)
+		req, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Errorf("snappy.Decode() error = %v", err)
+			return
+		}
+		received = append(received, decodeWriteRequest(t, req)...)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	open := func() *RemoteWrite {
+		r, err := openRemoteWrite(RemoteWriteOptions{
+			URL:      srv.URL,
+			Token:    "secret",
+			Tenant:   "acme",
+			Name:     "telemetry",
+			WALDir:   dir,
+			WALQuota: 1 << 20,
+			Timeout:  time.Second,
+		}, time.Millisecond)
+		if err != nil {
+			t.Fatalf("openRemoteWrite() error = %v", err)
+		}
+		return r
+	}
+
+	// Closed while the endpoint fails, the request stays in the log.
+	r := open()
+	data := `{"data_time":"2024-05-01T12:00:00Z","sensor_name":"temp-1","sensor_value":21.5,"timestamp":"2024-05-01T12:00:01Z"}` + "\n"
+	if _, err := r.Write([]byte(data)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	r.Close()
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("write-ahead log has %d records after failed deliveries, want 1", len(entries))
+	}
+
+	// Reopened, it is delivered and removed.
+	r = open()
+	defer r.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for r.wal.Len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []remoteWriteTimeSeries{{
+		labels:  []remoteWriteLabel{{"__name__", "telemetry"}, {"sensor", "temp-1"}},
+		samples: []remoteWriteSample{{21.5, 1714564800000}},
+	}}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("received %+v, want %+v", received, want)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("write-ahead log has %d records after delivery, want 0", len(entries))
+	}
+}
+
+// decodeWriteRequest decodes the series of a prometheus.WriteRequest.
+func decodeWriteRequest(t *testing.T, b []byte) []remoteWriteTimeSeries {
+	t.Helper()
+
+	fields := func(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatalf("malformed WriteRequest: %v", protowire.ParseError(n))
+			}
+			b = b[n:]
+			if n = fn(num, typ, b); n < 0 {
+				t.Fatalf("malformed WriteRequest: %v", protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+
+	var series []remoteWriteTimeSeries
+	fields(b, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+		tsBytes, n := protowire.ConsumeBytes(b)
+		var ts remoteWriteTimeSeries
+		fields(tsBytes, func(num protowire.Number, _ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			switch num {
+			case 1:
+				var l remoteWriteLabel
+				fields(msg, func(num protowire.Number, _ protowire.Type, b []byte) int {
+					s, n := protowire.ConsumeString(b)
+					if num == 1 {
+						l.name = s
+					} else {
+						l.value = s
+					}
+					return n
+				})
+				ts.labels = append(ts.labels, l)
+			case 2:
+				var s remoteWriteSample
+				fields(msg, func(num protowire.Number, _ protowire.Type, b []byte) int {
+					if num == 1 {
+						v, n := protowire.ConsumeFixed64(b)
+						s.value = math.Float64frombits(v)
+						return n
+					}
+					v, n := protowire.ConsumeVarint(b)
+					s.timestamp = int64(v)
+					return n
+				})
+				ts.samples = append(ts.samples, s)
+			}
+			return n
+		})
+		series = append(series, ts)
+		return n
+	})
+	return series
+}
@@ -0,0 +1,41 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// retry calls write until it succeeds, fails with an error it reports as permanent,
+// or has been retried retries times. It waits backoff before the first retry and
+// doubles the wait for each one after.
+func retry(name string, retries int, backoff time.Duration, write func() (retryable bool, err error)) error {
+	for attempt := 0; ; attempt++ {
+		retryable, err := write()
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= retries {
+			return err
+		}
+
+		log.Printf("%s write failed, retrying in %v: %v", name, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// checkResponse consumes the body of a response to a write and returns an error with
+// its start unless the write succeeded. Throttling and server errors are retryable.
+func checkResponse(resp *http.Response) (retryable bool, err error) {
+	if resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(body))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
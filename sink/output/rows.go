@@ -0,0 +1,101 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// row is a value of an entry as stored in a table.
+type row struct {
+	Time   time.Time
+	Sensor string
+	Metric string   // empty for a reading's single value
+	Value  *float64 // nil for events and sealed entries
+	Tags   map[string]string
+}
+
+// logEntry holds the fields of a log entry that rows are made of.
+type logEntry struct {
+	SensorName  string             `json:"sensor_name"`
+	SensorValue *float64           `json:"sensor_value"`
+	Metric      string             `json:"metric"`
+	Values      map[string]float64 `json:"values"`
+	Aggregate   *struct {
+		Count uint64  `json:"count"`
+		Sum   float64 `json:"sum"`
+	} `json:"aggregate"`
+	Event *struct {
+		Message  string `json:"message"`
+		Severity string `json:"severity"`
+	} `json:"event"`
+	Sealed    string            `json:"sealed_payload"`
+	Priority  string            `json:"priority"`
+	Tags      map[string]string `json:"tags"`
+	DataTime  time.Time         `json:"data_time"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// decodeRows converts JSON lines, as written to the log, to rows. A reading becomes
+// a row per value, named by metric; an aggregate one for its count and one for its
+// sum. Events and sealed entries have no value, their message, severity or payload
+// are tags, as is a critical priority. The row's time is the device time.
+func decodeRows(data []byte) ([]row, error) {
+	var rows []row
+	for line := range bytes.Lines(data) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var e logEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("decode entry: %w", err)
+		}
+
+		ts := e.DataTime
+		if ts.IsZero() {
+			ts = e.Timestamp
+		}
+		tags := make(map[string]string, len(e.Tags)+2)
+		maps.Copy(tags, e.Tags)
+		if e.Priority != "" {
+			tags["priority"] = e.Priority
+		}
+		add := func(metric string, value *float64) {
+			rows = append(rows, row{Time: ts, Sensor: e.SensorName, Metric: metric, Value: value, Tags: tags})
+		}
+
+		switch {
+		case e.Sealed != "":
+			tags["sealed_payload"] = e.Sealed
+			add(e.Metric, nil)
+		case e.Event != nil:
+			tags["type"] = "event"
+			tags["severity"] = e.Event.Severity
+			tags["message"] = e.Event.Message
+			add(e.Metric, nil)
+		case e.Aggregate != nil:
+			count := float64(e.Aggregate.Count)
+			add(joinMetric(e.Metric, "count"), &count)
+			add(joinMetric(e.Metric, "sum"), &e.Aggregate.Sum)
+		case e.Values != nil:
+			for _, name := range slices.Sorted(maps.Keys(e.Values)) {
+				value := e.Values[name]
+				add(joinMetric(e.Metric, name), &value)
+			}
+		case e.SensorValue != nil:
+			add(e.Metric, e.SensorValue)
+		}
+	}
+	return rows, nil
+}
+
+func joinMetric(metric, name string) string {
+	if metric == "" {
+		return name
+	}
+	return metric + "_" + name
+}
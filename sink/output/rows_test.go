@@ -0,0 +1,74 @@
+package output
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeRows(t *testing.T) {
+	dataTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	received := dataTime.Add(time.Second)
+	value := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name string
+		line string
+		want []row
+	}{
+		{
+			name: "value",
+			line: `{"data_time":"2024-05-01T12:00:00Z","sensor_name":"temp-1","sensor_value":21.5,"tags":{"room":"a"},"timestamp":"2024-05-01T12:00:01Z"}`,
+			want: []row{{Time: dataTime, Sensor: "temp-1", Value: value(21.5), Tags: map[string]string{"room": "a"}}},
+		},
+		{
+			name: "split value with critical priority",
+			line: `{"data_time":"2024-05-01T12:00:00Z","metric":"humidity","priority":"critical","sensor_name":"env-1","sensor_value":40,"timestamp":"2024-05-01T12:00:01Z"}`,
+			want: []row{{Time: dataTime, Sensor: "env-1", Metric: "humidity", Value: value(40), Tags: map[string]string{"priority": "critical"}}},
+		},
+		{
+			name: "combined values",
+			line: `{"data_time":"2024-05-01T12:00:00Z","sensor_name":"env-1","timestamp":"2024-05-01T12:00:01Z","values":{"temperature":21,"humidity":40}}`,
+			want: []row{
+				{Time: dataTime, Sensor: "env-1", Metric: "humidity", Value: value(40), Tags: map[string]string{}},
+				{Time: dataTime, Sensor: "env-1", Metric: "temperature", Value: value(21), Tags: map[string]string{}},
+			},
+		},
+		{
+			name: "aggregate",
+			line: `{"aggregate":{"count":10,"sum":55},"data_time":"2024-05-01T12:00:00Z","sensor_name":"flow-1","timestamp":"2024-05-01T12:00:01Z"}`,
+			want: []row{
+				{Time: dataTime, Sensor: "flow-1", Metric: "count", Value: value(10), Tags: map[string]string{}},
+				{Time: dataTime, Sensor: "flow-1", Metric: "sum", Value: value(55), Tags: map[string]string{}},
+			},
+		},
+		{
+			name: "event",
+			line: `{"data_time":"2024-05-01T12:00:00Z","event":{"message":"door open","severity":"warning"},"sensor_name":"door-1","timestamp":"2024-05-01T12:00:01Z","type":"event"}`,
+			want: []row{{Time: dataTime, Sensor: "door-1", Tags: map[string]string{"type": "event", "severity": "warning", "message": "door open"}}},
+		},
+		{
+			name: "receive time without device time",
+			line: `{"data_time":"0001-01-01T00:00:00Z","sensor_name":"temp-1","sensor_value":1,"timestamp":"2024-05-01T12:00:01Z"}`,
+			want: []row{{Time: received, Sensor: "temp-1", Value: value(1), Tags: map[string]string{}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeRows([]byte(tt.line + "\n"))
+			if err != nil {
+				t.Fatalf("decodeRows() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeRows() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRows_Invalid(t *testing.T) {
+	if _, err := decodeRows([]byte("not json\n")); err == nil {
+		t.Error("decodeRows() error = nil, want error")
+	}
+}
@@ -0,0 +1,159 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const walSuffix = ".wal"
+
+// errWALFull is returned by append when a record would exceed the log's quota.
+var errWALFull = errors.New("write-ahead log full")
+
+// wal is a write-ahead log of records waiting to be delivered, one file per record
+// in a directory, bounded by a quota. Records left by a previous run are delivered
+// first.
+type wal struct {
+	dir   string
+	quota int64
+	bytes prometheus.Gauge
+
+	mu      sync.Mutex
+	records []walRecord // oldest first
+	size    int64
+	next    uint64 // sequence number of the next record
+}
+
+type walRecord struct {
+	name string
+	size int64
+}
+
+// openWAL creates dir if needed and picks up the records appended to it before.
+// At most quota bytes are kept, reported in bytes.
+func openWAL(dir string, quota int64, bytes prometheus.Gauge) (*wal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create write-ahead log directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read write-ahead log directory: %w", err)
+	}
+
+	w := &wal{dir: dir, quota: quota, bytes: bytes}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Interrupted while being appended, never acknowledged as stored.
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, walSuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(name, walSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("read write-ahead log directory: %w", err)
+		}
+		w.records = append(w.records, walRecord{name: name, size: info.Size()})
+		w.size += info.Size()
+		w.next = max(w.next, seq+1)
+	}
+	slices.SortFunc(w.records, func(a, b walRecord) int { return strings.Compare(a.name, b.name) })
+	w.bytes.Set(float64(w.size))
+
+	return w, nil
+}
+
+// Len returns the number of records waiting.
+func (w *wal) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.records)
+}
+
+// append adds a record once it is synced to disk. The file is renamed into place
+// once complete, so a crash never leaves a partial record to be delivered.
+func (w *wal) append(data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size+int64(len(data)) > w.quota {
+		return errWALFull
+	}
+
+	name := fmt.Sprintf("%020d%s", w.next, walSuffix)
+	path := filepath.Join(w.dir, name)
+	if err := writeSynced(path+".tmp", data); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	w.next++
+	w.records = append(w.records, walRecord{name: name, size: int64(len(data))})
+	w.size += int64(len(data))
+	w.bytes.Set(float64(w.size))
+	return nil
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// oldest reads the oldest record. ok is false when the log is empty.
+func (w *wal) oldest() (data []byte, ok bool, err error) {
+	w.mu.Lock()
+	if len(w.records) == 0 {
+		w.mu.Unlock()
+		return nil, false, nil
+	}
+	name := w.records[0].name
+	w.mu.Unlock()
+
+	// Only the delivering goroutine removes records, so the oldest can't go away
+	// meanwhile.
+	data, err = os.ReadFile(filepath.Join(w.dir, name))
+	return data, true, err
+}
+
+// remove removes the oldest record once delivered.
+func (w *wal) remove() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.records) == 0 {
+		return nil
+	}
+	record := w.records[0]
+	w.records = w.records[1:]
+	w.size -= record.size
+	w.bytes.Set(float64(w.size))
+
+	return os.Remove(filepath.Join(w.dir, record.name))
+}
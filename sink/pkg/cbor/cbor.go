@@ -0,0 +1,320 @@
+// Package cbor decodes CBOR (RFC 8949) data items into Go values, for readings sent
+// by constrained devices. It decodes into generic values only:
+//
+//	unsigned integers  uint64
+//	negative integers  int64
+//	byte strings       []byte
+//	text strings       string
+//	arrays             []any
+//	maps               map[string]any, keys must be text strings
+//	tags               Tag
+//	false, true        bool
+//	null, undefined    nil
+//	floats             float64, from half, single or double precision
+//
+// Indefinite-length strings, arrays and maps are accepted.
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Tag is a tagged data item, e.g. tag 1 for an epoch timestamp.
+type Tag struct {
+	Number  uint64
+	Content any
+}
+
+// maxDepth bounds nesting so crafted input can't exhaust the stack.
+const maxDepth = 16
+
+// ErrSyntax is returned for data that is not well-formed CBOR or uses an
+// unsupported feature.
+var ErrSyntax = errors.New("invalid CBOR")
+
+// Unmarshal decodes a single data item, which must span all of data.
+func Unmarshal(data []byte) (any, error) {
+	d := decoder{data: data}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(data) {
+		return nil, fmt.Errorf("%w: %d bytes after the data item", ErrSyntax, len(data)-d.off)
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	off  int
+}
+
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+
+	indefinite = 31
+	breakByte  = 0xff
+)
+
+func (d *decoder) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: offset %d: %s", ErrSyntax, d.off, fmt.Sprintf(format, args...))
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.off >= len(d.data) {
+		return 0, d.errorf("unexpected end of data")
+	}
+	b := d.data[d.off]
+	d.off++
+	return b, nil
+}
+
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, d.errorf("length %d past the end of data", n)
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+// argument reads the argument of an initial byte with additional info ai.
+func (d *decoder) argument(ai byte) (uint64, error) {
+	var size uint64
+	switch {
+	case ai < 24:
+		return uint64(ai), nil
+	case ai == 24:
+		size = 1
+	case ai == 25:
+		size = 2
+	case ai == 26:
+		size = 4
+	case ai == 27:
+		size = 8
+	default:
+		return 0, d.errorf("reserved additional information %d", ai)
+	}
+
+	b, err := d.bytes(size)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+func (d *decoder) item(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, d.errorf("nested deeper than %d", maxDepth)
+	}
+
+	initial, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	major, ai := initial>>5, initial&0x1f
+
+	if ai == indefinite {
+		switch major {
+		case majorBytes, majorText:
+			return d.indefiniteString(major)
+		case majorArray:
+			return d.array(-1, depth)
+		case majorMap:
+			return d.mapItem(-1, depth)
+		case majorSimple:
+			return nil, d.errorf("unexpected break")
+		}
+		return nil, d.errorf("indefinite length for major type %d", major)
+	}
+
+	if major == majorSimple {
+		return d.simple(ai)
+	}
+	arg, err := d.argument(ai)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUint:
+		return arg, nil
+	case majorNegInt:
+		if arg > math.MaxInt64 {
+			return nil, d.errorf("negative integer out of range")
+		}
+		return -1 - int64(arg), nil
+	case majorBytes:
+		return d.bytes(arg)
+	case majorText:
+		b, err := d.bytes(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !utf8.Valid(b) {
+			return nil, d.errorf("text string is not valid UTF-8")
+		}
+		return string(b), nil
+	case majorArray:
+		return d.array(int64(min(arg, math.MaxInt64)), depth)
+	case majorMap:
+		return d.mapItem(int64(min(arg, math.MaxInt64)), depth)
+	default: // majorTag
+		content, err := d.item(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Tag{Number: arg, Content: content}, nil
+	}
+}
+
+func (d *decoder) simple(ai byte) (any, error) {
+	switch ai {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		b, err := d.bytes(2)
+		if err != nil {
+			return nil, err
+		}
+		return halfToFloat(binary.BigEndian.Uint16(b)), nil
+	case 26:
+		b, err := d.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 27:
+		b, err := d.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	}
+	return nil, d.errorf("unsupported simple value %d", ai)
+}
+
+// halfToFloat converts an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		v = -v
+	}
+	return v
+}
+
+// atBreak consumes a break byte if one is next.
+func (d *decoder) atBreak() bool {
+	if d.off < len(d.data) && d.data[d.off] == breakByte {
+		d.off++
+		return true
+	}
+	return false
+}
+
+func (d *decoder) indefiniteString(major byte) (any, error) {
+	var b []byte
+	for !d.atBreak() {
+		initial, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		if initial>>5 != major || initial&0x1f == indefinite {
+			return nil, d.errorf("invalid chunk in indefinite-length string")
+		}
+		n, err := d.argument(initial & 0x1f)
+		if err != nil {
+			return nil, err
+		}
+		chunk, err := d.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+	}
+
+	if major == majorBytes {
+		return b, nil
+	}
+	if !utf8.Valid(b) {
+		return nil, d.errorf("text string is not valid UTF-8")
+	}
+	return string(b), nil
+}
+
+// array decodes n items, or items up to a break when n is negative.
+func (d *decoder) array(n int64, depth int) (any, error) {
+	// Every item takes at least a byte, which bounds the allocation.
+	items := make([]any, 0, min(max(n, 0), int64(len(d.data)-d.off)))
+	for i := int64(0); n < 0 || i < n; i++ {
+		if n < 0 && d.atBreak() {
+			break
+		}
+		v, err := d.item(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+// mapItem decodes n pairs, or pairs up to a break when n is negative.
+func (d *decoder) mapItem(n int64, depth int) (any, error) {
+	m := make(map[string]any, min(max(n, 0), int64(len(d.data)-d.off)/2))
+	for i := int64(0); n < 0 || i < n; i++ {
+		if n < 0 && d.atBreak() {
+			break
+		}
+		k, err := d.item(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, d.errorf("map key %v is not a text string", k)
+		}
+		if _, dup := m[key]; dup {
+			return nil, d.errorf("duplicate map key %q", key)
+		}
+		if m[key], err = d.item(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,82 @@
+package cbor
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshal(t *testing.T) {
+	// Examples from RFC 8949 appendix A.
+	tests := []struct {
+		hex  string
+		want any
+	}{
+		{"00", uint64(0)},
+		{"1903e8", uint64(1000)},
+		{"1bffffffffffffffff", uint64(math.MaxUint64)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f93c00", 1.0},
+		{"f97bff", 65504.0},
+		{"f90001", 5.960464477539063e-8},
+		{"fa47c35000", 100000.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"83010203", []any{uint64(1), uint64(2), uint64(3)}},
+		{"a26161016162820203", map[string]any{"a": uint64(1), "b": []any{uint64(2), uint64(3)}}},
+		{"c11a514b67b0", Tag{Number: 1, Content: uint64(1363896240)}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9f018202039f0405ffff", []any{uint64(1), []any{uint64(2), uint64(3)}, []any{uint64(4), uint64(5)}}},
+		{"bf6346756ef563416d7421ff", map[string]any{"Fun": true, "Amt": int64(-2)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.hex, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.hex)
+			got, err := Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshal_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		hex  string
+	}{
+		{"empty", ""},
+		{"trailing data", "0000"},
+		{"truncated argument", "1903"},
+		{"string past the end", "6449"},
+		{"huge array", "9bffffffffffffffff"},
+		{"reserved info", "1c"},
+		{"invalid UTF-8", "62c328"},
+		{"integer map key", "a10102"},
+		{"duplicate key", "a2616101616102"},
+		{"unterminated array", "9f01"},
+		{"lone break", "ff"},
+		{"negative out of range", "3bffffffffffffffff"},
+		{"too deep", "8181818181818181818181818181818181818181" + "00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.hex)
+			if _, err := Unmarshal(data); !errors.Is(err, ErrSyntax) {
+				t.Errorf("Unmarshal() error = %v, want ErrSyntax", err)
+			}
+		})
+	}
+}
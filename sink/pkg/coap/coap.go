@@ -0,0 +1,257 @@
+// Package coap encodes and decodes the CoAP messages (RFC 7252) the sink exchanges
+// with constrained devices over UDP. It covers what the sink's endpoint needs:
+// message types, codes, tokens, options and payloads. Block-wise transfers, observe
+// and DTLS are not supported.
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Type is a message type.
+type Type uint8
+
+const (
+	Confirmable     Type = 0 // CON, acknowledged by the receiver
+	NonConfirmable  Type = 1 // NON, fire and forget
+	Acknowledgement Type = 2 // ACK
+	Reset           Type = 3 // RST, the receiver could not process a message
+)
+
+// Code is a request method or response code, class.detail as in "2.04".
+type Code uint8
+
+const (
+	Empty Code = 0
+	GET   Code = 1
+	POST  Code = 2
+	PUT   Code = 3
+
+	Created                  Code = 2<<5 | 1
+	Changed                  Code = 2<<5 | 4
+	BadRequest               Code = 4<<5 | 0
+	Unauthorized             Code = 4<<5 | 1
+	BadOption                Code = 4<<5 | 2
+	Forbidden                Code = 4<<5 | 3
+	NotFound                 Code = 4<<5 | 4
+	MethodNotAllowed         Code = 4<<5 | 5
+	RequestEntityTooLarge    Code = 4<<5 | 13
+	UnsupportedContentFormat Code = 4<<5 | 15
+	TooManyRequests          Code = 4<<5 | 29 // RFC 8516
+	InternalServerError      Code = 5<<5 | 0
+	ServiceUnavailable       Code = 5<<5 | 3
+)
+
+func (c Code) String() string {
+	return fmt.Sprintf("%d.%02d", c>>5, c&0x1f)
+}
+
+// Option numbers used by the sink.
+const (
+	OptionURIHost       = 3
+	OptionURIPort       = 7
+	OptionURIPath       = 11
+	OptionContentFormat = 12
+)
+
+// Content formats.
+const (
+	FormatText = 0
+	FormatCBOR = 60
+)
+
+// Option is a message option. Options with odd numbers are critical: a receiver
+// that doesn't understand them must reject the message.
+type Option struct {
+	Number uint16
+	Value  []byte
+}
+
+// Critical reports whether the option must be understood by the receiver.
+func (o Option) Critical() bool {
+	return o.Number&1 == 1
+}
+
+// Message is a CoAP message.
+type Message struct {
+	Type      Type
+	Code      Code
+	MessageID uint16
+	Token     []byte
+	Options   []Option
+	Payload   []byte
+}
+
+const (
+	version       = 1
+	headerSize    = 4
+	maxTokenSize  = 8
+	payloadMarker = 0xff
+)
+
+// ErrFormat is returned for datagrams that are not valid CoAP messages.
+var ErrFormat = errors.New("malformed CoAP message")
+
+// Parse decodes a message. The message refers to data, which must not be modified
+// while it is in use.
+func Parse(data []byte) (*Message, error) {
+	if len(data) < headerSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFormat, len(data))
+	}
+	if v := data[0] >> 6; v != version {
+		return nil, fmt.Errorf("%w: version %d", ErrFormat, v)
+	}
+
+	m := &Message{
+		Type:      Type(data[0] >> 4 & 0x3),
+		Code:      Code(data[1]),
+		MessageID: binary.BigEndian.Uint16(data[2:]),
+	}
+	tokenSize := int(data[0] & 0xf)
+	if tokenSize > maxTokenSize || len(data) < headerSize+tokenSize {
+		return nil, fmt.Errorf("%w: token of %d bytes", ErrFormat, tokenSize)
+	}
+	m.Token = data[headerSize : headerSize+tokenSize]
+	rest := data[headerSize+tokenSize:]
+
+	number := 0
+	for len(rest) > 0 {
+		if rest[0] == payloadMarker {
+			if len(rest) == 1 {
+				return nil, fmt.Errorf("%w: payload marker without payload", ErrFormat)
+			}
+			m.Payload = rest[1:]
+			break
+		}
+
+		var delta, length int
+		var err error
+		header := rest[0]
+		rest = rest[1:]
+		if delta, rest, err = optionNibble(header>>4, rest); err != nil {
+			return nil, err
+		}
+		if length, rest, err = optionNibble(header&0xf, rest); err != nil {
+			return nil, err
+		}
+		number += delta
+		if number > 0xffff || length > len(rest) {
+			return nil, fmt.Errorf("%w: option %d", ErrFormat, number)
+		}
+		m.Options = append(m.Options, Option{Number: uint16(number), Value: rest[:length]})
+		rest = rest[length:]
+	}
+	return m, nil
+}
+
+// optionNibble decodes an option delta or length, reading its extended bytes.
+func optionNibble(nibble byte, rest []byte) (int, []byte, error) {
+	switch nibble {
+	case 13:
+		if len(rest) < 1 {
+			return 0, nil, fmt.Errorf("%w: truncated option", ErrFormat)
+		}
+		return int(rest[0]) + 13, rest[1:], nil
+	case 14:
+		if len(rest) < 2 {
+			return 0, nil, fmt.Errorf("%w: truncated option", ErrFormat)
+		}
+		return int(binary.BigEndian.Uint16(rest)) + 269, rest[2:], nil
+	case 15:
+		return 0, nil, fmt.Errorf("%w: reserved option nibble", ErrFormat)
+	}
+	return int(nibble), rest, nil
+}
+
+// Append encodes the message and appends it to dst. Options are written in order of
+// their numbers, as the encoding requires.
+func (m *Message) Append(dst []byte) []byte {
+	dst = append(dst, version<<6|byte(m.Type)<<4|byte(len(m.Token)))
+	dst = append(dst, byte(m.Code))
+	dst = binary.BigEndian.AppendUint16(dst, m.MessageID)
+	dst = append(dst, m.Token...)
+
+	options := slices.Clone(m.Options)
+	slices.SortStableFunc(options, func(a, b Option) int { return int(a.Number) - int(b.Number) })
+	number := 0
+	for _, o := range options {
+		delta := int(o.Number) - number
+		number = int(o.Number)
+
+		i := len(dst)
+		dst = append(dst, 0)
+		var deltaNibble, lengthNibble byte
+		deltaNibble, dst = appendNibble(dst, delta)
+		lengthNibble, dst = appendNibble(dst, len(o.Value))
+		dst[i] = deltaNibble<<4 | lengthNibble
+		dst = append(dst, o.Value...)
+	}
+
+	if len(m.Payload) > 0 {
+		dst = append(dst, payloadMarker)
+		dst = append(dst, m.Payload...)
+	}
+	return dst
+}
+
+// appendNibble returns the nibble for an option delta or length and appends its
+// extended bytes.
+func appendNibble(dst []byte, v int) (byte, []byte) {
+	switch {
+	case v < 13:
+		return byte(v), dst
+	case v < 269:
+		return 13, append(dst, byte(v-13))
+	default:
+		return 14, binary.BigEndian.AppendUint16(dst, uint16(v-269))
+	}
+}
+
+// Option returns the value of the first option with the given number.
+func (m *Message) Option(number uint16) ([]byte, bool) {
+	for _, o := range m.Options {
+		if o.Number == number {
+			return o.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Uint returns the value of a uint option, such as Content-Format.
+func (m *Message) Uint(number uint16) (uint32, bool) {
+	value, ok := m.Option(number)
+	if !ok || len(value) > 4 {
+		return 0, false
+	}
+	var v uint32
+	for _, b := range value {
+		v = v<<8 | uint32(b)
+	}
+	return v, true
+}
+
+// Path returns the Uri-Path options joined with slashes, e.g. "readings".
+func (m *Message) Path() string {
+	var segments []string
+	for _, o := range m.Options {
+		if o.Number == OptionURIPath {
+			segments = append(segments, string(o.Value))
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// UintOption returns an option holding v in the fewest bytes, as uint options are
+// encoded.
+func UintOption(number uint16, v uint32) Option {
+	var value []byte
+	for v > 0 {
+		value = append([]byte{byte(v)}, value...)
+		v >>= 8
+	}
+	return Option{Number: number, Value: value}
+}
@@ -0,0 +1,86 @@
+package coap
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMessage_AppendParse(t *testing.T) {
+	long := bytes.Repeat([]byte("a"), 300)
+	m := &Message{
+		Type:      Confirmable,
+		Code:      POST,
+		MessageID: 0xbeef,
+		Token:     []byte{1, 2, 3, 4},
+		Options: []Option{
+			UintOption(OptionContentFormat, FormatCBOR),
+			{Number: OptionURIPath, Value: []byte("readings")},
+			{Number: OptionURIPath, Value: long},
+			{Number: 2048, Value: []byte("x")}, // extended delta
+		},
+		Payload: []byte{0xa1, 0x61, 0x76, 0x01},
+	}
+
+	got, err := Parse(m.Append(nil))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got.Type != m.Type || got.Code != m.Code || got.MessageID != m.MessageID || !bytes.Equal(got.Token, m.Token) || !bytes.Equal(got.Payload, m.Payload) {
+		t.Errorf("Parse() = %+v, want %+v", got, m)
+	}
+	if path := got.Path(); path != "readings/"+string(long) {
+		t.Errorf("Path() = %.20q..., want readings/aaa...", path)
+	}
+	if format, ok := got.Uint(OptionContentFormat); !ok || format != FormatCBOR {
+		t.Errorf("Uint(Content-Format) = %d, %v, want %d", format, ok, FormatCBOR)
+	}
+	if value, ok := got.Option(2048); !ok || string(value) != "x" {
+		t.Errorf("Option(2048) = %q, %v, want x", value, ok)
+	}
+}
+
+func TestParse_RFCExample(t *testing.T) {
+	// GET /temperature, confirmable, from RFC 7252 appendix A.
+	data := []byte{0x40, 0x01, 0x7d, 0x34, 0xbb, 't', 'e', 'm', 'p', 'e', 'r', 'a', 't', 'u', 'r', 'e'}
+
+	m, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if m.Type != Confirmable || m.Code != GET || m.MessageID != 0x7d34 || m.Path() != "temperature" {
+		t.Errorf("Parse() = %+v, want CON GET /temperature with ID 0x7d34", m)
+	}
+	if got := m.Append(nil); !bytes.Equal(got, data) {
+		t.Errorf("Append() = % x, want % x", got, data)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"short", []byte{0x40, 0x01}},
+		{"version 2", []byte{0x80, 0x01, 0, 1}},
+		{"token too long", []byte{0x49, 0x01, 0, 1, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"truncated token", []byte{0x44, 0x01, 0, 1, 1}},
+		{"empty payload", []byte{0x40, 0x02, 0, 1, 0xff}},
+		{"reserved delta", []byte{0x40, 0x02, 0, 1, 0xf0}},
+		{"option past the end", []byte{0x40, 0x02, 0, 1, 0xb5, 'a'}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data); !errors.Is(err, ErrFormat) {
+				t.Errorf("Parse() error = %v, want ErrFormat", err)
+			}
+		})
+	}
+}
+
+func TestCode_String(t *testing.T) {
+	if got := TooManyRequests.String(); got != "4.29" {
+		t.Errorf("String() = %s, want 4.29", got)
+	}
+}
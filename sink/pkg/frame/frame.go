@@ -0,0 +1,85 @@
+// Package frame defines the length-framed protobuf protocol the sink accepts over
+// plain TCP, for devices that can't speak gRPC or HTTP/2.
+//
+// Requests and responses are frames with a fixed header followed by the payload:
+//
+//	magic   [4]byte  "\x00TLF"
+//	code    uint8    requests: TypeSensorData; responses: a gRPC status code
+//	length  uint32   big endian, payload size
+//	payload [length]byte
+//
+// A request carries a serialized SensorData. The sink answers every request, in
+// order, with a response whose payload is a serialized SensorDataResponse when the
+// code is 0 (OK) and the error message otherwise. A connection carries any number
+// of requests; the sink closes it on a malformed or oversized frame.
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// HeaderSize is the size of a frame header.
+	HeaderSize = len(magic) + 1 + 4
+
+	// TypeSensorData is the code of a request carrying a SensorData.
+	TypeSensorData = 1
+)
+
+var magic = [4]byte{0, 'T', 'L', 'F'}
+
+var (
+	// ErrMagic is returned for data that doesn't start with a frame header.
+	ErrMagic = errors.New("not a telemetry frame")
+	// ErrTooLarge is returned for frames whose payload exceeds the reader's limit.
+	ErrTooLarge = errors.New("frame too large")
+)
+
+// Append appends a frame with the given code and payload to dst.
+func Append(dst []byte, code uint8, payload []byte) []byte {
+	dst = append(dst, magic[:]...)
+	dst = append(dst, code)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(payload)))
+	return append(dst, payload...)
+}
+
+// Write writes a frame to w in one call, so concurrent writers on a connection
+// never interleave their frames.
+func Write(w io.Writer, code uint8, payload []byte) error {
+	_, err := w.Write(Append(make([]byte, 0, HeaderSize+len(payload)), code, payload))
+	return err
+}
+
+// Read reads the next frame from r. Payloads over maxSize bytes are not read and
+// return ErrTooLarge, leaving r in the middle of the frame. io.EOF is returned only
+// when r ends before a frame starts.
+func Read(r io.Reader, maxSize int) (code uint8, payload []byte, err error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, fmt.Errorf("read frame header: %w", err)
+		}
+		return 0, nil, err
+	}
+	if [4]byte(header[:4]) != magic {
+		return 0, nil, ErrMagic
+	}
+
+	code = header[4]
+	length := binary.BigEndian.Uint32(header[5:])
+	if uint64(length) > uint64(maxSize) {
+		return code, nil, fmt.Errorf("%w: %d bytes, limit is %d", ErrTooLarge, length, maxSize)
+	}
+
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return code, nil, fmt.Errorf("read frame payload: %w", err)
+	}
+	return code, payload, nil
+}
@@ -0,0 +1,54 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, TypeSensorData, []byte("reading")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := Write(&buf, 3, nil); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	code, payload, err := Read(&buf, 1024)
+	if err != nil || code != TypeSensorData || string(payload) != "reading" {
+		t.Errorf("Read() = %d, %q, %v, want the first frame", code, payload, err)
+	}
+	code, payload, err = Read(&buf, 1024)
+	if err != nil || code != 3 || len(payload) != 0 {
+		t.Errorf("Read() = %d, %q, %v, want the empty second frame", code, payload, err)
+	}
+	if _, _, err := Read(&buf, 1024); err != io.EOF {
+		t.Errorf("Read() at the end error = %v, want io.EOF", err)
+	}
+}
+
+func TestRead_Invalid(t *testing.T) {
+	frame := Append(nil, TypeSensorData, []byte("reading"))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		maxSize int
+		wantErr error
+	}{
+		{"bad magic", append([]byte("HTTP"), frame[4:]...), 1024, ErrMagic},
+		{"too large", frame, 6, ErrTooLarge},
+		{"truncated header", frame[:HeaderSize-1], 1024, io.ErrUnexpectedEOF},
+		{"truncated payload", frame[:len(frame)-1], 1024, io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := Read(bytes.NewReader(tt.data), tt.maxSize); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
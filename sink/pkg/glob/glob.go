@@ -0,0 +1,15 @@
+// Package glob compiles the name patterns of the sink's policy, pipeline and route
+// files, where '*' matches any sequence of characters and everything else matches
+// itself.
+package glob
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Compile turns a pattern where '*' matches any sequence into an anchored regexp.
+func Compile(pattern string) (*regexp.Regexp, error) {
+	quoted := regexp.QuoteMeta(pattern)
+	return regexp.Compile("^" + strings.ReplaceAll(quoted, `\*`, ".*") + "$")
+}
@@ -0,0 +1,30 @@
+package glob
+
+import "testing"
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"temp-*", "temp-01", true},
+		{"temp-*", "temp-", true},
+		{"temp-*", "xtemp-01", false},
+		{"*.example", "sensor.example", true},
+		{"*.example", "sensorxexample", false},
+		{"a*b*c", "a-b-c", true},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+		{"[x]+", "[x]+", true},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Fatalf("Compile(%q) error = %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.value); got != tt.want {
+			t.Errorf("Compile(%q) matches %q = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package logformat
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+
+	encryption "github.com/sink/encryptor"
+)
+
+// Encoder turns buffers of newline terminated entries into records of up to
+// ChunkSize bytes each, split at entry boundaries. It is not safe for concurrent use.
+type Encoder struct {
+	compress  bool
+	encryptor *encryption.Encryptor // nil stores payloads unencrypted
+	chunkSize int
+
+	compressed bytes.Buffer
+	flate      *flate.Writer
+	zstd       *zstd.Encoder // compresses instead of flate when set
+	zstdOut    []byte
+	sealed     []byte
+}
+
+// NewEncoder creates an encoder that compresses and, with a non-nil encryptor,
+// encrypts record payloads.
+func NewEncoder(compress bool, encryptor *encryption.Encryptor) *Encoder {
+	e := &Encoder{compress: compress, encryptor: encryptor, chunkSize: ChunkSize}
+	if compress {
+		// flate.NewWriter only fails for invalid levels.
+		e.flate, _ = flate.NewWriter(nil, flate.DefaultCompression)
+	}
+	return e
+}
+
+// NewZstdEncoder creates an encoder that compresses record payloads of up to
+// ZstdChunkSize with zstd at its best ratio and, with a non-nil encryptor, encrypts
+// them. It is several times slower than flate and meant for rewriting logs at rest.
+func NewZstdEncoder(encryptor *encryption.Encryptor) *Encoder {
+	// zstd.NewWriter only fails for invalid options.
+	enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression), zstd.WithEncoderConcurrency(1))
+	return &Encoder{compress: true, encryptor: encryptor, chunkSize: ZstdChunkSize, zstd: enc}
+}
+
+// Encode appends the records for buf to dst.
+func (e *Encoder) Encode(dst, buf []byte) ([]byte, error) {
+	for len(buf) > 0 {
+		n := chunkEnd(buf, e.chunkSize)
+		payload := buf[:n]
+		var flags Flags
+
+		if e.zstd != nil {
+			e.zstdOut = e.zstd.EncodeAll(payload, e.zstdOut[:0])
+			payload = e.zstdOut
+			flags |= FlagZstd
+		} else if e.compress {
+			e.compressed.Reset()
+			e.flate.Reset(&e.compressed)
+			if _, err := e.flate.Write(payload); err != nil {
+				return nil, fmt.Errorf("compress record: %w", err)
+			}
+			if err := e.flate.Close(); err != nil {
+				return nil, fmt.Errorf("compress record: %w", err)
+			}
+			payload = e.compressed.Bytes()
+			flags |= FlagCompressed
+		}
+
+		if e.encryptor != nil {
+			sealed, err := e.encryptor.EncryptAppend(e.sealed[:0], payload)
+			if err != nil {
+				return nil, fmt.Errorf("encrypt record: %w", err)
+			}
+			e.sealed = sealed
+			payload = sealed
+			flags |= FlagEncrypted
+		}
+
+		dst = AppendRecord(dst, flags, payload)
+		buf = buf[n:]
+	}
+
+	return dst, nil
+}
+
+// chunkEnd returns the length of the next chunk of buf: up to the last newline
+// within size, or the whole first entry if it is longer than that.
+func chunkEnd(buf []byte, size int) int {
+	if len(buf) <= size {
+		return len(buf)
+	}
+	if i := bytes.LastIndexByte(buf[:size], '\n'); i >= 0 {
+		return i + 1
+	}
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		return i + 1
+	}
+	return len(buf)
+}
@@ -0,0 +1,244 @@
+package logformat
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the entries the sink writes, stamped in their
+// schema field. Entries without one are version 1, written before entries were
+// stamped; Migrate upgrades them.
+const SchemaVersion = 2
+
+// Time formats of the times in log entries.
+const (
+	TimeRFC3339   = "rfc3339" // RFC 3339 strings with nanoseconds, the default
+	TimeUnix      = "unix"    // seconds since the epoch
+	TimeUnixMilli = "unix-ms" // milliseconds since the epoch
+	TimeUnixMicro = "unix-us" // microseconds since the epoch
+)
+
+// FieldNames are the default names of the top-level fields of log entries, which
+// Fields may rename.
+var FieldNames = []string{
+	"aggregate", "attachment", "attachment_id", "data_time", "event", "location", "metric", "priority",
+	"raw_value", "schema", "sealed_payload", "sensor_name", "sensor_value", "tags", "timestamp", "type", "values",
+}
+
+// Fields names the top-level fields of the JSON entries in a log and sets how their
+// times are written. A nil *Fields is the default: the FieldNames and TimeRFC3339.
+type Fields struct {
+	names  map[string]string // by default name, of the renamed fields
+	format string
+}
+
+// NewFields returns the fields renamed by names, from default to new name, with
+// times in format: one of the Time constants or a Go time layout, which is written
+// in UTC. It returns nil for the default.
+func NewFields(names map[string]string, format string) (*Fields, error) {
+	if format == "" {
+		format = TimeRFC3339
+	}
+	switch format {
+	case TimeRFC3339, TimeUnix, TimeUnixMilli, TimeUnixMicro:
+	default:
+		if err := checkLayout(format); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]string, len(FieldNames))
+	for _, field := range FieldNames {
+		name := field
+		if renamed, ok := names[field]; ok {
+			if err := checkName(renamed); err != nil {
+				return nil, fmt.Errorf("field %s: %w", field, err)
+			}
+			name = renamed
+		}
+		if other, ok := seen[name]; ok {
+			return nil, fmt.Errorf("fields %s and %s are both named %q", other, field, name)
+		}
+		seen[name] = field
+	}
+	for field := range names {
+		if !slices.Contains(FieldNames, field) {
+			return nil, fmt.Errorf("unknown field %q, want one of %s", field, strings.Join(FieldNames, ", "))
+		}
+	}
+
+	f := &Fields{names: make(map[string]string), format: format}
+	for field, name := range names {
+		if name != field {
+			f.names[field] = name
+		}
+	}
+	if len(f.names) == 0 && format == TimeRFC3339 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// ParseFieldNames parses a comma separated list of renamed fields, e.g.
+// "timestamp=ts,sensor_name=name", into the names NewFields takes.
+func ParseFieldNames(list string) (map[string]string, error) {
+	names := make(map[string]string)
+	for pair := range strings.SplitSeq(list, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		field, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not field=name", pair)
+		}
+		if _, ok := names[field]; ok {
+			return nil, fmt.Errorf("field %s renamed twice", field)
+		}
+		names[field] = name
+	}
+	return names, nil
+}
+
+// checkName accepts names that need no escaping in JSON.
+func checkName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	if !printable(name) {
+		return fmt.Errorf("name %q must be printable ASCII without quotes or backslashes", name)
+	}
+	return nil
+}
+
+// checkLayout accepts layouts that need no escaping in JSON and keep times to the
+// second, which compaction and retention read them back at.
+func checkLayout(layout string) error {
+	if !printable(layout) {
+		return fmt.Errorf("time layout %q must be printable ASCII without quotes or backslashes", layout)
+	}
+	sample := time.Date(2001, 2, 3, 16, 5, 6, 0, time.UTC)
+	if t, err := time.Parse(layout, sample.Format(layout)); err != nil || !t.Equal(sample) {
+		return fmt.Errorf("time layout %q must hold the date and the time to the second, like %q", layout, time.DateTime)
+	}
+	return nil
+}
+
+func printable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; b < 0x20 || b > 0x7e || b == '"' || b == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// Name returns the name of the field with the default name field.
+func (f *Fields) Name(field string) string {
+	if f != nil {
+		if name, ok := f.names[field]; ok {
+			return name
+		}
+	}
+	return field
+}
+
+// TimeFormat returns the format of times, one of the Time constants or a layout.
+func (f *Fields) TimeFormat() string {
+	if f == nil {
+		return TimeRFC3339
+	}
+	return f.format
+}
+
+// AppendTime appends t as a JSON value in the time format.
+func (f *Fields) AppendTime(dst []byte, t time.Time) []byte {
+	switch format := f.TimeFormat(); format {
+	case TimeRFC3339:
+		dst = append(dst, '"')
+		dst = t.AppendFormat(dst, time.RFC3339Nano)
+		return append(dst, '"')
+	case TimeUnix:
+		return strconv.AppendInt(dst, t.Unix(), 10)
+	case TimeUnixMilli:
+		return strconv.AppendInt(dst, t.UnixMilli(), 10)
+	case TimeUnixMicro:
+		return strconv.AppendInt(dst, t.UnixMicro(), 10)
+	default:
+		dst = append(dst, '"')
+		dst = t.UTC().AppendFormat(dst, format)
+		return append(dst, '"')
+	}
+}
+
+// ParseTime parses a time as AppendTime writes it.
+func (f *Fields) ParseTime(value []byte) (time.Time, error) {
+	var t time.Time
+	switch format := f.TimeFormat(); format {
+	case TimeRFC3339:
+		err := json.Unmarshal(value, &t)
+		return t, err
+	case TimeUnix, TimeUnixMilli, TimeUnixMicro:
+		n, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return t, fmt.Errorf("time %s is not in %s", value, format)
+		}
+		switch format {
+		case TimeUnix:
+			return time.Unix(n, 0), nil
+		case TimeUnixMilli:
+			return time.UnixMilli(n), nil
+		}
+		return time.UnixMicro(n), nil
+	default:
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return t, fmt.Errorf("time %s is not a string", value)
+		}
+		return time.Parse(format, s)
+	}
+}
+
+// EntryInfo is what compaction needs to know of an entry.
+type EntryInfo struct {
+	Received time.Time // the timestamp field
+	Schema   int       // SchemaVersion of the sink that wrote it
+}
+
+// Info returns when the sink received the JSON entry and its schema version.
+func (f *Fields) Info(entry []byte) (EntryInfo, error) {
+	var info EntryInfo
+	if f == nil {
+		var fields struct {
+			Timestamp time.Time `json:"timestamp"`
+			Schema    int       `json:"schema"`
+		}
+		if err := json.Unmarshal(entry, &fields); err != nil {
+			return info, err
+		}
+		return EntryInfo{Received: fields.Timestamp, Schema: max(fields.Schema, 1)}, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(entry, &fields); err != nil {
+		return info, err
+	}
+	value, ok := fields[f.Name("timestamp")]
+	if !ok {
+		return info, fmt.Errorf("no %s field", f.Name("timestamp"))
+	}
+	var err error
+	if info.Received, err = f.ParseTime(value); err != nil {
+		return info, err
+	}
+	info.Schema = 1
+	if value, ok := fields[f.Name("schema")]; ok {
+		if err := json.Unmarshal(value, &info.Schema); err != nil {
+			return info, fmt.Errorf("schema %s is not a number", value)
+		}
+	}
+	return info, nil
+}
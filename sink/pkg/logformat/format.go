@@ -0,0 +1,69 @@
+// Package logformat defines the binary record format of sink logs and reads logs in
+// any of the formats the sink writes. Fields describes the JSON entries they hold.
+//
+// A record is a fixed header followed by its payload:
+//
+//	magic   [4]byte  "\x00TLR"
+//	version uint8    1
+//	flags   uint8    FlagCompressed | FlagZstd | FlagEncrypted
+//	length  uint32   big endian, payload size
+//	crc     uint32   big endian, CRC-32C of the payload as stored
+//	payload [length]byte
+//
+// The payload holds one or more newline separated JSON entries, compressed with
+// flate, or zstd in compacted segments, and then encrypted when the flags say so. The first magic byte never starts
+// a text log line, so logs written in text format before switching formats remain
+// readable in the same file.
+package logformat
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// Version is the record format version written by this package.
+const Version = 1
+
+// Flags describe how a record payload is stored.
+type Flags uint8
+
+const (
+	FlagCompressed Flags = 1 << iota // payload is a flate stream
+	FlagEncrypted                    // payload is sealed by the sink's encryptor
+	FlagZstd                         // payload is a zstd frame
+)
+
+const (
+	// HeaderSize is the size of a record header.
+	HeaderSize = len(magic) + 1 + 1 + 4 + 4
+
+	// MaxRecordSize bounds a record payload and its decompressed contents, so a
+	// corrupt header can't make readers allocate without limit.
+	MaxRecordSize = 16 * 1024 * 1024
+
+	// ChunkSize is the largest amount of log data an Encoder puts in one record,
+	// unless a single entry is longer.
+	ChunkSize = 64 * 1024
+
+	// ZstdChunkSize is ChunkSize for zstd encoders, whose ratio gains from larger
+	// windows and which only write records of data at rest.
+	ZstdChunkSize = 1024 * 1024
+)
+
+var magic = [4]byte{0, 'T', 'L', 'R'}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// AppendRecord appends a record holding payload, stored as described by flags, to dst.
+func AppendRecord(dst []byte, flags Flags, payload []byte) []byte {
+	dst = appendHeader(dst, flags, payload)
+	return append(dst, payload...)
+}
+
+func appendHeader(dst []byte, flags Flags, payload []byte) []byte {
+	dst = append(dst, magic[:]...)
+	dst = append(dst, Version, byte(flags))
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(payload)))
+	dst = binary.BigEndian.AppendUint32(dst, crc32.Checksum(payload, crcTable))
+	return dst
+}
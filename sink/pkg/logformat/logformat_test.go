@@ -0,0 +1,271 @@
+package logformat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	encryption "github.com/sink/encryptor"
+)
+
+func newTestEncryptor(t testing.TB) *encryption.Encryptor {
+	t.Helper()
+
+	e, err := encryption.NewEncryptor(encryption.CipherChaCha20Poly1305, base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{5}, 32)))
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	return e
+}
+
+func readAll(t *testing.T, r *Reader) []string {
+	t.Helper()
+
+	var entries []string
+	for {
+		entry, err := r.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		entries = append(entries, string(entry))
+	}
+}
+
+func TestReader_MixedFormats(t *testing.T) {
+	e := newTestEncryptor(t)
+
+	var (
+		want []string
+		buf  []byte
+	)
+	for i := 0; i < 3000; i++ {
+		entry := fmt.Sprintf(`{"sensor_name":"sensor-%d","sensor_value":%d}`, i%50, i)
+		want = append(want, entry)
+		buf = append(buf, entry...)
+		buf = append(buf, '\n')
+	}
+
+	// A text line, a base64 line encrypted per entry, compressed and encrypted
+	// records, a compressed record, an entry record and a final unterminated line.
+	log := []byte("{\"text\":1}\n")
+	sealed, err := e.Encrypt([]byte(`{"base64":2}`))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	log = base64.StdEncoding.AppendEncode(log, sealed)
+	log = append(log, '\n')
+
+	if log, err = NewEncoder(true, e).Encode(log, buf); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if log, err = NewEncoder(true, nil).Encode(log, []byte("{\"compressed\":3}\n")); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if sealed, err = e.Encrypt([]byte(`{"record":4}`)); err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	log = AppendRecord(log, FlagEncrypted, sealed)
+	log = append(log, `{"tail":5}`...)
+
+	want = append(append([]string{`{"text":1}`, `{"base64":2}`}, want...), `{"compressed":3}`, `{"record":4}`, `{"tail":5}`)
+
+	got := readAll(t, NewReader(bytes.NewReader(log), e))
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Reader returned %d entries, want %d matching entries", len(got), len(want))
+	}
+}
+
+func TestEncoder_ChunksAndCompresses(t *testing.T) {
+	var buf []byte
+	for i := 0; len(buf) < 3*ChunkSize; i++ {
+		buf = fmt.Appendf(buf, `{"sensor_name":"s","sensor_value":%d}`+"\n", i)
+	}
+
+	encoded, err := NewEncoder(true, nil).Encode(nil, buf)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	if records := bytes.Count(encoded, magic[:]); records < 3 {
+		t.Errorf("Encode() wrote %d records, want at least 3 for %d bytes", records, len(buf))
+	}
+	if len(encoded) > len(buf)/2 {
+		t.Errorf("Encode() wrote %d bytes for %d bytes of entries, want compression", len(encoded), len(buf))
+	}
+}
+
+func TestZstdEncoder(t *testing.T) {
+	e := newTestEncryptor(t)
+
+	var (
+		want []string
+		buf  []byte
+	)
+	for i := 0; len(buf) < ZstdChunkSize+ChunkSize; i++ {
+		entry := fmt.Sprintf(`{"sensor_name":"s","sensor_value":%d}`, i)
+		want = append(want, entry)
+		buf = append(buf, entry...)
+		buf = append(buf, '\n')
+	}
+
+	flated, err := NewEncoder(true, e).Encode(nil, buf)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	encoded, err := NewZstdEncoder(e).Encode(nil, buf)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	if records := bytes.Count(encoded, magic[:]); records != 2 {
+		t.Errorf("zstd Encode() wrote %d records, want 2 for %d bytes", records, len(buf))
+	}
+	if len(encoded) >= len(flated) {
+		t.Errorf("zstd Encode() wrote %d bytes, want fewer than flate's %d", len(encoded), len(flated))
+	}
+	got := readAll(t, NewReader(bytes.NewReader(encoded), e))
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Reader returned %d entries, want %d matching entries", len(got), len(want))
+	}
+}
+
+func TestReader_Errors(t *testing.T) {
+	e := newTestEncryptor(t)
+	record, err := NewEncoder(true, e).Encode(nil, []byte("{\"a\":1}\n"))
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	corrupt := bytes.Clone(record)
+	corrupt[len(corrupt)-1] ^= 1
+
+	newVersion := bytes.Clone(record)
+	newVersion[len(magic)] = Version + 1
+
+	tests := []struct {
+		name      string
+		data      []byte
+		encryptor *encryption.Encryptor
+		entryErr  bool // the reader can continue after the error
+	}{
+		{name: "no key", data: record, entryErr: true},
+		{name: "checksum mismatch", data: corrupt, encryptor: e, entryErr: true},
+		{name: "encrypted line without key", data: []byte("AQID\n"), entryErr: true},
+		{name: "truncated", data: record[:len(record)-3], encryptor: e},
+		{name: "unknown version", data: newVersion, encryptor: e},
+		{name: "oversized length", data: append(magic[:], Version, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0), encryptor: e},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReader(bytes.NewReader(tt.data), tt.encryptor).Next()
+			if err == nil || err == io.EOF {
+				t.Fatalf("Next() error = %v, want a read error", err)
+			}
+			if errors.Is(err, ErrEntry) != tt.entryErr {
+				t.Errorf("Next() error = %v, ErrEntry = %v, want %v", err, errors.Is(err, ErrEntry), tt.entryErr)
+			}
+		})
+	}
+}
+
+func TestReader_ContinuesAfterCorruptRecord(t *testing.T) {
+	corrupt := AppendRecord(nil, 0, []byte(`{"a":1}`))
+	corrupt[len(corrupt)-1] ^= 1
+	log := append(corrupt, "{\"b\":2}\n"...)
+
+	r := NewReader(bytes.NewReader(log), nil)
+	if _, err := r.Next(); !errors.Is(err, ErrEntry) {
+		t.Fatalf("first Next() error = %v, want ErrEntry", err)
+	}
+	entry, err := r.Next()
+	if err != nil || string(entry) != `{"b":2}` {
+		t.Errorf("second Next() = %s, %v, want {\"b\":2}", entry, err)
+	}
+}
+
+func FuzzReader(f *testing.F) {
+	e := newTestEncryptor(f)
+
+	f.Add([]byte("{\"a\":1}\n\n{\"b\":2}"))
+	f.Add(AppendRecord(nil, 0, []byte("{\"a\":1}\n")))
+	f.Add(AppendRecord(nil, FlagCompressed|FlagEncrypted, []byte("not compressed")))
+	f.Add(append(magic[:], 1, 0, 0xff, 0xff, 0xff, 0xff))
+	if chunk, err := NewEncoder(true, e).Encode(nil, []byte("{\"a\":1}\n")); err == nil {
+		f.Add(chunk)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := NewReader(bytes.NewReader(data), e)
+		for i := 0; i <= len(data); i++ {
+			if _, err := r.Next(); err != nil && !errors.Is(err, ErrEntry) {
+				return
+			}
+		}
+		t.Errorf("Next() returned more than %d entries for %d bytes", len(data)+1, len(data))
+	})
+}
+
+func TestNewFields(t *testing.T) {
+	if f, err := NewFields(map[string]string{"metric": "metric"}, TimeRFC3339); err != nil || f != nil {
+		t.Errorf("NewFields() of the default = %v, %v, want nil", f, err)
+	}
+	for _, tt := range []struct {
+		names  map[string]string
+		format string
+		want   string
+	}{
+		{map[string]string{"sensor": "name"}, "", "unknown field"},
+		{map[string]string{"sensor_name": "metric"}, "", "both named"},
+		{map[string]string{"tags": `t"`}, "", "printable"},
+		{nil, "15:04", "to the second"},
+	} {
+		if _, err := NewFields(tt.names, tt.format); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("NewFields(%v, %q) error = %v, want %q", tt.names, tt.format, err, tt.want)
+		}
+	}
+
+	names, err := ParseFieldNames("timestamp=ts, sensor_name=name")
+	if err != nil || len(names) != 2 || names["timestamp"] != "ts" || names["sensor_name"] != "name" {
+		t.Errorf("ParseFieldNames() = %v, %v", names, err)
+	}
+	if _, err := ParseFieldNames("timestamp"); err == nil {
+		t.Error("ParseFieldNames() accepted a field without a name")
+	}
+}
+
+func TestFields_Time(t *testing.T) {
+	ts := time.Date(2024, 1, 15, 10, 30, 5, 123456789, time.FixedZone("CET", 3600))
+	for _, tt := range []struct {
+		format string
+		want   string
+		parsed time.Time
+	}{
+		{TimeRFC3339, `"2024-01-15T10:30:05.123456789+01:00"`, ts},
+		{TimeUnix, "1705311005", ts.Truncate(time.Second)},
+		{TimeUnixMilli, "1705311005123", ts.Truncate(time.Millisecond)},
+		{TimeUnixMicro, "1705311005123456", ts.Truncate(time.Microsecond)},
+		{"2006-01-02 15:04:05.000", `"2024-01-15 09:30:05.123"`, ts.Truncate(time.Millisecond)},
+	} {
+		f, err := NewFields(map[string]string{"timestamp": "ts"}, tt.format)
+		if err != nil {
+			t.Fatalf("NewFields(%q) error = %v", tt.format, err)
+		}
+		got := f.AppendTime(nil, ts)
+		if string(got) != tt.want {
+			t.Errorf("AppendTime() in %s = %s, want %s", tt.format, got, tt.want)
+		}
+		entry := fmt.Appendf(nil, `{"ts":%s,"timestamp":"other"}`, got)
+		if info, err := f.Info(entry); err != nil || !info.Received.Equal(tt.parsed) || info.Schema != 1 {
+			t.Errorf("Info() in %s = %+v, %v, want received at %v with schema 1", tt.format, info, err, tt.parsed)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package logformat
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// timeFields are the fields holding times, written in the time format of Fields.
+var timeFields = []string{"data_time", "timestamp"}
+
+// Migrate upgrades a JSON entry written with from to the current SchemaVersion and
+// writes it with to: fields renamed, times reformatted and the schema stamped. Fields
+// are written in the order the sink writes them, followed by any it doesn't know.
+func Migrate(entry []byte, from, to *Fields) ([]byte, error) {
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(entry, &named); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEntry, err)
+	}
+	fields := make(map[string]json.RawMessage, len(named))
+	for name, value := range named {
+		fields[from.field(name)] = value
+	}
+
+	schema := 1
+	if value, ok := fields["schema"]; ok {
+		if err := json.Unmarshal(value, &schema); err != nil {
+			return nil, fmt.Errorf("%w: schema %s is not a number", ErrEntry, value)
+		}
+	}
+	if schema > SchemaVersion {
+		return nil, fmt.Errorf("%w: schema %d is newer than %d", ErrEntry, schema, SchemaVersion)
+	}
+	// Version 1 differs from 2 only in the stamp.
+	fields["schema"] = json.RawMessage(fmt.Appendf(nil, "%d", SchemaVersion))
+
+	for _, field := range timeFields {
+		value, ok := fields[field]
+		if !ok {
+			continue
+		}
+		t, err := from.ParseTime(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrEntry, field, err)
+		}
+		fields[field] = to.AppendTime(nil, t)
+	}
+
+	dst := []byte{'{'}
+	appendField := func(name string, value []byte) {
+		if len(dst) > 1 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, '"')
+		dst = append(dst, name...)
+		dst = append(dst, `":`...)
+		dst = append(dst, value...)
+	}
+	for _, field := range FieldNames {
+		if value, ok := fields[field]; ok {
+			appendField(to.Name(field), value)
+			delete(fields, field)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		if to.field(name) != name {
+			return nil, fmt.Errorf("%w: field %s clashes with the new name of %s", ErrEntry, name, to.field(name))
+		}
+		key, _ := json.Marshal(name)
+		appendField(string(key[1:len(key)-1]), fields[name])
+	}
+	return append(dst, '}'), nil
+}
+
+// field returns the default name of the field named name.
+func (f *Fields) field(name string) string {
+	if f != nil {
+		for field, renamed := range f.names {
+			if renamed == name {
+				return field
+			}
+		}
+	}
+	return name
+}
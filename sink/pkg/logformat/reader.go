@@ -0,0 +1,188 @@
+package logformat
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+
+	encryption "github.com/sink/encryptor"
+)
+
+// Reader returns the plain JSON entries of a sink log, whichever way they were
+// written: text lines, base64 lines encrypted per entry, or binary records.
+type Reader struct {
+	r         *bufio.Reader
+	encryptor *encryption.Encryptor // nil fails on encrypted entries
+	pending   []byte                // entries left from the last record
+	line      []byte                // scratch space for long and decrypted lines
+}
+
+// NewReader creates a reader. The encryptor is only needed for encrypted logs; its
+// cipher doesn't matter as every entry names the cipher that sealed it.
+func NewReader(r io.Reader, encryptor *encryption.Encryptor) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64*1024), encryptor: encryptor}
+}
+
+// Next returns the next entry, or io.EOF at the end of the log. The entry is only
+// valid until the following call. Errors in a record's framing leave the reader at an
+// unknown position, so callers should stop at the first error other than ErrEntry.
+func (l *Reader) Next() ([]byte, error) {
+	for {
+		if len(l.pending) > 0 {
+			entry := l.pending
+			if i := bytes.IndexByte(entry, '\n'); i >= 0 {
+				entry, l.pending = entry[:i], entry[i+1:]
+			} else {
+				l.pending = nil
+			}
+			if len(entry) > 0 {
+				return entry, nil
+			}
+			continue
+		}
+
+		prefix, err := l.r.Peek(len(magic))
+		if err == nil && bytes.Equal(prefix, magic[:]) {
+			if l.pending, err = l.readRecord(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		line, err := l.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		return l.decodeLine(line)
+	}
+}
+
+// ErrEntry marks an entry that could not be decoded. The reader stays positioned
+// after it, so reading can continue.
+var ErrEntry = errors.New("invalid entry")
+
+// decodeLine decrypts a text line encrypted per entry. JSON entries start with a
+// brace, which base64 never does.
+func (l *Reader) decodeLine(line []byte) ([]byte, error) {
+	if line[0] == '{' {
+		return line, nil
+	}
+	if l.encryptor == nil {
+		return nil, fmt.Errorf("%w: encrypted entry found, an encryption key is required", ErrEntry)
+	}
+
+	ciphertext, err := base64.StdEncoding.AppendDecode(nil, line)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode encrypted line: %v", ErrEntry, err)
+	}
+	entry, err := l.encryptor.Decrypt(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEntry, err)
+	}
+	return entry, nil
+}
+
+func (l *Reader) readLine() ([]byte, error) {
+	line, err := l.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		l.line = append(l.line[:0], line...)
+		for errors.Is(err, bufio.ErrBufferFull) {
+			if len(l.line) > MaxRecordSize {
+				return nil, fmt.Errorf("line longer than %d bytes", MaxRecordSize)
+			}
+			line, err = l.r.ReadSlice('\n')
+			l.line = append(l.line, line...)
+		}
+		line = l.line
+	}
+	if len(line) == 0 && err != nil {
+		return nil, err
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return line, nil
+}
+
+// readRecord reads a record and returns its entries.
+func (l *Reader) readRecord() ([]byte, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(l.r, header[:]); err != nil {
+		return nil, fmt.Errorf("read record header: %w", unexpectedEOF(err))
+	}
+
+	version := header[len(magic)]
+	flags := Flags(header[len(magic)+1])
+	size := binary.BigEndian.Uint32(header[len(magic)+2:])
+	checksum := binary.BigEndian.Uint32(header[len(magic)+6:])
+
+	if version != Version {
+		return nil, fmt.Errorf("unsupported record version %d", version)
+	}
+	if size > MaxRecordSize {
+		return nil, fmt.Errorf("record of %d bytes exceeds limit", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(l.r, payload); err != nil {
+		return nil, fmt.Errorf("read record: %w", unexpectedEOF(err))
+	}
+	// The record is consumed, so failures from here on affect only this record.
+	if crc32.Checksum(payload, crcTable) != checksum {
+		return nil, fmt.Errorf("%w: record checksum mismatch", ErrEntry)
+	}
+
+	if flags&FlagEncrypted != 0 {
+		if l.encryptor == nil {
+			return nil, fmt.Errorf("%w: encrypted record found, an encryption key is required", ErrEntry)
+		}
+		var err error
+		if payload, err = l.encryptor.Decrypt(payload); err != nil {
+			return nil, fmt.Errorf("%w: decrypt record: %v", ErrEntry, err)
+		}
+	}
+
+	if flags&FlagZstd != 0 {
+		entries, err := zstdDecoder.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: decompress record: %v", ErrEntry, err)
+		}
+		payload = entries
+	} else if flags&FlagCompressed != 0 {
+		fr := flate.NewReader(bytes.NewReader(payload))
+		defer fr.Close()
+
+		entries, err := io.ReadAll(io.LimitReader(fr, MaxRecordSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: decompress record: %v", ErrEntry, err)
+		}
+		if len(entries) > MaxRecordSize {
+			return nil, fmt.Errorf("%w: record decompresses to more than %d bytes", ErrEntry, MaxRecordSize)
+		}
+		payload = entries
+	}
+
+	return payload, nil
+}
+
+// zstdDecoder decompresses zstd records. It is safe for concurrent DecodeAll calls,
+// and fails on frames decompressing to more than MaxRecordSize.
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(MaxRecordSize))
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
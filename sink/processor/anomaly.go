@@ -0,0 +1,109 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sink/anomaly"
+	"github.com/sink/state"
+)
+
+func init() {
+	Register("anomaly", newAnomalyProcessor)
+}
+
+// anomalyProcessor tags readings that deviate from their sensor's recent values and
+// optionally raises alerts for them. Series are tracked per tenant and sensor.
+type anomalyProcessor struct {
+	detector anomaly.Detector
+	notifier anomaly.Notifier // nil when alerting is disabled
+}
+
+func newAnomalyProcessor(config *yaml.Node) (Processor, error) {
+	cfg := struct {
+		Detector  string  `yaml:"detector"`
+		Alpha     float64 `yaml:"alpha"`
+		Threshold float64 `yaml:"threshold"`
+		Warmup    int     `yaml:"warmup"`
+		Webhook   string  `yaml:"webhook"` // URL alerts are POSTed to
+
+		MaxSeries int           `yaml:"max_series"` // 0 is unlimited
+		SeriesTTL time.Duration `yaml:"series_ttl"` // 0 keeps idle series forever
+	}{
+		Detector:  "ewma",
+		Alpha:     0.1,
+		Threshold: 3,
+		Warmup:    30,
+		MaxSeries: state.DefaultMaxEntries,
+		SeriesTTL: 24 * time.Hour,
+	}
+	if err := decodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+
+	p := &anomalyProcessor{}
+	switch cfg.Detector {
+	case "ewma":
+		if cfg.Alpha <= 0 || cfg.Alpha > 1 {
+			return nil, fmt.Errorf("alpha must be in (0, 1]")
+		}
+		if cfg.Threshold <= 0 {
+			return nil, fmt.Errorf("threshold must be positive")
+		}
+		p.detector = anomaly.NewEWMA(cfg.Alpha, cfg.Threshold, cfg.Warmup, state.Limits{TTL: cfg.SeriesTTL, MaxEntries: cfg.MaxSeries})
+	default:
+		return nil, fmt.Errorf("unknown detector %q", cfg.Detector)
+	}
+
+	if cfg.Webhook != "" {
+		p.notifier = anomaly.NewWebhook(cfg.Webhook, 5*time.Second)
+	}
+
+	return p, nil
+}
+
+func (p *anomalyProcessor) Process(_ context.Context, entry *Entry) (*Entry, error) {
+	value := entry.SensorValue
+	switch {
+	case entry.Aggregate != nil:
+		// Aggregates are scored by the mean of their samples.
+		if entry.Aggregate.Count == 0 {
+			return entry, nil
+		}
+		value = entry.Aggregate.Mean()
+	case !entry.Scalar():
+		// A sealed value is only known to readers holding the sensor's key; combined
+		// multi-value entries and events have no single value to score.
+		return entry, nil
+	}
+
+	score, anomalous := p.detector.Observe(entry.Tenant+"/"+entry.Series(), value)
+	if !anomalous {
+		return entry, nil
+	}
+
+	entry.SetTag("anomaly", "true")
+	entry.SetTag("anomaly_score", strconv.FormatFloat(score, 'f', 2, 64))
+
+	if p.notifier != nil {
+		p.notifier.Notify(anomaly.Alert{
+			SensorName: entry.Series(),
+			Tenant:     entry.Tenant,
+			Value:      value,
+			Score:      score,
+			DataTime:   entry.DataTime,
+		})
+	}
+	return entry, nil
+}
+
+func (p *anomalyProcessor) Close() error {
+	if p.notifier == nil {
+		return nil
+	}
+	return p.notifier.Close()
+}
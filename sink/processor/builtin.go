@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sink/pkg/glob"
+)
+
+func init() {
+	Register("tags", newTagsProcessor)
+	Register("filter", newFilterProcessor)
+}
+
+// tagsProcessor attaches static tags, e.g. the site or region a sink serves.
+type tagsProcessor struct {
+	tags     map[string]string
+	override bool // replace tags the client already set
+}
+
+func newTagsProcessor(config *yaml.Node) (Processor, error) {
+	var cfg struct {
+		Tags     map[string]string `yaml:"tags"`
+		Override bool              `yaml:"override"`
+	}
+	if err := decodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Tags) == 0 {
+		return nil, fmt.Errorf("at least one tag is required")
+	}
+
+	return &tagsProcessor{tags: cfg.Tags, override: cfg.Override}, nil
+}
+
+func (p *tagsProcessor) Process(_ context.Context, entry *Entry) (*Entry, error) {
+	for key, value := range p.tags {
+		if _, exists := entry.Tags[key]; exists && !p.override {
+			continue
+		}
+		entry.SetTag(key, value)
+	}
+	return entry, nil
+}
+
+// filterProcessor drops entries by sensor name or tag value.
+type filterProcessor struct {
+	dropSensors []*regexp.Regexp
+	dropTags    map[string]*regexp.Regexp
+}
+
+func newFilterProcessor(config *yaml.Node) (Processor, error) {
+	var cfg struct {
+		DropSensors []string          `yaml:"drop_sensors"`
+		DropTags    map[string]string `yaml:"drop_tags"` // tag name to value pattern
+	}
+	if err := decodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.DropSensors) == 0 && len(cfg.DropTags) == 0 {
+		return nil, fmt.Errorf("drop_sensors or drop_tags is required")
+	}
+
+	p := &filterProcessor{dropTags: make(map[string]*regexp.Regexp)}
+	for _, pattern := range cfg.DropSensors {
+		re, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("drop_sensors: %w", err)
+		}
+		p.dropSensors = append(p.dropSensors, re)
+	}
+	for key, pattern := range cfg.DropTags {
+		re, err := glob.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("drop_tags %s: %w", key, err)
+		}
+		p.dropTags[key] = re
+	}
+
+	return p, nil
+}
+
+func (p *filterProcessor) Process(_ context.Context, entry *Entry) (*Entry, error) {
+	for _, re := range p.dropSensors {
+		if re.MatchString(entry.SensorName) {
+			return nil, nil
+		}
+	}
+	for key, re := range p.dropTags {
+		if value, ok := entry.Tags[key]; ok && re.MatchString(value) {
+			return nil, nil
+		}
+	}
+	return entry, nil
+}
@@ -0,0 +1,201 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sink/pkg/glob"
+)
+
+func init() {
+	Register("calibrate", newCalibrateProcessor)
+}
+
+// registryOffsetTag is the tag the registry stage stores a device's calibration
+// offset in.
+const registryOffsetTag = "calibration_offset"
+
+// calibrateProcessor applies per-sensor linear corrections. The first rule matching
+// the entry's series (the sensor name, or "sensor/metric" for values split from a
+// multi-value reading) is used; values are converted between units, then scaled,
+// offset and clamped, in that order.
+type calibrateProcessor struct {
+	keepRaw bool
+	rules   []*calibrationRule
+}
+
+type calibrationRule struct {
+	Sensors        []string        `yaml:"sensors"`
+	Unit           *unitConversion `yaml:"unit"`
+	Scale          *float64        `yaml:"scale"` // default 1
+	Offset         float64         `yaml:"offset"`
+	RegistryOffset bool            `yaml:"registry_offset"` // add the device registry's calibration_offset
+	Min            *float64        `yaml:"min"`
+	Max            *float64        `yaml:"max"`
+
+	sensors []*regexp.Regexp
+}
+
+type unitConversion struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+
+	convert func(float64) float64
+}
+
+func newCalibrateProcessor(config *yaml.Node) (Processor, error) {
+	var cfg struct {
+		KeepRaw bool               `yaml:"keep_raw"` // store the reported value as raw_value
+		Rules   []*calibrationRule `yaml:"rules"`
+	}
+	if err := decodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Rules) == 0 {
+		return nil, fmt.Errorf("at least one rule is required")
+	}
+
+	for i, rule := range cfg.Rules {
+		if len(rule.Sensors) == 0 {
+			return nil, fmt.Errorf("rule %d: at least one sensor pattern is required", i+1)
+		}
+		for _, pattern := range rule.Sensors {
+			re, err := glob.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: sensors: %w", i+1, err)
+			}
+			rule.sensors = append(rule.sensors, re)
+		}
+		if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+			return nil, fmt.Errorf("rule %d: min %v is above max %v", i+1, *rule.Min, *rule.Max)
+		}
+		if rule.Unit != nil {
+			convert, err := converter(rule.Unit.From, rule.Unit.To)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: unit: %w", i+1, err)
+			}
+			rule.Unit.convert = convert
+		}
+	}
+
+	return &calibrateProcessor{keepRaw: cfg.KeepRaw, rules: cfg.Rules}, nil
+}
+
+func (p *calibrateProcessor) Process(_ context.Context, entry *Entry) (*Entry, error) {
+	rule := p.ruleFor(entry.Series())
+	if rule == nil || !entry.Scalar() {
+		return entry, nil
+	}
+
+	value := rule.apply(entry)
+	if p.keepRaw && entry.RawValue == nil {
+		raw := entry.SensorValue
+		entry.RawValue = &raw
+	}
+	entry.SensorValue = value
+	return entry, nil
+}
+
+func (p *calibrateProcessor) ruleFor(sensorName string) *calibrationRule {
+	for _, rule := range p.rules {
+		for _, re := range rule.sensors {
+			if re.MatchString(sensorName) {
+				return rule
+			}
+		}
+	}
+	return nil
+}
+
+func (r *calibrationRule) apply(entry *Entry) float64 {
+	value := entry.SensorValue
+
+	if r.Unit != nil {
+		value = r.Unit.convert(value)
+	}
+	if r.Scale != nil {
+		value *= *r.Scale
+	}
+	value += r.Offset
+	if r.RegistryOffset {
+		if tag, ok := entry.Tags[registryOffsetTag]; ok {
+			if offset, err := strconv.ParseFloat(tag, 64); err == nil {
+				value += offset
+			} else {
+				log.Printf("ignoring invalid %s tag %q on %s", registryOffsetTag, tag, entry.SensorName)
+			}
+		}
+	}
+	if r.Min != nil {
+		value = math.Max(value, *r.Min)
+	}
+	if r.Max != nil {
+		value = math.Min(value, *r.Max)
+	}
+
+	return value
+}
+
+// unit describes how to convert a unit to and from the base unit of its quantity.
+type unit struct {
+	quantity string
+	toBase   func(float64) float64
+	fromBase func(float64) float64
+}
+
+func linear(factor float64) (func(float64) float64, func(float64) float64) {
+	return func(v float64) float64 { return v * factor }, func(v float64) float64 { return v / factor }
+}
+
+var units = func() map[string]unit {
+	u := map[string]unit{
+		"celsius": {
+			quantity: "temperature",
+			toBase:   func(v float64) float64 { return v + 273.15 },
+			fromBase: func(v float64) float64 { return v - 273.15 },
+		},
+		"fahrenheit": {
+			quantity: "temperature",
+			toBase:   func(v float64) float64 { return (v-32)*5/9 + 273.15 },
+			fromBase: func(v float64) float64 { return (v-273.15)*9/5 + 32 },
+		},
+		"kelvin": {
+			quantity: "temperature",
+			toBase:   func(v float64) float64 { return v },
+			fromBase: func(v float64) float64 { return v },
+		},
+	}
+
+	for name, factor := range map[string]float64{"pa": 1, "hpa": 100, "kpa": 1000, "bar": 100000, "psi": 6894.757293168} {
+		to, from := linear(factor)
+		u[name] = unit{quantity: "pressure", toBase: to, fromBase: from}
+	}
+	for name, factor := range map[string]float64{"mm": 0.001, "cm": 0.01, "m": 1, "in": 0.0254, "ft": 0.3048} {
+		to, from := linear(factor)
+		u[name] = unit{quantity: "length", toBase: to, fromBase: from}
+	}
+
+	return u
+}()
+
+func converter(from, to string) (func(float64) float64, error) {
+	f, ok := units[from]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %q", from)
+	}
+	t, ok := units[to]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %q", to)
+	}
+	if f.quantity != t.quantity {
+		return nil, fmt.Errorf("cannot convert %s (%s) to %s (%s)", from, f.quantity, to, t.quantity)
+	}
+
+	return func(v float64) float64 { return t.fromBase(f.toBase(v)) }, nil
+}
@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestCalibrateProcessor(t *testing.T) {
+	chain, err := parseChain([]byte(`
+processors:
+  - type: calibrate
+    config:
+      keep_raw: true
+      rules:
+        - sensors: ["thermistor-*"]
+          scale: 1.02
+          offset: -0.5
+          registry_offset: true
+          min: -40
+          max: 125
+        - sensors: ["outdoor-*"]
+          unit: {from: fahrenheit, to: celsius}
+        - sensors: ["tank-*"]
+          unit: {from: psi, to: bar}
+        - sensors: ["weather-*/temperature"]
+          unit: {from: fahrenheit, to: celsius}
+`))
+	if err != nil {
+		t.Fatalf("parseChain() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		entry   Entry
+		want    float64
+		wantRaw *float64
+	}{
+		{
+			name:    "linear correction",
+			entry:   Entry{SensorName: "thermistor-1", SensorValue: 50},
+			want:    50.5,
+			wantRaw: ptr(50),
+		},
+		{
+			name:    "registry offset",
+			entry:   Entry{SensorName: "thermistor-1", SensorValue: 50, Tags: map[string]string{"calibration_offset": "-0.4"}},
+			want:    50.1,
+			wantRaw: ptr(50),
+		},
+		{
+			name:    "clamped",
+			entry:   Entry{SensorName: "thermistor-2", SensorValue: 200},
+			want:    125,
+			wantRaw: ptr(200),
+		},
+		{
+			name:    "temperature conversion",
+			entry:   Entry{SensorName: "outdoor-1", SensorValue: 212},
+			want:    100,
+			wantRaw: ptr(212),
+		},
+		{
+			name:    "pressure conversion",
+			entry:   Entry{SensorName: "tank-1", SensorValue: 100},
+			want:    6.894757293168,
+			wantRaw: ptr(100),
+		},
+		{
+			name:    "metric of a multi-value reading",
+			entry:   Entry{SensorName: "weather-1", Metric: "temperature", SensorValue: 212},
+			want:    100,
+			wantRaw: ptr(212),
+		},
+		{
+			name:  "other metric of a multi-value reading",
+			entry: Entry{SensorName: "weather-1", Metric: "humidity", SensorValue: 40},
+			want:  40,
+		},
+		{
+			name:  "no matching rule",
+			entry: Entry{SensorName: "humidity-1", SensorValue: 40},
+			want:  40,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := tt.entry
+			got, err := chain.Process(context.Background(), &entry)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if math.Abs(got.SensorValue-tt.want) > 1e-9 {
+				t.Errorf("SensorValue = %v, want %v", got.SensorValue, tt.want)
+			}
+			switch {
+			case tt.wantRaw == nil && got.RawValue != nil:
+				t.Errorf("RawValue = %v, want nil", *got.RawValue)
+			case tt.wantRaw != nil && (got.RawValue == nil || *got.RawValue != *tt.wantRaw):
+				t.Errorf("RawValue = %v, want %v", got.RawValue, *tt.wantRaw)
+			}
+		})
+	}
+}
+
+func TestCalibrateProcessor_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "no sensors", data: "processors:\n  - type: calibrate\n    config:\n      rules:\n        - offset: 1\n"},
+		{name: "unknown unit", data: "processors:\n  - type: calibrate\n    config:\n      rules:\n        - sensors: [a]\n          unit: {from: celsius, to: rankine}\n"},
+		{name: "mismatched units", data: "processors:\n  - type: calibrate\n    config:\n      rules:\n        - sensors: [a]\n          unit: {from: celsius, to: bar}\n"},
+		{name: "min above max", data: "processors:\n  - type: calibrate\n    config:\n      rules:\n        - sensors: [a]\n          min: 10\n          max: 0\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseChain([]byte(tt.data)); err == nil {
+				t.Error("parseChain() should fail")
+			}
+		})
+	}
+}
+
+func ptr(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,231 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sink/errdefs"
+)
+
+func init() {
+	Register("cel", newCELProcessor)
+}
+
+// ErrInvalid is wrapped by the errors of stages rejecting an entry as invalid, which
+// is the client's fault rather than the sink's. It is an errdefs.ErrInvalid.
+var ErrInvalid = errdefs.Wrap(errdefs.ErrInvalid, errors.New("invalid entry"))
+
+// celEnv declares the variables expressions see of an entry:
+//
+//	sensor    string               sensor name
+//	metric    string               name of a value split from a multi-value reading
+//	value     double               the value, 0 unless scalar
+//	scalar    bool                 whether the entry has a single value in the clear
+//	values    map(string, double)  values of a combined multi-value reading
+//	tags      map(string, string)
+//	tenant    string
+//	critical  bool
+//	event     bool                 whether the entry is an event
+//	severity  string               an event's severity
+//	time      timestamp            device timestamp
+//
+// Numbers of different types compare, so value > 100 works as written.
+var celEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("sensor", cel.StringType),
+		cel.Variable("metric", cel.StringType),
+		cel.Variable("value", cel.DoubleType),
+		cel.Variable("scalar", cel.BoolType),
+		cel.Variable("values", cel.MapType(cel.StringType, cel.DoubleType)),
+		cel.Variable("tags", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("tenant", cel.StringType),
+		cel.Variable("critical", cel.BoolType),
+		cel.Variable("event", cel.BoolType),
+		cel.Variable("severity", cel.StringType),
+		cel.Variable("time", cel.TimestampType),
+		cel.CrossTypeNumericComparisons(true),
+	)
+})
+
+// celExpr is a compiled expression.
+type celExpr struct {
+	source  string
+	program cel.Program
+}
+
+// compileCEL compiles source, which must evaluate to want.
+func compileCEL(source string, want *cel.Type) (*celExpr, error) {
+	env, err := celEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := env.Compile(source)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("compile %q: %w", source, iss.Err())
+	}
+	if !ast.OutputType().IsExactType(want) {
+		return nil, fmt.Errorf("%q evaluates to %s, want %s", source, ast.OutputType(), want)
+	}
+	program, err := env.Program(ast, cel.EvalOptions(cel.OptOptimize))
+	if err != nil {
+		return nil, fmt.Errorf("compile %q: %w", source, err)
+	}
+	return &celExpr{source: source, program: program}, nil
+}
+
+// eval evaluates the expression on the entry's variables.
+func (e *celExpr) eval(ctx context.Context, vars map[string]any) (any, error) {
+	out, _, err := e.program.ContextEval(ctx, vars)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate %q: %w", e.source, err)
+	}
+	return out.Value(), nil
+}
+
+// evalBool evaluates an expression compiled to evaluate to a bool.
+func (e *celExpr) evalBool(ctx context.Context, vars map[string]any) (bool, error) {
+	out, err := e.eval(ctx, vars)
+	if err != nil {
+		return false, err
+	}
+	b, _ := out.(bool)
+	return b, nil
+}
+
+// celVars returns the variables of entry.
+func celVars(entry *Entry) map[string]any {
+	vars := map[string]any{
+		"sensor":   entry.SensorName,
+		"metric":   entry.Metric,
+		"value":    0.0,
+		"scalar":   entry.Scalar(),
+		"values":   entry.Values,
+		"tags":     entry.Tags,
+		"tenant":   entry.Tenant,
+		"critical": entry.Critical,
+		"event":    entry.Event != nil,
+		"severity": "",
+		"time":     entry.DataTime,
+	}
+	if entry.Scalar() {
+		vars["value"] = entry.SensorValue
+	}
+	if entry.Values == nil {
+		vars["values"] = map[string]float64{}
+	}
+	if entry.Tags == nil {
+		vars["tags"] = map[string]string{}
+	}
+	if entry.Event != nil {
+		vars["severity"] = entry.Event.Severity
+	}
+	return vars
+}
+
+// celProcessor validates entries, drops them and computes tags with CEL expressions.
+type celProcessor struct {
+	validate []celRule
+	drop     *celExpr // nil keeps every entry
+	tags     []celTag // in tag name order
+}
+
+type celRule struct {
+	expr    *celExpr
+	message string
+}
+
+type celTag struct {
+	name string
+	expr *celExpr
+}
+
+func newCELProcessor(config *yaml.Node) (Processor, error) {
+	var cfg struct {
+		Validate []struct {
+			Expr    string `yaml:"expr"`
+			Message string `yaml:"message"`
+		} `yaml:"validate"`
+		Drop string            `yaml:"drop"`
+		Tags map[string]string `yaml:"tags"` // tag name to expression
+	}
+	if err := decodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Validate) == 0 && cfg.Drop == "" && len(cfg.Tags) == 0 {
+		return nil, fmt.Errorf("validate, drop or tags is required")
+	}
+
+	p := &celProcessor{}
+	for i, rule := range cfg.Validate {
+		expr, err := compileCEL(rule.Expr, cel.BoolType)
+		if err != nil {
+			return nil, fmt.Errorf("validate rule %d: %w", i+1, err)
+		}
+		if rule.Message == "" {
+			rule.Message = "fails " + rule.Expr
+		}
+		p.validate = append(p.validate, celRule{expr: expr, message: rule.Message})
+	}
+	if cfg.Drop != "" {
+		expr, err := compileCEL(cfg.Drop, cel.BoolType)
+		if err != nil {
+			return nil, fmt.Errorf("drop: %w", err)
+		}
+		p.drop = expr
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Tags)) {
+		expr, err := compileCEL(cfg.Tags[name], cel.StringType)
+		if err != nil {
+			return nil, fmt.Errorf("tag %s: %w", name, err)
+		}
+		p.tags = append(p.tags, celTag{name: name, expr: expr})
+	}
+
+	return p, nil
+}
+
+// Process rejects an entry failing a validation rule, then drops it or sets the
+// computed tags. Tags are computed from the entry as it arrived, so their order
+// doesn't matter.
+func (p *celProcessor) Process(ctx context.Context, entry *Entry) (*Entry, error) {
+	vars := celVars(entry)
+
+	for _, rule := range p.validate {
+		ok, err := rule.expr.evalBool(ctx, vars)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalid, rule.message)
+		}
+	}
+	if p.drop != nil {
+		drop, err := p.drop.evalBool(ctx, vars)
+		if err != nil {
+			return nil, err
+		}
+		if drop {
+			return nil, nil
+		}
+	}
+
+	values := make([]string, len(p.tags))
+	for i, tag := range p.tags {
+		out, err := tag.expr.eval(ctx, vars)
+		if err != nil {
+			return nil, fmt.Errorf("tag %s: %w", tag.name, err)
+		}
+		values[i] = out.(string)
+	}
+	for i, tag := range p.tags {
+		entry.SetTag(tag.name, values[i])
+	}
+	return entry, nil
+}
@@ -0,0 +1,104 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCELProcessor(t *testing.T) {
+	chain, err := parseChain([]byte(`
+processors:
+  - type: cel
+    config:
+      validate:
+        - expr: '!scalar || (value >= -50 && value <= 150)'
+          message: temperature out of range
+      drop: 'sensor.startsWith("test-")'
+      tags:
+        level: 'value > 100 ? "high" : "normal"'
+        zone: '"site" in tags ? tags.site + "/" + sensor : sensor'
+`))
+	if err != nil {
+		t.Fatalf("parseChain() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		entry   *Entry
+		tags    map[string]string // nil when dropped
+		invalid bool
+	}{
+		{
+			name:  "normal",
+			entry: &Entry{SensorName: "temp-1", SensorValue: 21, Tags: map[string]string{"site": "berlin"}},
+			tags:  map[string]string{"site": "berlin", "level": "normal", "zone": "berlin/temp-1"},
+		},
+		{
+			name:  "high",
+			entry: &Entry{SensorName: "temp-2", SensorValue: 120},
+			tags:  map[string]string{"level": "high", "zone": "temp-2"},
+		},
+		{
+			name:  "event",
+			entry: &Entry{SensorName: "door", Event: &Event{Severity: "info"}},
+			tags:  map[string]string{"level": "normal", "zone": "door"},
+		},
+		{
+			name:  "dropped",
+			entry: &Entry{SensorName: "test-1", SensorValue: 21},
+		},
+		{
+			name:    "invalid",
+			entry:   &Entry{SensorName: "temp-3", SensorValue: 500},
+			invalid: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := chain.Process(context.Background(), tt.entry)
+			if tt.invalid {
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("Process() error = %v, want ErrInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if tt.tags == nil {
+				if got != nil {
+					t.Errorf("Process() = %+v, want it dropped", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("Process() dropped the entry")
+			}
+			if len(got.Tags) != len(tt.tags) {
+				t.Errorf("tags = %v, want %v", got.Tags, tt.tags)
+			}
+			for k, v := range tt.tags {
+				if got.Tags[k] != v {
+					t.Errorf("tag %s = %q, want %q", k, got.Tags[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestCELProcessor_InvalidConfig(t *testing.T) {
+	for name, config := range map[string]string{
+		"empty":         "{}",
+		"syntax":        "drop: 'value >'",
+		"unknown":       "drop: 'humidity > 3'",
+		"not a bool":    "drop: 'sensor'",
+		"not a string":  "tags: {level: 'value'}",
+		"validate type": "validate: [{expr: '1 + 2'}]",
+	} {
+		_, err := parseChain([]byte("processors:\n  - type: cel\n    config: " + config + "\n"))
+		if err == nil {
+			t.Errorf("parseChain(%s) error = nil, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sink/registry"
+)
+
+func init() {
+	Register("registry", newRegistryProcessor)
+}
+
+// registryProcessor attaches device metadata from the device registry as tags.
+// Registry values replace tags of the same name set by the client.
+type registryProcessor struct {
+	registry registry.Registry
+}
+
+func newRegistryProcessor(config *yaml.Node) (Processor, error) {
+	cfg := struct {
+		File    string        `yaml:"file"` // static YAML registry
+		URL     string        `yaml:"url"`  // HTTP registry, must contain {sensor}
+		TTL     time.Duration `yaml:"ttl"`
+		Timeout time.Duration `yaml:"timeout"`
+	}{
+		TTL:     5 * time.Minute,
+		Timeout: 2 * time.Second,
+	}
+	if err := decodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case cfg.File != "" && cfg.URL != "":
+		return nil, fmt.Errorf("file and url are mutually exclusive")
+	case cfg.File != "":
+		static, err := registry.LoadStatic(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		return &registryProcessor{registry: static}, nil
+	case cfg.URL != "":
+		remote, err := registry.NewHTTP(cfg.URL, cfg.TTL, cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return &registryProcessor{registry: remote}, nil
+	default:
+		return nil, fmt.Errorf("file or url is required")
+	}
+}
+
+func (p *registryProcessor) Process(ctx context.Context, entry *Entry) (*Entry, error) {
+	device, err := p.registry.Lookup(ctx, entry.SensorName)
+	if err != nil {
+		// A registry outage shouldn't stop ingestion; the entry is stored as sent.
+		log.Printf("device registry lookup for %s failed: %v", entry.SensorName, err)
+		return entry, nil
+	}
+	if device == nil {
+		return entry, nil
+	}
+
+	for key, value := range device.Attributes() {
+		entry.SetTag(key, value)
+	}
+	return entry, nil
+}
@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"fmt"
+	"plugin"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pluginSymbol is the constructor a Go plugin must export:
+//
+//	func NewProcessor(config map[string]any) (processor.Processor, error)
+//
+// The plugin has to be built against the same version of this module and toolchain
+// as the sink (go build -buildmode=plugin).
+const pluginSymbol = "NewProcessor"
+
+func init() {
+	Register("plugin", newPluginProcessor)
+}
+
+func newPluginProcessor(config *yaml.Node) (Processor, error) {
+	var cfg struct {
+		Path   string         `yaml:"path"`
+		Config map[string]any `yaml:"config"`
+	}
+	if err := decodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+
+	plug, err := plugin.Open(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("open plugin: %w", err)
+	}
+	sym, err := plug.Lookup(pluginSymbol)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", cfg.Path, err)
+	}
+	constructor, ok := sym.(func(map[string]any) (Processor, error))
+	if !ok {
+		return nil, fmt.Errorf("plugin %s: %s has type %T", cfg.Path, pluginSymbol, sym)
+	}
+
+	return constructor(cfg.Config)
+}
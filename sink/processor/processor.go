@@ -0,0 +1,252 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Entry is an accepted reading on its way to storage.
+type Entry struct {
+	Timestamp   time.Time // server receive time
+	SensorName  string
+	SensorValue float64            // reported value, after any transforms
+	Metric      string             // name of the value when split from a multi-value reading
+	Values      map[string]float64 // values of a combined multi-value reading, SensorValue is unused
+	Aggregate   *Aggregate         // samples aggregated by the device, SensorValue is unused
+	Event       *Event             // set for events, which carry no value; their attributes are in Tags
+	Location    *Location          // where a mobile sensor took the reading, nil when not reported
+	Attachment  []byte             // binary payload sent with the reading, stored inline
+	// AttachmentID names the sidecar file the attachment was stored in instead
+	AttachmentID string
+	RawValue     *float64  // value as reported when a transform kept it, nil otherwise
+	Sealed       []byte    // value and tags encrypted by the sensor node, opaque to the sink
+	DataTime     time.Time // device timestamp
+	Critical     bool
+	Tags         map[string]string
+	Tenant       string // not persisted, available for tenant-specific stages
+}
+
+// Aggregate is the count and sum of samples a device aggregated itself, and with
+// Buckets a histogram of them.
+type Aggregate struct {
+	Count   uint64
+	Sum     float64
+	Buckets []Bucket // ascending upper bounds, empty for a count/sum summary
+}
+
+// Bucket counts the samples above the previous bucket's upper bound up to and
+// including UpperBound.
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Mean returns the mean of the samples, 0 when there are none.
+func (a *Aggregate) Mean() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Sum / float64(a.Count)
+}
+
+// Location is a WGS 84 position in degrees.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  *float64 // meters above sea level, nil when unknown
+}
+
+// Event is a state transition or error a device reported.
+type Event struct {
+	Severity string // debug, info, warning, error or critical
+	Message  string
+}
+
+// Scalar reports whether the entry's value is SensorValue in the clear, rather than
+// sealed, combined, aggregated or absent as for events.
+func (e *Entry) Scalar() bool {
+	return e.Sealed == nil && e.Values == nil && e.Aggregate == nil && e.Event == nil
+}
+
+// Series names what the entry's value measures: the sensor name, followed by "/" and
+// the metric for a value split from a multi-value reading.
+func (e *Entry) Series() string {
+	if e.Metric == "" {
+		return e.SensorName
+	}
+	return e.SensorName + "/" + e.Metric
+}
+
+// SetTag sets a tag, allocating the tag map if needed.
+func (e *Entry) SetTag(key, value string) {
+	if e.Tags == nil {
+		e.Tags = make(map[string]string)
+	}
+	e.Tags[key] = value
+}
+
+// Processor is a stage of the ingestion pipeline. It returns the entry to pass on,
+// which may be modified in place, or nil to drop the entry without an error.
+// Processors holding resources may also implement io.Closer; after a reload Close can
+// run while requests are still using the old stage, so Process must stay safe to call.
+type Processor interface {
+	Process(ctx context.Context, entry *Entry) (*Entry, error)
+}
+
+// Factory creates a processor from the stage's config block, which is empty when the
+// stage has none.
+type Factory func(config *yaml.Node) (Processor, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a processor type available to pipeline configs. It panics if the
+// type is registered twice, so it is meant to be called from init functions.
+func Register(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("processor: type %q registered twice", name))
+	}
+	factories[name] = factory
+}
+
+// Types returns the registered processor types.
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	types := make([]string, 0, len(factories))
+	for name := range factories {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Stage is one step of a pipeline config.
+type Stage struct {
+	Name   string    `yaml:"name"`
+	Type   string    `yaml:"type"`
+	Config yaml.Node `yaml:"config"`
+}
+
+type namedProcessor struct {
+	name string
+	Processor
+}
+
+// Chain runs processors in order.
+type Chain struct {
+	stages []namedProcessor
+}
+
+// NewChain builds a chain from already constructed processors, named by position.
+func NewChain(processors ...Processor) *Chain {
+	c := &Chain{}
+	for i, p := range processors {
+		c.stages = append(c.stages, namedProcessor{name: fmt.Sprintf("stage-%d", i+1), Processor: p})
+	}
+	return c
+}
+
+// LoadChain reads a YAML pipeline file and builds its processors.
+func LoadChain(path string) (*Chain, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read pipeline file: %w", err)
+	}
+
+	return parseChain(data)
+}
+
+func parseChain(data []byte) (*Chain, error) {
+	var file struct {
+		Processors []Stage `yaml:"processors"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse pipeline file: %w", err)
+	}
+
+	return Build(file.Processors)
+}
+
+// Build constructs a chain from stage configs.
+func Build(stages []Stage) (*Chain, error) {
+	c := &Chain{}
+	for i, stage := range stages {
+		if stage.Name == "" {
+			stage.Name = fmt.Sprintf("%s-%d", stage.Type, i+1)
+		}
+
+		factoriesMu.RLock()
+		factory, ok := factories[stage.Type]
+		factoriesMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("stage %s: unknown processor type %q", stage.Name, stage.Type)
+		}
+
+		p, err := factory(&stage.Config)
+		if err != nil {
+			return nil, fmt.Errorf("stage %s: %w", stage.Name, err)
+		}
+		c.stages = append(c.stages, namedProcessor{name: stage.Name, Processor: p})
+	}
+
+	return c, nil
+}
+
+// Len returns the number of stages in the chain.
+func (c *Chain) Len() int {
+	return len(c.stages)
+}
+
+// Process runs the entry through every stage. It returns nil if a stage dropped it.
+func (c *Chain) Process(ctx context.Context, entry *Entry) (*Entry, error) {
+	for _, stage := range c.stages {
+		var err error
+		if entry, err = stage.Process(ctx, entry); err != nil {
+			return nil, fmt.Errorf("processor %s: %w", stage.name, err)
+		}
+		if entry == nil {
+			return nil, nil
+		}
+	}
+	return entry, nil
+}
+
+// Close releases the resources of stages implementing io.Closer.
+func (c *Chain) Close() error {
+	var errs []error
+	for _, stage := range c.stages {
+		if closer, ok := stage.Processor.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("processor %s: %w", stage.name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodeConfig decodes a stage's config block into v, leaving v untouched when the
+// block is empty.
+func decodeConfig(node *yaml.Node, v any) error {
+	if node == nil || node.Kind == 0 {
+		return nil
+	}
+	if err := node.Decode(v); err != nil {
+		return fmt.Errorf("decode config: %w", err)
+	}
+	return nil
+}
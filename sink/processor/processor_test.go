@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseChain(t *testing.T) {
+	chain, err := parseChain([]byte(`
+processors:
+  - type: tags
+    config:
+      tags:
+        site: plant-1
+        region: eu
+  - name: drop-test-sensors
+    type: filter
+    config:
+      drop_sensors: ["test-*"]
+      drop_tags:
+        env: staging
+`))
+	if err != nil {
+		t.Fatalf("parseChain() error = %v", err)
+	}
+	if chain.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", chain.Len())
+	}
+
+	tests := []struct {
+		name     string
+		entry    Entry
+		wantTags map[string]string
+		dropped  bool
+	}{
+		{
+			name:     "tags added",
+			entry:    Entry{SensorName: "temperature-01"},
+			wantTags: map[string]string{"site": "plant-1", "region": "eu"},
+		},
+		{
+			name:     "client tags kept",
+			entry:    Entry{SensorName: "temperature-01", Tags: map[string]string{"site": "lab"}},
+			wantTags: map[string]string{"site": "lab", "region": "eu"},
+		},
+		{
+			name:    "dropped by sensor name",
+			entry:   Entry{SensorName: "test-probe"},
+			dropped: true,
+		},
+		{
+			name:    "dropped by tag",
+			entry:   Entry{SensorName: "temperature-01", Tags: map[string]string{"env": "staging"}},
+			dropped: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := tt.entry
+			got, err := chain.Process(context.Background(), &entry)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if tt.dropped {
+				if got != nil {
+					t.Errorf("Process() = %+v, want dropped", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("Process() dropped the entry")
+			}
+			if !reflect.DeepEqual(got.Tags, tt.wantTags) {
+				t.Errorf("Process() tags = %v, want %v", got.Tags, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestParseChain_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "unknown type", data: "processors:\n  - type: nope\n"},
+		{name: "tags without tags", data: "processors:\n  - type: tags\n"},
+		{name: "filter without rules", data: "processors:\n  - type: filter\n"},
+		{name: "plugin without path", data: "processors:\n  - type: plugin\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseChain([]byte(tt.data)); err == nil {
+				t.Error("parseChain() should fail")
+			}
+		})
+	}
+}
+
+type failingProcessor struct{}
+
+func (failingProcessor) Process(context.Context, *Entry) (*Entry, error) {
+	return nil, errors.New("boom")
+}
+
+func TestChain_StopsOnError(t *testing.T) {
+	called := false
+	chain := NewChain(failingProcessor{}, processorFunc(func(e *Entry) *Entry {
+		called = true
+		return e
+	}))
+
+	if _, err := chain.Process(context.Background(), &Entry{}); err == nil {
+		t.Error("Process() should return the stage error")
+	}
+	if called {
+		t.Error("Stages after a failing stage should not run")
+	}
+}
+
+type processorFunc func(*Entry) *Entry
+
+func (f processorFunc) Process(_ context.Context, e *Entry) (*Entry, error) {
+	return f(e), nil
+}
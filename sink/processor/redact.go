@@ -0,0 +1,134 @@
+package processor
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/sink/pkg/glob"
+)
+
+func init() {
+	Register("redact", newRedactProcessor)
+}
+
+// redactProcessor drops or pseudonymizes identifying fields so they never reach
+// storage in cleartext. Hashes are keyed, so the same identifier always maps to the
+// same value but cannot be recovered by hashing candidate identifiers.
+type redactProcessor struct {
+	key   []byte
+	rules []*redactRule
+}
+
+type redactRule struct {
+	Tenants        []string `yaml:"tenants"` // tenant patterns, empty applies to every tenant
+	DropTags       []string `yaml:"drop_tags"`
+	HashTags       []string `yaml:"hash_tags"`
+	HashSensorName bool     `yaml:"hash_sensor_name"`
+
+	tenants []*regexp.Regexp
+}
+
+func newRedactProcessor(config *yaml.Node) (Processor, error) {
+	var cfg struct {
+		KeyFile string        `yaml:"key_file"` // base64 encoded HMAC key
+		Rules   []*redactRule `yaml:"rules"`
+	}
+	if err := decodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Rules) == 0 {
+		return nil, fmt.Errorf("at least one rule is required")
+	}
+
+	p := &redactProcessor{rules: cfg.Rules}
+	hashing := false
+	for i, rule := range cfg.Rules {
+		for _, pattern := range rule.Tenants {
+			re, err := glob.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: tenants: %w", i+1, err)
+			}
+			rule.tenants = append(rule.tenants, re)
+		}
+		hashing = hashing || rule.HashSensorName || len(rule.HashTags) > 0
+	}
+
+	if hashing {
+		if cfg.KeyFile == "" {
+			return nil, fmt.Errorf("key_file is required for hashing")
+		}
+		key, err := loadKey(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		p.key = key
+	}
+
+	return p, nil
+}
+
+func (p *redactProcessor) Process(_ context.Context, entry *Entry) (*Entry, error) {
+	for _, rule := range p.rules {
+		if !rule.appliesTo(entry.Tenant) {
+			continue
+		}
+
+		for _, key := range rule.DropTags {
+			delete(entry.Tags, key)
+		}
+		for _, key := range rule.HashTags {
+			if value, ok := entry.Tags[key]; ok {
+				entry.Tags[key] = p.hash(value)
+			}
+		}
+		if rule.HashSensorName {
+			entry.SensorName = p.hash(entry.SensorName)
+		}
+	}
+	return entry, nil
+}
+
+func (p *redactProcessor) hash(value string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(value))
+	return "hmac:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (r *redactRule) appliesTo(tenant string) bool {
+	if len(r.tenants) == 0 {
+		return true
+	}
+	for _, re := range r.tenants {
+		if re.MatchString(tenant) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadKey reads a base64 encoded key from a file.
+func loadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key: %w", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decode key: %w", err)
+	}
+	if len(key) < 16 {
+		return nil, fmt.Errorf("key must be at least 16 bytes long")
+	}
+
+	return key, nil
+}
@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactProcessor(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "redact.key")
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	if err := os.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	chain, err := parseChain([]byte(`
+processors:
+  - type: redact
+    config:
+      key_file: ` + keyFile + `
+      rules:
+        - drop_tags: ["serial"]
+        - tenants: ["hospital-*"]
+          hash_tags: ["patient"]
+          hash_sensor_name: true
+`))
+	if err != nil {
+		t.Fatalf("parseChain() error = %v", err)
+	}
+
+	newEntry := func(tenant string) *Entry {
+		return &Entry{
+			SensorName: "pump-17",
+			Tenant:     tenant,
+			Tags:       map[string]string{"serial": "SN-0042", "patient": "jane", "ward": "3"},
+		}
+	}
+
+	other, err := chain.Process(context.Background(), newEntry("factory"))
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if _, ok := other.Tags["serial"]; ok {
+		t.Error("serial tag should be dropped for every tenant")
+	}
+	if other.Tags["patient"] != "jane" || other.SensorName != "pump-17" {
+		t.Errorf("Process() = %+v, hashing should only apply to hospital tenants", other)
+	}
+
+	hospital, err := chain.Process(context.Background(), newEntry("hospital-north"))
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if !strings.HasPrefix(hospital.Tags["patient"], "hmac:") || strings.Contains(hospital.Tags["patient"], "jane") {
+		t.Errorf("patient tag = %q, want a keyed hash", hospital.Tags["patient"])
+	}
+	if !strings.HasPrefix(hospital.SensorName, "hmac:") {
+		t.Errorf("SensorName = %q, want a keyed hash", hospital.SensorName)
+	}
+	if hospital.Tags["ward"] != "3" {
+		t.Errorf("ward tag = %q, want untouched", hospital.Tags["ward"])
+	}
+
+	again, _ := chain.Process(context.Background(), newEntry("hospital-south"))
+	if again.Tags["patient"] != hospital.Tags["patient"] {
+		t.Error("Hashing should be stable so pseudonymized values can still be correlated")
+	}
+}
+
+func TestRedactProcessor_HashRequiresKey(t *testing.T) {
+	_, err := parseChain([]byte(`
+processors:
+  - type: redact
+    config:
+      rules:
+        - hash_tags: ["serial"]
+`))
+	if err == nil {
+		t.Error("parseChain() should fail when hashing without a key")
+	}
+}
@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+
+	"github.com/google/cel-go/cel"
+	"gopkg.in/yaml.v3"
+
+	"github.com/sink/pkg/glob"
+)
+
+// Routes selects the entries each output stores. An output with rules stores the
+// entries matching any of them; an output without rules stores every entry.
+type Routes struct {
+	outputs map[string][]routeRule
+}
+
+// routeRule matches entries meeting all of its conditions.
+type routeRule struct {
+	sensors  []*regexp.Regexp          // any of them, every sensor when empty
+	tags     map[string]*regexp.Regexp // tag name to value pattern, all of them
+	min, max *float64                  // bounds of the value, inclusive
+	expr     *celExpr                  // CEL condition, nil when none
+}
+
+// LoadRoutes reads a YAML routes file.
+func LoadRoutes(path string) (*Routes, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read routes file: %w", err)
+	}
+
+	return parseRoutes(data)
+}
+
+func parseRoutes(data []byte) (*Routes, error) {
+	var file struct {
+		Outputs map[string][]struct {
+			Sensors []string          `yaml:"sensors"`
+			Tags    map[string]string `yaml:"tags"`
+			Min     *float64          `yaml:"min"`
+			Max     *float64          `yaml:"max"`
+			Expr    string            `yaml:"expr"`
+		} `yaml:"outputs"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse routes file: %w", err)
+	}
+
+	r := &Routes{outputs: make(map[string][]routeRule)}
+	for output, rules := range file.Outputs {
+		if len(rules) == 0 {
+			return nil, fmt.Errorf("output %s: at least one rule is required", output)
+		}
+		for i, cfg := range rules {
+			rule := routeRule{tags: make(map[string]*regexp.Regexp), min: cfg.Min, max: cfg.Max}
+			if rule.min != nil && rule.max != nil && *rule.min > *rule.max {
+				return nil, fmt.Errorf("output %s rule %d: min is above max", output, i+1)
+			}
+			if cfg.Expr != "" {
+				expr, err := compileCEL(cfg.Expr, cel.BoolType)
+				if err != nil {
+					return nil, fmt.Errorf("output %s rule %d: expr: %w", output, i+1, err)
+				}
+				rule.expr = expr
+			}
+			for _, pattern := range cfg.Sensors {
+				re, err := glob.Compile(pattern)
+				if err != nil {
+					return nil, fmt.Errorf("output %s rule %d: sensors: %w", output, i+1, err)
+				}
+				rule.sensors = append(rule.sensors, re)
+			}
+			for key, pattern := range cfg.Tags {
+				re, err := glob.Compile(pattern)
+				if err != nil {
+					return nil, fmt.Errorf("output %s rule %d: tags %s: %w", output, i+1, key, err)
+				}
+				rule.tags[key] = re
+			}
+			r.outputs[output] = append(r.outputs[output], rule)
+		}
+	}
+
+	return r, nil
+}
+
+// Outputs returns the outputs with rules, sorted.
+func (r *Routes) Outputs() []string {
+	return slices.Sorted(maps.Keys(r.outputs))
+}
+
+// Select returns the entries output stores. It returns entries itself when the output
+// stores them all, and otherwise a new slice. A nil Routes selects every entry.
+func (r *Routes) Select(output string, entries []*Entry) []*Entry {
+	if r == nil {
+		return entries
+	}
+	rules, ok := r.outputs[output]
+	if !ok {
+		return entries
+	}
+
+	var selected []*Entry
+	for _, entry := range entries {
+		if slices.ContainsFunc(rules, func(rule routeRule) bool { return rule.match(entry) }) {
+			selected = append(selected, entry)
+		}
+	}
+	if len(selected) == len(entries) {
+		return entries
+	}
+	return selected
+}
+
+// match reports whether entry meets the rule. Only scalar entries have a value, so
+// the others never meet a rule with bounds. An expression failing to evaluate, e.g.
+// on a tag the entry lacks, isn't met either.
+func (rule *routeRule) match(entry *Entry) bool {
+	if len(rule.sensors) > 0 && !slices.ContainsFunc(rule.sensors, func(re *regexp.Regexp) bool { return re.MatchString(entry.SensorName) }) {
+		return false
+	}
+	for key, re := range rule.tags {
+		if value, ok := entry.Tags[key]; !ok || !re.MatchString(value) {
+			return false
+		}
+	}
+	if rule.min != nil || rule.max != nil {
+		if !entry.Scalar() {
+			return false
+		}
+		if rule.min != nil && entry.SensorValue < *rule.min || rule.max != nil && entry.SensorValue > *rule.max {
+			return false
+		}
+	}
+	if rule.expr != nil {
+		ok, err := rule.expr.evalBool(context.Background(), celVars(entry))
+		return ok && err == nil
+	}
+	return true
+}
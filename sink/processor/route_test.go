@@ -0,0 +1,81 @@
+package processor
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	routes, err := parseRoutes([]byte(`
+outputs:
+  remote-write:
+    - sensors: ["power.*"]
+      min: 0
+      max: 1000
+    - tags: {priority: critical}
+  postgres:
+    - sensors: ["temp-*", "hum-*"]
+      tags: {site: "berlin-*"}
+    - expr: 'tags.floor == "3" && value > 10'
+`))
+	if err != nil {
+		t.Fatalf("parseRoutes() error = %v", err)
+	}
+	if got, want := routes.Outputs(), []string{"postgres", "remote-write"}; !slices.Equal(got, want) {
+		t.Errorf("Outputs() = %v, want %v", got, want)
+	}
+
+	var (
+		power     = &Entry{SensorName: "power.main", SensorValue: 230}
+		surge     = &Entry{SensorName: "power.main", SensorValue: 5000}
+		combined  = &Entry{SensorName: "power.phases", Values: map[string]float64{"l1": 230}}
+		critical  = &Entry{SensorName: "door", Tags: map[string]string{"priority": "critical"}}
+		berlin    = &Entry{SensorName: "temp-1", Tags: map[string]string{"site": "berlin-3"}}
+		elsewhere = &Entry{SensorName: "temp-2", Tags: map[string]string{"site": "paris-1"}}
+		untagged  = &Entry{SensorName: "hum-1"}
+		floor     = &Entry{SensorName: "co2", SensorValue: 800, Tags: map[string]string{"floor": "3"}}
+	)
+	entries := []*Entry{power, surge, combined, critical, berlin, elsewhere, untagged, floor}
+
+	tests := []struct {
+		output string
+		want   []*Entry
+	}{
+		{"remote-write", []*Entry{power, critical}},
+		{"postgres", []*Entry{berlin, floor}},
+		{"log", entries},
+	}
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			if got := routes.Select(tt.output, entries); !slices.Equal(got, tt.want) {
+				t.Errorf("Select() = %v, want %v", names(got), names(tt.want))
+			}
+		})
+	}
+
+	var none *Routes
+	if got := none.Select("log", entries); !slices.Equal(got, entries) {
+		t.Errorf("nil Routes Select() = %v, want every entry", names(got))
+	}
+}
+
+func TestParseRoutes_Invalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"no rules":   "outputs:\n  log: []\n",
+		"min > max":  "outputs:\n  log:\n    - {min: 2, max: 1}\n",
+		"not a list": "outputs:\n  log: {sensors: [a]}\n",
+		"bad expr":   "outputs:\n  log:\n    - {expr: 'sensor'}\n",
+	} {
+		if _, err := parseRoutes([]byte(data)); err == nil {
+			t.Errorf("parseRoutes(%s) error = nil, want an error", name)
+		}
+	}
+}
+
+func names(entries []*Entry) []string {
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.SensorName)
+	}
+	return names
+}
@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	Register("units", newUnitsProcessor)
+}
+
+// Tags of the units stage.
+const (
+	// UnitTag holds the unit a value is in. The sink sets it from a reading's unit
+	// field; the units stage rewrites it to the canonical unit.
+	UnitTag = "unit"
+	// OriginalUnitTag keeps the unit the device declared when the value was converted.
+	OriginalUnitTag = "original_unit"
+)
+
+// canonicalUnits are the units each quantity is normalized to by default.
+var canonicalUnits = map[string]string{
+	"temperature": "celsius",
+	"pressure":    "kpa",
+	"length":      "m",
+}
+
+// unitAliases maps symbols and spellings devices report to the names of units.
+var unitAliases = map[string]string{
+	"°c": "celsius", "℃": "celsius", "degc": "celsius", "c": "celsius",
+	"°f": "fahrenheit", "℉": "fahrenheit", "degf": "fahrenheit", "f": "fahrenheit",
+	"kelvins": "kelvin", "k": "kelvin",
+	"pascal": "pa", "hectopascal": "hpa", "kilopascal": "kpa", "mbar": "hpa", "millibar": "hpa",
+	"meter": "m", "metre": "m", "inch": "in", "foot": "ft", "feet": "ft",
+}
+
+// lookupUnit returns the name of the unit reported as name, in any case and by its
+// symbol or name.
+func lookupUnit(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := unitAliases[name]; ok {
+		name = alias
+	}
+	_, ok := units[name]
+	return name, ok
+}
+
+// unitsProcessor converts values to the canonical unit of their quantity, by the unit
+// the device declared in the unit tag, so readings of devices reporting in different
+// units can be compared. Converted entries keep the declared unit in OriginalUnitTag.
+type unitsProcessor struct {
+	tag           string
+	canonical     map[string]string // by quantity
+	rejectUnknown bool
+}
+
+func newUnitsProcessor(config *yaml.Node) (Processor, error) {
+	var cfg struct {
+		Tag           string            `yaml:"tag"`       // default UnitTag
+		Canonical     map[string]string `yaml:"canonical"` // quantity to unit, overriding canonicalUnits
+		RejectUnknown bool              `yaml:"reject_unknown"`
+	}
+	if err := decodeConfig(config, &cfg); err != nil {
+		return nil, err
+	}
+
+	p := &unitsProcessor{tag: cfg.Tag, canonical: make(map[string]string), rejectUnknown: cfg.RejectUnknown}
+	if p.tag == "" {
+		p.tag = UnitTag
+	}
+	for quantity, unit := range canonicalUnits {
+		p.canonical[quantity] = unit
+	}
+	for quantity, name := range cfg.Canonical {
+		if _, ok := canonicalUnits[quantity]; !ok {
+			return nil, fmt.Errorf("canonical: unknown quantity %q", quantity)
+		}
+		unit, ok := lookupUnit(name)
+		if !ok {
+			return nil, fmt.Errorf("canonical: unknown unit %q", name)
+		}
+		if units[unit].quantity != quantity {
+			return nil, fmt.Errorf("canonical: %s is not a unit of %s", name, quantity)
+		}
+		p.canonical[quantity] = unit
+	}
+
+	return p, nil
+}
+
+func (p *unitsProcessor) Process(_ context.Context, entry *Entry) (*Entry, error) {
+	declared, ok := entry.Tags[p.tag]
+	if !ok || !entry.Scalar() {
+		return entry, nil
+	}
+	from, ok := lookupUnit(declared)
+	if !ok {
+		if p.rejectUnknown {
+			return nil, fmt.Errorf("%w: unknown unit %q", ErrInvalid, declared)
+		}
+		return entry, nil
+	}
+
+	to := p.canonical[units[from].quantity]
+	if from != to {
+		// Both units are known and measure the same quantity.
+		convert, _ := converter(from, to)
+		entry.SensorValue = convert(entry.SensorValue)
+		entry.SetTag(OriginalUnitTag, declared)
+	}
+	entry.SetTag(p.tag, to)
+	return entry, nil
+}
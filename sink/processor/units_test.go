@@ -0,0 +1,72 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestUnitsProcessor(t *testing.T) {
+	chain, err := parseChain([]byte(`
+processors:
+  - type: units
+    config:
+      canonical: {length: cm}
+`))
+	if err != nil {
+		t.Fatalf("parseChain() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		entry        Entry
+		want         float64
+		wantUnit     string
+		wantOriginal string
+	}{
+		{name: "fahrenheit", entry: Entry{SensorValue: 212, Tags: map[string]string{"unit": "°F"}}, want: 100, wantUnit: "celsius", wantOriginal: "°F"},
+		{name: "psi", entry: Entry{SensorValue: 10, Tags: map[string]string{"unit": "psi"}}, want: 68.94757293168, wantUnit: "kpa", wantOriginal: "psi"},
+		{name: "configured canonical unit", entry: Entry{SensorValue: 2, Tags: map[string]string{"unit": "m"}}, want: 200, wantUnit: "cm", wantOriginal: "m"},
+		{name: "canonical spelled otherwise", entry: Entry{SensorValue: 21.5, Tags: map[string]string{"unit": "℃"}}, want: 21.5, wantUnit: "celsius"},
+		{name: "unknown unit", entry: Entry{SensorValue: 40, Tags: map[string]string{"unit": "%"}}, want: 40, wantUnit: "%"},
+		{name: "no unit", entry: Entry{SensorValue: 40}, want: 40},
+		{name: "sealed", entry: Entry{Sealed: []byte{1}, Tags: map[string]string{"unit": "psi"}}, wantUnit: "psi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := tt.entry
+			got, err := chain.Process(context.Background(), &entry)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+			if math.Abs(got.SensorValue-tt.want) > 1e-9 {
+				t.Errorf("SensorValue = %v, want %v", got.SensorValue, tt.want)
+			}
+			if got.Tags[UnitTag] != tt.wantUnit || got.Tags[OriginalUnitTag] != tt.wantOriginal {
+				t.Errorf("tags = %v, want unit %q and original_unit %q", got.Tags, tt.wantUnit, tt.wantOriginal)
+			}
+		})
+	}
+}
+
+func TestUnitsProcessor_RejectUnknown(t *testing.T) {
+	chain, err := parseChain([]byte("processors:\n  - type: units\n    config:\n      reject_unknown: true\n"))
+	if err != nil {
+		t.Fatalf("parseChain() error = %v", err)
+	}
+	entry := Entry{SensorValue: 1, Tags: map[string]string{"unit": "furlong"}}
+	if _, err := chain.Process(context.Background(), &entry); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Process() error = %v, want ErrInvalid", err)
+	}
+
+	for _, data := range []string{
+		"processors:\n  - type: units\n    config:\n      canonical: {temperature: bar}\n",
+		"processors:\n  - type: units\n    config:\n      canonical: {speed: knots}\n",
+	} {
+		if _, err := parseChain([]byte(data)); err == nil {
+			t.Errorf("parseChain(%q) should fail", data)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Code generated by protoc-gen-connect-go. DO NOT EDIT.
+//
+// Source: proto/sensor.proto
+
+package protoconnect
+
+import (
+	connect "connectrpc.com/connect"
+	context "context"
+	errors "errors"
+	proto "github.com/sink/proto"
+	http "net/http"
+	strings "strings"
+)
+
+// This is a compile-time assertion to ensure that this generated file and the connect package are
+// compatible. If you get a compiler error that this constant is not defined, this code was
+// generated with a version of connect newer than the one compiled into your binary. You can fix the
+// problem by either regenerating this code with an older version of connect or updating the connect
+// version compiled into your binary.
+const _ = connect.IsAtLeastVersion1_13_0
+
+const (
+	// TelemetryServiceName is the fully-qualified name of the TelemetryService service.
+	TelemetryServiceName = "telemetry.TelemetryService"
+)
+
+// These constants are the fully-qualified names of the RPCs defined in this package. They're
+// exposed at runtime as Spec.Procedure and as the final two segments of the HTTP route.
+//
+// Note that these are different from the fully-qualified method names used by
+// google.golang.org/protobuf/reflect/protoreflect. To convert from these constants to
+// reflection-formatted method names, remove the leading slash and convert the remaining slash to a
+// period.
+const (
+	// TelemetryServiceSendSensorDataProcedure is the fully-qualified name of the TelemetryService's
+	// SendSensorData RPC.
+	TelemetryServiceSendSensorDataProcedure = "/telemetry.TelemetryService/SendSensorData"
+	// TelemetryServiceSendSensorDataBatchProcedure is the fully-qualified name of the
+	// TelemetryService's SendSensorDataBatch RPC.
+	TelemetryServiceSendSensorDataBatchProcedure = "/telemetry.TelemetryService/SendSensorDataBatch"
+	// TelemetryServiceRegisterSensorProcedure is the fully-qualified name of the TelemetryService's
+	// RegisterSensor RPC.
+	TelemetryServiceRegisterSensorProcedure = "/telemetry.TelemetryService/RegisterSensor"
+	// TelemetryServiceHeartbeatProcedure is the fully-qualified name of the TelemetryService's
+	// Heartbeat RPC.
+	TelemetryServiceHeartbeatProcedure = "/telemetry.TelemetryService/Heartbeat"
+	// TelemetryServiceReportEventProcedure is the fully-qualified name of the TelemetryService's
+	// ReportEvent RPC.
+	TelemetryServiceReportEventProcedure = "/telemetry.TelemetryService/ReportEvent"
+	// TelemetryServiceReplicateProcedure is the fully-qualified name of the TelemetryService's
+	// Replicate RPC.
+	TelemetryServiceReplicateProcedure = "/telemetry.TelemetryService/Replicate"
+)
+
+// TelemetryServiceClient is a client for the telemetry.TelemetryService service.
+type TelemetryServiceClient interface {
+	SendSensorData(context.Context, *connect.Request[proto.SensorData]) (*connect.Response[proto.SensorDataResponse], error)
+	// SendSensorDataBatch stores several readings in one call. Each is admitted,
+	// processed and stored like a SendSensorData call and gets its own result, so a
+	// node resends only the readings that failed.
+	SendSensorDataBatch(context.Context, *connect.Request[proto.SensorDataBatch]) (*connect.Response[proto.SensorDataBatchResponse], error)
+	// RegisterSensor announces a sensor to the sink's inventory when it starts.
+	RegisterSensor(context.Context, *connect.Request[proto.RegisterSensorRequest]) (*connect.Response[proto.RegisterSensorResponse], error)
+	// Heartbeat tells the sink a sensor is alive even when it has nothing to report.
+	Heartbeat(context.Context, *connect.Request[proto.HeartbeatRequest]) (*connect.Response[proto.HeartbeatResponse], error)
+	// ReportEvent records a state transition or error of a device, stored in the log
+	// alongside its readings.
+	ReportEvent(context.Context, *connect.Request[proto.Event]) (*connect.Response[proto.EventResponse], error)
+	// Replicate applies batches of a primary sink's accepted entries on a standby sink
+	// started with --accept-replication.
+	Replicate(context.Context, *connect.Request[proto.ReplicationRequest]) (*connect.Response[proto.ReplicationResponse], error)
+}
+
+// NewTelemetryServiceClient constructs a client for the telemetry.TelemetryService service. By
+// default, it uses the Connect protocol with the binary Protobuf Codec, asks for gzipped responses,
+// and sends uncompressed requests. To use the gRPC or gRPC-Web protocols, supply the
+// connect.WithGRPC() or connect.WithGRPCWeb() options.
+//
+// The URL supplied here should be the base URL for the Connect or gRPC server (for example,
+// http://api.acme.com or https://acme.com/grpc).
+func NewTelemetryServiceClient(httpClient connect.HTTPClient, baseURL string, opts ...connect.ClientOption) TelemetryServiceClient {
+	baseURL = strings.TrimRight(baseURL, "/")
+	telemetryServiceMethods := proto.File_proto_sensor_proto.Services().ByName("TelemetryService").Methods()
+	return &telemetryServiceClient{
+		sendSensorData: connect.NewClient[proto.SensorData, proto.SensorDataResponse](
+			httpClient,
+			baseURL+TelemetryServiceSendSensorDataProcedure,
+			connect.WithSchema(telemetryServiceMethods.ByName("SendSensorData")),
+			connect.WithClientOptions(opts...),
+		),
+		sendSensorDataBatch: connect.NewClient[proto.SensorDataBatch, proto.SensorDataBatchResponse](
+			httpClient,
+			baseURL+TelemetryServiceSendSensorDataBatchProcedure,
+			connect.WithSchema(telemetryServiceMethods.ByName("SendSensorDataBatch")),
+			connect.WithClientOptions(opts...),
+		),
+		registerSensor: connect.NewClient[proto.RegisterSensorRequest, proto.RegisterSensorResponse](
+			httpClient,
+			baseURL+TelemetryServiceRegisterSensorProcedure,
+			connect.WithSchema(telemetryServiceMethods.ByName("RegisterSensor")),
+			connect.WithClientOptions(opts...),
+		),
+		heartbeat: connect.NewClient[proto.HeartbeatRequest, proto.HeartbeatResponse](
+			httpClient,
+			baseURL+TelemetryServiceHeartbeatProcedure,
+			connect.WithSchema(telemetryServiceMethods.ByName("Heartbeat")),
+			connect.WithClientOptions(opts...),
+		),
+		reportEvent: connect.NewClient[proto.Event, proto.EventResponse](
+			httpClient,
+			baseURL+TelemetryServiceReportEventProcedure,
+			connect.WithSchema(telemetryServiceMethods.ByName("ReportEvent")),
+			connect.WithClientOptions(opts...),
+		),
+		replicate: connect.NewClient[proto.ReplicationRequest, proto.ReplicationResponse](
+			httpClient,
+			baseURL+TelemetryServiceReplicateProcedure,
+			connect.WithSchema(telemetryServiceMethods.ByName("Replicate")),
+			connect.WithClientOptions(opts...),
+		),
+	}
+}
+
+// telemetryServiceClient implements TelemetryServiceClient.
+type telemetryServiceClient struct {
+	sendSensorData      *connect.Client[proto.SensorData, proto.SensorDataResponse]
+	sendSensorDataBatch *connect.Client[proto.SensorDataBatch, proto.SensorDataBatchResponse]
+	registerSensor      *connect.Client[proto.RegisterSensorRequest, proto.RegisterSensorResponse]
+	heartbeat           *connect.Client[proto.HeartbeatRequest, proto.HeartbeatResponse]
+	reportEvent         *connect.Client[proto.Event, proto.EventResponse]
+	replicate           *connect.Client[proto.ReplicationRequest, proto.ReplicationResponse]
+}
+
+// SendSensorData calls telemetry.TelemetryService.SendSensorData.
+func (c *telemetryServiceClient) SendSensorData(ctx context.Context, req *connect.Request[proto.SensorData]) (*connect.Response[proto.SensorDataResponse], error) {
+	return c.sendSensorData.CallUnary(ctx, req)
+}
+
+// SendSensorDataBatch calls telemetry.TelemetryService.SendSensorDataBatch.
+func (c *telemetryServiceClient) SendSensorDataBatch(ctx context.Context, req *connect.Request[proto.SensorDataBatch]) (*connect.Response[proto.SensorDataBatchResponse], error) {
+	return c.sendSensorDataBatch.CallUnary(ctx, req)
+}
+
+// RegisterSensor calls telemetry.TelemetryService.RegisterSensor.
+func (c *telemetryServiceClient) RegisterSensor(ctx context.Context, req *connect.Request[proto.RegisterSensorRequest]) (*connect.Response[proto.RegisterSensorResponse], error) {
+	return c.registerSensor.CallUnary(ctx, req)
+}
+
+// Heartbeat calls telemetry.TelemetryService.Heartbeat.
+func (c *telemetryServiceClient) Heartbeat(ctx context.Context, req *connect.Request[proto.HeartbeatRequest]) (*connect.Response[proto.HeartbeatResponse], error) {
+	return c.heartbeat.CallUnary(ctx, req)
+}
+
+// ReportEvent calls telemetry.TelemetryService.ReportEvent.
+func (c *telemetryServiceClient) ReportEvent(ctx context.Context, req *connect.Request[proto.Event]) (*connect.Response[proto.EventResponse], error) {
+	return c.reportEvent.CallUnary(ctx, req)
+}
+
+// Replicate calls telemetry.TelemetryService.Replicate.
+func (c *telemetryServiceClient) Replicate(ctx context.Context, req *connect.Request[proto.ReplicationRequest]) (*connect.Response[proto.ReplicationResponse], error) {
+	return c.replicate.CallUnary(ctx, req)
+}
+
+// TelemetryServiceHandler is an implementation of the telemetry.TelemetryService service.
+type TelemetryServiceHandler interface {
+	SendSensorData(context.Context, *connect.Request[proto.SensorData]) (*connect.Response[proto.SensorDataResponse], error)
+	// SendSensorDataBatch stores several readings in one call. Each is admitted,
+	// processed and stored like a SendSensorData call and gets its own result, so a
+	// node resends only the readings that failed.
+	SendSensorDataBatch(context.Context, *connect.Request[proto.SensorDataBatch]) (*connect.Response[proto.SensorDataBatchResponse], error)
+	// RegisterSensor announces a sensor to the sink's inventory when it starts.
+	RegisterSensor(context.Context, *connect.Request[proto.RegisterSensorRequest]) (*connect.Response[proto.RegisterSensorResponse], error)
+	// Heartbeat tells the sink a sensor is alive even when it has nothing to report.
+	Heartbeat(context.Context, *connect.Request[proto.HeartbeatRequest]) (*connect.Response[proto.HeartbeatResponse], error)
+	// ReportEvent records a state transition or error of a device, stored in the log
+	// alongside its readings.
+	ReportEvent(context.Context, *connect.Request[proto.Event]) (*connect.Response[proto.EventResponse], error)
+	// Replicate applies batches of a primary sink's accepted entries on a standby sink
+	// started with --accept-replication.
+	Replicate(context.Context, *connect.Request[proto.ReplicationRequest]) (*connect.Response[proto.ReplicationResponse], error)
+}
+
+// NewTelemetryServiceHandler builds an HTTP handler from the service implementation. It returns the
+// path on which to mount the handler and the handler itself.
+//
+// By default, handlers support the Connect, gRPC, and gRPC-Web protocols with the binary Protobuf
+// and JSON codecs. They also support gzip compression.
+func NewTelemetryServiceHandler(svc TelemetryServiceHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	telemetryServiceMethods := proto.File_proto_sensor_proto.Services().ByName("TelemetryService").Methods()
+	telemetryServiceSendSensorDataHandler := connect.NewUnaryHandler(
+		TelemetryServiceSendSensorDataProcedure,
+		svc.SendSensorData,
+		connect.WithSchema(telemetryServiceMethods.ByName("SendSensorData")),
+		connect.WithHandlerOptions(opts...),
+	)
+	telemetryServiceSendSensorDataBatchHandler := connect.NewUnaryHandler(
+		TelemetryServiceSendSensorDataBatchProcedure,
+		svc.SendSensorDataBatch,
+		connect.WithSchema(telemetryServiceMethods.ByName("SendSensorDataBatch")),
+		connect.WithHandlerOptions(opts...),
+	)
+	telemetryServiceRegisterSensorHandler := connect.NewUnaryHandler(
+		TelemetryServiceRegisterSensorProcedure,
+		svc.RegisterSensor,
+		connect.WithSchema(telemetryServiceMethods.ByName("RegisterSensor")),
+		connect.WithHandlerOptions(opts...),
+	)
+	telemetryServiceHeartbeatHandler := connect.NewUnaryHandler(
+		TelemetryServiceHeartbeatProcedure,
+		svc.Heartbeat,
+		connect.WithSchema(telemetryServiceMethods.ByName("Heartbeat")),
+		connect.WithHandlerOptions(opts...),
+	)
+	telemetryServiceReportEventHandler := connect.NewUnaryHandler(
+		TelemetryServiceReportEventProcedure,
+		svc.ReportEvent,
+		connect.WithSchema(telemetryServiceMethods.ByName("ReportEvent")),
+		connect.WithHandlerOptions(opts...),
+	)
+	telemetryServiceReplicateHandler := connect.NewUnaryHandler(
+		TelemetryServiceReplicateProcedure,
+		svc.Replicate,
+		connect.WithSchema(telemetryServiceMethods.ByName("Replicate")),
+		connect.WithHandlerOptions(opts...),
+	)
+	return "/telemetry.TelemetryService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case TelemetryServiceSendSensorDataProcedure:
+			telemetryServiceSendSensorDataHandler.ServeHTTP(w, r)
+		case TelemetryServiceSendSensorDataBatchProcedure:
+			telemetryServiceSendSensorDataBatchHandler.ServeHTTP(w, r)
+		case TelemetryServiceRegisterSensorProcedure:
+			telemetryServiceRegisterSensorHandler.ServeHTTP(w, r)
+		case TelemetryServiceHeartbeatProcedure:
+			telemetryServiceHeartbeatHandler.ServeHTTP(w, r)
+		case TelemetryServiceReportEventProcedure:
+			telemetryServiceReportEventHandler.ServeHTTP(w, r)
+		case TelemetryServiceReplicateProcedure:
+			telemetryServiceReplicateHandler.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// UnimplementedTelemetryServiceHandler returns CodeUnimplemented from all methods.
+type UnimplementedTelemetryServiceHandler struct{}
+
+func (UnimplementedTelemetryServiceHandler) SendSensorData(context.Context, *connect.Request[proto.SensorData]) (*connect.Response[proto.SensorDataResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("telemetry.TelemetryService.SendSensorData is not implemented"))
+}
+
+func (UnimplementedTelemetryServiceHandler) SendSensorDataBatch(context.Context, *connect.Request[proto.SensorDataBatch]) (*connect.Response[proto.SensorDataBatchResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("telemetry.TelemetryService.SendSensorDataBatch is not implemented"))
+}
+
+func (UnimplementedTelemetryServiceHandler) RegisterSensor(context.Context, *connect.Request[proto.RegisterSensorRequest]) (*connect.Response[proto.RegisterSensorResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("telemetry.TelemetryService.RegisterSensor is not implemented"))
+}
+
+func (UnimplementedTelemetryServiceHandler) Heartbeat(context.Context, *connect.Request[proto.HeartbeatRequest]) (*connect.Response[proto.HeartbeatResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("telemetry.TelemetryService.Heartbeat is not implemented"))
+}
+
+func (UnimplementedTelemetryServiceHandler) ReportEvent(context.Context, *connect.Request[proto.Event]) (*connect.Response[proto.EventResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("telemetry.TelemetryService.ReportEvent is not implemented"))
+}
+
+func (UnimplementedTelemetryServiceHandler) Replicate(context.Context, *connect.Request[proto.ReplicationRequest]) (*connect.Response[proto.ReplicationResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("telemetry.TelemetryService.Replicate is not implemented"))
+}
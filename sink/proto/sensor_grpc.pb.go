@@ -23,6 +23,20 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type TelemetryServiceClient interface {
 	SendSensorData(ctx context.Context, in *SensorData, opts ...grpc.CallOption) (*SensorDataResponse, error)
+	// SendSensorDataBatch stores several readings in one call. Each is admitted,
+	// processed and stored like a SendSensorData call and gets its own result, so a
+	// node resends only the readings that failed.
+	SendSensorDataBatch(ctx context.Context, in *SensorDataBatch, opts ...grpc.CallOption) (*SensorDataBatchResponse, error)
+	// RegisterSensor announces a sensor to the sink's inventory when it starts.
+	RegisterSensor(ctx context.Context, in *RegisterSensorRequest, opts ...grpc.CallOption) (*RegisterSensorResponse, error)
+	// Heartbeat tells the sink a sensor is alive even when it has nothing to report.
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	// ReportEvent records a state transition or error of a device, stored in the log
+	// alongside its readings.
+	ReportEvent(ctx context.Context, in *Event, opts ...grpc.CallOption) (*EventResponse, error)
+	// Replicate applies batches of a primary sink's accepted entries on a standby sink
+	// started with --accept-replication.
+	Replicate(ctx context.Context, in *ReplicationRequest, opts ...grpc.CallOption) (*ReplicationResponse, error)
 }
 
 type telemetryServiceClient struct {
@@ -42,11 +56,70 @@ func (c *telemetryServiceClient) SendSensorData(ctx context.Context, in *SensorD
 	return out, nil
 }
 
+func (c *telemetryServiceClient) SendSensorDataBatch(ctx context.Context, in *SensorDataBatch, opts ...grpc.CallOption) (*SensorDataBatchResponse, error) {
+	out := new(SensorDataBatchResponse)
+	err := c.cc.Invoke(ctx, "/telemetry.TelemetryService/SendSensorDataBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telemetryServiceClient) RegisterSensor(ctx context.Context, in *RegisterSensorRequest, opts ...grpc.CallOption) (*RegisterSensorResponse, error) {
+	out := new(RegisterSensorResponse)
+	err := c.cc.Invoke(ctx, "/telemetry.TelemetryService/RegisterSensor", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telemetryServiceClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, "/telemetry.TelemetryService/Heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telemetryServiceClient) ReportEvent(ctx context.Context, in *Event, opts ...grpc.CallOption) (*EventResponse, error) {
+	out := new(EventResponse)
+	err := c.cc.Invoke(ctx, "/telemetry.TelemetryService/ReportEvent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *telemetryServiceClient) Replicate(ctx context.Context, in *ReplicationRequest, opts ...grpc.CallOption) (*ReplicationResponse, error) {
+	out := new(ReplicationResponse)
+	err := c.cc.Invoke(ctx, "/telemetry.TelemetryService/Replicate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TelemetryServiceServer is the server API for TelemetryService service.
 // All implementations must embed UnimplementedTelemetryServiceServer
 // for forward compatibility
 type TelemetryServiceServer interface {
 	SendSensorData(context.Context, *SensorData) (*SensorDataResponse, error)
+	// SendSensorDataBatch stores several readings in one call. Each is admitted,
+	// processed and stored like a SendSensorData call and gets its own result, so a
+	// node resends only the readings that failed.
+	SendSensorDataBatch(context.Context, *SensorDataBatch) (*SensorDataBatchResponse, error)
+	// RegisterSensor announces a sensor to the sink's inventory when it starts.
+	RegisterSensor(context.Context, *RegisterSensorRequest) (*RegisterSensorResponse, error)
+	// Heartbeat tells the sink a sensor is alive even when it has nothing to report.
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	// ReportEvent records a state transition or error of a device, stored in the log
+	// alongside its readings.
+	ReportEvent(context.Context, *Event) (*EventResponse, error)
+	// Replicate applies batches of a primary sink's accepted entries on a standby sink
+	// started with --accept-replication.
+	Replicate(context.Context, *ReplicationRequest) (*ReplicationResponse, error)
 	mustEmbedUnimplementedTelemetryServiceServer()
 }
 
@@ -57,6 +130,21 @@ type UnimplementedTelemetryServiceServer struct {
 func (UnimplementedTelemetryServiceServer) SendSensorData(context.Context, *SensorData) (*SensorDataResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendSensorData not implemented")
 }
+func (UnimplementedTelemetryServiceServer) SendSensorDataBatch(context.Context, *SensorDataBatch) (*SensorDataBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendSensorDataBatch not implemented")
+}
+func (UnimplementedTelemetryServiceServer) RegisterSensor(context.Context, *RegisterSensorRequest) (*RegisterSensorResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterSensor not implemented")
+}
+func (UnimplementedTelemetryServiceServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedTelemetryServiceServer) ReportEvent(context.Context, *Event) (*EventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportEvent not implemented")
+}
+func (UnimplementedTelemetryServiceServer) Replicate(context.Context, *ReplicationRequest) (*ReplicationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Replicate not implemented")
+}
 func (UnimplementedTelemetryServiceServer) mustEmbedUnimplementedTelemetryServiceServer() {}
 
 // UnsafeTelemetryServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +176,96 @@ func _TelemetryService_SendSensorData_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TelemetryService_SendSensorDataBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SensorDataBatch)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelemetryServiceServer).SendSensorDataBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telemetry.TelemetryService/SendSensorDataBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelemetryServiceServer).SendSensorDataBatch(ctx, req.(*SensorDataBatch))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelemetryService_RegisterSensor_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterSensorRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelemetryServiceServer).RegisterSensor(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telemetry.TelemetryService/RegisterSensor",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelemetryServiceServer).RegisterSensor(ctx, req.(*RegisterSensorRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelemetryService_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelemetryServiceServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telemetry.TelemetryService/Heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelemetryServiceServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelemetryService_ReportEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Event)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelemetryServiceServer).ReportEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telemetry.TelemetryService/ReportEvent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelemetryServiceServer).ReportEvent(ctx, req.(*Event))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TelemetryService_Replicate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplicationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TelemetryServiceServer).Replicate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telemetry.TelemetryService/Replicate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TelemetryServiceServer).Replicate(ctx, req.(*ReplicationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // TelemetryService_ServiceDesc is the grpc.ServiceDesc for TelemetryService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,6 +277,26 @@ var TelemetryService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SendSensorData",
 			Handler:    _TelemetryService_SendSensorData_Handler,
 		},
+		{
+			MethodName: "SendSensorDataBatch",
+			Handler:    _TelemetryService_SendSensorDataBatch_Handler,
+		},
+		{
+			MethodName: "RegisterSensor",
+			Handler:    _TelemetryService_RegisterSensor_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _TelemetryService_Heartbeat_Handler,
+		},
+		{
+			MethodName: "ReportEvent",
+			Handler:    _TelemetryService_ReportEvent_Handler,
+		},
+		{
+			MethodName: "Replicate",
+			Handler:    _TelemetryService_Replicate_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/sensor.proto",
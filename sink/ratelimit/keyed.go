@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sink/clock"
+	"github.com/sink/state"
+)
+
+// idleBucketTTL is how long an unused bucket is kept. Buckets refill within a second,
+// so evicting an idle one and creating it again later loses nothing.
+const idleBucketTTL = time.Minute
+
+// KeyedLimiter enforces an independent byte quota per key, e.g. per tenant or per sensor.
+type KeyedLimiter interface {
+	Allow(ctx context.Context, key string, bytes int) bool
+	// Quota returns what is left of the key's quota without consuming any of it.
+	Quota(ctx context.Context, key string) Quota
+}
+
+// LocalKeyedLimiter keeps one in-memory token bucket per key.
+type LocalKeyedLimiter struct {
+	rate     int
+	limiters *state.Table[string, *RateLimiter]
+	clock    clock.Clock
+	mu       sync.Mutex
+}
+
+// NewLocalKeyedLimiter creates a limiter holding at most maxKeys buckets (0 is
+// unlimited). Beyond that the least recently used bucket is dropped.
+func NewLocalKeyedLimiter(rate, maxKeys int, clk clock.Clock) *LocalKeyedLimiter {
+	return &LocalKeyedLimiter{
+		rate:     rate,
+		limiters: state.NewTable[string, *RateLimiter]("rate_limit_buckets", state.Limits{TTL: idleBucketTTL, MaxEntries: maxKeys}),
+		clock:    clk,
+	}
+}
+
+func (l *LocalKeyedLimiter) Allow(_ context.Context, key string, bytes int) bool {
+	l.mu.Lock()
+	rl := l.limiters.GetOrCreate(key, l.clock.Now(), func() *RateLimiter {
+		return NewRateLimiter(l.rate, l.clock)
+	})
+	l.mu.Unlock()
+
+	return rl.Allow(bytes)
+}
+
+func (l *LocalKeyedLimiter) Quota(_ context.Context, key string) Quota {
+	l.mu.Lock()
+	rl, ok := l.limiters.Get(key, l.clock.Now())
+	l.mu.Unlock()
+
+	if !ok {
+		return newQuota(l.rate, l.rate)
+	}
+	return rl.Quota()
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sink/clock"
+)
+
+func TestLocalKeyedLimiter_Allow(t *testing.T) {
+	l := NewLocalKeyedLimiter(100, 0, clock.NewFake(testStart))
+	ctx := context.Background()
+
+	if !l.Allow(ctx, "tenant-a", 100) {
+		t.Error("First request for tenant-a should be allowed")
+	}
+	if l.Allow(ctx, "tenant-a", 1) {
+		t.Error("Second request for tenant-a should be denied (bucket empty)")
+	}
+	if !l.Allow(ctx, "tenant-b", 100) {
+		t.Error("tenant-b should have its own bucket")
+	}
+}
+
+func TestLocalKeyedLimiter_Quota(t *testing.T) {
+	clk := clock.NewFake(testStart)
+	l := NewLocalKeyedLimiter(100, 0, clk)
+	ctx := context.Background()
+
+	if q := l.Quota(ctx, "tenant-a"); q != (Quota{Limit: 100, Remaining: 100}) {
+		t.Errorf("Quota() of an unused key = %+v, want a full bucket", q)
+	}
+	l.Allow(ctx, "tenant-a", 80)
+	if q := l.Quota(ctx, "tenant-a"); q.Remaining != 20 || q.Reset != 800*time.Millisecond {
+		t.Errorf("Quota() after 80 bytes = %+v, want 20 remaining, full in 800ms", q)
+	}
+	clk.Advance(500 * time.Millisecond)
+	if q := l.Quota(ctx, "tenant-a"); q.Remaining != 70 {
+		t.Errorf("Quota() after refilling = %+v, want 70 remaining", q)
+	}
+	// Looking doesn't consume anything.
+	if !l.Allow(ctx, "tenant-a", 70) {
+		t.Error("Allow() of the remaining quota = false")
+	}
+}
+
+func TestRedisLimiter_FallbackWhenUnavailable(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 50 * time.Millisecond,
+		MaxRetries:  -1,
+	})
+	defer client.Close()
+
+	l := NewRedisLimiter(client, "test:", 100, 0, clock.NewFake(testStart))
+	ctx := context.Background()
+
+	if !l.Allow(ctx, "sensor-1", 60) {
+		t.Error("First request should be allowed by local fallback")
+	}
+	if !l.degraded() {
+		t.Error("Limiter should be degraded after redis failure")
+	}
+	if l.Allow(ctx, "sensor-1", 60) {
+		t.Error("Fallback should still enforce the quota")
+	}
+	if !l.Allow(ctx, "sensor-2", 60) {
+		t.Error("Fallback should track keys independently")
+	}
+}
+
+func BenchmarkLocalKeyedLimiter_Allow(b *testing.B) {
+	l := NewLocalKeyedLimiter(1<<30, 0, clock.Real)
+	ctx := context.Background()
+
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("tenant/sensor-%d", i)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			l.Allow(ctx, keys[i%len(keys)], 128)
+			i++
+		}
+	})
+}
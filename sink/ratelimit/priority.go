@@ -0,0 +1,34 @@
+package ratelimit
+
+import "github.com/sink/clock"
+
+// PriorityLimiter admits critical traffic from a reserved budget before it competes
+// with bulk traffic, so safety readings keep flowing while bulk data is being shed.
+type PriorityLimiter struct {
+	normal   *RateLimiter
+	critical *RateLimiter // nil when no budget is reserved
+}
+
+// NewPriorityLimiter creates a limiter with rate bytes per second shared by all
+// traffic and criticalRate bytes per second reserved for critical traffic.
+func NewPriorityLimiter(rate, criticalRate int, clk clock.Clock) *PriorityLimiter {
+	l := &PriorityLimiter{normal: NewRateLimiter(rate, clk)}
+	if criticalRate > 0 {
+		l.critical = NewRateLimiter(criticalRate, clk)
+	}
+	return l
+}
+
+// Allow reports whether a message of the given size may be admitted. Critical
+// messages spill over into the shared budget once the reserved one is used up.
+func (l *PriorityLimiter) Allow(critical bool, bytes int) bool {
+	if critical && l.critical != nil && l.critical.Allow(bytes) {
+		return true
+	}
+	return l.normal.Allow(bytes)
+}
+
+// Quota returns what is left of the budget shared by all traffic.
+func (l *PriorityLimiter) Quota() Quota {
+	return l.normal.Quota()
+}
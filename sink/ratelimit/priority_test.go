@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"testing"
+
+	"github.com/sink/clock"
+)
+
+func TestPriorityLimiter_Allow(t *testing.T) {
+	l := NewPriorityLimiter(100, 50, clock.NewFake(testStart))
+
+	if !l.Allow(false, 100) {
+		t.Error("Normal request within the shared budget should be allowed")
+	}
+	if l.Allow(false, 1) {
+		t.Error("Normal request should be denied once the shared budget is used up")
+	}
+	if !l.Allow(true, 50) {
+		t.Error("Critical request should be admitted from the reserved budget")
+	}
+	if l.Allow(true, 1) {
+		t.Error("Critical request should be denied once both budgets are used up")
+	}
+}
+
+func TestPriorityLimiter_CriticalSpillsOver(t *testing.T) {
+	l := NewPriorityLimiter(100, 50, clock.NewFake(testStart))
+
+	if !l.Allow(true, 80) {
+		t.Error("Critical request larger than the reserved budget should use the shared one")
+	}
+	if !l.Allow(true, 50) {
+		t.Error("Reserved budget should be untouched by the spilled request")
+	}
+	if l.Allow(false, 30) {
+		t.Error("Spilled critical traffic should count against the shared budget")
+	}
+}
+
+func TestPriorityLimiter_NoReservation(t *testing.T) {
+	l := NewPriorityLimiter(100, 0, clock.NewFake(testStart))
+
+	if !l.Allow(true, 100) {
+		t.Error("Critical request should use the shared budget")
+	}
+	if l.Allow(true, 1) {
+		t.Error("Critical request should be denied without a reserved budget")
+	}
+}
+
+func BenchmarkPriorityLimiter_Allow(b *testing.B) {
+	l := NewPriorityLimiter(1<<40, 1<<20, clock.Real)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			l.Allow(false, 128)
+		}
+	})
+}
@@ -3,20 +3,24 @@ package ratelimit
 import (
 	"sync"
 	"time"
+
+	"github.com/sink/clock"
 )
 
 type RateLimiter struct {
 	rate       int
 	bucket     int
 	lastUpdate time.Time
+	clock      clock.Clock
 	mu         sync.Mutex
 }
 
-func NewRateLimiter(rate int) *RateLimiter {
+func NewRateLimiter(rate int, clk clock.Clock) *RateLimiter {
 	return &RateLimiter{
 		rate:       rate,
 		bucket:     rate,
-		lastUpdate: time.Now(),
+		lastUpdate: clk.Now(),
+		clock:      clk,
 	}
 }
 
@@ -24,18 +28,21 @@ func (rl *RateLimiter) Allow(bytes int) bool {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	now := time.Now()
+	now := rl.clock.Now()
 	sinceLast := now.Sub(rl.lastUpdate)
 
 	tokensToAdd := int(sinceLast.Seconds() * float64(rl.rate))
 	rl.bucket += tokensToAdd
 
-	if rl.bucket > rl.rate {
+	if rl.bucket >= rl.rate {
 		rl.bucket = rl.rate
+		rl.lastUpdate = now
+	} else {
+		// Only the time turned into whole tokens is used up; the rest counts towards
+		// the next token, so frequent calls still refill at rate.
+		rl.lastUpdate = rl.lastUpdate.Add(time.Duration(tokensToAdd) * time.Second / time.Duration(rl.rate))
 	}
 
-	rl.lastUpdate = now
-
 	if rl.bucket >= bytes {
 		rl.bucket -= bytes
 		return true
@@ -43,3 +50,37 @@ func (rl *RateLimiter) Allow(bytes int) bool {
 
 	return false
 }
+
+// Quota is what is left of a token bucket.
+type Quota struct {
+	Limit     int           // bytes per second, and the bucket's size
+	Remaining int           // bytes that may be sent now
+	Reset     time.Duration // until the bucket is full again
+}
+
+// newQuota returns the quota of a bucket of rate bytes per second holding tokens.
+func newQuota(rate, tokens int) Quota {
+	tokens = max(min(tokens, rate), 0)
+	q := Quota{Limit: rate, Remaining: tokens}
+	if rate > 0 {
+		q.Reset = time.Duration(float64(rate-tokens) / float64(rate) * float64(time.Second))
+	}
+	return q
+}
+
+// Tighter returns the quota with fewer bytes remaining.
+func (q Quota) Tighter(other Quota) Quota {
+	if other.Remaining < q.Remaining {
+		return other
+	}
+	return q
+}
+
+// Quota returns what is left of the bucket now, without consuming any of it.
+func (rl *RateLimiter) Quota() Quota {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	tokens := rl.bucket + int(rl.clock.Now().Sub(rl.lastUpdate).Seconds()*float64(rl.rate))
+	return newQuota(rl.rate, tokens)
+}
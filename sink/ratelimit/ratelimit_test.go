@@ -5,8 +5,12 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/sink/clock"
 )
 
+var testStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
 func TestRateLimiter_Allow(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -132,7 +136,8 @@ func TestRateLimiter_Allow(t *testing.T) {
 			rl := &RateLimiter{
 				rate:       tt.rate,
 				bucket:     tt.initialBucket,
-				lastUpdate: time.Now().Add(-tt.timeSinceLast),
+				lastUpdate: testStart.Add(-tt.timeSinceLast),
+				clock:      clock.NewFake(testStart),
 			}
 
 			result := rl.Allow(tt.requestBytes)
@@ -149,7 +154,7 @@ func TestRateLimiter_Allow(t *testing.T) {
 }
 
 func TestRateLimiter_Allow_Concurrent(t *testing.T) {
-	rl := NewRateLimiter(1000)
+	rl := NewRateLimiter(1000, clock.NewFake(testStart))
 
 	var wg sync.WaitGroup
 	var allowed, denied int32
@@ -181,7 +186,8 @@ func TestRateLimiter_Allow_Concurrent(t *testing.T) {
 }
 
 func TestRateLimiter_Allow_Sequential(t *testing.T) {
-	rl := NewRateLimiter(100)
+	clk := clock.NewFake(testStart)
+	rl := NewRateLimiter(100, clk)
 
 	// First request should be allowed
 	if !rl.Allow(50) {
@@ -199,15 +205,33 @@ func TestRateLimiter_Allow_Sequential(t *testing.T) {
 	}
 
 	// Wait for refill and try again
-	time.Sleep(time.Second)
+	clk.Advance(time.Second)
 	if !rl.Allow(50) {
 		t.Error("Request after refill should be allowed")
 	}
 }
 
+func TestRateLimiter_Allow_KeepsFractionalTokens(t *testing.T) {
+	clk := clock.NewFake(testStart)
+	rl := NewRateLimiter(5, clk)
+
+	// A client asking every 150ms, slightly more often than 5 a second, earns
+	// 0.75 tokens per call and is held to 5 a second once the burst is spent.
+	allowed := 0
+	for range 10 * time.Second / (150 * time.Millisecond) {
+		if rl.Allow(1) {
+			allowed++
+		}
+		clk.Advance(150 * time.Millisecond)
+	}
+	if want := 5 + 9*5; allowed < want {
+		t.Errorf("allowed %d calls in 10s at 5 per second, want at least %d", allowed, want)
+	}
+}
+
 func TestRateLimiter_Allow_EdgeCases(t *testing.T) {
 	t.Run("negative bytes", func(t *testing.T) {
-		rl := NewRateLimiter(100)
+		rl := NewRateLimiter(100, clock.NewFake(testStart))
 		// Negative bytes should always be allowed and increase bucket
 		if !rl.Allow(-10) {
 			t.Error("Negative bytes request should be allowed")
@@ -218,7 +242,7 @@ func TestRateLimiter_Allow_EdgeCases(t *testing.T) {
 	})
 
 	t.Run("zero rate limiter", func(t *testing.T) {
-		rl := NewRateLimiter(0)
+		rl := NewRateLimiter(0, clock.NewFake(testStart))
 		// With zero rate, only zero-byte requests should be allowed
 		if !rl.Allow(0) {
 			t.Error("Zero bytes should be allowed even with zero rate")
@@ -232,7 +256,8 @@ func TestRateLimiter_Allow_EdgeCases(t *testing.T) {
 		rl := &RateLimiter{
 			rate:       100,
 			bucket:     0,
-			lastUpdate: time.Now().Add(-24 * time.Hour),
+			lastUpdate: testStart.Add(-24 * time.Hour),
+			clock:      clock.NewFake(testStart),
 		}
 
 		if !rl.Allow(100) {
@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/sink/clock"
+)
+
+// redisRetryInterval is how long the limiter stays on local buckets after a Redis failure.
+const redisRetryInterval = 5 * time.Second
+
+// tokenBucketScript refills and consumes a token bucket atomically on the Redis server,
+// using the Redis clock so that all sink instances share the same time source.
+// Returns 1 when the request is allowed and 0 otherwise.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local requested = tonumber(ARGV[2])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = rate
+	ts = now
+end
+
+local elapsed = math.max(0, now - ts)
+tokens = math.min(rate, tokens + elapsed * rate / 1000)
+
+local allowed = 0
+if tokens >= requested then
+	tokens = tokens - requested
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], 2000)
+
+return allowed
+`)
+
+// tokenBucketPeekScript returns the tokens in the bucket tokenBucketScript keeps,
+// refilled to now, without consuming any.
+var tokenBucketPeekScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	return rate
+end
+
+local elapsed = math.max(0, now - ts)
+return math.floor(math.min(rate, tokens + elapsed * rate / 1000))
+`)
+
+// RedisLimiter enforces per-key quotas shared by every sink instance connected to the
+// same Redis. While Redis is unreachable it falls back to per-instance local buckets.
+type RedisLimiter struct {
+	client   redis.UniversalClient
+	prefix   string
+	rate     int
+	fallback *LocalKeyedLimiter
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	retryAt time.Time
+}
+
+// NewRedisLimiter creates a limiter whose local fallback holds at most maxKeys
+// buckets (0 is unlimited).
+func NewRedisLimiter(client redis.UniversalClient, prefix string, rate, maxKeys int, clk clock.Clock) *RedisLimiter {
+	return &RedisLimiter{
+		client:   client,
+		prefix:   prefix,
+		rate:     rate,
+		fallback: NewLocalKeyedLimiter(rate, maxKeys, clk),
+		clock:    clk,
+	}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, bytes int) bool {
+	if l.degraded() {
+		return l.fallback.Allow(ctx, key, bytes)
+	}
+
+	allowed, err := tokenBucketScript.Run(ctx, l.client, []string{l.prefix + key}, l.rate, bytes).Int()
+	if err != nil {
+		l.markUnavailable(err)
+		return l.fallback.Allow(ctx, key, bytes)
+	}
+
+	return allowed == 1
+}
+
+func (l *RedisLimiter) Quota(ctx context.Context, key string) Quota {
+	if l.degraded() {
+		return l.fallback.Quota(ctx, key)
+	}
+
+	tokens, err := tokenBucketPeekScript.Run(ctx, l.client, []string{l.prefix + key}, l.rate).Int()
+	if err != nil {
+		l.markUnavailable(err)
+		return l.fallback.Quota(ctx, key)
+	}
+	return newQuota(l.rate, tokens)
+}
+
+func (l *RedisLimiter) degraded() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.retryAt.IsZero() {
+		return false
+	}
+	if l.clock.Now().Before(l.retryAt) {
+		return true
+	}
+
+	log.Printf("retrying redis rate limiter after fallback period")
+	l.retryAt = time.Time{}
+	return false
+}
+
+func (l *RedisLimiter) markUnavailable(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log.Printf("redis rate limiter unavailable, using local fallback for %v: %v", redisRetryInterval, err)
+	l.retryAt = l.clock.Now().Add(redisRetryInterval)
+}
@@ -0,0 +1,153 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCachedDevices bounds the cache so a flood of unknown sensor names can't grow
+	// it without limit; the cache is cleared when it fills up.
+	maxCachedDevices = 10000
+
+	maxResponseSize = 64 * 1024
+
+	// staleRetryInterval is how long a stale answer is served after a failed refresh
+	// before the service is asked again.
+	staleRetryInterval = 10 * time.Second
+)
+
+// HTTP looks devices up from an external service and caches the answers, including
+// "not found", for a TTL. When the service fails, a stale cached answer is served if
+// there is one.
+type HTTP struct {
+	urlTemplate string // contains {sensor}
+	ttl         time.Duration
+	client      *http.Client
+
+	mu       sync.Mutex
+	cache    map[string]cachedDevice
+	inflight map[string]*lookup
+}
+
+type cachedDevice struct {
+	device  *Device
+	expires time.Time
+}
+
+// lookup lets concurrent requests for the same sensor share one HTTP call.
+type lookup struct {
+	done   chan struct{}
+	device *Device
+	err    error
+}
+
+// NewHTTP creates a registry querying urlTemplate, in which {sensor} is replaced by
+// the escaped sensor name. The service answers with a JSON device or 404.
+func NewHTTP(urlTemplate string, ttl, timeout time.Duration) (*HTTP, error) {
+	if !strings.Contains(urlTemplate, "{sensor}") {
+		return nil, fmt.Errorf("registry url %q must contain {sensor}", urlTemplate)
+	}
+
+	return &HTTP{
+		urlTemplate: urlTemplate,
+		ttl:         ttl,
+		client:      &http.Client{Timeout: timeout},
+		cache:       make(map[string]cachedDevice),
+		inflight:    make(map[string]*lookup),
+	}, nil
+}
+
+func (h *HTTP) Lookup(ctx context.Context, sensorName string) (*Device, error) {
+	h.mu.Lock()
+	cached, ok := h.cache[sensorName]
+	if ok && time.Now().Before(cached.expires) {
+		h.mu.Unlock()
+		return cached.device, nil
+	}
+
+	l, running := h.inflight[sensorName]
+	if !running {
+		l = &lookup{done: make(chan struct{})}
+		h.inflight[sensorName] = l
+	}
+	h.mu.Unlock()
+
+	if !running {
+		// Not tied to ctx, other callers may be waiting on the result.
+		l.device, l.err = h.fetch(context.Background(), sensorName)
+		h.store(sensorName, l)
+		close(l.done)
+	}
+
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if l.err != nil {
+		if ok {
+			return cached.device, nil
+		}
+		return nil, l.err
+	}
+	return l.device, nil
+}
+
+func (h *HTTP) store(sensorName string, l *lookup) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.inflight, sensorName)
+	if l.err != nil {
+		if cached, ok := h.cache[sensorName]; ok {
+			cached.expires = time.Now().Add(staleRetryInterval)
+			h.cache[sensorName] = cached
+		}
+		return
+	}
+
+	if len(h.cache) >= maxCachedDevices {
+		clear(h.cache)
+	}
+	h.cache[sensorName] = cachedDevice{device: l.device, expires: time.Now().Add(h.ttl)}
+}
+
+func (h *HTTP) fetch(ctx context.Context, sensorName string) (*Device, error) {
+	target := strings.ReplaceAll(h.urlTemplate, "{sensor}", url.PathEscape(sensorName))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("build registry request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("query registry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("query registry: unexpected status %s", resp.Status)
+	}
+
+	var device Device
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&device); err != nil {
+		return nil, fmt.Errorf("decode registry response: %w", err)
+	}
+
+	return &device, nil
+}
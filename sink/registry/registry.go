@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Device is the metadata the registry holds for a sensor.
+type Device struct {
+	Location          string            `yaml:"location" json:"location"`
+	Model             string            `yaml:"model" json:"model"`
+	CalibrationOffset float64           `yaml:"calibration_offset" json:"calibration_offset"`
+	Tags              map[string]string `yaml:"tags" json:"tags"` // any further attributes
+}
+
+// Attributes flattens the device into tags, skipping empty fields.
+func (d *Device) Attributes() map[string]string {
+	attrs := make(map[string]string, len(d.Tags)+3)
+	for k, v := range d.Tags {
+		attrs[k] = v
+	}
+	if d.Location != "" {
+		attrs["location"] = d.Location
+	}
+	if d.Model != "" {
+		attrs["model"] = d.Model
+	}
+	if d.CalibrationOffset != 0 {
+		attrs["calibration_offset"] = strconv.FormatFloat(d.CalibrationOffset, 'g', -1, 64)
+	}
+	return attrs
+}
+
+// Registry looks up devices by sensor name. Lookup returns nil without an error for
+// sensors the registry doesn't know.
+type Registry interface {
+	Lookup(ctx context.Context, sensorName string) (*Device, error)
+}
+
+// Static is a registry loaded from a YAML file.
+type Static struct {
+	Devices map[string]*Device `yaml:"devices"`
+}
+
+// LoadStatic reads a YAML file mapping sensor names to devices.
+func LoadStatic(path string) (*Static, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read registry file: %w", err)
+	}
+
+	var static Static
+	if err := yaml.Unmarshal(data, &static); err != nil {
+		return nil, fmt.Errorf("parse registry file: %w", err)
+	}
+
+	return &static, nil
+}
+
+func (s *Static) Lookup(_ context.Context, sensorName string) (*Device, error) {
+	return s.Devices[sensorName], nil
+}
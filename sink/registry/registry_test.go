@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadStatic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.yaml")
+	data := `
+devices:
+  temperature-01:
+    location: boiler-room
+    model: TMP117
+    calibration_offset: -0.4
+    tags:
+      line: "2"
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	static, err := LoadStatic(path)
+	if err != nil {
+		t.Fatalf("LoadStatic() error = %v", err)
+	}
+
+	device, _ := static.Lookup(context.Background(), "temperature-01")
+	if device == nil {
+		t.Fatal("Lookup() = nil, want device")
+	}
+	want := map[string]string{"location": "boiler-room", "model": "TMP117", "calibration_offset": "-0.4", "line": "2"}
+	if got := device.Attributes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Attributes() = %v, want %v", got, want)
+	}
+
+	if device, _ := static.Lookup(context.Background(), "unknown"); device != nil {
+		t.Errorf("Lookup() = %+v, want nil for unknown sensor", device)
+	}
+}
+
+func TestHTTP_LookupCaches(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path != "/devices/temperature-01" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"location":"boiler-room","model":"TMP117"}`))
+	}))
+	defer srv.Close()
+
+	reg, err := NewHTTP(srv.URL+"/devices/{sensor}", time.Minute, time.Second)
+	if err != nil {
+		t.Fatalf("NewHTTP() error = %v", err)
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		device, err := reg.Lookup(ctx, "temperature-01")
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if device == nil || device.Location != "boiler-room" {
+			t.Fatalf("Lookup() = %+v, want boiler-room device", device)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if device, err := reg.Lookup(ctx, "unknown"); device != nil || err != nil {
+			t.Fatalf("Lookup() = %+v, %v, want nil, nil", device, err)
+		}
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("registry called %d times, want 2 (one per sensor)", got)
+	}
+}
+
+func TestHTTP_ServesStaleOnFailure(t *testing.T) {
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"model":"TMP117"}`))
+	}))
+	defer srv.Close()
+
+	reg, err := NewHTTP(srv.URL+"/{sensor}", time.Nanosecond, time.Second)
+	if err != nil {
+		t.Fatalf("NewHTTP() error = %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := reg.Lookup(ctx, "temperature-01"); err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+
+	failing.Store(true)
+	device, err := reg.Lookup(ctx, "temperature-01")
+	if err != nil || device == nil || device.Model != "TMP117" {
+		t.Errorf("Lookup() = %+v, %v, want stale device", device, err)
+	}
+	if _, err := reg.Lookup(ctx, "temperature-02"); err == nil {
+		t.Error("Lookup() should fail for an uncached sensor while the registry is down")
+	}
+}
+
+func TestNewHTTP_RequiresPlaceholder(t *testing.T) {
+	if _, err := NewHTTP("http://registry/devices", time.Minute, time.Second); err == nil {
+		t.Error("NewHTTP() should fail without {sensor} in the url")
+	}
+}
@@ -0,0 +1,399 @@
+// Package replication keeps what a primary sink has yet to replicate to its standby,
+// and what a standby has applied of it.
+//
+// The primary appends the entries of every accepted message to a journal as one
+// numbered batch. Batches are shipped to the standby, which acknowledges the highest
+// sequence number it has applied; acknowledged segments of the journal are removed.
+// A standby that was away is caught up by shipping whole journal segments.
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	encryption "github.com/sink/encryptor"
+)
+
+// SegmentSize is the size at which a journal segment is sealed and a new one started.
+// A sealed segment is shipped in one request, so it stays well below gRPC's default
+// 4MB message limit.
+const SegmentSize = 1 << 20
+
+const (
+	segmentSuffix = ".seg"
+	idFile        = "id"
+	// A record is its sequence number, payload length and CRC-32C, then the payload.
+	recordHeaderSize = 16
+)
+
+// ErrJournalFull is returned by Append when a batch would exceed the journal's quota.
+var ErrJournalFull = errors.New("replication journal full")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Journal is a primary's log of batches waiting for the standby's acknowledgement,
+// in segment files named after the sequence number of their first batch and bounded
+// by a quota. Batches are encrypted on disk when the log is. The batches of the
+// segment being appended to are also kept in memory, to be shipped as they come.
+type Journal struct {
+	dir       string
+	quota     int64
+	encryptor *encryption.Encryptor
+	bytes     prometheus.Gauge
+	id        string
+
+	mu     sync.Mutex
+	sealed []segment // oldest first
+	active *os.File
+	// Sequence number the active segment's file is named after and its size
+	activeFirst uint64
+	activeSize  int64
+	// Batches of the active segment not yet trimmed, from sequence number memFirst
+	batches  [][]byte
+	memFirst uint64
+	size     int64 // of every segment
+	next     uint64
+	scratch  []byte
+}
+
+type segment struct {
+	first uint64
+	size  int64
+}
+
+func segmentName(first uint64) string {
+	return fmt.Sprintf("%020d%s", first, segmentSuffix)
+}
+
+// OpenJournal creates dir if needed and picks up the batches journaled before. At
+// most quota bytes are kept, reported in bytes.
+func OpenJournal(dir string, quota int64, encryptor *encryption.Encryptor, bytes prometheus.Gauge) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create replication journal: %w", err)
+	}
+	j := &Journal{dir: dir, quota: quota, encryptor: encryptor, bytes: bytes, next: 1}
+
+	id, err := os.ReadFile(filepath.Join(dir, idFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		j.id = rand.Text()
+		if err := os.WriteFile(filepath.Join(dir, idFile), []byte(j.id+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("write replication journal ID: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("read replication journal ID: %w", err)
+	default:
+		j.id = strings.TrimSpace(string(id))
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read replication journal: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(name, segmentSuffix) || first == 0 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("read replication journal: %w", err)
+		}
+		j.sealed = append(j.sealed, segment{first: first, size: info.Size()})
+		j.size += info.Size()
+	}
+	slices.SortFunc(j.sealed, func(a, b segment) int { return compareUint(a.first, b.first) })
+
+	// The newest segment is appended to again, from its last intact batch.
+	if n := len(j.sealed); n > 0 {
+		last := j.sealed[n-1]
+		j.sealed = j.sealed[:n-1]
+		j.size -= last.size
+		if err := j.reopen(last.first); err != nil {
+			return nil, err
+		}
+	} else if err := j.startSegment(1); err != nil {
+		return nil, err
+	}
+	j.bytes.Set(float64(j.size))
+	return j, nil
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// reopen loads the batches of the segment starting at first, drops a torn batch left
+// by a crash at its end and continues appending to it.
+func (j *Journal) reopen(first uint64) error {
+	path := filepath.Join(j.dir, segmentName(first))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read replication journal: %w", err)
+	}
+	batches, intact, err := j.parse(data, first)
+	if err != nil {
+		return fmt.Errorf("read replication journal: %w", err)
+	}
+	if intact < len(data) {
+		log.Printf("Replication journal: dropping %d bytes of a torn batch at the end of %s", len(data)-intact, path)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("open replication journal: %w", err)
+	}
+	if err := f.Truncate(int64(intact)); err != nil {
+		f.Close()
+		return fmt.Errorf("open replication journal: %w", err)
+	}
+	if _, err := f.Seek(int64(intact), io.SeekStart); err != nil {
+		f.Close()
+		return fmt.Errorf("open replication journal: %w", err)
+	}
+
+	j.active, j.activeFirst, j.activeSize = f, first, int64(intact)
+	j.batches, j.memFirst = batches, first
+	j.size += int64(intact)
+	j.next = first + uint64(len(batches))
+	return nil
+}
+
+// parse returns the batches in a segment's data, which must be numbered from first,
+// and the length of the intact batches.
+func (j *Journal) parse(data []byte, first uint64) ([][]byte, int, error) {
+	var batches [][]byte
+	offset := 0
+	for len(data)-offset >= recordHeaderSize {
+		header := data[offset : offset+recordHeaderSize]
+		seq := binary.BigEndian.Uint64(header)
+		length := int(binary.BigEndian.Uint32(header[8:]))
+		end := offset + recordHeaderSize + length
+		if seq != first+uint64(len(batches)) || end > len(data) || end < offset {
+			break
+		}
+		payload := data[offset+recordHeaderSize : end]
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[12:]) {
+			break
+		}
+		if j.encryptor != nil {
+			var err error
+			if payload, err = j.encryptor.Decrypt(payload); err != nil {
+				return nil, 0, err
+			}
+		}
+		batches = append(batches, payload)
+		offset = end
+	}
+	return batches, offset, nil
+}
+
+// startSegment starts an empty segment for the batch numbered first.
+func (j *Journal) startSegment(first uint64) error {
+	f, err := os.OpenFile(filepath.Join(j.dir, segmentName(first)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("create replication journal segment: %w", err)
+	}
+	j.active, j.activeFirst, j.activeSize = f, first, 0
+	j.batches, j.memFirst = nil, first
+	j.next = first
+	return nil
+}
+
+// ID identifies the journal; standbys keep their position per journal.
+func (j *Journal) ID() string {
+	return j.id
+}
+
+// Next returns the sequence number the next batch will get.
+func (j *Journal) Next() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.next
+}
+
+// Append adds a batch and returns its sequence number. Batches are written without
+// waiting for the disk; segments are synced as they are sealed.
+func (j *Journal) Append(batch []byte) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.activeSize >= SegmentSize {
+		if err := j.seal(); err != nil {
+			return 0, err
+		}
+	}
+
+	payload := batch
+	if j.encryptor != nil {
+		var err error
+		if payload, err = j.encryptor.EncryptAppend(j.scratch[:0], batch); err != nil {
+			return 0, err
+		}
+		j.scratch = payload
+	}
+	size := int64(recordHeaderSize + len(payload))
+	if j.size+size > j.quota {
+		return 0, ErrJournalFull
+	}
+
+	seq := j.next
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint64(header[:], seq)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[12:], crc32.Checksum(payload, crcTable))
+	if _, err := j.active.Write(append(header[:], payload...)); err != nil {
+		// The segment may end in a partial batch now; start over in a new one.
+		j.seal()
+		return 0, fmt.Errorf("write replication journal: %w", err)
+	}
+
+	j.batches = append(j.batches, slices.Clone(batch))
+	j.activeSize += size
+	j.size += size
+	j.next++
+	j.bytes.Set(float64(j.size))
+	return seq, nil
+}
+
+// SkipTo continues numbering batches at next, if that is ahead. A standby may have
+// applied batches that a crash tore off the journal's end before they were synced;
+// the batches appended since must not reuse their numbers.
+func (j *Journal) SkipTo(next uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if next <= j.next {
+		return nil
+	}
+	if j.activeSize > 0 {
+		if err := j.seal(); err != nil {
+			return err
+		}
+	}
+	j.active.Close()
+	os.Remove(filepath.Join(j.dir, segmentName(j.activeFirst)))
+	return j.startSegment(next)
+}
+
+// seal syncs and closes the active segment and starts the next one.
+func (j *Journal) seal() error {
+	if err := j.active.Sync(); err != nil {
+		return fmt.Errorf("sync replication journal: %w", err)
+	}
+	if err := j.active.Close(); err != nil {
+		return fmt.Errorf("close replication journal segment: %w", err)
+	}
+	j.sealed = append(j.sealed, segment{first: j.activeFirst, size: j.activeSize})
+	return j.startSegment(j.next)
+}
+
+// Read returns the batches after sequence number after, starting at first. Batches
+// in a sealed segment are read from disk, all of that segment's at once; those of
+// the active segment come from memory, up to about maxBytes. It returns no batches
+// when the journal holds none after after. A standby that is further behind than
+// the journal goes starts from the oldest batch kept.
+func (j *Journal) Read(after uint64, maxBytes int) (first uint64, batches [][]byte, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for i, s := range j.sealed {
+		last := j.activeFirst - 1
+		if i+1 < len(j.sealed) {
+			last = j.sealed[i+1].first - 1
+		}
+		if after >= last {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(j.dir, segmentName(s.first)))
+		if err != nil {
+			return 0, nil, fmt.Errorf("read replication journal: %w", err)
+		}
+		all, _, err := j.parse(data, s.first)
+		if err != nil {
+			return 0, nil, fmt.Errorf("read replication journal: %w", err)
+		}
+		skip := 0
+		if after >= s.first {
+			skip = int(min(after-s.first+1, uint64(len(all))))
+		}
+		return s.first + uint64(skip), all[skip:], nil
+	}
+
+	skip := 0
+	if after >= j.memFirst {
+		skip = int(min(after-j.memFirst+1, uint64(len(j.batches))))
+	}
+	first = j.memFirst + uint64(skip)
+	size := 0
+	for _, batch := range j.batches[skip:] {
+		if len(batches) > 0 && size+len(batch) > maxBytes {
+			break
+		}
+		batches = append(batches, batch)
+		size += len(batch)
+	}
+	return first, batches, nil
+}
+
+// Trim removes the batches up to sequence number acked, which the standby has.
+// Sealed segments are removed whole; the active segment's file stays until sealed.
+func (j *Journal) Trim(acked uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var errs []error
+	for len(j.sealed) > 0 {
+		s := j.sealed[0]
+		last := j.activeFirst - 1
+		if len(j.sealed) > 1 {
+			last = j.sealed[1].first - 1
+		}
+		if acked < last {
+			break
+		}
+		if err := os.Remove(filepath.Join(j.dir, segmentName(s.first))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			break
+		}
+		j.sealed = j.sealed[1:]
+		j.size -= s.size
+	}
+	if acked >= j.memFirst {
+		n := min(acked-j.memFirst+1, uint64(len(j.batches)))
+		clear(j.batches[:n])
+		j.batches = j.batches[n:]
+		j.memFirst += n
+	}
+	j.bytes.Set(float64(j.size))
+	return errors.Join(errs...)
+}
+
+// Close syncs and closes the active segment.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	err := j.active.Sync()
+	return errors.Join(err, j.active.Close())
+}
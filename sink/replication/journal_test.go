@@ -0,0 +1,131 @@
+package replication
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	encryption "github.com/sink/encryptor"
+)
+
+func openJournal(t *testing.T, dir string, quota int64, e *encryption.Encryptor) *Journal {
+	t.Helper()
+	j, err := OpenJournal(dir, quota, e, prometheus.NewGauge(prometheus.GaugeOpts{Name: "journal_bytes"}))
+	if err != nil {
+		t.Fatalf("OpenJournal() error = %v", err)
+	}
+	t.Cleanup(func() { j.Close() })
+	return j
+}
+
+func appendBatch(t *testing.T, j *Journal, batch []byte, want uint64) {
+	t.Helper()
+	seq, err := j.Append(batch)
+	if err != nil || seq != want {
+		t.Fatalf("Append() = %d, %v, want %d", seq, err, want)
+	}
+}
+
+func TestJournal_ShipsActiveBatchesAndSealedSegments(t *testing.T) {
+	dir := t.TempDir()
+	j := openJournal(t, dir, 1<<30, nil)
+
+	large := bytes.Repeat([]byte("x"), SegmentSize/2)
+	appendBatch(t, j, large, 1)
+	appendBatch(t, j, large, 2)
+	// The segment reached SegmentSize, so the third batch starts a new one.
+	appendBatch(t, j, []byte("c\n"), 3)
+	appendBatch(t, j, []byte("d\n"), 4)
+
+	// A standby that is behind gets the whole sealed segment from its position on.
+	first, batches, err := j.Read(1, 1<<20)
+	if err != nil || first != 2 || len(batches) != 1 || len(batches[0]) != len(large) {
+		t.Fatalf("Read(1) = %d, %d batches, %v, want the rest of the sealed segment from 2", first, len(batches), err)
+	}
+	first, batches, err = j.Read(2, 1<<20)
+	if err != nil || first != 3 || len(batches) != 2 || string(batches[1]) != "d\n" {
+		t.Fatalf("Read(2) = %d, %q, %v, want batches 3 and 4 from memory", first, batches, err)
+	}
+	if _, batches, _ := j.Read(4, 1<<20); len(batches) != 0 {
+		t.Errorf("Read(4) = %q, want nothing", batches)
+	}
+
+	if err := j.Trim(3); err != nil {
+		t.Fatalf("Trim() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, segmentName(1))); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("acknowledged segment kept: %v", err)
+	}
+	// A standby further behind than the journal goes starts from its oldest batch.
+	if first, batches, _ := j.Read(0, 1<<20); first != 4 || len(batches) != 1 {
+		t.Errorf("Read(0) after Trim(3) = %d, %d batches, want batch 4", first, len(batches))
+	}
+}
+
+func TestJournal_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	e, err := encryption.NewEncryptor(encryption.CipherAESGCM, base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := openJournal(t, dir, 1<<30, e)
+	appendBatch(t, j, []byte("secret reading\n"), 1)
+	appendBatch(t, j, []byte("b\n"), 2)
+	id := j.ID()
+	j.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, segmentName(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Error("journal holds entries of an encrypted log in the clear")
+	}
+	// A crash tore the end of the last batch.
+	if err := os.WriteFile(filepath.Join(dir, segmentName(1)), data[:len(data)-3], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	j = openJournal(t, dir, 1<<30, e)
+	if j.ID() != id {
+		t.Errorf("ID() = %q after reopening, want %q", j.ID(), id)
+	}
+	if first, batches, _ := j.Read(0, 1<<20); first != 1 || len(batches) != 1 || string(batches[0]) != "secret reading\n" {
+		t.Errorf("Read(0) after reopening = %d, %q, want the intact batch", first, batches)
+	}
+	appendBatch(t, j, []byte("c\n"), 2)
+
+	// The standby applied batches up to 5 before the crash.
+	if err := j.SkipTo(6); err != nil {
+		t.Fatalf("SkipTo() error = %v", err)
+	}
+	appendBatch(t, j, []byte("d\n"), 6)
+}
+
+func TestJournal_Quota(t *testing.T) {
+	j := openJournal(t, t.TempDir(), 64, nil)
+	appendBatch(t, j, bytes.Repeat([]byte("a"), 30), 1)
+	if _, err := j.Append(bytes.Repeat([]byte("b"), 30)); !errors.Is(err, ErrJournalFull) {
+		t.Errorf("Append() over the quota error = %v, want ErrJournalFull", err)
+	}
+	appendBatch(t, j, []byte("c"), 2)
+}
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telemetry.log.replica")
+	if state, err := LoadState(path); err != nil || state != (State{}) {
+		t.Fatalf("LoadState() of a new standby = %+v, %v, want the zero State", state, err)
+	}
+	want := State{Primary: "journal", Applied: 42}
+	if err := want.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if state, err := LoadState(path); err != nil || state != want {
+		t.Errorf("LoadState() = %+v, %v, want %+v", state, err, want)
+	}
+}
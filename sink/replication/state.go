@@ -0,0 +1,50 @@
+package replication
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// State is what a standby has applied of a primary's journal.
+type State struct {
+	Primary string `json:"primary"` // journal ID
+	Applied uint64 `json:"applied"` // highest sequence number applied
+}
+
+// LoadState reads the state at path; a standby that never applied a batch has the
+// zero State.
+func LoadState(path string) (State, error) {
+	var state State
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("read replication state: %w", err)
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("parse replication state: %w", err)
+	}
+	return state, nil
+}
+
+// Save replaces the state at path through a temporary file, so a crash leaves either
+// the old or the new state. It doesn't wait for the disk: like the entries applied,
+// which wait in the buffer, the latest state may be lost in a power failure, and the
+// batches since are then applied again.
+func (s State) Save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write replication state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write replication state: %w", err)
+	}
+	return nil
+}
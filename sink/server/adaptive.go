@@ -0,0 +1,73 @@
+package server
+
+import (
+	"time"
+
+	"github.com/sink/config"
+)
+
+// Adaptive flushing measures the ingest rate in windows of adaptiveWindow. A window
+// taking in less than adaptiveDrop of the average rate ends a burst, and the buffer
+// is written rather than left waiting for the flush interval. The average follows
+// the windows with weight adaptiveAlpha.
+const (
+	adaptiveWindow = 100 * time.Millisecond
+	adaptiveDrop   = 0.25
+	adaptiveAlpha  = 0.2
+)
+
+// ingestRate tracks the bytes buffered per window for adaptive flushing. It is
+// guarded by bufferMutex.
+type ingestRate struct {
+	window  int     // bytes buffered in the current window
+	average float64 // bytes per window
+}
+
+func (s *SinkServer) adaptiveFlush() bool {
+	return s.config.FlushPolicy == config.FlushPolicyAdaptive
+}
+
+// flushTarget returns how many bytes adaptive flushing writes at a time.
+func (s *SinkServer) flushTarget() int {
+	if s.config.FlushTargetSize > 0 {
+		return s.config.FlushTargetSize
+	}
+	return s.config.BufferSize
+}
+
+// noteBuffered counts size bytes buffered and, with adaptive flushing, writes a
+// buffer that reached the target size while the writer keeps up. While it is behind,
+// buffers grow up to BufferSize, so fewer and larger writes catch up. Callers hold
+// bufferMutex.
+func (s *SinkServer) noteBuffered(size int) {
+	if !s.adaptiveFlush() {
+		return
+	}
+	s.rate.window += size
+	if len(s.buffer) >= s.flushTarget() && s.writer.QueueLen() == 0 {
+		// On failure the buffer waits for the next flush.
+		s.flushBuffer()
+	}
+}
+
+// flushOnRateDrop closes an adaptive flushing window and writes the buffers when
+// the window took in far less than the average: a burst ended, and entries left in
+// the buffer would otherwise wait for the flush interval.
+func (s *SinkServer) flushOnRateDrop() {
+	s.bufferMutex.Lock()
+	defer s.bufferMutex.Unlock()
+
+	window := float64(s.rate.window)
+	dropped := window < adaptiveDrop*s.rate.average
+	s.rate.average += adaptiveAlpha * (window - s.rate.average)
+	s.rate.window = 0
+
+	if !dropped || len(s.buffer) == 0 {
+		return
+	}
+	if err := s.flushBuffer(); err == nil {
+		for _, f := range s.fanOut {
+			f.flush()
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/sink/liveness"
+	"github.com/sink/metrics"
+)
+
+// adminRetryInterval is how often binding the admin address is retried. During an
+// upgrade the parent holds the address until the new process is ready.
+const adminRetryInterval = time.Second
+
+// adminHandler serves the admin endpoints. They aren't authenticated, so the admin
+// address should only be reachable by operators.
+func (s *SinkServer) adminHandler() http.Handler {
+	registry := metrics.NewRegistry(liveness.NewCollector(s.sensors))
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("GET /sensors", s.handleSensors)
+	mux.HandleFunc("GET /bans", s.handleBans)
+	mux.HandleFunc("PUT /bans/{ip}", s.handleBan)
+	mux.HandleFunc("DELETE /bans/{ip}", s.handleUnban)
+	mux.HandleFunc("POST /snapshot", s.handleSnapshot)
+	mux.HandleFunc("GET /redirect", s.handleRedirect)
+	mux.HandleFunc("PUT /redirect", s.handleSetRedirect)
+	mux.HandleFunc("DELETE /redirect", s.handleClearRedirect)
+	mux.HandleFunc("GET /healthz", handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	return mux
+}
+
+// handleHealth reports that the process is up. It never fails while the admin server
+// answers; use /readyz to decide whether to send traffic.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReady reports whether the sink accepts readings: the gRPC server is serving
+// and the last write to the log file succeeded. It fails with 503 during startup,
+// draining and shutdown, on the standby of an active/standby pair, while the watchdog
+// sheds load and while the disk is failing.
+func (s *SinkServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	if err := s.ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *SinkServer) ready() error {
+	if s.draining.Load() {
+		return errors.New("draining")
+	}
+	if !s.serving.Load() {
+		return errors.New("not serving")
+	}
+	if !s.isLeader() {
+		return errors.New("standby")
+	}
+	if reason := s.overloaded.Load(); reason != nil {
+		return fmt.Errorf("overloaded: %s", *reason)
+	}
+	if err := s.writer.Err(); err != nil {
+		return fmt.Errorf("log write failed: %v", err)
+	}
+	if reason := s.panicked.Load(); reason != nil {
+		return errors.New(*reason)
+	}
+	if err := s.writer.Panicked(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// handleSensors lists tracked sensors as JSON. ?silent=true lists only silent ones.
+func (s *SinkServer) handleSensors(w http.ResponseWriter, r *http.Request) {
+	sensors := s.Sensors()
+	if r.URL.Query().Get("silent") == "true" {
+		silent := sensors[:0]
+		for _, sensor := range sensors {
+			if sensor.Silent {
+				silent = append(silent, sensor)
+			}
+		}
+		sensors = silent
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sensors); err != nil {
+		log.Printf("Admin: encode sensors: %v", err)
+	}
+}
+
+// Sensors lists the tracked sensors as of now, as served on /sensors.
+func (s *SinkServer) Sensors() []liveness.Sensor {
+	return s.sensors.List(s.now())
+}
+
+// serveAdmin runs the admin HTTP server until the sink stops.
+func (s *SinkServer) serveAdmin() {
+	defer s.wg.Done()
+
+	srv := &http.Server{
+		Addr:              s.config.AdminAddr,
+		Handler:           s.adminHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-s.done
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	for {
+		log.Printf("Admin server listening on %s", s.config.AdminAddr)
+		err := srv.ListenAndServe()
+		if errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		log.Printf("Admin server: %v, retrying in %v", err, adminRetryInterval)
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(adminRetryInterval):
+		}
+	}
+}
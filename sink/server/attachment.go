@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/sink/deadletter"
+	"github.com/sink/errdefs"
+	"github.com/sink/processor"
+)
+
+// storeAttachments moves the entries' attachments to the attachment directory, when
+// one is configured, leaving the ID they are stored under in their place. Entries
+// split from one reading share its attachment, which is stored once.
+func (s *SinkServer) storeAttachments(in *incoming, entries []*processor.Entry) error {
+	if s.config.AttachmentDir == "" {
+		return nil
+	}
+
+	for _, entry := range entries {
+		if entry.Attachment == nil {
+			continue
+		}
+		id, err := s.writeAttachment(entry.Attachment)
+		if err != nil {
+			log.Printf("failed to store attachment from %s: %v", in.sensorName, err)
+			return s.reject(in, deadletter.ReasonUnavailable, errdefs.Errorf(errdefs.ErrStorage, "failed to store attachment: %w", err))
+		}
+		entry.AttachmentID = id
+		entry.Attachment = nil
+	}
+	return nil
+}
+
+// writeAttachment writes an attachment to the attachment directory, encrypted when
+// the log is, and returns its ID: the hex SHA-256 of its content. An attachment that
+// is already stored is not written again.
+func (s *SinkServer) writeAttachment(attachment []byte) (string, error) {
+	sum := sha256.Sum256(attachment)
+	id := hex.EncodeToString(sum[:])
+	path := filepath.Join(s.config.AttachmentDir, id)
+
+	if _, err := os.Stat(path); err == nil {
+		return id, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	data := attachment
+	if s.encryptor != nil {
+		encrypted, err := s.encryptor.Encrypt(attachment)
+		if err != nil {
+			return "", fmt.Errorf("encrypt: %w", err)
+		}
+		data = encrypted
+	}
+
+	// Written to a temporary file and renamed so a crash never leaves a partial
+	// attachment under its ID.
+	f, err := os.CreateTemp(s.config.AttachmentDir, ".tmp-"+id+"-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+	return id, nil
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+
+	"github.com/sink/audit"
+)
+
+type remoteAddrKey struct{}
+
+// connAuditor records client connects and disconnects in the audit log.
+type connAuditor struct {
+	audit *audit.Logger
+}
+
+func (c *connAuditor) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (c *connAuditor) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (c *connAuditor) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return context.WithValue(ctx, remoteAddrKey{}, info.RemoteAddr.String())
+}
+
+func (c *connAuditor) HandleConn(ctx context.Context, st stats.ConnStats) {
+	remote, _ := ctx.Value(remoteAddrKey{}).(string)
+
+	switch st.(type) {
+	case *stats.ConnBegin:
+		c.audit.Log(audit.EventClientConnect, "", remote, "")
+	case *stats.ConnEnd:
+		c.audit.Log(audit.EventClientDisconnect, "", remote, "")
+	}
+}
+
+// auditEvent records an event if the audit log is enabled.
+func (s *SinkServer) auditEvent(ctx context.Context, eventType, identity, detail string) {
+	if s.audit == nil {
+		return
+	}
+
+	var remote string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remote = p.Addr.String()
+	}
+
+	s.audit.Log(eventType, identity, remote, detail)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sink/audit"
+	"github.com/sink/auth"
+	"github.com/sink/config"
+)
+
+var errPolicyWithoutAuth = errors.New("authorization policy requires authenticated clients (-auth, or mTLS with -tls and -ca-file, or -spiffe-socket)")
+
+// authEnabled reports whether clients are authenticated: by AuthMethods, or by their
+// certificate with mTLS when none are set.
+func authEnabled(config config.Config) bool {
+	return len(config.AuthMethods) > 0 || mtlsEnabled(config)
+}
+
+// loadAuth loads the bearer tokens and sets up the OIDC provider the configuration
+// authenticates clients with. Both are nil when not used.
+func loadAuth(config config.Config) (*auth.Tokens, *auth.OIDC, error) {
+	var tokens *auth.Tokens
+	if config.AuthTokensFile != "" {
+		var err error
+		if tokens, err = auth.LoadTokens(config.AuthTokensFile); err != nil {
+			return nil, nil, fmt.Errorf("failed to load bearer tokens: %w", err)
+		}
+		log.Printf("Bearer tokens loaded for %d clients", tokens.Len())
+	}
+
+	var oidc *auth.OIDC
+	if slices.Contains(config.AuthMethods, auth.MethodOIDC) {
+		var err error
+		oidc, err = auth.NewOIDC(auth.OIDCConfig{
+			Issuer:       config.OIDCIssuer,
+			Audience:     config.OIDCAudience,
+			JWKSURL:      config.OIDCJWKSURL,
+			SubjectClaim: config.OIDCSubjectClaim,
+			KeyRefresh:   config.OIDCKeyRefresh,
+			Clock:        config.Clock,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to set up OIDC: %w", err)
+		}
+	}
+
+	return tokens, oidc, nil
+}
+
+// newAuthenticator chains the authenticators of AuthMethods in order, or returns one
+// of client certificates with mTLS and nil otherwise when none are set.
+func (s *SinkServer) newAuthenticator(oidc *auth.OIDC) auth.Authenticator {
+	methods := s.config.AuthMethods
+	if len(methods) == 0 {
+		if !mtlsEnabled(s.config) {
+			return nil
+		}
+		methods = []string{auth.MethodMTLS}
+	}
+
+	var chain auth.Chain
+	for _, method := range methods {
+		switch method {
+		case auth.MethodToken:
+			// The tokens are looked up on every call, so a reload applies to them.
+			chain = append(chain, auth.AuthenticatorFunc(func(ctx context.Context) (*auth.Identity, error) {
+				return s.tokens.Load().Authenticate(ctx)
+			}))
+		case auth.MethodMTLS:
+			chain = append(chain, auth.MTLS{Name: clientIdentity})
+		case auth.MethodOIDC:
+			chain = append(chain, oidc)
+		}
+	}
+	return chain
+}
+
+// identify authenticates the client of a call. The identity is nil when clients
+// aren't authenticated.
+func (s *SinkServer) identify(ctx context.Context) (*auth.Identity, error) {
+	if s.authenticator == nil {
+		return nil, nil
+	}
+	id, err := s.authenticator.Authenticate(ctx)
+	if err != nil {
+		log.Printf("Client authentication failed: %v", err)
+		s.auditEvent(ctx, audit.EventAuthFailure, "", err.Error())
+		return nil, status.Errorf(codes.Unauthenticated, "authentication failed: %v", err)
+	}
+	if id.Cert != nil {
+		log.Printf("Client authenticated with certificate: Identity=%s, Subject=%s, Issuer=%s", id, id.Cert.Subject, id.Cert.Issuer)
+	}
+	return id, nil
+}
+
+func (s *SinkServer) reloadTokens() error {
+	tokens, err := auth.LoadTokens(s.config.AuthTokensFile)
+	if err != nil {
+		s.auditEvent(context.Background(), audit.EventConfigReload, "", fmt.Sprintf("failed: %v", err))
+		return fmt.Errorf("reload bearer tokens: %w", err)
+	}
+
+	s.tokens.Store(tokens)
+	s.auditEvent(context.Background(), audit.EventConfigReload, "", fmt.Sprintf("bearer tokens %s, %d clients", s.config.AuthTokensFile, tokens.Len()))
+	log.Printf("Bearer tokens reloaded for %d clients", tokens.Len())
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/sink/listener"
+	"github.com/sink/metrics"
+)
+
+// guardListener closes connections from client IPs the IP access list denies and
+// from banned ones and, with HandshakesPerIP, connections beyond the rate of their
+// IP before the handshake.
+func (s *SinkServer) guardListener(lis net.Listener) net.Listener {
+	return listener.Filter(lis, func(ip string) string {
+		if reason := s.ipAccess.Load().Check(ip); reason != "" {
+			metrics.ConnectionsRejected.WithLabelValues("ip_access").Inc()
+			return reason
+		}
+		if ban, banned := s.bans.Banned(ip); banned {
+			metrics.ConnectionsRejected.WithLabelValues("banned").Inc()
+			return fmt.Sprintf("banned until %s", ban.Until.Format(time.RFC3339))
+		}
+		if s.handshakes != nil && !s.handshakes.Allow(context.Background(), ip, 1) {
+			metrics.ConnectionsRejected.WithLabelValues("handshake_rate").Inc()
+			return fmt.Sprintf("limit of %d new connections per second per IP reached", s.config.HandshakesPerIP)
+		}
+		return ""
+	})
+}
+
+// guardUnary rejects calls from client IPs denied or banned after they connected,
+// as by a reloaded IP access list, and counts the authentication failures of the
+// others.
+func (s *SinkServer) guardUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ip, ok := peerIP(ctx)
+	if !ok {
+		return handler(ctx, req)
+	}
+
+	var resp any
+	err := s.guard(ip, func() (err error) {
+		resp, err = handler(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// guardStream is guardUnary for streaming handlers.
+func (s *SinkServer) guardStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ip, ok := peerIP(ss.Context())
+	if !ok {
+		return handler(srv, ss)
+	}
+
+	return s.guard(ip, func() error { return handler(srv, ss) })
+}
+
+// guard runs a call from the client at ip unless the client is denied or banned,
+// and counts the call towards a ban if it fails authentication. The gRPC
+// interceptors and the Connect, frame and CoAP handlers all go through it.
+func (s *SinkServer) guard(ip string, call func() error) error {
+	if err := s.checkClient(ip); err != nil {
+		return err
+	}
+
+	err := call()
+	s.recordAuth(ip, err)
+	return err
+}
+
+func (s *SinkServer) checkClient(ip string) error {
+	if reason := s.ipAccess.Load().Check(ip); reason != "" {
+		return status.Errorf(codes.PermissionDenied, "client %s is %s", ip, reason)
+	}
+	if ban, banned := s.bans.Banned(ip); banned {
+		return status.Errorf(codes.PermissionDenied, "client %s is banned until %s", ip, ban.Until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// recordAuth counts a call that failed authentication or authorization towards the
+// ban of its client IP, with BanAfter set.
+func (s *SinkServer) recordAuth(ip string, err error) {
+	if s.config.BanAfter == 0 {
+		return
+	}
+	if code := status.Code(err); code != codes.Unauthenticated && code != codes.PermissionDenied {
+		return
+	}
+	if ban, banned := s.bans.Fail(ip); banned {
+		log.Printf("Banning %s until %s after %d failed authentications within %v", ip, ban.Until.Format(time.RFC3339), s.config.BanAfter, s.config.BanWindow)
+		metrics.ClientsBanned.Inc()
+	}
+}
+
+// peerIP returns the IP address of the client of a call, if it connected over TCP.
+func peerIP(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	if _, ok := p.Addr.(*net.TCPAddr); !ok {
+		return "", false
+	}
+	return listener.ClientIP(p.Addr)
+}
+
+// parseBanIP parses the client IP of an admin ban request into the form peerIP
+// returns, keeping the zone of a link-local address such as fe80::1%eth0.
+func parseBanIP(value string) (netip.Addr, bool) {
+	ip, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap(), true
+}
+
+// handleBans lists the bans in effect as JSON.
+func (s *SinkServer) handleBans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.bans.List()); err != nil {
+		log.Printf("Admin: encode bans: %v", err)
+	}
+}
+
+// handleBan bans the client IP in the path for ?duration, by default BanDuration,
+// giving ?reason.
+func (s *SinkServer) handleBan(w http.ResponseWriter, r *http.Request) {
+	ip, ok := parseBanIP(r.PathValue("ip"))
+	if !ok {
+		http.Error(w, "invalid IP address", http.StatusBadRequest)
+		return
+	}
+	duration := s.config.BanDuration
+	if value := r.URL.Query().Get("duration"); value != "" {
+		var err error
+		if duration, err = time.ParseDuration(value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid duration: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if duration <= 0 {
+		http.Error(w, "duration must be positive", http.StatusBadRequest)
+		return
+	}
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		reason = "banned by an operator"
+	}
+
+	ban := s.bans.Ban(ip.String(), duration, reason)
+	log.Printf("Admin: banned %s until %s: %s", ban.IP, ban.Until.Format(time.RFC3339), reason)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ban); err != nil {
+		log.Printf("Admin: encode ban: %v", err)
+	}
+}
+
+// handleUnban lifts the ban of the client IP in the path.
+func (s *SinkServer) handleUnban(w http.ResponseWriter, r *http.Request) {
+	ip, ok := parseBanIP(r.PathValue("ip"))
+	if !ok {
+		http.Error(w, "invalid IP address", http.StatusBadRequest)
+		return
+	}
+	if !s.bans.Unban(ip.String()) {
+		http.Error(w, "not banned", http.StatusNotFound)
+		return
+	}
+	log.Printf("Admin: lifted the ban of %s", ip)
+	w.WriteHeader(http.StatusNoContent)
+}
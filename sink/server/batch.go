@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sink/errdefs"
+	pb "github.com/sink/proto"
+	"github.com/sink/state"
+)
+
+const (
+	// maxBatchSize bounds the readings of one SendSensorDataBatch call.
+	maxBatchSize = 1000
+
+	// recentPerSensor is how many timestamps of readings accepted in batches are
+	// remembered per sensor to recognize a resent batch.
+	recentPerSensor = 64
+)
+
+// SendSensorDataBatch stores each reading like a SendSensorData call and reports
+// its outcome separately. Only a client failing authentication or an oversized batch
+// fail the whole call.
+func (s *SinkServer) SendSensorDataBatch(ctx context.Context, req *pb.SensorDataBatch) (*pb.SensorDataBatchResponse, error) {
+	if len(req.Readings) > maxBatchSize {
+		return nil, errdefs.Errorf(errdefs.ErrInvalid, "batch of %d readings, at most %d are allowed", len(req.Readings), maxBatchSize)
+	}
+	if _, err := s.identify(ctx); err != nil {
+		return nil, err
+	}
+
+	resp := &pb.SensorDataBatchResponse{
+		Results:    make([]*pb.ReadingResult, len(req.Readings)),
+		ReceivedAt: timestamppb.New(s.now()),
+	}
+	tenant := tenantFromContext(ctx)
+	for i, reading := range req.Readings {
+		resp.Results[i] = s.storeBatchReading(ctx, tenant, reading)
+	}
+
+	var accepted int
+	for _, result := range resp.Results {
+		if result.Status == pb.ReadingStatus_READING_STATUS_ACCEPTED {
+			accepted++
+		}
+	}
+	log.Printf("Received batch of %d readings, %d accepted", len(req.Readings), accepted)
+
+	resp.Draining = s.draining.Load()
+	resp.Throttle = s.throttleHint()
+	resp.Redirect = s.redirectTo()
+	return resp, nil
+}
+
+// storeBatchReading stores a reading of a batch unless it is a duplicate. The
+// result carries the reading's request ID.
+func (s *SinkServer) storeBatchReading(ctx context.Context, tenant string, reading *pb.SensorData) *pb.ReadingResult {
+	result := s.batchReadingResult(ctx, tenant, reading)
+	result.RequestId = readingRequestID(ctx, reading)
+	return result
+}
+
+func (s *SinkServer) batchReadingResult(ctx context.Context, tenant string, reading *pb.SensorData) *pb.ReadingResult {
+	id := readingID{sequence: reading.Sequence}
+	if reading.Timestamp != nil {
+		id.timestamp = reading.Timestamp.AsTime()
+	}
+	// Fire-and-forget readings aren't resent, so they aren't worth remembering.
+	forget := reading.Delivery == pb.Delivery_DELIVERY_FIRE_AND_FORGET
+	claimed := !forget && (!id.timestamp.IsZero() || id.sequence != 0)
+	if claimed && !s.recent.claim(tenant, reading.SensorName, id, s.now()) {
+		return &pb.ReadingResult{
+			Status:  pb.ReadingStatus_READING_STATUS_DUPLICATE,
+			Message: "reading already accepted",
+		}
+	}
+
+	resp, err := s.SendSensorData(ctx, reading)
+	if err == nil {
+		return &pb.ReadingResult{Status: pb.ReadingStatus_READING_STATUS_ACCEPTED, Sequence: resp.Sequence}
+	}
+	if claimed {
+		s.recent.release(tenant, reading.SensorName, id, s.now())
+	}
+
+	st := status.Convert(err)
+	result := &pb.ReadingResult{Status: pb.ReadingStatus_READING_STATUS_UNAVAILABLE, Message: st.Message()}
+	switch st.Code() {
+	case codes.InvalidArgument, codes.PermissionDenied, codes.Unauthenticated:
+		result.Status = pb.ReadingStatus_READING_STATUS_INVALID
+	case codes.ResourceExhausted:
+		result.Status = pb.ReadingStatus_READING_STATUS_THROTTLED
+	}
+	return result
+}
+
+type sensorKey struct {
+	tenant string
+	name   string
+}
+
+// recentReadings remembers the latest readings each sensor had accepted in batches,
+// so that readings resent after a lost response are reported as duplicates instead
+// of being stored twice.
+type recentReadings struct {
+	mu      sync.Mutex
+	sensors *state.Table[sensorKey, *recentIDs]
+}
+
+// readingID identifies a reading of a sensor by the sequence number the node gave
+// it or, for nodes that don't number readings, by its timestamp.
+type readingID struct {
+	timestamp time.Time
+	sequence  uint64
+}
+
+func (id readingID) is(other readingID) bool {
+	if id.sequence != 0 || other.sequence != 0 {
+		return id.sequence == other.sequence
+	}
+	return id.timestamp.Equal(other.timestamp)
+}
+
+// recentIDs is a ring of a sensor's latest reading IDs.
+type recentIDs struct {
+	ids  [recentPerSensor]readingID
+	next int
+}
+
+func newRecentReadings(limits state.Limits) *recentReadings {
+	return &recentReadings{
+		sensors: state.NewTable[sensorKey, *recentIDs]("batch_readings", limits),
+	}
+}
+
+// claim remembers a reading and reports whether it is new. A claimed reading that
+// isn't stored must be released.
+func (r *recentReadings) claim(tenant, name string, id readingID, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recent := r.sensors.GetOrCreate(sensorKey{tenant: tenant, name: name}, now, func() *recentIDs {
+		return &recentIDs{}
+	})
+	for _, seen := range recent.ids {
+		if seen.is(id) {
+			return false
+		}
+	}
+
+	recent.ids[recent.next] = id
+	recent.next = (recent.next + 1) % recentPerSensor
+	return true
+}
+
+// release forgets a claimed reading, so it may be sent again.
+func (r *recentReadings) release(tenant, name string, id readingID, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	recent, ok := r.sensors.Get(sensorKey{tenant: tenant, name: name}, now)
+	if !ok {
+		return
+	}
+	for i, seen := range recent.ids {
+		if seen.is(id) {
+			recent.ids[i] = readingID{}
+		}
+	}
+}
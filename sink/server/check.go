@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sink/audit"
+	"github.com/sink/authz"
+	"github.com/sink/clock"
+	"github.com/sink/config"
+	"github.com/sink/ipaccess"
+	"github.com/sink/processor"
+)
+
+// CheckConfig loads everything the configuration refers to (keys, certificates, tokens,
+// the OIDC provider's signing keys, policy and pipeline files) without opening the log
+// or binding any address, so a deployment can be validated before it replaces a
+// running sink.
+func CheckConfig(cfg config.Config) error {
+	if cfg.EnableEncryption {
+		if _, err := newEncryptor(cfg); err != nil {
+			return fmt.Errorf("encryption: %w", err)
+		}
+	}
+
+	if info, err := os.Stat(filepath.Dir(cfg.LogFilePath)); err != nil {
+		return fmt.Errorf("log file directory: %w", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("log file directory: %s is not a directory", filepath.Dir(cfg.LogFilePath))
+	}
+
+	if cfg.UseTLS && cfg.SpiffeSocket == "" {
+		s := &SinkServer{config: cfg}
+		if _, err := s.loadTLSConfig(); err != nil {
+			return fmt.Errorf("TLS: %w", err)
+		}
+	}
+
+	if _, _, err := loadAuth(cfg); err != nil {
+		return err
+	}
+
+	if cfg.AuthzPolicyFile != "" {
+		if !authEnabled(cfg) {
+			return errPolicyWithoutAuth
+		}
+		if _, err := authz.LoadPolicy(cfg.AuthzPolicyFile, clock.Real); err != nil {
+			return fmt.Errorf("authorization policy: %w", err)
+		}
+	}
+
+	if cfg.IPAccessFile != "" {
+		if _, err := ipaccess.Load(cfg.IPAccessFile); err != nil {
+			return fmt.Errorf("IP access list: %w", err)
+		}
+	}
+
+	if cfg.PipelineFile != "" {
+		pipeline, err := processor.LoadChain(cfg.PipelineFile)
+		if err != nil {
+			return fmt.Errorf("processing pipeline: %w", err)
+		}
+		pipeline.Close()
+	}
+
+	if cfg.RoutesFile != "" {
+		if _, err := loadRoutes(cfg); err != nil {
+			return err
+		}
+	}
+
+	if cfg.AuditLogFile != "" && cfg.AuditSigningKeyFile != "" {
+		if _, err := audit.LoadSigningKey(cfg.AuditSigningKeyFile); err != nil {
+			return fmt.Errorf("audit signing key: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,463 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sink/listener"
+	"github.com/sink/pkg/cbor"
+	"github.com/sink/pkg/coap"
+	pb "github.com/sink/proto"
+	"github.com/sink/state"
+)
+
+const (
+	// coapPath is the resource readings are POSTed to.
+	coapPath = "readings"
+
+	// coapFormatProtobuf is the Content-Format of a serialized SensorData, from the
+	// range RFC 7252 reserves for experimental use.
+	coapFormatProtobuf = 65000
+
+	// coapExchangeLifetime is how long a message ID is remembered to detect
+	// retransmissions (EXCHANGE_LIFETIME in RFC 7252).
+	coapExchangeLifetime = 247 * time.Second
+
+	// coapMaxDatagram is the largest UDP payload.
+	coapMaxDatagram = 65507
+
+	// coapMaxInFlight bounds the requests handled at once; datagrams beyond it are
+	// dropped and confirmable ones retransmitted by the device.
+	coapMaxInFlight = 256
+)
+
+// coapServer accepts readings over CoAP. Confirmable requests are acknowledged with
+// the result piggybacked on the ACK; non-confirmable requests get no response.
+// Retransmissions are answered from the exchange table instead of being stored
+// again.
+type coapServer struct {
+	sink *SinkServer
+
+	mu        sync.Mutex
+	conn      net.PacketConn // nil until bound
+	exchanges *state.Table[coapExchangeKey, *coapExchange]
+	stopped   bool
+
+	inFlight chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type coapExchangeKey struct {
+	addr      string
+	messageID uint16
+}
+
+type coapExchange struct {
+	response []byte // nil while the request is handled, empty for NON requests
+}
+
+// startCoAP binds CoAPAddr in the background, retrying while it is in use, and
+// serves requests until Stop.
+func (s *SinkServer) startCoAP() *coapServer {
+	c := &coapServer{
+		sink:      s,
+		exchanges: state.NewTable[coapExchangeKey, *coapExchange]("coap_exchanges", state.Limits{TTL: coapExchangeLifetime, MaxEntries: s.config.MaxTrackedSensors}),
+		inFlight:  make(chan struct{}, coapMaxInFlight),
+		stop:      make(chan struct{}),
+	}
+	c.wg.Add(1)
+	go c.serve()
+	return c
+}
+
+func (c *coapServer) serve() {
+	defer c.wg.Done()
+
+	addr := c.sink.config.CoAPAddr
+	for {
+		conn, err := net.ListenPacket(listener.Network("udp", c.sink.config.IPv6Only), addr)
+		if err == nil {
+			c.read(conn)
+			return
+		}
+		log.Printf("CoAP listener: %v, retrying in %v", err, frameRetryInterval)
+
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(frameRetryInterval):
+		}
+	}
+}
+
+func (c *coapServer) read(conn net.PacketConn) {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		conn.Close()
+		return
+	}
+	c.conn = conn
+	c.mu.Unlock()
+	log.Printf("CoAP listener on %s", conn.LocalAddr())
+
+	buf := make([]byte, coapMaxDatagram)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("CoAP listener: %v", err)
+			}
+			return
+		}
+
+		select {
+		case c.inFlight <- struct{}{}:
+		default:
+			continue
+		}
+		datagram := append([]byte(nil), buf[:n]...)
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			defer func() { <-c.inFlight }()
+			c.handle(conn, addr, datagram)
+		}()
+	}
+}
+
+// Stop closes the socket and waits for the requests being handled.
+func (c *coapServer) Stop() {
+	c.mu.Lock()
+	c.stopped = true
+	close(c.stop)
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.mu.Unlock()
+
+	c.wg.Wait()
+}
+
+func (c *coapServer) handle(conn net.PacketConn, addr net.Addr, datagram []byte) {
+	req, err := coap.Parse(datagram)
+	if err != nil {
+		// Malformed confirmable messages are rejected with a reset, others ignored.
+		if len(datagram) >= 4 && coap.Type(datagram[0]>>4&0x3) == coap.Confirmable {
+			c.send(conn, addr, (&coap.Message{Type: coap.Reset, MessageID: uint16(datagram[2])<<8 | uint16(datagram[3])}).Append(nil))
+		}
+		return
+	}
+
+	switch {
+	case req.Type == coap.Acknowledgement || req.Type == coap.Reset:
+		return
+	case req.Code == coap.Empty:
+		// An empty confirmable message is a ping, answered with a reset.
+		if req.Type == coap.Confirmable {
+			c.send(conn, addr, (&coap.Message{Type: coap.Reset, MessageID: req.MessageID}).Append(nil))
+		}
+		return
+	}
+
+	key := coapExchangeKey{addr: addr.String(), messageID: req.MessageID}
+	c.mu.Lock()
+	exchange, seen := c.exchanges.Get(key, c.sink.now())
+	if !seen {
+		exchange = c.exchanges.GetOrCreate(key, c.sink.now(), func() *coapExchange { return &coapExchange{} })
+	}
+	cached := exchange.response
+	c.mu.Unlock()
+	if seen {
+		// A retransmission: repeat the response, if there is one yet.
+		if len(cached) > 0 {
+			c.send(conn, addr, cached)
+		}
+		return
+	}
+
+	code, payload := c.process(addr, req)
+
+	var response []byte
+	if req.Type == coap.Confirmable {
+		resp := &coap.Message{
+			Type:      coap.Acknowledgement,
+			Code:      code,
+			MessageID: req.MessageID,
+			Token:     req.Token,
+			Payload:   payload,
+		}
+		if len(payload) > 0 {
+			resp.Options = []coap.Option{coap.UintOption(coap.OptionContentFormat, coap.FormatText)}
+		}
+		response = resp.Append(nil)
+	} else {
+		response = []byte{}
+	}
+
+	c.mu.Lock()
+	exchange.response = response
+	c.mu.Unlock()
+
+	if len(response) > 0 {
+		c.send(conn, addr, response)
+	}
+}
+
+func (c *coapServer) send(conn net.PacketConn, addr net.Addr, datagram []byte) {
+	if _, err := conn.WriteTo(datagram, addr); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("CoAP response to %s: %v", addr, err)
+	}
+}
+
+// process stores the reading in a request and returns the response code, with a
+// diagnostic payload for errors. Like a gRPC call, it is refused while the client is
+// denied or banned, before the reading is decoded, and counts towards a ban if it
+// fails authentication.
+func (c *coapServer) process(addr net.Addr, req *coap.Message) (code coap.Code, payload []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			code, payload = coapError(recovered("coap", r))
+		}
+	}()
+
+	ip, ok := listener.ClientIP(addr)
+	if !ok {
+		code, payload, _ = c.store(addr, req)
+		return code, payload
+	}
+	err := c.sink.guard(ip, func() (err error) {
+		code, payload, err = c.store(addr, req)
+		return err
+	})
+	if code == coap.Empty {
+		// Refused before the reading was looked at.
+		return coapError(err)
+	}
+	return code, payload
+}
+
+// store decodes and stores the reading in a request. The error is that of storing
+// it, the response code and payload already describe it.
+func (c *coapServer) store(addr net.Addr, req *coap.Message) (coap.Code, []byte, error) {
+	if req.Path() != coapPath {
+		return coap.NotFound, []byte("readings are posted to /" + coapPath), nil
+	}
+	if req.Code != coap.POST {
+		return coap.MethodNotAllowed, nil, nil
+	}
+	for _, o := range req.Options {
+		switch o.Number {
+		case coap.OptionURIHost, coap.OptionURIPort, coap.OptionURIPath:
+		default:
+			if o.Critical() {
+				return coap.BadOption, []byte(fmt.Sprintf("unsupported option %d", o.Number)), nil
+			}
+		}
+	}
+	if limit := c.sink.config.MaxRecvMsgSize; limit > 0 && len(req.Payload) > limit {
+		return coap.RequestEntityTooLarge, nil, nil
+	}
+
+	var (
+		reading *pb.SensorData
+		err     error
+	)
+	format, ok := req.Uint(coap.OptionContentFormat)
+	switch {
+	case !ok || format == coap.FormatCBOR:
+		reading, err = sensorDataFromCBOR(req.Payload)
+	case format == coapFormatProtobuf:
+		reading = &pb.SensorData{}
+		if err = proto.Unmarshal(req.Payload, reading); err != nil {
+			err = fmt.Errorf("malformed SensorData: %v", err)
+		}
+	default:
+		return coap.UnsupportedContentFormat, []byte(fmt.Sprintf("content format %d, want %d (CBOR) or %d (protobuf)", format, coap.FormatCBOR, coapFormatProtobuf)), nil
+	}
+	if err != nil {
+		return coap.BadRequest, []byte(err.Error()), nil
+	}
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+	if _, err := c.sink.SendSensorData(ctx, reading); err != nil {
+		code, payload := coapError(err)
+		return code, payload, err
+	}
+	return coap.Changed, nil, nil
+}
+
+// coapError maps a gRPC status to a CoAP response code and diagnostic payload.
+func coapError(err error) (coap.Code, []byte) {
+	st := status.Convert(err)
+
+	code := coap.InternalServerError
+	switch st.Code() {
+	case codes.InvalidArgument:
+		code = coap.BadRequest
+	case codes.Unauthenticated:
+		code = coap.Unauthorized
+	case codes.PermissionDenied:
+		code = coap.Forbidden
+	case codes.ResourceExhausted:
+		code = coap.TooManyRequests
+	case codes.Unavailable, codes.Canceled, codes.DeadlineExceeded:
+		code = coap.ServiceUnavailable
+	}
+	return code, []byte(st.Message())
+}
+
+// sensorDataFromCBOR decodes a reading sent as a CBOR map with the SensorData field
+// names: sensor_name, sensor_value (integer), timestamp (epoch seconds, optionally
+// tagged 1, or an RFC 3339 string, optionally tagged 0), and the optional tags,
+// values, priority ("critical"), location, attachment and unit.
+func sensorDataFromCBOR(payload []byte) (*pb.SensorData, error) {
+	v, err := cbor.Unmarshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("reading must be a CBOR map")
+	}
+
+	req := &pb.SensorData{}
+	for name, value := range fields {
+		switch name {
+		case "sensor_name":
+			req.SensorName, ok = value.(string)
+		case "sensor_value":
+			var n float64
+			if n, ok = cborNumber(value); ok && n == math.Trunc(n) && n >= math.MinInt32 && n <= math.MaxInt32 {
+				req.SensorValue = int32(n)
+			} else {
+				ok = false
+			}
+		case "timestamp":
+			req.Timestamp, ok = cborTimestamp(value)
+		case "tags":
+			req.Tags, ok = cborStrings(value)
+		case "values":
+			req.Values, ok = cborNumbers(value)
+		case "priority":
+			var priority string
+			if priority, ok = value.(string); ok && priority == "critical" {
+				req.Priority = pb.Priority_PRIORITY_CRITICAL
+			}
+		case "location":
+			req.Location, ok = cborLocation(value)
+		case "attachment":
+			req.Attachment, ok = value.([]byte)
+		case "unit":
+			req.Unit, ok = value.(string)
+		default:
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		if !ok {
+			return nil, fmt.Errorf("field %q has an invalid value", name)
+		}
+	}
+	return req, nil
+}
+
+func cborNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func cborTimestamp(v any) (*timestamppb.Timestamp, bool) {
+	if tag, ok := v.(cbor.Tag); ok {
+		if tag.Number != 0 && tag.Number != 1 {
+			return nil, false
+		}
+		v = tag.Content
+	}
+
+	if s, ok := v.(string); ok {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, false
+		}
+		return timestamppb.New(t), true
+	}
+	seconds, ok := cborNumber(v)
+	if !ok || math.IsNaN(seconds) || math.IsInf(seconds, 0) || math.Abs(seconds) > 1<<62/1e9 {
+		return nil, false
+	}
+	sec, frac := math.Modf(seconds)
+	return timestamppb.New(time.Unix(int64(sec), int64(frac*1e9))), true
+}
+
+func cborStrings(v any) (map[string]string, bool) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	tags := make(map[string]string, len(m))
+	for k, v := range m {
+		if tags[k], ok = v.(string); !ok {
+			return nil, false
+		}
+	}
+	return tags, true
+}
+
+func cborNumbers(v any) (map[string]float64, bool) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	numbers := make(map[string]float64, len(m))
+	for k, v := range m {
+		if numbers[k], ok = cborNumber(v); !ok {
+			return nil, false
+		}
+	}
+	return numbers, true
+}
+
+func cborLocation(v any) (*pb.Location, bool) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+
+	location := &pb.Location{}
+	for k, v := range m {
+		n, ok := cborNumber(v)
+		if !ok {
+			return nil, false
+		}
+		switch k {
+		case "latitude":
+			location.Latitude = n
+		case "longitude":
+			location.Longitude = n
+		case "altitude":
+			location.Altitude = &n
+		default:
+			return nil, false
+		}
+	}
+	return location, true
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"time"
+
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/mem"
+
+	pb "github.com/sink/proto"
+)
+
+// timingCodec is gRPC's proto codec, timing how long readings take to decode. gRPC
+// decodes requests before calling the handler, so this time is invisible to it.
+type timingCodec struct {
+	encoding.CodecV2
+}
+
+func newTimingCodec() timingCodec {
+	return timingCodec{CodecV2: encoding.GetCodecV2(grpcproto.Name)}
+}
+
+func (c timingCodec) Unmarshal(data mem.BufferSlice, v any) error {
+	if _, ok := v.(*pb.SensorData); !ok {
+		return c.CodecV2.Unmarshal(data, v)
+	}
+
+	start := time.Now()
+	err := c.CodecV2.Unmarshal(data, v)
+	observeStage("unmarshal", start)
+	return err
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"log"
+
+	"github.com/sink/compact"
+	"github.com/sink/metrics"
+)
+
+// runCompaction compacts the log's rotated segments every CompactInterval until Stop
+// is called. A failed compaction leaves the segments as they were and is retried.
+func (s *SinkServer) runCompaction() {
+	compactor := compact.New(compact.Options{
+		Path:        s.config.LogFilePath,
+		Retention:   s.config.LogRetention,
+		SegmentSize: s.config.LogSegmentSize,
+		Encryptor:   s.encryptor,
+		Fields:      s.entryFormat.fields,
+	})
+
+	ticker := s.config.Clock.NewTicker(s.config.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			s.compact(compactor)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *SinkServer) compact(compactor *compact.Compactor) {
+	stats, err := compactor.Run(s.now())
+	if err != nil {
+		metrics.CompactionFailures.Inc()
+		log.Printf("Log compaction failed: %v", err)
+		return
+	}
+	metrics.CompactionReclaimedBytes.Add(float64(max(stats.Reclaimed, 0)))
+	metrics.CompactionExpiredEntries.Add(float64(stats.Expired))
+	if stats.Written > 0 || stats.Removed > 0 {
+		log.Printf("Log compacted: %d segments written in place of %d, %d entries expired, %d bytes reclaimed",
+			stats.Written, stats.Removed, stats.Expired, stats.Reclaimed)
+	}
+}
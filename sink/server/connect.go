@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sink/listener"
+	pb "github.com/sink/proto"
+	"github.com/sink/proto/protoconnect"
+)
+
+// connectRetryInterval is how often binding the Connect address is retried.
+const connectRetryInterval = time.Second
+
+// serveConnect runs the Connect listener on ConnectAddr until the sink stops. It
+// serves the gRPC listener's credentials, and connections are guarded and limited
+// per IP like the gRPC listener's.
+func (s *SinkServer) serveConnect(tlsConfig *tls.Config) {
+	defer s.wg.Done()
+
+	srv := s.newConnectServer(tlsConfig)
+	go func() {
+		<-s.done
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	for {
+		err := s.listenConnect(srv)
+		if errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		log.Printf("Connect listener: %v, retrying in %v", err, connectRetryInterval)
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(connectRetryInterval):
+		}
+	}
+}
+
+// newConnectServer returns the HTTP server of the Connect listener, serving HTTP/2
+// over TLS with tlsConfig, or in the clear without it.
+func (s *SinkServer) newConnectServer(tlsConfig *tls.Config) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(s.connectHandler(s.config.ConnectOrigins))
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		Protocols:         new(http.Protocols),
+	}
+	srv.Protocols.SetHTTP1(true)
+	if tlsConfig != nil {
+		srv.TLSConfig = tlsConfig.Clone()
+		srv.Protocols.SetHTTP2(true)
+	} else {
+		// gRPC clients need HTTP/2, which is served without TLS then.
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return srv
+}
+
+// listenConnect binds ConnectAddr and serves srv on it.
+func (s *SinkServer) listenConnect(srv *http.Server) error {
+	lis, err := net.Listen(listener.Network("tcp", s.config.IPv6Only), s.config.ConnectAddr)
+	if err != nil {
+		return err
+	}
+	if s.config.ProxyProtocol {
+		lis = listener.ProxyProtocol(lis, s.config.ProxyProtocolFrom)
+	}
+	lis = s.guardListener(lis)
+	if s.config.MaxConnsPerIP > 0 {
+		lis = listener.LimitPerIP(lis, s.config.MaxConnsPerIP)
+	}
+
+	log.Printf("Connect listener on %s (TLS: %v)", lis.Addr(), srv.TLSConfig != nil)
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(lis, "", "")
+	}
+	return srv.Serve(lis)
+}
+
+// connectHandler serves TelemetryService over the Connect, gRPC-Web and gRPC
+// protocols on an HTTP server, for clients that can't use the gRPC listener, such as
+// browsers and plain HTTP clients posting JSON. Calls are handled like gRPC calls:
+// credentials are checked against the client's TLS state and the tenant is taken
+// from the request headers. Client IPs are checked and authentication failures
+// counted towards bans as by guardUnary. origins lists the origins allowed by CORS, "*" allowing
+// any. It returns the path prefix to serve the handler on.
+func (s *SinkServer) connectHandler(origins []string) (string, http.Handler) {
+	path, handler := protoconnect.NewTelemetryServiceHandler(connectService{sink: s})
+	return path, &connectHTTP{sink: s, next: handler, origins: origins}
+}
+
+// connectHTTP answers CORS requests and gives calls the peer a gRPC call would have.
+type connectHTTP struct {
+	sink    *SinkServer
+	next    http.Handler
+	origins []string
+}
+
+func (c *connectHTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if !slices.Contains(c.origins, "*") && !slices.Contains(c.origins, origin) {
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Expose-Headers", "Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin")
+	}
+
+	if r.Method == http.MethodOptions {
+		h := w.Header()
+		h.Set("Access-Control-Allow-Methods", "GET, POST")
+		h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Connect-Protocol-Version, Connect-Timeout-Ms, X-Grpc-Web, X-User-Agent, Grpc-Timeout, "+tenantMetadataKey)
+		h.Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	addr, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "invalid remote address", http.StatusBadRequest)
+		return
+	}
+	p := &peer.Peer{Addr: net.TCPAddrFromAddrPort(addr)}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS, CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}
+	}
+	c.next.ServeHTTP(w, r.WithContext(peer.NewContext(r.Context(), p)))
+}
+
+// connectService adapts the sink's gRPC methods to the Connect handler.
+type connectService struct {
+	protoconnect.UnimplementedTelemetryServiceHandler
+	sink *SinkServer
+}
+
+func (c connectService) SendSensorData(ctx context.Context, req *connect.Request[pb.SensorData]) (*connect.Response[pb.SensorDataResponse], error) {
+	return unaryConnect(ctx, c.sink, req, c.sink.SendSensorData)
+}
+
+func (c connectService) SendSensorDataBatch(ctx context.Context, req *connect.Request[pb.SensorDataBatch]) (*connect.Response[pb.SensorDataBatchResponse], error) {
+	return unaryConnect(ctx, c.sink, req, c.sink.SendSensorDataBatch)
+}
+
+func (c connectService) RegisterSensor(ctx context.Context, req *connect.Request[pb.RegisterSensorRequest]) (*connect.Response[pb.RegisterSensorResponse], error) {
+	return unaryConnect(ctx, c.sink, req, c.sink.RegisterSensor)
+}
+
+func (c connectService) Heartbeat(ctx context.Context, req *connect.Request[pb.HeartbeatRequest]) (*connect.Response[pb.HeartbeatResponse], error) {
+	return unaryConnect(ctx, c.sink, req, c.sink.Heartbeat)
+}
+
+func (c connectService) ReportEvent(ctx context.Context, req *connect.Request[pb.Event]) (*connect.Response[pb.EventResponse], error) {
+	return unaryConnect(ctx, c.sink, req, c.sink.ReportEvent)
+}
+
+// unaryConnect calls a gRPC method with the request headers as incoming metadata,
+// guarded like guardUnary and recovering from panics like recoverUnary.
+func unaryConnect[Req, Resp any](ctx context.Context, s *SinkServer, req *connect.Request[Req], method func(context.Context, *Req) (*Resp, error)) (resp *connect.Response[Resp], err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = connectError(recovered(req.Spec().Procedure, r))
+		}
+	}()
+
+	md := make(metadata.MD, len(req.Header()))
+	for k, v := range req.Header() {
+		md[strings.ToLower(k)] = v
+	}
+
+	var msg *Resp
+	call := func() (err error) {
+		msg, err = method(metadata.NewIncomingContext(ctx, md), req.Msg)
+		return err
+	}
+	if ip, ok := peerIP(ctx); ok {
+		err = s.guard(ip, call)
+	} else {
+		err = call()
+	}
+	if err != nil {
+		return nil, connectError(err)
+	}
+	return connect.NewResponse(msg), nil
+}
+
+// connectError converts a gRPC status error, keeping its code, message and details.
+func connectError(err error) error {
+	st := status.Convert(err)
+	cerr := connect.NewError(connect.Code(st.Code()), errors.New(st.Message()))
+	for _, detail := range st.Details() {
+		msg, ok := detail.(proto.Message)
+		if !ok {
+			continue
+		}
+		if d, err := connect.NewErrorDetail(msg); err == nil {
+			cerr.AddDetail(d)
+		}
+	}
+	return cerr
+}
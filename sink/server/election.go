@@ -0,0 +1,68 @@
+package server
+
+import (
+	"log"
+
+	"github.com/sink/errdefs"
+	"github.com/sink/metrics"
+)
+
+// isLeader reports whether the sink accepts readings: it leads its active/standby
+// pair, or no election is configured.
+func (s *SinkServer) isLeader() bool {
+	return s.election == nil || s.leader.Load()
+}
+
+// notLeader is the error readings sent to the standby get, naming the leader and
+// redirecting the node to it once the leader is known.
+func (s *SinkServer) notLeader() error {
+	leader := "unknown"
+	if addr := s.leaderAddr.Load(); addr != nil && *addr != "" {
+		leader = *addr
+	}
+	return s.withRedirect(errdefs.Errorf(errdefs.ErrNotLeader, "standby sink, the leader is %s", leader))
+}
+
+// runElection tries to take the leader lock every LeaderPollInterval until it
+// succeeds or Stop is called. The leader keeps the lock until it is closed.
+func (s *SinkServer) runElection() {
+	defer s.wg.Done()
+
+	ticker := s.config.Clock.NewTicker(s.config.LeaderPollInterval)
+	defer ticker.Stop()
+
+	for !s.leader.Load() {
+		select {
+		case <-ticker.C():
+			s.campaign()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// campaign tries to take the leader lock once, and otherwise records the leader's
+// address for the clients it turns away.
+func (s *SinkServer) campaign() {
+	leader, err := s.election.TryAcquire()
+	if err != nil {
+		log.Printf("Leader election failed: %v", err)
+		return
+	}
+	if leader {
+		s.leaderAddr.Store(&s.config.AdvertiseAddr)
+		s.leader.Store(true)
+		metrics.Leader.Set(1)
+		log.Printf("Leader election: this sink leads, accepting readings at %s", s.config.AdvertiseAddr)
+		return
+	}
+
+	addr, err := s.election.Leader()
+	if err != nil {
+		log.Printf("Leader election failed: %v", err)
+		return
+	}
+	if prev := s.leaderAddr.Swap(&addr); prev == nil || *prev != addr {
+		log.Printf("Leader election: standby, the leader is %s", addr)
+	}
+}
@@ -0,0 +1,387 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sink/pkg/logformat"
+	"github.com/sink/processor"
+)
+
+// logEntry is the record persisted for every accepted reading, after it has passed
+// through the processing pipeline.
+type logEntry processor.Entry
+
+// entryBufferPool recycles scratch buffers used to encode and encrypt entries, so the
+// request path doesn't allocate a fresh buffer per message.
+var entryBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 512)
+		return &buf
+	},
+}
+
+func getEntryBuffer() *[]byte {
+	return entryBufferPool.Get().(*[]byte)
+}
+
+func putEntryBuffer(buf *[]byte) {
+	*buf = (*buf)[:0]
+	entryBufferPool.Put(buf)
+}
+
+// entryFormat is how log entries are written: the keys of their fields, quoted and
+// followed by a colon, and their times as fields formats them.
+type entryFormat struct {
+	fields *logformat.Fields
+
+	aggregate, attachment, attachmentID, dataTime, event, location, metric, priority string
+	rawValue, sealedPayload, sensorName, sensorValue, tags, timestamp, typ, values   string
+
+	schema string // key and value of the SchemaVersion stamp
+}
+
+// defaultEntryFormat writes entries with the default names and times.
+var defaultEntryFormat = newEntryFormat(nil)
+
+func newEntryFormat(fields *logformat.Fields) *entryFormat {
+	key := func(field string) string {
+		return `"` + fields.Name(field) + `":`
+	}
+	return &entryFormat{
+		fields:        fields,
+		aggregate:     key("aggregate"),
+		attachment:    key("attachment"),
+		attachmentID:  key("attachment_id"),
+		dataTime:      key("data_time"),
+		event:         key("event"),
+		location:      key("location"),
+		metric:        key("metric"),
+		priority:      key("priority"),
+		rawValue:      key("raw_value"),
+		schema:        key("schema") + strconv.Itoa(logformat.SchemaVersion),
+		sealedPayload: key("sealed_payload"),
+		sensorName:    key("sensor_name"),
+		sensorValue:   key("sensor_value"),
+		tags:          key("tags"),
+		timestamp:     key("timestamp"),
+		typ:           key("type"),
+		values:        key("values"),
+	}
+}
+
+// appendJSON appends the entry as a JSON object. In the default format the output is
+// byte-for-byte what encoding/json produced for the map the sink used to marshal
+// (sorted keys, HTML-safe string escaping, RFC 3339 timestamps) without the
+// reflection and allocations. Renamed fields keep their place.
+func (e *logEntry) appendJSON(dst []byte, f *entryFormat) ([]byte, error) {
+	var err error
+
+	dst = append(dst, '{')
+	if e.Aggregate != nil {
+		dst = append(dst, f.aggregate...)
+		if dst, err = appendJSONAggregate(dst, e.Aggregate); err != nil {
+			return nil, fmt.Errorf("aggregate: %w", err)
+		}
+		dst = append(dst, ',')
+	}
+	if e.AttachmentID != "" {
+		dst = append(dst, f.attachmentID...)
+		dst = appendJSONString(dst, e.AttachmentID)
+		dst = append(dst, ',')
+	} else if e.Attachment != nil {
+		dst = append(dst, f.attachment...)
+		dst = append(dst, '"')
+		dst = base64.StdEncoding.AppendEncode(dst, e.Attachment)
+		dst = append(dst, `",`...)
+	}
+	dst = append(dst, f.dataTime...)
+	if dst, err = appendJSONTime(dst, e.DataTime, f.fields); err != nil {
+		return nil, fmt.Errorf("data_time: %w", err)
+	}
+	if e.Event != nil {
+		dst = append(dst, ',')
+		dst = append(dst, f.event...)
+		dst = append(dst, `{"message":`...)
+		dst = appendJSONString(dst, e.Event.Message)
+		dst = append(dst, `,"severity":`...)
+		dst = appendJSONString(dst, e.Event.Severity)
+		dst = append(dst, '}')
+	}
+	if e.Location != nil {
+		dst = append(dst, ',')
+		dst = append(dst, f.location...)
+		if dst, err = appendJSONLocation(dst, e.Location); err != nil {
+			return nil, fmt.Errorf("location: %w", err)
+		}
+	}
+	if e.Metric != "" {
+		dst = append(dst, ',')
+		dst = append(dst, f.metric...)
+		dst = appendJSONString(dst, e.Metric)
+	}
+	if e.Critical {
+		dst = append(dst, ',')
+		dst = append(dst, f.priority...)
+		dst = append(dst, `"critical"`...)
+	}
+	if e.RawValue != nil {
+		dst = append(dst, ',')
+		dst = append(dst, f.rawValue...)
+		if dst, err = appendJSONFloat(dst, *e.RawValue); err != nil {
+			return nil, fmt.Errorf("raw_value: %w", err)
+		}
+	}
+	dst = append(dst, ',')
+	dst = append(dst, f.schema...)
+	if e.Sealed != nil {
+		// Sealed entries carry their value inside the ciphertext.
+		dst = append(dst, ',')
+		dst = append(dst, f.sealedPayload...)
+		dst = append(dst, '"')
+		dst = base64.StdEncoding.AppendEncode(dst, e.Sealed)
+		dst = append(dst, `",`...)
+		dst = append(dst, f.sensorName...)
+		dst = appendJSONString(dst, e.SensorName)
+	} else if e.Values != nil || e.Aggregate != nil || e.Event != nil {
+		// Combined multi-value entries and aggregates carry their values elsewhere,
+		// events have none.
+		dst = append(dst, ',')
+		dst = append(dst, f.sensorName...)
+		dst = appendJSONString(dst, e.SensorName)
+	} else {
+		dst = append(dst, ',')
+		dst = append(dst, f.sensorName...)
+		dst = appendJSONString(dst, e.SensorName)
+		dst = append(dst, ',')
+		dst = append(dst, f.sensorValue...)
+		if dst, err = appendJSONFloat(dst, e.SensorValue); err != nil {
+			return nil, fmt.Errorf("sensor_value: %w", err)
+		}
+	}
+	if len(e.Tags) > 0 {
+		dst = append(dst, ',')
+		dst = append(dst, f.tags...)
+		dst = appendJSONTags(dst, e.Tags)
+	}
+	dst = append(dst, ',')
+	dst = append(dst, f.timestamp...)
+	if dst, err = appendJSONTime(dst, e.Timestamp, f.fields); err != nil {
+		return nil, fmt.Errorf("timestamp: %w", err)
+	}
+	if e.Event != nil {
+		// Entries without a type are readings.
+		dst = append(dst, ',')
+		dst = append(dst, f.typ...)
+		dst = append(dst, `"event"`...)
+	}
+	if e.Values != nil {
+		dst = append(dst, ',')
+		dst = append(dst, f.values...)
+		if dst, err = appendJSONValues(dst, e.Values); err != nil {
+			return nil, fmt.Errorf("values: %w", err)
+		}
+	}
+	dst = append(dst, '}')
+
+	return dst, nil
+}
+
+// appendJSONTime appends t in the time format of fields.
+func appendJSONTime(dst []byte, t time.Time, fields *logformat.Fields) ([]byte, error) {
+	if y := t.Year(); y < 0 || y >= 10000 {
+		return nil, fmt.Errorf("year %d outside of range [0,9999]", y)
+	}
+	return fields.AppendTime(dst, t), nil
+}
+
+// appendJSONFloat formats f the way encoding/json does, so integral values are
+// written without a fractional part.
+func appendJSONFloat(dst []byte, f float64) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("unsupported value %v", f)
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, 64)
+	if format == 'e' {
+		// Shorten e-09 to e-9 like encoding/json.
+		if n := len(dst); n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst, nil
+}
+
+// appendJSONTags appends tags as a JSON object with sorted keys.
+func appendJSONTags(dst []byte, tags map[string]string) []byte {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	dst = append(dst, '{')
+	for i, k := range keys {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, k)
+		dst = append(dst, ':')
+		dst = appendJSONString(dst, tags[k])
+	}
+	dst = append(dst, '}')
+
+	return dst
+}
+
+// appendJSONValues appends values as a JSON object with sorted keys.
+func appendJSONValues(dst []byte, values map[string]float64) ([]byte, error) {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var err error
+	dst = append(dst, '{')
+	for i, k := range keys {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = appendJSONString(dst, k)
+		dst = append(dst, ':')
+		if dst, err = appendJSONFloat(dst, values[k]); err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	dst = append(dst, '}')
+
+	return dst, nil
+}
+
+// appendJSONLocation appends a location as a JSON object, without altitude when it is
+// unknown.
+func appendJSONLocation(dst []byte, l *processor.Location) ([]byte, error) {
+	var err error
+	dst = append(dst, '{')
+	if l.Altitude != nil {
+		dst = append(dst, `"altitude":`...)
+		if dst, err = appendJSONFloat(dst, *l.Altitude); err != nil {
+			return nil, fmt.Errorf("altitude: %w", err)
+		}
+		dst = append(dst, ',')
+	}
+	dst = append(dst, `"latitude":`...)
+	if dst, err = appendJSONFloat(dst, l.Latitude); err != nil {
+		return nil, fmt.Errorf("latitude: %w", err)
+	}
+	dst = append(dst, `,"longitude":`...)
+	if dst, err = appendJSONFloat(dst, l.Longitude); err != nil {
+		return nil, fmt.Errorf("longitude: %w", err)
+	}
+	dst = append(dst, '}')
+
+	return dst, nil
+}
+
+// appendJSONAggregate appends an aggregate as a JSON object. Histogram buckets are
+// an array of {"count","le"} objects in the order of their upper bounds, left out
+// for a count/sum summary.
+func appendJSONAggregate(dst []byte, a *processor.Aggregate) ([]byte, error) {
+	var err error
+	dst = append(dst, '{')
+	if len(a.Buckets) > 0 {
+		dst = append(dst, `"buckets":[`...)
+		for i, b := range a.Buckets {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(dst, `{"count":`...)
+			dst = strconv.AppendUint(dst, b.Count, 10)
+			dst = append(dst, `,"le":`...)
+			if dst, err = appendJSONFloat(dst, b.UpperBound); err != nil {
+				return nil, fmt.Errorf("bucket %d: %w", i, err)
+			}
+			dst = append(dst, '}')
+		}
+		dst = append(dst, "],"...)
+	}
+	dst = append(dst, `"count":`...)
+	dst = strconv.AppendUint(dst, a.Count, 10)
+	dst = append(dst, `,"sum":`...)
+	if dst, err = appendJSONFloat(dst, a.Sum); err != nil {
+		return nil, fmt.Errorf("sum: %w", err)
+	}
+	dst = append(dst, '}')
+
+	return dst, nil
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString appends s as a JSON string using the same escaping rules as
+// encoding/json with HTML escaping enabled.
+func appendJSONString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	dst = append(dst, '"')
+
+	return dst
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/status"
+
+	"github.com/sink/deadletter"
+	"github.com/sink/metrics"
+	"github.com/sink/processor"
+	pb "github.com/sink/proto"
+)
+
+// ReportEvent stores an event like a reading: it is admitted under the same rate
+// limits and quotas, passes through the pipeline with its attributes as tags, and is
+// written to the log with "type":"event".
+func (s *SinkServer) ReportEvent(ctx context.Context, req *pb.Event) (*pb.EventResponse, error) {
+	received := time.Now()
+	defer func() { metrics.ReceiveDuration.Observe(time.Since(received).Seconds()) }()
+
+	tenant := tenantFromContext(ctx)
+
+	id, rule, err := s.authenticate(ctx, tenant, req.SensorName)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &incoming{
+		msg:        req,
+		sensorName: req.SensorName,
+		tenant:     tenant,
+		identity:   id.String(),
+		rule:       rule,
+		critical:   req.Severity == pb.Severity_SEVERITY_CRITICAL,
+	}
+
+	if err := s.validateEvent(req); err != nil {
+		log.Printf("invalid event from %s: %v", in.identity, err)
+		s.recordRejection(in, deadletter.ReasonInvalid, status.Convert(err).Message())
+		return nil, err
+	}
+
+	if err := s.admit(ctx, in); err != nil {
+		return nil, err
+	}
+
+	severity := severityName(req.Severity)
+	entry := &processor.Entry{
+		Timestamp:  s.now(),
+		SensorName: req.SensorName,
+		DataTime:   req.Timestamp.AsTime().UTC(),
+		Critical:   in.critical,
+		Tags:       req.Attributes,
+		Tenant:     tenant,
+		Event:      &processor.Event{Severity: severity, Message: req.Message},
+	}
+
+	if s.config.MaxClockSkew > 0 {
+		s.checkClockSkew(entry)
+	}
+
+	stored, err := s.store(ctx, in, []*processor.Entry{entry})
+	if err != nil {
+		return nil, err
+	}
+
+	// An event shows the device is alive without being a reading.
+	s.sensors.Heartbeat(tenant, req.SensorName, entry.Timestamp, req.Timestamp.AsTime())
+	if stored {
+		metrics.EventsReceived.WithLabelValues(severity).Inc()
+		log.Printf("Received %s event from %s: %s", severity, req.SensorName, req.Message)
+	}
+
+	return &pb.EventResponse{Draining: s.draining.Load(), Redirect: s.redirectTo()}, nil
+}
+
+// severityName returns the name an event's severity is stored under, e.g. "error".
+// Events without a severity are stored as info.
+func severityName(severity pb.Severity) string {
+	if severity == pb.Severity_SEVERITY_UNSPECIFIED {
+		severity = pb.Severity_SEVERITY_INFO
+	}
+	return strings.ToLower(strings.TrimPrefix(severity.String(), "SEVERITY_"))
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/sink/config"
+	"github.com/sink/metrics"
+	"github.com/sink/processor"
+	"github.com/sink/storage"
+)
+
+// fanOut is an output fed a copy of every entry besides the primary one. It has its
+// own buffer and write queue, and its failures are its own: a buffer that finds the
+// queue full is dropped and counted instead of holding up ingestion, and write errors
+// don't affect readiness. Its fields are guarded by the server's bufferMutex.
+type fanOut struct {
+	name    string
+	influx  bool // buffers hold line protocol rather than JSON lines
+	writer  *storage.FileWriter
+	buffer  []byte
+	entries int // in buffer
+}
+
+func newFanOut(name string, writer *storage.FileWriter) *fanOut {
+	return &fanOut{
+		name:   name,
+		influx: name == config.OutputInflux,
+		writer: writer,
+		buffer: writer.NewBuffer(),
+	}
+}
+
+// append adds the encoded entries to the buffer, flushing it first when they don't
+// fit in bufferSize.
+func (f *fanOut) append(data []byte, entries, bufferSize int) {
+	if len(f.buffer)+len(data) > bufferSize {
+		f.flush()
+	}
+	f.buffer = append(f.buffer, data...)
+	f.entries += entries
+}
+
+// flush hands the buffer to the writer without waiting, dropping it when the queue
+// is full.
+func (f *fanOut) flush() {
+	if len(f.buffer) == 0 {
+		return
+	}
+
+	if err := f.writer.TryEnqueue(f.buffer); err != nil {
+		log.Printf("%s output: %v, dropping %d entries", f.name, err, f.entries)
+		metrics.FanOutEntriesDropped.WithLabelValues(f.name).Add(float64(f.entries))
+		f.buffer = f.buffer[:0]
+	} else {
+		f.buffer = f.writer.NewBuffer()
+	}
+	f.entries = 0
+}
+
+// fanOutCopy is what a fan-out output stores of a message's entries.
+type fanOutCopy struct {
+	data    []byte
+	entries int
+}
+
+// fanOutData encodes the entries each fan-out output's routes select, as JSON lines
+// or line protocol. Outputs taking every entry in the same format share an encoding,
+// which is logData when the primary output stored them all in the default format: it
+// is in the clear, as the log can't be encrypted with fan-out outputs.
+func (s *SinkServer) fanOutData(routes *processor.Routes, entries, stored []*processor.Entry, logData []byte) ([]fanOutCopy, error) {
+	all := make(map[bool][]byte, 2) // every entry, as line protocol when true
+	if len(stored) == len(entries) && s.entryFormat.fields == nil {
+		all[influxOutput(s.config)] = logData
+	}
+
+	copies := make([]fanOutCopy, len(s.fanOut))
+	for i, f := range s.fanOut {
+		selected := routes.Select(f.name, entries)
+		if len(selected) == 0 {
+			continue
+		}
+		copies[i].entries = len(selected)
+
+		every := len(selected) == len(entries)
+		if data, ok := all[f.influx]; ok && every {
+			copies[i].data = data
+			continue
+		}
+		var data []byte
+		for _, entry := range selected {
+			var err error
+			if data, err = s.appendEntry(data, entry, f.influx); err != nil {
+				return nil, err
+			}
+			data = append(data, '\n')
+		}
+		copies[i].data = data
+		if every {
+			all[f.influx] = data
+		}
+	}
+	return copies, nil
+}
+
+// loadRoutes reads RoutesFile, whose rules must be for configured outputs.
+func loadRoutes(cfg config.Config) (*processor.Routes, error) {
+	routes, err := processor.LoadRoutes(cfg.RoutesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load routes: %w", err)
+	}
+	for _, name := range routes.Outputs() {
+		if name != cfg.Output && !slices.Contains(cfg.FanOut, name) {
+			return nil, fmt.Errorf("failed to load routes: rules for %s, which isn't an output", name)
+		}
+	}
+	return routes, nil
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sink/listener"
+	"github.com/sink/pkg/frame"
+	pb "github.com/sink/proto"
+)
+
+const (
+	// frameRetryInterval is how often binding the frame address is retried. During an
+	// upgrade the parent holds the address until the new process is ready.
+	frameRetryInterval = time.Second
+
+	// frameIOTimeout bounds TLS handshakes and writing a response to a device that
+	// stopped reading.
+	frameIOTimeout = 10 * time.Second
+
+	// defaultMaxFrameSize matches gRPC's default receive limit.
+	defaultMaxFrameSize = 4 * 1024 * 1024
+)
+
+// frameServer accepts readings framed as described in pkg/frame and hands them to
+// SendSensorData, so they go through the same authentication, limits and pipeline
+// as gRPC readings.
+type frameServer struct {
+	sink      *SinkServer
+	tlsConfig *tls.Config // nil serves plain TCP
+
+	mu      sync.Mutex
+	lis     net.Listener // nil until bound
+	conns   map[net.Conn]struct{}
+	stopped bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startFrames binds FrameAddr in the background, retrying while it is in use, and
+// serves frame connections until Stop.
+func (s *SinkServer) startFrames(tlsConfig *tls.Config) *frameServer {
+	f := &frameServer{
+		sink:      s,
+		tlsConfig: tlsConfig,
+		conns:     make(map[net.Conn]struct{}),
+		stop:      make(chan struct{}),
+	}
+	f.wg.Add(1)
+	go f.serve()
+	return f
+}
+
+func (f *frameServer) serve() {
+	defer f.wg.Done()
+
+	addr := f.sink.config.FrameAddr
+	for {
+		lis, err := net.Listen(listener.Network("tcp", f.sink.config.IPv6Only), addr)
+		if err == nil {
+			f.accept(lis)
+			return
+		}
+		log.Printf("Frame listener: %v, retrying in %v", err, frameRetryInterval)
+
+		select {
+		case <-f.stop:
+			return
+		case <-time.After(frameRetryInterval):
+		}
+	}
+}
+
+func (f *frameServer) accept(lis net.Listener) {
+	if f.sink.config.ProxyProtocol {
+		lis = listener.ProxyProtocol(lis, f.sink.config.ProxyProtocolFrom)
+	}
+	lis = f.sink.guardListener(lis)
+	if f.sink.config.MaxConnsPerIP > 0 {
+		lis = listener.LimitPerIP(lis, f.sink.config.MaxConnsPerIP)
+	}
+
+	f.mu.Lock()
+	if f.stopped {
+		f.mu.Unlock()
+		lis.Close()
+		return
+	}
+	f.lis = lis
+	f.mu.Unlock()
+	log.Printf("Frame listener on %s (TLS: %v)", lis.Addr(), f.tlsConfig != nil)
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Frame listener: %v", err)
+			}
+			return
+		}
+
+		f.mu.Lock()
+		if f.stopped {
+			f.mu.Unlock()
+			conn.Close()
+			return
+		}
+		f.conns[conn] = struct{}{}
+		f.wg.Add(1)
+		f.mu.Unlock()
+
+		go f.serveConn(conn)
+	}
+}
+
+// Stop closes the listener and lets every connection finish the request it is
+// handling before closing it.
+func (f *frameServer) Stop() {
+	f.mu.Lock()
+	f.stopped = true
+	close(f.stop)
+	if f.lis != nil {
+		f.lis.Close()
+	}
+	for conn := range f.conns {
+		// Wakes connections waiting for their next frame; the rest notice after
+		// responding.
+		conn.SetReadDeadline(time.Now())
+	}
+	f.mu.Unlock()
+
+	f.wg.Wait()
+}
+
+func (f *frameServer) serveConn(conn net.Conn) {
+	defer f.wg.Done()
+	defer func() {
+		f.mu.Lock()
+		delete(f.conns, conn)
+		f.mu.Unlock()
+		conn.Close()
+	}()
+
+	p := &peer.Peer{Addr: conn.RemoteAddr(), LocalAddr: conn.LocalAddr()}
+	if f.tlsConfig != nil {
+		tlsConn := tls.Server(conn, f.tlsConfig)
+		conn = tlsConn
+
+		ctx, cancel := context.WithTimeout(context.Background(), frameIOTimeout)
+		err := tlsConn.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Frame connection from %s: TLS handshake: %v", p.Addr, err)
+			return
+		}
+		// Client certificates are checked and authorized like those of gRPC clients.
+		p.AuthInfo = credentials.TLSInfo{State: tlsConn.ConnectionState()}
+	}
+	ctx := peer.NewContext(context.Background(), p)
+
+	maxSize := f.sink.config.MaxRecvMsgSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxFrameSize
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		if !f.setReadDeadline(conn) {
+			return
+		}
+
+		code, payload, err := frame.Read(r, maxSize)
+		if err != nil {
+			switch {
+			case err == io.EOF, errors.Is(err, os.ErrDeadlineExceeded):
+			case errors.Is(err, frame.ErrTooLarge):
+				// The rest of the frame is unread, so the connection can't continue.
+				f.respond(conn, nil, status.Error(codes.ResourceExhausted, err.Error()))
+			default:
+				log.Printf("Frame connection from %s: %v", p.Addr, err)
+			}
+			return
+		}
+
+		var resp *pb.SensorDataResponse
+		if code != frame.TypeSensorData {
+			err = status.Errorf(codes.Unimplemented, "unknown frame type %d", code)
+		} else {
+			resp, err = f.handle(ctx, payload)
+		}
+		if !f.respond(conn, resp, err) {
+			return
+		}
+	}
+}
+
+// setReadDeadline arms the idle timeout for the next frame. It returns false once
+// the server is stopping.
+func (f *frameServer) setReadDeadline(conn net.Conn) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.stopped {
+		return false
+	}
+	var deadline time.Time
+	if timeout := f.sink.config.FrameIdleTimeout; timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	conn.SetReadDeadline(deadline)
+	return true
+}
+
+// handle decodes a reading and stores it like a SendSensorData call. Like a gRPC
+// call, it is refused once the client is denied or banned, and counts towards a ban
+// if it fails authentication.
+func (f *frameServer) handle(ctx context.Context, payload []byte) (resp *pb.SensorDataResponse, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered("frame", r)
+		}
+	}()
+
+	call := func() (err error) {
+		req := &pb.SensorData{}
+		if err := proto.Unmarshal(payload, req); err != nil {
+			return status.Errorf(codes.InvalidArgument, "malformed SensorData: %v", err)
+		}
+		resp, err = f.sink.SendSensorData(ctx, req)
+		return err
+	}
+	if ip, ok := peerIP(ctx); ok {
+		return resp, f.sink.guard(ip, call)
+	}
+	return resp, call()
+}
+
+// respond writes the response to a request: the SensorDataResponse on success and
+// the status message otherwise. It returns false when the connection is broken.
+func (f *frameServer) respond(conn net.Conn, resp *pb.SensorDataResponse, err error) bool {
+	code := codes.OK
+	var payload []byte
+	if err != nil {
+		st := status.Convert(err)
+		code, payload = st.Code(), []byte(st.Message())
+	} else if payload, err = proto.Marshal(resp); err != nil {
+		code, payload = codes.Internal, []byte(err.Error())
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(frameIOTimeout))
+	if err := frame.Write(conn, uint8(code), payload); err != nil {
+		log.Printf("Frame connection from %s: write response: %v", conn.RemoteAddr(), err)
+		return false
+	}
+	return true
+}
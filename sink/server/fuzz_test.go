@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sink/config"
+	"github.com/sink/pkg/logformat"
+	pb "github.com/sink/proto"
+)
+
+func FuzzSendSensorData(f *testing.F) {
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:    filepath.Join(f.TempDir(), "telemetry.log"),
+		BufferSize:     64 * 1024,
+		WriteQueueSize: 16,
+		FlushInterval:  time.Minute,
+		RateLimit:      1 << 40,
+		MaxTags:        16,
+		MaxFieldSize:   256,
+	})
+	if err != nil {
+		f.Fatalf("NewSinkServer() error = %v", err)
+	}
+	f.Cleanup(s.Close)
+
+	seeds := []*pb.SensorData{
+		{SensorName: "temp", SensorValue: 21, Timestamp: timestamppb.Now()},
+		{SensorName: "temp", Timestamp: &timestamppb.Timestamp{Seconds: 1 << 62}},
+		{SensorName: "temp", Timestamp: &timestamppb.Timestamp{Seconds: 1, Nanos: -1}},
+		{SensorName: "temp", Timestamp: timestamppb.Now(), Tags: map[string]string{"zone": "a", "": "\x00"}},
+		{SensorName: "temp", Timestamp: timestamppb.Now(), SealedPayload: []byte{1, 2, 3}},
+	}
+	for _, seed := range seeds {
+		data, err := proto.Marshal(seed)
+		if err != nil {
+			f.Fatalf("proto.Marshal() error = %v", err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0x0f})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		req := &pb.SensorData{}
+		if err := proto.Unmarshal(data, req); err != nil {
+			return
+		}
+
+		_, err := s.SendSensorData(context.Background(), req)
+		if err == nil {
+			return
+		}
+		st, ok := status.FromError(err)
+		if !ok {
+			t.Fatalf("SendSensorData() error = %v, want a gRPC status", err)
+		}
+		switch st.Code() {
+		case codes.InvalidArgument, codes.ResourceExhausted, codes.FailedPrecondition:
+		default:
+			t.Errorf("SendSensorData() code = %v for %v", st.Code(), req)
+		}
+	})
+}
+
+func FuzzLogEntry_AppendJSON(f *testing.F) {
+	f.Add("temperature-01", "zone", "a<b>&c", 42.5, int64(1715941800))
+	f.Add("\xff \x01", "\"", "\\", -1e21, int64(-62135596800))
+	f.Add("", "", "", 1.5e-9, int64(253402300799))
+
+	f.Fuzz(func(t *testing.T, name, key, value string, v float64, sec int64) {
+		ts := time.Unix(sec, 0).UTC()
+		entry := logEntry{Timestamp: ts, SensorName: name, SensorValue: v, DataTime: ts, Tags: map[string]string{key: value}}
+
+		got, err := entry.appendJSON(nil, defaultEntryFormat)
+		want, wantErr := json.Marshal(map[string]interface{}{
+			"timestamp":    ts,
+			"sensor_name":  name,
+			"sensor_value": v,
+			"data_time":    ts,
+			"schema":       logformat.SchemaVersion,
+			"tags":         map[string]string{key: value},
+		})
+		if (err != nil) != (wantErr != nil) {
+			t.Fatalf("appendJSON() error = %v, encoding/json error = %v", err, wantErr)
+		}
+		if err == nil && string(got) != string(want) {
+			t.Errorf("appendJSON() = %s, want %s", got, want)
+		}
+	})
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/sink/metrics"
+	pb "github.com/sink/proto"
+)
+
+func (s *SinkServer) RegisterSensor(ctx context.Context, req *pb.RegisterSensorRequest) (*pb.RegisterSensorResponse, error) {
+	tenant := tenantFromContext(ctx)
+	if _, _, err := s.authenticate(ctx, tenant, req.SensorName); err != nil {
+		return nil, err
+	}
+	if err := s.validateRegistration(req); err != nil {
+		return nil, err
+	}
+
+	s.sensors.Register(tenant, req.SensorName, req.Metadata, s.now())
+	log.Printf("Sensor registered: %s (tenant %s)", req.SensorName, tenant)
+
+	return &pb.RegisterSensorResponse{
+		HeartbeatInterval: durationpb.New(s.config.HeartbeatInterval),
+	}, nil
+}
+
+func (s *SinkServer) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	tenant := tenantFromContext(ctx)
+	if _, _, err := s.authenticate(ctx, tenant, req.SensorName); err != nil {
+		return nil, err
+	}
+	if err := s.validateHeartbeat(req); err != nil {
+		return nil, err
+	}
+
+	var deviceTime time.Time
+	if req.Timestamp != nil {
+		deviceTime = req.Timestamp.AsTime()
+	}
+	s.sensors.Heartbeat(tenant, req.SensorName, s.now(), deviceTime)
+	metrics.Heartbeats.Inc()
+
+	return &pb.HeartbeatResponse{Draining: s.draining.Load(), Redirect: s.redirectTo()}, nil
+}
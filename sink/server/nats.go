@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/sink/output"
+	pb "github.com/sink/proto"
+	"github.com/sink/state"
+)
+
+const (
+	// natsDuplicateWindow is how long message IDs are remembered, JetStream's
+	// default duplicate window.
+	natsDuplicateWindow = 2 * time.Minute
+
+	// natsRetryDelay is when a reading the sink couldn't take is redelivered.
+	natsRetryDelay = 5 * time.Second
+)
+
+// natsIngest consumes readings from a JetStream subject through a durable consumer
+// and hands them to SendSensorData, so they go through the same validation, limits
+// and pipeline as gRPC readings. A message is acknowledged once its reading is
+// stored or rejected for good, and redelivered otherwise.
+type natsIngest struct {
+	sink *SinkServer
+
+	mu      sync.Mutex
+	nc      *nats.Conn // nil until connected
+	consume jetstream.ConsumeContext
+	stopped bool
+
+	seen *state.Table[string, struct{}] // IDs of messages stored recently
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startNATS connects to NATSURL in the background, retrying until it succeeds, and
+// consumes NATSIngestSubject until Stop.
+func (s *SinkServer) startNATS() *natsIngest {
+	n := &natsIngest{
+		sink: s,
+		seen: state.NewTable[string, struct{}]("nats_message_ids", state.Limits{TTL: natsDuplicateWindow, MaxEntries: s.config.MaxTrackedSensors}),
+		stop: make(chan struct{}),
+	}
+	n.wg.Add(1)
+	go n.serve()
+	return n
+}
+
+func (n *natsIngest) serve() {
+	defer n.wg.Done()
+
+	for {
+		err := n.subscribe()
+		if err == nil {
+			return
+		}
+		log.Printf("NATS ingestion: %v, retrying in %v", err, frameRetryInterval)
+
+		select {
+		case <-n.stop:
+			return
+		case <-time.After(frameRetryInterval):
+		}
+	}
+}
+
+// subscribe creates or updates the durable consumer on the stream holding the
+// subject and starts consuming it.
+func (n *natsIngest) subscribe() error {
+	cfg := n.sink.config
+	nc, err := output.DialNATS(cfg.NATSURL, cfg.NATSCredsFile, "sink ingestion")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.NATSTimeout)
+	defer cancel()
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return err
+	}
+	stream, err := js.StreamNameBySubject(ctx, cfg.NATSIngestSubject)
+	if err != nil {
+		nc.Close()
+		return fmt.Errorf("find stream of %s: %w", cfg.NATSIngestSubject, err)
+	}
+	consumer, err := js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+		Durable:       cfg.NATSIngestDurable,
+		FilterSubject: cfg.NATSIngestSubject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		nc.Close()
+		return fmt.Errorf("create consumer %s on stream %s: %w", cfg.NATSIngestDurable, stream, err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped {
+		nc.Close()
+		return nil
+	}
+	n.consume, err = consumer.Consume(n.handle)
+	if err != nil {
+		nc.Close()
+		return err
+	}
+	n.nc = nc
+	log.Printf("NATS ingestion from %s on stream %s as %s", cfg.NATSIngestSubject, stream, cfg.NATSIngestDurable)
+	return nil
+}
+
+// Stop stops consuming, letting the message being handled finish, and closes the
+// connection.
+func (n *natsIngest) Stop() {
+	n.mu.Lock()
+	n.stopped = true
+	close(n.stop)
+	if n.consume != nil {
+		n.consume.Drain()
+		<-n.consume.Closed()
+	}
+	if n.nc != nil {
+		n.nc.Close()
+	}
+	n.mu.Unlock()
+
+	n.wg.Wait()
+}
+
+// handle stores the reading in a message and settles the message: acknowledged when
+// stored, already stored under its Nats-Msg-Id, or rejected for good; terminated
+// when malformed; redelivered later when the sink couldn't take it.
+func (n *natsIngest) handle(msg jetstream.Msg) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("NATS ingestion: %v", recovered("nats", r))
+			msg.NakWithDelay(natsRetryDelay)
+		}
+	}()
+
+	id := msg.Headers().Get(jetstream.MsgIDHeader)
+	if id != "" {
+		if _, ok := n.seen.Get(id, n.sink.now()); ok {
+			n.ack(msg)
+			return
+		}
+	}
+
+	reading, err := natsReading(msg)
+	if err != nil {
+		log.Printf("NATS ingestion: %v", err)
+		msg.Term()
+		return
+	}
+
+	md := make(metadata.MD, len(msg.Headers()))
+	for k, v := range msg.Headers() {
+		md.Append(strings.ToLower(k), v...)
+	}
+	ctx, cancel := context.WithTimeout(metadata.NewIncomingContext(context.Background(), md), n.sink.config.NATSTimeout)
+	defer cancel()
+
+	_, err = n.sink.SendSensorData(ctx, reading)
+	switch status.Code(err) {
+	case codes.OK:
+		if id != "" {
+			n.seen.GetOrCreate(id, n.sink.now(), func() struct{} { return struct{}{} })
+		}
+		n.ack(msg)
+	case codes.InvalidArgument, codes.PermissionDenied, codes.Unauthenticated:
+		// Counted and dead-lettered like any rejected reading; it would fail again.
+		msg.Term()
+	default:
+		msg.NakWithDelay(natsRetryDelay)
+	}
+}
+
+// ack acknowledges msg and waits for the server to confirm, so a stored reading is
+// only redelivered if the confirmation is lost.
+func (n *natsIngest) ack(msg jetstream.Msg) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.sink.config.NATSTimeout)
+	defer cancel()
+
+	if err := msg.DoubleAck(ctx); err != nil {
+		log.Printf("NATS ingestion: acknowledge: %v", err)
+	}
+}
+
+// natsReading decodes a SensorData sent as protobuf, or as protobuf JSON with a
+// Content-Type of application/json.
+func natsReading(msg jetstream.Msg) (*pb.SensorData, error) {
+	reading := &pb.SensorData{}
+	var err error
+	if strings.HasPrefix(msg.Headers().Get("Content-Type"), "application/json") {
+		err = protojson.Unmarshal(msg.Data(), reading)
+	} else {
+		err = proto.Unmarshal(msg.Data(), reading)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed SensorData: %v", err)
+	}
+	return reading, nil
+}
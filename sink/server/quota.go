@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/sink/ratelimit"
+)
+
+// Trailer keys of the quota left after a call, with QuotaTrailers.
+const (
+	quotaLimitKey             = "x-quota-limit-bytes"
+	quotaRemainingKey         = "x-quota-remaining-bytes"
+	quotaRemainingMessagesKey = "x-quota-remaining-messages"
+	quotaResetKey             = "x-quota-reset-ms"
+)
+
+// quotaReport holds the quota left after the last message of a call was admitted.
+type quotaReport struct {
+	quota ratelimit.Quota
+	size  int // bytes of the message, to count the messages of its size left
+	set   bool
+}
+
+type quotaReportKey struct{}
+
+// quotaUnary sends the quota left after a call's messages were admitted, or
+// rejected, in its trailers. Calls that admit no message, e.g. heartbeats, send none.
+func (s *SinkServer) quotaUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	report := &quotaReport{}
+	resp, err := handler(context.WithValue(ctx, quotaReportKey{}, report), req)
+	if report.set {
+		grpc.SetTrailer(ctx, report.metadata())
+	}
+	return resp, err
+}
+
+func (r *quotaReport) metadata() metadata.MD {
+	return metadata.Pairs(
+		quotaLimitKey, strconv.Itoa(r.quota.Limit),
+		quotaRemainingKey, strconv.Itoa(r.quota.Remaining),
+		quotaRemainingMessagesKey, strconv.Itoa(r.quota.Remaining/max(r.size, 1)),
+		quotaResetKey, strconv.FormatInt(r.quota.Reset.Milliseconds(), 10),
+	)
+}
+
+// noteQuota records the tightest of the rate limits and quotas applying to a
+// message of size bytes for quotaUnary, if the call is reporting them.
+func (s *SinkServer) noteQuota(ctx context.Context, in *incoming, size int) {
+	report, ok := ctx.Value(quotaReportKey{}).(*quotaReport)
+	if !ok {
+		return
+	}
+
+	quota := s.rateLimiter.Quota()
+	if in.rule != nil {
+		if q, ok := in.rule.RateQuota(ctx, in.identity); ok {
+			quota = quota.Tighter(q)
+		}
+	}
+	if s.tenantLimiter != nil {
+		quota = quota.Tighter(s.tenantLimiter.Quota(ctx, in.tenant))
+	}
+	if s.sensorLimiter != nil {
+		quota = quota.Tighter(s.sensorLimiter.Quota(ctx, in.tenant+"/"+in.sensorName))
+	}
+	*report = quotaReport{quota: quota, size: size, set: true}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sink/metrics"
+)
+
+// recoverUnary turns a panic in a handler into an Internal error for that request
+// instead of crashing the sink. It is a backstop; handlers are expected to validate
+// their input and return proper status codes.
+func recoverUnary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(info.FullMethod, r)
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+// recoverStream is recoverUnary for streaming handlers.
+func recoverStream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(info.FullMethod, r)
+		}
+	}()
+
+	return handler(srv, ss)
+}
+
+func recovered(method string, r any) error {
+	log.Printf("panic in %s: %v\n%s", method, r, debug.Stack())
+	metrics.Panics.Inc()
+	return status.Error(codes.Internal, "internal error")
+}
+
+// restartDelay is how long a background goroutine that panicked waits before it is
+// restarted, so one panicking on every run doesn't spin.
+const restartDelay = time.Second
+
+// supervise runs a background goroutine, which returns once Stop is called, and
+// restarts it after a panic. Panics are logged and counted, and the first is kept
+// as the sink's panicked state, failing readiness until the sink is restarted: the
+// goroutine carries on, but whatever it was doing was cut short.
+func (s *SinkServer) supervise(name string, run func()) {
+	defer s.wg.Done()
+
+	for s.runRecovered(name, run) {
+		delay := s.config.Clock.NewTicker(restartDelay)
+		select {
+		case <-delay.C():
+			delay.Stop()
+			log.Printf("Restarting %s after a panic", name)
+		case <-s.done:
+			delay.Stop()
+			return
+		}
+	}
+}
+
+// runRecovered runs a background goroutine and reports whether it panicked.
+func (s *SinkServer) runRecovered(name string, run func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic in %s: %v\n%s", name, r, debug.Stack())
+			metrics.GoroutinePanics.WithLabelValues(name).Inc()
+			reason := fmt.Sprintf("%s panicked: %v", name, r)
+			s.panicked.CompareAndSwap(nil, &reason)
+			panicked = true
+		}
+	}()
+
+	run()
+	return false
+}
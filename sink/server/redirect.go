@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sink/proto"
+)
+
+// redirectTo returns where nodes are asked to move their traffic: the endpoints an
+// operator set, or the leader's address on the standby of an active/standby pair.
+// It is nil while nodes should stay.
+func (s *SinkServer) redirectTo() *pb.Redirect {
+	if redirect := s.redirect.Load(); redirect != nil {
+		return redirect
+	}
+	if !s.isLeader() {
+		if addr := s.leaderAddr.Load(); addr != nil && *addr != "" {
+			return &pb.Redirect{Endpoints: []string{*addr}}
+		}
+	}
+	return nil
+}
+
+// redirectError is an error whose status carries a Redirect detail. It unwraps to
+// the error it adds the detail to.
+type redirectError struct {
+	err      error
+	redirect *pb.Redirect
+}
+
+func (e *redirectError) Error() string { return e.err.Error() }
+func (e *redirectError) Unwrap() error { return e.err }
+
+func (e *redirectError) GRPCStatus() *status.Status {
+	st := status.Convert(e.err)
+	detailed, err := st.WithDetails(e.redirect)
+	if err != nil {
+		log.Printf("failed to attach error details: %v", err)
+		return st
+	}
+	return detailed
+}
+
+// withRedirect adds the Redirect detail of redirectTo, if any, to err.
+func (s *SinkServer) withRedirect(err error) error {
+	redirect := s.redirectTo()
+	if redirect == nil {
+		return err
+	}
+	return &redirectError{err: err, redirect: redirect}
+}
+
+// ParseEndpoints splits a comma separated list of host:port sink addresses, as given
+// to -redirect-to and the admin server's /redirect.
+func ParseEndpoints(list string) ([]string, error) {
+	var endpoints []string
+	for _, endpoint := range strings.Split(list, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return nil, fmt.Errorf("invalid endpoint %q: %v", endpoint, err)
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// handleRedirect shows where nodes are asked to move their traffic, as JSON.
+func (s *SinkServer) handleRedirect(w http.ResponseWriter, r *http.Request) {
+	endpoints := []string{}
+	if redirect := s.redirectTo(); redirect != nil {
+		endpoints = redirect.Endpoints
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]string{"endpoints": endpoints}); err != nil {
+		log.Printf("Admin: encode redirect: %v", err)
+	}
+}
+
+// handleSetRedirect asks nodes to move their traffic to the comma separated sink
+// addresses in ?to. The sink keeps accepting readings from nodes that stay.
+func (s *SinkServer) handleSetRedirect(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := ParseEndpoints(r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(endpoints) == 0 {
+		http.Error(w, "?to must list the sink addresses to redirect to", http.StatusBadRequest)
+		return
+	}
+
+	s.redirect.Store(&pb.Redirect{Endpoints: endpoints})
+	log.Printf("Admin: redirecting nodes to %s", strings.Join(endpoints, ", "))
+	s.handleRedirect(w, r)
+}
+
+// handleClearRedirect stops redirecting nodes.
+func (s *SinkServer) handleClearRedirect(w http.ResponseWriter, r *http.Request) {
+	if s.redirect.Swap(nil) == nil {
+		http.Error(w, "not redirecting", http.StatusNotFound)
+		return
+	}
+	log.Printf("Admin: stopped redirecting nodes")
+	w.WriteHeader(http.StatusNoContent)
+}
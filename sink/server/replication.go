@@ -0,0 +1,402 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/sink/audit"
+	"github.com/sink/auth"
+	"github.com/sink/config"
+	"github.com/sink/deadletter"
+	"github.com/sink/errdefs"
+	"github.com/sink/metrics"
+	"github.com/sink/processor"
+	pb "github.com/sink/proto"
+	"github.com/sink/replication"
+)
+
+// Replication retries back off from replicaMinBackoff to replicaMaxBackoff while the
+// standby is unreachable.
+const (
+	replicaMinBackoff = 100 * time.Millisecond
+	replicaMaxBackoff = 10 * time.Second
+)
+
+// replicaShipBytes is about the most a request ships of the journal's active segment;
+// sealed segments are shipped whole.
+const replicaShipBytes = 256 << 10
+
+// errReplicaTimeout is returned when the standby didn't apply a batch within ReplicaTimeout.
+var errReplicaTimeout = errors.New("standby did not acknowledge in time")
+
+// replicator ships the entries of accepted messages, journaled as numbered batches,
+// to the standby sink and lets requests wait until the standby has theirs.
+type replicator struct {
+	journal *replication.Journal
+	conn    *grpc.ClientConn
+	client  pb.TelemetryServiceClient
+	timeout time.Duration
+
+	pending chan struct{} // signaled when a batch is journaled
+
+	mu      sync.Mutex
+	known   bool          // applied was learned from the standby
+	applied uint64        // highest sequence number the standby applied
+	acked   chan struct{} // closed and replaced when applied advances
+}
+
+// newReplicator opens the journal and connects to the standby at ReplicaAddr.
+func (s *SinkServer) newReplicator() (*replicator, error) {
+	dir := s.config.ReplicaJournalDir
+	if dir == "" {
+		dir = s.config.LogFilePath + ".journal"
+	}
+	journal, err := replication.OpenJournal(dir, s.config.ReplicaJournalQuota, s.encryptor, metrics.ReplicationJournalBytes)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := s.dialSink(s.config.ReplicaAddr)
+	if err != nil {
+		journal.Close()
+		return nil, fmt.Errorf("connect to standby: %w", err)
+	}
+
+	return &replicator{
+		journal: journal,
+		conn:    conn,
+		client:  pb.NewTelemetryServiceClient(conn),
+		timeout: s.config.ReplicaTimeout,
+		pending: make(chan struct{}, 1),
+		acked:   make(chan struct{}),
+	}, nil
+}
+
+// append journals a message's entries, as JSON lines, as the next batch and returns
+// its sequence number. Callers hold bufferMutex, so batches are numbered in the
+// order entries are buffered.
+func (r *replicator) append(data []byte) (uint64, error) {
+	seq, err := r.journal.Append(data)
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case r.pending <- struct{}{}:
+	default:
+	}
+	r.updateLag()
+	return seq, nil
+}
+
+func (r *replicator) close() {
+	r.conn.Close()
+	if err := r.journal.Close(); err != nil {
+		log.Printf("Failed to close replication journal: %v", err)
+	}
+}
+
+// wait blocks until the standby applied batch seq, ctx is done or the timeout passes.
+func (r *replicator) wait(ctx context.Context, seq uint64) error {
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+
+	for {
+		r.mu.Lock()
+		applied, acked := r.applied, r.acked
+		r.mu.Unlock()
+		if applied >= seq {
+			return nil
+		}
+
+		select {
+		case <-acked:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return errReplicaTimeout
+		}
+	}
+}
+
+// setApplied records the standby's position and removes what it has from the journal.
+func (r *replicator) setApplied(applied uint64) {
+	r.mu.Lock()
+	advanced := applied > r.applied
+	r.known, r.applied = true, applied
+	if advanced {
+		close(r.acked)
+		r.acked = make(chan struct{})
+	}
+	r.mu.Unlock()
+
+	if err := r.journal.Trim(applied); err != nil {
+		log.Printf("Replication: failed to remove acknowledged journal segments: %v", err)
+	}
+	r.updateLag()
+}
+
+func (r *replicator) updateLag() {
+	r.mu.Lock()
+	applied := r.applied
+	r.mu.Unlock()
+	if last := r.journal.Next() - 1; last > applied {
+		metrics.ReplicationLag.Set(float64(last - applied))
+	} else {
+		metrics.ReplicationLag.Set(0)
+	}
+}
+
+// ship sends the standby the batches after its position. It returns false when the
+// standby has every batch journaled.
+func (r *replicator) ship(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	r.mu.Lock()
+	known, applied := r.known, r.applied
+	r.mu.Unlock()
+
+	if !known {
+		resp, err := r.client.Replicate(ctx, &pb.ReplicationRequest{JournalId: r.journal.ID()})
+		if err != nil {
+			return false, err
+		}
+		// The standby may have applied batches a crash tore off the journal.
+		if err := r.journal.SkipTo(resp.Applied + 1); err != nil {
+			return false, err
+		}
+		r.setApplied(resp.Applied)
+		return true, nil
+	}
+
+	first, batches, err := r.journal.Read(applied, replicaShipBytes)
+	if err != nil || len(batches) == 0 {
+		return false, err
+	}
+	resp, err := r.client.Replicate(ctx, &pb.ReplicationRequest{JournalId: r.journal.ID(), FirstSequence: first, Batches: batches})
+	if err != nil {
+		return false, err
+	}
+	r.setApplied(resp.Applied)
+	return true, nil
+}
+
+// runReplication ships journaled batches to the standby until Stop is called,
+// catching it up from the journal after it was unreachable. Batches left then are
+// shipped after the next start.
+func (s *SinkServer) runReplication() {
+	r := s.replicator
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.done
+		cancel()
+	}()
+
+	backoff := replicaMinBackoff
+	failing := false
+	for {
+		shipped, err := r.ship(ctx)
+		switch {
+		case err != nil:
+			if !failing {
+				log.Printf("Replication to %s failing, retrying: %v", s.config.ReplicaAddr, err)
+			}
+			failing = true
+			select {
+			case <-time.After(backoff):
+			case <-s.done:
+				return
+			}
+			backoff = min(2*backoff, replicaMaxBackoff)
+			continue
+		case failing:
+			log.Printf("Replication to %s resumed", s.config.ReplicaAddr)
+			failing, backoff = false, replicaMinBackoff
+		}
+
+		if shipped {
+			continue
+		}
+		select {
+		case <-r.pending:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// replicate journals the JSON lines of a message's stored entries for the standby.
+// Callers hold bufferMutex. With ReplicaAckPrimary, entries the full journal can't
+// take are counted and left out; with ReplicaAckBoth, the message is rejected.
+func (s *SinkServer) replicate(in *incoming, data []byte, entries int) (uint64, error) {
+	seq, err := s.replicator.append(data)
+	switch {
+	case err == nil:
+		return seq, nil
+	case s.config.ReplicaAck == config.ReplicaAckBoth:
+		return 0, s.reject(in, deadletter.ReasonUnavailable, errdefs.Errorf(errdefs.ErrBufferFull, "replicate: %w", err))
+	}
+	if errors.Is(err, replication.ErrJournalFull) {
+		metrics.ReplicationEntriesDropped.Add(float64(entries))
+	} else {
+		log.Printf("Replication: failed to journal %d entries: %v", entries, err)
+	}
+	return 0, nil
+}
+
+// replicaData returns the JSON lines of the stored entries, which logData holds
+// unless the log is encrypted per entry.
+func (s *SinkServer) replicaData(stored []*processor.Entry, logData []byte) ([]byte, error) {
+	if s.encryptor == nil || segmentEncryption(s.config) {
+		return bytes.Clone(logData), nil
+	}
+
+	var data []byte
+	for _, entry := range stored {
+		var err error
+		if data, err = s.appendStored(data, entry); err != nil {
+			return nil, err
+		}
+		data = append(data, '\n')
+	}
+	return data, nil
+}
+
+// replicaStatePath is where a standby keeps what it applied of the primary's journal.
+func replicaStatePath(cfg config.Config) string {
+	return cfg.LogFilePath + ".replica"
+}
+
+// Replicate applies batches of a primary's journal: their entries are buffered as
+// the primary's were, already processed, and the position is kept next to the log.
+// Batches applied before are skipped, so the primary may resend them. Only the
+// ReplicationPeers may replicate.
+func (s *SinkServer) Replicate(ctx context.Context, req *pb.ReplicationRequest) (*pb.ReplicationResponse, error) {
+	if !s.config.AcceptReplication {
+		return nil, status.Error(codes.Unimplemented, "sink does not accept replication")
+	}
+	// Primaries connect with the sink's certificate whatever AuthMethods are set.
+	id, err := (auth.MTLS{Name: clientIdentity}).Authenticate(ctx)
+	if err != nil {
+		s.auditEvent(ctx, audit.EventAuthFailure, "", fmt.Sprintf("replication: %v", err))
+		return nil, status.Errorf(codes.Unauthenticated, "invalid client certificate: %v", err)
+	}
+	if !slices.Contains(s.config.ReplicationPeers, id.Name) {
+		log.Printf("Replication: refused batches from %s, not a replication peer", id)
+		s.auditEvent(ctx, audit.EventAuthFailure, id.Name, "replication: not a replication peer")
+		return nil, status.Errorf(codes.PermissionDenied, "%s may not replicate to this sink", id)
+	}
+	if req.JournalId == "" {
+		return nil, status.Error(codes.InvalidArgument, "journal_id is required")
+	}
+
+	s.replicaMutex.Lock()
+	defer s.replicaMutex.Unlock()
+
+	state := s.replicaState
+	if state.Primary != req.JournalId {
+		if len(req.Batches) == 0 {
+			return &pb.ReplicationResponse{}, nil
+		}
+		log.Printf("Replication: applying journal %s of a new primary from batch %d", req.JournalId, req.FirstSequence)
+		state = replication.State{Primary: req.JournalId, Applied: req.FirstSequence - 1}
+	}
+	if len(req.Batches) > 0 && req.FirstSequence > state.Applied+1 {
+		log.Printf("Replication: missed batches %d to %d of journal %s, dropped by the primary", state.Applied+1, req.FirstSequence-1, req.JournalId)
+	}
+
+	var (
+		data    []byte
+		entries int
+		scratch []byte
+	)
+	applied := state.Applied
+	for i, batch := range req.Batches {
+		seq := req.FirstSequence + uint64(i)
+		if seq <= applied {
+			continue
+		}
+		for line := range bytes.Lines(batch) {
+			line = bytes.TrimSuffix(line, []byte("\n"))
+			if len(line) == 0 {
+				continue
+			}
+			start := len(data)
+			data = append(data, line...)
+			if s.encryptor != nil && !segmentEncryption(s.config) {
+				var err error
+				if data, err = s.sealEntry(data, start, &scratch); err != nil {
+					return nil, errdefs.Errorf(errdefs.ErrEncryption, "failed to encrypt log data: %w", err)
+				}
+			} else {
+				data = append(data, '\n')
+			}
+			entries++
+		}
+		applied = seq
+	}
+
+	if len(data) > 0 {
+		if err := s.bufferReplicated(ctx, data, entries); err != nil {
+			return nil, err
+		}
+		metrics.ReplicationEntriesApplied.Add(float64(entries))
+	}
+	if applied != s.replicaState.Applied || state.Primary != s.replicaState.Primary {
+		state.Applied = applied
+		if err := state.Save(replicaStatePath(s.config)); err != nil {
+			// The batches are buffered; at worst they are applied again after a restart.
+			log.Printf("Replication: %v", err)
+		}
+		s.replicaState = state
+	}
+	return &pb.ReplicationResponse{Applied: state.Applied}, nil
+}
+
+// bufferReplicated appends replicated entries, encoded as the log stores them, to
+// the buffer.
+func (s *SinkServer) bufferReplicated(ctx context.Context, data []byte, entries int) error {
+	s.bufferMutex.Lock()
+	defer s.bufferMutex.Unlock()
+
+	if s.buffer == nil {
+		return status.Error(codes.Unavailable, "sink is closed")
+	}
+	if len(s.buffer)+len(data) > s.config.BufferSize {
+		if err := s.flushBuffer(); err != nil {
+			if err := s.waitForWriter(ctx, &incoming{}); err != nil {
+				return errdefs.Errorf(errdefs.ErrBufferFull, "buffer full: %w", err)
+			}
+		}
+	}
+	s.buffer = append(s.buffer, data...)
+	s.buffered.add(entries, false, s.now())
+	s.noteBuffered(len(data))
+	return nil
+}
+
+// loadReplicaState reads what a standby applied before it was restarted.
+func loadReplicaState(cfg config.Config) (replication.State, error) {
+	if !cfg.AcceptReplication {
+		return replication.State{}, nil
+	}
+	state, err := replication.LoadState(replicaStatePath(cfg))
+	if err != nil {
+		return state, err
+	}
+	if state.Primary != "" {
+		log.Printf("Replication: applied batch %d of journal %s before, in %s", state.Applied, state.Primary, filepath.Dir(cfg.LogFilePath))
+	}
+	return state, nil
+}
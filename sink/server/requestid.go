@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/sink/proto"
+)
+
+// requestIDMetadataKey is the gRPC metadata key of the ID clients stamp calls with.
+const requestIDMetadataKey = "x-request-id"
+
+// readingRequestID returns the ID the node gave a reading, or else the ID of the
+// call carrying it. It is empty for clients sending neither.
+func readingRequestID(ctx context.Context, req *pb.SensorData) string {
+	if req.RequestId != "" {
+		return req.RequestId
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIDMetadataKey); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// withRequestID adds a RequestInfo detail with the request ID to a status error,
+// keeping its code, message and other details.
+func withRequestID(err error, requestID string) error {
+	if requestID == "" {
+		return err
+	}
+
+	st := status.Convert(err)
+	detailed, derr := st.WithDetails(&errdetails.RequestInfo{RequestId: requestID})
+	if derr != nil {
+		log.Printf("failed to attach error details: %v", derr)
+		return err
+	}
+	return detailed.Err()
+}
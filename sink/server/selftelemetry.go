@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sink/metrics"
+	"github.com/sink/processor"
+	pb "github.com/sink/proto"
+)
+
+// selfHostTag names the host a sink's own readings come from, which tells sinks
+// reporting to the same upstream apart.
+const selfHostTag = "host"
+
+// selfCounters are the cumulative figures that self-telemetry reports as changes
+// since its previous reading.
+type selfCounters struct {
+	received     float64
+	rejected     float64
+	canceled     float64
+	writes       uint64
+	writeSeconds float64
+}
+
+func readSelfCounters() selfCounters {
+	writes, writeSeconds := metrics.Observed(metrics.StageDuration.WithLabelValues("write").(prometheus.Histogram))
+	return selfCounters{
+		received:     metrics.Total(metrics.EntriesReceived),
+		rejected:     metrics.Total(metrics.EntriesRejected),
+		canceled:     metrics.Total(metrics.RequestsCanceled),
+		writes:       writes,
+		writeSeconds: writeSeconds,
+	}
+}
+
+// dialUpstream connects to the sink receiving self-telemetry, nil when it is stored
+// locally or self-telemetry is off. The connection is made when the first reading
+// is sent.
+func (s *SinkServer) dialUpstream() (*grpc.ClientConn, error) {
+	if s.config.SelfTelemetryInterval == 0 || s.config.SelfTelemetryUpstream == "" {
+		return nil, nil
+	}
+	return s.dialSink(s.config.SelfTelemetryUpstream)
+}
+
+// dialSink connects to another sink at addr, with this sink's TLS files when UseTLS
+// is set. The connection is made when the first call is sent.
+func (s *SinkServer) dialSink(addr string) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if s.config.UseTLS {
+		tlsConfig := &tls.Config{}
+		if s.config.CertFile != "" {
+			cert, err := tls.LoadX509KeyPair(s.config.CertFile, s.config.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("load certificates: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		if s.config.CAFile != "" {
+			caCert, err := os.ReadFile(s.config.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("read CA certificate: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+				return nil, fmt.Errorf("append CA certificate")
+			}
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	return grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+}
+
+// runSelfTelemetry sends a reading of the sink's own health every
+// SelfTelemetryInterval until Stop is called. upstream is nil to store the readings
+// through this sink's pipeline.
+func (s *SinkServer) runSelfTelemetry(upstream *grpc.ClientConn) {
+	defer s.wg.Done()
+
+	var client pb.TelemetryServiceClient
+	if upstream != nil {
+		defer upstream.Close()
+		client = pb.NewTelemetryServiceClient(upstream)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Printf("Self-telemetry: failed to get hostname: %v", err)
+	}
+
+	ticker := s.config.Clock.NewTicker(s.config.SelfTelemetryInterval)
+	defer ticker.Stop()
+
+	last := readSelfCounters()
+	for {
+		select {
+		case <-ticker.C():
+		case <-s.done:
+			return
+		}
+
+		current := readSelfCounters()
+		req := s.selfReading(last, current)
+		if hostname != "" {
+			req.Tags = map[string]string{selfHostTag: hostname}
+		}
+		last = current
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.SelfTelemetryInterval)
+		if client != nil {
+			_, err = client.SendSensorData(ctx, req)
+		} else {
+			err = s.storeSelfReading(ctx, req)
+		}
+		cancel()
+		if err != nil {
+			log.Printf("Self-telemetry: failed to send reading: %v", err)
+		}
+	}
+}
+
+// selfReading returns the sink's current load and what changed between two
+// readings of its counters. Entries and rejections count readings of other sensors
+// only: self-telemetry stored locally is not counted.
+func (s *SinkServer) selfReading(last, current selfCounters) *pb.SensorData {
+	stats := s.watchdogStats()
+
+	values := map[string]float64{
+		"buffer_bytes":          float64(stats.BufferLen),
+		"buffer_percent":        100 * float64(stats.BufferLen) / float64(stats.BufferSize),
+		"write_queue":           float64(stats.Queued),
+		"flush_latency_seconds": stats.FlushLatency.Seconds(),
+		"goroutines":            float64(stats.Goroutines),
+		"entries_received":      current.received - last.received,
+		"entries_rejected":      current.rejected - last.rejected,
+		"requests_canceled":     current.canceled - last.canceled,
+		"writes":                float64(current.writes - last.writes),
+	}
+	if writes := current.writes - last.writes; writes > 0 {
+		values["write_seconds_mean"] = (current.writeSeconds - last.writeSeconds) / float64(writes)
+	}
+
+	return &pb.SensorData{
+		SensorName: s.config.SelfTelemetrySensor,
+		Timestamp:  timestamppb.New(s.now()),
+		Values:     values,
+	}
+}
+
+// storeSelfReading stores a reading of the sink's own health through its pipeline.
+// The sink trusts itself, so authentication, rate limits and quotas don't apply.
+func (s *SinkServer) storeSelfReading(ctx context.Context, req *pb.SensorData) error {
+	in := &incoming{
+		msg:        req,
+		sensorName: req.SensorName,
+		tenant:     defaultTenant,
+		identity:   "self",
+	}
+
+	entry := &processor.Entry{
+		Timestamp:  req.Timestamp.AsTime(),
+		SensorName: req.SensorName,
+		DataTime:   req.Timestamp.AsTime(),
+		Tags:       req.Tags,
+		Tenant:     defaultTenant,
+	}
+
+	_, err := s.store(ctx, in, s.splitValues(entry, req))
+	return err
+}
@@ -1,89 +1,448 @@
 package server
 
 import (
-	"bufio"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"net"
 	"os"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/redis/go-redis/v9"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/sink/audit"
+	"github.com/sink/auth"
+	"github.com/sink/authz"
+	"github.com/sink/banlist"
+	"github.com/sink/clock"
 	"github.com/sink/config"
+	"github.com/sink/deadletter"
+	"github.com/sink/election"
 	"github.com/sink/encryptor"
+	"github.com/sink/errdefs"
+	"github.com/sink/ipaccess"
+	"github.com/sink/listener"
+	"github.com/sink/liveness"
+	"github.com/sink/metrics"
+	"github.com/sink/output"
+	"github.com/sink/pkg/logformat"
+	"github.com/sink/processor"
 	pb "github.com/sink/proto"
 	"github.com/sink/ratelimit"
+	"github.com/sink/replication"
+	"github.com/sink/state"
+	"github.com/sink/storage"
 )
 
-const serverName = "localhost"
+const (
+	serverName = "localhost"
+
+	// tenantMetadataKey is the gRPC metadata key clients use to declare their tenant.
+	tenantMetadataKey = "x-tenant-id"
+	defaultTenant     = "default"
+
+	// priorityTag lets clients that predate the priority field mark readings critical.
+	priorityTag = "priority"
+
+	// Tags set on entries with a skewed device clock.
+	clockSkewTag  = "clock_skew"
+	deviceTimeTag = "device_time"
+
+	// ingestLatencyTag is set on readings received later than MaxIngestLatency.
+	ingestLatencyTag = "ingest_latency"
+
+	// bufferAgeChecks is how many times per MaxBufferAge the age of the oldest
+	// buffered entry is checked.
+	bufferAgeChecks = 4
+
+	// criticalBufferHeadroom is how far past BufferSize the buffer may grow with
+	// critical entries while the writer queue is full.
+	criticalBufferHeadroom = 2
+
+	// maxWriterWait bounds how long a request waits for a slot in a full writer
+	// queue. The wait holds bufferMutex, so every other request, flush and
+	// snapshot waits as well.
+	maxWriterWait = 100 * time.Millisecond
+
+	// Reasons entries are dropped by the overload policy.
+	dropOldest  = "oldest"
+	dropSampled = "sampled"
+)
 
 type SinkServer struct {
 	pb.UnimplementedTelemetryServiceServer
 	config      config.Config
 	buffer      []byte
+	buffered    bufferCount // entries in buffer, for the overload policy
+	rate        ingestRate  // for adaptive flushing
+	sampled     uint64      // entries that met the sample overload policy
 	bufferMutex sync.Mutex
-	logFile     *os.File
-	fileWriter  *bufio.Writer
-	rateLimiter *ratelimit.RateLimiter
-	encryptor   *encryption.AESGCMEncryptor
-	done        chan struct{}
-	wg          sync.WaitGroup
+	writer      *storage.FileWriter
+	fanOut      []*fanOut    // further outputs, guarded by bufferMutex
+	entryFormat *entryFormat // of the log's JSON entries; other outputs get the default
+	rateLimiter *ratelimit.PriorityLimiter
+	// attachmentLimiter is nil when attachments are not rate limited separately
+	attachmentLimiter *ratelimit.RateLimiter
+	// tenantLimiter and sensorLimiter are nil when the corresponding quota is disabled
+	tenantLimiter ratelimit.KeyedLimiter
+	sensorLimiter ratelimit.KeyedLimiter
+	redisClient   *redis.Client
+	authenticator auth.Authenticator               // nil when clients aren't authenticated
+	tokens        atomic.Pointer[auth.Tokens]      // nil without AuthTokensFile
+	policy        atomic.Pointer[authz.Policy]     // nil when no policy is configured
+	pipeline      atomic.Pointer[processor.Chain]  // nil when no pipeline is configured
+	routes        atomic.Pointer[processor.Routes] // nil when every output stores every entry
+	x509Source    *workloadapi.X509Source
+	audit         *audit.Logger      // nil when audit logging is disabled
+	deadLetter    *deadletter.Writer // nil when no dead-letter file is configured
+	sensors       *liveness.Tracker
+	recent        *recentReadings // readings accepted in batches, to recognize resent ones
+	encryptor     *encryption.Encryptor
+	replicator    *replicator    // nil unless a standby is configured
+	election      *election.Lock // nil unless LeaderLockFile is set
+	leader        atomic.Bool    // set once this sink holds the leader lock
+	leaderAddr    atomic.Pointer[string]
+	redirect      atomic.Pointer[pb.Redirect] // set by an operator, nil while nodes should stay
+	// replicaState is what this sink, as a standby, applied of its primary's journal
+	replicaState replication.State
+	replicaMutex sync.Mutex
+	done         chan struct{}
+	stopOnce     sync.Once
+	wg           sync.WaitGroup
+	serving      atomic.Bool            // set while the gRPC server accepts connections
+	draining     atomic.Bool            // set once shutdown starts, reported to sensor nodes
+	overloaded   atomic.Pointer[string] // watchdog's reason while shedding load, nil otherwise
+	panicked     atomic.Pointer[string] // first panic of a background goroutine, nil if none
+
+	listener      net.Listener
+	listenerMutex sync.Mutex
+
+	// bans holds the banned client IPs; handshakes limits new connections per
+	// client IP, nil unless HandshakesPerIP is set
+	bans       *banlist.List
+	handshakes ratelimit.KeyedLimiter
+	ipAccess   atomic.Pointer[ipaccess.List] // nil allows every client IP
 }
 
-func NewSinkServer(config config.Config) (*SinkServer, error) {
-	logFile, err := os.OpenFile(config.LogFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %w", err)
+func NewSinkServer(config config.Config) (_ *SinkServer, err error) {
+	if config.Clock == nil {
+		config.Clock = clock.Real
 	}
 
-	fileWriter := bufio.NewWriter(logFile)
+	// If a step fails, whatever was opened before it is closed again: piece by piece,
+	// or by Close once the server holds it all.
+	var (
+		server      *SinkServer
+		spill       *storage.Spill
+		writer      *storage.FileWriter
+		fanOuts     []*fanOut
+		pipeline    *processor.Chain
+		auditLogger *audit.Logger
+		deadLetter  *deadletter.Writer
+	)
+	defer func() {
+		switch {
+		case err == nil:
+		case server != nil:
+			server.Close()
+		default:
+			closeOpened(spill, writer, fanOuts, pipeline, auditLogger, deadLetter)
+		}
+	}()
 
-	var encryptor *encryption.AESGCMEncryptor
+	var (
+		encryptor *encryption.Encryptor
+		encoder   storage.Encoder
+	)
 	if config.EnableEncryption {
-		encryptor, err = encryption.NewAESGCMEncryptor(config.EncryptionKey)
+		encryptor, err = newEncryptor(config)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create encryptor: %w", err)
 		}
-		log.Println("Log encryption enabled")
+		// The encryptor holds its own copy; don't keep the encoded key around as well.
+		config.EncryptionKey = ""
+		log.Printf("Log encryption enabled (%s)", config.EncryptionCipher)
+	}
+	if segmentEncryption(config) {
+		encoder = logformat.NewEncoder(true, encryptor)
+	} else if binaryLog(config) && encryptor == nil {
+		encoder = logformat.NewEncoder(true, nil)
+	}
+
+	if !logOutput(config) && (config.EnableEncryption || binaryLog(config)) {
+		return nil, fmt.Errorf("%s output can't be encrypted or use the binary log format", config.Output)
+	}
+	fields, err := logformat.NewFields(config.LogFields, config.LogTimeFormat)
+	if err != nil {
+		return nil, fmt.Errorf("log fields: %w", err)
+	}
+	if fields != nil && !logOutput(config) {
+		return nil, fmt.Errorf("%s output has fixed field names and times; renaming them applies to the log", config.Output)
+	}
+	if len(config.FanOut) > 0 && config.EnableEncryption {
+		return nil, fmt.Errorf("outputs fed a copy of the entries get them in the clear, so the log can't be encrypted")
+	}
+
+	if config.SpillDir != "" {
+		if spill, err = storage.OpenSpill(config.SpillDir, config.SpillQuota); err != nil {
+			return nil, err
+		}
+		if n := spill.Len(); n > 0 {
+			log.Printf("Found %d spilled buffers (%d bytes) from a previous run", n, spill.Bytes())
+		}
+	}
+
+	if writer, err = openOutput(config, config.Output, encoder, spill); err != nil {
+		return nil, err
+	}
+	for _, name := range config.FanOut {
+		w, err := openOutput(config, name, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s output: %w", name, err)
+		}
+		fanOuts = append(fanOuts, newFanOut(name, w))
+	}
+
+	tokens, oidc, err := loadAuth(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy *authz.Policy
+	if config.AuthzPolicyFile != "" {
+		if !authEnabled(config) {
+			return nil, errPolicyWithoutAuth
+		}
+		policy, err = authz.LoadPolicy(config.AuthzPolicyFile, config.Clock)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load authorization policy: %w", err)
+		}
+		log.Printf("Authorization policy loaded with %d rules", len(policy.Rules))
+	}
+
+	var ipAccess *ipaccess.List
+	if config.IPAccessFile != "" {
+		if ipAccess, err = ipaccess.Load(config.IPAccessFile); err != nil {
+			return nil, fmt.Errorf("failed to load IP access list: %w", err)
+		}
+		log.Printf("IP access list loaded with %d allowed and %d denied networks", len(ipAccess.Allow), len(ipAccess.Deny))
+	}
+
+	if config.PipelineFile != "" {
+		pipeline, err = processor.LoadChain(config.PipelineFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load processing pipeline: %w", err)
+		}
+		log.Printf("Processing pipeline loaded with %d stages", pipeline.Len())
+	}
+
+	var routes *processor.Routes
+	if config.RoutesFile != "" {
+		if routes, err = loadRoutes(config); err != nil {
+			return nil, err
+		}
+		log.Printf("Routes loaded for outputs %v", routes.Outputs())
+	}
+
+	if config.AuditLogFile != "" {
+		var signingKey []byte
+		if config.AuditSigningKeyFile != "" {
+			signingKey, err = audit.LoadSigningKey(config.AuditSigningKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load audit signing key: %w", err)
+			}
+		}
+		auditLogger, err = audit.NewLogger(config.AuditLogFile, config.AuditMaxSize, signingKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audit log: %w", err)
+		}
+		log.Printf("Audit log: %s (signed: %v)", config.AuditLogFile, signingKey != nil)
+	}
+
+	if config.DeadLetterFile != "" {
+		deadLetter, err = deadletter.NewWriter(config.DeadLetterFile, config.DeadLetterMaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dead-letter file: %w", err)
+		}
+		log.Printf("Dead-letter file: %s", config.DeadLetterFile)
+	}
+
+	if config.AttachmentDir != "" {
+		if err := os.MkdirAll(config.AttachmentDir, 0750); err != nil {
+			return nil, fmt.Errorf("failed to create attachment directory: %w", err)
+		}
+		log.Printf("Attachment directory: %s", config.AttachmentDir)
 	}
 
-	return &SinkServer{
+	server = &SinkServer{
 		config:      config,
-		buffer:      make([]byte, 0, config.BufferSize),
-		logFile:     logFile,
-		fileWriter:  fileWriter,
-		rateLimiter: ratelimit.NewRateLimiter(config.RateLimit),
+		buffer:      writer.NewBuffer(),
+		writer:      writer,
+		fanOut:      fanOuts,
+		entryFormat: newEntryFormat(fields),
+		rateLimiter: ratelimit.NewPriorityLimiter(config.RateLimit, config.CriticalRateLimit, config.Clock),
 		encryptor:   encryptor,
+		audit:       auditLogger,
+		deadLetter:  deadLetter,
+		sensors:     liveness.NewTracker(config.SensorSilentAfter, config.MaxIngestLatency, state.Limits{TTL: config.SensorStateTTL, MaxEntries: config.MaxTrackedSensors}),
+		recent:      newRecentReadings(state.Limits{TTL: config.SensorStateTTL, MaxEntries: config.MaxTrackedSensors}),
+		bans:        banlist.New(config.BanAfter, config.BanWindow, config.BanDuration, config.Clock),
 		done:        make(chan struct{}),
-	}, nil
+	}
+	if config.HandshakesPerIP > 0 {
+		server.handshakes = ratelimit.NewLocalKeyedLimiter(config.HandshakesPerIP, config.MaxTrackedSensors, config.Clock)
+	}
+	if config.AttachmentRateLimit > 0 {
+		server.attachmentLimiter = ratelimit.NewRateLimiter(config.AttachmentRateLimit, config.Clock)
+	}
+	if len(config.RedirectTo) > 0 {
+		server.redirect.Store(&pb.Redirect{Endpoints: config.RedirectTo})
+	}
+	server.tokens.Store(tokens)
+	server.authenticator = server.newAuthenticator(oidc)
+	server.policy.Store(policy)
+	server.ipAccess.Store(ipAccess)
+	server.pipeline.Store(pipeline)
+	server.routes.Store(routes)
+	server.setupQuotas()
+
+	if config.SpiffeSocket != "" {
+		server.x509Source, err = newX509Source(config.SpiffeSocket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up SPIFFE: %w", err)
+		}
+		log.Printf("SPIFFE workload API connected at %s", config.SpiffeSocket)
+	}
+
+	if config.LeaderLockFile != "" {
+		server.election = election.New(config.LeaderLockFile, config.AdvertiseAddr)
+		server.campaign()
+	}
+
+	if server.replicaState, err = loadReplicaState(config); err != nil {
+		return nil, err
+	}
+	if config.ReplicaAddr != "" {
+		if server.replicator, err = server.newReplicator(); err != nil {
+			return nil, fmt.Errorf("failed to set up replication: %w", err)
+		}
+	}
+
+	return server, nil
+}
+
+// closeOpened closes what NewSinkServer opened before it failed. A writer closes its
+// spill.
+func closeOpened(spill *storage.Spill, writer *storage.FileWriter, fanOuts []*fanOut, pipeline *processor.Chain, auditLogger *audit.Logger, deadLetter *deadletter.Writer) {
+	if writer != nil {
+		writer.Close()
+	} else if spill != nil {
+		spill.Close()
+	}
+	for _, f := range fanOuts {
+		f.writer.Close()
+	}
+	if pipeline != nil {
+		pipeline.Close()
+	}
+	if auditLogger != nil {
+		auditLogger.Close()
+	}
+	if deadLetter != nil {
+		deadLetter.Close()
+	}
+}
+
+func (s *SinkServer) setupQuotas() {
+	newLimiter := func(rate int, scope string) ratelimit.KeyedLimiter {
+		if s.redisClient != nil {
+			return ratelimit.NewRedisLimiter(s.redisClient, s.config.RedisKeyPrefix+scope+":", rate, s.config.MaxTrackedSensors, s.config.Clock)
+		}
+		return ratelimit.NewLocalKeyedLimiter(rate, s.config.MaxTrackedSensors, s.config.Clock)
+	}
+
+	if s.config.RedisAddr != "" && (s.config.TenantRateLimit > 0 || s.config.SensorRateLimit > 0) {
+		s.redisClient = redis.NewClient(&redis.Options{
+			Addr:         s.config.RedisAddr,
+			Password:     s.config.RedisPassword,
+			DialTimeout:  200 * time.Millisecond,
+			ReadTimeout:  100 * time.Millisecond,
+			WriteTimeout: 100 * time.Millisecond,
+		})
+		log.Printf("Distributed quotas enabled via redis at %s", s.config.RedisAddr)
+	}
+
+	if s.config.TenantRateLimit > 0 {
+		s.tenantLimiter = newLimiter(s.config.TenantRateLimit, "tenant")
+	}
+	if s.config.SensorRateLimit > 0 {
+		s.sensorLimiter = newLimiter(s.config.SensorRateLimit, "sensor")
+	}
 }
 
 func (s *SinkServer) Start() error {
-	lis, err := net.Listen("tcp", s.config.BindAddr)
+	lis, err := s.listen()
 	if err != nil {
 		return fmt.Errorf("listen: %w", err)
 	}
 
-	var opts []grpc.ServerOption
+	s.listenerMutex.Lock()
+	s.listener = lis
+	s.listenerMutex.Unlock()
 
-	if s.config.UseTLS {
-		creds, err := s.loadTLSCredentials()
+	return s.Serve(lis)
+}
+
+// Serve runs the sink on lis until Stop is called. Start binds the configured address
+// and calls Serve; tests and benchmarks pass an in-memory listener. Listeners passed to
+// Serve directly can't be handed over by Upgrade.
+func (s *SinkServer) Serve(lis net.Listener) error {
+	if s.config.ProxyProtocol {
+		lis = listener.ProxyProtocol(lis, s.config.ProxyProtocolFrom)
+	}
+	lis = s.guardListener(lis)
+	if s.config.MaxConnsPerIP > 0 {
+		lis = listener.LimitPerIP(lis, s.config.MaxConnsPerIP)
+	}
+
+	opts := s.tuningOptions()
+
+	// The frame listener serves the same credentials as gRPC.
+	var (
+		tlsConfig *tls.Config
+		err       error
+	)
+	if s.x509Source != nil {
+		tlsConfig, err = s.spiffeTLSConfig()
+		if err != nil {
+			return fmt.Errorf("load SPIFFE credentials: %w", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		log.Println("mTLS with SPIFFE identities enabled for gRPC server")
+	} else if s.config.UseTLS {
+		tlsConfig, err = s.loadTLSConfig()
 		if err != nil {
 			return fmt.Errorf("load TLS credentials: %w", err)
 		}
-		opts = append(opts, grpc.Creds(creds))
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
 		if s.config.CAFile != "" {
 			log.Println("mTLS enabled for gRPC server")
 		} else {
@@ -91,30 +450,161 @@ func (s *SinkServer) Start() error {
 		}
 	}
 
+	upstream, err := s.dialUpstream()
+	if err != nil {
+		return fmt.Errorf("connect to self-telemetry upstream: %w", err)
+	}
+
+	if s.audit != nil {
+		opts = append(opts, grpc.StatsHandler(&connAuditor{audit: s.audit}))
+	}
+
+	unary := []grpc.UnaryServerInterceptor{recoverUnary, s.guardUnary}
+	if s.config.QuotaTrailers {
+		unary = append(unary, s.quotaUnary)
+	}
+	opts = append(opts,
+		grpc.ForceServerCodecV2(newTimingCodec()),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(recoverStream, s.guardStream),
+	)
+
 	grpcServer := grpc.NewServer(opts...)
 	pb.RegisterTelemetryServiceServer(grpcServer, s)
 
 	s.wg.Add(1)
-	go s.flushTimer()
+	go s.supervise("flush_timer", s.flushTimer)
+
+	if s.config.WatchdogInterval > 0 {
+		s.wg.Add(1)
+		go s.supervise("watchdog", s.runWatchdog)
+	}
+
+	if s.config.CompactInterval > 0 {
+		s.wg.Add(1)
+		go s.supervise("compaction", s.runCompaction)
+	}
+
+	if s.replicator != nil {
+		s.wg.Add(1)
+		go s.supervise("replication", s.runReplication)
+	}
+
+	if s.election != nil && !s.leader.Load() {
+		s.wg.Add(1)
+		go s.runElection()
+	}
+
+	if s.config.AdminAddr != "" {
+		s.wg.Add(1)
+		go s.serveAdmin()
+	}
+
+	if s.config.SelfTelemetryInterval > 0 {
+		s.wg.Add(1)
+		go s.runSelfTelemetry(upstream)
+	}
+
+	var frames *frameServer
+	if s.config.FrameAddr != "" {
+		frames = s.startFrames(tlsConfig)
+	}
+	if s.config.ConnectAddr != "" {
+		s.wg.Add(1)
+		go s.serveConnect(tlsConfig)
+	}
+	var coapServer *coapServer
+	if s.config.CoAPAddr != "" {
+		coapServer = s.startCoAP()
+	}
+	var natsIngest *natsIngest
+	if s.config.NATSIngestSubject != "" {
+		natsIngest = s.startNATS()
+	}
 
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
+		if err := listener.NotifyReady(); err != nil {
+			log.Printf("Failed to notify parent process: %v", err)
+		}
+		s.serving.Store(true)
 		if err := grpcServer.Serve(lis); err != nil {
 			log.Printf("Server: %v", err)
 		}
 	}()
 
 	<-s.done
+	s.serving.Store(false)
+	s.draining.Store(true)
+
+	// Keep serving for a while so nodes learn from their responses that this sink is
+	// going away and move their traffic before the connections close.
+	if s.config.DrainPeriod > 0 {
+		log.Printf("Draining for %v before closing connections...", s.config.DrainPeriod)
+		time.Sleep(s.config.DrainPeriod)
+	}
 
 	log.Println("Shutting down server...")
+	if frames != nil {
+		frames.Stop()
+	}
+	if coapServer != nil {
+		coapServer.Stop()
+	}
+	if natsIngest != nil {
+		natsIngest.Stop()
+	}
 	grpcServer.GracefulStop()
 	s.wg.Wait()
 
 	return nil
 }
 
-func (s *SinkServer) loadTLSCredentials() (credentials.TransportCredentials, error) {
+// tuningOptions sizes the gRPC server from the configuration, leaving gRPC defaults
+// in place for unset values.
+func (s *SinkServer) tuningOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+
+	if s.config.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(s.config.MaxConcurrentStreams))
+	}
+	if s.config.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(s.config.MaxRecvMsgSize))
+	}
+	if s.config.StreamWorkers > 0 {
+		// Handlers run on a fixed pool of goroutines instead of one goroutine per stream.
+		opts = append(opts, grpc.NumStreamWorkers(s.config.StreamWorkers))
+	}
+
+	return opts
+}
+
+// listen prefers a socket handed over by a parent sink during an upgrade, then one
+// passed by systemd socket activation, and binds BindAddr itself otherwise.
+func (s *SinkServer) listen() (net.Listener, error) {
+	lis, err := listener.Inherited()
+	if err != nil {
+		return nil, err
+	}
+	if lis != nil {
+		log.Printf("Using socket inherited from parent process: %s", lis.Addr())
+		return lis, nil
+	}
+
+	lis, err = listener.Activated()
+	if err != nil {
+		return nil, err
+	}
+	if lis != nil {
+		log.Printf("Using socket from systemd activation: %s", lis.Addr())
+		return lis, nil
+	}
+
+	return listener.Listen(s.config.BindAddr, s.config.UnixSocketMode, s.config.IPv6Only)
+}
+
+func (s *SinkServer) loadTLSConfig() (*tls.Config, error) {
 	serverCert, err := tls.LoadX509KeyPair(s.config.CertFile, s.config.KeyFile)
 	if err != nil {
 		return nil, fmt.Errorf("load server certificates: %w", err)
@@ -140,161 +630,1071 @@ func (s *SinkServer) loadTLSCredentials() (credentials.TransportCredentials, err
 		tlsConfig.ClientCAs = caCertPool
 	}
 
-	return credentials.NewTLS(tlsConfig), nil
+	return tlsConfig, nil
 }
 
+// SendSensorData stores a reading. Its errors carry the reading's request ID, which
+// is logged with it, in a RequestInfo detail.
 func (s *SinkServer) SendSensorData(ctx context.Context, req *pb.SensorData) (*pb.SensorDataResponse, error) {
-	err := s.validateClientCertificateIfMTLS(ctx)
+	requestID := readingRequestID(ctx, req)
+	resp, err := s.receiveSensorData(ctx, req, requestID)
 	if err != nil {
-		log.Printf("Client certificate validation failed: %v", err)
-		return nil, status.Errorf(codes.Unauthenticated, "invalid client certificate: %v", err)
+		if requestID != "" {
+			log.Printf("Rejected data from %s (request %s): %v", req.SensorName, requestID, status.Convert(err).Message())
+		}
+		return nil, withRequestID(err, requestID)
 	}
+	return resp, nil
+}
 
-	data, err := proto.Marshal(req)
+func (s *SinkServer) receiveSensorData(ctx context.Context, req *pb.SensorData, requestID string) (*pb.SensorDataResponse, error) {
+	received := time.Now()
+	defer func() { metrics.ReceiveDuration.Observe(time.Since(received).Seconds()) }()
+
+	tenant := tenantFromContext(ctx)
+
+	id, rule, err := s.authenticate(ctx, tenant, req.SensorName)
 	if err != nil {
-		log.Printf("marshal req: %v", err)
-		return nil, status.Errorf(codes.Internal, "marshal data: %v", err)
+		return nil, err
+	}
+
+	in := &incoming{
+		msg:        req,
+		sensorName: req.SensorName,
+		tenant:     tenant,
+		identity:   id.String(),
+		rule:       rule,
+		critical:   isCritical(req),
+		forget:     req.Delivery == pb.Delivery_DELIVERY_FIRE_AND_FORGET,
+		attachment: len(req.Attachment),
+		requestID:  requestID,
 	}
 
-	if !s.rateLimiter.Allow(len(data)) {
-		log.Printf("rate limit exceeded, dropping message from %s", req.SensorName)
-		return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded")
+	if err := s.validateRequest(req); err != nil {
+		log.Printf("invalid reading from %s: %v", in.identity, err)
+		s.recordRejection(in, deadletter.ReasonInvalid, status.Convert(err).Message())
+		return nil, err
 	}
 
-	logEntry := map[string]interface{}{
-		"timestamp":    time.Now().UTC(),
-		"sensor_name":  req.SensorName,
-		"sensor_value": req.SensorValue,
-		"data_time":    req.Timestamp.AsTime().UTC(),
+	if err := s.admit(ctx, in); err != nil {
+		return nil, err
+	}
+
+	entry := &processor.Entry{
+		Timestamp:   s.now(),
+		SensorName:  req.SensorName,
+		SensorValue: float64(req.SensorValue),
+		Sealed:      req.SealedPayload,
+		DataTime:    req.Timestamp.AsTime().UTC(),
+		Critical:    in.critical,
+		Tags:        req.Tags,
+		Tenant:      tenant,
+		Aggregate:   aggregateFromProto(req.Aggregate),
+		Location:    locationFromProto(req.Location),
+		Attachment:  req.Attachment,
+	}
+	if req.Unit != "" {
+		entry.SetTag(processor.UnitTag, req.Unit)
 	}
 
-	logData, err := json.Marshal(logEntry)
+	// Before a skewed device time is rewritten.
+	if s.config.MaxIngestLatency > 0 && req.Timestamp != nil {
+		s.checkIngestLatency(entry)
+	}
+	if s.config.MaxClockSkew > 0 {
+		s.checkClockSkew(entry)
+	}
+
+	stored, err := s.store(ctx, in, s.splitValues(entry, req))
 	if err != nil {
-		log.Printf("failed to marshal log entry: %v", err)
-		return nil, status.Errorf(codes.Internal, "failed to marshal log entry: %v", err)
+		return nil, err
+	}
+	if !stored {
+		// A filtered reading still arrived, so its number isn't missing.
+		s.sensors.Sequence(tenant, req.SensorName, req.Sequence, entry.Timestamp)
+		return &pb.SensorDataResponse{
+			Message:    "Filtered",
+			Draining:   s.draining.Load(),
+			ReceivedAt: timestamppb.New(entry.Timestamp),
+			Throttle:   s.throttleHint(),
+			Redirect:   s.redirectTo(),
+		}, nil
 	}
 
-	if s.encryptor != nil {
-		encryptedData, err := s.encryptor.Encrypt(logData)
+	metrics.EntriesReceived.Inc()
+	metrics.AttachmentBytes.Add(float64(len(req.Attachment)))
+	var deviceTime time.Time
+	if req.Timestamp != nil {
+		deviceTime = req.Timestamp.AsTime()
+	}
+	sequence := s.sensors.Reading(tenant, req.SensorName, entry.Timestamp, deviceTime)
+	s.sensors.Sequence(tenant, req.SensorName, req.Sequence, entry.Timestamp)
+
+	from := req.SensorName
+	if requestID != "" {
+		from += " (request " + requestID + ")"
+	}
+	if entry.Sealed != nil {
+		log.Printf("Received sealed data from %s", from)
+	} else if len(req.Values) > 0 {
+		log.Printf("Received data from %s: %d values", from, len(req.Values))
+	} else if req.Aggregate != nil {
+		log.Printf("Received data from %s: aggregate of %d samples", from, req.Aggregate.Count)
+	} else {
+		log.Printf("Received data from %s: value=%d", from, req.SensorValue)
+	}
+
+	return &pb.SensorDataResponse{
+		Message:    "Received successfully",
+		Draining:   s.draining.Load(),
+		ReceivedAt: timestamppb.New(entry.Timestamp),
+		Sequence:   sequence,
+		Throttle:   s.throttleHint(),
+		Redirect:   s.redirectTo(),
+	}, nil
+}
+
+// throttleHint returns the delay to suggest to a node before its next reading: none
+// while the write queue is empty, MaxThrottleHint once it is full or the watchdog
+// sheds load, and in proportion to the queued buffers in between.
+func (s *SinkServer) throttleHint() *durationpb.Duration {
+	if s.config.MaxThrottleHint <= 0 {
+		return nil
+	}
+	if s.overloaded.Load() != nil {
+		return durationpb.New(s.config.MaxThrottleHint)
+	}
+
+	queued := s.writer.QueueLen()
+	if queued == 0 {
+		return nil
+	}
+	fill := min(float64(queued)/float64(max(s.config.WriteQueueSize, 1)), 1)
+	return durationpb.New(time.Duration(fill * float64(s.config.MaxThrottleHint)))
+}
+
+// incoming is a validated reading or event on its way to storage.
+type incoming struct {
+	msg        proto.Message // as received, for dead letters
+	sensorName string
+	tenant     string
+	identity   string
+	rule       *authz.Rule // nil when no policy is configured
+	critical   bool
+	forget     bool   // fire-and-forget, never waiting for the writer
+	attachment int    // bytes of attachment, charged to the attachment rate limit
+	requestID  string // of a reading, empty when the client sent none
+}
+
+// admit applies load shedding, rate limits and quotas to a message.
+func (s *SinkServer) admit(ctx context.Context, in *incoming) error {
+	// A client that gave up must not use up rate limits or buffer space.
+	if err := canceled(ctx, "rate_limit"); err != nil {
+		return err
+	}
+
+	if !s.isLeader() {
+		return s.reject(in, deadletter.ReasonUnavailable, s.notLeader())
+	}
+
+	if reason := s.overloaded.Load(); reason != nil && !in.critical {
+		return s.reject(in, deadletter.ReasonUnavailable, errdefs.Errorf(errdefs.ErrOverloaded, "sink overloaded: %s", *reason))
+	}
+
+	size := proto.Size(in.msg)
+	defer s.noteQuota(ctx, in, size)
+
+	start := time.Now()
+	// With their own limit, attachments are charged to it instead of the sink-wide
+	// rate, so occasional large ones don't crowd out readings. Quotas below still
+	// count the whole message.
+	rateSize := size
+	if in.attachment > 0 && s.attachmentLimiter != nil {
+		if !s.attachmentLimiter.Allow(in.attachment) {
+			log.Printf("attachment rate limit exceeded, dropping message from %s", in.sensorName)
+			return s.reject(in, deadletter.ReasonRateLimit, errdefs.Errorf(errdefs.ErrRateLimited, "attachment rate limit exceeded"))
+		}
+		rateSize -= in.attachment
+	}
+	if !s.rateLimiter.Allow(in.critical, rateSize) {
+		log.Printf("rate limit exceeded, dropping message from %s", in.sensorName)
+		return s.reject(in, deadletter.ReasonRateLimit, errdefs.Errorf(errdefs.ErrRateLimited, "rate limit exceeded"))
+	}
+
+	if in.rule != nil && !in.rule.AllowRate(ctx, in.identity, size) {
+		log.Printf("client rate limit exceeded, dropping message from %s (rule %s)", in.sensorName, in.rule.Name)
+		return s.reject(in, deadletter.ReasonRateLimit, errdefs.Errorf(errdefs.ErrRateLimited, "client rate limit exceeded"))
+	}
+
+	if s.tenantLimiter != nil && !s.tenantLimiter.Allow(ctx, in.tenant, size) {
+		log.Printf("tenant quota exceeded, dropping message from %s (tenant %s)", in.sensorName, in.tenant)
+		return s.reject(in, deadletter.ReasonQuota, errdefs.Errorf(errdefs.ErrQuotaExceeded, "tenant quota exceeded"))
+	}
+	if s.sensorLimiter != nil && !s.sensorLimiter.Allow(ctx, in.tenant+"/"+in.sensorName, size) {
+		log.Printf("sensor quota exceeded, dropping message from %s (tenant %s)", in.sensorName, in.tenant)
+		return s.reject(in, deadletter.ReasonQuota, errdefs.Errorf(errdefs.ErrQuotaExceeded, "sensor quota exceeded"))
+	}
+	observeStage("rate_limit", start)
+
+	return nil
+}
+
+// bufferCount counts the entries in the buffer.
+type bufferCount struct {
+	entries  int
+	critical int
+	oldest   time.Time // when the oldest entry was buffered, zero while empty
+}
+
+// add counts entries buffered at now.
+func (c *bufferCount) add(entries int, critical bool, now time.Time) {
+	c.entries += entries
+	if critical {
+		c.critical += entries
+	}
+	if c.oldest.IsZero() {
+		c.oldest = now
+	}
+}
+
+// store runs a message's entries through the pipeline and appends them to the
+// buffer. It returns false when the pipeline or the overload policy dropped every
+// entry.
+func (s *SinkServer) store(ctx context.Context, in *incoming, entries []*processor.Entry) (bool, error) {
+	var err error
+
+	if pipeline := s.pipeline.Load(); pipeline != nil {
+		if err := canceled(ctx, "process"); err != nil {
+			return false, err
+		}
+
+		start := time.Now()
+		processed := entries[:0]
+		for _, entry := range entries {
+			// Stages may edit tags in place, so they get a copy of the request's map.
+			entry.Tags = maps.Clone(entry.Tags)
+
+			entry, err = pipeline.Process(ctx, entry)
+			if errors.Is(err, processor.ErrInvalid) {
+				observeStage("process", start)
+				return false, s.reject(in, deadletter.ReasonInvalid, err)
+			}
+			if err != nil {
+				observeStage("process", start)
+				log.Printf("failed to process entry from %s: %v", in.sensorName, err)
+				return false, s.reject(in, deadletter.ReasonProcessing, errdefs.Errorf(errdefs.ErrProcessing, "failed to process entry: %w", err))
+			}
+			if entry != nil {
+				processed = append(processed, entry)
+			}
+		}
+		observeStage("process", start)
+
+		entries = processed
+		if len(entries) == 0 {
+			return false, nil
+		}
+	}
+
+	if err := canceled(ctx, "encode"); err != nil {
+		return false, err
+	}
+
+	entryBuf := getEntryBuffer()
+	defer putEntryBuffer(entryBuf)
+
+	var encryptBuf *[]byte
+	if s.encryptor != nil && !segmentEncryption(s.config) {
+		encryptBuf = getEntryBuffer()
+		defer putEntryBuffer(encryptBuf)
+	}
+
+	if err := s.storeAttachments(in, entries); err != nil {
+		return false, err
+	}
+
+	// The primary output stores the entries its routes select; the fan-out outputs get
+	// copies of those theirs select.
+	routes := s.routes.Load()
+	stored := routes.Select(s.config.Output, entries)
+
+	logData := *entryBuf
+	for _, entry := range stored {
+		line := len(logData)
+
+		start := time.Now()
+		logData, err = s.appendStored(logData, entry)
+		observeStage("encode", start)
+		if err != nil {
+			// Values a processing stage turned into NaN or infinity can't be stored.
+			log.Printf("failed to marshal log entry: %v", err)
+			return false, s.reject(in, deadletter.ReasonInvalid, errdefs.Errorf(errdefs.ErrInvalid, "failed to marshal log entry: %w", err))
+		}
+
+		if encryptBuf == nil {
+			logData = append(logData, '\n')
+			continue
+		}
+
+		start = time.Now()
+		logData, err = s.sealEntry(logData, line, encryptBuf)
+		observeStage("encrypt", start)
 		if err != nil {
 			log.Printf("failed to encrypt log data: %v", err)
-			return nil, status.Errorf(codes.Internal, "failed to encrypt log data: %v", err)
+			return false, errdefs.Errorf(errdefs.ErrEncryption, "failed to encrypt log data: %w", err)
 		}
+	}
+	*entryBuf = logData
 
-		logData = []byte(base64.StdEncoding.EncodeToString(encryptedData))
+	var replicaData []byte
+	if s.replicator != nil && len(stored) > 0 {
+		if replicaData, err = s.replicaData(stored, logData); err != nil {
+			log.Printf("failed to marshal log entry: %v", err)
+			return false, s.reject(in, deadletter.ReasonInvalid, errdefs.Errorf(errdefs.ErrInvalid, "failed to marshal log entry: %w", err))
+		}
 	}
 
-	logData = append(logData, '\n')
+	var copies []fanOutCopy
+	if len(s.fanOut) > 0 {
+		start := time.Now()
+		copies, err = s.fanOutData(routes, entries, stored, logData)
+		observeStage("encode", start)
+		if err != nil {
+			log.Printf("failed to marshal log entry: %v", err)
+			return false, s.reject(in, deadletter.ReasonInvalid, errdefs.Errorf(errdefs.ErrInvalid, "failed to marshal log entry: %w", err))
+		}
+	}
 
+	start := time.Now()
 	s.bufferMutex.Lock()
 
-	if len(s.buffer)+len(logData) > s.config.BufferSize {
+	// Waiting for the lock may have outlasted the client.
+	if err := canceled(ctx, "buffer"); err != nil {
+		s.bufferMutex.Unlock()
+		return false, err
+	}
+
+	if len(stored) > 0 && len(s.buffer)+len(logData) > s.config.BufferSize {
 		log.Printf("flushing buffer due to size limit, max size: %d bytes", s.config.BufferSize)
 		if err := s.flushBuffer(); err != nil {
+			// Critical entries queue up behind the full writer instead of being
+			// rejected, up to a bounded amount of extra memory.
+			if in.critical && len(s.buffer)+len(logData) <= criticalBufferHeadroom*s.config.BufferSize {
+				log.Printf("failed to flush buffer, holding critical entry from %s: %v", in.sensorName, err)
+			} else if err := s.waitForWriter(ctx, in); err != nil {
+				kept, err := s.overflow(in, len(stored), len(logData), err)
+				if !kept {
+					s.bufferMutex.Unlock()
+					return false, err
+				}
+			}
+		}
+	}
+
+	var seq uint64 // of the batch the standby must apply, 0 when not waiting for it
+	if replicaData != nil {
+		if seq, err = s.replicate(in, replicaData, len(stored)); err != nil {
 			s.bufferMutex.Unlock()
-			log.Printf("failed to flush buffer: %v", err)
-			return nil, status.Errorf(codes.Internal, "flush buffer: %v", err)
+			return false, err
+		}
+		if s.config.ReplicaAck != config.ReplicaAckBoth {
+			seq = 0
 		}
 	}
 
 	s.buffer = append(s.buffer, logData...)
+	s.buffered.add(len(stored), in.critical, s.now())
+	s.noteBuffered(len(logData))
+	for i, f := range s.fanOut {
+		if copies[i].entries > 0 {
+			f.append(copies[i].data, copies[i].entries, s.config.BufferSize)
+		}
+	}
 	s.bufferMutex.Unlock()
+	observeStage("buffer", start)
 
-	log.Printf("Received data from %s: value=%d", req.SensorName, req.SensorValue)
+	if seq > 0 {
+		start := time.Now()
+		err := s.replicator.wait(ctx, seq)
+		observeStage("replicate", start)
+		if err != nil {
+			if err := canceled(ctx, "replicate"); err != nil {
+				return false, err
+			}
+			// The entries are buffered and journaled; the client's retry may duplicate them.
+			return false, errdefs.Errorf(errdefs.ErrStorage, "replicate: %w", err)
+		}
+	}
 
-	return &pb.SensorDataResponse{
-		Message: "Received successfully",
-	}, nil
+	return true, nil
 }
 
-func (s *SinkServer) validateClientCertificateIfMTLS(ctx context.Context) error {
-	if !s.config.UseTLS || s.config.CAFile == "" {
+// sealEntry encrypts the entry logData holds from line on and encodes it as the log
+// stores encrypted entries, using scratch for the ciphertext.
+func (s *SinkServer) sealEntry(logData []byte, line int, scratch *[]byte) ([]byte, error) {
+	encryptedData, err := s.encryptor.EncryptAppend((*scratch)[:0], logData[line:])
+	if err != nil {
+		return logData, err
+	}
+	*scratch = encryptedData
+
+	if binaryLog(s.config) {
+		return logformat.AppendRecord(logData[:line], logformat.FlagEncrypted, encryptedData), nil
+	}
+	logData = base64.StdEncoding.AppendEncode(logData[:line], encryptedData)
+	return append(logData, '\n'), nil
+}
+
+// splitValues returns the entries a reading is stored as. A reading with several
+// named values becomes one entry per value, in name order, or a single entry holding
+// all of them with MultiValueCombined. Sealed values can't be told apart, so sealed
+// readings are always stored as one entry.
+func (s *SinkServer) splitValues(entry *processor.Entry, req *pb.SensorData) []*processor.Entry {
+	if len(req.Values) == 0 || entry.Sealed != nil {
+		return []*processor.Entry{entry}
+	}
+	if s.config.MultiValueMode == config.MultiValueCombined {
+		entry.Values = req.Values
+		return []*processor.Entry{entry}
+	}
+
+	entries := make([]*processor.Entry, 0, len(req.Values))
+	for _, metric := range slices.Sorted(maps.Keys(req.Values)) {
+		split := *entry
+		split.Metric = metric
+		split.SensorValue = req.Values[metric]
+		entries = append(entries, &split)
+	}
+	return entries
+}
+
+// aggregateFromProto converts a reading's aggregate, nil when it has none.
+func aggregateFromProto(a *pb.Aggregate) *processor.Aggregate {
+	if a == nil {
 		return nil
 	}
 
-	peer, ok := peer.FromContext(ctx)
-	if !ok {
-		return fmt.Errorf("get peer from context")
+	aggregate := &processor.Aggregate{Count: a.Count, Sum: a.Sum}
+	if len(a.Buckets) > 0 {
+		aggregate.Buckets = make([]processor.Bucket, len(a.Buckets))
+		for i, b := range a.Buckets {
+			aggregate.Buckets[i] = processor.Bucket{UpperBound: b.UpperBound, Count: b.Count}
+		}
 	}
+	return aggregate
+}
+
+// locationFromProto converts a reading's location, nil when it has none.
+func locationFromProto(l *pb.Location) *processor.Location {
+	if l == nil {
+		return nil
+	}
+	return &processor.Location{Latitude: l.Latitude, Longitude: l.Longitude, Altitude: l.Altitude}
+}
+
+// authenticate establishes the client's identity and checks that the client may
+// report for the tenant and sensor. The identity is nil when clients aren't
+// authenticated, the returned rule when no policy is configured.
+func (s *SinkServer) authenticate(ctx context.Context, tenant, sensorName string) (*auth.Identity, *authz.Rule, error) {
+	id, err := s.identify(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rule *authz.Rule
+	if policy := s.policy.Load(); policy != nil {
+		rule, err = authorize(policy, id, tenant, sensorName)
+		if err != nil {
+			log.Printf("Authorization failed for %s: %v", id, err)
+			s.auditEvent(ctx, audit.EventAuthFailure, id.String(), err.Error())
+			return nil, nil, status.Errorf(codes.PermissionDenied, "%v", err)
+		}
+	}
+
+	return id, rule, nil
+}
+
+// checkClockSkew flags entries whose device time is further than MaxClockSkew from
+// the receive time, and with the rewrite action replaces the device time with it.
+func (s *SinkServer) checkClockSkew(entry *processor.Entry) {
+	skew := entry.Timestamp.Sub(entry.DataTime)
+	if skew <= s.config.MaxClockSkew && skew >= -s.config.MaxClockSkew {
+		return
+	}
+
+	// The tag map still belongs to the request.
+	entry.Tags = maps.Clone(entry.Tags)
+	entry.SetTag(clockSkewTag, strconv.FormatFloat(skew.Seconds(), 'f', 3, 64))
+
+	if s.config.ClockSkewAction == config.ClockSkewRewrite {
+		entry.SetTag(deviceTimeTag, entry.DataTime.Format(time.RFC3339Nano))
+		entry.DataTime = entry.Timestamp
+	}
+}
 
-	tlsInfo, ok := peer.AuthInfo.(credentials.TLSInfo)
+// checkIngestLatency flags entries received more than MaxIngestLatency after their
+// device time, with the latency in seconds. A device clock running behind looks like
+// latency as well.
+func (s *SinkServer) checkIngestLatency(entry *processor.Entry) {
+	latency := entry.Timestamp.Sub(entry.DataTime)
+	if latency <= s.config.MaxIngestLatency {
+		return
+	}
+
+	// The tag map still belongs to the request.
+	entry.Tags = maps.Clone(entry.Tags)
+	entry.SetTag(ingestLatencyTag, strconv.FormatFloat(latency.Seconds(), 'f', 3, 64))
+}
+
+// reject records a rejected message in the dead-letter file, if one is configured,
+// and returns err, whose errdefs kind gives the client its status code.
+func (s *SinkServer) reject(in *incoming, reason string, err error) error {
+	s.recordRejection(in, reason, err.Error())
+	return err
+}
+
+// recordRejection counts a rejected message and writes it to the dead-letter file.
+func (s *SinkServer) recordRejection(in *incoming, reason, detail string) {
+	metrics.EntriesRejected.WithLabelValues(reason).Inc()
+	if s.deadLetter != nil {
+		s.writeDeadLetter(in, reason, detail)
+	}
+}
+
+func (s *SinkServer) writeDeadLetter(in *incoming, reason, detail string) {
+	payload, err := proto.Marshal(in.msg)
+	if err != nil {
+		log.Printf("failed to marshal dead letter: %v", err)
+		return
+	}
+
+	// Dead letters hold the same data as the log, so they get the same protection.
+	if s.encryptor != nil {
+		if payload, err = s.encryptor.Encrypt(payload); err != nil {
+			log.Printf("failed to encrypt dead letter: %v", err)
+			return
+		}
+	}
+
+	var recordType string
+	if _, ok := in.msg.(*pb.Event); ok {
+		recordType = deadletter.TypeEvent
+	}
+
+	s.deadLetter.Write(deadletter.Record{
+		Time:      s.now(),
+		Reason:    reason,
+		Detail:    detail,
+		Tenant:    in.tenant,
+		Identity:  in.identity,
+		RequestID: in.requestID,
+		Type:      recordType,
+		Payload:   payload,
+		Encrypted: s.encryptor != nil,
+	})
+}
+
+// isCritical reports whether a reading asked for critical admission, either with the
+// priority field or a priority=critical tag.
+func isCritical(req *pb.SensorData) bool {
+	return req.Priority == pb.Priority_PRIORITY_CRITICAL || req.Tags[priorityTag] == "critical"
+}
+
+// tenantFromContext returns the tenant declared by the client in request metadata.
+func tenantFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return fmt.Errorf("get TLS info")
+		return defaultTenant
+	}
+	if values := md.Get(tenantMetadataKey); len(values) > 0 && values[0] != "" {
+		return values[0]
+	}
+	return defaultTenant
+}
+
+// authorize checks the request against the authorization policy rule matching the
+// client.
+func authorize(policy *authz.Policy, id *auth.Identity, tenant, sensorName string) (*authz.Rule, error) {
+	identity := id.String()
+
+	var cert *x509.Certificate
+	if id != nil {
+		cert = id.Cert
+	}
+	rule := policy.RuleFor(identity, cert)
+	if rule == nil {
+		return nil, fmt.Errorf("no authorization rule matches client %s", identity)
 	}
+	if !rule.AllowsSensor(sensorName) {
+		return nil, fmt.Errorf("client %s is not authorized for sensor %s", identity, sensorName)
+	}
+	if !rule.AllowsTenant(tenant) {
+		return nil, fmt.Errorf("client %s is not authorized for tenant %s", identity, tenant)
+	}
+	return rule, nil
+}
+
+// segmentEncryption reports whether the writer encrypts whole segments, so entries
+// are buffered in plain text.
+func segmentEncryption(cfg config.Config) bool {
+	return cfg.EnableEncryption && cfg.EncryptionMode == config.EncryptionModeSegment
+}
+
+func binaryLog(cfg config.Config) bool {
+	return cfg.LogFormat == config.LogFormatBinary
+}
 
-	if len(tlsInfo.State.PeerCertificates) == 0 {
-		return fmt.Errorf("no client certificate provided")
+// logOutput reports whether entries are stored as the log's JSON lines or binary
+// records, which may be encrypted. The other outputs need them in the clear.
+func logOutput(cfg config.Config) bool {
+	return cfg.Output == "" || cfg.Output == config.OutputLog
+}
+
+func influxOutput(cfg config.Config) bool {
+	return cfg.Output == config.OutputInflux
+}
+
+// appendEntry appends entry as JSON in the default format, or as a line of line
+// protocol with influx.
+func (s *SinkServer) appendEntry(dst []byte, entry *processor.Entry, influx bool) ([]byte, error) {
+	if influx {
+		return output.AppendInfluxLine(dst, s.config.InfluxMeasurement, entry)
 	}
+	return (*logEntry)(entry).appendJSON(dst, defaultEntryFormat)
+}
 
-	clientCert := tlsInfo.State.PeerCertificates[0]
-	log.Printf(
-		"Client authenticated with certificate: Subject=%s, Issuer=%s",
-		clientCert.Subject,
-		clientCert.Issuer,
-	)
+// appendStored appends entry as the primary output stores it: the log in its
+// entryFormat, the other outputs as appendEntry encodes for them.
+func (s *SinkServer) appendStored(dst []byte, entry *processor.Entry) ([]byte, error) {
+	if logOutput(s.config) {
+		return (*logEntry)(entry).appendJSON(dst, s.entryFormat)
+	}
+	return s.appendEntry(dst, entry, influxOutput(s.config))
+}
 
-	return nil
+// openOutput opens the named output as the destination of a writer. Only the primary
+// output gets the encoder and spill.
+func openOutput(cfg config.Config, name string, encoder storage.Encoder, spill *storage.Spill) (*storage.FileWriter, error) {
+	var dest storage.Destination
+	switch {
+	case name == config.OutputInflux && cfg.InfluxURL != "":
+		dest = output.NewInfluxHTTP(cfg.InfluxURL, cfg.InfluxToken, cfg.InfluxTimeout, cfg.InfluxRetries)
+	case name == config.OutputPostgres:
+		postgres, err := output.OpenPostgres(context.Background(), cfg.PostgresURL, cfg.PostgresTable, int32(cfg.PostgresMaxConns), cfg.PostgresTimeout, cfg.PostgresRetries)
+		if err != nil {
+			return nil, err
+		}
+		dest = postgres
+	case name == config.OutputClickHouse:
+		clickhouse, err := output.OpenClickHouse(context.Background(), output.ClickHouseOptions{
+			URL:                cfg.ClickHouseURL,
+			User:               cfg.ClickHouseUser,
+			Password:           cfg.ClickHousePassword,
+			Table:              cfg.ClickHouseTable,
+			AsyncInsert:        cfg.ClickHouseAsyncInsert,
+			WaitForAsyncInsert: cfg.ClickHouseWaitForAsyncInsert,
+			Timeout:            cfg.ClickHouseTimeout,
+			Retries:            cfg.ClickHouseRetries,
+		})
+		if err != nil {
+			return nil, err
+		}
+		dest = clickhouse
+	case name == config.OutputNATS:
+		nc, err := output.DialNATS(cfg.NATSURL, cfg.NATSCredsFile, "sink")
+		if err != nil {
+			return nil, err
+		}
+		nats, err := output.NewNATS(nc, cfg.NATSSubject, cfg.NATSTimeout, cfg.NATSRetries)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+		dest = nats
+	case name == config.OutputRemoteWrite:
+		remoteWrite, err := output.OpenRemoteWrite(output.RemoteWriteOptions{
+			URL:      cfg.RemoteWriteURL,
+			Token:    cfg.RemoteWriteToken,
+			Tenant:   cfg.RemoteWriteTenant,
+			Name:     cfg.RemoteWriteName,
+			WALDir:   cfg.RemoteWriteWALDir,
+			WALQuota: cfg.RemoteWriteWALQuota,
+			Timeout:  cfg.RemoteWriteTimeout,
+		})
+		if err != nil {
+			return nil, err
+		}
+		dest = remoteWrite
+	case name == config.OutputLog && cfg.LogSegmentSize > 0:
+		segmented, err := storage.OpenSegmentedFile(cfg.LogFilePath, cfg.LogSegmentSize)
+		if err != nil {
+			return nil, err
+		}
+		dest = segmented
+	default:
+		return storage.NewFileWriter(cfg.LogFilePath, cfg.BufferSize, cfg.WriteQueueSize, encoder, spill)
+	}
+	return storage.NewWriter(dest, cfg.BufferSize, cfg.WriteQueueSize, encoder, spill), nil
 }
 
+// now returns the current time in UTC from the configured clock.
+func (s *SinkServer) now() time.Time {
+	return s.config.Clock.Now().UTC()
+}
+
+// mtlsEnabled reports whether clients must present a verified certificate.
+func mtlsEnabled(config config.Config) bool {
+	return (config.UseTLS && config.CAFile != "") || config.SpiffeSocket != ""
+}
+
+// flushTimer flushes the buffers every FlushInterval until Stop is called.
 func (s *SinkServer) flushTimer() {
-	defer s.wg.Done()
-	ticker := time.NewTicker(s.config.FlushInterval)
+	ticker := s.config.Clock.NewTicker(s.config.FlushInterval)
 	defer ticker.Stop()
 
+	var aged, windows <-chan time.Time
+	if s.config.MaxBufferAge > 0 {
+		ageTicker := s.config.Clock.NewTicker(s.config.MaxBufferAge / bufferAgeChecks)
+		defer ageTicker.Stop()
+		aged = ageTicker.C()
+	}
+	if s.adaptiveFlush() {
+		windowTicker := s.config.Clock.NewTicker(adaptiveWindow)
+		defer windowTicker.Stop()
+		windows = windowTicker.C()
+	}
+
 	for {
 		select {
-		case <-ticker.C:
-			s.bufferMutex.Lock()
-			if len(s.buffer) > 0 {
-				log.Println("Flushing buffer by timer")
-				if err := s.flushBuffer(); err != nil {
-					log.Printf("Failed to flush buffer: %v", err)
-				}
-			}
-			s.bufferMutex.Unlock()
+		case <-ticker.C():
+			s.flushByTimer()
+		case <-aged:
+			s.flushAged()
+		case <-windows:
+			s.flushOnRateDrop()
 		case <-s.done:
 			return
 		}
 	}
 }
 
+// flushAged flushes the buffers when the oldest buffered entry would be older than
+// MaxBufferAge at the next check.
+func (s *SinkServer) flushAged() {
+	s.bufferMutex.Lock()
+	defer s.bufferMutex.Unlock()
+
+	oldest := s.buffered.oldest
+	if oldest.IsZero() || s.now().Sub(oldest) < s.config.MaxBufferAge-s.config.MaxBufferAge/bufferAgeChecks {
+		return
+	}
+	log.Printf("Flushing buffer by age, oldest entry buffered %v ago", s.now().Sub(oldest).Round(time.Millisecond))
+	if err := s.flushBuffer(); err != nil {
+		log.Printf("Failed to flush buffer: %v", err)
+	}
+	for _, f := range s.fanOut {
+		f.flush()
+	}
+}
+
+func (s *SinkServer) flushByTimer() {
+	// Deferred, so a panic doesn't leave the buffer locked.
+	s.bufferMutex.Lock()
+	defer s.bufferMutex.Unlock()
+
+	if len(s.buffer) > 0 {
+		log.Println("Flushing buffer by timer")
+		if err := s.flushBuffer(); err != nil {
+			log.Printf("Failed to flush buffer: %v", err)
+		}
+	}
+	for _, f := range s.fanOut {
+		f.flush()
+	}
+}
+
+// overflow applies the overload policy to entries of size bytes that found the
+// buffer and the write queue full. It returns true when they may be appended to the
+// buffer, and otherwise the error for the client, nil when they were sampled out.
+// Callers must hold bufferMutex.
+func (s *SinkServer) overflow(in *incoming, entries, size int, cause error) (bool, error) {
+	switch s.config.OverloadPolicy {
+	case config.OverloadDropOldest:
+		// Critical entries held in the buffer are kept; the new ones wait their turn.
+		// So are all entries of a primary: they are in the replication journal
+		// already, and dropping them would leave them in the standby's log only.
+		if s.buffered.critical == 0 && s.replicator == nil {
+			log.Printf("write queue full, dropping %d buffered entries: %v", s.buffered.entries, cause)
+			metrics.EntriesDropped.WithLabelValues(dropOldest).Add(float64(s.buffered.entries))
+			s.buffer = s.buffer[:0]
+			s.buffered = bufferCount{}
+			return true, nil
+		}
+	case config.OverloadSample:
+		// Critical entries past the headroom are rejected rather than silently lost.
+		if in.critical {
+			break
+		}
+		s.sampled++
+		if s.sampled%uint64(max(s.config.OverloadSampleRate, 1)) != 0 {
+			metrics.EntriesDropped.WithLabelValues(dropSampled).Add(float64(entries))
+			return false, nil
+		}
+		if len(s.buffer)+size <= criticalBufferHeadroom*s.config.BufferSize {
+			log.Printf("write queue full, holding sampled entry from %s: %v", in.sensorName, cause)
+			return true, nil
+		}
+	}
+
+	log.Printf("failed to flush buffer: %v", cause)
+	return false, s.reject(in, deadletter.ReasonUnavailable, errdefs.Errorf(errdefs.ErrBufferFull, "flush buffer: %w", cause))
+}
+
+// flushBuffer hands the current buffer to the writer goroutine and continues with an
+// empty one. It never waits for the disk: if the writer is too far behind it returns
+// storage.ErrQueueFull and the buffer is kept. Callers must hold bufferMutex.
 func (s *SinkServer) flushBuffer() error {
 	if len(s.buffer) == 0 {
 		return nil
 	}
 
-	_, err := s.fileWriter.Write(s.buffer)
-	if err != nil {
+	if err := s.writer.TryEnqueue(s.buffer); err != nil {
 		return err
 	}
+	s.buffer = s.writer.NewBuffer()
+	s.buffered = bufferCount{}
+
+	return nil
+}
 
-	if err := s.fileWriter.Flush(); err != nil {
+// FlushNow hands the current buffer to the writer, waits until everything queued is
+// on disk and syncs the log file, so the latest entries can be inspected without
+// waiting for the flush interval. Fan-out outputs are flushed and synced as well.
+func (s *SinkServer) FlushNow() error {
+	s.bufferMutex.Lock()
+	if s.buffer == nil {
+		s.bufferMutex.Unlock()
+		return fmt.Errorf("sink is closed")
+	}
+	used, capacity := len(s.buffer), cap(s.buffer)
+	queued := s.writer.QueueLen()
+	if used > 0 {
+		s.writer.Enqueue(s.buffer)
+		s.buffer = s.writer.NewBuffer()
+		s.buffered = bufferCount{}
+	}
+	for _, f := range s.fanOut {
+		f.flush()
+	}
+	s.bufferMutex.Unlock()
+
+	log.Printf("Flushing on demand: buffer %d/%d bytes, %d buffers already queued, last write error: %v", used, capacity, queued, s.writer.Err())
+	errs := []error{s.writer.Sync()}
+	for _, f := range s.fanOut {
+		if err := f.writer.Sync(); err != nil {
+			errs = append(errs, fmt.Errorf("%s output: %w", f.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// waitForWriter hands the current buffer to a writer whose queue is full, waiting for
+// a free slot for up to maxWriterWait or the request's deadline, whichever is sooner,
+// so a brief disk stall is absorbed instead of failing requests. Requests without a
+// deadline, and fire-and-forget readings whose senders won't resend them, get
+// storage.ErrQueueFull at once. Callers must hold bufferMutex, which is why the wait
+// is short: a longer stall is left to the overload policy.
+func (s *SinkServer) waitForWriter(ctx context.Context, in *incoming) error {
+	if _, ok := ctx.Deadline(); !ok || in.forget {
+		return storage.ErrQueueFull
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, maxWriterWait)
+	defer cancel()
+	if err := s.writer.EnqueueContext(ctx, s.buffer); err != nil {
+		return fmt.Errorf("%w: %v", storage.ErrQueueFull, err)
+	}
+	s.buffer = s.writer.NewBuffer()
+	s.buffered = bufferCount{}
+
+	return nil
+}
+
+// newEncryptor creates the log encryptor from the key file or, without one, the
+// encoded key, clearing the decoded key material it reads.
+func newEncryptor(cfg config.Config) (*encryption.Encryptor, error) {
+	if cfg.EncryptionKeyFile == "" {
+		return encryption.NewEncryptor(cfg.EncryptionCipher, cfg.EncryptionKey)
+	}
+
+	key, err := encryption.LoadKey(cfg.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key)
+
+	return encryption.NewEncryptorFromKey(cfg.EncryptionCipher, key)
+}
+
+// observeStage records the time spent in a stage of handling a reading since start.
+func observeStage(stage string, start time.Time) {
+	metrics.StageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// canceled returns the status for a request whose client canceled it or whose deadline
+// passed, counted by the stage reached, or nil if the request is still wanted.
+func canceled(ctx context.Context, stage string) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+
+	metrics.RequestsCanceled.WithLabelValues(stage).Inc()
+	return status.FromContextError(ctx.Err()).Err()
+}
+
+// Reload re-reads reloadable configuration files: the bearer tokens, the authorization
+// policy, the IP access list, the processing pipeline and the routes. On failure the
+// previous configuration stays in effect.
+func (s *SinkServer) Reload() error {
+	if s.config.AuthTokensFile != "" {
+		if err := s.reloadTokens(); err != nil {
+			return err
+		}
+	}
+	if s.config.AuthzPolicyFile != "" {
+		if err := s.reloadPolicy(); err != nil {
+			return err
+		}
+	}
+	if s.config.IPAccessFile != "" {
+		if err := s.reloadIPAccess(); err != nil {
+			return err
+		}
+	}
+	if s.config.PipelineFile != "" {
+		if err := s.reloadPipeline(); err != nil {
+			return err
+		}
+	}
+	if s.config.RoutesFile != "" {
+		if err := s.reloadRoutes(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SinkServer) reloadPolicy() error {
+	policy, err := authz.LoadPolicy(s.config.AuthzPolicyFile, s.config.Clock)
+	if err != nil {
+		s.auditEvent(context.Background(), audit.EventConfigReload, "", fmt.Sprintf("failed: %v", err))
+		return fmt.Errorf("reload authorization policy: %w", err)
+	}
+
+	s.policy.Store(policy)
+	s.auditEvent(context.Background(), audit.EventConfigReload, "", fmt.Sprintf("authorization policy %s, %d rules", s.config.AuthzPolicyFile, len(policy.Rules)))
+	log.Printf("Authorization policy reloaded with %d rules", len(policy.Rules))
+
+	return nil
+}
+
+func (s *SinkServer) reloadIPAccess() error {
+	ipAccess, err := ipaccess.Load(s.config.IPAccessFile)
+	if err != nil {
+		s.auditEvent(context.Background(), audit.EventConfigReload, "", fmt.Sprintf("failed: %v", err))
+		return fmt.Errorf("reload IP access list: %w", err)
+	}
+
+	s.ipAccess.Store(ipAccess)
+	s.auditEvent(context.Background(), audit.EventConfigReload, "", fmt.Sprintf("IP access list %s, %d allowed and %d denied networks", s.config.IPAccessFile, len(ipAccess.Allow), len(ipAccess.Deny)))
+	log.Printf("IP access list reloaded with %d allowed and %d denied networks", len(ipAccess.Allow), len(ipAccess.Deny))
+
+	return nil
+}
+
+func (s *SinkServer) reloadPipeline() error {
+	pipeline, err := processor.LoadChain(s.config.PipelineFile)
+	if err != nil {
+		s.auditEvent(context.Background(), audit.EventConfigReload, "", fmt.Sprintf("failed: %v", err))
+		return fmt.Errorf("reload processing pipeline: %w", err)
+	}
+
+	if old := s.pipeline.Swap(pipeline); old != nil {
+		// Requests still running on the old chain may call a closed stage, which
+		// stages must tolerate.
+		if err := old.Close(); err != nil {
+			log.Printf("Failed to close previous processing pipeline: %v", err)
+		}
+	}
+	s.auditEvent(context.Background(), audit.EventConfigReload, "", fmt.Sprintf("processing pipeline %s, %d stages", s.config.PipelineFile, pipeline.Len()))
+	log.Printf("Processing pipeline reloaded with %d stages", pipeline.Len())
+
+	return nil
+}
+
+func (s *SinkServer) reloadRoutes() error {
+	routes, err := loadRoutes(s.config)
+	if err != nil {
+		s.auditEvent(context.Background(), audit.EventConfigReload, "", fmt.Sprintf("failed: %v", err))
 		return err
 	}
 
-	s.buffer = s.buffer[:0]
+	s.routes.Store(routes)
+	s.auditEvent(context.Background(), audit.EventConfigReload, "", fmt.Sprintf("routes %s, outputs %v", s.config.RoutesFile, routes.Outputs()))
+	log.Printf("Routes reloaded for outputs %v", routes.Outputs())
 
-	log.Printf("Flushed buffer to log file")
 	return nil
 }
 
+// Stop starts a graceful shutdown. It may be called more than once, e.g. by a signal
+// arriving while Upgrade hands over to a new process.
 func (s *SinkServer) Stop() {
-	close(s.done)
+	s.stopOnce.Do(func() { close(s.done) })
 }
 
 func (s *SinkServer) Close() {
 	s.bufferMutex.Lock()
 	if len(s.buffer) > 0 {
-		if err := s.flushBuffer(); err != nil { // Final flush
-			log.Printf("Failed to flush buffer during shutdown: %v", err)
+		s.writer.Enqueue(s.buffer) // Final flush
+	}
+	for _, f := range s.fanOut {
+		if len(f.buffer) > 0 {
+			f.writer.Enqueue(f.buffer)
 		}
 	}
+	s.buffer = nil // marks the sink closed for FlushNow
 	s.bufferMutex.Unlock()
 
-	if s.fileWriter != nil {
-		s.fileWriter.Flush()
+	if err := s.writer.Close(); err != nil {
+		log.Printf("Failed to close log file during shutdown: %v", err)
+	}
+	for _, f := range s.fanOut {
+		if err := f.writer.Close(); err != nil {
+			log.Printf("Failed to close %s output during shutdown: %v", f.name, err)
+		}
+	}
+	if pipeline := s.pipeline.Load(); pipeline != nil {
+		if err := pipeline.Close(); err != nil {
+			log.Printf("Failed to close processing pipeline: %v", err)
+		}
+	}
+	if s.redisClient != nil {
+		s.redisClient.Close()
+	}
+	if s.x509Source != nil {
+		s.x509Source.Close()
+	}
+	if s.audit != nil {
+		s.audit.Close()
+	}
+	if s.deadLetter != nil {
+		s.deadLetter.Close()
+	}
+	if s.replicator != nil {
+		s.replicator.close()
 	}
-	if s.logFile != nil {
-		s.logFile.Close()
+	if s.election != nil {
+		s.election.Release()
 	}
 }
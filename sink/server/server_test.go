@@ -0,0 +1,2404 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sink/auth"
+	"github.com/sink/backup"
+	"github.com/sink/clock"
+	"github.com/sink/config"
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/errdefs"
+	"github.com/sink/metrics"
+	"github.com/sink/pkg/coap"
+	"github.com/sink/pkg/logformat"
+	"github.com/sink/processor"
+	pb "github.com/sink/proto"
+	"github.com/sink/proto/protoconnect"
+	"github.com/sink/state"
+	"github.com/sink/storage"
+)
+
+func TestLogEntry_AppendJSON_MatchesEncodingJSON(t *testing.T) {
+	now := time.Date(2024, 5, 17, 10, 30, 0, 123456789, time.UTC)
+
+	tests := []struct {
+		name  string
+		entry logEntry
+	}{
+		{
+			name:  "plain",
+			entry: logEntry{Timestamp: now, SensorName: "temperature-01", SensorValue: 42, DataTime: now.Add(-time.Second)},
+		},
+		{
+			name:  "negative value and whole seconds",
+			entry: logEntry{Timestamp: now.Truncate(time.Second), SensorName: "t", SensorValue: -7, DataTime: time.Unix(0, 0).UTC()},
+		},
+		{
+			name:  "characters needing escapes",
+			entry: logEntry{Timestamp: now, SensorName: "a\"b\\c\n\t\r\b\f\x01<tag>&amp;\u2028\u2029", DataTime: now},
+		},
+		{
+			name:  "unicode and invalid utf-8",
+			entry: logEntry{Timestamp: now, SensorName: "température-\xff-датчик", DataTime: now},
+		},
+		{
+			name:  "calibrated value with raw value",
+			entry: logEntry{Timestamp: now, SensorName: "thermistor", SensorValue: 21.637, RawValue: ptr(22.0), DataTime: now},
+		},
+		{
+			name:  "tiny and huge values",
+			entry: logEntry{Timestamp: now, SensorName: "t", SensorValue: 1.5e-9, RawValue: ptr(3e21), DataTime: now},
+		},
+		{
+			name:  "critical with tags",
+			entry: logEntry{Timestamp: now, SensorName: "pressure", DataTime: now, Critical: true, Tags: map[string]string{"zone": "b<2>", "class": "safety"}},
+		},
+		{
+			name:  "sealed payload",
+			entry: logEntry{Timestamp: now, SensorName: "heart-rate", Sealed: []byte{1, 0xfb, 0xff, 0x00, 'x'}, DataTime: now, Tags: map[string]string{"clock_skew": "0.500"}},
+		},
+		{
+			name:  "value split from a multi-value reading",
+			entry: logEntry{Timestamp: now, SensorName: "weather-01", Metric: "humidity", SensorValue: 41.5, DataTime: now},
+		},
+		{
+			name:  "combined multi-value reading",
+			entry: logEntry{Timestamp: now, SensorName: "weather-01", Values: map[string]float64{"temperature": 21.5, "humidity": 41.5, "battery": 3.7}, DataTime: now, Tags: map[string]string{"site": "roof"}},
+		},
+		{
+			name: "histogram",
+			entry: logEntry{Timestamp: now, SensorName: "vibration-01", DataTime: now, Aggregate: &processor.Aggregate{
+				Count: 1000, Sum: 312.5, Buckets: []processor.Bucket{{UpperBound: 0.1, Count: 700}, {UpperBound: 1, Count: 250}, {UpperBound: 3e21, Count: 0}},
+			}},
+		},
+		{
+			name:  "event",
+			entry: logEntry{Timestamp: now, SensorName: "pump-3", DataTime: now, Event: &processor.Event{Severity: "error", Message: "motor <stalled> & tripped"}, Tags: map[string]string{"code": "E42"}},
+		},
+		{
+			name:  "location with altitude",
+			entry: logEntry{Timestamp: now, SensorName: "drone-7", SensorValue: 3, DataTime: now, Location: &processor.Location{Latitude: 52.2297, Longitude: 21.0122, Altitude: ptr(120.5)}},
+		},
+		{
+			name:  "location without altitude",
+			entry: logEntry{Timestamp: now, SensorName: "truck-2", SensorValue: 3, DataTime: now, Location: &processor.Location{Latitude: -33.8688, Longitude: 151.2093}},
+		},
+		{
+			name:  "inline attachment",
+			entry: logEntry{Timestamp: now, SensorName: "spectrometer", SensorValue: 1, DataTime: now, Attachment: []byte{0x00, 0xfb, 0xff, '<'}},
+		},
+		{
+			name:  "stored attachment",
+			entry: logEntry{Timestamp: now, SensorName: "spectrometer", DataTime: now, AttachmentID: "9f86d081884c7d65", Aggregate: &processor.Aggregate{Count: 1, Sum: 2}},
+		},
+		{
+			name:  "summary",
+			entry: logEntry{Timestamp: now, SensorName: "vibration-01", DataTime: now, Critical: true, Aggregate: &processor.Aggregate{Count: 4, Sum: -2.25}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields := map[string]interface{}{
+				"timestamp":    tt.entry.Timestamp,
+				"sensor_name":  tt.entry.SensorName,
+				"sensor_value": tt.entry.SensorValue,
+				"data_time":    tt.entry.DataTime,
+				"schema":       logformat.SchemaVersion,
+			}
+			if tt.entry.Sealed != nil {
+				fields["sealed_payload"] = tt.entry.Sealed
+				delete(fields, "sensor_value")
+			}
+			if tt.entry.Metric != "" {
+				fields["metric"] = tt.entry.Metric
+			}
+			if tt.entry.Values != nil {
+				fields["values"] = tt.entry.Values
+				delete(fields, "sensor_value")
+			}
+			if l := tt.entry.Location; l != nil {
+				location := map[string]float64{"latitude": l.Latitude, "longitude": l.Longitude}
+				if l.Altitude != nil {
+					location["altitude"] = *l.Altitude
+				}
+				fields["location"] = location
+			}
+			if e := tt.entry.Event; e != nil {
+				fields["event"] = map[string]string{"severity": e.Severity, "message": e.Message}
+				fields["type"] = "event"
+				delete(fields, "sensor_value")
+			}
+			if a := tt.entry.Aggregate; a != nil {
+				aggregate := map[string]interface{}{"count": a.Count, "sum": a.Sum}
+				if len(a.Buckets) > 0 {
+					var buckets []map[string]interface{}
+					for _, b := range a.Buckets {
+						buckets = append(buckets, map[string]interface{}{"le": b.UpperBound, "count": b.Count})
+					}
+					aggregate["buckets"] = buckets
+				}
+				fields["aggregate"] = aggregate
+				delete(fields, "sensor_value")
+			}
+			if tt.entry.AttachmentID != "" {
+				fields["attachment_id"] = tt.entry.AttachmentID
+			} else if tt.entry.Attachment != nil {
+				fields["attachment"] = tt.entry.Attachment
+			}
+			if tt.entry.RawValue != nil {
+				fields["raw_value"] = *tt.entry.RawValue
+			}
+			if tt.entry.Critical {
+				fields["priority"] = "critical"
+			}
+			if len(tt.entry.Tags) > 0 {
+				fields["tags"] = tt.entry.Tags
+			}
+
+			expected, err := json.Marshal(fields)
+			if err != nil {
+				t.Fatalf("json.Marshal() error = %v", err)
+			}
+
+			got, err := tt.entry.appendJSON(nil, defaultEntryFormat)
+			if err != nil {
+				t.Fatalf("appendJSON() error = %v", err)
+			}
+
+			if string(got) != string(expected) {
+				t.Errorf("appendJSON() = %s, want %s", got, expected)
+			}
+		})
+	}
+}
+
+func TestLogEntry_AppendJSON_Fields(t *testing.T) {
+	fields, err := logformat.NewFields(map[string]string{"timestamp": "ts", "sensor_name": "name", "sensor_value": "val"}, logformat.TimeUnixMilli)
+	if err != nil {
+		t.Fatalf("NewFields() error = %v", err)
+	}
+	ts := time.Date(2024, 1, 15, 10, 30, 0, 123456789, time.UTC)
+	entry := logEntry{SensorName: "temp-1", SensorValue: 21.5, Timestamp: ts, DataTime: ts.Add(-time.Second), Tags: map[string]string{"site": "a"}}
+
+	got, err := entry.appendJSON(nil, newEntryFormat(fields))
+	if err != nil {
+		t.Fatalf("appendJSON() error = %v", err)
+	}
+	want := `{"data_time":1705314599123,"schema":2,"name":"temp-1","val":21.5,"tags":{"site":"a"},"ts":1705314600123}`
+	if string(got) != want {
+		t.Errorf("appendJSON() = %s, want %s", got, want)
+	}
+	if info, err := fields.Info(got); err != nil || !info.Received.Equal(ts.Truncate(time.Millisecond)) || info.Schema != logformat.SchemaVersion {
+		t.Errorf("Info() = %+v, %v, want received at %v with the current schema", info, err, ts.Truncate(time.Millisecond))
+	}
+}
+
+func TestLogEntry_AppendJSON_RejectsNaN(t *testing.T) {
+	entry := logEntry{Timestamp: time.Now(), DataTime: time.Now(), SensorValue: math.NaN()}
+	if _, err := entry.appendJSON(nil, defaultEntryFormat); err == nil {
+		t.Error("appendJSON() should fail for NaN values")
+	}
+}
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func TestLogEntry_AppendJSON_RejectsOutOfRangeYear(t *testing.T) {
+	entry := logEntry{Timestamp: time.Now(), DataTime: time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if _, err := entry.appendJSON(nil, defaultEntryFormat); err == nil {
+		t.Error("appendJSON() should fail for years encoding/json cannot represent")
+	}
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	now := time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		action       string
+		dataTime     time.Time
+		wantDataTime time.Time
+		wantTags     map[string]string
+	}{
+		{
+			name:         "within bound",
+			action:       config.ClockSkewFlag,
+			dataTime:     now.Add(-30 * time.Second),
+			wantDataTime: now.Add(-30 * time.Second),
+		},
+		{
+			name:         "flagged",
+			action:       config.ClockSkewFlag,
+			dataTime:     now.Add(-90 * time.Second),
+			wantDataTime: now.Add(-90 * time.Second),
+			wantTags:     map[string]string{"clock_skew": "90.000"},
+		},
+		{
+			name:         "rewritten",
+			action:       config.ClockSkewRewrite,
+			dataTime:     now.Add(2 * time.Hour),
+			wantDataTime: now,
+			wantTags:     map[string]string{"clock_skew": "-7200.000", "device_time": "2024-05-17T12:30:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SinkServer{config: config.Config{MaxClockSkew: time.Minute, ClockSkewAction: tt.action}}
+			entry := &processor.Entry{Timestamp: now, DataTime: tt.dataTime}
+
+			s.checkClockSkew(entry)
+
+			if !entry.DataTime.Equal(tt.wantDataTime) {
+				t.Errorf("DataTime = %v, want %v", entry.DataTime, tt.wantDataTime)
+			}
+			if len(entry.Tags) != len(tt.wantTags) {
+				t.Fatalf("Tags = %v, want %v", entry.Tags, tt.wantTags)
+			}
+			for k, v := range tt.wantTags {
+				if entry.Tags[k] != v {
+					t.Errorf("Tags[%q] = %q, want %q", k, entry.Tags[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckIngestLatency(t *testing.T) {
+	now := time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)
+	s := &SinkServer{config: config.Config{MaxIngestLatency: 5 * time.Second}}
+
+	tests := []struct {
+		name     string
+		dataTime time.Time
+		want     string
+	}{
+		{"on time", now.Add(-2 * time.Second), ""},
+		{"device clock ahead", now.Add(time.Minute), ""},
+		{"late", now.Add(-12500 * time.Millisecond), "12.500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &processor.Entry{Timestamp: now, DataTime: tt.dataTime}
+			s.checkIngestLatency(entry)
+			if got := entry.Tags[ingestLatencyTag]; got != tt.want {
+				t.Errorf("Tags[%q] = %q, want %q", ingestLatencyTag, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	s := &SinkServer{config: config.Config{MaxTags: 2, MaxFieldSize: 16, MaxAttachmentSize: 8}}
+	now := timestamppb.Now()
+
+	tests := []struct {
+		name       string
+		req        *pb.SensorData
+		wantFields []string
+	}{
+		{
+			name: "valid",
+			req:  &pb.SensorData{SensorName: "temp", Timestamp: now, Tags: map[string]string{"a": "b"}},
+		},
+		{
+			name:       "missing name and timestamp",
+			req:        &pb.SensorData{},
+			wantFields: []string{"sensor_name", "timestamp"},
+		},
+		{
+			name:       "long sensor name",
+			req:        &pb.SensorData{SensorName: strings.Repeat("x", 17), Timestamp: now},
+			wantFields: []string{"sensor_name"},
+		},
+		{
+			name:       "too many tags",
+			req:        &pb.SensorData{SensorName: "temp", Timestamp: now, Tags: map[string]string{"a": "1", "b": "2", "c": "3"}},
+			wantFields: []string{"tags"},
+		},
+		{
+			name:       "long tag value",
+			req:        &pb.SensorData{SensorName: "temp", Timestamp: now, Tags: map[string]string{"a": strings.Repeat("x", 17)}},
+			wantFields: []string{"tags"},
+		},
+		{
+			name:       "timestamp out of range",
+			req:        &pb.SensorData{SensorName: "temp", Timestamp: &timestamppb.Timestamp{Seconds: 1 << 62}},
+			wantFields: []string{"timestamp"},
+		},
+		{
+			name:       "negative nanos",
+			req:        &pb.SensorData{SensorName: "temp", Timestamp: &timestamppb.Timestamp{Seconds: 1, Nanos: -1}},
+			wantFields: []string{"timestamp"},
+		},
+		{
+			name: "multiple values",
+			req:  &pb.SensorData{SensorName: "weather", Timestamp: now, Values: map[string]float64{"temperature": 21.5, "humidity": 40}},
+		},
+		{
+			name:       "unnamed value",
+			req:        &pb.SensorData{SensorName: "weather", Timestamp: now, Values: map[string]float64{"": 1}},
+			wantFields: []string{"values"},
+		},
+		{
+			name:       "NaN value",
+			req:        &pb.SensorData{SensorName: "weather", Timestamp: now, Values: map[string]float64{"humidity": math.NaN()}},
+			wantFields: []string{"values"},
+		},
+		{
+			name: "histogram",
+			req: &pb.SensorData{SensorName: "vibration", Timestamp: now, Aggregate: &pb.Aggregate{
+				Count: 10, Sum: 4.2, Buckets: []*pb.HistogramBucket{{UpperBound: 0.1, Count: 6}, {UpperBound: 1, Count: 4}},
+			}},
+		},
+		{
+			name: "histogram buckets out of order",
+			req: &pb.SensorData{SensorName: "vibration", Timestamp: now, Aggregate: &pb.Aggregate{
+				Count: 10, Buckets: []*pb.HistogramBucket{{UpperBound: 1, Count: 6}, {UpperBound: 0.1, Count: 4}},
+			}},
+			wantFields: []string{"aggregate"},
+		},
+		{
+			name: "histogram buckets over the count",
+			req: &pb.SensorData{SensorName: "vibration", Timestamp: now, Aggregate: &pb.Aggregate{
+				Count: 5, Buckets: []*pb.HistogramBucket{{UpperBound: 0.1, Count: 6}},
+			}},
+			wantFields: []string{"aggregate"},
+		},
+		{
+			name: "location",
+			req:  &pb.SensorData{SensorName: "drone", Timestamp: now, Location: &pb.Location{Latitude: -90, Longitude: 180, Altitude: ptr(-12.0)}},
+		},
+		{
+			name:       "latitude out of range",
+			req:        &pb.SensorData{SensorName: "drone", Timestamp: now, Location: &pb.Location{Latitude: 91}},
+			wantFields: []string{"location"},
+		},
+		{
+			name:       "NaN longitude and infinite altitude",
+			req:        &pb.SensorData{SensorName: "drone", Timestamp: now, Location: &pb.Location{Longitude: math.NaN(), Altitude: ptr(math.Inf(1))}},
+			wantFields: []string{"location", "location"},
+		},
+		{
+			name: "attachment",
+			req:  &pb.SensorData{SensorName: "spectrometer", Timestamp: now, Attachment: make([]byte, 8)},
+		},
+		{
+			name:       "attachment over limit",
+			req:        &pb.SensorData{SensorName: "spectrometer", Timestamp: now, Attachment: make([]byte, 9)},
+			wantFields: []string{"attachment"},
+		},
+		{
+			name: "unit",
+			req:  &pb.SensorData{SensorName: "outdoor", Timestamp: now, Unit: "fahrenheit"},
+		},
+		{
+			name:       "long unit",
+			req:        &pb.SensorData{SensorName: "outdoor", Timestamp: now, Unit: strings.Repeat("x", 17)},
+			wantFields: []string{"unit"},
+		},
+		{
+			name:       "aggregate with values",
+			req:        &pb.SensorData{SensorName: "vibration", Timestamp: now, Values: map[string]float64{"x": 1}, Aggregate: &pb.Aggregate{Count: 1, Sum: 1}},
+			wantFields: []string{"aggregate"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.validateRequest(tt.req)
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Errorf("validateRequest() error = %v, want nil", err)
+				}
+				return
+			}
+
+			st := status.Convert(err)
+			if st.Code() != codes.InvalidArgument {
+				t.Fatalf("validateRequest() code = %v, want InvalidArgument", st.Code())
+			}
+
+			var fields []string
+			for _, detail := range st.Details() {
+				if br, ok := detail.(*errdetails.BadRequest); ok {
+					for _, v := range br.FieldViolations {
+						fields = append(fields, v.Field)
+					}
+				}
+			}
+			if !slices.Equal(fields, tt.wantFields) {
+				t.Errorf("violated fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestValidateRequest_AttachmentsDisabled(t *testing.T) {
+	s := &SinkServer{config: config.Config{}}
+	req := &pb.SensorData{SensorName: "spectrometer", Timestamp: timestamppb.Now(), Attachment: []byte{1}}
+
+	if err := s.validateRequest(req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("validateRequest() error = %v, want InvalidArgument with -max-attachment-size=0", err)
+	}
+}
+
+func TestSensorDataFromCBOR(t *testing.T) {
+	// {"sensor_name": "mote", "sensor_value": -5, "timestamp": 0("2024-01-01T12:00:00Z"),
+	//  "tags": {"site": "b7"}, "values": {"rh": 1.5}, "priority": "critical"}
+	data, _ := hex.DecodeString("a66b73656e736f725f6e616d65646d6f74656c73656e736f725f76616c756538046974696d657374616d70c074323032342d30312d30315431323a30303a30305a6474616773a164736974656262376676616c756573a1627268f93e00687072696f7269747968637269746963616c")
+
+	req, err := sensorDataFromCBOR(data)
+	if err != nil {
+		t.Fatalf("sensorDataFromCBOR() error = %v", err)
+	}
+	if req.SensorName != "mote" || req.SensorValue != -5 || req.Tags["site"] != "b7" || req.Values["rh"] != 1.5 || req.Priority != pb.Priority_PRIORITY_CRITICAL {
+		t.Errorf("sensorDataFromCBOR() = %v, want every field decoded", req)
+	}
+	if got := req.Timestamp.AsTime(); !got.Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Timestamp = %v, want 2024-01-01T12:00:00Z", got)
+	}
+
+	invalid := []struct {
+		name string
+		hex  string
+	}{
+		{"not a map", "01"},
+		{"fractional sensor_value", "a16c73656e736f725f76616c7565f93e00"},
+		{"unknown field", "a164756e69786143"},
+		{"timestamp with another tag", "a16974696d657374616d70c24100"},
+		{"integer tag value", "a16474616773a1616101"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := hex.DecodeString(tt.hex)
+			if _, err := sensorDataFromCBOR(data); err == nil {
+				t.Error("sensorDataFromCBOR() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestValidateEvent(t *testing.T) {
+	s := &SinkServer{config: config.Config{MaxTags: 2, MaxFieldSize: 16}}
+	now := timestamppb.Now()
+
+	tests := []struct {
+		name      string
+		req       *pb.Event
+		wantField string
+	}{
+		{"valid", &pb.Event{SensorName: "pump", Timestamp: now, Severity: pb.Severity_SEVERITY_ERROR, Message: "stalled"}, ""},
+		{"missing message", &pb.Event{SensorName: "pump", Timestamp: now}, "message"},
+		{"unknown severity", &pb.Event{SensorName: "pump", Timestamp: now, Severity: 42, Message: "stalled"}, "severity"},
+		{"too many attributes", &pb.Event{SensorName: "pump", Timestamp: now, Message: "stalled", Attributes: map[string]string{"a": "1", "b": "2", "c": "3"}}, "attributes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.validateEvent(tt.req)
+			if tt.wantField == "" {
+				if err != nil {
+					t.Errorf("validateEvent() error = %v, want nil", err)
+				}
+				return
+			}
+
+			st := status.Convert(err)
+			if st.Code() != codes.InvalidArgument {
+				t.Fatalf("validateEvent() code = %v, want InvalidArgument", st.Code())
+			}
+			for _, detail := range st.Details() {
+				if br, ok := detail.(*errdetails.BadRequest); ok && br.FieldViolations[0].Field != tt.wantField {
+					t.Errorf("validateEvent() field = %s, want %s", br.FieldViolations[0].Field, tt.wantField)
+				}
+			}
+		})
+	}
+}
+
+func TestRecoverUnary(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/telemetry.TelemetryService/SendSensorData"}
+	_, err := recoverUnary(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		var tags map[string]string
+		tags["boom"] = "x"
+		return nil, nil
+	})
+
+	if code := status.Code(err); code != codes.Internal {
+		t.Errorf("recoverUnary() code = %v, want Internal", code)
+	}
+}
+
+func TestNewSinkServer_ClosesOnFailure(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	if err := os.WriteFile(notDir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+	_, err := NewSinkServer(config.Config{
+		LogFilePath:    filepath.Join(dir, "telemetry.log"),
+		BufferSize:     1024,
+		SpillDir:       filepath.Join(dir, "spill"),
+		SpillQuota:     1 << 20,
+		DeadLetterFile: filepath.Join(dir, "dead-letter.jsonl"),
+		AttachmentDir:  filepath.Join(notDir, "attachments"),
+	})
+	if err == nil {
+		t.Fatal("NewSinkServer() with an attachment directory under a file error = nil")
+	}
+
+	// The log writer, spill and dead-letter file opened before the failing step
+	// stop their goroutines when closed.
+	for deadline := time.Now().Add(2 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines after a failed NewSinkServer(), want %d", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSupervise(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	clk := clock.NewFake(time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC))
+	s, err := NewSinkServer(config.Config{LogFilePath: filepath.Join(t.TempDir(), "telemetry.log"), BufferSize: 1024, Clock: clk})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+	s.serving.Store(true)
+
+	runs := make(chan int, 2)
+	n := 0
+	s.wg.Add(1)
+	go s.supervise("flush_timer", func() {
+		n++
+		runs <- n
+		if n == 1 {
+			var tags map[string]string
+			tags["boom"] = "x"
+		}
+		<-s.done
+	})
+
+	if got := <-runs; got != 1 {
+		t.Fatalf("run %d, want 1", got)
+	}
+	// The restart waits for the server's clock, which only moves when advanced.
+	deadline := time.After(5 * time.Second)
+	for restarted := false; !restarted; {
+		select {
+		case got := <-runs:
+			if got != 2 {
+				t.Fatalf("run %d, want 2", got)
+			}
+			restarted = true
+		case <-deadline:
+			t.Fatalf("goroutine not restarted after a panic")
+		case <-time.After(10 * time.Millisecond):
+			clk.Advance(restartDelay)
+		}
+	}
+	if err := s.ready(); err == nil || !strings.Contains(err.Error(), "flush_timer panicked") {
+		t.Errorf("ready() = %v, want the panic", err)
+	}
+
+	s.Stop()
+	s.wg.Wait()
+}
+
+func TestHandleReady(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{LogFilePath: filepath.Join(t.TempDir(), "telemetry.log"), BufferSize: 1024})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		s.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec.Code
+	}
+
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz before serving = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	s.serving.Store(true)
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("/readyz while serving = %d, want %d", code, http.StatusOK)
+	}
+	s.draining.Store(true)
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz while draining = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestCheckWatchdog(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dumpDir := filepath.Join(t.TempDir(), "dumps")
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:           filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:            1024,
+		RateLimit:             1 << 20,
+		WatchdogMaxGoroutines: 1,
+		WatchdogActions:       []string{config.WatchdogShed, config.WatchdogDump},
+		WatchdogDumpDir:       dumpDir,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+	s.serving.Store(true)
+
+	var state watchdogState
+	s.checkWatchdog(&state)
+
+	ctx := context.Background()
+	req := &pb.SensorData{SensorName: "temp", SensorValue: 1, Timestamp: timestamppb.Now()}
+	if _, err := s.SendSensorData(ctx, req); status.Code(err) != codes.Unavailable || !errors.Is(err, errdefs.ErrOverloaded) {
+		t.Errorf("SendSensorData() while overloaded error = %v, want Unavailable and ErrOverloaded", err)
+	}
+	critical := &pb.SensorData{SensorName: "smoke", SensorValue: 1, Timestamp: timestamppb.Now(), Priority: pb.Priority_PRIORITY_CRITICAL}
+	if _, err := s.SendSensorData(ctx, critical); err != nil {
+		t.Errorf("SendSensorData() of a critical reading while overloaded error = %v", err)
+	}
+	if err := s.ready(); err == nil {
+		t.Error("ready() while overloaded = nil, want an error")
+	}
+	if dumps, _ := os.ReadDir(dumpDir); len(dumps) != 2 {
+		t.Errorf("dump directory holds %d files, want goroutines and heap", len(dumps))
+	}
+
+	s.config.WatchdogMaxGoroutines = 0
+	s.checkWatchdog(&state)
+	if _, err := s.SendSensorData(ctx, req); err != nil {
+		t.Errorf("SendSensorData() after recovery error = %v", err)
+	}
+	if err := s.ready(); err != nil {
+		t.Errorf("ready() after recovery = %v", err)
+	}
+}
+
+func TestFlushAged(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	fake := clock.NewFake(time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC))
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:  filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:   1024,
+		MaxBufferAge: 4 * time.Second,
+		Clock:        fake,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	s.buffer = append(s.buffer, "entry\n"...)
+	s.buffered.add(1, false, s.now())
+	fake.Advance(time.Second)
+	s.buffer = append(s.buffer, "entry\n"...)
+	s.buffered.add(1, false, s.now())
+
+	// The next check, a quarter of the age later, would be too late.
+	for _, tt := range []struct {
+		advance time.Duration
+		want    int
+	}{{time.Second, 12}, {time.Second, 0}} {
+		fake.Advance(tt.advance)
+		s.flushAged()
+		if len(s.buffer) != tt.want {
+			t.Errorf("buffer holds %d bytes %v after the first entry, want %d", len(s.buffer), fake.Now().Sub(time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC)), tt.want)
+		}
+	}
+	if !s.buffered.oldest.IsZero() {
+		t.Errorf("oldest = %v after the flush, want zero", s.buffered.oldest)
+	}
+}
+
+func TestAdaptiveFlush(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:     filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:      1024,
+		FlushPolicy:     config.FlushPolicyAdaptive,
+		FlushTargetSize: 512,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	buffer := func(size int) {
+		s.bufferMutex.Lock()
+		defer s.bufferMutex.Unlock()
+		s.buffer = append(s.buffer, strings.Repeat("x", size)...)
+		s.noteBuffered(size)
+	}
+
+	// Steady traffic is batched.
+	for range 5 {
+		buffer(30)
+		s.flushOnRateDrop()
+	}
+	if len(s.buffer) != 150 {
+		t.Fatalf("buffer holds %d bytes under steady traffic, want 150", len(s.buffer))
+	}
+	// The burst ended.
+	s.flushOnRateDrop()
+	if len(s.buffer) != 0 {
+		t.Fatalf("buffer holds %d bytes after the rate dropped, want 0", len(s.buffer))
+	}
+
+	if err := s.writer.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	buffer(600)
+	if len(s.buffer) != 0 {
+		t.Errorf("buffer holds %d bytes over the target size, want 0", len(s.buffer))
+	}
+}
+
+func TestCheckWatchdog_FlushesOversizedBuffer(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:     filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:      16,
+		WatchdogActions: []string{config.WatchdogFlush},
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	// Critical entries held while the writer was behind.
+	s.buffer = append(s.buffer, strings.Repeat("x", 20)...)
+
+	var state watchdogState
+	s.checkWatchdog(&state)
+	if len(s.buffer) != 0 {
+		t.Errorf("buffer holds %d bytes after the flush action, want 0", len(s.buffer))
+	}
+	if s.overloaded.Load() != nil {
+		t.Error("flush action alone shed load")
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	type outcome struct {
+		kept     bool
+		code     codes.Code
+		buffered int
+	}
+	tests := []struct {
+		name     string
+		policy   string
+		critical int // of the 3 buffered entries
+		primary  bool
+		want     []outcome
+		dropped  map[string]float64
+	}{
+		{
+			name:   "reject",
+			policy: config.OverloadReject,
+			want:   []outcome{{code: codes.Unavailable, buffered: 3}},
+		},
+		{
+			name:    "drop oldest",
+			policy:  config.OverloadDropOldest,
+			want:    []outcome{{kept: true}},
+			dropped: map[string]float64{dropOldest: 3},
+		},
+		{
+			name:     "drop oldest keeps critical entries",
+			policy:   config.OverloadDropOldest,
+			critical: 1,
+			want:     []outcome{{code: codes.Unavailable, buffered: 3}},
+		},
+		{
+			name:    "drop oldest keeps replicated entries",
+			policy:  config.OverloadDropOldest,
+			primary: true,
+			want:    []outcome{{code: codes.Unavailable, buffered: 3}},
+		},
+		{
+			name:   "sample",
+			policy: config.OverloadSample,
+			want: []outcome{
+				{buffered: 3},
+				{buffered: 3},
+				{kept: true, buffered: 3},
+				{buffered: 3},
+			},
+			dropped: map[string]float64{dropSampled: 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSinkServer(config.Config{
+				LogFilePath:        filepath.Join(t.TempDir(), "telemetry.log"),
+				BufferSize:         64,
+				OverloadPolicy:     tt.policy,
+				OverloadSampleRate: 3,
+			})
+			if err != nil {
+				t.Fatalf("NewSinkServer() error = %v", err)
+			}
+			defer s.Close()
+			if tt.primary {
+				s.replicator = &replicator{}
+				defer func() { s.replicator = nil }()
+			}
+
+			s.buffer = append(s.buffer, strings.Repeat("x", 60)...)
+			s.buffered = bufferCount{entries: 3, critical: tt.critical}
+			before := map[string]float64{
+				dropOldest:  metrics.Total(metrics.EntriesDropped.WithLabelValues(dropOldest)),
+				dropSampled: metrics.Total(metrics.EntriesDropped.WithLabelValues(dropSampled)),
+			}
+
+			for i, want := range tt.want {
+				kept, err := s.overflow(&incoming{sensorName: "temp"}, 1, 10, storage.ErrQueueFull)
+				if kept != want.kept || status.Code(err) != want.code {
+					t.Errorf("overflow() #%d = %v, %v, want %v, %v", i+1, kept, err, want.kept, want.code)
+				}
+				if s.buffered.entries != want.buffered {
+					t.Errorf("after overflow() #%d, %d entries buffered, want %d", i+1, s.buffered.entries, want.buffered)
+				}
+			}
+			for reason, base := range before {
+				if got := metrics.Total(metrics.EntriesDropped.WithLabelValues(reason)) - base; got != tt.dropped[reason] {
+					t.Errorf("entries dropped as %s = %v, want %v", reason, got, tt.dropped[reason])
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForWriter(t *testing.T) {
+	s, err := NewSinkServer(config.Config{
+		LogFilePath: filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:  1024,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s.bufferMutex.Lock()
+	defer s.bufferMutex.Unlock()
+	s.buffer = append(s.buffer, "entry\n"...)
+	if err := s.waitForWriter(ctx, &incoming{forget: true}); !errors.Is(err, storage.ErrQueueFull) || len(s.buffer) == 0 {
+		t.Errorf("waitForWriter() of a fire-and-forget reading = %v, want ErrQueueFull and the buffer kept", err)
+	}
+	if err := s.waitForWriter(ctx, &incoming{}); err != nil || len(s.buffer) != 0 {
+		t.Errorf("waitForWriter() = %v with %d bytes buffered, want the buffer handed over", err, len(s.buffer))
+	}
+}
+
+// stalledFile is a log file whose writes block until it is released.
+type stalledFile struct{ release chan struct{} }
+
+func (f stalledFile) Write(p []byte) (int, error) { <-f.release; return len(p), nil }
+func (f stalledFile) Sync() error                 { return nil }
+func (f stalledFile) Close() error                { return nil }
+
+func TestWaitForWriter_Concurrent(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:    filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:     16,
+		WriteQueueSize: 1,
+		RateLimit:      1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	// One buffer is being written to a stalled disk and another fills the queue.
+	file := stalledFile{release: make(chan struct{})}
+	s.writer.Close()
+	s.writer = storage.NewWriter(file, 16, 1, nil, nil)
+	defer close(file.release)
+	s.writer.Enqueue([]byte("entry\n"))
+	s.writer.Enqueue([]byte("entry\n"))
+	s.buffer = append(s.buffer, "entry\n"...)
+
+	// Neither request holds the buffer for its whole deadline while waiting for the
+	// writer, so the second one isn't held up behind the first.
+	const deadline = 10 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Go(func() {
+			_, errs[i] = s.SendSensorData(ctx, &pb.SensorData{SensorName: "temp", SensorValue: int32(i), Timestamp: timestamppb.Now()})
+		})
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed >= deadline/2 {
+		t.Errorf("requests against a full write queue took %v, want them refused well before their deadline", elapsed)
+	}
+	for i, err := range errs {
+		if status.Code(err) == codes.OK {
+			t.Errorf("SendSensorData() #%d error = nil, want the full write queue refusing it", i+1)
+		}
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	var (
+		mu       sync.Mutex
+		received int
+	)
+	release := make(chan struct{})
+	unstall := sync.OnceFunc(func() { close(release) })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // a stalled output
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received += strings.Count(string(body), "\n")
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	logPath := filepath.Join(t.TempDir(), "telemetry.log")
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:       logPath,
+		BufferSize:        256,
+		WriteQueueSize:    1,
+		RateLimit:         1 << 20,
+		Output:            config.OutputLog,
+		FanOut:            []string{config.OutputInflux},
+		InfluxURL:         srv.URL,
+		InfluxMeasurement: "telemetry",
+		InfluxTimeout:     time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+	defer unstall()
+
+	before := metrics.Total(metrics.FanOutEntriesDropped.WithLabelValues(config.OutputInflux))
+	const readings = 20
+	// With a deadline, readings wait for the log's writer, but not for the stalled one.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := range readings {
+		if _, err := s.SendSensorData(ctx, &pb.SensorData{SensorName: "temp", SensorValue: int32(i), Timestamp: timestamppb.Now()}); err != nil {
+			t.Fatalf("SendSensorData() #%d error = %v, want the stalled output not to hold up readings", i+1, err)
+		}
+	}
+	if metrics.Total(metrics.FanOutEntriesDropped.WithLabelValues(config.OutputInflux)) == before {
+		t.Error("stalled output dropped no entries")
+	}
+
+	unstall()
+	if err := s.FlushNow(); err != nil {
+		t.Fatalf("FlushNow() error = %v", err)
+	}
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != readings {
+		t.Errorf("log holds %d entries, want %d", lines, readings)
+	}
+	dropped := metrics.Total(metrics.FanOutEntriesDropped.WithLabelValues(config.OutputInflux)) - before
+	mu.Lock()
+	defer mu.Unlock()
+	if received == 0 || received+int(dropped) != readings {
+		t.Errorf("influx output received %d entries and dropped %v, want %d in all", received, dropped, readings)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, strings.Fields(string(body))...)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	routesFile := filepath.Join(dir, "routes.yaml")
+	writeRoutes := func(routes string) {
+		if err := os.WriteFile(routesFile, []byte(routes), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeRoutes(`
+outputs:
+  log:
+    - sensors: ["temp-*"]
+  influx:
+    - sensors: ["power.*"]
+      min: 0
+`)
+	cfg := config.Config{
+		LogFilePath:       filepath.Join(dir, "telemetry.log"),
+		BufferSize:        4096,
+		RateLimit:         1 << 20,
+		Output:            config.OutputLog,
+		FanOut:            []string{config.OutputInflux},
+		InfluxURL:         srv.URL,
+		InfluxMeasurement: "telemetry",
+		InfluxTimeout:     time.Minute,
+		RoutesFile:        routesFile,
+	}
+	s, err := NewSinkServer(cfg)
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	for _, reading := range []*pb.SensorData{
+		{SensorName: "temp-1", SensorValue: 21},
+		{SensorName: "power.main", SensorValue: 230},
+		{SensorName: "power.main", SensorValue: -5},
+	} {
+		reading.Timestamp = timestamppb.Now()
+		if _, err := s.SendSensorData(context.Background(), reading); err != nil {
+			t.Fatalf("SendSensorData(%s) error = %v", reading.SensorName, err)
+		}
+	}
+	if err := s.FlushNow(); err != nil {
+		t.Fatalf("FlushNow() error = %v", err)
+	}
+
+	data, err := os.ReadFile(cfg.LogFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"sensor_name":"temp-1"`) {
+		t.Errorf("log = %s, want only temp-1", data)
+	}
+	mu.Lock()
+	if len(received) == 0 || !strings.Contains(received[0], "sensor=power.main") || !slices.Contains(received, "value=230") || slices.Contains(received, "value=-5") {
+		t.Errorf("influx output received %q, want only power.main at 230", received)
+	}
+	mu.Unlock()
+
+	writeRoutes("outputs:\n  postgres:\n    - sensors: [\"*\"]\n")
+	if err := s.Reload(); err == nil {
+		t.Error("Reload() of routes for an output that isn't configured error = nil")
+	}
+}
+
+func TestConnect(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:    filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:     4096,
+		RateLimit:      1 << 20,
+		ConnectAddr:    "127.0.0.1:0",
+		ConnectOrigins: []string{"https://dashboard.example"},
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = s.newConnectServer(nil)
+	srv.Start()
+	defer srv.Close()
+
+	h2c := &http.Client{Transport: &http.Transport{Protocols: new(http.Protocols)}}
+	h2c.Transport.(*http.Transport).Protocols.SetUnencryptedHTTP2(true)
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		opts   []connect.ClientOption
+	}{
+		{"connect", http.DefaultClient, nil},
+		{"connect json", http.DefaultClient, []connect.ClientOption{connect.WithProtoJSON()}},
+		{"grpc-web", http.DefaultClient, []connect.ClientOption{connect.WithGRPCWeb()}},
+		{"grpc", h2c, []connect.ClientOption{connect.WithGRPC()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := protoconnect.NewTelemetryServiceClient(tt.client, srv.URL, tt.opts...)
+			ctx := context.Background()
+
+			resp, err := client.SendSensorData(ctx, connect.NewRequest(&pb.SensorData{SensorName: "temp", SensorValue: 21, Timestamp: timestamppb.Now()}))
+			if err != nil {
+				t.Fatalf("SendSensorData() error = %v", err)
+			}
+			if resp.Msg.Sequence == 0 {
+				t.Errorf("SendSensorData() = %v, want a sequence", resp.Msg)
+			}
+
+			_, err = client.SendSensorData(ctx, connect.NewRequest(&pb.SensorData{SensorName: "temp"}))
+			if connect.CodeOf(err) != connect.CodeInvalidArgument {
+				t.Errorf("SendSensorData() of an invalid reading error = %v, want InvalidArgument", err)
+			}
+			var connectErr *connect.Error
+			if errors.As(err, &connectErr) && len(connectErr.Details()) == 0 {
+				t.Error("SendSensorData() of an invalid reading lost the error details")
+			}
+		})
+	}
+
+	call := func(method, origin string) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+protoconnect.TelemetryServiceHeartbeatProcedure, strings.NewReader(`{"sensorName":"temp"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", origin)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := call(http.MethodPost, "https://dashboard.example"); resp.StatusCode != http.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "https://dashboard.example" {
+		t.Errorf("call from an allowed origin = %d, Access-Control-Allow-Origin %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+	if resp := call(http.MethodPost, "https://elsewhere.example"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("call from another origin status = %d, want 403", resp.StatusCode)
+	}
+	if resp := call(http.MethodOptions, "https://dashboard.example"); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("preflight status = %d, want 204", resp.StatusCode)
+	} else if allowed := resp.Header.Get("Access-Control-Allow-Headers"); !strings.Contains(allowed, "Authorization") {
+		t.Errorf("preflight Access-Control-Allow-Headers = %q, want Authorization allowed for bearer tokens", allowed)
+	}
+
+	// The unauthenticated admin server doesn't serve the TelemetryService.
+	rec := httptest.NewRecorder()
+	s.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, protoconnect.TelemetryServiceHeartbeatProcedure, strings.NewReader(`{"sensorName":"temp"}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Connect call on the admin server status = %d, want 404", rec.Code)
+	}
+}
+
+func TestConnect_TLS(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sink"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath: filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:  4096,
+		RateLimit:   1 << 20,
+		ConnectAddr: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	srv := s.newConnectServer(&tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}})
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.ServeTLS(lis, "", "")
+	defer srv.Close()
+
+	// gRPC clients reach the listener over HTTP/2 with TLS.
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, ForceAttemptHTTP2: true}}
+	telemetry := protoconnect.NewTelemetryServiceClient(client, "https://"+lis.Addr().String(), connect.WithGRPC())
+	if _, err := telemetry.SendSensorData(context.Background(), connect.NewRequest(&pb.SensorData{SensorName: "temp", SensorValue: 21, Timestamp: timestamppb.Now()})); err != nil {
+		t.Errorf("SendSensorData() over TLS error = %v", err)
+	}
+}
+
+func TestConnect_Bans(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	tokensFile := filepath.Join(dir, "tokens.yaml")
+	sum := sha256.Sum256([]byte("secret"))
+	if err := os.WriteFile(tokensFile, fmt.Appendf(nil, "tokens:\n  - name: gateway\n    sha256: %x\n", sum), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:    filepath.Join(dir, "telemetry.log"),
+		BufferSize:     1024,
+		RateLimit:      1 << 20,
+		AuthMethods:    []string{auth.MethodToken},
+		AuthTokensFile: tokensFile,
+		BanAfter:       2,
+		BanWindow:      time.Minute,
+		BanDuration:    time.Hour,
+		ConnectAddr:    "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	_, handler := s.connectHandler(nil)
+	call := func(ip, token string) int {
+		req := httptest.NewRequest(http.MethodPost, protoconnect.TelemetryServiceHeartbeatProcedure, strings.NewReader(`{"sensorName":"temp"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.RemoteAddr = net.JoinHostPort(ip, "40000")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// Failed authentications over Connect count towards the ban like gRPC ones.
+	for i := range 2 {
+		if code := call("10.0.0.1", "wrong"); code != http.StatusUnauthorized {
+			t.Fatalf("call #%d with a wrong token status = %d, want 401", i+1, code)
+		}
+	}
+	if code := call("10.0.0.1", "secret"); code != http.StatusForbidden {
+		t.Errorf("call from a banned IP status = %d, want 403", code)
+	}
+	if code := call("10.0.0.2", "secret"); code != http.StatusOK {
+		t.Errorf("call from another IP status = %d, want 200", code)
+	}
+}
+
+func TestFrameAndCoAP_Bans(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	tokensFile := filepath.Join(dir, "tokens.yaml")
+	sum := sha256.Sum256([]byte("secret"))
+	if err := os.WriteFile(tokensFile, fmt.Appendf(nil, "tokens:\n  - name: gateway\n    sha256: %x\n", sum), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:    filepath.Join(dir, "telemetry.log"),
+		BufferSize:     1024,
+		AuthMethods:    []string{auth.MethodToken},
+		AuthTokensFile: tokensFile,
+		BanAfter:       2,
+		BanWindow:      time.Minute,
+		BanDuration:    time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	payload, err := proto.Marshal(&pb.SensorData{SensorName: "temp", SensorValue: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Frames carry no token, so every call on the connection fails authentication
+	// until the ban refuses them.
+	f := &frameServer{sink: s}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 40000}})
+	for i, want := range []codes.Code{codes.Unauthenticated, codes.Unauthenticated, codes.PermissionDenied} {
+		if _, err := f.handle(ctx, payload); status.Code(err) != want {
+			t.Errorf("frame call #%d error = %v, want %v", i+1, err, want)
+		}
+	}
+	if _, banned := s.bans.Banned("10.0.0.1"); !banned {
+		t.Error("frame client not banned after failed authentications")
+	}
+
+	c := &coapServer{sink: s}
+	req := &coap.Message{
+		Type:    coap.Confirmable,
+		Code:    coap.POST,
+		Options: []coap.Option{{Number: coap.OptionURIPath, Value: []byte(coapPath)}, coap.UintOption(coap.OptionContentFormat, coapFormatProtobuf)},
+		Payload: payload,
+	}
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5683}
+	for i, want := range []coap.Code{coap.Unauthorized, coap.Unauthorized, coap.Forbidden} {
+		if code, _ := c.process(addr, req); code != want {
+			t.Errorf("CoAP reading #%d = %v, want %v", i+1, code, want)
+		}
+	}
+}
+
+// natsMsg records how a test message was settled.
+type natsMsg struct {
+	jetstream.Msg
+	data    []byte
+	headers nats.Header
+	settled string
+}
+
+func (m *natsMsg) Data() []byte                           { return m.data }
+func (m *natsMsg) Headers() nats.Header                   { return m.headers }
+func (m *natsMsg) DoubleAck(context.Context) error        { m.settled = "ack"; return nil }
+func (m *natsMsg) Term() error                            { m.settled = "term"; return nil }
+func (m *natsMsg) NakWithDelay(delay time.Duration) error { m.settled = "nak"; return nil }
+
+func TestNATSIngest_Handle(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath: filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:  4096,
+		RateLimit:   1 << 20,
+		NATSTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+	n := &natsIngest{sink: s, seen: state.NewTable[string, struct{}]("nats_message_ids", state.Limits{TTL: natsDuplicateWindow})}
+
+	reading, err := proto.Marshal(&pb.SensorData{SensorName: "temp", SensorValue: 21, Timestamp: timestamppb.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid, err := proto.Marshal(&pb.SensorData{SensorName: "temp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		headers nats.Header
+		want    string
+	}{
+		{"stored", reading, nats.Header{jetstream.MsgIDHeader: {"a"}}, "ack"},
+		{"duplicate", reading, nats.Header{jetstream.MsgIDHeader: {"a"}}, "ack"},
+		{"json", []byte(`{"sensorName":"humidity","sensorValue":40,"timestamp":"2024-05-01T12:00:00Z"}`), nats.Header{"Content-Type": {"application/json"}}, "ack"},
+		{"malformed", []byte("not a reading"), nil, "term"},
+		{"invalid", invalid, nil, "term"},
+	}
+	for _, tt := range tests {
+		msg := &natsMsg{data: tt.data, headers: tt.headers}
+		n.handle(msg)
+		if msg.settled != tt.want {
+			t.Errorf("%s: message settled with %q, want %q", tt.name, msg.settled, tt.want)
+		}
+	}
+
+	if got := strings.Count(string(s.buffer), `"sensor_name":"temp"`); got != 1 {
+		t.Errorf("buffer has %d entries of temp, want 1: %s", got, s.buffer)
+	}
+	if !strings.Contains(string(s.buffer), `"sensor_name":"humidity"`) {
+		t.Errorf("buffer = %s, want the JSON reading", s.buffer)
+	}
+}
+
+func TestSelfTelemetry(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:           filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:            4096,
+		RateLimit:             1 << 20,
+		MultiValueMode:        config.MultiValueCombined,
+		SelfTelemetryInterval: time.Minute,
+		SelfTelemetrySensor:   "sink-a",
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	last := readSelfCounters()
+	ctx := context.Background()
+	for _, name := range []string{"temp", "flow", ""} {
+		s.SendSensorData(ctx, &pb.SensorData{SensorName: name, SensorValue: 1, Timestamp: timestamppb.Now()})
+	}
+
+	req := s.selfReading(last, readSelfCounters())
+	if req.SensorName != "sink-a" {
+		t.Errorf("selfReading() sensor = %q, want sink-a", req.SensorName)
+	}
+	for name, want := range map[string]float64{"entries_received": 2, "entries_rejected": 1, "writes": 0} {
+		if got := req.Values[name]; got != want {
+			t.Errorf("selfReading() %s = %v, want %v", name, got, want)
+		}
+	}
+	if req.Values["buffer_bytes"] == 0 || req.Values["buffer_percent"] == 0 {
+		t.Errorf("selfReading() values = %v, want the buffered readings counted", req.Values)
+	}
+	if _, ok := req.Values["write_seconds_mean"]; ok {
+		t.Error("selfReading() reported a mean write duration without writes")
+	}
+
+	if err := s.storeSelfReading(ctx, req); err != nil {
+		t.Fatalf("storeSelfReading() error = %v", err)
+	}
+	if !strings.Contains(string(s.buffer), `"sensor_name":"sink-a"`) {
+		t.Errorf("buffer = %s, want the self-telemetry entry", s.buffer)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath: filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:  1024,
+		RateLimit:   1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	requestInfo := func(err error) (id string, badRequest bool) {
+		for _, detail := range status.Convert(err).Details() {
+			switch d := detail.(type) {
+			case *errdetails.RequestInfo:
+				id = d.RequestId
+			case *errdetails.BadRequest:
+				badRequest = true
+			}
+		}
+		return id, badRequest
+	}
+
+	_, err = s.SendSensorData(context.Background(), &pb.SensorData{SensorName: "temp-1", RequestId: "reading-1"})
+	if id, badRequest := requestInfo(err); status.Code(err) != codes.InvalidArgument || id != "reading-1" || !badRequest {
+		t.Errorf("SendSensorData() error = %v with request ID %q, want InvalidArgument with the BadRequest and request ID reading-1", err, id)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIDMetadataKey, "call-1"))
+	_, err = s.SendSensorData(ctx, &pb.SensorData{SensorName: "temp-1"})
+	if id, _ := requestInfo(err); id != "call-1" {
+		t.Errorf("SendSensorData() without an ID error request ID = %q, want the call's, call-1", id)
+	}
+
+	resp, err := s.SendSensorDataBatch(ctx, &pb.SensorDataBatch{Readings: []*pb.SensorData{
+		{SensorName: "temp-1", Timestamp: timestamppb.Now(), RequestId: "reading-2"},
+		{SensorName: "temp-2"},
+	}})
+	if err != nil {
+		t.Fatalf("SendSensorDataBatch() error = %v", err)
+	}
+	for i, want := range []string{"reading-2", "call-1"} {
+		if got := resp.Results[i].RequestId; got != want {
+			t.Errorf("result %d request ID = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestBans(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath: filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:  1024,
+		BanAfter:    2,
+		BanWindow:   time.Minute,
+		BanDuration: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	call := func(ip string, handlerErr error) error {
+		addr := net.TCPAddrFromAddrPort(netip.AddrPortFrom(netip.MustParseAddr(ip), 40000))
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+		_, err := s.guardUnary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/telemetry.TelemetryService/SendSensorData"}, func(ctx context.Context, req any) (any, error) {
+			return nil, handlerErr
+		})
+		return err
+	}
+	denied := status.Error(codes.PermissionDenied, "not allowed")
+
+	call("10.0.0.1", denied)
+	call("10.0.0.1", status.Error(codes.InvalidArgument, "invalid reading"))
+	if err := call("10.0.0.1", nil); err != nil {
+		t.Fatalf("call after one failed authentication error = %v, want nil", err)
+	}
+	call("10.0.0.1", denied)
+	if err := call("10.0.0.1", nil); status.Code(err) != codes.PermissionDenied || !strings.Contains(err.Error(), "banned") {
+		t.Fatalf("call after two failed authentications error = %v, want the ban", err)
+	}
+	if err := call("10.0.0.2", nil); err != nil {
+		t.Errorf("call from another IP error = %v, want nil", err)
+	}
+
+	admin := s.adminHandler()
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "/bans")
+	if !strings.Contains(rec.Body.String(), `"ip":"10.0.0.1"`) || !strings.Contains(rec.Body.String(), "failed authentication") {
+		t.Errorf("GET /bans = %s, want the ban of 10.0.0.1", rec.Body)
+	}
+	if rec := serve(http.MethodPut, "/bans/10.0.0.3?duration=10m&reason=scanner"); rec.Code != http.StatusOK {
+		t.Errorf("PUT /bans/10.0.0.3 = %d %s, want 200", rec.Code, rec.Body)
+	}
+	if err := call("10.0.0.3", nil); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("call from a manually banned IP error = %v, want PermissionDenied", err)
+	}
+	if rec := serve(http.MethodPut, "/bans/not-an-ip"); rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT /bans/not-an-ip = %d, want 400", rec.Code)
+	}
+	if rec := serve(http.MethodDelete, "/bans/10.0.0.1"); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE /bans/10.0.0.1 = %d, want 204", rec.Code)
+	}
+	if err := call("10.0.0.1", nil); err != nil {
+		t.Errorf("call after the ban was lifted error = %v, want nil", err)
+	}
+	if rec := serve(http.MethodDelete, "/bans/10.0.0.1"); rec.Code != http.StatusNotFound {
+		t.Errorf("DELETE of an IP not banned = %d, want 404", rec.Code)
+	}
+
+	// Link-local clients are told apart by their zone, and IPv4-mapped addresses
+	// are banned as IPv4.
+	if rec := serve(http.MethodPut, "/bans/fe80::1%25eth0"); rec.Code != http.StatusOK {
+		t.Errorf("PUT /bans/fe80::1%%25eth0 = %d %s, want 200", rec.Code, rec.Body)
+	}
+	if err := call("fe80::1%eth0", nil); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("call from a banned link-local IP error = %v, want PermissionDenied", err)
+	}
+	if err := call("fe80::1%eth1", nil); err != nil {
+		t.Errorf("call from the same IP on another interface error = %v, want nil", err)
+	}
+	if rec := serve(http.MethodPut, "/bans/::ffff:10.0.0.4"); rec.Code != http.StatusOK {
+		t.Errorf("PUT /bans/::ffff:10.0.0.4 = %d %s, want 200", rec.Code, rec.Body)
+	}
+	if err := call("10.0.0.4", nil); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("call from an IP banned as IPv4-mapped error = %v, want PermissionDenied", err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	tokensFile := filepath.Join(dir, "tokens.yaml")
+	writeTokens := func(names ...string) {
+		var data strings.Builder
+		data.WriteString("tokens:\n")
+		for _, name := range names {
+			sum := sha256.Sum256([]byte("secret-" + name))
+			fmt.Fprintf(&data, "  - name: %s\n    sha256: %x\n", name, sum)
+		}
+		if err := os.WriteFile(tokensFile, []byte(data.String()), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTokens("gateway-a", "gateway-b")
+	policyFile := filepath.Join(dir, "policy.yaml")
+	err := os.WriteFile(policyFile, []byte("rules:\n  - match: {subject: gateway-a}\n    sensors: [\"temp-*\"]\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:     filepath.Join(dir, "telemetry.log"),
+		BufferSize:      1024,
+		RateLimit:       1 << 20,
+		AuthMethods:     []string{auth.MethodToken},
+		AuthTokensFile:  tokensFile,
+		AuthzPolicyFile: policyFile,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	send := func(token, sensor string) error {
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+token))
+		}
+		_, err := s.SendSensorData(ctx, &pb.SensorData{SensorName: sensor, SensorValue: 1, Timestamp: timestamppb.Now()})
+		return err
+	}
+
+	if err := send("secret-gateway-a", "temp-1"); err != nil {
+		t.Errorf("send with a valid token error = %v, want nil", err)
+	}
+	for _, token := range []string{"", "secret-unknown"} {
+		if err := send(token, "temp-1"); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("send with token %q error = %v, want Unauthenticated", token, err)
+		}
+	}
+	if err := send("secret-gateway-a", "pressure-1"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("send for a sensor outside the rule error = %v, want PermissionDenied", err)
+	}
+	if err := send("secret-gateway-b", "temp-1"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("send by a client without a rule error = %v, want PermissionDenied", err)
+	}
+
+	// Tokens removed from the file are rejected after a reload.
+	writeTokens("gateway-b")
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if err := send("secret-gateway-a", "temp-1"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("send with a removed token error = %v, want Unauthenticated", err)
+	}
+}
+
+func TestIPAccess(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	accessFile := filepath.Join(dir, "ip-access.yaml")
+	if err := os.WriteFile(accessFile, []byte("allow: [10.0.0.0/8]\ndeny: [10.66.0.0/16]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:  filepath.Join(dir, "telemetry.log"),
+		BufferSize:   1024,
+		IPAccessFile: accessFile,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	call := func(ip string) error {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+		_, err := s.guardUnary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/telemetry.TelemetryService/SendSensorData"}, func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+		return err
+	}
+
+	if err := call("10.1.2.3"); err != nil {
+		t.Errorf("call from an allowed network error = %v, want nil", err)
+	}
+	for _, ip := range []string{"10.66.0.9", "203.0.113.7"} {
+		if err := call(ip); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("call from %s error = %v, want PermissionDenied", ip, err)
+		}
+	}
+
+	// Connect calls are checked as well.
+	_, handler := s.connectHandler(nil)
+	for ip, want := range map[string]int{"10.1.2.3": http.StatusOK, "10.66.0.9": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodPost, protoconnect.TelemetryServiceHeartbeatProcedure, strings.NewReader(`{"sensorName":"temp"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = net.JoinHostPort(ip, "40000")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Connect call from %s status = %d, want %d", ip, rec.Code, want)
+		}
+	}
+
+	// So are CoAP readings, before they are decoded.
+	c := &coapServer{sink: s}
+	for ip, want := range map[string]coap.Code{"10.1.2.3": coap.BadRequest, "10.66.0.9": coap.Forbidden} {
+		req := &coap.Message{Type: coap.Confirmable, Code: coap.POST, Options: []coap.Option{{Number: coap.OptionURIPath, Value: []byte(coapPath)}}, Payload: []byte{0xff}}
+		if code, _ := c.process(&net.UDPAddr{IP: net.ParseIP(ip), Port: 5683}, req); code != want {
+			t.Errorf("CoAP reading from %s = %v, want %v", ip, code, want)
+		}
+	}
+
+	// Clients already connected are checked against the reloaded list.
+	if err := os.WriteFile(accessFile, []byte("deny: [10.1.0.0/16]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if err := call("10.1.2.3"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("call from a newly denied network error = %v, want PermissionDenied", err)
+	}
+	if err := call("203.0.113.7"); err != nil {
+		t.Errorf("call without an allow list error = %v, want nil", err)
+	}
+
+	// A broken file keeps the list in effect.
+	if err := os.WriteFile(accessFile, []byte("deny: [10.1.0.0/99]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Error("Reload() of an invalid list error = nil, want an error")
+	}
+	if err := call("10.1.2.3"); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("call after a failed reload error = %v, want the previous list applied", err)
+	}
+}
+
+func TestThrottleHint(t *testing.T) {
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:     filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:      1024,
+		WriteQueueSize:  4,
+		MaxThrottleHint: 2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	if hint := s.throttleHint(); hint != nil {
+		t.Errorf("throttleHint() with an empty write queue = %v, want none", hint.AsDuration())
+	}
+
+	reason := "queue"
+	s.overloaded.Store(&reason)
+	if hint := s.throttleHint(); hint.AsDuration() != 2*time.Second {
+		t.Errorf("throttleHint() while shedding = %v, want 2s", hint.AsDuration())
+	}
+
+	s.config.MaxThrottleHint = 0
+	if hint := s.throttleHint(); hint != nil {
+		t.Errorf("throttleHint() when disabled = %v, want none", hint.AsDuration())
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	dir := t.TempDir()
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	keyFile := filepath.Join(dir, "encryption.key")
+	if err := os.WriteFile(keyFile, []byte(key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			cfg:  config.Config{LogFilePath: filepath.Join(dir, "telemetry.log"), EnableEncryption: true, EncryptionCipher: encryption.CipherAESGCM, EncryptionKey: key},
+		},
+		{
+			name: "key file",
+			cfg:  config.Config{LogFilePath: filepath.Join(dir, "telemetry.log"), EnableEncryption: true, EncryptionCipher: encryption.CipherAESGCM, EncryptionKeyFile: keyFile},
+		},
+		{
+			name:    "missing key file",
+			cfg:     config.Config{LogFilePath: filepath.Join(dir, "telemetry.log"), EnableEncryption: true, EncryptionCipher: encryption.CipherAESGCM, EncryptionKeyFile: keyFile + ".missing"},
+			wantErr: true,
+		},
+		{
+			name:    "short key",
+			cfg:     config.Config{LogFilePath: filepath.Join(dir, "telemetry.log"), EnableEncryption: true, EncryptionCipher: encryption.CipherAESGCM, EncryptionKey: "c2hvcnQ="},
+			wantErr: true,
+		},
+		{
+			name:    "missing log directory",
+			cfg:     config.Config{LogFilePath: filepath.Join(dir, "missing", "telemetry.log")},
+			wantErr: true,
+		},
+		{
+			name:    "missing certificates",
+			cfg:     config.Config{LogFilePath: filepath.Join(dir, "telemetry.log"), UseTLS: true, CertFile: filepath.Join(dir, "server.pem")},
+			wantErr: true,
+		},
+		{
+			name:    "policy without mTLS",
+			cfg:     config.Config{LogFilePath: filepath.Join(dir, "telemetry.log"), AuthzPolicyFile: filepath.Join(dir, "policy.yaml")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckConfig(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, statErr := os.Stat(tt.cfg.LogFilePath); statErr == nil {
+				t.Errorf("CheckConfig() created %s", tt.cfg.LogFilePath)
+			}
+		})
+	}
+}
+
+func newBenchmarkServer(b *testing.B, encrypt bool) *SinkServer {
+	b.Helper()
+
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	cfg := config.Config{
+		LogFilePath:    filepath.Join(b.TempDir(), "telemetry.log"),
+		BufferSize:     1024 * 1024,
+		WriteQueueSize: 64,
+		FlushInterval:  time.Minute,
+		RateLimit:      1 << 40,
+	}
+	if encrypt {
+		cfg.EnableEncryption = true
+		cfg.EncryptionKey = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	}
+
+	s, err := NewSinkServer(cfg)
+	if err != nil {
+		b.Fatalf("NewSinkServer() error = %v", err)
+	}
+	b.Cleanup(s.Close)
+
+	return s
+}
+
+func benchmarkSendSensorData(b *testing.B, encrypt bool) {
+	s := newBenchmarkServer(b, encrypt)
+	req := &pb.SensorData{
+		SensorName:  "temperature-01",
+		SensorValue: 42,
+		Timestamp:   timestamppb.Now(),
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		ctx := context.Background()
+		for pb.Next() {
+			if _, err := s.SendSensorData(ctx, req); err != nil {
+				b.Fatalf("SendSensorData() error = %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkSendSensorData(b *testing.B) {
+	benchmarkSendSensorData(b, false)
+}
+
+func BenchmarkSendSensorData_Encrypted(b *testing.B) {
+	benchmarkSendSensorData(b, true)
+}
+
+func BenchmarkLogEntry_AppendJSON(b *testing.B) {
+	now := time.Now().UTC()
+	entry := logEntry{
+		Timestamp:   now,
+		SensorName:  "temperature-01",
+		SensorValue: 42,
+		DataTime:    now.Add(-time.Second),
+		Tags:        map[string]string{"zone": "b", "class": "safety"},
+	}
+
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if buf, err = entry.appendJSON(buf[:0], defaultEntryFormat); err != nil {
+			b.Fatalf("appendJSON() error = %v", err)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	logPath := filepath.Join(t.TempDir(), "telemetry.log")
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:      logPath,
+		BufferSize:       1 << 16,
+		RateLimit:        1 << 20,
+		Output:           config.OutputLog,
+		EnableEncryption: true,
+		EncryptionKey:    base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		EncryptionCipher: encryption.CipherAESGCM,
+		EncryptionMode:   config.EncryptionModeEntry,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	send := func(value int32) {
+		t.Helper()
+		if _, err := s.SendSensorData(context.Background(), &pb.SensorData{SensorName: "temp", SensorValue: value, Timestamp: timestamppb.Now()}); err != nil {
+			t.Fatalf("SendSensorData() error = %v", err)
+		}
+	}
+	send(1)
+	send(2)
+
+	// The buffered readings are written before the snapshot is taken.
+	rec := httptest.NewRecorder()
+	s.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/snapshot", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /snapshot = %d %s, want 200", rec.Code, rec.Body)
+	}
+	var snapshot struct {
+		Dir string `json:"dir"`
+		backup.Manifest
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &snapshot); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	if snapshot.Log != "telemetry.log" || snapshot.Encryption == nil || snapshot.Encryption.KeyID != s.encryptor.KeyID() {
+		t.Errorf("snapshot = %+v, want the log and its key ID", snapshot)
+	}
+
+	send(3)
+	if err := s.FlushNow(); err != nil {
+		t.Fatalf("FlushNow() error = %v", err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "backup")
+	if _, err := backup.Copy(snapshot.Dir, dest); err != nil {
+		t.Fatalf("Copy() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "telemetry.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("backed up log holds %d entries, want the 2 sent before the snapshot", lines)
+	}
+}
+
+// standbyClient calls a standby sink's Replicate directly, failing while down is set.
+type standbyClient struct {
+	pb.TelemetryServiceClient
+	standby *SinkServer
+	down    atomic.Bool
+}
+
+func (c *standbyClient) Replicate(ctx context.Context, req *pb.ReplicationRequest, _ ...grpc.CallOption) (*pb.ReplicationResponse, error) {
+	if c.down.Load() {
+		return nil, status.Error(codes.Unavailable, "standby down")
+	}
+	return c.standby.Replicate(asPeer(ctx, "sink-a"), req)
+}
+
+// asPeer returns ctx as of a call from a client whose certificate names identity.
+func asPeer(ctx context.Context, identity string) context.Context {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: identity}}
+	return peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}}})
+}
+
+func TestReplication(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	newSink := func(cfg config.Config) *SinkServer {
+		t.Helper()
+		cfg.BufferSize, cfg.RateLimit, cfg.Output = 1<<16, 1<<20, config.OutputLog
+		cfg.EnableEncryption, cfg.EncryptionKey, cfg.EncryptionCipher = true, key, encryption.CipherAESGCM
+		cfg.EncryptionMode = config.EncryptionModeEntry
+		s, err := NewSinkServer(cfg)
+		if err != nil {
+			t.Fatalf("NewSinkServer() error = %v", err)
+		}
+		t.Cleanup(s.Close)
+		return s
+	}
+
+	standbyLog := filepath.Join(t.TempDir(), "telemetry.log")
+	standby := newSink(config.Config{LogFilePath: standbyLog, AcceptReplication: true, ReplicationPeers: []string{"sink-a"}})
+	primary := newSink(config.Config{
+		LogFilePath:         filepath.Join(t.TempDir(), "telemetry.log"),
+		ReplicaAddr:         "localhost:1",
+		ReplicaAck:          config.ReplicaAckBoth,
+		ReplicaTimeout:      5 * time.Second,
+		ReplicaJournalQuota: 1 << 30,
+	})
+	client := &standbyClient{standby: standby}
+	client.down.Store(true)
+	primary.replicator.client = client
+
+	primary.wg.Add(1)
+	go primary.supervise("replication", primary.runReplication)
+	defer func() {
+		primary.Stop()
+		primary.wg.Wait()
+	}()
+
+	send := func(ctx context.Context, value int32) error {
+		_, err := primary.SendSensorData(ctx, &pb.SensorData{SensorName: "temp", SensorValue: value, Timestamp: timestamppb.Now()})
+		return err
+	}
+
+	// While the standby is down, acknowledging on both sinks holds the reading back.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := send(ctx, 1); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("SendSensorData() with the standby down = %v, want DeadlineExceeded", err)
+	}
+
+	// Once it is back, the journal catches it up with the first reading too.
+	client.down.Store(false)
+	if err := send(context.Background(), 2); err != nil {
+		t.Fatalf("SendSensorData() error = %v", err)
+	}
+	standby.bufferMutex.Lock()
+	entries, buffered := standby.buffered.entries, string(standby.buffer)
+	standby.bufferMutex.Unlock()
+	if entries != 2 {
+		t.Errorf("standby buffered %d entries, want 2", entries)
+	}
+	if strings.Contains(buffered, "temp") {
+		t.Error("standby stores replicated entries in the clear")
+	}
+
+	// Resent batches are skipped.
+	first, batches, err := primary.replicator.journal.Read(0, 1<<20)
+	if err != nil || len(batches) != 0 {
+		t.Fatalf("journal holds %d batches from %d after acknowledgement, %v", len(batches), first, err)
+	}
+	resp, err := standby.Replicate(asPeer(context.Background(), "sink-a"), &pb.ReplicationRequest{
+		JournalId:     primary.replicator.journal.ID(),
+		FirstSequence: 2,
+		Batches:       [][]byte{[]byte(`{"sensor_name":"temp"}` + "\n")},
+	})
+	if err != nil || resp.Applied != 2 {
+		t.Errorf("Replicate() of a resent batch = %v, %v, want applied 2", resp, err)
+	}
+	standby.bufferMutex.Lock()
+	entries = standby.buffered.entries
+	standby.bufferMutex.Unlock()
+	if entries != 2 {
+		t.Errorf("standby buffered %d entries after a resent batch, want 2", entries)
+	}
+
+	// A restarted standby remembers what it applied.
+	if err := standby.FlushNow(); err != nil {
+		t.Fatalf("FlushNow() error = %v", err)
+	}
+	restarted := newSink(config.Config{LogFilePath: standbyLog, AcceptReplication: true, ReplicationPeers: []string{"sink-a"}})
+	resp, err = restarted.Replicate(asPeer(context.Background(), "sink-a"), &pb.ReplicationRequest{JournalId: primary.replicator.journal.ID()})
+	if err != nil || resp.Applied != 2 {
+		t.Errorf("Replicate() position after restart = %v, %v, want applied 2", resp, err)
+	}
+
+	// Only the replication peers may replicate, and only with their certificate.
+	if _, err := restarted.Replicate(metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret")), &pb.ReplicationRequest{JournalId: "x"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Replicate() without a client certificate = %v, want Unauthenticated", err)
+	}
+	if _, err := restarted.Replicate(asPeer(context.Background(), "sensor-7"), &pb.ReplicationRequest{JournalId: "x"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Replicate() from a sensor's certificate = %v, want PermissionDenied", err)
+	}
+
+	if _, err := primary.Replicate(context.Background(), &pb.ReplicationRequest{JournalId: "x"}); status.Code(err) != codes.Unimplemented {
+		t.Errorf("Replicate() on a sink not accepting replication = %v, want Unimplemented", err)
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	lockFile := filepath.Join(t.TempDir(), "sink.leader")
+	newSink := func(addr string) *SinkServer {
+		t.Helper()
+		s, err := NewSinkServer(config.Config{
+			LogFilePath:        filepath.Join(t.TempDir(), "telemetry.log"),
+			BufferSize:         4096,
+			RateLimit:          1 << 20,
+			LeaderLockFile:     lockFile,
+			AdvertiseAddr:      addr,
+			LeaderPollInterval: time.Second,
+		})
+		if err != nil {
+			t.Fatalf("NewSinkServer() error = %v", err)
+		}
+		return s
+	}
+	send := func(s *SinkServer) error {
+		_, err := s.SendSensorData(context.Background(), &pb.SensorData{SensorName: "temp", SensorValue: 1, Timestamp: timestamppb.Now()})
+		return err
+	}
+
+	active := newSink("sink-a:9090")
+	standby := newSink("sink-b:9090")
+	defer standby.Close()
+
+	if err := send(active); err != nil {
+		t.Errorf("SendSensorData() to the leader error = %v", err)
+	}
+	err := send(standby)
+	if status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), "sink-a:9090") {
+		t.Errorf("SendSensorData() to the standby = %v, want Unavailable naming the leader", err)
+	}
+	if got := redirectDetail(err); got == nil || !slices.Equal(got.Endpoints, []string{"sink-a:9090"}) {
+		t.Errorf("SendSensorData() to the standby redirects to %v, want the leader", got)
+	}
+	if !errors.Is(err, errdefs.ErrNotLeader) {
+		t.Errorf("SendSensorData() to the standby = %v, want ErrNotLeader", err)
+	}
+	standby.serving.Store(true)
+	if err := standby.ready(); err == nil || err.Error() != "standby" {
+		t.Errorf("ready() of the standby = %v, want standby", err)
+	}
+
+	// The standby takes over once the leader's lock is released.
+	active.Close()
+	standby.campaign()
+	if err := send(standby); err != nil {
+		t.Errorf("SendSensorData() after takeover error = %v", err)
+	}
+	if err := standby.ready(); err != nil {
+		t.Errorf("ready() after takeover = %v", err)
+	}
+}
+
+// redirectDetail returns the Redirect detail of a status error, nil if it has none.
+func redirectDetail(err error) *pb.Redirect {
+	for _, detail := range status.Convert(err).Details() {
+		if redirect, ok := detail.(*pb.Redirect); ok {
+			return redirect
+		}
+	}
+	return nil
+}
+
+func TestRedirect(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath: filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:  4096,
+		RateLimit:   1 << 20,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+	admin := s.adminHandler()
+
+	send := func() *pb.SensorDataResponse {
+		t.Helper()
+		resp, err := s.SendSensorData(context.Background(), &pb.SensorData{SensorName: "temp", SensorValue: 1, Timestamp: timestamppb.Now()})
+		if err != nil {
+			t.Fatalf("SendSensorData() error = %v", err)
+		}
+		return resp
+	}
+	if resp := send(); resp.Redirect != nil {
+		t.Errorf("response redirects to %v before an operator asked to", resp.Redirect.Endpoints)
+	}
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/redirect?to=sink-b:9090,bad", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT /redirect with an invalid endpoint = %d, want 400", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/redirect?to=sink-b:9090,sink-c:9090", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT /redirect = %d %s, want 200", rec.Code, rec.Body)
+	}
+	if resp := send(); resp.Redirect == nil || !slices.Equal(resp.Redirect.Endpoints, []string{"sink-b:9090", "sink-c:9090"}) {
+		t.Errorf("response redirect = %v, want sink-b and sink-c", resp.Redirect)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/redirect", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE /redirect = %d, want 204", rec.Code)
+	}
+	if resp := send(); resp.Redirect != nil {
+		t.Errorf("response redirects to %v after the redirect was cleared", resp.Redirect.Endpoints)
+	}
+}
+
+// trailerStream records the trailers a handler sets.
+type trailerStream struct {
+	grpc.ServerTransportStream
+	trailer metadata.MD
+}
+
+func (s *trailerStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+func TestQuotaTrailers(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:     filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:      4096,
+		RateLimit:       1 << 20,
+		SensorRateLimit: 1000,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	call := func(req any, handler grpc.UnaryHandler) metadata.MD {
+		t.Helper()
+		stream := &trailerStream{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		s.quotaUnary(ctx, req, &grpc.UnaryServerInfo{}, handler)
+		return stream.trailer
+	}
+
+	reading := &pb.SensorData{SensorName: "temp", SensorValue: 1, Timestamp: timestamppb.Now()}
+	size := proto.Size(reading)
+	trailer := call(reading, func(ctx context.Context, req any) (any, error) {
+		return s.SendSensorData(ctx, req.(*pb.SensorData))
+	})
+	value := func(key string) int {
+		t.Helper()
+		values := trailer.Get(key)
+		if len(values) != 1 {
+			t.Fatalf("trailer %s = %v, want one value", key, values)
+		}
+		n, err := strconv.Atoi(values[0])
+		if err != nil {
+			t.Fatalf("trailer %s = %q: %v", key, values[0], err)
+		}
+		return n
+	}
+	// The sensor's quota is the tightest.
+	if limit := value(quotaLimitKey); limit != 1000 {
+		t.Errorf("limit = %d, want the sensor's 1000", limit)
+	}
+	remaining := value(quotaRemainingKey)
+	if remaining < 1000-size || remaining >= 1000 {
+		t.Errorf("remaining = %d, want about %d after a %d byte reading", remaining, 1000-size, size)
+	}
+	if messages := value(quotaRemainingMessagesKey); messages != remaining/size {
+		t.Errorf("remaining messages = %d, want %d", messages, remaining/size)
+	}
+	if reset := value(quotaResetKey); reset <= 0 || reset > 1000 {
+		t.Errorf("reset = %dms, want under a second", reset)
+	}
+
+	trailer = call(&pb.HeartbeatRequest{}, func(ctx context.Context, req any) (any, error) {
+		return &pb.HeartbeatResponse{}, nil
+	})
+	if len(trailer) != 0 {
+		t.Errorf("heartbeat trailers = %v, want none", trailer)
+	}
+}
+
+func TestUpgrade_KeyFromEnvironment(t *testing.T) {
+	s, err := NewSinkServer(config.Config{
+		LogFilePath:          filepath.Join(t.TempDir(), "telemetry.log"),
+		BufferSize:           1024,
+		EncryptionKeyFromEnv: true,
+	})
+	if err != nil {
+		t.Fatalf("NewSinkServer() error = %v", err)
+	}
+	defer s.Close()
+
+	if err := s.Upgrade(); err == nil || !strings.Contains(err.Error(), "ENCRYPTION_KEY") {
+		t.Errorf("Upgrade() = %v, want a refusal naming ENCRYPTION_KEY", err)
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"github.com/sink/backup"
+	"github.com/sink/config"
+	"github.com/sink/storage"
+)
+
+// Snapshot hands the current buffer to the writer, waits until everything queued is
+// on disk and hard-links the log's files into a new directory next to it, for
+// sinkctl backup to copy. Readings wait for the buffer while this runs, so the
+// snapshot holds every entry acknowledged before it and none after.
+func (s *SinkServer) Snapshot() (string, *backup.Manifest, error) {
+	if !logOutput(s.config) {
+		return "", nil, fmt.Errorf("snapshots need -output=%s", config.OutputLog)
+	}
+
+	s.bufferMutex.Lock()
+	defer s.bufferMutex.Unlock()
+	if s.buffer == nil {
+		return "", nil, fmt.Errorf("sink is closed")
+	}
+	if len(s.buffer) > 0 {
+		s.writer.Enqueue(s.buffer)
+		s.buffer = s.writer.NewBuffer()
+		s.buffered = bufferCount{}
+	}
+	if err := s.writer.Sync(); err != nil {
+		return "", nil, err
+	}
+
+	now := s.now()
+	m := &backup.Manifest{Created: now, Format: s.config.LogFormat}
+	if fields := s.entryFormat.fields; fields != nil {
+		m.Fields, m.TimeFormat = s.config.LogFields, fields.TimeFormat()
+	}
+	if s.encryptor != nil {
+		m.Encryption = &backup.Encryption{Cipher: s.config.EncryptionCipher, Mode: s.config.EncryptionMode, KeyID: s.encryptor.KeyID()}
+	}
+	dir, err := filepath.Abs(filepath.Join(filepath.Dir(s.config.LogFilePath), ".snapshot-"+now.Format(storage.SegmentTimeFormat)))
+	if err != nil {
+		return "", nil, err
+	}
+	if err := backup.Snapshot(s.config.LogFilePath, dir, m); err != nil {
+		return "", nil, err
+	}
+	return dir, m, nil
+}
+
+// handleSnapshot takes a snapshot of the log for sinkctl backup and describes it as
+// JSON: its directory and manifest.
+func (s *SinkServer) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	dir, m, err := s.Snapshot()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Admin: snapshot of the log in %s", dir)
+
+	w.Header().Set("Content-Type", "application/json")
+	snapshot := struct {
+		Dir string `json:"dir"`
+		*backup.Manifest
+	}{dir, m}
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		log.Printf("Admin: encode snapshot: %v", err)
+	}
+}
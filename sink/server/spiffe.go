@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// spiffeFetchTimeout bounds how long startup waits for the first SVID and bundle.
+const spiffeFetchTimeout = 30 * time.Second
+
+// newX509Source connects to the SPIRE Workload API and waits for the initial SVID and
+// trust bundle. The source keeps both up to date as SPIRE rotates them.
+func newX509Source(socketPath string) (*workloadapi.X509Source, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), spiffeFetchTimeout)
+	defer cancel()
+
+	source, err := workloadapi.NewX509Source(ctx, workloadapi.WithClientOptions(workloadapi.WithAddr(socketPath)))
+	if err != nil {
+		return nil, fmt.Errorf("fetch X509-SVID from workload API: %w", err)
+	}
+
+	return source, nil
+}
+
+// spiffeTLSConfig serves the sink's own SVID and verifies client SVIDs against the
+// trust bundle, optionally restricting clients to a single trust domain.
+func (s *SinkServer) spiffeTLSConfig() (*tls.Config, error) {
+	authorizer := tlsconfig.AuthorizeAny()
+	if s.config.SpiffeTrustDomain != "" {
+		td, err := spiffeid.TrustDomainFromString(s.config.SpiffeTrustDomain)
+		if err != nil {
+			return nil, fmt.Errorf("parse trust domain: %w", err)
+		}
+		authorizer = tlsconfig.AuthorizeMemberOf(td)
+	}
+
+	return tlsconfig.MTLSServerConfig(s.x509Source, s.x509Source, authorizer), nil
+}
+
+// clientIdentity returns the SPIFFE ID of the client certificate if it carries one,
+// and the subject common name otherwise.
+func clientIdentity(cert *x509.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+	if id, err := x509svid.IDFromCert(cert); err == nil {
+		return id.String()
+	}
+	return cert.Subject.CommonName
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/sink/listener"
+)
+
+// upgradeReadyTimeout bounds how long the old process waits for the new one to serve.
+const upgradeReadyTimeout = 30 * time.Second
+
+// Upgrade starts a new sink process from the current binary path and hands it the
+// listening socket. Once the new process reports it is serving, this process stops
+// accepting, drains in-flight requests and final-flushes as in a normal shutdown.
+// Connections arriving meanwhile queue on the shared socket instead of being refused.
+// If the new process fails to become ready, it is killed and this process keeps serving.
+func (s *SinkServer) Upgrade() error {
+	if s.config.EncryptionKeyFromEnv {
+		return fmt.Errorf("the encryption key came from ENCRYPTION_KEY, which is cleared so child processes don't inherit it; use -encryption-key-file to upgrade in place")
+	}
+
+	s.listenerMutex.Lock()
+	lis := s.listener
+	s.listenerMutex.Unlock()
+	if lis == nil {
+		return fmt.Errorf("server is not listening")
+	}
+
+	file, err := listener.File(lis)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("create ready pipe: %w", err)
+	}
+	defer readyReader.Close()
+
+	// ExtraFiles[i] becomes file descriptor 3+i in the child.
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{file, readyWriter}
+	cmd.Env = append(os.Environ(), listener.InheritedFdEnv+"=3", listener.ReadyFdEnv+"=4")
+
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return fmt.Errorf("start new process: %w", err)
+	}
+	log.Printf("Started upgraded sink process %d, waiting for it to become ready", cmd.Process.Pid)
+
+	ready := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := readyReader.Read(buf)
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(upgradeReadyTimeout):
+		err = fmt.Errorf("timed out after %v", upgradeReadyTimeout)
+	}
+
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return fmt.Errorf("upgraded process did not become ready: %w", err)
+	}
+
+	log.Printf("Upgraded sink process %d is serving, draining this process", cmd.Process.Pid)
+	go cmd.Process.Release()
+	s.Stop()
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/sink/proto"
+)
+
+// validateRequest checks a reading against the payload limits. Violations are
+// reported as InvalidArgument with a BadRequest detail listing every offending
+// field, so clients can tell a bad payload from a transient failure.
+func (s *SinkServer) validateRequest(req *pb.SensorData) error {
+	var v violations
+	s.checkSensorName(&v, req.SensorName)
+	checkTimestamp(&v, req.Timestamp, true)
+	s.checkTags(&v, "tags", req.Tags)
+	s.checkValues(&v, req.Values)
+	if maxField := s.config.MaxFieldSize; maxField > 0 && len(req.Unit) > maxField {
+		v.add("unit", "unit is %d bytes, limit is %d", len(req.Unit), maxField)
+	}
+	if req.Aggregate != nil {
+		checkAggregate(&v, req)
+	}
+	if req.Location != nil {
+		checkLocation(&v, req.Location)
+	}
+	if len(req.Attachment) > 0 {
+		s.checkAttachment(&v, req.Attachment)
+	}
+	return v.err("invalid reading")
+}
+
+// validateEvent applies the payload limits to an event; attributes are bounded like
+// tags.
+func (s *SinkServer) validateEvent(req *pb.Event) error {
+	var v violations
+	s.checkSensorName(&v, req.SensorName)
+	checkTimestamp(&v, req.Timestamp, true)
+	if req.Message == "" {
+		v.add("message", "message is required")
+	}
+	if _, ok := pb.Severity_name[int32(req.Severity)]; !ok {
+		v.add("severity", "unknown severity %d", req.Severity)
+	}
+	s.checkTags(&v, "attributes", req.Attributes)
+	return v.err("invalid event")
+}
+
+// validateRegistration applies the payload limits to a registration; metadata is
+// bounded like tags.
+func (s *SinkServer) validateRegistration(req *pb.RegisterSensorRequest) error {
+	var v violations
+	s.checkSensorName(&v, req.SensorName)
+	s.checkTags(&v, "metadata", req.Metadata)
+	return v.err("invalid registration")
+}
+
+// validateHeartbeat applies the payload limits to a heartbeat, whose timestamp is
+// optional.
+func (s *SinkServer) validateHeartbeat(req *pb.HeartbeatRequest) error {
+	var v violations
+	s.checkSensorName(&v, req.SensorName)
+	checkTimestamp(&v, req.Timestamp, false)
+	return v.err("invalid heartbeat")
+}
+
+type violations []*errdetails.BadRequest_FieldViolation
+
+func (v *violations) add(field, format string, args ...any) {
+	*v = append(*v, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v violations) err(what string) error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	st := status.New(codes.InvalidArgument, fmt.Sprintf("%s: %s", what, v[0].Description))
+	if detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: v}); err == nil {
+		st = detailed
+	} else {
+		log.Printf("failed to attach error details: %v", err)
+	}
+	return st.Err()
+}
+
+func (s *SinkServer) checkSensorName(v *violations, name string) {
+	maxField := s.config.MaxFieldSize
+
+	switch {
+	case name == "":
+		v.add("sensor_name", "sensor name is required")
+	case maxField > 0 && len(name) > maxField:
+		v.add("sensor_name", "sensor name is %d bytes, limit is %d", len(name), maxField)
+	}
+}
+
+// checkTimestamp rejects timestamps outside the years 1 to 9999, which can't be
+// stored, including nanoseconds out of range.
+func checkTimestamp(v *violations, ts *timestamppb.Timestamp, required bool) {
+	if ts == nil {
+		if required {
+			v.add("timestamp", "timestamp is required")
+		}
+		return
+	}
+	if err := ts.CheckValid(); err != nil {
+		v.add("timestamp", "timestamp is invalid: %v", err)
+	}
+}
+
+func (s *SinkServer) checkTags(v *violations, field string, tags map[string]string) {
+	maxField := s.config.MaxFieldSize
+
+	if s.config.MaxTags > 0 && len(tags) > s.config.MaxTags {
+		v.add(field, "%d %s, limit is %d", len(tags), field, s.config.MaxTags)
+	} else if maxField > 0 {
+		for key, value := range tags {
+			if len(key) > maxField {
+				v.add(field, "key %.32q... is %d bytes, limit is %d", key, len(key), maxField)
+			}
+			if len(value) > maxField {
+				v.add(field, "value of %.32q is %d bytes, limit is %d", key, len(value), maxField)
+			}
+		}
+	}
+}
+
+// checkValues rejects unnamed values and values that can't be stored, such as NaN.
+func (s *SinkServer) checkValues(v *violations, values map[string]float64) {
+	maxField := s.config.MaxFieldSize
+
+	for name, value := range values {
+		switch {
+		case name == "":
+			v.add("values", "value name is required")
+		case maxField > 0 && len(name) > maxField:
+			v.add("values", "name %.32q... is %d bytes, limit is %d", name, len(name), maxField)
+		case math.IsNaN(value) || math.IsInf(value, 0):
+			v.add("values", "value of %q is %v", name, value)
+		}
+	}
+}
+
+// checkAggregate rejects aggregates that can't be stored or contradict themselves:
+// buckets out of order or holding more samples than the count.
+func checkAggregate(v *violations, req *pb.SensorData) {
+	a := req.Aggregate
+	if len(req.Values) > 0 {
+		v.add("aggregate", "aggregate can't be combined with values")
+	}
+	if math.IsNaN(a.Sum) || math.IsInf(a.Sum, 0) {
+		v.add("aggregate", "sum is %v", a.Sum)
+	}
+
+	var samples uint64
+	for i, b := range a.Buckets {
+		switch {
+		case math.IsNaN(b.UpperBound) || math.IsInf(b.UpperBound, 0):
+			v.add("aggregate", "bucket %d upper bound is %v", i, b.UpperBound)
+			return
+		case i > 0 && b.UpperBound <= a.Buckets[i-1].UpperBound:
+			v.add("aggregate", "bucket %d upper bound %v is not above %v", i, b.UpperBound, a.Buckets[i-1].UpperBound)
+			return
+		}
+		samples += b.Count
+		if samples < b.Count || samples > a.Count {
+			v.add("aggregate", "buckets hold more samples than the count of %d", a.Count)
+			return
+		}
+	}
+}
+
+// checkLocation rejects coordinates outside their ranges, including NaN.
+func checkLocation(v *violations, l *pb.Location) {
+	if !(l.Latitude >= -90 && l.Latitude <= 90) {
+		v.add("location", "latitude %v is outside -90 to 90", l.Latitude)
+	}
+	if !(l.Longitude >= -180 && l.Longitude <= 180) {
+		v.add("location", "longitude %v is outside -180 to 180", l.Longitude)
+	}
+	if l.Altitude != nil && (math.IsNaN(*l.Altitude) || math.IsInf(*l.Altitude, 0)) {
+		v.add("location", "altitude is %v", *l.Altitude)
+	}
+}
+
+// checkAttachment enforces MaxAttachmentSize, which unlike the other limits rejects
+// attachments when 0 rather than disabling the check.
+func (s *SinkServer) checkAttachment(v *violations, attachment []byte) {
+	switch limit := s.config.MaxAttachmentSize; {
+	case limit == 0:
+		v.add("attachment", "attachments are not accepted")
+	case len(attachment) > limit:
+		v.add("attachment", "attachment is %d bytes, limit is %d", len(attachment), limit)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"log"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sink/config"
+	"github.com/sink/metrics"
+	"github.com/sink/watchdog"
+)
+
+// watchdogDumpInterval limits diagnostics dumps, which are large, during a long overload.
+const watchdogDumpInterval = 10 * time.Minute
+
+// watchdogState is what the watchdog remembers between checks.
+type watchdogState struct {
+	tripped  bool
+	lastDump time.Time
+}
+
+// runWatchdog checks the sink's load every WatchdogInterval until Stop is called.
+func (s *SinkServer) runWatchdog() {
+	ticker := s.config.Clock.NewTicker(s.config.WatchdogInterval)
+	defer ticker.Stop()
+
+	var state watchdogState
+	for {
+		select {
+		case <-ticker.C():
+			s.checkWatchdog(&state)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *SinkServer) watchdogStats() watchdog.Stats {
+	s.bufferMutex.Lock()
+	bufferLen := len(s.buffer)
+	s.bufferMutex.Unlock()
+
+	return watchdog.Stats{
+		BufferLen:    bufferLen,
+		BufferSize:   s.config.BufferSize,
+		Queued:       s.writer.QueueLen(),
+		FlushLatency: s.writer.Latency(),
+		Goroutines:   runtime.NumGoroutine(),
+	}
+}
+
+// checkWatchdog compares the load with the thresholds and takes the configured
+// actions while any is crossed.
+func (s *SinkServer) checkWatchdog(state *watchdogState) {
+	thresholds := watchdog.Thresholds{
+		MaxQueued:       s.config.WatchdogMaxQueued,
+		MaxFlushLatency: s.config.WatchdogMaxFlushLatency,
+		MaxGoroutines:   s.config.WatchdogMaxGoroutines,
+	}
+	violations := thresholds.Check(s.watchdogStats())
+
+	if len(violations) == 0 {
+		if state.tripped {
+			log.Println("Watchdog: load back within thresholds")
+			state.tripped = false
+		}
+		s.overloaded.Store(nil)
+		metrics.Overloaded.Set(0)
+		return
+	}
+
+	details := make([]string, 0, len(violations))
+	for _, v := range violations {
+		metrics.WatchdogViolations.WithLabelValues(v.Check).Inc()
+		details = append(details, v.Detail)
+	}
+	reason := strings.Join(details, "; ")
+
+	first := !state.tripped
+	state.tripped = true
+	if first {
+		log.Printf("Watchdog: %s", reason)
+	}
+
+	actions := s.config.WatchdogActions
+	if slices.Contains(actions, config.WatchdogShed) {
+		s.overloaded.Store(&reason)
+		metrics.Overloaded.Set(1)
+	}
+	if slices.Contains(actions, config.WatchdogFlush) && slices.ContainsFunc(violations, func(v watchdog.Violation) bool {
+		return v.Check == watchdog.CheckBuffer
+	}) {
+		if err := s.lockedFlush(); err != nil {
+			log.Printf("Watchdog: failed to flush buffer: %v", err)
+		}
+	}
+	if slices.Contains(actions, config.WatchdogDump) && first {
+		now := s.now()
+		if state.lastDump.IsZero() || now.Sub(state.lastDump) >= watchdogDumpInterval {
+			state.lastDump = now
+			paths, err := watchdog.Dump(s.config.WatchdogDumpDir, now)
+			if err != nil {
+				log.Printf("Watchdog: failed to write diagnostics: %v", err)
+			} else {
+				log.Printf("Watchdog: wrote diagnostics %s", strings.Join(paths, ", "))
+			}
+		}
+	}
+}
+
+// lockedFlush flushes the buffer under bufferMutex, which is released even if the
+// flush panics.
+func (s *SinkServer) lockedFlush() error {
+	s.bufferMutex.Lock()
+	defer s.bufferMutex.Unlock()
+	return s.flushBuffer()
+}
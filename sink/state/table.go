@@ -0,0 +1,144 @@
+// Package state bounds the memory used for per-sensor state. Every component keeping
+// something per sensor, tenant or client stores it in a Table, which evicts entries
+// that have been idle too long and caps how many entries exist at once, so a flood
+// of random sensor names can't exhaust the sink's memory.
+package state
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/sink/metrics"
+)
+
+// DefaultMaxEntries is the cap used by tables of components that don't configure one.
+const DefaultMaxEntries = 100000
+
+// Eviction reasons reported in metrics.
+const (
+	ReasonIdle = "idle"
+	ReasonCap  = "cap"
+)
+
+// Limits bound a table. Zero values disable the respective limit.
+type Limits struct {
+	TTL        time.Duration // entries unused for longer are evicted
+	MaxEntries int           // the least recently used entry is evicted beyond this
+}
+
+// Table maps keys to values, tracking when each entry was last used. It is not safe
+// for concurrent use; owners guard it with their own lock.
+type Table[K comparable, V any] struct {
+	name    string
+	limits  Limits
+	entries map[K]*list.Element
+	order   *list.List // most recently used first
+}
+
+type item[K comparable, V any] struct {
+	key      K
+	value    V
+	lastUsed time.Time
+}
+
+// NewTable creates a table. The name labels its metrics; tables of the same kind
+// may share a name.
+func NewTable[K comparable, V any](name string, limits Limits) *Table[K, V] {
+	return &Table[K, V]{
+		name:    name,
+		limits:  limits,
+		entries: make(map[K]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get returns the value for key and marks it used at now.
+func (t *Table[K, V]) Get(key K, now time.Time) (V, bool) {
+	el, ok := t.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	t.touch(el, now)
+	return el.Value.(*item[K, V]).value, true
+}
+
+// GetOrCreate returns the value for key, creating it with create if it doesn't
+// exist, and marks it used at now. Creating an entry first evicts idle entries and,
+// if the table is full, the least recently used one.
+func (t *Table[K, V]) GetOrCreate(key K, now time.Time, create func() V) V {
+	if value, ok := t.Get(key, now); ok {
+		return value
+	}
+
+	t.Sweep(now)
+	if t.limits.MaxEntries > 0 && len(t.entries) >= t.limits.MaxEntries {
+		t.evict(t.order.Back(), ReasonCap)
+	}
+
+	value := create()
+	t.entries[key] = t.order.PushFront(&item[K, V]{key: key, value: value, lastUsed: now})
+	metrics.StateEntries.WithLabelValues(t.name).Inc()
+	return value
+}
+
+// Delete removes key from the table.
+func (t *Table[K, V]) Delete(key K) {
+	if el, ok := t.entries[key]; ok {
+		t.remove(el)
+	}
+}
+
+// Len returns the number of entries.
+func (t *Table[K, V]) Len() int {
+	return len(t.entries)
+}
+
+// Range calls fn for every entry, most recently used first, until fn returns false.
+// It does not mark entries used.
+func (t *Table[K, V]) Range(fn func(key K, value V) bool) {
+	for el := t.order.Front(); el != nil; el = el.Next() {
+		it := el.Value.(*item[K, V])
+		if !fn(it.key, it.value) {
+			return
+		}
+	}
+}
+
+// Sweep evicts the entries idle for longer than the TTL at now and returns how many
+// it evicted.
+func (t *Table[K, V]) Sweep(now time.Time) int {
+	if t.limits.TTL <= 0 {
+		return 0
+	}
+
+	evicted := 0
+	for el := t.order.Back(); el != nil; el = t.order.Back() {
+		if now.Sub(el.Value.(*item[K, V]).lastUsed) <= t.limits.TTL {
+			break
+		}
+		t.evict(el, ReasonIdle)
+		evicted++
+	}
+	return evicted
+}
+
+func (t *Table[K, V]) touch(el *list.Element, now time.Time) {
+	it := el.Value.(*item[K, V])
+	if now.After(it.lastUsed) {
+		it.lastUsed = now
+	}
+	t.order.MoveToFront(el)
+}
+
+func (t *Table[K, V]) evict(el *list.Element, reason string) {
+	t.remove(el)
+	metrics.StateEvictions.WithLabelValues(t.name, reason).Inc()
+}
+
+func (t *Table[K, V]) remove(el *list.Element) {
+	t.order.Remove(el)
+	delete(t.entries, el.Value.(*item[K, V]).key)
+	metrics.StateEntries.WithLabelValues(t.name).Dec()
+}
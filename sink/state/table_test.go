@@ -0,0 +1,88 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTable(t *testing.T) {
+	start := time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		limits Limits
+		ops    []string // keys used, one per second
+		sweep  time.Duration
+		want   []string // remaining keys, most recently used first
+	}{
+		{
+			name: "unlimited",
+			ops:  []string{"a", "b", "c"},
+			want: []string{"c", "b", "a"},
+		},
+		{
+			name:   "cap evicts least recently used",
+			limits: Limits{MaxEntries: 2},
+			ops:    []string{"a", "b", "a", "c"},
+			want:   []string{"c", "a"},
+		},
+		{
+			name:   "ttl evicts idle entries on insert",
+			limits: Limits{TTL: 2 * time.Second},
+			ops:    []string{"a", "b", "c", "b", "d"},
+			want:   []string{"d", "b", "c"},
+		},
+		{
+			name:   "sweep",
+			limits: Limits{TTL: 2 * time.Second},
+			ops:    []string{"a", "b", "c"},
+			sweep:  4 * time.Second,
+			want:   []string{"c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := NewTable[string, int]("test", tt.limits)
+			for i, key := range tt.ops {
+				table.GetOrCreate(key, start.Add(time.Duration(i)*time.Second), func() int { return i })
+			}
+			if tt.sweep > 0 {
+				table.Sweep(start.Add(tt.sweep))
+			}
+
+			var got []string
+			table.Range(func(key string, _ int) bool {
+				got = append(got, key)
+				return true
+			})
+			if len(got) != len(tt.want) || table.Len() != len(tt.want) {
+				t.Fatalf("keys = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("keys = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestTable_GetOrCreateKeepsValue(t *testing.T) {
+	table := NewTable[string, *int]("test", Limits{})
+	now := time.Now()
+
+	first := table.GetOrCreate("a", now, func() *int { return new(int) })
+	*first = 5
+	second := table.GetOrCreate("a", now, func() *int { return new(int) })
+
+	if second != first || *second != 5 {
+		t.Errorf("GetOrCreate() = %v, want the existing value", *second)
+	}
+
+	table.Delete("a")
+	if _, ok := table.Get("a", now); ok {
+		t.Error("Get() after Delete() found the key")
+	}
+}
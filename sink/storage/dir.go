@@ -0,0 +1,13 @@
+package storage
+
+import "os"
+
+// SyncDir makes renames and new files in dir durable.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// SegmentTimeFormat is the UTC time, of its rotation, that a segment's name ends in.
+// Names sort in the order segments were written.
+const SegmentTimeFormat = "20060102T150405.000000000"
+
+// SegmentPath returns the name a log at path is rotated to at t.
+func SegmentPath(path string, t time.Time) string {
+	return path + "." + t.UTC().Format(SegmentTimeFormat)
+}
+
+// SegmentedFile is a log file rotated into segments: once a write takes it to
+// maxSize bytes, it is renamed to SegmentPath and a new file is started. Writes are
+// never split, so segments end at buffer boundaries and hold whole entries.
+type SegmentedFile struct {
+	path    string
+	maxSize int64
+
+	file *os.File
+	size int64
+}
+
+// OpenSegmentedFile opens path for appending, rotating it into segments of about
+// maxSize bytes.
+func OpenSegmentedFile(path string, maxSize int64) (*SegmentedFile, error) {
+	f := &SegmentedFile{path: path, maxSize: maxSize}
+	if err := f.open(); err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return f, nil
+}
+
+func (f *SegmentedFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *SegmentedFile) Write(p []byte) (int, error) {
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	// The data is written either way; a failed rotation is retried on the next write.
+	if f.size >= f.maxSize {
+		if err := f.rotate(); err != nil {
+			log.Printf("Failed to rotate log file: %v", err)
+		}
+	}
+	return n, nil
+}
+
+// rotate renames the log to a new segment and starts a new log. Until that succeeds,
+// writes continue to the old file.
+func (f *SegmentedFile) rotate() error {
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	// A coarse clock may give two rotations the same time; a segment is never replaced.
+	t := time.Now()
+	segment := SegmentPath(f.path, t)
+	for fileExists(segment) {
+		t = t.Add(time.Nanosecond)
+		segment = SegmentPath(f.path, t)
+	}
+
+	old := f.file
+	if err := os.Rename(f.path, segment); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func (f *SegmentedFile) Sync() error {
+	return f.file.Sync()
+}
+
+func (f *SegmentedFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSegmentedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "telemetry.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := OpenSegmentedFile(path, 10)
+	if err != nil {
+		t.Fatalf("OpenSegmentedFile() error = %v", err)
+	}
+	// The existing 4 bytes count towards the first segment.
+	for _, buf := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := f.Write([]byte(buf)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	segments, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(segments)
+	var got []string
+	for _, segment := range append(segments, path) {
+		data, err := os.ReadFile(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(data))
+	}
+	want := []string{"old\nfirst\n", "second\nthird\n", ""}
+	if !slices.Equal(got, want) {
+		t.Errorf("segments and log = %q, want %q", got, want)
+	}
+}
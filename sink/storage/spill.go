@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sink/metrics"
+)
+
+const (
+	spillSuffix = ".spill"
+
+	// maxPendingSpills bounds the spilled buffers held in memory until they are on
+	// disk, so a slow disk pushes back on callers instead of growing the heap.
+	maxPendingSpills = 4
+)
+
+var (
+	// errSpillFull is returned by push when a buffer would exceed the spill quota.
+	errSpillFull = errors.New("spill quota reached")
+	// errSpillBusy is returned by push while maxPendingSpills buffers wait for the disk.
+	errSpillBusy = errors.New("spill behind on disk")
+)
+
+// Spill is an on-disk queue for buffers the writer can't take in time, one file per
+// buffer in a directory, bounded by a quota. Files left by a previous run are
+// written first.
+//
+// Pushed buffers are written to disk on a goroutine of their own, so the callers,
+// which hold the sink's buffer lock, don't wait for the disk. Until a buffer is on
+// disk it is kept in memory, and peek returns it from there.
+type Spill struct {
+	dir   string
+	quota int64
+
+	mu      sync.Mutex
+	files   []*spillFile // oldest first
+	size    int64
+	next    uint64 // sequence number of the next file
+	pending int    // files not on disk yet
+
+	wake    chan struct{} // wakes the goroutine writing pending files
+	stop    chan struct{}
+	stopped chan struct{}
+	close   sync.Once
+}
+
+type spillFile struct {
+	name    string
+	size    int64
+	data    []byte // held until written to disk, nil once there
+	failed  bool   // writing it failed, so it stays in memory
+	removed bool   // popped while being written to disk
+}
+
+// OpenSpill creates dir if needed and picks up the buffers spilled to it before.
+// At most quota bytes are spilled at a time.
+func OpenSpill(dir string, quota int64) (*Spill, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create spill directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spill directory: %w", err)
+	}
+
+	s := &Spill{
+		dir:     dir,
+		quota:   quota,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Interrupted while being spilled, never acknowledged as stored.
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spillSuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(name, spillSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("read spill directory: %w", err)
+		}
+		s.files = append(s.files, &spillFile{name: name, size: info.Size()})
+		s.size += info.Size()
+		s.next = max(s.next, seq+1)
+	}
+	slices.SortFunc(s.files, func(a, b *spillFile) int { return strings.Compare(a.name, b.name) })
+	metrics.SpillBytes.Set(float64(s.size))
+
+	go s.persist()
+	return s, nil
+}
+
+// Close writes the buffers still held in memory to disk and stops the goroutine
+// writing them.
+func (s *Spill) Close() {
+	s.close.Do(func() { close(s.stop) })
+	<-s.stopped
+}
+
+// Len returns the number of spilled buffers.
+func (s *Spill) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.files)
+}
+
+// Bytes returns the size of the spilled buffers.
+func (s *Spill) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.size
+}
+
+// push appends data to the queue, taking ownership of it. It is written to disk in
+// the background.
+func (s *Spill) push(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.size+int64(len(data)) > s.quota {
+		return errSpillFull
+	}
+	if s.pending >= maxPendingSpills {
+		return errSpillBusy
+	}
+
+	name := fmt.Sprintf("%020d%s", s.next, spillSuffix)
+	s.next++
+	s.files = append(s.files, &spillFile{name: name, size: int64(len(data)), data: data})
+	s.size += int64(len(data))
+	s.pending++
+	metrics.SpillBytes.Set(float64(s.size))
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// persist writes pushed buffers to disk in order until the spill is closed, and
+// then the ones still pending.
+func (s *Spill) persist() {
+	defer close(s.stopped)
+
+	for {
+		for s.persistNext() {
+		}
+		select {
+		case <-s.wake:
+		case <-s.stop:
+			// Try the buffers that failed once more rather than lose them.
+			s.mu.Lock()
+			for _, file := range s.files {
+				file.failed = false
+			}
+			s.mu.Unlock()
+			for s.persistNext() {
+			}
+			return
+		}
+	}
+}
+
+// persistNext writes the oldest buffer held in memory to disk. It returns false when
+// there is none.
+func (s *Spill) persistNext() bool {
+	s.mu.Lock()
+	i := slices.IndexFunc(s.files, func(f *spillFile) bool { return f.data != nil && !f.failed })
+	if i < 0 {
+		s.mu.Unlock()
+		return false
+	}
+	file, data := s.files[i], s.files[i].data
+	s.mu.Unlock()
+
+	err := writeSpillFile(filepath.Join(s.dir, file.name), data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err != nil:
+		// The buffer stays in memory until the writer takes it.
+		log.Printf("Failed to write spill file, keeping the buffer in memory: %v", err)
+		file.failed = true
+	case file.removed:
+		// Written to the log meanwhile.
+		os.Remove(filepath.Join(s.dir, file.name))
+	default:
+		file.data = nil
+		s.pending--
+	}
+	return true
+}
+
+// writeSpillFile writes data to path through a synced temporary file, so a crash
+// never leaves a partial buffer to be written to the log, nor loses a complete one.
+func writeSpillFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return SyncDir(filepath.Dir(path))
+}
+
+// peek reads the oldest spilled buffer. ok is false when nothing is spilled.
+func (s *Spill) peek() (data []byte, ok bool, err error) {
+	s.mu.Lock()
+	if len(s.files) == 0 {
+		s.mu.Unlock()
+		return nil, false, nil
+	}
+	file := s.files[0]
+	if file.data != nil {
+		data := file.data
+		s.mu.Unlock()
+		return data, true, nil
+	}
+	name := file.name
+	s.mu.Unlock()
+
+	// Only the writer goroutine removes files, so the oldest can't go away meanwhile.
+	data, err = os.ReadFile(filepath.Join(s.dir, name))
+	return data, true, err
+}
+
+// pop removes the oldest spilled buffer.
+func (s *Spill) pop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.files) == 0 {
+		return nil
+	}
+	file := s.files[0]
+	s.files = s.files[1:]
+	s.size -= file.size
+	metrics.SpillBytes.Set(float64(s.size))
+
+	if file.data != nil {
+		// Not on disk, unless it is being written, which removes it afterwards.
+		file.removed = true
+		s.pending--
+		return nil
+	}
+	return os.Remove(filepath.Join(s.dir, file.name))
+}
@@ -0,0 +1,415 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sink/errdefs"
+	"github.com/sink/metrics"
+)
+
+// ErrQueueFull is returned by TryEnqueue when the writer is behind and every queue
+// slot is taken. It is an errdefs.ErrBufferFull.
+var ErrQueueFull = errdefs.Wrap(errdefs.ErrBufferFull, errors.New("write queue full"))
+
+// Destination is where a FileWriter writes buffers: the log file, or an output
+// sending them elsewhere.
+type Destination interface {
+	Write(p []byte) (int, error)
+	Sync() error
+	Close() error
+}
+
+// Encoder transforms a buffer of log entries before it is written to disk.
+type Encoder interface {
+	Encode(dst, buf []byte) ([]byte, error)
+}
+
+// FileWriter appends completed buffers to a file on a dedicated goroutine, so request
+// handlers never wait for disk I/O. Written buffers are recycled to callers through
+// NewBuffer, giving double buffering without per-flush allocations.
+//
+// With a spill, buffers that find the queue full are encoded and spilled to disk
+// instead, and written once the queue is empty. Buffers enqueued while any are
+// spilled are spilled as well, so the log keeps the order they were enqueued in.
+type FileWriter struct {
+	file       Destination
+	bufferSize int
+	queue      chan queued
+	free       chan []byte
+	syncs      chan chan error
+	done       chan struct{}
+
+	spill   *Spill        // nil without spilling
+	spilled chan struct{} // wakes the writer goroutine to write spilled buffers
+
+	encodeMu sync.Mutex
+	encoder  Encoder // nil writes buffers as they are
+	encoded  []byte  // scratch space for encoded buffers
+
+	mu          sync.Mutex
+	lastErr     error
+	panicked    error         // first panic recovered on the writer goroutine
+	writing     time.Time     // when the buffer being written was queued, zero when idle
+	lastLatency time.Duration // queue wait and write time of the last buffer
+}
+
+// queued is a buffer waiting for the writer goroutine.
+type queued struct {
+	buf []byte
+	at  time.Time
+}
+
+// NewFileWriter opens path for appending and starts the writer goroutine. At most
+// queueSize buffers wait for the disk at any time, unless a non-nil spill takes the
+// rest. A non-nil encoder transforms every buffer before it is written: on the writer
+// goroutine, or when it is spilled.
+func NewFileWriter(path string, bufferSize, queueSize int, encoder Encoder, spill *Spill) (*FileWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	return NewWriter(file, bufferSize, queueSize, encoder, spill), nil
+}
+
+// NewWriter is NewFileWriter for buffers written to dest instead of a log file. The
+// writer closes dest when it is closed.
+func NewWriter(file Destination, bufferSize, queueSize int, encoder Encoder, spill *Spill) *FileWriter {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	w := &FileWriter{
+		file:       file,
+		bufferSize: bufferSize,
+		encoder:    encoder,
+		queue:      make(chan queued, queueSize),
+		free:       make(chan []byte, queueSize+1),
+		syncs:      make(chan chan error),
+		done:       make(chan struct{}),
+		spill:      spill,
+		spilled:    make(chan struct{}, 1),
+	}
+	go w.run()
+
+	return w
+}
+
+// NewBuffer returns an empty buffer, reusing one that has already been written when
+// available.
+func (w *FileWriter) NewBuffer() []byte {
+	select {
+	case buf := <-w.free:
+		return buf[:0]
+	default:
+		return make([]byte, 0, w.bufferSize)
+	}
+}
+
+// TryEnqueue hands buf to the writer goroutine without blocking, spilling it when
+// the queue is full. On success the writer owns buf; on an error wrapping
+// ErrQueueFull the caller keeps it.
+func (w *FileWriter) TryEnqueue(buf []byte) error {
+	if w.spilling() {
+		return w.spillBuffer(buf)
+	}
+
+	select {
+	case w.queue <- queued{buf, time.Now()}:
+		return nil
+	default:
+		if w.spill == nil {
+			return ErrQueueFull
+		}
+		return w.spillBuffer(buf)
+	}
+}
+
+// EnqueueContext hands buf to the writer goroutine, waiting for a free queue slot
+// until ctx is done. On error the caller keeps buf.
+func (w *FileWriter) EnqueueContext(ctx context.Context, buf []byte) error {
+	if w.spilling() && w.spillBuffer(buf) == nil {
+		return nil
+	}
+
+	select {
+	case w.queue <- queued{buf, time.Now()}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Enqueue hands buf to the writer goroutine, waiting for a free queue slot. When
+// buffers are spilled but buf can't be, it may be written ahead of them.
+func (w *FileWriter) Enqueue(buf []byte) {
+	if w.spilling() && w.spillBuffer(buf) == nil {
+		return
+	}
+
+	w.queue <- queued{buf, time.Now()}
+}
+
+// QueueLen returns the number of buffers waiting to be written, spilled ones
+// included.
+func (w *FileWriter) QueueLen() int {
+	n := len(w.queue)
+	if w.spill != nil {
+		n += w.spill.Len()
+	}
+	return n
+}
+
+// spilling reports whether buffers are spilled, which later buffers must queue
+// behind.
+func (w *FileWriter) spilling() bool {
+	return w.spill != nil && w.spill.Len() > 0
+}
+
+// spillBuffer encodes buf and spills it. The writer owns buf on success.
+func (w *FileWriter) spillBuffer(buf []byte) error {
+	data := buf
+	if w.encoder != nil {
+		var err error
+		if data, err = w.encodeCopy(buf); err != nil {
+			return fmt.Errorf("%w: encode spilled buffer: %v", ErrQueueFull, err)
+		}
+	}
+
+	if err := w.spill.push(data); err != nil {
+		return fmt.Errorf("%w: %v", ErrQueueFull, err)
+	}
+
+	// Unencoded, buf itself is held by the spill until it is on disk.
+	if w.encoder != nil {
+		select {
+		case w.free <- buf[:0]:
+		default:
+		}
+	}
+	select {
+	case w.spilled <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Err returns the most recent write error, an errdefs.ErrStorage, or nil if the last
+// write succeeded.
+func (w *FileWriter) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.lastErr
+}
+
+// Latency returns how long the buffer being written has waited since it was queued,
+// or while the writer is between buffers, how long the last one took. It is 0 when
+// nothing is queued, so a single slow write isn't reported forever.
+func (w *FileWriter) Latency() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch {
+	case !w.writing.IsZero():
+		return time.Since(w.writing)
+	case w.QueueLen() > 0:
+		return w.lastLatency
+	default:
+		return 0
+	}
+}
+
+// Sync waits until every buffer enqueued before the call is written, spilled ones
+// included, and flushes the file to stable storage.
+func (w *FileWriter) Sync() error {
+	reply := make(chan error, 1)
+	select {
+	case w.syncs <- reply:
+		return <-reply
+	case <-w.done:
+		return errors.New("writer closed")
+	}
+}
+
+func (w *FileWriter) run() {
+	defer close(w.done)
+
+	for {
+		select {
+		case q, ok := <-w.queue:
+			if !ok {
+				for w.writeSpilled() {
+				}
+				return
+			}
+			w.writeQueued(q)
+		case <-w.spilled:
+		case reply := <-w.syncs:
+			// Buffers enqueued before Sync are already in the queue or spilled.
+			for n := len(w.queue); n > 0; n-- {
+				q, ok := <-w.queue
+				if !ok {
+					break
+				}
+				w.writeQueued(q)
+			}
+			for w.writeSpilled() {
+			}
+			reply <- errdefs.Wrap(errdefs.ErrStorage, w.recovering(w.file.Sync))
+		}
+
+		// Spilled buffers were enqueued after the queued ones.
+		for len(w.queue) == 0 && w.writeSpilled() {
+		}
+	}
+}
+
+// writeSpilled writes the oldest spilled buffer. It returns false when there is
+// none or it fails, in which case it is retried when the writer is next woken.
+func (w *FileWriter) writeSpilled() bool {
+	if w.spill == nil {
+		return false
+	}
+
+	start := time.Now()
+	data, ok, err := w.spill.peek()
+	if !ok {
+		return false
+	}
+	if err == nil {
+		err = w.recovering(func() error {
+			_, err := w.file.Write(data)
+			return err
+		})
+	}
+	metrics.StageDuration.WithLabelValues("write").Observe(time.Since(start).Seconds())
+
+	w.mu.Lock()
+	w.lastErr = err
+	w.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to write spilled buffer to log file: %v", err)
+		return false
+	}
+	if err := w.spill.pop(); err != nil {
+		log.Printf("Failed to remove written spill file: %v", err)
+	}
+	log.Printf("Flushed spilled buffer to log file")
+	return true
+}
+
+func (w *FileWriter) writeQueued(q queued) {
+	start := time.Now()
+	metrics.StageDuration.WithLabelValues("flush_wait").Observe(start.Sub(q.at).Seconds())
+
+	w.mu.Lock()
+	w.writing = q.at
+	w.mu.Unlock()
+
+	buf := q.buf
+	err := errdefs.Wrap(errdefs.ErrStorage, w.recovering(func() error { return w.write(buf) }))
+	metrics.StageDuration.WithLabelValues("write").Observe(time.Since(start).Seconds())
+	if err != nil {
+		log.Printf("Failed to write buffer to log file: %v", err)
+	} else {
+		log.Printf("Flushed buffer to log file")
+	}
+
+	w.mu.Lock()
+	w.lastErr = err
+	w.writing = time.Time{}
+	w.lastLatency = time.Since(q.at)
+	w.mu.Unlock()
+
+	select {
+	case w.free <- buf[:0]:
+	default:
+	}
+}
+
+func (w *FileWriter) write(buf []byte) error {
+	if w.encoder == nil {
+		_, err := w.file.Write(buf)
+		return err
+	}
+
+	encoded, err := w.encode(buf)
+	if err != nil {
+		return err
+	}
+	_, err = w.file.Write(encoded)
+	return err
+}
+
+// encode encodes buf into the writer's scratch space, which only the writer
+// goroutine uses.
+func (w *FileWriter) encode(buf []byte) ([]byte, error) {
+	w.encodeMu.Lock()
+	defer w.encodeMu.Unlock()
+
+	encoded, err := w.encoder.Encode(w.encoded[:0], buf)
+	if err != nil {
+		return nil, err
+	}
+	w.encoded = encoded
+	return encoded, nil
+}
+
+// encodeCopy encodes buf into a new slice.
+func (w *FileWriter) encodeCopy(buf []byte) ([]byte, error) {
+	w.encodeMu.Lock()
+	defer w.encodeMu.Unlock()
+
+	return w.encoder.Encode(nil, buf)
+}
+
+// recovering runs f, a write or sync on the writer goroutine, turning a panic, e.g.
+// in an output's client or the encoder, into its error. The buffer is lost like one
+// that failed to write, but the goroutine carries on with the next.
+func (w *FileWriter) recovering(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic in log writer: %v\n%s", r, debug.Stack())
+			metrics.GoroutinePanics.WithLabelValues("writer").Inc()
+			err = fmt.Errorf("writer panicked: %v", r)
+
+			w.mu.Lock()
+			if w.panicked == nil {
+				w.panicked = err
+			}
+			w.mu.Unlock()
+		}
+	}()
+
+	return f()
+}
+
+// Panicked returns the first panic recovered on the writer goroutine, nil if there
+// was none. Unlike Err it stays set after later writes succeed.
+func (w *FileWriter) Panicked() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.panicked
+}
+
+// Close waits until every queued and spilled buffer is written and closes the file
+// and the spill. No buffers may be enqueued after Close.
+func (w *FileWriter) Close() error {
+	close(w.queue)
+	<-w.done
+	if w.spill != nil {
+		w.spill.Close()
+	}
+
+	return w.file.Close()
+}
@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWriter_WritesQueuedBuffersInOrder(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	path := filepath.Join(t.TempDir(), "telemetry.log")
+	w, err := NewFileWriter(path, 64, 2, nil, nil)
+	if err != nil {
+		t.Fatalf("NewFileWriter() error = %v", err)
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		buf := append(w.NewBuffer(), line...)
+		w.Enqueue(buf)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log file: %v", err)
+	}
+	if string(data) != "first\nsecond\nthird\n" {
+		t.Errorf("log file = %q", data)
+	}
+}
+
+func TestFileWriter_SyncWritesQueuedBuffers(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	path := filepath.Join(t.TempDir(), "telemetry.log")
+	w, err := NewFileWriter(path, 64, 4, nil, nil)
+	if err != nil {
+		t.Fatalf("NewFileWriter() error = %v", err)
+	}
+	defer w.Close()
+
+	for _, line := range []string{"first\n", "second\n"} {
+		w.Enqueue(append(w.NewBuffer(), line...))
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log file: %v", err)
+	}
+	if string(data) != "first\nsecond\n" {
+		t.Errorf("log file after Sync() = %q", data)
+	}
+}
+
+// panickyEncoder panics on buffers starting with "panic".
+type panickyEncoder struct{}
+
+func (panickyEncoder) Encode(dst, buf []byte) ([]byte, error) {
+	if string(buf[:5]) == "panic" {
+		panic("bad buffer")
+	}
+	return append(dst, buf...), nil
+}
+
+func TestFileWriter_RecoversFromPanics(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	path := filepath.Join(t.TempDir(), "telemetry.log")
+	w, err := NewFileWriter(path, 64, 4, panickyEncoder{}, nil)
+	if err != nil {
+		t.Fatalf("NewFileWriter() error = %v", err)
+	}
+	defer w.Close()
+
+	w.Enqueue(append(w.NewBuffer(), "panic\n"...))
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if err := w.Err(); err == nil {
+		t.Error("Err() = nil after a panicking write, want the panic")
+	}
+
+	w.Enqueue(append(w.NewBuffer(), "after\n"...))
+	if err := w.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if err := w.Err(); err != nil {
+		t.Errorf("Err() = %v after a successful write, want nil", err)
+	}
+	if err := w.Panicked(); err == nil {
+		t.Error("Panicked() = nil, want the recovered panic")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "after\n" {
+		t.Errorf("log file = %q, want the buffer written after the panic", data)
+	}
+}
+
+func TestFileWriter_TryEnqueueWhenFull(t *testing.T) {
+	w := &FileWriter{queue: make(chan queued, 1)}
+
+	if err := w.TryEnqueue([]byte("a")); err != nil {
+		t.Fatalf("first TryEnqueue() error = %v", err)
+	}
+	if err := w.TryEnqueue([]byte("b")); err != ErrQueueFull {
+		t.Errorf("second TryEnqueue() error = %v, want ErrQueueFull", err)
+	}
+}
+
+func TestFileWriter_EnqueueContextWhenFull(t *testing.T) {
+	w := &FileWriter{queue: make(chan queued, 1)}
+
+	if err := w.EnqueueContext(context.Background(), []byte("a")); err != nil {
+		t.Fatalf("first EnqueueContext() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.EnqueueContext(ctx, []byte("b")); err != context.DeadlineExceeded {
+		t.Errorf("second EnqueueContext() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestFileWriter_SpillsWhenFullInOrder(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	spill, err := OpenSpill(filepath.Join(dir, "spill"), 1024)
+	if err != nil {
+		t.Fatalf("OpenSpill() error = %v", err)
+	}
+	path := filepath.Join(dir, "telemetry.log")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open log file: %v", err)
+	}
+	// The writer goroutine isn't started until every buffer is enqueued.
+	w := &FileWriter{
+		file:    file,
+		queue:   make(chan queued, 1),
+		free:    make(chan []byte, 2),
+		syncs:   make(chan chan error),
+		done:    make(chan struct{}),
+		spill:   spill,
+		spilled: make(chan struct{}, 1),
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if err := w.TryEnqueue([]byte(line)); err != nil {
+			t.Fatalf("TryEnqueue(%q) error = %v", line, err)
+		}
+	}
+	// Spilled buffers are still pending, so this one must not overtake them.
+	w.Enqueue([]byte("fourth\n"))
+	if got := w.QueueLen(); got != 4 {
+		t.Errorf("QueueLen() = %d, want 4", got)
+	}
+	if got := spill.Len(); got != 3 {
+		t.Errorf("spill.Len() = %d, want 3", got)
+	}
+
+	go w.run()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log file: %v", err)
+	}
+	if string(data) != "first\nsecond\nthird\nfourth\n" {
+		t.Errorf("log file = %q", data)
+	}
+	if spill.Len() != 0 || spill.Bytes() != 0 {
+		t.Errorf("spill after Close() = %d buffers, %d bytes, want empty", spill.Len(), spill.Bytes())
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "spill")); len(entries) != 0 {
+		t.Errorf("spill directory has %d files after Close(), want 0", len(entries))
+	}
+}
+
+func TestFileWriter_SpillQuota(t *testing.T) {
+	spill, err := OpenSpill(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("OpenSpill() error = %v", err)
+	}
+	defer spill.Close()
+	w := &FileWriter{
+		queue:   make(chan queued, 1),
+		free:    make(chan []byte, 2),
+		spill:   spill,
+		spilled: make(chan struct{}, 1),
+	}
+
+	if err := w.TryEnqueue([]byte("a")); err != nil {
+		t.Fatalf("first TryEnqueue() error = %v", err)
+	}
+	if err := w.TryEnqueue([]byte("12345678")); err != nil {
+		t.Fatalf("second TryEnqueue() error = %v, want spilled", err)
+	}
+	if err := w.TryEnqueue([]byte("123")); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("TryEnqueue() over quota error = %v, want ErrQueueFull", err)
+	}
+	if got := spill.Bytes(); got != 8 {
+		t.Errorf("spill.Bytes() = %d, want 8", got)
+	}
+}
+
+func TestOpenSpill_ResumesPreviousRun(t *testing.T) {
+	dir := t.TempDir()
+	first, err := OpenSpill(dir, 1024)
+	if err != nil {
+		t.Fatalf("OpenSpill() error = %v", err)
+	}
+	for _, data := range []string{"first\n", "second\n"} {
+		if err := first.push([]byte(data)); err != nil {
+			t.Fatalf("push(%q) error = %v", data, err)
+		}
+	}
+	first.Close()
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000002.spill.tmp"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	spill, err := OpenSpill(dir, 1024)
+	if err != nil {
+		t.Fatalf("OpenSpill() again error = %v", err)
+	}
+	defer spill.Close()
+	if spill.Len() != 2 || spill.Bytes() != 13 {
+		t.Fatalf("reopened spill = %d buffers, %d bytes, want 2, 13", spill.Len(), spill.Bytes())
+	}
+	if err := spill.push([]byte("third\n")); err != nil {
+		t.Fatalf("push() error = %v", err)
+	}
+
+	var got string
+	for {
+		data, ok, err := spill.peek()
+		if err != nil {
+			t.Fatalf("peek() error = %v", err)
+		}
+		if !ok {
+			break
+		}
+		got += string(data)
+		if err := spill.pop(); err != nil {
+			t.Fatalf("pop() error = %v", err)
+		}
+	}
+	if got != "first\nsecond\nthird\n" {
+		t.Errorf("spilled buffers = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000002.spill.tmp")); !os.IsNotExist(err) {
+		t.Errorf("partial spill file still exists: %v", err)
+	}
+}
+
+func TestSpill_WritesInBackground(t *testing.T) {
+	dir := t.TempDir()
+	// Without the goroutine writing them, pushed buffers stay in memory.
+	s := &Spill{dir: dir, quota: 1024, wake: make(chan struct{}, 1), stop: make(chan struct{}), stopped: make(chan struct{})}
+	for i := range maxPendingSpills {
+		if err := s.push([]byte{byte('a' + i), '\n'}); err != nil {
+			t.Fatalf("push() #%d error = %v", i+1, err)
+		}
+	}
+	if err := s.push([]byte("e\n")); !errors.Is(err, errSpillBusy) {
+		t.Errorf("push() with %d buffers pending error = %v, want errSpillBusy", maxPendingSpills, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spill directory has %d files before they are written, want 0", len(entries))
+	}
+
+	// The writer takes pending buffers from memory.
+	if data, ok, err := s.peek(); err != nil || !ok || string(data) != "a\n" {
+		t.Errorf("peek() = %q, %v, %v, want the first buffer from memory", data, ok, err)
+	}
+	if err := s.pop(); err != nil {
+		t.Fatalf("pop() error = %v", err)
+	}
+
+	// The rest are on disk once the spill is closed, and survive a restart.
+	go s.persist()
+	s.Close()
+	reopened, err := OpenSpill(dir, 1024)
+	if err != nil {
+		t.Fatalf("OpenSpill() error = %v", err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != maxPendingSpills-1 || reopened.Bytes() != 2*(maxPendingSpills-1) {
+		t.Errorf("reopened spill = %d buffers, %d bytes, want the %d not taken", reopened.Len(), reopened.Bytes(), maxPendingSpills-1)
+	}
+	if data, _, err := reopened.peek(); err != nil || string(data) != "b\n" {
+		t.Errorf("peek() after reopening = %q, %v, want the second buffer", data, err)
+	}
+}
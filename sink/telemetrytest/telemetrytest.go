@@ -0,0 +1,216 @@
+// Package telemetrytest runs a sink in process for integration tests. The sink serves
+// gRPC on an in-memory listener, stores its log in a temporary directory and reads
+// time from a fake clock the test advances, so tests need no network, no fixed paths
+// and no sleeps.
+//
+//	sink := telemetrytest.NewSink(t, config.Config{})
+//	sink.Client.SendSensorData(ctx, &pb.SensorData{...})
+//	entries := sink.Entries()
+package telemetrytest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/sink/clock"
+	"github.com/sink/config"
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/pkg/logformat"
+	pb "github.com/sink/proto"
+	"github.com/sink/server"
+)
+
+// Entry is a log entry as written by the sink.
+type Entry struct {
+	Timestamp     time.Time          `json:"timestamp"`
+	SensorName    string             `json:"sensor_name"`
+	SensorValue   float64            `json:"sensor_value"`
+	Metric        string             `json:"metric"`
+	Values        map[string]float64 `json:"values"`
+	Aggregate     *Aggregate         `json:"aggregate"`
+	Type          string             `json:"type"` // "event" for events, empty for readings
+	Event         *Event             `json:"event"`
+	Location      *Location          `json:"location"`
+	RawValue      *float64           `json:"raw_value"`
+	SealedPayload []byte             `json:"sealed_payload"`
+	Attachment    []byte             `json:"attachment"`
+	AttachmentID  string             `json:"attachment_id"` // set when stored in the attachment directory
+	DataTime      time.Time          `json:"data_time"`
+	Priority      string             `json:"priority"`
+	Tags          map[string]string  `json:"tags"`
+}
+
+// Aggregate is the aggregate of an entry for samples a device aggregated itself.
+type Aggregate struct {
+	Count   uint64  `json:"count"`
+	Sum     float64 `json:"sum"`
+	Buckets []struct {
+		UpperBound float64 `json:"le"`
+		Count      uint64  `json:"count"`
+	} `json:"buckets"`
+}
+
+// Location is where a mobile sensor took a reading.
+type Location struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude"`
+}
+
+// Event is the event of an entry written for a device's event.
+type Event struct {
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Sink is a sink server running in process.
+type Sink struct {
+	Server *server.SinkServer
+	Client pb.TelemetryServiceClient
+	Conn   *grpc.ClientConn
+	Clock  *clock.Fake // nil when the configuration brought another clock
+	// LogPath is the log file in the test's temporary directory.
+	LogPath string
+
+	t         testing.TB
+	encryptor *encryption.Encryptor // nil when the log is not encrypted
+}
+
+// StartTime is where the clock of a new Sink starts.
+var StartTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// NewSink starts a sink with cfg and connects a client to it. Unset fields get
+// test-friendly defaults: the log lives in t.TempDir(), the flush interval is an hour
+// and rate limits are effectively off. Unless cfg.Clock is set the sink runs on
+// Sink.Clock, a fake clock starting at StartTime; advancing it refills rate limits,
+// fires the flush timer and ages sensors. The sink is stopped when the test ends.
+func NewSink(t testing.TB, cfg config.Config) *Sink {
+	t.Helper()
+
+	if cfg.Clock == nil {
+		cfg.Clock = clock.NewFake(StartTime)
+	}
+	fake, _ := cfg.Clock.(*clock.Fake)
+	if cfg.LogFilePath == "" {
+		cfg.LogFilePath = filepath.Join(t.TempDir(), "telemetry.log")
+	}
+	if cfg.BufferSize == 0 {
+		cfg.BufferSize = 1024 * 1024
+	}
+	if cfg.WriteQueueSize == 0 {
+		cfg.WriteQueueSize = 16
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = time.Hour
+	}
+	if cfg.RateLimit == 0 {
+		cfg.RateLimit = 1 << 40
+	}
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = config.LogFormatText
+	}
+	if cfg.EncryptionCipher == "" {
+		cfg.EncryptionCipher = encryption.CipherAESGCM
+	}
+	if cfg.EncryptionMode == "" {
+		cfg.EncryptionMode = config.EncryptionModeEntry
+	}
+
+	var encryptor *encryption.Encryptor
+	if cfg.EnableEncryption {
+		var err error
+		if encryptor, err = encryption.NewEncryptor(cfg.EncryptionCipher, cfg.EncryptionKey); err != nil {
+			t.Fatalf("telemetrytest: create encryptor: %v", err)
+		}
+	}
+
+	srv, err := server.NewSinkServer(cfg)
+	if err != nil {
+		t.Fatalf("telemetrytest: create sink: %v", err)
+	}
+
+	lis := bufconn.Listen(1024 * 1024)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("telemetrytest: connect: %v", err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Stop()
+		if err := <-served; err != nil {
+			t.Errorf("telemetrytest: serve: %v", err)
+		}
+		srv.Close()
+	})
+
+	return &Sink{
+		Server:    srv,
+		Client:    pb.NewTelemetryServiceClient(conn),
+		Conn:      conn,
+		Clock:     fake,
+		LogPath:   cfg.LogFilePath,
+		t:         t,
+		encryptor: encryptor,
+	}
+}
+
+// Flush writes buffered entries to the log file and syncs it.
+func (s *Sink) Flush() {
+	s.t.Helper()
+
+	if err := s.Server.FlushNow(); err != nil {
+		s.t.Fatalf("telemetrytest: flush: %v", err)
+	}
+}
+
+// Entries flushes the sink and returns every entry in its log, decrypting the log if
+// the sink encrypts it. Sealed payloads are returned as they are.
+func (s *Sink) Entries() []Entry {
+	s.t.Helper()
+
+	s.Flush()
+
+	f, err := os.Open(s.LogPath)
+	if err != nil {
+		s.t.Fatalf("telemetrytest: open log: %v", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	r := logformat.NewReader(f, s.encryptor)
+	for {
+		line, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if err != nil {
+			s.t.Fatalf("telemetrytest: read log: %v", err)
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			s.t.Fatalf("telemetrytest: decode entry %s: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+}
@@ -0,0 +1,762 @@
+package telemetrytest_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sink/config"
+	encryption "github.com/sink/encryptor"
+	"github.com/sink/metrics"
+	"github.com/sink/pkg/coap"
+	"github.com/sink/pkg/frame"
+	pb "github.com/sink/proto"
+	"github.com/sink/telemetrytest"
+)
+
+func TestSink_StoresReadingsWithClockTime(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{})
+	ctx := context.Background()
+
+	deviceTime := telemetrytest.StartTime.Add(-time.Second)
+	for i := int32(1); i <= 3; i++ {
+		_, err := sink.Client.SendSensorData(ctx, &pb.SensorData{
+			SensorName:  "temperature-01",
+			SensorValue: i,
+			Timestamp:   timestamppb.New(deviceTime),
+			Tags:        map[string]string{"zone": "a"},
+		})
+		if err != nil {
+			t.Fatalf("SendSensorData() error = %v", err)
+		}
+		sink.Clock.Advance(time.Minute)
+	}
+
+	entries := sink.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("Entries() returned %d entries, want 3", len(entries))
+	}
+	for i, entry := range entries {
+		wantTime := telemetrytest.StartTime.Add(time.Duration(i) * time.Minute)
+		if !entry.Timestamp.Equal(wantTime) {
+			t.Errorf("entry %d timestamp = %v, want %v", i, entry.Timestamp, wantTime)
+		}
+		if entry.SensorValue != float64(i+1) || entry.Tags["zone"] != "a" || !entry.DataTime.Equal(deviceTime) {
+			t.Errorf("entry %d = %+v", i, entry)
+		}
+	}
+}
+
+func TestSink_LivenessFollowsClock(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{SensorSilentAfter: time.Minute})
+
+	_, err := sink.Client.Heartbeat(context.Background(), &pb.HeartbeatRequest{SensorName: "pressure-01"})
+	if err != nil {
+		t.Fatalf("Heartbeat() error = %v", err)
+	}
+
+	if sensors := sink.Server.Sensors(); len(sensors) != 1 || sensors[0].Silent {
+		t.Fatalf("Sensors() = %+v, want one live sensor", sensors)
+	}
+	sink.Clock.Advance(2 * time.Minute)
+	if sensors := sink.Server.Sensors(); len(sensors) != 1 || !sensors[0].Silent {
+		t.Errorf("Sensors() after 2m = %+v, want one silent sensor", sensors)
+	}
+}
+
+func TestSink_EncryptedLog(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{
+		LogFormat:        config.LogFormatBinary,
+		EnableEncryption: true,
+		EncryptionKey:    base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		EncryptionCipher: encryption.CipherChaCha20Poly1305,
+		EncryptionMode:   config.EncryptionModeSegment,
+	})
+
+	_, err := sink.Client.SendSensorData(context.Background(), &pb.SensorData{
+		SensorName:  "humidity",
+		SensorValue: 55,
+		Timestamp:   timestamppb.New(telemetrytest.StartTime),
+	})
+	if err != nil {
+		t.Fatalf("SendSensorData() error = %v", err)
+	}
+
+	entries := sink.Entries()
+	if len(entries) != 1 || entries[0].SensorName != "humidity" || entries[0].SensorValue != 55 {
+		t.Errorf("Entries() = %+v, want the humidity reading", entries)
+	}
+}
+
+func TestSink_MultiValueReadings(t *testing.T) {
+	values := map[string]float64{"temperature": 21.5, "humidity": 40, "battery": 3.7}
+
+	t.Run("split", func(t *testing.T) {
+		sink := telemetrytest.NewSink(t, config.Config{
+			EnableEncryption: true,
+			EncryptionKey:    base64.StdEncoding.EncodeToString(make([]byte, 32)),
+			EncryptionMode:   config.EncryptionModeEntry,
+		})
+		_, err := sink.Client.SendSensorData(context.Background(), &pb.SensorData{
+			SensorName: "weather-01",
+			Values:     values,
+			Timestamp:  timestamppb.New(telemetrytest.StartTime),
+		})
+		if err != nil {
+			t.Fatalf("SendSensorData() error = %v", err)
+		}
+
+		entries := sink.Entries()
+		if len(entries) != len(values) {
+			t.Fatalf("Entries() returned %d entries, want one per value", len(entries))
+		}
+		for i, metric := range []string{"battery", "humidity", "temperature"} {
+			if entries[i].SensorName != "weather-01" || entries[i].Metric != metric || entries[i].SensorValue != values[metric] {
+				t.Errorf("entry %d = %+v, want %s=%v", i, entries[i], metric, values[metric])
+			}
+		}
+	})
+
+	t.Run("combined", func(t *testing.T) {
+		sink := telemetrytest.NewSink(t, config.Config{MultiValueMode: config.MultiValueCombined})
+		_, err := sink.Client.SendSensorData(context.Background(), &pb.SensorData{
+			SensorName: "weather-01",
+			Values:     values,
+			Timestamp:  timestamppb.New(telemetrytest.StartTime),
+		})
+		if err != nil {
+			t.Fatalf("SendSensorData() error = %v", err)
+		}
+
+		entries := sink.Entries()
+		if len(entries) != 1 || !maps.Equal(entries[0].Values, values) || entries[0].Metric != "" {
+			t.Errorf("Entries() = %+v, want one entry with all values", entries)
+		}
+	})
+}
+
+func TestSink_AggregateReadings(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{})
+
+	_, err := sink.Client.SendSensorData(context.Background(), &pb.SensorData{
+		SensorName: "vibration-01",
+		Timestamp:  timestamppb.New(telemetrytest.StartTime),
+		Aggregate: &pb.Aggregate{
+			Count:   1000,
+			Sum:     312.5,
+			Buckets: []*pb.HistogramBucket{{UpperBound: 0.1, Count: 700}, {UpperBound: 1, Count: 250}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SendSensorData() error = %v", err)
+	}
+
+	entries := sink.Entries()
+	if len(entries) != 1 || entries[0].Aggregate == nil {
+		t.Fatalf("Entries() = %+v, want one aggregate entry", entries)
+	}
+	a := entries[0].Aggregate
+	if a.Count != 1000 || a.Sum != 312.5 || len(a.Buckets) != 2 || a.Buckets[1].UpperBound != 1 || a.Buckets[1].Count != 250 {
+		t.Errorf("Aggregate = %+v, want the histogram as sent", a)
+	}
+}
+
+func TestSink_EventsAreStoredWithReadings(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{})
+	ctx := context.Background()
+	now := timestamppb.New(telemetrytest.StartTime)
+
+	if _, err := sink.Client.SendSensorData(ctx, &pb.SensorData{SensorName: "pump-3", SensorValue: 7, Timestamp: now}); err != nil {
+		t.Fatalf("SendSensorData() error = %v", err)
+	}
+	_, err := sink.Client.ReportEvent(ctx, &pb.Event{
+		SensorName: "pump-3",
+		Timestamp:  now,
+		Severity:   pb.Severity_SEVERITY_ERROR,
+		Message:    "motor stalled",
+		Attributes: map[string]string{"code": "E42"},
+	})
+	if err != nil {
+		t.Fatalf("ReportEvent() error = %v", err)
+	}
+
+	entries := sink.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Entries() returned %d entries, want the reading and the event", len(entries))
+	}
+	if entries[0].Type != "" || entries[0].Event != nil {
+		t.Errorf("reading = %+v, want no type", entries[0])
+	}
+	event := entries[1]
+	if event.Type != "event" || event.Event == nil || event.Event.Severity != "error" || event.Event.Message != "motor stalled" || event.Tags["code"] != "E42" {
+		t.Errorf("event = %+v, want the reported error event", event)
+	}
+}
+
+func TestSink_StoresLocation(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{})
+	altitude := 120.5
+
+	_, err := sink.Client.SendSensorData(context.Background(), &pb.SensorData{
+		SensorName:  "drone-7",
+		SensorValue: 3,
+		Timestamp:   timestamppb.New(telemetrytest.StartTime),
+		Location:    &pb.Location{Latitude: 52.2297, Longitude: 21.0122, Altitude: &altitude},
+	})
+	if err != nil {
+		t.Fatalf("SendSensorData() error = %v", err)
+	}
+
+	_, err = sink.Client.SendSensorData(context.Background(), &pb.SensorData{
+		SensorName: "drone-7",
+		Timestamp:  timestamppb.New(telemetrytest.StartTime),
+		Location:   &pb.Location{Latitude: 95},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SendSensorData() with latitude 95 error = %v, want InvalidArgument", err)
+	}
+
+	entries := sink.Entries()
+	if len(entries) != 1 || entries[0].Location == nil {
+		t.Fatalf("Entries() = %+v, want one located entry", entries)
+	}
+	if l := entries[0].Location; l.Latitude != 52.2297 || l.Longitude != 21.0122 || l.Altitude == nil || *l.Altitude != altitude {
+		t.Errorf("Location = %+v, want the reported position", l)
+	}
+}
+
+func TestSink_StoresAttachmentsInline(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{MaxAttachmentSize: 16})
+	ctx := context.Background()
+	req := &pb.SensorData{
+		SensorName: "spectrometer",
+		Timestamp:  timestamppb.New(telemetrytest.StartTime),
+		Attachment: []byte{0x00, 0xff, 0x10, 0x20},
+	}
+
+	if _, err := sink.Client.SendSensorData(ctx, req); err != nil {
+		t.Fatalf("SendSensorData() error = %v", err)
+	}
+	req.Attachment = make([]byte, 17)
+	if _, err := sink.Client.SendSensorData(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SendSensorData() with a 17-byte attachment error = %v, want InvalidArgument", err)
+	}
+
+	entries := sink.Entries()
+	if len(entries) != 1 || string(entries[0].Attachment) != "\x00\xff\x10\x20" || entries[0].AttachmentID != "" {
+		t.Errorf("Entries() = %+v, want one entry with the attachment inline", entries)
+	}
+}
+
+func TestSink_StoresAttachmentsInDirectory(t *testing.T) {
+	dir := t.TempDir()
+	sink := telemetrytest.NewSink(t, config.Config{MaxAttachmentSize: 1024, AttachmentDir: dir, MultiValueMode: config.MultiValueSplit})
+	attachment := []byte("spectrum snapshot")
+
+	_, err := sink.Client.SendSensorData(context.Background(), &pb.SensorData{
+		SensorName: "spectrometer",
+		Timestamp:  timestamppb.New(telemetrytest.StartTime),
+		Values:     map[string]float64{"peak": 532, "width": 1.5},
+		Attachment: attachment,
+	})
+	if err != nil {
+		t.Fatalf("SendSensorData() error = %v", err)
+	}
+
+	entries := sink.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("Entries() = %+v, want one per value", entries)
+	}
+	for _, e := range entries {
+		if e.Attachment != nil || e.AttachmentID != entries[0].AttachmentID {
+			t.Errorf("entry %+v, want it to reference the shared attachment", e)
+		}
+	}
+
+	stored, err := os.ReadFile(filepath.Join(dir, entries[0].AttachmentID))
+	if err != nil {
+		t.Fatalf("read stored attachment: %v", err)
+	}
+	if string(stored) != string(attachment) {
+		t.Errorf("stored attachment = %q, want %q", stored, attachment)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("attachment directory holds %d files, want 1", len(files))
+	}
+}
+
+func TestSink_AttachmentRateLimit(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{RateLimit: 200, MaxAttachmentSize: 1000, AttachmentRateLimit: 1500})
+	ctx := context.Background()
+	req := &pb.SensorData{
+		SensorName: "spectrometer",
+		Timestamp:  timestamppb.New(telemetrytest.StartTime),
+		Attachment: make([]byte, 1000),
+	}
+
+	// The attachment is charged to its own limit, well over -rate-limit.
+	if _, err := sink.Client.SendSensorData(ctx, req); err != nil {
+		t.Fatalf("SendSensorData() error = %v", err)
+	}
+	if _, err := sink.Client.SendSensorData(ctx, req); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second SendSensorData() error = %v, want ResourceExhausted", err)
+	}
+
+	// Readings without attachments still have the sink-wide rate.
+	req.Attachment = nil
+	if _, err := sink.Client.SendSensorData(ctx, req); err != nil {
+		t.Errorf("SendSensorData() without attachment error = %v", err)
+	}
+}
+
+func TestSink_AcceptsFramedReadings(t *testing.T) {
+	// Reserve a free port for the frame listener.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	sink := telemetrytest.NewSink(t, config.Config{FrameAddr: addr, MaxRecvMsgSize: 256})
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("dial frame listener: %v", err)
+	}
+	defer conn.Close()
+
+	send := func(code uint8, payload []byte) (codes.Code, []byte) {
+		t.Helper()
+		if err := frame.Write(conn, code, payload); err != nil {
+			t.Fatalf("frame.Write() error = %v", err)
+		}
+		code, payload, err := frame.Read(conn, 1024)
+		if err != nil {
+			t.Fatalf("frame.Read() error = %v", err)
+		}
+		return codes.Code(code), payload
+	}
+
+	reading, _ := proto.Marshal(&pb.SensorData{SensorName: "mcu-1", SensorValue: 7, Timestamp: timestamppb.New(telemetrytest.StartTime)})
+	code, payload := send(frame.TypeSensorData, reading)
+	var resp pb.SensorDataResponse
+	if err := proto.Unmarshal(payload, &resp); code != codes.OK || err != nil || resp.Message != "Received successfully" {
+		t.Errorf("response = %v %q, want OK with a SensorDataResponse", code, payload)
+	}
+
+	invalid, _ := proto.Marshal(&pb.SensorData{SensorName: "mcu-1"})
+	if code, payload := send(frame.TypeSensorData, invalid); code != codes.InvalidArgument {
+		t.Errorf("response to a reading without timestamp = %v %q, want InvalidArgument", code, payload)
+	}
+	if code, _ := send(9, nil); code != codes.Unimplemented {
+		t.Errorf("response to an unknown frame type = %v, want Unimplemented", code)
+	}
+	if code, _ := send(frame.TypeSensorData, make([]byte, 257)); code != codes.ResourceExhausted {
+		t.Errorf("response to an oversized frame = %v, want ResourceExhausted", code)
+	}
+	if _, _, err := frame.Read(conn, 1024); err != io.EOF {
+		t.Errorf("read after an oversized frame error = %v, want the connection closed", err)
+	}
+
+	entries := sink.Entries()
+	if len(entries) != 1 || entries[0].SensorName != "mcu-1" || entries[0].SensorValue != 7 {
+		t.Errorf("Entries() = %+v, want the framed reading", entries)
+	}
+}
+
+// cborReading encodes {"sensor_name": name, "sensor_value": value, "timestamp": 1(ts)}.
+func cborReading(name string, value uint8, ts time.Time) []byte {
+	text := func(b []byte, s string) []byte { return append(append(b, 0x60|byte(len(s))), s...) }
+
+	b := []byte{0xa3}
+	b = text(b, "sensor_name")
+	b = text(b, name)
+	b = text(b, "sensor_value")
+	b = append(b, 0x18, value)
+	b = text(b, "timestamp")
+	b = append(b, 0xc1, 0x1a)
+	return binary.BigEndian.AppendUint32(b, uint32(ts.Unix()))
+}
+
+func TestSink_AcceptsCoAPReadings(t *testing.T) {
+	// Reserve a free port for the CoAP endpoint.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	sink := telemetrytest.NewSink(t, config.Config{CoAPAddr: addr})
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	post := func(typ coap.Type, id uint16, format uint32, payload []byte) *coap.Message {
+		return &coap.Message{
+			Type:      typ,
+			Code:      coap.POST,
+			MessageID: id,
+			Token:     []byte{byte(id)},
+			Options:   []coap.Option{{Number: coap.OptionURIPath, Value: []byte("readings")}, coap.UintOption(coap.OptionContentFormat, format)},
+			Payload:   payload,
+		}
+	}
+	// exchange sends a request, retransmitting until the endpoint answers.
+	exchange := func(req *coap.Message) *coap.Message {
+		t.Helper()
+		buf := make([]byte, 1500)
+		for i := 0; i < 50; i++ {
+			conn.Write(req.Append(nil))
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, err := conn.Read(buf)
+			if err != nil {
+				// Refused until the endpoint is bound.
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			resp, err := coap.Parse(buf[:n])
+			if err != nil {
+				t.Fatalf("coap.Parse() error = %v", err)
+			}
+			return resp
+		}
+		t.Fatalf("no response to message %d", req.MessageID)
+		return nil
+	}
+
+	con := post(coap.Confirmable, 1, coap.FormatCBOR, cborReading("mote-1", 21, telemetrytest.StartTime))
+	resp := exchange(con)
+	if resp.Type != coap.Acknowledgement || resp.Code != coap.Changed || resp.MessageID != 1 || string(resp.Token) != "\x01" {
+		t.Errorf("response = %+v, want a piggybacked 2.04 ACK", resp)
+	}
+	// A retransmission is acknowledged again without storing the reading twice.
+	if resp := exchange(con); resp.Code != coap.Changed {
+		t.Errorf("response to retransmission = %v, want 2.04", resp.Code)
+	}
+
+	reading, _ := proto.Marshal(&pb.SensorData{SensorName: "mote-2", SensorValue: 5, Timestamp: timestamppb.New(telemetrytest.StartTime)})
+	if resp := exchange(post(coap.Confirmable, 2, 65000, reading)); resp.Code != coap.Changed {
+		t.Errorf("response to protobuf reading = %v %q, want 2.04", resp.Code, resp.Payload)
+	}
+
+	invalid, _ := proto.Marshal(&pb.SensorData{SensorName: "mote-2"})
+	if resp := exchange(post(coap.Confirmable, 3, 65000, invalid)); resp.Code != coap.BadRequest || len(resp.Payload) == 0 {
+		t.Errorf("response to reading without timestamp = %v %q, want 4.00 with a diagnostic", resp.Code, resp.Payload)
+	}
+	if resp := exchange(post(coap.Confirmable, 4, 50, nil)); resp.Code != coap.UnsupportedContentFormat {
+		t.Errorf("response to JSON = %v, want 4.15", resp.Code)
+	}
+
+	if resp := exchange(&coap.Message{Type: coap.Confirmable, MessageID: 5}); resp.Type != coap.Reset || resp.MessageID != 5 {
+		t.Errorf("response to ping = %+v, want a reset", resp)
+	}
+
+	// Non-confirmable readings are stored without a response.
+	conn.Write(post(coap.NonConfirmable, 6, coap.FormatCBOR, cborReading("mote-3", 7, telemetrytest.StartTime)).Append(nil))
+
+	var names []string
+	for i := 0; i < 50 && len(names) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		names = names[:0]
+		for _, e := range sink.Entries() {
+			names = append(names, e.SensorName)
+		}
+	}
+	slices.Sort(names)
+	if want := []string{"mote-1", "mote-2", "mote-3"}; !slices.Equal(names, want) {
+		t.Errorf("stored readings from %v, want %v", names, want)
+	}
+}
+
+func TestSink_RateLimitRefillsWithClock(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{RateLimit: 100})
+	ctx := context.Background()
+	req := &pb.SensorData{SensorName: "vibration", SensorValue: 1, Timestamp: timestamppb.New(telemetrytest.StartTime)}
+
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = sink.Client.SendSensorData(ctx, req)
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("SendSensorData() error = %v, want ResourceExhausted once the budget is used", err)
+	}
+
+	sink.Clock.Advance(time.Second)
+	if _, err := sink.Client.SendSensorData(ctx, req); err != nil {
+		t.Errorf("SendSensorData() after a second error = %v, want the budget refilled", err)
+	}
+}
+
+func TestSink_CanceledRequestIsNotStored(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{})
+	req := &pb.SensorData{SensorName: "humidity", SensorValue: 7, Timestamp: timestamppb.New(telemetrytest.StartTime)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sink.Server.SendSensorData(ctx, req); status.Code(err) != codes.Canceled {
+		t.Fatalf("SendSensorData() with a canceled context error = %v, want Canceled", err)
+	}
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := sink.Server.SendSensorData(ctx, req); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("SendSensorData() past its deadline error = %v, want DeadlineExceeded", err)
+	}
+
+	if entries := sink.Entries(); len(entries) != 0 {
+		t.Errorf("Entries() = %+v, want none", entries)
+	}
+}
+
+func TestSink_ResponsesReportDraining(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{DrainPeriod: time.Second})
+	ctx := context.Background()
+	req := &pb.SensorData{SensorName: "flow", SensorValue: 3, Timestamp: timestamppb.New(telemetrytest.StartTime)}
+
+	resp, err := sink.Client.SendSensorData(ctx, req)
+	if err != nil {
+		t.Fatalf("SendSensorData() error = %v", err)
+	}
+	if resp.Draining {
+		t.Errorf("SendSensorData() before shutdown Draining = true, want false")
+	}
+
+	sink.Server.Stop()
+
+	// Requests keep being served during the drain period, flagged as draining.
+	deadline := time.Now().Add(time.Second)
+	for !resp.Draining && time.Now().Before(deadline) {
+		if resp, err = sink.Client.SendSensorData(ctx, req); err != nil {
+			t.Fatalf("SendSensorData() while draining error = %v", err)
+		}
+	}
+	if !resp.Draining {
+		t.Errorf("SendSensorData() after Stop() Draining = false, want true")
+	}
+
+	hb, err := sink.Client.Heartbeat(ctx, &pb.HeartbeatRequest{SensorName: "flow"})
+	if err != nil {
+		t.Fatalf("Heartbeat() while draining error = %v", err)
+	}
+	if !hb.Draining {
+		t.Errorf("Heartbeat() after Stop() Draining = false, want true")
+	}
+}
+
+func TestSink_ResponsesCarryReceiveTimeAndSequence(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{})
+	ctx := context.Background()
+
+	for i, name := range []string{"flow", "flow", "level", "flow"} {
+		resp, err := sink.Client.SendSensorData(ctx, &pb.SensorData{SensorName: name, SensorValue: 1, Timestamp: timestamppb.New(telemetrytest.StartTime)})
+		if err != nil {
+			t.Fatalf("SendSensorData() error = %v", err)
+		}
+
+		wantSequence := map[int]uint64{0: 1, 1: 2, 2: 1, 3: 3}[i]
+		if resp.Sequence != wantSequence {
+			t.Errorf("reading %d Sequence = %d, want %d", i, resp.Sequence, wantSequence)
+		}
+		if got, want := resp.ReceivedAt.AsTime(), telemetrytest.StartTime.Add(time.Duration(i)*time.Second); !got.Equal(want) {
+			t.Errorf("reading %d ReceivedAt = %v, want %v", i, got, want)
+		}
+		if resp.Throttle != nil {
+			t.Errorf("reading %d Throttle = %v, want none", i, resp.Throttle.AsDuration())
+		}
+		sink.Clock.Advance(time.Second)
+	}
+}
+
+func TestSink_BatchReportsEachReading(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{SensorRateLimit: 60})
+	ctx := context.Background()
+
+	reading := func(name string, second int) *pb.SensorData {
+		return &pb.SensorData{SensorName: name, SensorValue: 1, Timestamp: timestamppb.New(telemetrytest.StartTime.Add(time.Duration(second) * time.Second))}
+	}
+	batch := &pb.SensorDataBatch{Readings: []*pb.SensorData{
+		reading("flow", 1),
+		reading("flow", 1),
+		reading("", 1),
+		reading("level", 1),
+		reading("level", 2),
+		reading("level", 3),
+		reading("level", 4),
+	}}
+	want := []pb.ReadingStatus{
+		pb.ReadingStatus_READING_STATUS_ACCEPTED,
+		pb.ReadingStatus_READING_STATUS_DUPLICATE,
+		pb.ReadingStatus_READING_STATUS_INVALID,
+		pb.ReadingStatus_READING_STATUS_ACCEPTED,
+		pb.ReadingStatus_READING_STATUS_ACCEPTED,
+		pb.ReadingStatus_READING_STATUS_ACCEPTED,
+		pb.ReadingStatus_READING_STATUS_THROTTLED, // over the sensor's 60 bytes per second
+	}
+
+	resp, err := sink.Client.SendSensorDataBatch(ctx, batch)
+	if err != nil {
+		t.Fatalf("SendSensorDataBatch() error = %v", err)
+	}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("SendSensorDataBatch() returned %d results, want %d", len(resp.Results), len(want))
+	}
+	for i, result := range resp.Results {
+		if result.Status != want[i] {
+			t.Errorf("reading %d status = %v (%s), want %v", i, result.Status, result.Message, want[i])
+		}
+	}
+	if resp.Results[4].Sequence != 2 {
+		t.Errorf("reading 4 sequence = %d, want 2", resp.Results[4].Sequence)
+	}
+
+	// Resending the batch stores only the readings that weren't accepted.
+	sink.Clock.Advance(time.Second)
+	resp, err = sink.Client.SendSensorDataBatch(ctx, batch)
+	if err != nil {
+		t.Fatalf("resent SendSensorDataBatch() error = %v", err)
+	}
+	for i, result := range resp.Results {
+		want := pb.ReadingStatus_READING_STATUS_DUPLICATE
+		switch i {
+		case 2:
+			want = pb.ReadingStatus_READING_STATUS_INVALID
+		case 6:
+			want = pb.ReadingStatus_READING_STATUS_ACCEPTED
+		}
+		if result.Status != want {
+			t.Errorf("resent reading %d status = %v (%s), want %v", i, result.Status, result.Message, want)
+		}
+	}
+
+	if entries := sink.Entries(); len(entries) != 5 {
+		t.Errorf("Entries() returned %d entries, want 5", len(entries))
+	}
+
+	tooLarge := &pb.SensorDataBatch{Readings: make([]*pb.SensorData, 1001)}
+	if _, err := sink.Client.SendSensorDataBatch(ctx, tooLarge); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SendSensorDataBatch() of 1001 readings error = %v, want InvalidArgument", err)
+	}
+}
+
+func TestSink_BatchDeduplicatesBySequence(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{})
+	ctx := context.Background()
+
+	reading := func(sequence uint64, second int) *pb.SensorData {
+		return &pb.SensorData{SensorName: "flow", SensorValue: 1, Sequence: sequence, Timestamp: timestamppb.New(telemetrytest.StartTime.Add(time.Duration(second) * time.Second))}
+	}
+	// Numbered readings taken within the same second are told apart by their
+	// sequence, and a resent one is recognized by it even with another timestamp.
+	batch := &pb.SensorDataBatch{Readings: []*pb.SensorData{reading(7, 1), reading(8, 1), reading(7, 2)}}
+	want := []pb.ReadingStatus{
+		pb.ReadingStatus_READING_STATUS_ACCEPTED,
+		pb.ReadingStatus_READING_STATUS_ACCEPTED,
+		pb.ReadingStatus_READING_STATUS_DUPLICATE,
+	}
+
+	resp, err := sink.Client.SendSensorDataBatch(ctx, batch)
+	if err != nil {
+		t.Fatalf("SendSensorDataBatch() error = %v", err)
+	}
+	for i, result := range resp.Results {
+		if result.Status != want[i] {
+			t.Errorf("reading %d status = %v (%s), want %v", i, result.Status, result.Message, want[i])
+		}
+	}
+	if entries := sink.Entries(); len(entries) != 2 {
+		t.Errorf("Entries() returned %d entries, want 2", len(entries))
+	}
+}
+
+func TestSink_BatchSkipsDeduplicationOfFireAndForgetReadings(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{})
+	ctx := context.Background()
+
+	reading := &pb.SensorData{SensorName: "vibration", SensorValue: 1, Sequence: 3, Timestamp: timestamppb.New(telemetrytest.StartTime), Delivery: pb.Delivery_DELIVERY_FIRE_AND_FORGET}
+	resp, err := sink.Client.SendSensorDataBatch(ctx, &pb.SensorDataBatch{Readings: []*pb.SensorData{reading, reading}})
+	if err != nil {
+		t.Fatalf("SendSensorDataBatch() error = %v", err)
+	}
+	for i, result := range resp.Results {
+		if result.Status != pb.ReadingStatus_READING_STATUS_ACCEPTED {
+			t.Errorf("reading %d status = %v (%s), want accepted", i, result.Status, result.Message)
+		}
+	}
+	if entries := sink.Entries(); len(entries) != 2 {
+		t.Errorf("Entries() returned %d entries, want 2", len(entries))
+	}
+}
+
+func TestSink_RecordsStageDurations(t *testing.T) {
+	sink := telemetrytest.NewSink(t, config.Config{
+		EnableEncryption: true,
+		EncryptionKey:    base64.StdEncoding.EncodeToString(make([]byte, 32)),
+	})
+
+	before := stageCounts(t)
+	_, err := sink.Client.SendSensorData(context.Background(), &pb.SensorData{
+		SensorName:  "temperature-01",
+		SensorValue: 21,
+		Timestamp:   timestamppb.New(telemetrytest.StartTime),
+	})
+	if err != nil {
+		t.Fatalf("SendSensorData() error = %v", err)
+	}
+	sink.Flush()
+
+	after := stageCounts(t)
+	for _, stage := range []string{"unmarshal", "rate_limit", "encode", "encrypt", "buffer", "flush_wait", "write"} {
+		if after[stage] <= before[stage] {
+			t.Errorf("stage %q recorded %d durations, want more than %d", stage, after[stage], before[stage])
+		}
+	}
+}
+
+// stageCounts returns the number of durations recorded per stage so far.
+func stageCounts(t *testing.T) map[string]uint64 {
+	t.Helper()
+
+	families, err := metrics.NewRegistry().Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	counts := make(map[string]uint64)
+	for _, family := range families {
+		if family.GetName() != "telemetry_stage_duration_seconds" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "stage" {
+					counts[label.GetValue()] = m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return counts
+}
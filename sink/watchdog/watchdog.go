@@ -0,0 +1,109 @@
+// Package watchdog checks the sink's load against thresholds and writes diagnostics
+// for postmortems when they are crossed.
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+)
+
+// Checks, used in violations and metrics.
+const (
+	CheckBuffer       = "buffer"
+	CheckQueue        = "queue"
+	CheckFlushLatency = "flush_latency"
+	CheckGoroutines   = "goroutines"
+)
+
+// Stats is a snapshot of the sink's load.
+type Stats struct {
+	BufferLen    int           // bytes in the in-memory buffer
+	BufferSize   int           // bytes at which the buffer is normally flushed
+	Queued       int           // buffers waiting for the writer
+	FlushLatency time.Duration // how long the buffer being written has been waiting
+	Goroutines   int
+}
+
+// Thresholds bound the load; zero values are not checked. The buffer is always
+// checked, as it only outgrows BufferSize while critical entries are held because
+// the writer is behind.
+type Thresholds struct {
+	MaxQueued       int
+	MaxFlushLatency time.Duration
+	MaxGoroutines   int
+}
+
+// Violation is a crossed threshold.
+type Violation struct {
+	Check  string
+	Detail string
+}
+
+func (v Violation) String() string {
+	return v.Detail
+}
+
+// Check returns the thresholds stats crosses.
+func (t Thresholds) Check(stats Stats) []Violation {
+	var violations []Violation
+	if stats.BufferSize > 0 && stats.BufferLen > stats.BufferSize {
+		violations = append(violations, Violation{CheckBuffer,
+			fmt.Sprintf("buffer holds %d bytes, over its size of %d", stats.BufferLen, stats.BufferSize)})
+	}
+	if t.MaxQueued > 0 && stats.Queued >= t.MaxQueued {
+		violations = append(violations, Violation{CheckQueue,
+			fmt.Sprintf("%d buffers queued for the writer, limit %d", stats.Queued, t.MaxQueued)})
+	}
+	if t.MaxFlushLatency > 0 && stats.FlushLatency > t.MaxFlushLatency {
+		violations = append(violations, Violation{CheckFlushLatency,
+			fmt.Sprintf("flush latency %v, limit %v", stats.FlushLatency.Round(time.Millisecond), t.MaxFlushLatency)})
+	}
+	if t.MaxGoroutines > 0 && stats.Goroutines > t.MaxGoroutines {
+		violations = append(violations, Violation{CheckGoroutines,
+			fmt.Sprintf("%d goroutines, limit %d", stats.Goroutines, t.MaxGoroutines)})
+	}
+	return violations
+}
+
+// Dump writes every goroutine's stack and a heap profile to dir, named after now,
+// and returns the paths written.
+func Dump(dir string, now time.Time) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create dump directory: %w", err)
+	}
+
+	prefix := filepath.Join(dir, "watchdog-"+now.UTC().Format("20060102T150405.000Z"))
+	profiles := []struct {
+		name   string
+		suffix string
+		debug  int
+	}{
+		{"goroutine", "-goroutines.txt", 2}, // full stacks, like an unrecovered panic
+		{"heap", "-heap.pprof", 0},          // for go tool pprof
+	}
+
+	var paths []string
+	for _, p := range profiles {
+		path := prefix + p.suffix
+		if err := writeProfile(path, p.name, p.debug); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func writeProfile(path, name string, debug int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	if err := pprof.Lookup(name).WriteTo(f, debug); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s profile: %w", name, err)
+	}
+	return f.Close()
+}
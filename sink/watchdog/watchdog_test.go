@@ -0,0 +1,71 @@
+package watchdog
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThresholds_Check(t *testing.T) {
+	thresholds := Thresholds{MaxQueued: 4, MaxFlushLatency: 10 * time.Second, MaxGoroutines: 1000}
+	healthy := Stats{BufferLen: 100, BufferSize: 1024, Queued: 1, FlushLatency: time.Second, Goroutines: 50}
+
+	tests := []struct {
+		name   string
+		modify func(*Stats)
+		want   []string
+	}{
+		{"healthy", func(*Stats) {}, nil},
+		{"held critical entries", func(s *Stats) { s.BufferLen = 2000 }, []string{CheckBuffer}},
+		{"queue full", func(s *Stats) { s.Queued = 4 }, []string{CheckQueue}},
+		{"slow disk", func(s *Stats) { s.FlushLatency = 11 * time.Second }, []string{CheckFlushLatency}},
+		{"goroutine leak", func(s *Stats) { s.Goroutines = 5000 }, []string{CheckGoroutines}},
+		{"saturated", func(s *Stats) { s.Queued = 4; s.FlushLatency = time.Minute }, []string{CheckQueue, CheckFlushLatency}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := healthy
+			tt.modify(&stats)
+
+			var got []string
+			for _, v := range thresholds.Check(stats) {
+				got = append(got, v.Check)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThresholds_CheckZeroDisables(t *testing.T) {
+	stats := Stats{BufferLen: 10, BufferSize: 1024, Queued: 100, FlushLatency: time.Hour, Goroutines: 1 << 20}
+	if violations := (Thresholds{}).Check(stats); len(violations) != 0 {
+		t.Errorf("Check() with zero thresholds = %v, want none", violations)
+	}
+}
+
+func TestDump(t *testing.T) {
+	dir := t.TempDir() + "/dumps"
+
+	paths, err := Dump(dir, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Dump() error = %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("Dump() wrote %v, want goroutines and heap", paths)
+	}
+
+	stacks, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatalf("read goroutine dump: %v", err)
+	}
+	if !strings.Contains(string(stacks), "TestDump") {
+		t.Errorf("goroutine dump does not contain the calling test's stack")
+	}
+	if info, err := os.Stat(paths[1]); err != nil || info.Size() == 0 {
+		t.Errorf("heap profile %s missing or empty: %v", paths[1], err)
+	}
+}